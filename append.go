@@ -0,0 +1,48 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Append adds the contents of r to the end of path, creating the file if it
+// does not exist, and returns the number of bytes appended. Engines
+// implementing [Appender] are used natively.
+//
+// For other engines, Append is a read-modify-write: the existing content
+// followed by r is written with [PutAtomic], which replaces the file only
+// if everything was read successfully. The cost therefore grows with the
+// size of the file, and engines without [AtomicWriter] buffer the whole
+// result in memory. Concurrent appends to the same path may lose data.
+func Append(ctx context.Context, engine StorageEngine, path string, r io.Reader) (int64, error) {
+	if a, ok := engine.(Appender); ok {
+		return a.Append(ctx, path, r)
+	}
+
+	src := &countingReader{r: r}
+	old, err := engine.Open(ctx, path)
+	switch {
+	case err == nil:
+		defer func() { _ = old.Close() }()
+		err = PutAtomic(ctx, engine, path, io.MultiReader(old, src))
+	case errors.Is(err, ErrNotFound):
+		err = PutAtomic(ctx, engine, path, src)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return src.n, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,56 @@
+package sbox_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	engines := map[string]sbox.StorageEngine{
+		"native":   memory.New(),
+		"fallback": struct{ sbox.StorageEngine }{memory.New()},
+	}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			for _, part := range []string{"hello", ", ", "world"} {
+				n, err := sbox.Append(ctx, engine, "dir/log.txt", strings.NewReader(part))
+				if err != nil || n != int64(len(part)) {
+					t.Fatalf("Append(%q) = %d, %v", part, n, err)
+				}
+			}
+			r, err := engine.Open(ctx, "dir/log.txt")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			got, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(got) != "hello, world" {
+				t.Errorf("content = %q", got)
+			}
+		})
+	}
+}
+
+func TestAppend_FallbackKeepsFileOnError(t *testing.T) {
+	ctx := context.Background()
+	engine := struct{ sbox.StorageEngine }{memory.New()}
+	if _, err := sbox.Append(ctx, engine, "f.txt", strings.NewReader("keep")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	if _, err := sbox.Append(ctx, engine, "f.txt", &failingReader{}); err == nil {
+		t.Fatal("Append with failing reader succeeded")
+	}
+	r, _ := engine.Open(ctx, "f.txt")
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "keep" {
+		t.Errorf("content after failed append = %q", got)
+	}
+}
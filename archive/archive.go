@@ -0,0 +1,816 @@
+// Package archive provides a storage driver that exposes the contents of a
+// tar, tar.gz or zip file, for tools that inspect or build artifacts
+// without unpacking them. Tar archives are read-only; zip archives can also
+// be written, and are rewritten when the engine is closed.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// Auto-register the archive storage driver.
+func init() {
+	sbox.Register("archive", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
+		if cfg.BasePath == "" {
+			return nil, fmt.Errorf("sbox/archive: BasePath is required")
+		}
+		var opts []Option
+		if format, _ := cfg.Options["format"].(string); format != "" {
+			opts = append(opts, WithFormat(Format(format)))
+		}
+		return New(cfg.BasePath, opts...)
+	})
+}
+
+// Format is an archive file format.
+type Format string
+
+// Supported formats.
+const (
+	FormatTar   Format = "tar"
+	FormatTarGz Format = "tar.gz"
+	FormatZip   Format = "zip"
+)
+
+// DetectFormat returns the format implied by the extension of name:
+// ".tar", ".tar.gz" or ".tgz", or ".zip".
+func DetectFormat(name string) (Format, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar"):
+		return FormatTar, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return FormatZip, nil
+	}
+	return "", fmt.Errorf("sbox/archive: cannot detect the format of %q", name)
+}
+
+// Engine implements sbox.StorageEngine over the entries of an archive.
+// The entries are indexed when the engine is created. Files written to a
+// zip archive are kept in memory until Flush or Close rewrites the
+// archive, which invalidates open readers; writing to a tar archive fails
+// with sbox.ErrPermission.
+type Engine struct {
+	mu     sync.RWMutex
+	path   string
+	format Format
+	file   *os.File         // nil for a zip archive that does not exist yet
+	nodes  map[string]*node // Keyed by cleaned path; the root is ""
+	dirty  bool
+	closed bool
+}
+
+// node is a file or directory in the archive.
+type node struct {
+	dir     bool
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+
+	offset  int64     // Data offset in an uncompressed tar; -1 if unknown
+	entry   int       // Position of the header in a tar archive
+	zf      *zip.File // Entry in the zip file, if unchanged since it was read
+	data    []byte    // Content written since the archive was read
+	written bool      // data holds the content
+}
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithFormat sets the archive format instead of detecting it from the file
+// extension.
+func WithFormat(format Format) Option {
+	return func(e *Engine) {
+		e.format = format
+	}
+}
+
+// New opens the archive at path and indexes its entries. A zip archive
+// that does not exist is created on Close.
+func New(path string, opts ...Option) (*Engine, error) {
+	e := &Engine{path: path}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.format == "" {
+		format, err := DetectFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		e.format = format
+	}
+	switch e.format {
+	case FormatTar, FormatTarGz, FormatZip:
+	default:
+		return nil, fmt.Errorf("sbox/archive: unsupported format %q", e.format)
+	}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// load opens the archive file and builds the index.
+func (e *Engine) load() error {
+	e.nodes = map[string]*node{"": {dir: true, mode: os.ModeDir | 0755}}
+	f, err := os.Open(e.path)
+	if errors.Is(err, os.ErrNotExist) && e.format == FormatZip {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		if e.format == FormatZip {
+			err = e.indexZip(f, info.Size())
+		} else {
+			err = e.indexTar(f, info.Size())
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sbox/archive: %s: %w", e.path, err)
+	}
+	e.file = f
+	return nil
+}
+
+func (e *Engine) indexZip(f *os.File, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		p := clean(zf.Name)
+		if p == "" {
+			continue
+		}
+		n := &node{modTime: zf.Modified, mode: zf.Mode(), offset: -1}
+		if strings.HasSuffix(zf.Name, "/") || zf.Mode().IsDir() {
+			n.dir, n.mode = true, os.ModeDir|zf.Mode().Perm()
+		} else {
+			n.zf, n.size = zf, int64(zf.UncompressedSize64)
+		}
+		e.add(p, n)
+	}
+	return nil
+}
+
+func (e *Engine) indexTar(f *os.File, size int64) error {
+	var r io.Reader = io.NewSectionReader(f, 0, size)
+	counter := &countingReader{r: r}
+	r = counter
+	if e.format == FormatTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for entry := 0; ; entry++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := clean(hdr.Name)
+		if p == "" {
+			continue
+		}
+		n := &node{modTime: hdr.ModTime, mode: hdr.FileInfo().Mode(), offset: -1, entry: entry}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			n.dir = true
+		case tar.TypeReg:
+			n.size = hdr.Size
+			// tar.Reader stops right after the header, so the data of an
+			// uncompressed archive starts at the current position.
+			if e.format == FormatTar {
+				n.offset = counter.n
+			}
+		default:
+			// Links, devices and other special entries are not exposed.
+			continue
+		}
+		e.add(p, n)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// add indexes n at p with its missing parents. Later entries replace
+// earlier ones, as when extracting.
+func (e *Engine) add(p string, n *node) {
+	for dir := parent(p); dir != ""; dir = parent(dir) {
+		if existing, ok := e.nodes[dir]; ok && existing.dir {
+			break
+		}
+		e.nodes[dir] = &node{dir: true, modTime: n.modTime, mode: os.ModeDir | 0755, offset: -1}
+	}
+	e.nodes[p] = n
+}
+
+// clean normalizes an archive or engine path; the root is "".
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(p, `\`, "/")), "/")
+}
+
+func parent(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// writable returns an error unless entries can be changed. Callers hold
+// e.mu.
+func (e *Engine) writable() error {
+	if e.closed {
+		return sbox.ErrClosed
+	}
+	if e.format != FormatZip {
+		return fmt.Errorf("sbox/archive: %s archives are read-only: %w", e.format, sbox.ErrPermission)
+	}
+	return nil
+}
+
+// lookup returns the node at p. Callers hold e.mu.
+func (e *Engine) lookup(p string) (*node, error) {
+	if e.closed {
+		return nil, sbox.ErrClosed
+	}
+	n, ok := e.nodes[p]
+	if !ok {
+		return nil, sbox.ErrNotFound
+	}
+	return n, nil
+}
+
+func (n *node) info(p string) *sbox.EntryInfo {
+	info := &sbox.EntryInfo{Name: path.Base(p), Path: p, Size: n.size, ModTime: n.modTime, Mode: n.mode, IsDir: n.dir}
+	if p == "" {
+		info.Name = "/"
+	}
+	return info
+}
+
+// wrapErr makes *err an *sbox.PathError of the archive driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("archive", op, path, *err)
+}
+
+func (e *Engine) Stat(ctx context.Context, p string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", p, &err)
+	p = clean(p)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	n, err := e.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(p), nil
+}
+
+// Open returns a reader for p. Uncompressed tar entries and stored zip
+// entries are read in place; other entries are decompressed into memory so
+// that they can be seeked.
+func (e *Engine) Open(ctx context.Context, p string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	p = clean(p)
+	e.mu.RLock()
+	n, err := e.lookup(p)
+	e.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if n.dir {
+		return nil, sbox.ErrIsDir
+	}
+	switch {
+	case n.written:
+		return nopCloser{io.NewSectionReader(bytes.NewReader(n.data), 0, n.size)}, nil
+	case n.offset >= 0:
+		return nopCloser{io.NewSectionReader(e.file, n.offset, n.size)}, nil
+	case n.zf != nil && n.zf.Method == zip.Store:
+		offset, err := n.zf.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return nopCloser{io.NewSectionReader(e.file, offset, n.size)}, nil
+	}
+	rc, err := e.stream(p, n)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))}, nil
+}
+
+// nopCloser turns a SectionReader into a ReadSeekCloser.
+type nopCloser struct {
+	*io.SectionReader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// stream returns a sequential reader for the file node n at p.
+func (e *Engine) stream(p string, n *node) (io.ReadCloser, error) {
+	switch {
+	case n.written:
+		return io.NopCloser(bytes.NewReader(n.data)), nil
+	case n.offset >= 0:
+		return io.NopCloser(io.NewSectionReader(e.file, n.offset, n.size)), nil
+	case n.zf != nil:
+		return n.zf.Open()
+	}
+	return e.streamTarGz(n.entry)
+}
+
+// streamTarGz decompresses the archive up to the given entry, since a gzip
+// stream cannot be entered in the middle.
+func (e *Engine) streamTarGz(entry int) (io.ReadCloser, error) {
+	info, err := e.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(io.NewSectionReader(e.file, 0, info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for i := 0; i <= entry; i++ {
+		if _, err := tr.Next(); err != nil {
+			_ = gz.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return &gzipEntry{Reader: tr, gz: gz}, nil
+}
+
+// gzipEntry reads one entry of a tar.gz archive.
+type gzipEntry struct {
+	io.Reader
+	gz *gzip.Reader
+}
+
+func (g *gzipEntry) Close() error {
+	return g.gz.Close()
+}
+
+func (e *Engine) Create(ctx context.Context, p string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", p, &err)
+	return e.OpenFile(ctx, p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// OpenFile opens p for writing in a zip archive. The file is buffered in
+// memory, including its existing content unless os.O_TRUNC is given.
+func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	p = clean(p)
+	e.mu.RLock()
+	err = e.writable()
+	var n *node
+	if err == nil {
+		n, err = e.lookup(p)
+	}
+	e.mu.RUnlock()
+	switch {
+	case p == "" || err == nil && n.dir:
+		return nil, sbox.ErrIsDir
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, sbox.ErrExist
+	case err != nil && !errors.Is(err, sbox.ErrNotFound):
+		return nil, err
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	w := &writer{engine: e, path: p, mode: perm.Perm(), append: flag&os.O_APPEND != 0}
+	if err == nil && flag&os.O_TRUNC == 0 {
+		rc, err := e.stream(p, n)
+		if err != nil {
+			return nil, err
+		}
+		w.buf, err = io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		w.mode = n.mode.Perm()
+	}
+	return w, nil
+}
+
+// writer implements WriteSeekCloser over an in-memory copy of a file.
+type writer struct {
+	engine *Engine
+	path   string
+	mode   os.FileMode
+	buf    []byte
+	offset int64
+	append bool
+	closed bool
+}
+
+// Write writes p at the current offset, growing the file as needed. In
+// append mode every write goes to the end.
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	if w.append {
+		w.offset = int64(len(w.buf))
+	}
+	end := w.offset + int64(len(p))
+	if end > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, end-int64(len(w.buf)))...)
+	}
+	copy(w.buf[w.offset:end], p)
+	w.offset = end
+	return len(p), nil
+}
+
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = w.offset + offset
+	case io.SeekEnd:
+		newOffset = int64(len(w.buf)) + offset
+	default:
+		return 0, errors.New("sbox/archive: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("sbox/archive: negative seek offset")
+	}
+	w.offset = newOffset
+	return w.offset, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	return w.engine.put(w.path, &node{
+		size:    int64(len(w.buf)),
+		modTime: time.Now(),
+		mode:    w.mode,
+		offset:  -1,
+		data:    w.buf,
+		written: true,
+	})
+}
+
+// put stores the file n at p, creating its parents.
+func (e *Engine) put(p string, n *node) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.writable(); err != nil {
+		return err
+	}
+	if existing, ok := e.nodes[p]; ok && existing.dir {
+		return sbox.ErrIsDir
+	}
+	if err := e.checkParents(p); err != nil {
+		return err
+	}
+	e.add(p, n)
+	e.dirty = true
+	return nil
+}
+
+// checkParents returns sbox.ErrNotDir if a file occupies a parent of p.
+// Callers hold e.mu.
+func (e *Engine) checkParents(p string) error {
+	for dir := parent(p); dir != ""; dir = parent(dir) {
+		if n, ok := e.nodes[dir]; ok {
+			if !n.dir {
+				return sbox.ErrNotDir
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// subtree returns p and the paths below it. Callers hold e.mu.
+func (e *Engine) subtree(p string) []string {
+	paths := []string{p}
+	prefix := p + "/"
+	for sub := range e.nodes {
+		if strings.HasPrefix(sub, prefix) {
+			paths = append(paths, sub)
+		}
+	}
+	return paths
+}
+
+// Remove deletes p and everything below it. Removing a missing path
+// fails with sbox.ErrNotFound.
+func (e *Engine) Remove(ctx context.Context, p string) (err error) {
+	defer wrapErr("remove", p, &err)
+	p = clean(p)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.writable(); err != nil {
+		return err
+	}
+	if _, ok := e.nodes[p]; !ok {
+		return sbox.ErrNotFound
+	}
+	if p == "" {
+		e.nodes = map[string]*node{"": e.nodes[""]}
+	} else {
+		for _, sub := range e.subtree(p) {
+			delete(e.nodes, sub)
+		}
+	}
+	e.dirty = true
+	return nil
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	oldPath, newPath = clean(oldPath), clean(newPath)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.writable(); err != nil {
+		return err
+	}
+	n, ok := e.nodes[oldPath]
+	if !ok {
+		return sbox.ErrNotFound
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	if oldPath == "" || newPath == "" || strings.HasPrefix(newPath, oldPath+"/") {
+		return sbox.ErrInvalid
+	}
+	if dst, ok := e.nodes[newPath]; ok {
+		switch {
+		case dst.dir && len(e.subtree(newPath)) > 1:
+			return sbox.ErrExist
+		case dst.dir && !n.dir:
+			return sbox.ErrIsDir
+		case !dst.dir && n.dir:
+			return sbox.ErrNotDir
+		}
+	}
+	if err := e.checkParents(newPath); err != nil {
+		return err
+	}
+	moved := make(map[string]*node)
+	for _, sub := range e.subtree(oldPath) {
+		moved[newPath+strings.TrimPrefix(sub, oldPath)] = e.nodes[sub]
+		delete(e.nodes, sub)
+	}
+	for sub, n := range moved {
+		e.add(sub, n)
+	}
+	e.dirty = true
+	return nil
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, p string) (err error) {
+	defer wrapErr("mkdir", p, &err)
+	p = clean(p)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n, ok := e.nodes[p]; ok && !e.closed {
+		if !n.dir {
+			return sbox.ErrNotDir
+		}
+		return nil
+	}
+	if err := e.writable(); err != nil {
+		return err
+	}
+	if err := e.checkParents(p); err != nil {
+		return err
+	}
+	e.add(p, &node{dir: true, modTime: time.Now(), mode: os.ModeDir | 0755, offset: -1})
+	e.dirty = true
+	return nil
+}
+
+func (e *Engine) ReadDir(ctx context.Context, p string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", p, &err)
+	p = clean(p)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	n, err := e.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dir {
+		return nil, sbox.ErrNotDir
+	}
+	var entries []*sbox.EntryInfo
+	for sub, child := range e.nodes {
+		if sub != "" && parent(sub) == p {
+			entries = append(entries, child.info(sub))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Flush rewrites a changed zip archive: it is written to a temporary file
+// next to it, which then replaces it. Unchanged entries are copied without
+// recompressing them.
+func (e *Engine) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return sbox.ErrClosed
+	}
+	return e.flush()
+}
+
+func (e *Engine) flush() error {
+	if !e.dirty {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), "."+filepath.Base(e.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	err = e.writeZip(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), e.path)
+	}
+	if err != nil {
+		return fmt.Errorf("sbox/archive: rewrite %s: %w", e.path, err)
+	}
+
+	if e.file != nil {
+		_ = e.file.Close()
+		e.file = nil
+	}
+	e.dirty = false
+	return e.load()
+}
+
+// writeZip writes all entries as a zip archive to w, in path order.
+func (e *Engine) writeZip(w io.Writer) error {
+	paths := make([]string, 0, len(e.nodes))
+	for p := range e.nodes {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	zw := zip.NewWriter(w)
+	for _, p := range paths {
+		n := e.nodes[p]
+		var err error
+		switch {
+		case n.dir:
+			hdr := &zip.FileHeader{Name: p + "/", Modified: n.modTime}
+			hdr.SetMode(n.mode)
+			_, err = zw.CreateHeader(hdr)
+		case n.zf != nil:
+			err = copyRaw(zw, n.zf, p)
+		default:
+			hdr := &zip.FileHeader{Name: p, Method: zip.Deflate, Modified: n.modTime}
+			hdr.SetMode(n.mode)
+			var fw io.Writer
+			if fw, err = zw.CreateHeader(hdr); err == nil {
+				_, err = fw.Write(n.data)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// copyRaw copies the compressed entry zf to zw under the name p.
+func copyRaw(zw *zip.Writer, zf *zip.File, p string) error {
+	hdr := zf.FileHeader
+	hdr.Name = p
+	fw, err := zw.CreateRaw(&hdr)
+	if err != nil {
+		return err
+	}
+	r, err := zf.OpenRaw()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+// Close rewrites a changed zip archive and releases the archive file.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	err := e.flush()
+	e.closed = true
+	if e.file != nil {
+		if closeErr := e.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// === Extension: StreamReader ===
+
+// Get streams p without buffering it, also for compressed entries.
+func (e *Engine) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	p = clean(p)
+	e.mu.RLock()
+	n, err := e.lookup(p)
+	e.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if n.dir {
+		return nil, sbox.ErrIsDir
+	}
+	return e.stream(p, n)
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks that the engine is open and the archive file is still
+// readable.
+func (e *Engine) Ping(ctx context.Context) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return sbox.ErrClosed
+	}
+	if e.file == nil {
+		return nil
+	}
+	_, err := e.file.Stat()
+	return err
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine = (*Engine)(nil)
+	_ sbox.StreamReader  = (*Engine)(nil)
+	_ io.Closer          = (*Engine)(nil)
+	_ sbox.HealthChecker = (*Engine)(nil)
+)
@@ -0,0 +1,227 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/archive"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+var tarFiles = []struct{ name, content string }{
+	{"README", "top level"},
+	{"src/main.go", "package main"},
+	{"src/util/strings.go", "package util"},
+	{"README", "replaced by a later entry"},
+}
+
+// writeTar writes tarFiles to path, gzip-compressed if compress is set.
+func writeTar(t *testing.T, path string, compress bool) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	_ = tw.WriteHeader(&tar.Header{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, file := range tarFiles {
+		_ = tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file.content))})
+		_, _ = io.WriteString(tw, file.content)
+	}
+	_ = tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "README"})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		_ = gz.Close()
+	}
+	_ = f.Close()
+}
+
+func TestArchive_ZipSuite(t *testing.T) {
+	engine, err := archive.New(filepath.Join(t.TempDir(), "suite.zip"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() { _ = engine.Close() }()
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestArchive_Tar(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "files.tar"
+		if compress {
+			name = "files.tar.gz"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), name)
+			writeTar(t, path, compress)
+			engine, err := archive.New(path)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer func() { _ = engine.Close() }()
+
+			entries, err := engine.ReadDir(ctx, "")
+			if err != nil || len(entries) != 2 || entries[0].Name != "README" || !entries[1].IsDir {
+				t.Fatalf("ReadDir = %+v, %v", entries, err)
+			}
+			if got := readFile(t, engine, "README"); got != "replaced by a later entry" {
+				t.Errorf("README = %q", got)
+			}
+			if info, err := engine.Stat(ctx, "src/util"); err != nil || !info.IsDir {
+				t.Errorf("Stat(src/util) = %+v, %v", info, err)
+			}
+
+			r, err := engine.Open(ctx, "src/util/strings.go")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			_, _ = r.Seek(8, io.SeekStart)
+			rest, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(rest) != "util" {
+				t.Errorf("after Seek = %q", rest)
+			}
+
+			rc, err := engine.Get(ctx, "src/main.go")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			if string(data) != "package main" {
+				t.Errorf("Get = %q", data)
+			}
+
+			if _, err := engine.Create(ctx, "new.txt"); !errors.Is(err, sbox.ErrPermission) {
+				t.Errorf("Create = %v, want %v", err, sbox.ErrPermission)
+			}
+			if err := engine.Remove(ctx, "README"); !errors.Is(err, sbox.ErrPermission) {
+				t.Errorf("Remove = %v, want %v", err, sbox.ErrPermission)
+			}
+			if _, err := engine.Stat(ctx, "link"); !errors.Is(err, sbox.ErrNotFound) {
+				t.Errorf("Stat(link) = %v, want %v", err, sbox.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestArchive_ZipRewrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "out.zip")
+	engine, err := archive.New(path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, name := range []string{"a/one.txt", "a/two.txt", "b.txt"} {
+		w, _ := engine.Create(ctx, name)
+		_, _ = io.WriteString(w, strings.Repeat(name, 10))
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("archive written before Close: %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Changes to an existing archive keep the untouched entries.
+	engine, err = archive.New(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if err := engine.Rename(ctx, "a/one.txt", "c/one.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := engine.Remove(ctx, "b.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	w, err := engine.OpenFile(ctx, "a/two.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = io.WriteString(w, "!")
+	_ = w.Close()
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := readFile(t, engine, "c/one.txt"); got != strings.Repeat("a/one.txt", 10) {
+		t.Errorf("c/one.txt after Flush = %q", got)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("zip.OpenReader: %v", err)
+	}
+	defer func() { _ = zr.Close() }()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "a/,a/two.txt,c/,c/one.txt" {
+		t.Errorf("entries = %s", got)
+	}
+	rc, _ := zr.File[1].Open()
+	data, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != strings.Repeat("a/two.txt", 10)+"!" {
+		t.Errorf("a/two.txt = %q", data)
+	}
+}
+
+func TestArchive_Open(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "files.bin")
+	writeTar(t, path, true)
+
+	if _, err := sbox.Open(&sbox.Config{Type: "archive", BasePath: path}); err == nil {
+		t.Error("Open without a known extension succeeded")
+	}
+	engine, err := sbox.Open(&sbox.Config{Type: "archive", BasePath: path, Options: map[string]any{"format": "tar.gz"}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = sbox.Close(engine) }()
+	if got := readFile(t, engine, "src/main.go"); got != "package main" {
+		t.Errorf("src/main.go = %q", got)
+	}
+	if _, err := archive.New(filepath.Join(dir, "missing.tar")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("New(missing tar) = %v", err)
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,39 @@
+package sbox
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// PutAtomic writes the contents of r to path so that readers observe either
+// the previous file or the complete new one. Engines implementing
+// [AtomicWriter] are used natively; for others the data is buffered in
+// memory first so that a failing reader never leaves a truncated file.
+func PutAtomic(ctx context.Context, engine StorageEngine, path string, r io.Reader) error {
+	if aw, ok := engine.(AtomicWriter); ok {
+		w, err := aw.CreateAtomic(ctx, path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			_ = w.Abort()
+			return err
+		}
+		return w.Close()
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return err
+	}
+	w, err := engine.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
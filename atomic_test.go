@@ -0,0 +1,51 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+// failingReader returns some data and then an error.
+type failingReader struct{ sent bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.sent {
+		return 0, errors.New("boom")
+	}
+	r.sent = true
+	return copy(p, "partial"), nil
+}
+
+func TestPutAtomic(t *testing.T) {
+	ctx := context.Background()
+	engines := map[string]sbox.StorageEngine{
+		"native":   memory.New(),
+		"fallback": struct{ sbox.StorageEngine }{memory.New()},
+	}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			if err := sbox.PutAtomic(ctx, engine, "f.txt", strings.NewReader("first")); err != nil {
+				t.Fatalf("PutAtomic: %v", err)
+			}
+			if err := sbox.PutAtomic(ctx, engine, "f.txt", &failingReader{}); err == nil {
+				t.Fatal("PutAtomic with failing reader: expected error")
+			}
+
+			r, err := engine.Open(ctx, "f.txt")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			data, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(data) != "first" {
+				t.Errorf("content = %q, want %q", data, "first")
+			}
+		})
+	}
+}
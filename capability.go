@@ -0,0 +1,192 @@
+package sbox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capability names an optional feature of an engine, usually one of the
+// extension interfaces. Pass capabilities to [Require] to fail at [Open]
+// when the configured driver lacks them, instead of on first use with
+// ErrNotSupported.
+type Capability string
+
+// Capabilities of the extension interfaces.
+const (
+	CapStreamRead       Capability = "StreamRead"       // StreamReader
+	CapStreamWrite      Capability = "StreamWrite"      // StreamWriter
+	CapRangeRead        Capability = "RangeRead"        // RangeReader
+	CapRangeWrite       Capability = "RangeWrite"       // RangeWriter
+	CapHash             Capability = "Hash"             // Hasher
+	CapCopy             Capability = "Copy"             // Copier
+	CapAppend           Capability = "Append"           // Appender
+	CapSetModTime       Capability = "SetModTime"       // ModTimeSetter
+	CapMetadata         Capability = "Metadata"         // MetadataWriter
+	CapSymlink          Capability = "Symlink"          // Symlinker
+	CapPermissions      Capability = "Permissions"      // PermissionManager
+	CapConditionalWrite Capability = "ConditionalWrite" // ConditionalWriter
+	CapGlob             Capability = "Glob"             // Glober
+	CapSignedURL        Capability = "SignedURL"        // SignedURLGenerator
+	CapAtomicWrite      Capability = "AtomicWrite"      // AtomicWriter
+	CapTier             Capability = "Tier"             // TierManager
+	CapVersions         Capability = "Versions"         // Versioner
+	CapMultipart        Capability = "Multipart"        // MultipartUploader
+	CapLock             Capability = "Lock"             // Locker
+	CapList             Capability = "List"             // Lister
+	CapHealth           Capability = "Health"           // HealthChecker
+	CapUsage            Capability = "Usage"            // UsageReporter
+	CapWatch            Capability = "Watch"            // Watcher
+	CapWalk             Capability = "Walk"             // Walker
+)
+
+// AllCapabilities lists every Capability, in the order of the constants.
+var AllCapabilities = []Capability{
+	CapStreamRead, CapStreamWrite, CapRangeRead, CapRangeWrite, CapHash,
+	CapCopy, CapAppend, CapSetModTime, CapMetadata, CapSymlink,
+	CapPermissions, CapConditionalWrite, CapGlob, CapSignedURL, CapAtomicWrite,
+	CapTier, CapVersions, CapMultipart, CapLock, CapList,
+	CapHealth, CapUsage, CapWatch, CapWalk,
+}
+
+// CapabilityReporter is implemented by engines whose support for an
+// extension depends on their configuration or on the engines they wrap,
+// so implementing the interface does not mean the calls succeed. Supports
+// reports whether the calls can succeed; it is used by [Supports] instead
+// of a type assertion.
+type CapabilityReporter interface {
+	Supports(c Capability) bool
+}
+
+// Supports reports whether engine supports c: through [CapabilityReporter]
+// if engine implements it, and otherwise by whether engine implements the
+// extension interface.
+func Supports(engine StorageEngine, c Capability) bool {
+	if r, ok := engine.(CapabilityReporter); ok {
+		return r.Supports(c)
+	}
+	return Implements(engine, c)
+}
+
+// Implements reports whether engine implements the extension interface of
+// c, regardless of [CapabilityReporter]. Middleware use it to report the
+// extensions they provide themselves.
+func Implements(engine StorageEngine, c Capability) bool {
+	var ok bool
+	switch c {
+	case CapStreamRead:
+		_, ok = engine.(StreamReader)
+	case CapStreamWrite:
+		_, ok = engine.(StreamWriter)
+	case CapRangeRead:
+		_, ok = engine.(RangeReader)
+	case CapRangeWrite:
+		_, ok = engine.(RangeWriter)
+	case CapHash:
+		_, ok = engine.(Hasher)
+	case CapCopy:
+		_, ok = engine.(Copier)
+	case CapAppend:
+		_, ok = engine.(Appender)
+	case CapSetModTime:
+		_, ok = engine.(ModTimeSetter)
+	case CapMetadata:
+		_, ok = engine.(MetadataWriter)
+	case CapSymlink:
+		_, ok = engine.(Symlinker)
+	case CapPermissions:
+		_, ok = engine.(PermissionManager)
+	case CapConditionalWrite:
+		_, ok = engine.(ConditionalWriter)
+	case CapGlob:
+		_, ok = engine.(Glober)
+	case CapSignedURL:
+		_, ok = engine.(SignedURLGenerator)
+	case CapAtomicWrite:
+		_, ok = engine.(AtomicWriter)
+	case CapTier:
+		_, ok = engine.(TierManager)
+	case CapVersions:
+		_, ok = engine.(Versioner)
+	case CapMultipart:
+		_, ok = engine.(MultipartUploader)
+	case CapLock:
+		_, ok = engine.(Locker)
+	case CapList:
+		_, ok = engine.(Lister)
+	case CapHealth:
+		_, ok = engine.(HealthChecker)
+	case CapUsage:
+		_, ok = engine.(UsageReporter)
+	case CapWatch:
+		_, ok = engine.(Watcher)
+	case CapWalk:
+		_, ok = engine.(Walker)
+	}
+	return ok
+}
+
+// Capabilities returns the capabilities engine supports.
+func Capabilities(engine StorageEngine) []Capability {
+	var caps []Capability
+	for _, c := range AllCapabilities {
+		if Supports(engine, c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+// CapabilityError is returned by [Open] when the engine lacks capabilities
+// given to [Require]. It wraps ErrNotSupported.
+type CapabilityError struct {
+	Driver  string
+	Missing []Capability
+}
+
+func (e *CapabilityError) Error() string {
+	names := make([]string, len(e.Missing))
+	for i, c := range e.Missing {
+		names[i] = string(c)
+	}
+	return fmt.Sprintf("sbox: driver %q lacks required capabilities: %s", e.Driver, strings.Join(names, ", "))
+}
+
+func (e *CapabilityError) Unwrap() error {
+	return ErrNotSupported
+}
+
+// OpenOption configures [Open].
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	require    []Capability
+	probe      bool
+	probeWrite bool
+}
+
+// Require makes [Open] fail with a *CapabilityError listing every given
+// capability the engine lacks:
+//
+//	engine, err := sbox.Open(cfg, sbox.Require(sbox.CapRangeRead, sbox.CapSignedURL))
+func Require(caps ...Capability) OpenOption {
+	return func(o *openOptions) {
+		o.require = append(o.require, caps...)
+	}
+}
+
+// checkRequired returns a *CapabilityError if engine lacks any of caps.
+func checkRequired(driver string, engine StorageEngine, caps []Capability) error {
+	var missing []Capability
+	for _, c := range caps {
+		if !Supports(engine, c) {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return &CapabilityError{Driver: driver, Missing: missing}
+	}
+	return nil
+}
+
+// Compile-time interface checks.
+var _ error = (*CapabilityError)(nil)
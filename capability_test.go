@@ -0,0 +1,49 @@
+package sbox_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/nuln/sbox"
+	_ "github.com/nuln/sbox/local"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/metrics"
+)
+
+func TestOpen_Require(t *testing.T) {
+	cfg := &sbox.Config{Type: "local", BasePath: t.TempDir()}
+	if _, err := sbox.Open(cfg, sbox.Require(sbox.CapStreamRead, sbox.CapAtomicWrite)); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	_, err := sbox.Open(cfg, sbox.Require(sbox.CapCopy, sbox.CapSignedURL, sbox.CapTier))
+	var capErr *sbox.CapabilityError
+	if !errors.As(err, &capErr) || !errors.Is(err, sbox.ErrNotSupported) {
+		t.Fatalf("Open = %v, want *CapabilityError", err)
+	}
+	if capErr.Driver != "local" || !slices.Equal(capErr.Missing, []sbox.Capability{sbox.CapSignedURL, sbox.CapTier}) {
+		t.Errorf("error = %+v", capErr)
+	}
+	if got := err.Error(); got != `sbox: driver "local" lacks required capabilities: SignedURL, Tier` {
+		t.Errorf("message = %q", got)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	inner := memory.New()
+	caps := sbox.Capabilities(inner)
+	if !slices.Contains(caps, sbox.CapCopy) || slices.Contains(caps, sbox.CapVersions) {
+		t.Errorf("memory capabilities = %v", caps)
+	}
+
+	// Middleware report what the wrapped engine supports, not only the
+	// methods they forward.
+	wrapped := metrics.New(inner, "memory")
+	if sbox.Supports(wrapped, sbox.CapHash) != sbox.Supports(inner, sbox.CapHash) {
+		t.Errorf("metrics Hash = %v", sbox.Supports(wrapped, sbox.CapHash))
+	}
+	if !sbox.Supports(wrapped, sbox.CapStreamWrite) {
+		t.Error("metrics does not support StreamWrite")
+	}
+}
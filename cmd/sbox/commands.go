@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+	"github.com/nuln/sbox/sync"
+)
+
+func runLs(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	long := fs.Bool("l", false, "show mode, size and modification time")
+	recursive := fs.Bool("R", false, "list subdirectories recursively")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	root, err := sbox.CleanPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	show := func(p string, info *sbox.EntryInfo) {
+		if info.IsDir {
+			p += "/"
+		}
+		if *long {
+			fmt.Fprintf(e.stdout, "%s %12d %s %s\n", info.Mode, info.Size, info.ModTime.Format(time.RFC3339), p)
+		} else {
+			fmt.Fprintln(e.stdout, p)
+		}
+	}
+
+	if root != "" {
+		info, err := e.engine.Stat(ctx, root)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			show(root, info)
+			return nil
+		}
+	}
+	if *recursive {
+		return sbox.Walk(ctx, e.engine, root, func(p string, info *sbox.EntryInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != root {
+				show(p, info)
+			}
+			return nil
+		})
+	}
+	entries, err := e.engine.ReadDir(ctx, root)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b *sbox.EntryInfo) int { return strings.Compare(a.Name, b.Name) })
+	for _, info := range entries {
+		show(info.Name, info)
+	}
+	return nil
+}
+
+func runCat(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+	for _, arg := range fs.Args() {
+		p, err := sbox.CleanPath(arg)
+		if err != nil {
+			return err
+		}
+		r, err := sbox.Ranged(e.engine).GetRange(ctx, p, 0, -1)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(e.stdout, r)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runPut(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	appendData := fs.Bool("append", false, "append to the file instead of replacing it")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	local, dst := fs.Arg(0), fs.Arg(1)
+	if strings.HasSuffix(dst, "/") && local != "-" {
+		dst += filepath.Base(local)
+	}
+	p, err := sbox.CleanPath(dst)
+	if err != nil {
+		return err
+	}
+
+	r := e.stdin
+	if local != "-" {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	if *appendData {
+		_, err = sbox.Append(ctx, e.engine, p, r)
+		return err
+	}
+	return sbox.PutAtomic(ctx, e.engine, p, r)
+}
+
+func runGet(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	p, err := sbox.CleanPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	local := fs.Arg(1)
+	if local == "" {
+		local = path.Base(p)
+	} else if info, err := os.Stat(local); err == nil && info.IsDir() {
+		local = filepath.Join(local, path.Base(p))
+	}
+
+	r, err := sbox.Ranged(e.engine).GetRange(ctx, p, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	if local == "-" {
+		_, err = io.Copy(e.stdout, r)
+		return err
+	}
+
+	// Write to a temporary file first, so a failed download does not
+	// clobber an existing file.
+	f, err := os.CreateTemp(filepath.Dir(local), ".sbox-get-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), local)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func runRm(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	recursive := fs.Bool("r", false, "remove directories and their contents")
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+	for _, arg := range fs.Args() {
+		p, err := sbox.CleanPath(arg)
+		if err != nil {
+			return err
+		}
+		if p == "" {
+			return fmt.Errorf("refusing to remove the root: %w", sbox.ErrInvalid)
+		}
+		info, err := e.engine.Stat(ctx, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir && !*recursive {
+			return fmt.Errorf("%s: %w (use -r)", p, sbox.ErrIsDir)
+		}
+		if err := e.engine.Remove(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runCp(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	src, err := sbox.CleanPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := sbox.CleanPath(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return sbox.Copied(e.engine).Copy(ctx, src, dst)
+}
+
+func runSync(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	to := fs.String("to", "", "sync to the engine with this connection string instead of within the engine")
+	compare := fs.String("compare", "modtime", "how to compare files: modtime, size or hash")
+	del := fs.Bool("delete", false, "delete destination files missing from the source")
+	dryRun := fs.Bool("dry-run", false, "report what would change without changing it")
+	concurrency := fs.Int("concurrency", sync.DefaultConcurrency, "number of files transferred in parallel")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+
+	opts := sync.Options{Delete: *del, DryRun: *dryRun, Concurrency: *concurrency}
+	switch *compare {
+	case "modtime":
+		opts.Compare = sync.CompareModTime
+	case "size":
+		opts.Compare = sync.CompareSize
+	case "hash":
+		opts.Compare = sync.CompareHash
+	default:
+		fmt.Fprintf(e.stderr, "sbox sync: invalid -compare %q\n", *compare)
+		fs.Usage()
+		return errUsage
+	}
+	var err error
+	if opts.SrcPath, err = sbox.CleanPath(fs.Arg(0)); err != nil {
+		return err
+	}
+	if opts.DstPath, err = sbox.CleanPath(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	dst := e.engine
+	if *to != "" {
+		if dst, err = sbox.OpenURL(*to); err != nil {
+			return err
+		}
+		defer func() { _ = sbox.Close(dst) }()
+	}
+	stats, err := sync.Sync(ctx, e.engine, dst, opts)
+	if stats != nil {
+		fmt.Fprintf(e.stdout, "checked %d, copied %d (%d bytes), deleted %d, errors %d\n",
+			stats.Checked, stats.Copied, stats.Bytes, stats.Deleted, stats.Errors)
+	}
+	return err
+}
+
+func runHash(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	algorithm := fs.String("a", "sha256", "hash `algorithm`: md5, sha1, sha256, sha512 or one of the engine's")
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+	for _, arg := range fs.Args() {
+		p, err := sbox.CleanPath(arg)
+		if err != nil {
+			return err
+		}
+		sum, err := sbox.Hashed(e.engine).Hash(ctx, p, *algorithm)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s  %s\n", sum, p)
+	}
+	return nil
+}
+
+// shardedEngine returns the engine as a sharded engine, for the commands
+// only it supports.
+func shardedEngine(e *env) (*sharded.Engine, error) {
+	s, ok := e.engine.(*sharded.Engine)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a sharded engine: %w", e.engine, sbox.ErrNotSupported)
+	}
+	return s, nil
+}
+
+func runGC(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	dryRun := fs.Bool("dry-run", false, "report which shards would be deleted without deleting them")
+	minAge := fs.Duration("min-age", time.Hour, "keep shards modified more recently than this")
+	rate := fs.Float64("rate", 0, "delete at most this many shards per second (0 is unlimited)")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	s, err := shardedEngine(e)
+	if err != nil {
+		return err
+	}
+	stats, err := s.GCWithProgress(ctx, sharded.GCOptions{DryRun: *dryRun, MinAge: *minAge, DeleteRate: *rate})
+	if stats != nil {
+		verb := "deleted"
+		if *dryRun {
+			verb = "would delete"
+		}
+		fmt.Fprintf(e.stdout, "%d manifests, %d live shards, %d scanned, %s %d (%d bytes)\n",
+			stats.Manifests, stats.Live, stats.Scanned, verb, stats.Deleted, stats.BytesFreed)
+	}
+	return err
+}
+
+// errDamaged is returned by verify when it finds damage.
+var errDamaged = errors.New("damage found")
+
+func runVerify(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	s, err := shardedEngine(e)
+	if err != nil {
+		return err
+	}
+	report, err := s.Verify(ctx, sharded.VerifyOptions{})
+	if report == nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "%d manifests, %d shards checked\n", report.Manifests, report.Shards)
+	for _, list := range []struct {
+		name  string
+		items []string
+	}{
+		{"missing shard", report.Missing},
+		{"corrupt shard", report.Corrupt},
+		{"damaged file", report.Files},
+		{"damaged history", report.History},
+		{"corrupt manifest", report.CorruptManifests},
+	} {
+		for _, item := range list.items {
+			fmt.Fprintf(e.stdout, "%s: %s\n", list.name, item)
+		}
+	}
+	if err == nil && !report.OK() {
+		err = errDamaged
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+// Command sbox works with the files of any sbox storage engine from the
+// shell, for operators who need to inspect or repair a store without
+// writing Go.
+//
+// Usage:
+//
+//	sbox [-config file [-engine name]] [-dsn dsn] command [flags] [arguments]
+//
+// The engine is configured by -config, a YAML, JSON or TOML file read by
+// sbox.LoadConfigFile, such as {"type": "sharded", "basePath":
+// "/var/lib/sbox"}, or by -dsn, a connection string such as
+// sharded:///var/lib/sbox. Of a file defining several engines, -engine
+// selects one; the file's default engine is used otherwise. Without
+// -config or -dsn, the SBOX_DSN environment variable is used. All
+// built-in drivers are available.
+//
+// The commands are:
+//
+//	ls      list a directory
+//	cat     write files to standard output
+//	put     store a local file or standard input
+//	get     fetch a file to a local file or standard output
+//	rm      remove files and directories
+//	cp      copy a file or directory within the engine
+//	sync    make a directory match another, possibly on another engine
+//	hash    print the digests of files
+//	gc      remove unreferenced shards of a sharded engine
+//	verify  check the shards of a sharded engine
+//	serve   serve the engine over WebDAV, S3, REST or gRPC
+//	shell   explore the engine interactively
+//
+// Run "sbox command -h" for the flags of a command. The shell offers cd,
+// ls, get, put and the other commands with paths relative to a working
+// directory, glob patterns matched against the engine and, on a terminal,
+// tab completion of engine paths.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nuln/sbox"
+	_ "github.com/nuln/sbox/drivers"
+)
+
+// env is what commands run with.
+type env struct {
+	cmd    *command
+	engine sbox.StorageEngine
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a subcommand.
+type command struct {
+	name  string
+	args  string // Argument synopsis
+	short string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+// errUsage is returned by commands called with wrong arguments, after
+// printing their usage.
+var errUsage = errors.New("usage")
+
+// commands is set in init, since the shell runs commands.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"ls", "[-l] [-R] [path]", "list a directory", runLs},
+		{"cat", "path...", "write files to standard output", runCat},
+		{"put", "[-append] local|- path", "store a local file or standard input", runPut},
+		{"get", "path [local|-]", "fetch a file to a local file or standard output", runGet},
+		{"rm", "[-r] path...", "remove files and directories", runRm},
+		{"cp", "src dst", "copy a file or directory within the engine", runCp},
+		{"sync", "[flags] src dst", "make a directory match another, possibly on another engine", runSync},
+		{"hash", "[-a algorithm] path...", "print the digests of files", runHash},
+		{"gc", "[-dry-run] [-min-age duration] [-rate n]", "remove unreferenced shards of a sharded engine", runGC},
+		{"verify", "", "check the shards of a sharded engine", runVerify},
+		{"serve", "[-protocol p] [-addr addr] [-prefix prefix] [-token token]", "serve the engine over WebDAV, S3, REST or gRPC", runServe},
+		{"shell", "[dir]", "explore the engine interactively", runShell},
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run runs the command line args and returns the exit status: 0 on
+// success, 1 on failure and 2 for usage errors.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sbox", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "read the engine `config`uration from a YAML, JSON or TOML file")
+	engineName := fs.String("engine", "", "open the engine of this `name` from the -config file")
+	dsn := fs.String("dsn", "", "open the engine from a connection string (default $SBOX_DSN)")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var cmd *command
+	for _, c := range commands {
+		if c.name == fs.Arg(0) {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "sbox: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	if *dsn == "" && *configPath == "" {
+		*dsn = os.Getenv("SBOX_DSN")
+	}
+	engine, err := openEngine(*configPath, *engineName, *dsn)
+	if err != nil {
+		fmt.Fprintf(stderr, "sbox: %v\n", err)
+		return 1
+	}
+	defer func() { _ = sbox.Close(engine) }()
+
+	e := &env{cmd: cmd, engine: engine, stdin: stdin, stdout: stdout, stderr: stderr}
+	switch err := cmd.run(ctx, e, fs.Args()[1:]); {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "sbox %s: %v\n", cmd.name, err)
+		return 1
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: sbox [-config file [-engine name]] [-dsn dsn] command [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-7s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+// openEngine opens the engine called name in the file configPath, or the
+// engine of dsn.
+func openEngine(configPath, name, dsn string) (sbox.StorageEngine, error) {
+	switch {
+	case configPath != "" && dsn != "":
+		return nil, errors.New("-config and -dsn are mutually exclusive")
+	case name != "" && configPath == "":
+		return nil, errors.New("-engine requires -config")
+	case configPath != "":
+		f, err := sbox.LoadConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		return f.Open(name)
+	case dsn != "":
+		return sbox.OpenURL(dsn)
+	}
+	return nil, errors.New("no engine configured: use -config, -dsn or $SBOX_DSN")
+}
+
+// flags returns the flag set of the command, which prints its usage to
+// e.stderr.
+func (e *env) flags() *flag.FlagSet {
+	c := e.cmd
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: sbox %s %s\n\n%s.\n", c.name, c.args, c.short)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(e.stderr, "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parse parses args with fs and checks the number of arguments left.
+func parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
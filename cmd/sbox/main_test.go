@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runSbox runs the command line args and returns its exit status and output.
+func runSbox(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestFiles(t *testing.T) {
+	dsn := "memory://?name=" + t.Name()
+	check := func(wantOut string, args ...string) {
+		t.Helper()
+		code, out, errOut := runSbox(t, "", append([]string{"-dsn", dsn}, args...)...)
+		if code != 0 || out != wantOut {
+			t.Fatalf("sbox %v = %d, %q, stderr %q; want output %q", args, code, out, errOut, wantOut)
+		}
+	}
+
+	if code, _, errOut := runSbox(t, "hello\n", "-dsn", dsn, "put", "-", "/docs/a.txt"); code != 0 {
+		t.Fatalf("put: %d %s", code, errOut)
+	}
+	check("", "put", "-append", "-", "docs/a.txt")
+	check("hello\n", "cat", "docs/a.txt")
+	check("", "cp", "docs", "copy")
+	check("copy/\ndocs/\n", "ls")
+	check("copy/\ncopy/a.txt\ndocs/\ndocs/a.txt\n", "ls", "-R")
+	check("5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  copy/a.txt\n", "hash", "copy/a.txt")
+
+	local := filepath.Join(t.TempDir(), "a.txt")
+	check("", "get", "docs/a.txt", local)
+	if b, err := os.ReadFile(local); err != nil || string(b) != "hello\n" {
+		t.Fatalf("downloaded %q, %v", b, err)
+	}
+
+	if code, _, errOut := runSbox(t, "", "-dsn", dsn, "rm", "docs"); code != 1 || !strings.Contains(errOut, "-r") {
+		t.Fatalf("rm directory without -r = %d, %q", code, errOut)
+	}
+	check("", "rm", "-r", "docs")
+	check("copy/\n", "ls")
+
+	backup := "memory://?name=" + t.Name() + "-backup"
+	check("checked 1, copied 1 (6 bytes), deleted 0, errors 0\n", "sync", "-to", backup, "copy", "b")
+	if code, out, _ := runSbox(t, "", "-dsn", backup, "cat", "b/a.txt"); code != 0 || out != "hello\n" {
+		t.Fatalf("synced file = %d, %q", code, out)
+	}
+}
+
+func TestSharded(t *testing.T) {
+	dsn := "sharded://" + t.TempDir()
+	if code, _, errOut := runSbox(t, "data", "-dsn", dsn, "put", "-", "f"); code != 0 {
+		t.Fatalf("put: %d %s", code, errOut)
+	}
+	if code, out, errOut := runSbox(t, "", "-dsn", dsn, "verify"); code != 0 || !strings.Contains(out, "1 manifests") {
+		t.Fatalf("verify = %d, %q, %q", code, out, errOut)
+	}
+	if code, out, errOut := runSbox(t, "", "-dsn", dsn, "gc", "-dry-run"); code != 0 || !strings.Contains(out, "would delete 0") {
+		t.Fatalf("gc = %d, %q, %q", code, out, errOut)
+	}
+	if code, _, errOut := runSbox(t, "", "-dsn", "memory://", "gc"); code != 1 || !strings.Contains(errOut, "not a sharded engine") {
+		t.Fatalf("gc on memory = %d, %q", code, errOut)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "sbox.json")
+	if err := os.WriteFile(config, []byte(`{"type": "local", "basePath": "`+dir+`"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, out, errOut := runSbox(t, "", "-config", config, "ls"); code != 0 || out != "sbox.json\n" {
+		t.Fatalf("ls = %d, %q, %q", code, out, errOut)
+	}
+
+	t.Setenv("SBOX_TEST_DIR", dir)
+	config = filepath.Join(dir, "sbox.toml")
+	data := "[engines.local]\ntype = \"local\"\nbasePath = \"${SBOX_TEST_DIR}\"\n\n[engines.mem]\ndsn = \"memory://\"\n"
+	if err := os.WriteFile(config, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, out, errOut := runSbox(t, "", "-config", config, "-engine", "local", "ls"); code != 0 || out != "sbox.json\nsbox.toml\n" {
+		t.Fatalf("ls local = %d, %q, %q", code, out, errOut)
+	}
+	if code, _, errOut := runSbox(t, "", "-config", config, "ls"); code != 1 || !strings.Contains(errOut, "no default engine") {
+		t.Fatalf("ls without -engine = %d, %q", code, errOut)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if code, _, errOut := runSbox(t, "", "-dsn", "memory://", "frobnicate"); code != 2 || !strings.Contains(errOut, "unknown command") {
+		t.Fatalf("unknown command = %d, %q", code, errOut)
+	}
+	if code, _, errOut := runSbox(t, "", "-dsn", "memory://", "cat"); code != 2 || !strings.Contains(errOut, "Usage: sbox cat") {
+		t.Fatalf("cat without arguments = %d, %q", code, errOut)
+	}
+	t.Setenv("SBOX_DSN", "")
+	if code, _, errOut := runSbox(t, "", "ls"); code != 1 || !strings.Contains(errOut, "no engine configured") {
+		t.Fatalf("no engine = %d, %q", code, errOut)
+	}
+}
+
+func TestShell(t *testing.T) {
+	dsn := "memory://?name=" + t.Name()
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "up 1.txt"), []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(local)
+
+	script := `
+mkdir logs/2024 docs
+cd logs
+put "up 1.txt"
+cp "up 1.txt" 2024/a.log
+cp 'up 1.txt' 2024/b.log
+pwd
+ls 2024/*.log
+cat 2024/a*
+cd ..
+ls
+stat logs/2024/b.log
+frobnicate
+rm logs/2024
+cd /logs/2024
+mv b.log c.log
+ls
+lcd sub
+get *.log
+exit
+ls
+`
+	if err := os.Mkdir(filepath.Join(local, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	code, out, errOut := runSbox(t, script, "-dsn", dsn, "shell")
+	if code != 0 {
+		t.Fatalf("shell = %d, %q", code, errOut)
+	}
+	want := "/logs\nlogs/2024/a.log\nlogs/2024/b.log\none" + "docs/\nlogs/\n" +
+		"path:     /logs/2024/b.log\nsize:     3\n"
+	if !strings.HasPrefix(out, want) {
+		t.Fatalf("output = %q, want prefix %q", out, want)
+	}
+	if !strings.HasSuffix(out, "a.log\nc.log\n") {
+		t.Fatalf("output = %q", out)
+	}
+	for _, msg := range []string{`unknown command "frobnicate"`, "use -r"} {
+		if !strings.Contains(errOut, msg) {
+			t.Errorf("stderr %q lacks %q", errOut, msg)
+		}
+	}
+	for _, name := range []string{"a.log", "c.log"} {
+		if b, err := os.ReadFile(filepath.Join(local, "sub", name)); err != nil || string(b) != "one" {
+			t.Errorf("downloaded %s = %q, %v", name, b, err)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	words, err := splitWords(`ls  -l "a b" c\ d 'e*' f*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []word{{"ls", false}, {"-l", false}, {"a b", true}, {"c d", true}, {"e*", true}, {"f*", false}}
+	if !slices.Equal(words, want) {
+		t.Fatalf("splitWords = %v, want %v", words, want)
+	}
+	if _, err := splitWords(`cat "a`); err == nil {
+		t.Fatal("unterminated quote accepted")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nuln/sbox/gateway/s3"
+	"github.com/nuln/sbox/grpc"
+	"github.com/nuln/sbox/rest"
+	"github.com/nuln/sbox/webdav"
+)
+
+func runServe(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	protocol := fs.String("protocol", "webdav", "protocol to serve: webdav, s3, rest or grpc")
+	addr := fs.String("addr", "localhost:8080", "listen `address`")
+	prefix := fs.String("prefix", "", "URL path prefix (webdav and rest)")
+	token := fs.String("token", "", "require this bearer token (rest and grpc)")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *token != "" && *protocol != "rest" && *protocol != "grpc" {
+		fmt.Fprintf(e.stderr, "sbox serve: -token is not supported by %s\n", *protocol)
+		return errUsage
+	}
+
+	srv := &http.Server{ReadHeaderTimeout: 30 * time.Second}
+	switch *protocol {
+	case "webdav":
+		srv.Handler = webdav.NewHandler(e.engine, *prefix)
+	case "s3":
+		srv.Handler = s3.New(e.engine)
+	case "rest":
+		srv.Handler = rest.NewHandler(e.engine, *prefix, rest.WithServerToken(*token))
+	case "grpc":
+		srv.Handler = grpc.NewServer(e.engine, grpc.WithServerToken(*token))
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	default:
+		fmt.Fprintf(e.stderr, "sbox serve: unknown protocol %q\n", *protocol)
+		fs.Usage()
+		return errUsage
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "serving %s on %s\n", *protocol, l.Addr())
+
+	done := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		done <- srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-done
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/peterh/liner"
+	"golang.org/x/term"
+
+	"github.com/nuln/sbox"
+)
+
+// shell is the state of an interactive session.
+type shell struct {
+	env      *env
+	cwd      string // Engine working directory; the root is ""
+	localDir string // Local working directory
+}
+
+// shellCommand is a command of the shell.
+type shellCommand struct {
+	name  string
+	args  string
+	short string
+	run   func(ctx context.Context, s *shell, args []word) error
+}
+
+// shellCommands is set in init, since help refers to it.
+var shellCommands []*shellCommand
+
+func init() {
+	shellCommands = []*shellCommand{
+		{"ls", "[-l] [path|pattern...]", "list directories", shLs},
+		{"cd", "[dir]", "change the working directory", shCd},
+		{"pwd", "", "print the working directory", shPwd},
+		{"cat", "path|pattern...", "write files to the terminal", shCat},
+		{"get", "path|pattern...", "fetch files to the local directory", shGet},
+		{"put", "local|pattern...", "store local files in the working directory", shPut},
+		{"rm", "[-r] path|pattern...", "remove files and directories", shRm},
+		{"mkdir", "dir...", "create directories", shMkdir},
+		{"cp", "src dst", "copy a file or directory", shCp},
+		{"mv", "src dst", "move a file or directory", shMv},
+		{"stat", "path|pattern...", "show the attributes of files", shStat},
+		{"hash", "[-a algorithm] path|pattern...", "print the digests of files", shHash},
+		{"lcd", "[dir]", "change the local directory", shLcd},
+		{"lpwd", "", "print the local directory", shLpwd},
+		{"help", "", "list the commands", shHelp},
+		{"exit", "", "leave the shell", nil},
+	}
+}
+
+// lineReader reads the command lines of a session.
+type lineReader interface {
+	Prompt(prompt string) (string, error)
+	AppendHistory(line string)
+}
+
+// scanReader reads lines from a non-interactive input.
+type scanReader struct {
+	sc *bufio.Scanner
+}
+
+func (r scanReader) Prompt(string) (string, error) {
+	if !r.sc.Scan() {
+		if err := r.sc.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.sc.Text(), nil
+}
+
+func (r scanReader) AppendHistory(string) {}
+
+func runShell(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	cwd, err := sbox.CleanPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	localDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	s := &shell{env: e, cwd: cwd, localDir: localDir}
+
+	var lines lineReader = scanReader{bufio.NewScanner(e.stdin)}
+	if f, ok := e.stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		line := liner.NewLiner()
+		defer func() { _ = line.Close() }()
+		line.SetCtrlCAborts(true)
+		line.SetTabCompletionStyle(liner.TabPrints)
+		line.SetWordCompleter(func(line string, pos int) (string, []string, string) {
+			return s.complete(ctx, line, pos)
+		})
+		if history, err := historyPath(); err == nil {
+			if f, err := os.Open(history); err == nil {
+				_, _ = line.ReadHistory(f)
+				_ = f.Close()
+			}
+			defer func() {
+				if f, err := os.Create(history); err == nil {
+					_, _ = line.WriteHistory(f)
+					_ = f.Close()
+				}
+			}()
+		}
+		lines = line
+	}
+	return s.loop(ctx, lines)
+}
+
+// historyPath returns the file keeping the command history.
+func historyPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".sbox_history"), nil
+}
+
+// loop runs command lines until exit, the end of the input or
+// cancellation of ctx. Failed commands are reported and do not end the
+// session.
+func (s *shell) loop(ctx context.Context, lines lineReader) error {
+	for ctx.Err() == nil {
+		line, err := lines.Prompt("sbox:/" + s.cwd + "> ")
+		switch {
+		case errors.Is(err, liner.ErrPromptAborted):
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		words, err := splitWords(line)
+		if err != nil {
+			fmt.Fprintf(s.env.stderr, "%v\n", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		lines.AppendHistory(line)
+
+		name := words[0].text
+		if name == "exit" || name == "quit" {
+			return nil
+		}
+		i := slices.IndexFunc(shellCommands, func(c *shellCommand) bool { return c.name == name })
+		if i < 0 {
+			fmt.Fprintf(s.env.stderr, "unknown command %q; try help\n", name)
+			continue
+		}
+		if err := shellCommands[i].run(ctx, s, words[1:]); err != nil && !errors.Is(err, errUsage) {
+			fmt.Fprintf(s.env.stderr, "%s: %v\n", name, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// word is an argument of a command line. Quoted words are not expanded.
+type word struct {
+	text   string
+	quoted bool
+}
+
+// splitWords splits a command line into words separated by spaces.
+// Single and double quotes and backslashes escape spaces and glob
+// characters.
+func splitWords(line string) ([]word, error) {
+	var (
+		words []word
+		cur   strings.Builder
+		w     word
+		in    bool // Inside a word
+		quote rune
+		esc   bool
+	)
+	for _, r := range line {
+		switch {
+		case esc:
+			cur.WriteRune(r)
+			esc = false
+		case r == '\\' && quote != '\'':
+			esc, in, w.quoted = true, true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, in, w.quoted = r, true, true
+		case r == ' ' || r == '\t':
+			if in {
+				w.text = cur.String()
+				words = append(words, w)
+				cur.Reset()
+				w, in = word{}, false
+			}
+		default:
+			cur.WriteRune(r)
+			in = true
+		}
+	}
+	if quote != 0 || esc {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if in {
+		w.text = cur.String()
+		words = append(words, w)
+	}
+	return words, nil
+}
+
+// hasMeta reports whether p contains glob characters.
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// resolve returns the engine path of p relative to the working directory.
+func (s *shell) resolve(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join("/"+s.cwd, p)
+	}
+	return sbox.CleanPath(path.Clean(p))
+}
+
+// expand resolves the path arguments in args and expands their glob
+// patterns against the engine. Arguments starting with "-" are kept.
+func (s *shell) expand(ctx context.Context, args []word) ([]string, error) {
+	var out []string
+	for _, a := range args {
+		if !a.quoted && strings.HasPrefix(a.text, "-") {
+			out = append(out, a.text)
+			continue
+		}
+		p, err := s.resolve(a.text)
+		if err != nil {
+			return nil, err
+		}
+		if a.quoted || !hasMeta(a.text) {
+			out = append(out, p)
+			continue
+		}
+		matches, err := sbox.Glob(ctx, s.env.engine, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no match", a.text)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// expandLocal resolves local arguments against the local directory and
+// expands their glob patterns.
+func (s *shell) expandLocal(args []word) ([]string, error) {
+	var out []string
+	for _, a := range args {
+		p := a.text
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(s.localDir, p)
+		}
+		if a.quoted || !hasMeta(a.text) {
+			out = append(out, p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no match", a.text)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// call runs the top-level command name with args.
+func (s *shell) call(ctx context.Context, name string, args []string) error {
+	i := slices.IndexFunc(commands, func(c *command) bool { return c.name == name })
+	e := *s.env
+	e.cmd = commands[i]
+	return e.cmd.run(ctx, &e, args)
+}
+
+// callEach runs the top-level command name once per path in args, passing
+// the leading flags every time.
+func (s *shell) callEach(ctx context.Context, name string, args []string) error {
+	n := slices.IndexFunc(args, func(a string) bool { return !strings.HasPrefix(a, "-") })
+	if n < 0 {
+		return s.call(ctx, name, args)
+	}
+	flags, paths := args[:n], args[n:]
+	var errs []error
+	for _, p := range paths {
+		if err := s.call(ctx, name, append(slices.Clone(flags), p)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func shLs(ctx context.Context, s *shell, args []word) error {
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(paths, func(p string) bool { return !strings.HasPrefix(p, "-") }) {
+		paths = append(paths, s.cwd)
+	}
+	return s.callEach(ctx, "ls", paths)
+}
+
+func shCd(ctx context.Context, s *shell, args []word) error {
+	if len(args) > 1 {
+		return errors.New("usage: cd [dir]")
+	}
+	dir := ""
+	if len(args) == 1 {
+		paths, err := s.expand(ctx, args)
+		if err != nil {
+			return err
+		}
+		if len(paths) != 1 {
+			return fmt.Errorf("%s: ambiguous", args[0].text)
+		}
+		dir = paths[0]
+	}
+	if dir != "" {
+		info, err := s.env.engine.Stat(ctx, dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			return fmt.Errorf("%s: %w", dir, sbox.ErrNotDir)
+		}
+	}
+	s.cwd = dir
+	return nil
+}
+
+func shPwd(ctx context.Context, s *shell, args []word) error {
+	fmt.Fprintln(s.env.stdout, "/"+s.cwd)
+	return nil
+}
+
+func shCat(ctx context.Context, s *shell, args []word) error {
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "cat", paths)
+}
+
+func shGet(ctx context.Context, s *shell, args []word) error {
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return errors.New("usage: get path|pattern...")
+	}
+	var errs []error
+	for _, p := range paths {
+		if err := s.call(ctx, "get", []string{p, s.localDir}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func shPut(ctx context.Context, s *shell, args []word) error {
+	locals, err := s.expandLocal(args)
+	if err != nil {
+		return err
+	}
+	if len(locals) == 0 {
+		return errors.New("usage: put local|pattern...")
+	}
+	var errs []error
+	for _, local := range locals {
+		dst := path.Join("/"+s.cwd, filepath.Base(local))
+		if err := s.call(ctx, "put", []string{local, dst}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func shRm(ctx context.Context, s *shell, args []word) error {
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "rm", paths)
+}
+
+func shMkdir(ctx context.Context, s *shell, args []word) error {
+	for _, a := range args {
+		p, err := s.resolve(a.text)
+		if err != nil {
+			return err
+		}
+		if err := s.env.engine.MkdirAll(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// twoPaths expands the arguments of cp and mv.
+func (s *shell) twoPaths(ctx context.Context, name string, args []word) (string, string, error) {
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return "", "", err
+	}
+	if len(paths) != 2 {
+		return "", "", fmt.Errorf("usage: %s src dst", name)
+	}
+	return paths[0], paths[1], nil
+}
+
+func shCp(ctx context.Context, s *shell, args []word) error {
+	src, dst, err := s.twoPaths(ctx, "cp", args)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "cp", []string{src, dst})
+}
+
+func shMv(ctx context.Context, s *shell, args []word) error {
+	src, dst, err := s.twoPaths(ctx, "mv", args)
+	if err != nil {
+		return err
+	}
+	return sbox.Move(ctx, s.env.engine, src, s.env.engine, dst)
+}
+
+func shStat(ctx context.Context, s *shell, args []word) error {
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		info, err := s.env.engine.Stat(ctx, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(s.env.stdout, "path:     /%s\nsize:     %d\nmode:     %s\nmodified: %s\n",
+			p, info.Size, info.Mode, info.ModTime.Format(time.RFC3339))
+		for _, k := range slices.Sorted(maps.Keys(info.Metadata)) {
+			fmt.Fprintf(s.env.stdout, "meta:     %s=%s\n", k, info.Metadata[k])
+		}
+	}
+	return nil
+}
+
+func shHash(ctx context.Context, s *shell, args []word) error {
+	// The algorithm following -a is not a path.
+	var flags []string
+	if len(args) >= 2 && args[0].text == "-a" {
+		flags, args = []string{"-a", args[1].text}, args[2:]
+	}
+	paths, err := s.expand(ctx, args)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "hash", append(flags, paths...))
+}
+
+func shLcd(ctx context.Context, s *shell, args []word) error {
+	if len(args) > 1 {
+		return errors.New("usage: lcd [dir]")
+	}
+	dir, err := os.UserHomeDir()
+	if len(args) == 1 {
+		dir, err = args[0].text, nil
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(s.localDir, dir)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %w", dir, sbox.ErrNotDir)
+	}
+	s.localDir = dir
+	return nil
+}
+
+func shLpwd(ctx context.Context, s *shell, args []word) error {
+	fmt.Fprintln(s.env.stdout, s.localDir)
+	return nil
+}
+
+func shHelp(ctx context.Context, s *shell, args []word) error {
+	for _, c := range shellCommands {
+		fmt.Fprintf(s.env.stdout, "  %-6s %-32s %s\n", c.name, c.args, c.short)
+	}
+	return nil
+}
+
+// complete completes the word before pos in line: command names first,
+// then local paths for put and lcd, and engine paths otherwise.
+func (s *shell) complete(ctx context.Context, line string, pos int) (string, []string, string) {
+	head, tail := line[:pos], line[pos:]
+	start := strings.LastIndexAny(head, " \t") + 1
+	prefix := head[start:]
+	head = head[:start]
+
+	if strings.TrimSpace(head) == "" {
+		var names []string
+		for _, c := range shellCommands {
+			if strings.HasPrefix(c.name, prefix) {
+				names = append(names, c.name+" ")
+			}
+		}
+		return head, names, tail
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	dir, base := path.Split(prefix)
+	var candidates []string
+	switch strings.Fields(head)[0] {
+	case "put", "lcd":
+		localDir := dir
+		if !filepath.IsAbs(localDir) {
+			localDir = filepath.Join(s.localDir, localDir)
+		}
+		entries, err := os.ReadDir(localDir)
+		if err != nil {
+			return head, nil, tail
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), base) {
+				candidates = append(candidates, completion(dir, e.Name(), e.IsDir()))
+			}
+		}
+	default:
+		p, err := s.resolve(dir)
+		if err != nil {
+			return head, nil, tail
+		}
+		entries, err := s.env.engine.ReadDir(ctx, p)
+		if err != nil {
+			return head, nil, tail
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name, base) {
+				candidates = append(candidates, completion(dir, e.Name, e.IsDir))
+			}
+		}
+	}
+	slices.Sort(candidates)
+	return head, candidates, tail
+}
+
+// completion returns the completed word for the entry name in dir.
+// Directories end with a slash, so completion can continue below them.
+func completion(dir, name string, isDir bool) string {
+	name = strings.NewReplacer(" ", `\ `, "*", `\*`, "?", `\?`, "[", `\[`).Replace(name)
+	if isDir {
+		return dir + name + "/"
+	}
+	return dir + name + " "
+}
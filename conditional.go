@@ -0,0 +1,52 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Precondition is the condition of a write through [ConditionalWriter].
+// The zero value always holds.
+type Precondition struct {
+	// IfMatch requires the file to exist with this ETag.
+	IfMatch string
+
+	// IfNoneMatch requires the file not to exist, so that only one of
+	// several writers creates it.
+	IfNoneMatch bool
+}
+
+// Check returns ErrPreconditionFailed unless a file whose ETag lookup
+// returned etag and err satisfies p. Lookup errors other than ErrNotFound
+// are returned as they are. It is meant for drivers implementing
+// [ConditionalWriter].
+func (p Precondition) Check(etag string, err error) error {
+	exists := err == nil
+	if !exists && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if (p.IfNoneMatch && exists) || (p.IfMatch != "" && (!exists || etag != p.IfMatch)) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// PutIf writes the contents of r to path if cond holds, returning
+// ErrPreconditionFailed otherwise. Nothing is written if reading r fails.
+// Engines not implementing [ConditionalWriter] return ErrNotSupported.
+func PutIf(ctx context.Context, engine StorageEngine, path string, r io.Reader, cond Precondition) error {
+	cw, ok := engine.(ConditionalWriter)
+	if !ok {
+		return ErrNotSupported
+	}
+	w, err := cw.CreateIf(ctx, path, cond)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,43 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestPrecondition_Check(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		cond sbox.Precondition
+		etag string
+		err  error
+		want error
+	}{
+		{sbox.Precondition{}, "", sbox.ErrNotFound, nil},
+		{sbox.Precondition{}, "a", nil, nil},
+		{sbox.Precondition{IfNoneMatch: true}, "", sbox.ErrNotFound, nil},
+		{sbox.Precondition{IfNoneMatch: true}, "a", nil, sbox.ErrPreconditionFailed},
+		{sbox.Precondition{IfMatch: "a"}, "a", nil, nil},
+		{sbox.Precondition{IfMatch: "a"}, "b", nil, sbox.ErrPreconditionFailed},
+		{sbox.Precondition{IfMatch: "a"}, "", sbox.ErrNotFound, sbox.ErrPreconditionFailed},
+		{sbox.Precondition{IfMatch: "a"}, "", failure, failure},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.Check(tt.etag, tt.err); !errors.Is(got, tt.want) || (tt.want == nil && got != nil) {
+			t.Errorf("%+v.Check(%q, %v) = %v, want %v", tt.cond, tt.etag, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPutIf_NotSupported(t *testing.T) {
+	engine := struct{ sbox.StorageEngine }{memory.New()}
+	err := sbox.PutIf(context.Background(), engine, "f", strings.NewReader("x"), sbox.Precondition{})
+	if !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("PutIf = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
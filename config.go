@@ -21,9 +21,14 @@ type Config struct {
 // Factory is a function that creates a [StorageEngine] from a [Config].
 type Factory func(cfg *Config) (StorageEngine, error)
 
+// Wrapper wraps an engine opened by [Open] whose Config sets the option
+// the wrapper was registered for; value is the option value.
+type Wrapper func(engine StorageEngine, value any) (StorageEngine, error)
+
 var (
 	mu        sync.RWMutex
 	factories = make(map[string]Factory)
+	wrappers  = make(map[string]Wrapper)
 )
 
 // Register makes a storage driver available by the provided name.
@@ -39,6 +44,20 @@ func Register(name string, factory Factory) {
 	factories[name] = factory
 }
 
+// RegisterWrapper makes [Open] wrap engines whose Config sets option, so
+// middleware can be enabled from configuration. Wrappers apply in the
+// sorted order of their options. It panics if called twice with the same
+// option.
+func RegisterWrapper(option string, wrapper Wrapper) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := wrappers[option]; exists {
+		panic(fmt.Sprintf("sbox: wrapper for option %q already registered", option))
+	}
+	wrappers[option] = wrapper
+}
+
 // Drivers returns a sorted list of all registered driver names.
 func Drivers() []string {
 	mu.RLock()
@@ -58,7 +77,9 @@ func List() []string {
 }
 
 // Open creates a new [StorageEngine] using the registered driver specified in cfg.Type.
-func Open(cfg *Config) (StorageEngine, error) {
+// With [Require], it fails if the engine lacks a required capability; with
+// [Probe], if the engine does not work.
+func Open(cfg *Config, opts ...OpenOption) (StorageEngine, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("sbox: config must not be nil")
 	}
@@ -71,12 +92,61 @@ func Open(cfg *Config) (StorageEngine, error) {
 		return nil, fmt.Errorf("sbox: unknown driver %q (forgotten import?)", cfg.Type)
 	}
 
-	return factory(cfg)
+	var o openOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	engine, err := factory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if engine, err = wrap(engine, cfg); err != nil {
+		return nil, err
+	}
+	if err := checkRequired(cfg.Type, engine, o.require); err != nil {
+		_ = Close(engine)
+		return nil, err
+	}
+	if o.probe {
+		if err := probe(cfg.Type, engine, o.probeWrite); err != nil {
+			_ = Close(engine)
+			return nil, err
+		}
+	}
+	return engine, nil
+}
+
+// wrap applies the registered wrappers for the options set in cfg.
+func wrap(engine StorageEngine, cfg *Config) (StorageEngine, error) {
+	mu.RLock()
+	options := make([]string, 0, len(wrappers))
+	for option := range wrappers {
+		if _, ok := cfg.Options[option]; ok {
+			options = append(options, option)
+		}
+	}
+	sort.Strings(options)
+	apply := make([]Wrapper, len(options))
+	for i, option := range options {
+		apply[i] = wrappers[option]
+	}
+	mu.RUnlock()
+
+	for i, wrapper := range apply {
+		wrapped, err := wrapper(engine, cfg.Options[options[i]])
+		if err != nil {
+			_ = Close(engine)
+			return nil, fmt.Errorf("sbox: option %q: %w", options[i], err)
+		}
+		engine = wrapped
+	}
+	return engine, nil
 }
 
 // MustOpen is like [Open] but panics on error.
-func MustOpen(cfg *Config) StorageEngine {
-	engine, err := Open(cfg)
+func MustOpen(cfg *Config, opts ...OpenOption) StorageEngine {
+	engine, err := Open(cfg, opts...)
 	if err != nil {
 		panic(err)
 	}
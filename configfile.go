@@ -0,0 +1,311 @@
+package sbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is a set of named engine configurations, as read by
+// [LoadConfigFile].
+//
+// A file lists its engines under "engines", each either a [Config] or a
+// connection string given as "dsn", optionally with further "options":
+//
+//	default: hot
+//	engines:
+//	  hot:
+//	    type: sharded
+//	    basePath: /var/lib/sbox
+//	  archive:
+//	    dsn: rclone://s3:archive
+//	    options:
+//	      password: ${ARCHIVE_PASSWORD}
+//
+// A file holding a single Config at the top level defines the engine
+// "default".
+type ConfigFile struct {
+	// Default names the engine opened for the empty name.
+	Default string
+
+	// Engines maps engine names to their configuration.
+	Engines map[string]*Config
+}
+
+var (
+	namedMu      sync.RWMutex
+	named        = make(map[string]*Config)
+	namedDefault string
+)
+
+// LoadConfigFile reads the engine configurations of the file at path,
+// which is YAML, JSON or TOML according to its extension, and makes them
+// available to [OpenNamed]. Engines of later files replace engines of the
+// same name.
+//
+// "${VAR}" in string values is replaced by the environment variable VAR,
+// "${VAR:-default}" by default when VAR is unset or empty, and "$$" by
+// "$". Referring to an unset variable without a default is an error.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sbox: %w", err)
+	}
+	f, err := ParseConfigFile(b, strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return nil, fmt.Errorf("sbox: %s: %w", path, err)
+	}
+
+	namedMu.Lock()
+	defer namedMu.Unlock()
+	for name, cfg := range f.Engines {
+		named[name] = cfg
+	}
+	if f.Default != "" {
+		namedDefault = f.Default
+	}
+	return f, nil
+}
+
+// ParseConfigFile parses engine configurations in format, which is
+// "yaml", "yml", "json" or "toml". Unlike [LoadConfigFile], it does not
+// make them available to [OpenNamed].
+func ParseConfigFile(data []byte, format string) (*ConfigFile, error) {
+	var raw map[string]any
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case "json":
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case "toml":
+		var err error
+		if raw, err = parseTOML(string(data)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config file format %q", format)
+	}
+
+	v, err := expandEnv(raw)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ = v.(map[string]any)
+
+	f := &ConfigFile{Engines: make(map[string]*Config)}
+	engines, ok := raw["engines"]
+	if !ok {
+		cfg, err := configFromMap(raw)
+		if err != nil {
+			return nil, err
+		}
+		f.Default = "default"
+		f.Engines["default"] = cfg
+		return f, nil
+	}
+	if f.Default, ok = raw["default"].(string); !ok && raw["default"] != nil {
+		return nil, fmt.Errorf("default: not a string")
+	}
+	m, ok := engines.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("engines: not a table")
+	}
+	for name, v := range m {
+		em, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("engine %q: not a table", name)
+		}
+		cfg, err := configFromMap(em)
+		if err != nil {
+			return nil, fmt.Errorf("engine %q: %w", name, err)
+		}
+		f.Engines[name] = cfg
+	}
+	if f.Default != "" && f.Engines[f.Default] == nil {
+		return nil, fmt.Errorf("default engine %q is not defined", f.Default)
+	}
+	return f, nil
+}
+
+// Names returns the sorted names of the engines in f.
+func (f *ConfigFile) Names() []string {
+	names := make([]string, 0, len(f.Engines))
+	for name := range f.Engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the engine of f called name, or the default engine if name
+// is empty.
+func (f *ConfigFile) Open(name string, opts ...OpenOption) (StorageEngine, error) {
+	cfg, err := lookupNamed(f.Engines, f.Default, name)
+	if err != nil {
+		return nil, err
+	}
+	return Open(cfg, opts...)
+}
+
+// OpenNamed opens the engine called name from the files read by
+// [LoadConfigFile]. The empty name opens the default engine: the one a
+// file named as default, or the only engine loaded.
+func OpenNamed(name string, opts ...OpenOption) (StorageEngine, error) {
+	namedMu.RLock()
+	cfg, err := lookupNamed(named, namedDefault, name)
+	namedMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return Open(cfg, opts...)
+}
+
+// lookupNamed returns a copy of the configuration called name in engines.
+func lookupNamed(engines map[string]*Config, def, name string) (*Config, error) {
+	if name == "" {
+		name = def
+		if name == "" && len(engines) == 1 {
+			for n := range engines {
+				name = n
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("sbox: no default engine configured")
+		}
+	}
+	cfg, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("sbox: unknown engine %q", name)
+	}
+	c := *cfg
+	if cfg.Options != nil {
+		c.Options = make(map[string]any, len(cfg.Options))
+		for k, v := range cfg.Options {
+			c.Options[k] = v
+		}
+	}
+	return &c, nil
+}
+
+// configFromMap builds a Config from a decoded engine table.
+func configFromMap(m map[string]any) (*Config, error) {
+	cfg := &Config{}
+	if dsn, ok := m["dsn"]; ok {
+		s, ok := dsn.(string)
+		if !ok {
+			return nil, fmt.Errorf("dsn: not a string")
+		}
+		c, err := ParseDSN(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg = c
+	}
+	for _, field := range []struct {
+		key string
+		dst *string
+	}{{"type", &cfg.Type}, {"basePath", &cfg.BasePath}} {
+		v, ok := m[field.key]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a string", field.key)
+		}
+		*field.dst = s
+	}
+	if cfg.Type == "" {
+		return nil, fmt.Errorf("neither type nor dsn set")
+	}
+	if v, ok := m["options"]; ok {
+		opts, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("options: not a table")
+		}
+		if cfg.Options == nil {
+			cfg.Options = make(map[string]any, len(opts))
+		}
+		for k, v := range opts {
+			cfg.Options[k] = v
+		}
+	}
+	return cfg, nil
+}
+
+// expandEnv substitutes environment variables in the strings of v.
+func expandEnv(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnvString(v)
+	case map[string]any:
+		for k, e := range v {
+			x, err := expandEnv(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = x
+		}
+	case []any:
+		for i, e := range v {
+			x, err := expandEnv(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = x
+		}
+	}
+	return v, nil
+}
+
+func expandEnvString(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+			continue
+		case '{':
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		name, def, hasDef := strings.Cut(ref, ":-")
+		val, ok := os.LookupEnv(name)
+		switch {
+		case val != "":
+		case hasDef:
+			val = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(val)
+		s = s[i+end+1:]
+	}
+}
@@ -0,0 +1,135 @@
+package sbox_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	_ "github.com/nuln/sbox/local"
+	_ "github.com/nuln/sbox/memory"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	t.Setenv("SBOX_TEST_DIR", "/data")
+	t.Setenv("SBOX_TEST_EMPTY", "")
+
+	files := map[string]string{
+		"yaml": `
+default: hot
+engines:
+  hot:
+    type: local
+    basePath: ${SBOX_TEST_DIR}/hot
+    options:
+      chunkSize: 1024
+      tags: [a, "${SBOX_TEST_DIR}"]
+  archive:
+    dsn: memory://?name=archive
+    options:
+      password: ${SBOX_TEST_EMPTY:-secret}$$
+`,
+		"json": `{
+  "default": "hot",
+  "engines": {
+    "hot": {"type": "local", "basePath": "${SBOX_TEST_DIR}/hot", "options": {"chunkSize": 1024, "tags": ["a", "${SBOX_TEST_DIR}"]}},
+    "archive": {"dsn": "memory://?name=archive", "options": {"password": "${SBOX_TEST_EMPTY:-secret}$$"}}
+  }
+}`,
+		"toml": `
+# Engines for the test.
+default = "hot"
+
+[engines.hot]
+type = "local"
+basePath = "${SBOX_TEST_DIR}/hot"
+options = { chunkSize = 1_024, tags = [
+  "a",
+  '${SBOX_TEST_DIR}', # Trailing comma
+] }
+
+[engines.archive]
+dsn = "memory://?name=archive"
+options.password = "${SBOX_TEST_EMPTY:-secret}$$"
+`,
+	}
+	for format, data := range files {
+		t.Run(format, func(t *testing.T) {
+			f, err := sbox.ParseConfigFile([]byte(data), format)
+			if err != nil {
+				t.Fatalf("ParseConfigFile: %v", err)
+			}
+			if f.Default != "hot" || strings.Join(f.Names(), ",") != "archive,hot" {
+				t.Fatalf("Default = %q, Names = %v", f.Default, f.Names())
+			}
+			hot := f.Engines["hot"]
+			if hot.Type != "local" || hot.BasePath != "/data/hot" {
+				t.Errorf("hot = %+v", hot)
+			}
+			switch n := hot.Options["chunkSize"].(type) {
+			case int, int64, float64:
+			default:
+				t.Errorf("chunkSize = %T %v", n, n)
+			}
+			if tags, _ := hot.Options["tags"].([]any); len(tags) != 2 || tags[1] != "/data" {
+				t.Errorf("tags = %v", hot.Options["tags"])
+			}
+			archive := f.Engines["archive"]
+			if archive.Type != "memory" || archive.Options["name"] != "archive" || archive.Options["password"] != "secret$" {
+				t.Errorf("archive = %+v", archive)
+			}
+		})
+	}
+}
+
+func TestParseConfigFile_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		format, data, want string
+	}{
+		{"yaml", "type: local\nbasePath: ${SBOX_TEST_UNSET}\n", "SBOX_TEST_UNSET is not set"},
+		{"json", `{"default": "x", "engines": {"y": {"type": "memory"}}}`, `"x" is not defined`},
+		{"json", `{"engines": {"y": {"basePath": "/"}}}`, "neither type nor dsn"},
+		{"toml", "[[engines]]\n", "arrays of tables"},
+		{"toml", "a = 1\na = 2\n", "line 2: duplicate key"},
+		{"toml", "a = 1979-05-27\n", "dates are not supported"},
+		{"ini", "", "unknown config file format"},
+	} {
+		if _, err := sbox.ParseConfigFile([]byte(tt.data), tt.format); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseConfigFile(%q) = %v, want error containing %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestOpenNamed(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SBOX_TEST_DIR", dir)
+	path := filepath.Join(dir, "sbox.yml")
+	data := "default: hot\nengines:\n  hot:\n    type: local\n    basePath: ${SBOX_TEST_DIR}\n  archive:\n    dsn: memory://?name=" + t.Name() + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sbox.LoadConfigFile(path); err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+
+	ctx := context.Background()
+	hot, err := sbox.OpenNamed("")
+	if err != nil {
+		t.Fatalf("OpenNamed default: %v", err)
+	}
+	defer func() { _ = sbox.Close(hot) }()
+	if _, err := hot.Stat(ctx, "sbox.yml"); err != nil {
+		t.Errorf("default engine is not the local directory: %v", err)
+	}
+
+	archive, err := sbox.OpenNamed("archive", sbox.Require(sbox.CapCopy))
+	if err != nil {
+		t.Fatalf("OpenNamed archive: %v", err)
+	}
+	defer func() { _ = sbox.Close(archive) }()
+	if _, err := sbox.OpenNamed("missing"); err == nil || !strings.Contains(err.Error(), "unknown engine") {
+		t.Errorf("OpenNamed missing = %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package sbox
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// DetectContentType returns the MIME type of a file named name whose
+// content starts with head. The type sniffed from head by
+// http.DetectContentType wins, unless it is generic binary or text data
+// and the extension of name has a registered type, e.g. ".css".
+func DetectContentType(name string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/plain") {
+		return sniffed
+	}
+	if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return sniffed
+}
+
+// ContentTypeSniffer keeps the first bytes written to a file to detect its
+// content type. Engines storing content types write the data of files
+// written with [WithDetectedContentType] to it.
+type ContentTypeSniffer struct {
+	name string
+	head []byte
+}
+
+// NewContentTypeSniffer returns a ContentTypeSniffer for a file at path.
+func NewContentTypeSniffer(path string) *ContentTypeSniffer {
+	return &ContentTypeSniffer{name: path}
+}
+
+// Write keeps the start of p needed for detection. It never fails.
+func (s *ContentTypeSniffer) Write(p []byte) (int, error) {
+	if n := sniffLen - len(s.head); n > 0 {
+		s.head = append(s.head, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// ContentType returns the type detected from what was written.
+func (s *ContentTypeSniffer) ContentType() string {
+	return DetectContentType(s.name, s.head)
+}
@@ -0,0 +1,37 @@
+package sbox_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name, head, want string
+	}{
+		{"page", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"image.bin", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"style.css", "body { margin: 0 }", "text/css; charset=utf-8"},
+		{"notes", "plain words", "text/plain; charset=utf-8"},
+		{"blob", "\x00\x01\x02", "application/octet-stream"},
+		{"empty.txt", "", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if got := sbox.DetectContentType(tt.name, []byte(tt.head)); got != tt.want {
+			t.Errorf("DetectContentType(%q, %q) = %q, want %q", tt.name, tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestContentTypeSniffer(t *testing.T) {
+	s := sbox.NewContentTypeSniffer("dir/file")
+	// The type is decided by the first 512 bytes, however they are split.
+	_, _ = s.Write([]byte("%PDF-"))
+	_, _ = s.Write([]byte(strings.Repeat("\x00", 1000)))
+	_, _ = s.Write([]byte("<html>"))
+	if got := s.ContentType(); got != "application/pdf" {
+		t.Errorf("ContentType = %q, want application/pdf", got)
+	}
+}
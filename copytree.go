@@ -0,0 +1,159 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DefaultCopyConcurrency is the number of files copied in parallel by
+// CopyTree when CopyTreeOptions.Concurrency is not set.
+const DefaultCopyConcurrency = 4
+
+// CopyTreeOptions controls a CopyTree call.
+type CopyTreeOptions struct {
+	// Concurrency is the number of files copied in parallel.
+	Concurrency int
+
+	// Progress, if set, is called after every file is copied or fails.
+	// Calls are serialized.
+	Progress func(p CopyProgress)
+}
+
+// CopyProgress describes one file finished by CopyTree, along with the
+// totals so far.
+type CopyProgress struct {
+	Src  string // Source path
+	Dst  string // Destination path
+	Size int64  // File size
+	Err  error  // Set if the file could not be copied
+
+	Files int   // Files copied so far, including this one
+	Bytes int64 // Bytes copied so far
+}
+
+// CopyTree copies the file or directory tree at srcPath on src to dstPath
+// on dst. Files are written with PutAtomic by a bounded pool of workers,
+// and their modification times, metadata and permission bits are preserved
+// where dst supports it. A failure on one file does not stop the others; CopyTree
+// returns every error encountered. Progress is also reported as the "copy"
+// operation to the [Progress] attached to ctx.
+func CopyTree(ctx context.Context, src StorageEngine, srcPath string, dst StorageEngine, dstPath string, opts CopyTreeOptions) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultCopyConcurrency
+	}
+
+	var (
+		mu      sync.Mutex
+		errs    []error
+		total   CopyProgress
+		tracker = NewProgressTracker(ctx, "copy")
+	)
+	record := func(p CopyProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Err != nil {
+			errs = append(errs, p.Err)
+		} else {
+			total.Files++
+			total.Bytes += p.Size
+		}
+		p.Files, p.Bytes = total.Files, total.Bytes
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	files := make(chan *EntryInfo)
+	var wg sync.WaitGroup
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range files {
+				dp := copyTreeDst(srcPath, dstPath, info.Path)
+				err := copyTreeFile(ctx, src, info, dst, dp, tracker)
+				tracker.Advance(info.Path, 0, 1)
+				if err != nil {
+					err = fmt.Errorf("sbox: copy %s: %w", info.Path, err)
+				}
+				record(CopyProgress{Src: info.Path, Dst: dp, Size: info.Size, Err: err})
+			}
+		}()
+	}
+
+	walkErr := Walk(ctx, src, srcPath, func(p string, info *EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir {
+			return dst.MkdirAll(ctx, copyTreeDst(srcPath, dstPath, p))
+		}
+		tracker.AddTotal(info.Size, 1)
+		select {
+		case files <- info:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	if walkErr != nil {
+		errs = append(errs, fmt.Errorf("sbox: walk %s: %w", srcPath, walkErr))
+	}
+	return errors.Join(errs...)
+}
+
+// copyTreeDst maps a path under srcRoot to the same place under dstRoot.
+func copyTreeDst(srcRoot, dstRoot, p string) string {
+	rel := strings.TrimPrefix(cleanRoutePath(p), cleanRoutePath(srcRoot))
+	if rel == "" {
+		return dstRoot
+	}
+	return path.Join(dstRoot, rel)
+}
+
+// copyTreeFile copies a single file and, where supported, its modification
+// time and metadata. The bytes read are reported to t.
+func copyTreeFile(ctx context.Context, src StorageEngine, info *EntryInfo, dst StorageEngine, dstPath string, t *ProgressTracker) error {
+	r, err := src.Open(ctx, info.Path)
+	if err != nil {
+		return err
+	}
+	err = PutAtomic(ctx, dst, dstPath, t.Reader(info.Path, r))
+	_ = r.Close()
+	if err != nil {
+		return err
+	}
+	return copyAttributes(ctx, info, dst, dstPath)
+}
+
+// copyAttributes gives dstPath the modification time, metadata and
+// permission bits of info where dst supports it. Ownership is not copied, as
+// changing it usually requires privileges.
+func copyAttributes(ctx context.Context, info *EntryInfo, dst StorageEngine, dstPath string) error {
+	if mw, ok := dst.(MetadataWriter); ok && len(info.Metadata) > 0 {
+		if err := mw.SetMetadata(ctx, dstPath, info.Metadata); err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	if pm, ok := dst.(PermissionManager); ok && info.Mode.Perm() != 0 {
+		if err := pm.Chmod(ctx, dstPath, info.Mode.Perm()); err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	if ms, ok := dst.(ModTimeSetter); ok && !info.ModTime.IsZero() {
+		if err := ms.SetModTime(ctx, dstPath, info.ModTime); err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
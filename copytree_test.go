@@ -0,0 +1,125 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/local"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestCopyTree(t *testing.T) {
+	ctx := context.Background()
+	src := memory.New()
+	files := map[string]string{
+		"data/a.txt":       "alpha",
+		"data/sub/b.txt":   "bravo",
+		"data/sub/c/d.txt": "delta",
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for p, content := range files {
+		if err := sbox.PutAtomic(ctx, src, p, strings.NewReader(content)); err != nil {
+			t.Fatalf("PutAtomic: %v", err)
+		}
+		if err := src.SetModTime(ctx, p, mtime); err != nil {
+			t.Fatalf("SetModTime: %v", err)
+		}
+		if err := src.Chmod(ctx, p, 0640); err != nil {
+			t.Fatalf("Chmod: %v", err)
+		}
+	}
+
+	dst, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var last sbox.CopyProgress
+	calls := 0
+	err = sbox.CopyTree(ctx, src, "data", dst, "backup", sbox.CopyTreeOptions{
+		Concurrency: 2,
+		Progress: func(p sbox.CopyProgress) {
+			calls++
+			last = p
+		},
+	})
+	if err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+	if calls != len(files) || last.Files != len(files) || last.Bytes != 15 {
+		t.Errorf("progress: %d calls, last = %+v", calls, last)
+	}
+
+	for p, content := range files {
+		dp := "backup" + strings.TrimPrefix(p, "data")
+		r, err := dst.Open(ctx, dp)
+		if err != nil {
+			t.Fatalf("Open %s: %v", dp, err)
+		}
+		got, _ := io.ReadAll(r)
+		_ = r.Close()
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", dp, got, content)
+		}
+		info, err := dst.Stat(ctx, dp)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if !info.ModTime.Equal(mtime) {
+			t.Errorf("%s modtime = %v, want %v", dp, info.ModTime, mtime)
+		}
+		if info.Mode.Perm() != 0640 {
+			t.Errorf("%s mode = %v, want %v", dp, info.Mode.Perm(), os.FileMode(0640))
+		}
+	}
+}
+
+func TestCopyTree_ReportsFailures(t *testing.T) {
+	ctx := context.Background()
+	src := memory.New()
+	for _, p := range []string{"a.txt", "b.txt"} {
+		if err := sbox.PutAtomic(ctx, src, "in/"+p, strings.NewReader(p)); err != nil {
+			t.Fatalf("PutAtomic: %v", err)
+		}
+	}
+	dst := &rejectingEngine{StorageEngine: memory.New(), reject: "out/a.txt"}
+
+	var failed []string
+	err := sbox.CopyTree(ctx, src, "in", dst, "out", sbox.CopyTreeOptions{
+		Progress: func(p sbox.CopyProgress) {
+			if p.Err != nil {
+				failed = append(failed, p.Src)
+			}
+		},
+	})
+	if err == nil {
+		t.Fatal("CopyTree succeeded")
+	}
+	if len(failed) != 1 || failed[0] != "in/a.txt" {
+		t.Errorf("failed = %v, want [in/a.txt]", failed)
+	}
+	if _, err := dst.Stat(ctx, "out/b.txt"); err != nil {
+		t.Errorf("b.txt not copied: %v", err)
+	}
+	if !errors.Is(err, sbox.ErrPermission) {
+		t.Errorf("error = %v, want %v", err, sbox.ErrPermission)
+	}
+}
+
+// rejectingEngine refuses to create one path.
+type rejectingEngine struct {
+	sbox.StorageEngine
+	reject string
+}
+
+func (e *rejectingEngine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	if path == e.reject {
+		return nil, sbox.ErrPermission
+	}
+	return e.StorageEngine.Create(ctx, path)
+}
@@ -6,6 +6,7 @@
 // # Supported Drivers
 //
 //   - local   — Local filesystem via afero (import _ "github.com/nuln/sbox/local")
+//   - memory  — In-memory storage for tests (import _ "github.com/nuln/sbox/memory")
 //   - sharded — Content-addressed chunked storage (import _ "github.com/nuln/sbox/sharded")
 //   - rclone  — Any rclone-supported remote (import _ "github.com/nuln/sbox/rclone")
 //
@@ -18,6 +19,55 @@
 //
 //	engine, err := sbox.Open(&sbox.Config{Type: "local", BasePath: "./data"})
 //
+// Engines can also be opened from a connection string:
+//
+//	engine, err := sbox.OpenURL("sharded:///var/data?chunkSize=8388608")
+//
+// # Router
+//
+// [NewRouter] combines several engines into one namespace by mounting each
+// at a path prefix.
+//
+// # Sub
+//
+// [Sub] scopes an engine to a directory, rejecting paths that try to
+// escape it, e.g. to give each tenant of a service its own view.
+//
+// # Sync
+//
+// Package sync copies changes from one engine to another, like a portable
+// "rclone sync" that works between any two drivers.
+//
+// # S3 Gateway
+//
+// Package gateway/s3 serves any engine over a subset of the S3 API for
+// existing S3 clients and tools.
+//
+// # Resumable Uploads
+//
+// Package tusd stores tus resumable uploads in any engine, for use with
+// the tusd server.
+//
+// # FUSE and NFS
+//
+// Package fuse mounts any engine as a local filesystem, and package nfs
+// serves one over NFSv3 where FUSE is not available.
+//
+// # Middleware
+//
+// Packages under middleware wrap an existing engine and return a new one:
+//
+//   - middleware/compress — Transparent zstd/gzip compression
+//   - middleware/cache    — Read-through/write-through cache with LRU and TTL
+//   - middleware/retry    — Retries transient failures with exponential backoff
+//   - middleware/ratelimit — Token-bucket limits on ops/sec and read/write bytes/sec
+//   - middleware/timeout  — Metadata, transfer and idle timeouts for hung remotes
+//   - middleware/metrics  — Prometheus latency, error and byte counters
+//   - middleware/quota    — Byte and file-count limits per path prefix
+//   - middleware/mirror   — Replication to replicas with read failover and Repair
+//   - middleware/overlay  — Union mount of a writable engine over read-only ones
+//   - middleware/normalize — Unicode (NFC/NFD) and case normalization of paths
+//
 // # Import All Drivers
 //
 //	import _ "github.com/nuln/sbox/drivers"
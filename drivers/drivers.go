@@ -1,15 +1,22 @@
 // Package drivers is a convenience package that registers all built-in
-// storage drivers. Import it with a blank identifier to make all drivers
-// available:
+// storage drivers and configuration options. Import it with a blank
+// identifier to make all drivers available:
 //
 //	import _ "github.com/nuln/sbox/drivers"
 package drivers
 
 import (
 	"github.com/nuln/sbox"
+	_ "github.com/nuln/sbox/archive"
+	_ "github.com/nuln/sbox/grpc"
+	_ "github.com/nuln/sbox/kv"
 	_ "github.com/nuln/sbox/local"
+	_ "github.com/nuln/sbox/memory"
+	_ "github.com/nuln/sbox/middleware/normalize"
 	_ "github.com/nuln/sbox/rclone"
+	_ "github.com/nuln/sbox/rest"
 	_ "github.com/nuln/sbox/sharded"
+	_ "github.com/nuln/sbox/webdav"
 )
 
 // Init ensures all built-in drivers are registered.
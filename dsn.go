@@ -0,0 +1,87 @@
+package sbox
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DSNParser converts the part of a connection string following "<type>://"
+// into a [Config]. The returned Config's Type is filled in by [ParseDSN].
+type DSNParser func(rest string) (*Config, error)
+
+var dsnParsers = make(map[string]DSNParser)
+
+// RegisterDSN installs a custom DSN parser for the named driver. Drivers
+// without one use the default parser, which maps the path to BasePath and
+// query parameters to string-valued Options.
+// It panics if called twice with the same name.
+func RegisterDSN(name string, parser DSNParser) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := dsnParsers[name]; exists {
+		panic(fmt.Sprintf("sbox: DSN parser for %q already registered", name))
+	}
+	dsnParsers[name] = parser
+}
+
+// ParseDSN converts a connection string such as
+// "sharded:///var/data?chunkSize=8388608" or "rclone://gdrive:backup"
+// into a [Config].
+func ParseDSN(dsn string) (*Config, error) {
+	name, rest, ok := strings.Cut(dsn, "://")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("sbox: invalid DSN %q: missing \"<type>://\" prefix", dsn)
+	}
+
+	mu.RLock()
+	parser, ok := dsnParsers[name]
+	mu.RUnlock()
+	if !ok {
+		parser = parseDefaultDSN
+	}
+
+	cfg, err := parser(rest)
+	if err != nil {
+		return nil, fmt.Errorf("sbox: invalid DSN %q: %w", dsn, err)
+	}
+	cfg.Type = name
+	return cfg, nil
+}
+
+// OpenURL creates a new [StorageEngine] from a connection string.
+// See [ParseDSN] for the accepted format.
+func OpenURL(dsn string, opts ...OpenOption) (StorageEngine, error) {
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return Open(cfg, opts...)
+}
+
+// parseDefaultDSN maps "path?key=value&..." to BasePath and Options.
+func parseDefaultDSN(rest string) (*Config, error) {
+	rawPath, rawQuery, _ := strings.Cut(rest, "?")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{BasePath: path}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	if len(query) > 0 {
+		cfg.Options = make(map[string]any, len(query))
+		for key, values := range query {
+			if len(values) == 1 {
+				cfg.Options[key] = values[0]
+			} else {
+				cfg.Options[key] = values
+			}
+		}
+	}
+	return cfg, nil
+}
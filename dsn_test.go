@@ -0,0 +1,48 @@
+package sbox_test
+
+import (
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+func TestParseDSN(t *testing.T) {
+	cfg, err := sbox.ParseDSN("sharded:///var/data?chunkSize=8388608&verifyOnRead=true")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	if cfg.Type != "sharded" {
+		t.Errorf("Type = %q, want %q", cfg.Type, "sharded")
+	}
+	if cfg.BasePath != "/var/data" {
+		t.Errorf("BasePath = %q, want %q", cfg.BasePath, "/var/data")
+	}
+	if cfg.Options["chunkSize"] != "8388608" {
+		t.Errorf("chunkSize = %v, want %q", cfg.Options["chunkSize"], "8388608")
+	}
+	if cfg.Options["verifyOnRead"] != "true" {
+		t.Errorf("verifyOnRead = %v, want %q", cfg.Options["verifyOnRead"], "true")
+	}
+}
+
+func TestParseDSN_CustomParser(t *testing.T) {
+	sbox.RegisterDSN("dsntest", func(rest string) (*sbox.Config, error) {
+		return &sbox.Config{Options: map[string]any{"remote": rest}}, nil
+	})
+
+	cfg, err := sbox.ParseDSN("dsntest://gdrive:backup")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	if cfg.Type != "dsntest" || cfg.Options["remote"] != "gdrive:backup" {
+		t.Errorf("cfg = %+v, want dsntest with remote gdrive:backup", cfg)
+	}
+}
+
+func TestParseDSN_Invalid(t *testing.T) {
+	for _, dsn := range []string{"", "/var/data", "://x", "local://%zz"} {
+		if _, err := sbox.ParseDSN(dsn); err == nil {
+			t.Errorf("ParseDSN(%q): expected error", dsn)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package sbox
+
+import "fmt"
+
+// Durability selects what a successful Close guarantees about written data
+// surviving a power loss or OS crash. A crash of the process alone never
+// loses data handed to the OS. Drivers that support it read the level from
+// the "durability" option; see the README for the exact semantics per
+// driver.
+type Durability string
+
+const (
+	// DurabilityNone leaves flushing to the OS: files whose Close
+	// succeeded shortly before a power loss may be lost or truncated. It
+	// is the default.
+	DurabilityNone Durability = "none"
+
+	// DurabilityFlush flushes the file data to stable storage on Close.
+	// The directory entry of a new or renamed file may still be lost.
+	DurabilityFlush Durability = "flush"
+
+	// DurabilityFsync also flushes the directories whose entries changed,
+	// so a file whose Close succeeded survives a power loss under its
+	// name.
+	DurabilityFsync Durability = "fsync"
+)
+
+// ParseDurability parses a durability level. The empty string is
+// DurabilityNone.
+func ParseDurability(s string) (Durability, error) {
+	switch d := Durability(s); d {
+	case "":
+		return DurabilityNone, nil
+	case DurabilityNone, DurabilityFlush, DurabilityFsync:
+		return d, nil
+	default:
+		return "", fmt.Errorf("sbox: unknown durability %q", s)
+	}
+}
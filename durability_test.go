@@ -0,0 +1,23 @@
+package sbox_test
+
+import (
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+func TestParseDurability(t *testing.T) {
+	for in, want := range map[string]sbox.Durability{
+		"":      sbox.DurabilityNone,
+		"none":  sbox.DurabilityNone,
+		"flush": sbox.DurabilityFlush,
+		"fsync": sbox.DurabilityFsync,
+	} {
+		if got, err := sbox.ParseDurability(in); err != nil || got != want {
+			t.Errorf("ParseDurability(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := sbox.ParseDurability("sync"); err == nil {
+		t.Error("ParseDurability accepted an unknown level")
+	}
+}
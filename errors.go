@@ -2,7 +2,10 @@ package sbox
 
 import (
 	"errors"
+	"io/fs"
 	"os"
+	"strconv"
+	"syscall"
 )
 
 // Common storage errors. Where possible, these alias os package errors
@@ -16,4 +19,131 @@ var (
 	ErrNotDir       = errors.New("sbox: not a directory")
 	ErrClosed       = errors.New("sbox: already closed")
 	ErrNotSupported = errors.New("sbox: feature not supported by this backend")
+
+	// ErrCorruptManifest is returned when a manifest cannot be decoded or
+	// does not match its checksum.
+	ErrCorruptManifest = errors.New("sbox: corrupt manifest")
+
+	// ErrLocked is returned by Locker.Lock when the lock is held by someone
+	// else; ErrLockLost when a lease has expired or was taken over.
+	ErrLocked   = errors.New("sbox: locked")
+	ErrLockLost = errors.New("sbox: lock lost")
+
+	// ErrPreconditionFailed is returned by conditional writes when the
+	// file does not match the Precondition.
+	ErrPreconditionFailed = errors.New("sbox: precondition failed")
+
+	// ErrNoSpace is returned by writes that do not fit in the space left
+	// on the backend, usually as a *NoSpaceError.
+	ErrNoSpace = errors.New("sbox: no space left")
+
+	// ErrChecksumMismatch is returned by writes whose content does not
+	// match the checksum given with WithChecksum, or that the backend did
+	// not store as written; see WithVerification.
+	ErrChecksumMismatch = errors.New("sbox: checksum mismatch")
 )
+
+// PathError records a failed operation on a path and the driver it
+// failed in. Drivers return the errors of their StorageEngine methods as
+// *PathError, with Err matching the sentinel errors above where one
+// applies, so callers can both errors.As and errors.Is them.
+type PathError struct {
+	Op     string // "stat", "open", "create", "remove", "rename", "mkdir" or "readdir"
+	Driver string // The name the driver is registered under
+	Path   string // The engine path, not the backend's
+	Err    error
+}
+
+func (e *PathError) Error() string {
+	return "sbox/" + e.Driver + ": " + e.Op + " " + strconv.Quote(e.Path) + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// NewPathError returns err as a *PathError of driver, or nil if err is
+// nil. An err that already is a *PathError is returned unchanged, so
+// engines built on other engines keep the innermost context. The path
+// errors of the os package are replaced, as their paths are the
+// backend's, and Err is passed through [Classify].
+func NewPathError(driver, op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*PathError); ok {
+		return err
+	}
+	switch e := err.(type) {
+	case *fs.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return &PathError{Op: op, Driver: driver, Path: path, Err: Classify(err)}
+}
+
+// OSError returns err as an *os.PathError if it is a *PathError matching
+// ErrNotFound, ErrExist or ErrPermission, for code that tests errors with
+// os.IsNotExist and the like, which only see through the error types of
+// the os package. Other errors are returned unchanged.
+func OSError(err error) error {
+	var pe *PathError
+	if !errors.As(err, &pe) {
+		return err
+	}
+	for _, target := range []error{ErrNotFound, ErrExist, ErrPermission} {
+		if errors.Is(pe, target) {
+			return &os.PathError{Op: pe.Op, Path: pe.Path, Err: target}
+		}
+	}
+	return err
+}
+
+// Classify returns err made to match the sentinel error for the OS errors
+// that have one but do not match it by themselves, such as ENOTDIR for
+// ErrNotDir and ENOSPC for ErrNoSpace. Other errors are returned
+// unchanged.
+func Classify(err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	switch errno {
+	case syscall.ENOTDIR:
+		return ClassifyAs(err, ErrNotDir)
+	case syscall.EISDIR:
+		return ClassifyAs(err, ErrIsDir)
+	case syscall.ENOSPC, syscall.EDQUOT:
+		return ClassifyAs(err, ErrNoSpace)
+	case syscall.EROFS:
+		return ClassifyAs(err, ErrPermission)
+	}
+	return err
+}
+
+// ClassifyAs returns err made to match sentinel with errors.Is, keeping
+// its message, e.g. for a backend's own not found error. It returns err
+// unchanged if it is nil or matches sentinel already.
+func ClassifyAs(err, sentinel error) error {
+	if err == nil || errors.Is(err, sentinel) {
+		return err
+	}
+	return &classifiedError{err: err, sentinel: sentinel}
+}
+
+// classifiedError is an error that also matches a sentinel error.
+type classifiedError struct {
+	err      error
+	sentinel error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.err, e.sentinel}
+}
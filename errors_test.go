@@ -0,0 +1,58 @@
+package sbox_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+func TestNewPathError(t *testing.T) {
+	if err := sbox.NewPathError("local", "stat", "a", nil); err != nil {
+		t.Errorf("NewPathError(nil) = %v", err)
+	}
+
+	native := &fs.PathError{Op: "mkdir", Path: "/srv/data/a/b", Err: syscall.ENOTDIR}
+	err := sbox.NewPathError("local", "mkdir", "a/b", native)
+	var pe *sbox.PathError
+	if !errors.As(err, &pe) || pe.Driver != "local" || pe.Op != "mkdir" || pe.Path != "a/b" {
+		t.Fatalf("NewPathError = %#v", err)
+	}
+	if !errors.Is(err, sbox.ErrNotDir) || !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("%v does not match ErrNotDir and ENOTDIR", err)
+	}
+	if want := `sbox/local: mkdir "a/b": not a directory`; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if again := sbox.NewPathError("overlay", "stat", "b", err); again != err {
+		t.Errorf("NewPathError of a *PathError = %v, want it unchanged", again)
+	}
+
+	if err := sbox.Classify(syscall.ENOSPC); !errors.Is(err, sbox.ErrNoSpace) || err.Error() != syscall.ENOSPC.Error() {
+		t.Errorf("Classify(ENOSPC) = %v", err)
+	}
+	if err := sbox.Classify(sbox.ErrClosed); err != sbox.ErrClosed {
+		t.Errorf("Classify(ErrClosed) = %v", err)
+	}
+	backend := errors.New("object not found")
+	if err := sbox.ClassifyAs(backend, sbox.ErrNotFound); !errors.Is(err, sbox.ErrNotFound) || !errors.Is(err, backend) {
+		t.Errorf("ClassifyAs = %v", err)
+	}
+}
+
+func TestOSError(t *testing.T) {
+	err := sbox.NewPathError("sharded", "stat", "a", sbox.ErrNotFound)
+	if os.IsNotExist(err) {
+		t.Fatal("os.IsNotExist sees through *sbox.PathError; OSError is unnecessary")
+	}
+	if err := sbox.OSError(err); !os.IsNotExist(err) {
+		t.Errorf("OSError = %v, want os.IsNotExist", err)
+	}
+	closed := sbox.NewPathError("sharded", "stat", "a", sbox.ErrClosed)
+	if err := sbox.OSError(closed); err != closed {
+		t.Errorf("OSError(%v) = %v, want it unchanged", closed, err)
+	}
+}
@@ -3,6 +3,7 @@ package sbox
 import (
 	"context"
 	"io"
+	"os"
 	"time"
 )
 
@@ -24,6 +25,16 @@ type RangeReader interface {
 	GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
 }
 
+// RangeWriter supports patching a byte range of an existing file in place,
+// with the semantics of io.WriterAt: the data of r replaces the bytes at
+// offset, the rest of the file is unchanged, and data ending past the end
+// extends the file, filling any gap before offset with zeros. PutRange
+// returns the number of bytes written. Files that do not exist fail with
+// ErrNotFound. See [PutRange] for engines without it.
+type RangeWriter interface {
+	PutRange(ctx context.Context, path string, offset int64, r io.Reader) (int64, error)
+}
+
 // Hasher supports calculating file hashes.
 type Hasher interface {
 	Hash(ctx context.Context, path string, algorithm string) (string, error)
@@ -35,7 +46,189 @@ type Copier interface {
 	Copy(ctx context.Context, src, dst string) error
 }
 
+// Appender supports adding data to the end of a file without rewriting
+// it, creating the file if it does not exist. Append returns the number of
+// bytes read from r and appended. Use the package-level [Append] to fall
+// back to a rewrite on engines without native support.
+type Appender interface {
+	Append(ctx context.Context, path string, r io.Reader) (int64, error)
+}
+
+// ModTimeSetter supports changing the modification time of a file, e.g. to
+// preserve it when copying between engines.
+type ModTimeSetter interface {
+	SetModTime(ctx context.Context, path string, t time.Time) error
+}
+
+// MetadataWriter supports changing the modification time and the user
+// metadata of a file without rewriting its content. SetMetadata replaces
+// all existing metadata; the current metadata is reported by Stat in
+// EntryInfo.Metadata. Overwriting a file discards its metadata.
+type MetadataWriter interface {
+	ModTimeSetter
+	SetMetadata(ctx context.Context, path string, metadata map[string]string) error
+}
+
+// Symlinker supports symbolic links. Symlink creates a link at path
+// pointing to target, which is stored verbatim and resolved relative to the
+// link's directory unless it starts with "/". Stat and Open follow links;
+// Lstat describes the link itself, with Mode including os.ModeSymlink and
+// LinkTarget set. ReadDir reports links like Lstat. Readlink returns
+// sbox.ErrInvalid if path is not a link.
+type Symlinker interface {
+	Symlink(ctx context.Context, target, path string) error
+	Readlink(ctx context.Context, path string) (string, error)
+	Lstat(ctx context.Context, path string) (*EntryInfo, error)
+}
+
+// PermissionManager supports POSIX permission bits and ownership, so that
+// backups can restore them. Stat reports them in EntryInfo.Mode, Uid and
+// Gid. As with os.Chown, a uid or gid of -1 is left unchanged.
+type PermissionManager interface {
+	Chmod(ctx context.Context, path string, mode os.FileMode) error
+	Chown(ctx context.Context, path string, uid, gid int) error
+}
+
+// ConditionalWriter supports optimistic concurrency: a writer states the
+// ETag of the version it read, and the write fails cleanly with
+// ErrPreconditionFailed if the file has changed since, instead of the last
+// writer silently winning. ETags are opaque and change whenever the
+// content does; most engines change them on every write. CreateIf checks the precondition when called
+// and again when the file is published on Close; use [PutIf] to write from
+// a reader.
+type ConditionalWriter interface {
+	ETag(ctx context.Context, path string) (string, error)
+	CreateIf(ctx context.Context, path string, cond Precondition) (AtomicWriteCloser, error)
+}
+
+// Glober lists the paths matching a pattern more efficiently than walking
+// the tree, e.g. with a recursive listing. Implementations follow the
+// semantics of [Glob], which uses them when available.
+type Glober interface {
+	Glob(ctx context.Context, pattern string) ([]string, error)
+}
+
 // SignedURLGenerator generates temporary access URLs (e.g., S3 presigned URLs).
 type SignedURLGenerator interface {
 	SignedURL(ctx context.Context, path string, expiry time.Duration) (string, error)
 }
+
+// AtomicWriter supports creating files that only become visible, in full,
+// when the writer is closed successfully. Readers never observe partially
+// written content.
+type AtomicWriter interface {
+	CreateAtomic(ctx context.Context, path string) (AtomicWriteCloser, error)
+}
+
+// AtomicWriteCloser is returned by [AtomicWriter]. Close publishes the data;
+// Abort discards it and leaves any existing file untouched.
+type AtomicWriteCloser interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// TierManager supports reading and changing the storage class / tier of a
+// file (e.g. "STANDARD", "STANDARD_IA", "GLACIER"). Tier names are backend
+// specific. Backends without a tier concept return ErrNotSupported.
+type TierManager interface {
+	GetTier(ctx context.Context, path string) (string, error)
+	SetTier(ctx context.Context, path string, tier string) error
+}
+
+// Versioner supports per-file version history, for undelete and rollback.
+// ListVersions returns the previous versions of a file, newest first; the
+// current content is not included, and versions remain listed after the
+// file is removed. Version IDs are backend specific and opaque. Backends
+// where versioning is not enabled return ErrNotSupported.
+type Versioner interface {
+	ListVersions(ctx context.Context, path string) ([]VersionInfo, error)
+	OpenVersion(ctx context.Context, path, versionID string) (ReadSeekCloser, error)
+	// RestoreVersion makes the given version the current content of path.
+	RestoreVersion(ctx context.Context, path, versionID string) error
+	DeleteVersion(ctx context.Context, path, versionID string) error
+}
+
+// MultipartUploader supports uploading a large file in parts that can be
+// sent in parallel and retried one by one, like S3 multipart uploads.
+// InitiateUpload starts an upload to path and returns its ID. UploadPart
+// stores part partNumber, counting from 1; uploading a part again replaces
+// it. CompleteUpload publishes the given parts, in ascending order of
+// their numbers, as the content of path, and discards parts not listed;
+// parts whose ETag is set must match the uploaded part, or it fails with
+// ErrPreconditionFailed. AbortUpload discards the upload. Unknown upload
+// IDs return ErrNotFound.
+type MultipartUploader interface {
+	InitiateUpload(ctx context.Context, path string) (string, error)
+	UploadPart(ctx context.Context, path, uploadID string, partNumber int, r io.Reader) (PartInfo, error)
+	CompleteUpload(ctx context.Context, path, uploadID string, parts []PartInfo) error
+	AbortUpload(ctx context.Context, path, uploadID string) error
+}
+
+// HealthChecker supports checking that the backend is reachable and
+// usable, e.g. as a readiness probe. Ping returns nil if the engine can
+// serve requests; what it checks is backend specific, typically the root
+// and, where cheap, that it is writable.
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+}
+
+// UsageReporter reports the space used below a path, and the capacity of
+// the backend where it is known. For a file, it reports the file alone.
+type UsageReporter interface {
+	Usage(ctx context.Context, path string) (*UsageInfo, error)
+}
+
+// Watcher supports subscribing to changes below a path, so applications
+// can react to uploads without scanning. Watch returns a channel of
+// events for path, or for everything below it if recursive is set. The
+// channel is closed when ctx is canceled. See [WatchEvent].
+type Watcher interface {
+	Watch(ctx context.Context, path string, recursive bool) (<-chan WatchEvent, error)
+}
+
+// Walker supports walking a tree natively, e.g. with recursive listings
+// or concurrent directory reads, and is used by [Walk] and [WalkParallel].
+// Walk must call fn as [Walk] does, for root first, and for every
+// directory before its entries, but may report entries in any order.
+// fn is never called concurrently. filepath.SkipDir and
+// filepath.SkipAll returned by fn may be returned by Walk.
+type Walker interface {
+	Walk(ctx context.Context, root string, fn WalkFunc) error
+}
+
+// PartInfo describes a part of a multipart upload.
+type PartInfo struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+}
+
+// VersionInfo describes a previous version of a file.
+type VersionInfo struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// UsageInfo describes the space used below a path.
+type UsageInfo struct {
+	Files int64 `json:"files"` // Files below the path
+	Dirs  int64 `json:"dirs"`  // Directories below the path, not counting it
+	Bytes int64 `json:"bytes"` // Logical size of the files
+
+	// Physical is the space the files take in the backend: after
+	// deduplication and compression for the sharded driver, in allocated
+	// blocks for local files, and Bytes where the backend does not tell.
+	Physical int64 `json:"physical"`
+
+	// Quota is the capacity of the backend, if it reports one.
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Quota describes the capacity of a backend. Values the backend does not
+// report are -1.
+type Quota struct {
+	Total int64 `json:"total"`
+	Used  int64 `json:"used"`
+	Free  int64 `json:"free"`
+}
@@ -0,0 +1,186 @@
+package sbox
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Ranged returns a RangeReader for engine: engine itself if it supports
+// [RangeReader], and otherwise one that opens the file and seeks to the
+// offset.
+func Ranged(engine StorageEngine) RangeReader {
+	if rr, ok := engine.(RangeReader); ok && Supports(engine, CapRangeRead) {
+		return rr
+	}
+	return rangeFallback{engine}
+}
+
+type rangeFallback struct {
+	engine StorageEngine
+}
+
+func (f rangeFallback) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("sbox: negative range offset %d: %w", offset, ErrInvalid)
+	}
+	r, err := f.engine.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return r, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(r, length), Closer: r}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewHash returns a hash for algorithm: "md5", "sha1", "sha256" or
+// "sha512". Other algorithms fail with ErrNotSupported.
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "md5":
+		return md5.New(), nil //nolint:gosec // md5 intentionally supported
+	case "sha1":
+		return sha1.New(), nil //nolint:gosec // sha1 intentionally supported
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("sbox: unsupported hash algorithm %q: %w", algorithm, ErrNotSupported)
+}
+
+// Hashed returns a Hasher for engine. Hashes are computed by engine if it
+// supports [Hasher] and the algorithm, and otherwise by reading the file.
+// The fallback supports "md5", "sha1", "sha256" and "sha512" and returns
+// lowercase hex digests, like the built-in drivers.
+func Hashed(engine StorageEngine) Hasher {
+	return hashFallback{engine}
+}
+
+type hashFallback struct {
+	engine StorageEngine
+}
+
+func (f hashFallback) Hash(ctx context.Context, path string, algorithm string) (string, error) {
+	if h, ok := f.engine.(Hasher); ok && Supports(f.engine, CapHash) {
+		sum, err := h.Hash(ctx, path, algorithm)
+		if !errors.Is(err, ErrNotSupported) {
+			return sum, err
+		}
+	}
+
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	r, err := f.engine.Open(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Copied returns a Copier for engine. Copies are made by engine if it
+// supports [Copier], and otherwise by [CopyTree], which reads and writes
+// every file of a directory. Native copies that fail with ErrNotSupported
+// fall back to CopyTree as well.
+func Copied(engine StorageEngine) Copier {
+	return copyFallback{engine}
+}
+
+type copyFallback struct {
+	engine StorageEngine
+}
+
+func (f copyFallback) Copy(ctx context.Context, src, dst string) error {
+	if c, ok := f.engine.(Copier); ok && Supports(f.engine, CapCopy) {
+		err := c.Copy(ctx, src, dst)
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return CopyTree(ctx, f.engine, src, f.engine, dst, CopyTreeOptions{})
+}
+
+// Ping checks that engine is healthy with [HealthChecker] if it supports
+// it. Wrappers without their own check are unwrapped through their
+// Inner method, as middleware provide it; engines without a check are
+// healthy if their root can be stat'ed.
+func Ping(ctx context.Context, engine StorageEngine) error {
+	for {
+		if h, ok := engine.(HealthChecker); ok && Supports(engine, CapHealth) {
+			return h.Ping(ctx)
+		}
+		w, ok := engine.(interface{ Inner() StorageEngine })
+		if !ok {
+			break
+		}
+		engine = w.Inner()
+	}
+	_, err := engine.Stat(ctx, "")
+	return err
+}
+
+// Usage reports the space used below path with [UsageReporter] if engine
+// supports it, and otherwise by walking the tree and adding up the sizes
+// of the files, without a quota.
+func Usage(ctx context.Context, engine StorageEngine, path string) (*UsageInfo, error) {
+	if u, ok := engine.(UsageReporter); ok && Supports(engine, CapUsage) {
+		info, err := u.Usage(ctx, path)
+		if !errors.Is(err, ErrNotSupported) {
+			return info, err
+		}
+	}
+	root, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	usage := &UsageInfo{}
+	err = Walk(ctx, engine, root, func(p string, info *EntryInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir:
+			if p != root {
+				usage.Dirs++
+			}
+		default:
+			usage.Files++
+			usage.Bytes += info.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	usage.Physical = usage.Bytes
+	return usage, nil
+}
+
+// Compile-time interface checks.
+var (
+	_ RangeReader = rangeFallback{}
+	_ Hasher      = hashFallback{}
+	_ Copier      = copyFallback{}
+)
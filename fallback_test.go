@@ -0,0 +1,103 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/retry"
+)
+
+// plain hides every extension of an engine.
+type plain struct {
+	sbox.StorageEngine
+}
+
+func TestFallbacks(t *testing.T) {
+	ctx := context.Background()
+	engine := plain{memory.New()}
+	routerWrite(t, engine, "dir/a.txt", "hello world")
+
+	rc, err := sbox.Ranged(engine).GetRange(ctx, "dir/a.txt", 6, 3)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "wor" {
+		t.Errorf("GetRange = %q", data)
+	}
+	rc, err = sbox.Ranged(engine).GetRange(ctx, "dir/a.txt", 6, -1)
+	if err != nil {
+		t.Fatalf("GetRange to EOF: %v", err)
+	}
+	data, _ = io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "world" {
+		t.Errorf("GetRange to EOF = %q", data)
+	}
+
+	sum, err := sbox.Hashed(engine).Hash(ctx, "dir/a.txt", "sha256")
+	if err != nil || sum != "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Errorf("Hash = %q, %v", sum, err)
+	}
+	if _, err := sbox.Hashed(engine).Hash(ctx, "dir/a.txt", "crc7"); err == nil {
+		t.Error("Hash with unknown algorithm succeeded")
+	}
+
+	if err := sbox.Copied(engine).Copy(ctx, "dir", "copy"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if got := routerRead(t, engine, "copy/a.txt"); got != "hello world" {
+		t.Errorf("copied = %q", got)
+	}
+
+}
+
+// unhealthy fails its health check.
+type unhealthy struct {
+	sbox.StorageEngine
+}
+
+func (unhealthy) Ping(context.Context) error {
+	return sbox.ErrClosed
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	if err := sbox.Ping(ctx, memory.New()); err != nil {
+		t.Errorf("Ping memory: %v", err)
+	}
+	if err := sbox.Ping(ctx, plain{memory.New()}); err != nil {
+		t.Errorf("Ping without HealthChecker: %v", err)
+	}
+	if !sbox.Supports(memory.New(), sbox.CapHealth) {
+		t.Error("memory engine does not report CapHealth")
+	}
+
+	// Middleware without a health check of their own are unwrapped.
+	if err := sbox.Ping(ctx, retry.New(unhealthy{memory.New()})); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Ping through middleware = %v, want %v", err, sbox.ErrClosed)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	engine := plain{memory.New()}
+	routerWrite(t, engine, "dir/a.txt", "hello")
+	routerWrite(t, engine, "dir/sub/b.txt", "world!")
+
+	u, err := sbox.Usage(ctx, engine, "dir")
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if u.Files != 2 || u.Dirs != 1 || u.Bytes != 11 || u.Physical != 11 || u.Quota != nil {
+		t.Errorf("Usage = %+v", u)
+	}
+	if _, err := sbox.Usage(ctx, engine, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Usage(missing) = %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package sbox
+
+import (
+	"context"
+	"strings"
+)
+
+// FileOption sets an attribute of a file being opened or written, see
+// [CreateWith] and [OpenWith].
+type FileOption func(*FileOptions)
+
+// FileOptions are the attributes set by FileOption, as engines see them
+// through [FileOptionsFromContext]. Engines use those they can honor,
+// typically object stores and remote drivers that send them with the
+// upload, and ignore the others.
+type FileOptions struct {
+	ContentType  string // MIME type of the content, e.g. "text/plain"
+	CacheControl string // HTTP Cache-Control value served with the file
+	ExpectedSize int64  // Length of the content to be written; -1 if unknown
+
+	// DetectContentType asks engines storing content types to detect it
+	// with DetectContentType when ContentType is not set.
+	DetectContentType bool
+
+	// Checksum of the content to be written, as a hex digest computed
+	// with ChecksumAlgorithm, e.g. "sha256". Engines that verify it fail
+	// the write with ErrChecksumMismatch and discard the content.
+	ChecksumAlgorithm string
+	Checksum          string
+
+	// Verify asks for the stored content to be checked against the hash
+	// of the data written, computed while streaming it.
+	Verify bool
+}
+
+// WithContentType sets the MIME type of the file.
+func WithContentType(contentType string) FileOption {
+	return func(o *FileOptions) { o.ContentType = contentType }
+}
+
+// WithDetectedContentType makes engines that store content types detect
+// the type of the file from its name and first bytes, unless
+// WithContentType sets it.
+func WithDetectedContentType() FileOption {
+	return func(o *FileOptions) { o.DetectContentType = true }
+}
+
+// WithCacheControl sets the Cache-Control value served with the file.
+func WithCacheControl(value string) FileOption {
+	return func(o *FileOptions) { o.CacheControl = value }
+}
+
+// WithExpectedSize declares the length of the content to be written, so
+// engines can upload it in one request or preallocate space.
+func WithExpectedSize(n int64) FileOption {
+	return func(o *FileOptions) { o.ExpectedSize = n }
+}
+
+// WithChecksum declares the hex digest of the content to be written,
+// computed with algorithm, e.g. "sha256" or "md5".
+func WithChecksum(algorithm, digest string) FileOption {
+	return func(o *FileOptions) {
+		o.ChecksumAlgorithm = strings.ToLower(algorithm)
+		o.Checksum = strings.ToLower(digest)
+	}
+}
+
+// WithVerification asks for the content to be checked once written: the
+// hash of the data, computed while it is written, is compared with the
+// hash the backend reports, and with the checksum of WithChecksum if set.
+// Writes that do not match fail with ErrChecksumMismatch and the file is
+// removed. See the verify middleware for engines that do not check
+// writes themselves.
+func WithVerification() FileOption {
+	return func(o *FileOptions) { o.Verify = true }
+}
+
+type fileOptionsKey struct{}
+
+// WithFileOptions returns a copy of ctx carrying opts, on top of any
+// options ctx already carries, for the files opened or written with it.
+func WithFileOptions(ctx context.Context, opts ...FileOption) context.Context {
+	if len(opts) == 0 {
+		return ctx
+	}
+	o := FileOptionsFromContext(ctx)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, fileOptionsKey{}, o)
+}
+
+// FileOptionsFromContext returns the options attached to ctx by
+// [WithFileOptions], [CreateWith] or [OpenWith]. It is meant for engine
+// implementations.
+func FileOptionsFromContext(ctx context.Context) FileOptions {
+	if o, ok := ctx.Value(fileOptionsKey{}).(FileOptions); ok {
+		return o
+	}
+	return FileOptions{ExpectedSize: -1}
+}
+
+// CreateWith is engine.Create with opts passed to the engine in the
+// context.
+func CreateWith(ctx context.Context, engine StorageEngine, path string, opts ...FileOption) (WriteCloser, error) {
+	return engine.Create(WithFileOptions(ctx, opts...), path)
+}
+
+// OpenWith is engine.Open with opts passed to the engine in the context.
+func OpenWith(ctx context.Context, engine StorageEngine, path string, opts ...FileOption) (ReadSeekCloser, error) {
+	return engine.Open(WithFileOptions(ctx, opts...), path)
+}
@@ -0,0 +1,58 @@
+package sbox_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestFileOptions(t *testing.T) {
+	ctx := context.Background()
+	if o := sbox.FileOptionsFromContext(ctx); o != (sbox.FileOptions{ExpectedSize: -1}) {
+		t.Errorf("options of a bare context = %+v", o)
+	}
+
+	ctx = sbox.WithFileOptions(ctx, sbox.WithContentType("text/plain"), sbox.WithExpectedSize(4))
+	ctx = sbox.WithFileOptions(ctx, sbox.WithCacheControl("no-cache"), sbox.WithChecksum("SHA256", "ABCD"))
+	want := sbox.FileOptions{
+		ContentType:       "text/plain",
+		CacheControl:      "no-cache",
+		ExpectedSize:      4,
+		ChecksumAlgorithm: "sha256",
+		Checksum:          "abcd",
+	}
+	if o := sbox.FileOptionsFromContext(ctx); o != want {
+		t.Errorf("options = %+v, want %+v", o, want)
+	}
+}
+
+func TestCreateWith(t *testing.T) {
+	ctx := context.Background()
+	var got sbox.FileOptions
+	engine := sbox.WithHooks(memory.New(), sbox.Hooks{
+		BeforeWrite: func(ctx context.Context, _ string) error {
+			got = sbox.FileOptionsFromContext(ctx)
+			return nil
+		},
+	})
+	w, err := sbox.CreateWith(ctx, engine, "a.txt", sbox.WithContentType("text/plain"))
+	if err != nil {
+		t.Fatalf("CreateWith: %v", err)
+	}
+	_, _ = io.WriteString(w, "data")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got.ContentType != "text/plain" || got.ExpectedSize != -1 {
+		t.Errorf("engine saw %+v", got)
+	}
+
+	r, err := sbox.OpenWith(ctx, engine, "a.txt", sbox.WithCacheControl("no-store"))
+	if err != nil {
+		t.Fatalf("OpenWith: %v", err)
+	}
+	_ = r.Close()
+}
@@ -0,0 +1,278 @@
+// Package fuse mounts any sbox.StorageEngine as a local filesystem, so
+// programs that only know files can use sharded or rclone-backed storage.
+//
+// FS implements the filesystem semantics on top of the engine, independent
+// of a FUSE library: attribute caching, POSIX directory operations and
+// file handles. Reads go to the engine's RangeReader, keeping one stream
+// open per handle for sequential reads. Writes go to a spool file on local
+// disk, which is uploaded atomically with PutAtomic when the file is
+// flushed (on close or fsync), because engines cannot write at arbitrary
+// offsets.
+//
+// Building with -tags fuse adds [Mount], which serves an FS through
+// go-fuse (this also requires github.com/hanwen/go-fuse/v2 in your
+// module):
+//
+//	server, err := fuse.Mount(engine, "/mnt/data", fuse.WithAttrTimeout(5*time.Second))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	server.Wait()
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// DefaultAttrTimeout is how long attributes are cached by default.
+const DefaultAttrTimeout = time.Second
+
+// ErrNotEmpty is returned by Rmdir for a directory with entries.
+var ErrNotEmpty = errors.New("sbox/fuse: directory not empty")
+
+// errReadOnly is returned for changes to a read-only FS.
+var errReadOnly = fmt.Errorf("sbox/fuse: read-only filesystem: %w", sbox.ErrPermission)
+
+// FS is a filesystem view of an engine. Paths are engine paths; the root
+// is "".
+type FS struct {
+	engine     sbox.StorageEngine
+	attrTTL    time.Duration
+	readOnly   bool
+	spoolDir   string
+	allowOther bool
+
+	mu    sync.Mutex
+	attrs map[string]cachedAttr
+}
+
+// cachedAttr is an entry of the attribute cache.
+type cachedAttr struct {
+	info    *sbox.EntryInfo
+	expires time.Time
+}
+
+// New returns an FS for engine.
+func New(engine sbox.StorageEngine, opts ...Option) *FS {
+	f := &FS{engine: engine, attrTTL: DefaultAttrTimeout, attrs: make(map[string]cachedAttr)}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Engine returns the engine the FS is a view of.
+func (f *FS) Engine() sbox.StorageEngine {
+	return f.engine
+}
+
+// AttrTimeout returns how long attributes may be cached, also by the
+// kernel.
+func (f *FS) AttrTimeout() time.Duration {
+	return f.attrTTL
+}
+
+// clean converts a filesystem path to an engine path.
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// cache stores info in the attribute cache.
+func (f *FS) cache(info *sbox.EntryInfo) {
+	if f.attrTTL <= 0 {
+		return
+	}
+	f.mu.Lock()
+	f.attrs[clean(info.Path)] = cachedAttr{info: info, expires: time.Now().Add(f.attrTTL)}
+	f.mu.Unlock()
+}
+
+// Invalidate drops the cached attributes of p, of everything below it and
+// of its parent directory. Call it after changing the engine other than
+// through the FS.
+func (f *FS) Invalidate(p string) {
+	p = clean(p)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.attrs, p)
+	if p != "" {
+		delete(f.attrs, clean(path.Dir(p)))
+	}
+	for k := range f.attrs {
+		if p == "" || strings.HasPrefix(k, p+"/") {
+			delete(f.attrs, k)
+		}
+	}
+}
+
+// Stat returns the attributes of p, from the cache if they are fresh.
+func (f *FS) Stat(ctx context.Context, p string) (*sbox.EntryInfo, error) {
+	p = clean(p)
+	f.mu.Lock()
+	c, ok := f.attrs[p]
+	f.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		info := *c.info
+		info.Metadata = maps.Clone(c.info.Metadata)
+		return &info, nil
+	}
+	info, err := f.engine.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	info.Path = p
+	f.cache(info)
+	return info, nil
+}
+
+// ReadDir lists the directory p and caches the attributes of its entries,
+// which the kernel usually looks up next.
+func (f *FS) ReadDir(ctx context.Context, p string) ([]*sbox.EntryInfo, error) {
+	p = clean(p)
+	entries, err := f.engine.ReadDir(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Path == "" {
+			e.Path = path.Join(p, e.Name)
+		}
+		f.cache(e)
+	}
+	return entries, nil
+}
+
+// Mkdir creates the directory p, which must not exist.
+func (f *FS) Mkdir(ctx context.Context, p string) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	p = clean(p)
+	if _, err := f.engine.Stat(ctx, p); err == nil {
+		return fmt.Errorf("sbox/fuse: mkdir %s: %w", p, sbox.ErrExist)
+	}
+	defer f.Invalidate(p)
+	return f.engine.MkdirAll(ctx, p)
+}
+
+// Unlink removes the file p.
+func (f *FS) Unlink(ctx context.Context, p string) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	p = clean(p)
+	info, err := f.engine.Stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return fmt.Errorf("sbox/fuse: unlink %s: %w", p, sbox.ErrIsDir)
+	}
+	defer f.Invalidate(p)
+	return f.engine.Remove(ctx, p)
+}
+
+// Rmdir removes the directory p, which must be empty.
+func (f *FS) Rmdir(ctx context.Context, p string) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	p = clean(p)
+	entries, err := f.engine.ReadDir(ctx, p)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("sbox/fuse: rmdir %s: %w", p, ErrNotEmpty)
+	}
+	defer f.Invalidate(p)
+	return f.engine.Remove(ctx, p)
+}
+
+// Rename renames oldPath to newPath, replacing a file at newPath.
+func (f *FS) Rename(ctx context.Context, oldPath, newPath string) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	oldPath, newPath = clean(oldPath), clean(newPath)
+	defer f.Invalidate(oldPath)
+	defer f.Invalidate(newPath)
+	return f.engine.Rename(ctx, oldPath, newPath)
+}
+
+// Truncate changes the size of the file p.
+func (f *FS) Truncate(ctx context.Context, p string, size int64) error {
+	h, err := f.Open(ctx, p, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	if err := h.Truncate(ctx, size); err != nil {
+		_ = h.Release(ctx)
+		return err
+	}
+	return h.Release(ctx)
+}
+
+// SetModTime changes the modification time of p if the engine supports
+// it, and otherwise does nothing, so tools like touch keep working.
+func (f *FS) SetModTime(ctx context.Context, p string, t time.Time) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	mts, ok := f.engine.(sbox.ModTimeSetter)
+	if !ok || !sbox.Supports(f.engine, sbox.CapSetModTime) {
+		return nil
+	}
+	p = clean(p)
+	defer f.Invalidate(p)
+	return mts.SetModTime(ctx, p, t)
+}
+
+// Open opens the file p with the os.O_* flags in flag.
+func (f *FS) Open(ctx context.Context, p string, flag int) (*Handle, error) {
+	p = clean(p)
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if writable && f.readOnly {
+		return nil, errReadOnly
+	}
+	info, err := f.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, fmt.Errorf("sbox/fuse: open %s: %w", p, sbox.ErrIsDir)
+	}
+	h := &Handle{fs: f, path: p, info: info, writable: writable}
+	if writable && flag&os.O_TRUNC != 0 {
+		h.loaded, h.dirty = true, true
+	}
+	return h, nil
+}
+
+// Create creates or truncates the file p and opens it for writing. The
+// empty file is stored right away, so that it is visible to others.
+func (f *FS) Create(ctx context.Context, p string) (*Handle, error) {
+	if f.readOnly {
+		return nil, errReadOnly
+	}
+	p = clean(p)
+	if err := sbox.PutAtomic(ctx, f.engine, p, strings.NewReader("")); err != nil {
+		return nil, err
+	}
+	f.Invalidate(p)
+	info, err := f.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &Handle{fs: f, path: p, info: info, writable: true, loaded: true}, nil
+}
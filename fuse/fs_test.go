@@ -0,0 +1,228 @@
+package fuse_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/fuse"
+	"github.com/nuln/sbox/memory"
+)
+
+func get(t *testing.T, engine sbox.StorageEngine, p string) string {
+	t.Helper()
+	r, err := sbox.Ranged(engine).GetRange(context.Background(), p, 0, -1)
+	if err != nil {
+		t.Fatalf("GetRange %s: %v", p, err)
+	}
+	defer func() { _ = r.Close() }()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func TestFS_Write(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	fsys := fuse.New(engine, fuse.WithSpoolDir(t.TempDir()))
+
+	h, err := fsys.Create(ctx, "/dir/a.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got := get(t, engine, "dir/a.txt"); got != "" {
+		t.Fatalf("created file = %q, want empty", got)
+	}
+	if _, err := h.WriteAt(ctx, []byte("hello world"), 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if _, err := h.WriteAt(ctx, []byte("WORLD!"), 6); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if size := h.Stat().Size; size != 12 {
+		t.Fatalf("handle size = %d, want 12", size)
+	}
+	if err := h.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if got := get(t, engine, "dir/a.txt"); got != "hello WORLD!" {
+		t.Fatalf("content = %q", got)
+	}
+	info, err := fsys.Stat(ctx, "dir/a.txt")
+	if err != nil || info.Size != 12 {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	// Rewrite part of the file and shrink it.
+	h, err = fsys.Open(ctx, "dir/a.txt", os.O_RDWR)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := h.WriteAt(ctx, []byte("J"), 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if err := h.Truncate(ctx, 5); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if err := h.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if got := get(t, engine, "dir/a.txt"); got != "Jello" {
+		t.Fatalf("content = %q", got)
+	}
+
+	if err := fsys.Truncate(ctx, "dir/a.txt", 2); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := get(t, engine, "dir/a.txt"); got != "Je" {
+		t.Fatalf("content = %q", got)
+	}
+}
+
+func TestFS_Read(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	data := strings.Repeat("0123456789", 1000)
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader(data)); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	fsys := fuse.New(engine)
+	h, err := fsys.Open(ctx, "f", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = h.Release(ctx) }()
+
+	buf := make([]byte, 4096)
+	var got []byte
+	for off := int64(0); ; {
+		n, err := h.ReadAt(ctx, buf, off)
+		got = append(got, buf[:n]...)
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadAt %d: %v", off, err)
+		}
+	}
+	if string(got) != data {
+		t.Fatalf("sequential read differs")
+	}
+
+	n, err := h.ReadAt(ctx, buf[:5], 1003)
+	if err != nil || string(buf[:n]) != "34567" {
+		t.Fatalf("ReadAt = %q, %v", buf[:n], err)
+	}
+	if _, err := h.WriteAt(ctx, []byte("x"), 0); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("WriteAt on read-only handle: %v", err)
+	}
+}
+
+func TestFS_Directories(t *testing.T) {
+	ctx := context.Background()
+	fsys := fuse.New(memory.New())
+
+	if err := fsys.Mkdir(ctx, "d"); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := fsys.Mkdir(ctx, "d"); !errors.Is(err, sbox.ErrExist) {
+		t.Fatalf("Mkdir existing: %v", err)
+	}
+	h, err := fsys.Create(ctx, "d/f")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := h.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := fsys.Rmdir(ctx, "d"); !errors.Is(err, fuse.ErrNotEmpty) {
+		t.Fatalf("Rmdir non-empty: %v", err)
+	}
+	if err := fsys.Unlink(ctx, "d"); !errors.Is(err, sbox.ErrIsDir) {
+		t.Fatalf("Unlink directory: %v", err)
+	}
+	if _, err := fsys.Open(ctx, "d", os.O_RDONLY); !errors.Is(err, sbox.ErrIsDir) {
+		t.Fatalf("Open directory: %v", err)
+	}
+	if err := fsys.Rename(ctx, "d/f", "g"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	entries, err := fsys.ReadDir(ctx, "")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir = %d entries, %v", len(entries), err)
+	}
+	if err := fsys.Rmdir(ctx, "d"); err != nil {
+		t.Fatalf("Rmdir: %v", err)
+	}
+	if err := fsys.Unlink(ctx, "g"); err != nil {
+		t.Fatalf("Unlink: %v", err)
+	}
+	if _, err := fsys.Stat(ctx, "g"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Fatalf("Stat removed file: %v", err)
+	}
+}
+
+func TestFS_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader("x")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	fsys := fuse.New(engine, fuse.WithReadOnly())
+
+	if _, err := fsys.Create(ctx, "g"); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := fsys.Open(ctx, "f", os.O_WRONLY); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Open for writing: %v", err)
+	}
+	if err := fsys.Unlink(ctx, "f"); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Unlink: %v", err)
+	}
+	if err := fsys.Mkdir(ctx, "d"); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Mkdir: %v", err)
+	}
+}
+
+func TestFS_AttrCache(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader("x")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	fsys := fuse.New(engine, fuse.WithAttrTimeout(time.Hour))
+	if _, err := fsys.Stat(ctx, "f"); err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	// Changes made directly to the engine stay hidden until invalidated.
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader("xyz")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if info, _ := fsys.Stat(ctx, "f"); info.Size != 1 {
+		t.Fatalf("cached size = %d, want 1", info.Size)
+	}
+	fsys.Invalidate("f")
+	if info, _ := fsys.Stat(ctx, "f"); info.Size != 3 {
+		t.Fatalf("size after Invalidate = %d, want 3", info.Size)
+	}
+
+	uncached := fuse.New(engine, fuse.WithAttrTimeout(0))
+	if _, err := uncached.Stat(ctx, "f"); err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader("")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if info, _ := uncached.Stat(ctx, "f"); info.Size != 0 {
+		t.Fatalf("uncached size = %d, want 0", info.Size)
+	}
+}
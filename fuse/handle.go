@@ -0,0 +1,190 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// Handle is an open file of an FS. It is safe for concurrent use, as the
+// kernel may issue several reads on a handle at once.
+type Handle struct {
+	fs       *FS
+	path     string
+	info     *sbox.EntryInfo // Attributes when opened
+	writable bool
+
+	mu sync.Mutex
+
+	// Reading without a spool: an open stream of the file and its offset.
+	rc    io.ReadCloser
+	rcOff int64
+
+	// Writing: the content of the file, loaded on first use unless the
+	// file was truncated when opened.
+	spool   *os.File
+	size    int64
+	loaded  bool
+	dirty   bool
+	modTime time.Time
+}
+
+// Path returns the engine path of the file.
+func (h *Handle) Path() string {
+	return h.path
+}
+
+// Stat returns the attributes of the file, including changes not flushed
+// yet.
+func (h *Handle) Stat() *sbox.EntryInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info := *h.info
+	if h.loaded {
+		info.Size = h.size
+	}
+	if h.dirty && !h.modTime.IsZero() {
+		info.ModTime = h.modTime
+	}
+	return &info
+}
+
+// load makes sure the spool holds the content of the file.
+func (h *Handle) load(ctx context.Context) error {
+	if h.spool == nil {
+		spool, err := os.CreateTemp(h.fs.spoolDir, "sbox-fuse-*")
+		if err != nil {
+			return err
+		}
+		// The spool is only reachable through the handle.
+		_ = os.Remove(spool.Name())
+		h.spool = spool
+	}
+	if h.loaded {
+		return nil
+	}
+	r, err := sbox.Ranged(h.fs.engine).GetRange(ctx, h.path, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	n, err := io.Copy(h.spool, r)
+	if err != nil {
+		return err
+	}
+	h.size, h.loaded = n, true
+	return nil
+}
+
+// ReadAt reads len(p) bytes at off, like io.ReaderAt: fewer bytes are only
+// returned at the end of the file, together with io.EOF.
+func (h *Handle) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.writable {
+		if err := h.load(ctx); err != nil {
+			return 0, err
+		}
+		return h.spool.ReadAt(p, off)
+	}
+
+	// Sequential reads continue the open stream; others start a new one.
+	if h.rc != nil && h.rcOff != off {
+		_ = h.rc.Close()
+		h.rc = nil
+	}
+	if h.rc == nil {
+		rc, err := sbox.Ranged(h.fs.engine).GetRange(ctx, h.path, off, -1)
+		if err != nil {
+			return 0, err
+		}
+		h.rc, h.rcOff = rc, off
+	}
+	n, err := io.ReadFull(h.rc, p)
+	h.rcOff += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		// Reset the stream at the end of the file or after an error.
+		_ = h.rc.Close()
+		h.rc = nil
+	}
+	return n, err
+}
+
+// WriteAt writes p at off, growing the file as needed.
+func (h *Handle) WriteAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if !h.writable {
+		return 0, sbox.ErrPermission
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.load(ctx); err != nil {
+		return 0, err
+	}
+	n, err := h.spool.WriteAt(p, off)
+	if end := off + int64(n); end > h.size {
+		h.size = end
+	}
+	h.dirty, h.modTime = true, time.Now()
+	return n, err
+}
+
+// Truncate changes the size of the file.
+func (h *Handle) Truncate(ctx context.Context, size int64) error {
+	if !h.writable {
+		return sbox.ErrPermission
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.load(ctx); err != nil {
+		return err
+	}
+	if err := h.spool.Truncate(size); err != nil {
+		return err
+	}
+	h.size, h.dirty, h.modTime = size, true, time.Now()
+	return nil
+}
+
+// Flush uploads the changes, if any. The kernel calls it when a file
+// descriptor of the handle is closed.
+func (h *Handle) Flush(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil
+	}
+	if err := h.load(ctx); err != nil {
+		return err
+	}
+	if err := sbox.PutAtomic(ctx, h.fs.engine, h.path, io.NewSectionReader(h.spool, 0, h.size)); err != nil {
+		return err
+	}
+	h.dirty = false
+	h.fs.Invalidate(h.path)
+	return nil
+}
+
+// Release flushes the handle and frees its resources. The handle cannot
+// be used afterwards.
+func (h *Handle) Release(ctx context.Context) error {
+	err := h.Flush(ctx)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rc != nil {
+		_ = h.rc.Close()
+		h.rc = nil
+	}
+	if h.spool != nil {
+		err = errors.Join(err, h.spool.Close())
+		h.spool = nil
+	}
+	return err
+}
@@ -0,0 +1,281 @@
+//go:build fuse
+
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/nuln/sbox"
+)
+
+// Mount mounts engine at mountpoint, an existing directory. Call Unmount
+// on the returned server to unmount, and Wait to block until then.
+func Mount(engine sbox.StorageEngine, mountpoint string, opts ...Option) (*gofuse.Server, error) {
+	f := New(engine, opts...)
+	ttl := f.attrTTL
+	return fs.Mount(mountpoint, &node{fsys: f}, &fs.Options{
+		EntryTimeout: &ttl,
+		AttrTimeout:  &ttl,
+		UID:          uint32(os.Getuid()),
+		GID:          uint32(os.Getgid()),
+		MountOptions: gofuse.MountOptions{
+			FsName:     "sbox",
+			Name:       "sbox",
+			AllowOther: f.allowOther,
+		},
+	})
+}
+
+// errno converts an error to the errno returned to the kernel.
+func errno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errReadOnly):
+		return syscall.EROFS
+	case errors.Is(err, sbox.ErrNotFound):
+		return syscall.ENOENT
+	case errors.Is(err, sbox.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, sbox.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, sbox.ErrIsDir):
+		return syscall.EISDIR
+	case errors.Is(err, sbox.ErrNotDir):
+		return syscall.ENOTDIR
+	case errors.Is(err, ErrNotEmpty):
+		return syscall.ENOTEMPTY
+	case errors.Is(err, sbox.ErrInvalid):
+		return syscall.EINVAL
+	case errors.Is(err, sbox.ErrNotSupported):
+		return syscall.ENOTSUP
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	}
+	return syscall.EIO
+}
+
+// fillAttr sets the attributes the kernel sees for info.
+func fillAttr(f *FS, info *sbox.EntryInfo, out *gofuse.Attr) {
+	perm := uint32(info.Mode.Perm())
+	if info.IsDir {
+		if perm == 0 {
+			perm = 0755
+		}
+		out.Mode = syscall.S_IFDIR | perm
+		out.Nlink = 2
+	} else {
+		if perm == 0 {
+			perm = 0644
+		}
+		out.Mode = syscall.S_IFREG | perm
+		out.Nlink = 1
+		out.Size = uint64(info.Size)
+		out.Blocks = (out.Size + 511) / 512
+	}
+	if f.readOnly {
+		out.Mode &^= 0222
+	}
+	mt := info.ModTime
+	out.SetTimes(&mt, &mt, &mt)
+	out.Blksize = 4096
+}
+
+// node is a file or directory of the mount.
+type node struct {
+	fs.Inode
+	fsys *FS
+}
+
+func (n *node) path() string {
+	return n.Path(nil)
+}
+
+func (n *node) child(ctx context.Context, info *sbox.EntryInfo, out *gofuse.EntryOut) *fs.Inode {
+	fillAttr(n.fsys, info, &out.Attr)
+	out.SetEntryTimeout(n.fsys.attrTTL)
+	out.SetAttrTimeout(n.fsys.attrTTL)
+	mode := uint32(syscall.S_IFREG)
+	if info.IsDir {
+		mode = syscall.S_IFDIR
+	}
+	return n.NewInode(ctx, &node{fsys: n.fsys}, fs.StableAttr{Mode: mode})
+}
+
+func (n *node) Getattr(ctx context.Context, fh fs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	var info *sbox.EntryInfo
+	if h, ok := fh.(*file); ok {
+		info = h.h.Stat()
+	} else {
+		var err error
+		if info, err = n.fsys.Stat(ctx, n.path()); err != nil {
+			return errno(err)
+		}
+	}
+	fillAttr(n.fsys, info, &out.Attr)
+	out.SetTimeout(n.fsys.attrTTL)
+	return 0
+}
+
+func (n *node) Setattr(ctx context.Context, fh fs.FileHandle, in *gofuse.SetAttrIn, out *gofuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		var err error
+		if h, ok := fh.(*file); ok {
+			err = h.h.Truncate(ctx, int64(size))
+		} else {
+			err = n.fsys.Truncate(ctx, n.path(), int64(size))
+		}
+		if err != nil {
+			return errno(err)
+		}
+	}
+	if mtime, ok := in.GetMTime(); ok {
+		if h, ok := fh.(*file); ok {
+			// Upload pending writes first, so they do not overwrite the
+			// new time.
+			if err := h.h.Flush(ctx); err != nil {
+				return errno(err)
+			}
+		}
+		if err := n.fsys.SetModTime(ctx, n.path(), mtime); err != nil {
+			return errno(err)
+		}
+	}
+	return n.Getattr(ctx, fh, out)
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := n.fsys.Stat(ctx, path.Join(n.path(), name))
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.child(ctx, info, out), 0
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries, err := n.fsys.ReadDir(ctx, n.path())
+	if err != nil {
+		return nil, errno(err)
+	}
+	list := make([]gofuse.DirEntry, len(entries))
+	for i, e := range entries {
+		list[i] = gofuse.DirEntry{Name: e.Name, Mode: syscall.S_IFREG}
+		if e.IsDir {
+			list[i].Mode = syscall.S_IFDIR
+		}
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	h, err := n.fsys.Open(ctx, n.path(), int(flags))
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	var fuseFlags uint32
+	if !h.writable {
+		fuseFlags = gofuse.FOPEN_KEEP_CACHE
+	}
+	return &file{h: h}, fuseFlags, 0
+}
+
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *gofuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	h, err := n.fsys.Create(ctx, path.Join(n.path(), name))
+	if err != nil {
+		return nil, nil, 0, errno(err)
+	}
+	return n.child(ctx, h.Stat(), out), &file{h: h}, 0, 0
+}
+
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *gofuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := path.Join(n.path(), name)
+	if err := n.fsys.Mkdir(ctx, p); err != nil {
+		return nil, errno(err)
+	}
+	info, err := n.fsys.Stat(ctx, p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.child(ctx, info, out), 0
+}
+
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	return errno(n.fsys.Unlink(ctx, path.Join(n.path(), name)))
+}
+
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return errno(n.fsys.Rmdir(ctx, path.Join(n.path(), name)))
+}
+
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags != 0 {
+		// RENAME_NOREPLACE and RENAME_EXCHANGE cannot be done atomically.
+		return syscall.ENOTSUP
+	}
+	dst := path.Join(newParent.EmbeddedInode().Path(nil), newName)
+	return errno(n.fsys.Rename(ctx, path.Join(n.path(), name), dst))
+}
+
+// file is an open file of the mount.
+type file struct {
+	h *Handle
+}
+
+func (f *file) Read(ctx context.Context, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
+	n, err := f.h.ReadAt(ctx, dest, off)
+	if err != nil && err != io.EOF {
+		return nil, errno(err)
+	}
+	return gofuse.ReadResultData(dest[:n]), 0
+}
+
+func (f *file) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	n, err := f.h.WriteAt(ctx, data, off)
+	return uint32(n), errno(err)
+}
+
+func (f *file) Getattr(ctx context.Context, out *gofuse.AttrOut) syscall.Errno {
+	fillAttr(f.h.fs, f.h.Stat(), &out.Attr)
+	out.SetTimeout(f.h.fs.attrTTL)
+	return 0
+}
+
+func (f *file) Flush(ctx context.Context) syscall.Errno {
+	return errno(f.h.Flush(ctx))
+}
+
+func (f *file) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return errno(f.h.Flush(ctx))
+}
+
+func (f *file) Release(ctx context.Context) syscall.Errno {
+	return errno(f.h.Release(ctx))
+}
+
+// Compile-time interface checks.
+var (
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeSetattrer = (*node)(nil)
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.NodeCreater   = (*node)(nil)
+	_ fs.NodeMkdirer   = (*node)(nil)
+	_ fs.NodeUnlinker  = (*node)(nil)
+	_ fs.NodeRmdirer   = (*node)(nil)
+	_ fs.NodeRenamer   = (*node)(nil)
+	_ fs.FileReader    = (*file)(nil)
+	_ fs.FileWriter    = (*file)(nil)
+	_ fs.FileGetattrer = (*file)(nil)
+	_ fs.FileFlusher   = (*file)(nil)
+	_ fs.FileFsyncer   = (*file)(nil)
+	_ fs.FileReleaser  = (*file)(nil)
+)
@@ -0,0 +1,39 @@
+package fuse
+
+import "time"
+
+// Option configures optional FS behavior.
+type Option func(*FS)
+
+// WithAttrTimeout sets how long the attributes of files and directories
+// are cached, by the FS and by the kernel. Longer timeouts save engine
+// calls but hide changes made by others for longer; zero disables caching.
+// The default is DefaultAttrTimeout.
+func WithAttrTimeout(d time.Duration) Option {
+	return func(f *FS) {
+		f.attrTTL = d
+	}
+}
+
+// WithReadOnly rejects all changes.
+func WithReadOnly() Option {
+	return func(f *FS) {
+		f.readOnly = true
+	}
+}
+
+// WithSpoolDir keeps the content of files open for writing in dir instead
+// of the default directory for temporary files.
+func WithSpoolDir(dir string) Option {
+	return func(f *FS) {
+		f.spoolDir = dir
+	}
+}
+
+// WithAllowOther lets users other than the one mounting access the mount.
+// It requires user_allow_other in /etc/fuse.conf.
+func WithAllowOther() Option {
+	return func(f *FS) {
+		f.allowOther = true
+	}
+}
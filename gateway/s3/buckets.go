@@ -0,0 +1,365 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// timeFormat is the format of timestamps in S3 XML responses.
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+type bucketXML struct {
+	Name         string
+	CreationDate string
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.engine.ReadDir(r.Context(), "")
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	var buckets []bucketXML
+	for _, e := range entries {
+		if e.IsDir && validBucket(e.Name) {
+			buckets = append(buckets, bucketXML{Name: e.Name, CreationDate: e.ModTime.UTC().Format(timeFormat)})
+		}
+	}
+	s.writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+		Owner   struct{ ID, DisplayName string }
+		Buckets []bucketXML `xml:"Buckets>Bucket"`
+	}{Buckets: buckets})
+}
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := s.engine.MkdirAll(r.Context(), bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := s.checkBucket(r.Context(), bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	entries, err := s.engine.ReadDir(ctx, bucket)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if len(entries) > 0 {
+		s.error(w, r, errBucketNotEmpty)
+		return
+	}
+	if err := s.engine.Remove(ctx, bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getBucketLocation(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := s.checkBucket(r.Context(), bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+		Region  string   `xml:",chardata"`
+	}{Region: s.region})
+}
+
+type objectXML struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type prefixXML struct {
+	Prefix string
+}
+
+// listItem is an object or, with a delimiter, a common prefix.
+type listItem struct {
+	key    string
+	info   *sbox.EntryInfo // nil for common prefixes
+	prefix bool
+}
+
+// listObjects serves ListObjectsV2 and, without list-type=2, ListObjects.
+// Objects are listed by walking the bucket from the deepest directory
+// named by the prefix; with the delimiter "/", only that directory is
+// read.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		s.errorFor(w, r, err, errNoSuchBucket)
+		return
+	}
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.error(w, r, errInvalidArgument)
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			key, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				s.error(w, r, errInvalidArgument)
+				return
+			}
+			after = string(key)
+		}
+	}
+
+	items, err := s.list(r, bucket, prefix, delimiter)
+	if err != nil {
+		s.errorFor(w, r, err, errNoSuchBucket)
+		return
+	}
+	i := sort.Search(len(items), func(i int) bool { return items[i].key > after })
+	items = items[i:]
+	truncated := len(items) > maxKeys
+	if truncated {
+		items = items[:maxKeys]
+	}
+
+	encode := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		encode = func(s string) string { return strings.ReplaceAll(url.QueryEscape(s), "+", "%20") }
+	}
+	var contents []objectXML
+	var prefixes []prefixXML
+	for _, it := range items {
+		if it.prefix {
+			prefixes = append(prefixes, prefixXML{Prefix: encode(it.key)})
+			continue
+		}
+		contents = append(contents, objectXML{
+			Key:          encode(it.key),
+			LastModified: it.info.ModTime.UTC().Format(timeFormat),
+			ETag:         s.etag(ctx, it.info),
+			Size:         it.info.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	type result struct {
+		XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		Marker                string `xml:",omitempty"`
+		NextMarker            string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		KeyCount              int    `xml:",omitempty"`
+		MaxKeys               int
+		EncodingType          string `xml:",omitempty"`
+		IsTruncated           bool
+		Contents              []objectXML
+		CommonPrefixes        []prefixXML
+	}
+	res := result{
+		Name:           bucket,
+		Prefix:         encode(prefix),
+		Delimiter:      encode(delimiter),
+		MaxKeys:        maxKeys,
+		EncodingType:   q.Get("encoding-type"),
+		IsTruncated:    truncated,
+		Contents:       contents,
+		CommonPrefixes: prefixes,
+	}
+	var next string
+	if truncated {
+		next = items[len(items)-1].key
+	}
+	if v2 {
+		res.StartAfter = encode(q.Get("start-after"))
+		res.ContinuationToken = q.Get("continuation-token")
+		res.KeyCount = len(items)
+		if truncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(next))
+		}
+	} else {
+		res.Marker = encode(q.Get("marker"))
+		if truncated && delimiter != "" {
+			res.NextMarker = encode(next)
+		}
+	}
+	s.writeXML(w, http.StatusOK, res)
+}
+
+// list returns the objects of bucket whose keys start with prefix, sorted
+// by key, with the keys sharing a prefix up to the delimiter grouped into
+// common prefixes.
+func (s *Server) list(r *http.Request, bucket, prefix, delimiter string) ([]listItem, error) {
+	ctx := r.Context()
+	dir := prefix
+	if i := strings.LastIndex(dir, "/"); i >= 0 {
+		dir = dir[:i]
+	} else {
+		dir = ""
+	}
+	if sbox.ValidatePath(dir) != nil {
+		return nil, nil
+	}
+	root := path.Join(bucket, dir)
+
+	var items []listItem
+	seen := make(map[string]bool)
+	add := func(key string, info *sbox.EntryInfo) {
+		if !strings.HasPrefix(key, prefix) {
+			return
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					items = append(items, listItem{key: p, prefix: true})
+				}
+				return
+			}
+		}
+		if info != nil {
+			items = append(items, listItem{key: key, info: info})
+		}
+	}
+
+	if delimiter == "/" {
+		entries, err := s.engine.ReadDir(ctx, root)
+		if errors.Is(err, sbox.ErrNotFound) && dir != "" {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			key := strings.TrimPrefix(path.Join(dir, e.Name), "/")
+			if e.IsDir {
+				add(key+"/", nil)
+			} else {
+				add(key, e)
+			}
+		}
+	} else {
+		err := sbox.Walk(ctx, s.engine, root, func(p string, info *sbox.EntryInfo, err error) error {
+			if err != nil {
+				if errors.Is(err, sbox.ErrNotFound) && dir != "" {
+					return nil
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if info.IsDir {
+				return nil
+			}
+			key, err := sbox.CleanPath(p)
+			if err != nil {
+				return err
+			}
+			add(strings.TrimPrefix(key, bucket+"/"), info)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	return items, nil
+}
+
+type deleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deletedXML struct {
+	Key string
+}
+
+type deleteErrorXML struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	ctx := r.Context()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		s.errorFor(w, r, err, errNoSuchBucket)
+		return
+	}
+	var req deleteRequest
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, 2<<20)).Decode(&req); err != nil {
+		s.error(w, r, errMalformedXML)
+		return
+	}
+	var deleted []deletedXML
+	var errs []deleteErrorXML
+	for _, o := range req.Objects {
+		err := s.removeObject(r, bucket, o.Key)
+		if err != nil {
+			apiErr := toAPIError(err, errNoSuchKey)
+			if apiErr == nil {
+				s.logger.Error("sbox/gateway/s3: delete failed", "bucket", bucket, "key", o.Key, "err", err)
+				apiErr = errInternal
+			}
+			errs = append(errs, deleteErrorXML{Key: o.Key, Code: apiErr.Code, Message: apiErr.Message})
+			continue
+		}
+		if !req.Quiet {
+			deleted = append(deleted, deletedXML{Key: o.Key})
+		}
+	}
+	s.writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+		Deleted []deletedXML
+		Error   []deleteErrorXML
+	}{Deleted: deleted, Error: errs})
+}
+
+// lastModified formats t for the Last-Modified header.
+func lastModified(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
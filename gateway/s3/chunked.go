@@ -0,0 +1,112 @@
+package s3
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkLine bounds the header line of an aws-chunked chunk.
+const maxChunkLine = 4096
+
+// chunkedReader decodes an aws-chunked request body:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;...]\r\n[trailers]\r\n
+//
+// Chunk signatures and trailing checksums are not verified.
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int64 // Bytes left in the current chunk
+	err  error
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.left == 0 && c.err == nil {
+		c.err = c.next()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left == 0 && err == nil {
+		err = c.crlf()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// next reads the header of the next chunk. After the final chunk, it
+// skips the trailers and returns io.EOF.
+func (c *chunkedReader) next() error {
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	size, _, _ := strings.Cut(line, ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		return errInvalidArgument
+	}
+	if n > 0 {
+		c.left = n
+		return nil
+	}
+	for {
+		line, err := c.line()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return io.EOF
+		}
+	}
+}
+
+// line reads a line ending in "\r\n", without it. A body ending after the
+// final chunk without the closing blank line is accepted.
+func (c *chunkedReader) line() (string, error) {
+	var b strings.Builder
+	for {
+		s, err := c.r.ReadSlice('\n')
+		b.Write(s)
+		if b.Len() > maxChunkLine {
+			return "", errInvalidArgument
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && b.Len() == 0 {
+			return "", io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(b.String(), "\r\n"), nil
+	}
+}
+
+// crlf consumes the line break after the data of a chunk.
+func (c *chunkedReader) crlf() error {
+	line, err := c.line()
+	if err == nil && line != "" {
+		err = errInvalidArgument
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // S3 part ETags are MD5 digests
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// stagingBucket holds the parts of multipart uploads for engines without
+// a native sbox.MultipartUploader. Hidden names are not valid buckets, so
+// clients cannot reach it.
+const stagingBucket = ".sbox-multipart"
+
+// uploader returns the engine's MultipartUploader, or one that stages the
+// parts as files.
+func (s *Server) uploader() sbox.MultipartUploader {
+	if mu, ok := s.engine.(sbox.MultipartUploader); ok && sbox.Supports(s.engine, sbox.CapMultipart) {
+		return mu
+	}
+	return stagedUploads{s.engine}
+}
+
+func (s *Server) initiateUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	id, err := s.uploader().InitiateUpload(writeContext(ctx, r), p)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID, partNumber string) {
+	if r.Header.Get("x-amz-copy-source") != "" {
+		s.error(w, r, errNotImplemented)
+		return
+	}
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > 10000 {
+		s.error(w, r, errInvalidArgument)
+		return
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	part, err := s.uploader().UploadPart(r.Context(), p, uploadID, n, body)
+	if err != nil {
+		s.errorFor(w, r, err, errNoSuchUpload)
+		return
+	}
+	w.Header().Set("ETag", `"`+part.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	ctx := r.Context()
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	var req completeRequest
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, 2<<20)).Decode(&req); err != nil || len(req.Parts) == 0 {
+		s.error(w, r, errMalformedXML)
+		return
+	}
+	parts := make([]sbox.PartInfo, len(req.Parts))
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			s.error(w, r, errInvalidPartOrder)
+			return
+		}
+		parts[i] = sbox.PartInfo{Number: part.PartNumber, ETag: strings.Trim(part.ETag, `"`)}
+	}
+	err = s.uploader().CompleteUpload(ctx, p, uploadID, parts)
+	if errors.Is(err, sbox.ErrInvalid) || errors.Is(err, sbox.ErrPreconditionFailed) {
+		err = errInvalidPart
+	}
+	if err != nil {
+		s.errorFor(w, r, err, errNoSuchUpload)
+		return
+	}
+	info, err := s.engine.Stat(ctx, p)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Location: "/" + p, Bucket: bucket, Key: key, ETag: s.etag(ctx, info)})
+}
+
+func (s *Server) abortUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if err := s.uploader().AbortUpload(r.Context(), p, uploadID); err != nil {
+		s.errorFor(w, r, err, errNoSuchUpload)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stagedUploads implements multipart uploads on any engine. Each upload is
+// a directory in the staging bucket holding a record of the upload and
+// one file per part, which CompleteUpload concatenates into the object.
+type stagedUploads struct {
+	engine sbox.StorageEngine
+}
+
+// stagedUpload is the record of an upload.
+type stagedUpload struct {
+	Path     string            `json:"path"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (u stagedUploads) InitiateUpload(ctx context.Context, p string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	data, err := json.Marshal(&stagedUpload{Path: p, Metadata: sbox.MetadataFromContext(ctx)})
+	if err != nil {
+		return "", err
+	}
+	dir := path.Join(stagingBucket, uploadID)
+	if err := u.engine.MkdirAll(ctx, dir); err != nil {
+		return "", err
+	}
+	if err := sbox.PutAtomic(ctx, u.engine, path.Join(dir, "upload"), bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// load returns the directory and record of an upload.
+func (u stagedUploads) load(ctx context.Context, p, uploadID string) (string, *stagedUpload, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", nil, fmt.Errorf("sbox/gateway/s3: upload %q: %w", uploadID, sbox.ErrNotFound)
+	}
+	dir := path.Join(stagingBucket, uploadID)
+	r, err := u.engine.Open(ctx, path.Join(dir, "upload"))
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = r.Close() }()
+	var rec stagedUpload
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return "", nil, err
+	}
+	if rec.Path != p {
+		return "", nil, fmt.Errorf("sbox/gateway/s3: upload %q is not for %s: %w", uploadID, p, sbox.ErrNotFound)
+	}
+	return dir, &rec, nil
+}
+
+func (u stagedUploads) UploadPart(ctx context.Context, p, uploadID string, partNumber int, r io.Reader) (sbox.PartInfo, error) {
+	dir, _, err := u.load(ctx, p, uploadID)
+	if err != nil {
+		return sbox.PartInfo{}, err
+	}
+	h := md5.New() //nolint:gosec // S3 part ETags are MD5 digests
+	cr := &countingReader{r: io.TeeReader(r, h)}
+	part := path.Join(dir, strconv.Itoa(partNumber))
+	if err := sbox.PutAtomic(ctx, u.engine, part, cr); err != nil {
+		return sbox.PartInfo{}, err
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+	if err := sbox.PutAtomic(ctx, u.engine, part+".etag", strings.NewReader(etag)); err != nil {
+		return sbox.PartInfo{}, err
+	}
+	return sbox.PartInfo{Number: partNumber, Size: cr.n, ETag: etag}, nil
+}
+
+func (u stagedUploads) CompleteUpload(ctx context.Context, p, uploadID string, parts []sbox.PartInfo) error {
+	dir, rec, err := u.load(ctx, p, uploadID)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, len(parts))
+	for i, part := range parts {
+		paths[i] = path.Join(dir, strconv.Itoa(part.Number))
+		if part.ETag == "" {
+			continue
+		}
+		etag, err := readAll(ctx, u.engine, paths[i]+".etag")
+		if errors.Is(err, sbox.ErrNotFound) {
+			return fmt.Errorf("sbox/gateway/s3: part %d not uploaded: %w", part.Number, sbox.ErrInvalid)
+		}
+		if err != nil {
+			return err
+		}
+		if string(etag) != part.ETag {
+			return fmt.Errorf("sbox/gateway/s3: part %d: %w", part.Number, sbox.ErrPreconditionFailed)
+		}
+	}
+	pr := &partsReader{ctx: ctx, engine: u.engine, paths: paths}
+	err = sbox.PutAtomic(sbox.WithMetadata(ctx, rec.Metadata), u.engine, p, pr)
+	pr.close()
+	if errors.Is(err, sbox.ErrNotFound) {
+		return fmt.Errorf("sbox/gateway/s3: complete upload %s: %w: %v", uploadID, sbox.ErrInvalid, err)
+	}
+	if err != nil {
+		return err
+	}
+	return u.engine.Remove(ctx, dir)
+}
+
+func (u stagedUploads) AbortUpload(ctx context.Context, p, uploadID string) error {
+	dir, _, err := u.load(ctx, p, uploadID)
+	if err != nil {
+		return err
+	}
+	return u.engine.Remove(ctx, dir)
+}
+
+// partsReader reads the files at paths one after another, opening each
+// when the previous one is done.
+type partsReader struct {
+	ctx    context.Context
+	engine sbox.StorageEngine
+	paths  []string
+	cur    io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := r.engine.Open(r.ctx, r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.paths = f, r.paths[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			_ = r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) close() {
+	if r.cur != nil {
+		_ = r.cur.Close()
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readAll returns the content of the file at p.
+func readAll(ctx context.Context, engine sbox.StorageEngine, p string) ([]byte, error) {
+	r, err := engine.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
+
+// Compile-time interface checks.
+var _ sbox.MultipartUploader = stagedUploads{}
@@ -0,0 +1,325 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // Content-MD5 is defined as MD5
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// contentTypeKey is the metadata key holding an object's Content-Type.
+// Other metadata is served as x-amz-meta-* headers.
+const contentTypeKey = "content-type"
+
+// etag returns the quoted ETag of an object: the engine's ETag if it
+// supports sbox.ConditionalWriter, and otherwise one derived from the
+// size and modification time.
+func (s *Server) etag(ctx context.Context, info *sbox.EntryInfo) string {
+	if cw, ok := s.engine.(sbox.ConditionalWriter); ok && sbox.Supports(s.engine, sbox.CapConditionalWrite) {
+		if tag, err := cw.ETag(ctx, info.Path); err == nil {
+			return `"` + tag + `"`
+		}
+	}
+	sum := sha256.Sum256([]byte(strconv.FormatInt(info.Size, 10) + ":" + strconv.FormatInt(info.ModTime.UnixNano(), 10)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// stat returns the object at p, treating directories as missing.
+func (s *Server) stat(ctx context.Context, p string) (*sbox.EntryInfo, error) {
+	info, err := s.engine.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, errNoSuchKey
+	}
+	return info, nil
+}
+
+// objectHeaders sets the headers describing an object.
+func (s *Server) objectHeaders(w http.ResponseWriter, info *sbox.EntryInfo, etag string) {
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", lastModified(info.ModTime))
+	h.Set("Accept-Ranges", "bytes")
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = info.Metadata[contentTypeKey]
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(info.Name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	for k, v := range info.Metadata {
+		if k != contentTypeKey {
+			h.Set("x-amz-meta-"+k, v)
+		}
+	}
+}
+
+// getObject serves GetObject and HeadObject. Ranges and conditional
+// headers are handled by http.ServeContent.
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	info, err := s.stat(ctx, p)
+	if err != nil {
+		if errors.Is(err, sbox.ErrNotFound) {
+			if berr := s.checkBucket(ctx, bucket); berr != nil {
+				err = berr
+			}
+		}
+		s.error(w, r, err)
+		return
+	}
+	s.objectHeaders(w, info, s.etag(ctx, info))
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	f, err := s.engine.Open(ctx, p)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	http.ServeContent(w, r, "", info.ModTime, f)
+}
+
+// putObject serves PutObject. The object is written atomically, so a
+// failed upload leaves any existing object in place.
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	// Keys ending in "/" are folder markers, created as directories.
+	if strings.HasSuffix(key, "/") {
+		if err := s.engine.MkdirAll(ctx, p); err != nil {
+			s.error(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, err := requestBody(r)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if err := s.engine.MkdirAll(ctx, path.Dir(p)); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if err := sbox.PutAtomic(writeContext(ctx, r), s.engine, p, body); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	info, err := s.engine.Stat(ctx, p)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", s.etag(ctx, info))
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeContext returns ctx carrying the metadata, content type and
+// x-amz-checksum-sha256 checksum of an object written by r, for the
+// engine.
+func writeContext(ctx context.Context, r *http.Request) context.Context {
+	ctx = sbox.WithMetadata(ctx, requestMetadata(r))
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		ctx = sbox.WithFileOptions(ctx, sbox.WithContentType(ct))
+	}
+	if sum, err := base64.StdEncoding.DecodeString(r.Header.Get("x-amz-checksum-sha256")); err == nil && len(sum) == sha256.Size {
+		ctx = sbox.WithFileOptions(ctx, sbox.WithChecksum("sha256", hex.EncodeToString(sum)))
+	}
+	return ctx
+}
+
+// requestMetadata returns the metadata of an object from the
+// Content-Type and x-amz-meta-* headers, or nil if there is none.
+func requestMetadata(r *http.Request) map[string]string {
+	var md map[string]string
+	set := func(k, v string) {
+		if md == nil {
+			md = make(map[string]string)
+		}
+		md[k] = v
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		set(contentTypeKey, ct)
+	}
+	for k, v := range r.Header {
+		if name, ok := strings.CutPrefix(strings.ToLower(k), "x-amz-meta-"); ok && len(v) > 0 {
+			set(name, v[0])
+		}
+	}
+	return md
+}
+
+// requestBody returns the object data of a PUT request, decoding
+// aws-chunked uploads and checking Content-MD5 if given.
+func requestBody(r *http.Request) (io.Reader, error) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		body = newChunkedReader(r.Body)
+	}
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		want, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(want) != md5.Size {
+			return nil, errInvalidArgument
+		}
+		body = &verifyingReader{r: body, h: md5.New(), want: want} //nolint:gosec // Content-MD5
+	}
+	return body, nil
+}
+
+// verifyingReader fails with errBadDigest at the end of the data if its
+// hash does not match want, which makes PutAtomic discard the object.
+type verifyingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.h.Sum(nil), v.want) {
+		return n, errBadDigest
+	}
+	return n, err
+}
+
+// copyObject serves CopyObject, using the engine's copier if it has one.
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	ctx := r.Context()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	source, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		s.error(w, r, errInvalidArgument)
+		return
+	}
+	source, _, _ = strings.Cut(source, "?")
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !validBucket(srcBucket) || srcKey == "" {
+		s.error(w, r, errInvalidArgument)
+		return
+	}
+	src, err := objectPath(srcBucket, srcKey)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	dst, err := objectPath(bucket, key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if _, err := s.stat(ctx, src); err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if src != dst {
+		if err := s.engine.MkdirAll(ctx, path.Dir(dst)); err != nil {
+			s.error(w, r, err)
+			return
+		}
+		if err := sbox.Copied(s.engine).Copy(ctx, src, dst); err != nil {
+			s.error(w, r, err)
+			return
+		}
+	}
+	if r.Header.Get("x-amz-metadata-directive") == "REPLACE" {
+		if mw, ok := s.engine.(sbox.MetadataWriter); ok && sbox.Supports(s.engine, sbox.CapMetadata) {
+			if err := mw.SetMetadata(ctx, dst, requestMetadata(r)); err != nil {
+				s.error(w, r, err)
+				return
+			}
+		}
+	}
+	info, err := s.engine.Stat(ctx, dst)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.writeXML(w, http.StatusOK, struct {
+		XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+		LastModified string
+		ETag         string
+	}{LastModified: info.ModTime.UTC().Format(timeFormat), ETag: s.etag(ctx, info)})
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if err := s.removeObject(r, bucket, key); err != nil && !errors.Is(err, sbox.ErrNotFound) {
+		s.error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeObject removes an object. Like S3, removing a missing object
+// succeeds; folder markers remove their directory only if it is empty.
+func (s *Server) removeObject(r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	p, err := objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	info, err := s.engine.Stat(ctx, p)
+	if errors.Is(err, sbox.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		if !strings.HasSuffix(key, "/") {
+			return nil
+		}
+		entries, err := s.engine.ReadDir(ctx, p)
+		if err != nil || len(entries) > 0 {
+			return err
+		}
+	}
+	return s.engine.Remove(ctx, p)
+}
@@ -0,0 +1,21 @@
+package s3
+
+import "log/slog"
+
+// Option configures optional Server behavior.
+type Option func(*Server)
+
+// WithLogger logs internal errors to logger instead of slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithRegion sets the region reported by GetBucketLocation, "us-east-1"
+// by default.
+func WithRegion(region string) Option {
+	return func(s *Server) {
+		s.region = region
+	}
+}
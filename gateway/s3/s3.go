@@ -0,0 +1,233 @@
+// Package s3 serves any sbox.StorageEngine over a subset of the Amazon S3
+// REST API, so existing S3 clients and tools can use sharded or local
+// storage:
+//
+//	http.ListenAndServe("localhost:9000", s3.New(engine))
+//
+// Buckets are the top-level directories of the engine and object keys are
+// paths below them. Requests must use path-style addressing
+// ("http://host/bucket/key"); most clients have an option for it, such as
+// force_path_style or --endpoint-url with addressing_style "path".
+//
+// Supported operations are ListBuckets, CreateBucket, HeadBucket,
+// DeleteBucket, GetBucketLocation, ListObjectsV2 (and V1), GetObject with
+// ranges and conditional headers, HeadObject, PutObject (including
+// aws-chunked uploads), CopyObject, DeleteObject, DeleteObjects and
+// multipart uploads. Multipart uploads use the engine's
+// sbox.MultipartUploader when it supports one, and otherwise store the
+// parts in the hidden bucket ".sbox-multipart" until they are completed.
+//
+// The server does not check request signatures. Put it behind an
+// authenticating proxy, or bind it to a trusted network.
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// Server is an http.Handler serving an engine over the S3 API.
+type Server struct {
+	engine sbox.StorageEngine
+	logger *slog.Logger
+	region string
+}
+
+// New returns a Server for engine.
+func New(engine sbox.StorageEngine, opts ...Option) *Server {
+	s := &Server{engine: engine, logger: slog.Default(), region: "us-east-1"}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ServeHTTP routes a request to the S3 operation it names.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			s.error(w, r, errMethodNotAllowed)
+			return
+		}
+		s.listBuckets(w, r)
+		return
+	}
+	if !validBucket(bucket) {
+		s.error(w, r, errInvalidBucketName)
+		return
+	}
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodGet && q.Has("location"):
+			s.getBucketLocation(w, r, bucket)
+		case r.Method == http.MethodGet && q.Has("uploads"):
+			s.error(w, r, errNotImplemented)
+		case r.Method == http.MethodGet:
+			s.listObjects(w, r, bucket)
+		case r.Method == http.MethodHead:
+			s.headBucket(w, r, bucket)
+		case r.Method == http.MethodPut:
+			s.createBucket(w, r, bucket)
+		case r.Method == http.MethodDelete:
+			s.deleteBucket(w, r, bucket)
+		case r.Method == http.MethodPost && q.Has("delete"):
+			s.deleteObjects(w, r, bucket)
+		default:
+			s.error(w, r, errMethodNotAllowed)
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.initiateUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, r, bucket, key, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		s.abortUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucket, key)
+	default:
+		s.error(w, r, errMethodNotAllowed)
+	}
+}
+
+// validBucket reports whether name is a bucket name the server accepts:
+// one path element that is not hidden.
+func validBucket(name string) bool {
+	return !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`) && sbox.ValidatePath(name) == nil
+}
+
+// objectPath returns the engine path of an object, or an error if the key
+// cannot be stored.
+func objectPath(bucket, key string) (string, error) {
+	if err := sbox.ValidatePath(key); err != nil {
+		return "", errInvalidKey
+	}
+	return path.Join(bucket, key), nil
+}
+
+// checkBucket returns errNoSuchBucket unless bucket exists.
+func (s *Server) checkBucket(ctx context.Context, bucket string) error {
+	info, err := s.engine.Stat(ctx, bucket)
+	if errors.Is(err, sbox.ErrNotFound) || (err == nil && !info.IsDir) {
+		return errNoSuchBucket
+	}
+	return err
+}
+
+// writeXML writes v as the XML body of a response with the given status.
+func (s *Server) writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("sbox/gateway/s3: encode response", "err", err)
+	}
+}
+
+// apiError is an S3 error response.
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+var (
+	errAccessDenied       = &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errBadDigest          = &apiError{"BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest}
+	errBucketNotEmpty     = &apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty.", http.StatusConflict}
+	errInternal           = &apiError{"InternalError", "We encountered an internal error. Please try again.", http.StatusInternalServerError}
+	errInvalidArgument    = &apiError{"InvalidArgument", "Invalid Argument", http.StatusBadRequest}
+	errInvalidBucketName  = &apiError{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}
+	errInvalidKey         = &apiError{"InvalidArgument", "The specified key is not valid.", http.StatusBadRequest}
+	errInvalidPart        = &apiError{"InvalidPart", "One or more of the specified parts could not be found.", http.StatusBadRequest}
+	errInvalidPartOrder   = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order.", http.StatusBadRequest}
+	errMalformedXML       = &apiError{"MalformedXML", "The XML you provided was not well-formed.", http.StatusBadRequest}
+	errMethodNotAllowed   = &apiError{"MethodNotAllowed", "The specified method is not allowed against this resource.", http.StatusMethodNotAllowed}
+	errNoSuchBucket       = &apiError{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchKey          = &apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errNoSuchUpload       = &apiError{"NoSuchUpload", "The specified multipart upload does not exist.", http.StatusNotFound}
+	errNotImplemented     = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errPreconditionFailed = &apiError{"PreconditionFailed", "At least one of the preconditions you specified did not hold.", http.StatusPreconditionFailed}
+)
+
+// toAPIError maps an engine error to an S3 error; notFound is returned
+// for sbox.ErrNotFound.
+func toAPIError(err error, notFound *apiError) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, sbox.ErrNotFound):
+		return notFound
+	case errors.Is(err, sbox.ErrPreconditionFailed):
+		return errPreconditionFailed
+	case errors.Is(err, sbox.ErrChecksumMismatch):
+		return errBadDigest
+	case errors.Is(err, sbox.ErrPermission):
+		return errAccessDenied
+	case errors.Is(err, sbox.ErrInvalid), errors.Is(err, sbox.ErrIsDir), errors.Is(err, sbox.ErrNotDir), errors.Is(err, sbox.ErrExist):
+		return errInvalidArgument
+	case errors.Is(err, sbox.ErrNotSupported):
+		return errNotImplemented
+	}
+	return nil
+}
+
+// error writes the S3 error response for err, treating sbox.ErrNotFound as
+// a missing key.
+func (s *Server) error(w http.ResponseWriter, r *http.Request, err error) {
+	s.errorFor(w, r, err, errNoSuchKey)
+}
+
+// errorFor writes the S3 error response for err, using notFound for
+// sbox.ErrNotFound.
+func (s *Server) errorFor(w http.ResponseWriter, r *http.Request, err error, notFound *apiError) {
+	apiErr := toAPIError(err, notFound)
+	if apiErr == nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		s.logger.Error("sbox/gateway/s3: request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+		apiErr = errInternal
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(apiErr.Status)
+		return
+	}
+	s.writeXML(w, apiErr.Status, struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: apiErr.Code, Message: apiErr.Message, Resource: r.URL.Path})
+}
+
+// Compile-time interface checks.
+var (
+	_ http.Handler = (*Server)(nil)
+	_ error        = (*apiError)(nil)
+)
@@ -0,0 +1,250 @@
+package s3_test
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/gateway/s3"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sharded"
+)
+
+// do sends a request to srv and returns the response with its body read.
+func do(t *testing.T, srv *httptest.Server, method, target, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+target, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read %s %s: %v", method, target, err)
+	}
+	return resp, string(data)
+}
+
+func expect(t *testing.T, resp *http.Response, body string, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL, resp.StatusCode, status, body)
+	}
+}
+
+func TestGateway_Objects(t *testing.T) {
+	srv := httptest.NewServer(s3.New(sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)))
+	defer srv.Close()
+
+	resp, body := do(t, srv, "PUT", "/photos/a.txt", "x", nil)
+	expect(t, resp, body, http.StatusNotFound)
+	if !strings.Contains(body, "<Code>NoSuchBucket</Code>") {
+		t.Errorf("error body = %s", body)
+	}
+
+	resp, body = do(t, srv, "PUT", "/photos", "", nil)
+	expect(t, resp, body, http.StatusOK)
+	resp, body = do(t, srv, "PUT", "/photos/2024/a b.txt", "hello world", map[string]string{
+		"Content-Type":      "text/plain",
+		"x-amz-meta-author": "ann",
+	})
+	expect(t, resp, body, http.StatusOK)
+	if resp.Header.Get("ETag") == "" {
+		t.Error("PutObject returned no ETag")
+	}
+
+	resp, body = do(t, srv, "GET", "/photos/2024/a%20b.txt", "", nil)
+	expect(t, resp, body, http.StatusOK)
+	if body != "hello world" || resp.Header.Get("Content-Type") != "text/plain" || resp.Header.Get("x-amz-meta-author") != "ann" {
+		t.Errorf("GetObject = %q, headers %v", body, resp.Header)
+	}
+	resp, body = do(t, srv, "GET", "/photos/2024/a%20b.txt", "", map[string]string{"Range": "bytes=6-"})
+	expect(t, resp, body, http.StatusPartialContent)
+	if body != "world" {
+		t.Errorf("ranged GetObject = %q", body)
+	}
+	resp, body = do(t, srv, "HEAD", "/photos/2024/a%20b.txt", "", nil)
+	expect(t, resp, body, http.StatusOK)
+	if resp.ContentLength != 11 {
+		t.Errorf("HeadObject Content-Length = %d", resp.ContentLength)
+	}
+	resp, body = do(t, srv, "GET", "/photos/missing", "", nil)
+	expect(t, resp, body, http.StatusNotFound)
+	if !strings.Contains(body, "<Code>NoSuchKey</Code>") {
+		t.Errorf("error body = %s", body)
+	}
+
+	resp, body = do(t, srv, "PUT", "/photos/copy.txt", "", map[string]string{"x-amz-copy-source": "/photos/2024/a%20b.txt"})
+	expect(t, resp, body, http.StatusOK)
+	if _, body := do(t, srv, "GET", "/photos/copy.txt", "", nil); body != "hello world" {
+		t.Errorf("copied object = %q", body)
+	}
+
+	resp, body = do(t, srv, "PUT", "/photos/bad.txt", "data", map[string]string{"Content-MD5": "AAAAAAAAAAAAAAAAAAAAAA=="})
+	expect(t, resp, body, http.StatusBadRequest)
+	if resp, _ := do(t, srv, "HEAD", "/photos/bad.txt", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("object with a bad digest was stored: %d", resp.StatusCode)
+	}
+
+	// aws-chunked bodies, as sent by the AWS SDKs, are decoded.
+	chunked := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n chunk\r\n0;chunk-signature=ghi\r\n\r\n"
+	resp, body = do(t, srv, "PUT", "/photos/chunked.txt", chunked, map[string]string{
+		"x-amz-content-sha256":         "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+		"x-amz-decoded-content-length": "11",
+	})
+	expect(t, resp, body, http.StatusOK)
+	if _, body := do(t, srv, "GET", "/photos/chunked.txt", "", nil); body != "hello chunk" {
+		t.Errorf("chunked object = %q", body)
+	}
+
+	resp, body = do(t, srv, "DELETE", "/photos/copy.txt", "", nil)
+	expect(t, resp, body, http.StatusNoContent)
+	resp, body = do(t, srv, "POST", "/photos?delete", `<Delete><Object><Key>chunked.txt</Key></Object><Object><Key>gone</Key></Object></Delete>`, nil)
+	expect(t, resp, body, http.StatusOK)
+	if strings.Count(body, "<Deleted>") != 2 {
+		t.Errorf("DeleteObjects = %s", body)
+	}
+	resp, body = do(t, srv, "DELETE", "/photos", "", nil)
+	expect(t, resp, body, http.StatusConflict)
+}
+
+type listResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func list(t *testing.T, srv *httptest.Server, query string) listResult {
+	t.Helper()
+	resp, body := do(t, srv, "GET", "/b?list-type=2&"+query, "", nil)
+	expect(t, resp, body, http.StatusOK)
+	var res listResult
+	if err := xml.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	return res
+}
+
+func keys(res listResult) string {
+	var out []string
+	for _, c := range res.Contents {
+		out = append(out, c.Key)
+	}
+	for _, p := range res.CommonPrefixes {
+		out = append(out, p.Prefix+"*")
+	}
+	return strings.Join(out, ",")
+}
+
+func TestGateway_List(t *testing.T) {
+	srv := httptest.NewServer(s3.New(memory.New()))
+	defer srv.Close()
+	do(t, srv, "PUT", "/b", "", nil)
+	for _, k := range []string{"a-c", "a/b", "a/c/d", "b", "c"} {
+		resp, body := do(t, srv, "PUT", "/b/"+k, k, nil)
+		expect(t, resp, body, http.StatusOK)
+	}
+
+	if got := keys(list(t, srv, "")); got != "a-c,a/b,a/c/d,b,c" {
+		t.Errorf("keys = %s", got)
+	}
+	if got := keys(list(t, srv, "delimiter=/")); got != "a-c,b,c,a/*" {
+		t.Errorf("keys with delimiter = %s", got)
+	}
+	if got := keys(list(t, srv, "prefix=a/&delimiter=/")); got != "a/b,a/c/*" {
+		t.Errorf("keys under a/ = %s", got)
+	}
+	if got := keys(list(t, srv, "prefix=a/c")); got != "a/c/d" {
+		t.Errorf("keys with prefix a/c = %s", got)
+	}
+
+	res := list(t, srv, "max-keys=2")
+	if got := keys(res); got != "a-c,a/b" || !res.IsTruncated {
+		t.Fatalf("first page = %s, truncated %v", got, res.IsTruncated)
+	}
+	res = list(t, srv, "max-keys=2&continuation-token="+res.NextContinuationToken)
+	if got := keys(res); got != "a/c/d,b" {
+		t.Errorf("second page = %s", got)
+	}
+}
+
+type initiateResult struct {
+	UploadId string
+}
+
+func testMultipart(t *testing.T, engine sbox.StorageEngine) {
+	srv := httptest.NewServer(s3.New(engine))
+	defer srv.Close()
+	do(t, srv, "PUT", "/b", "", nil)
+
+	resp, body := do(t, srv, "POST", "/b/big.bin?uploads", "", map[string]string{"x-amz-meta-k": "v"})
+	expect(t, resp, body, http.StatusOK)
+	var init initiateResult
+	if err := xml.Unmarshal([]byte(body), &init); err != nil || init.UploadId == "" {
+		t.Fatalf("InitiateMultipartUpload = %s, %v", body, err)
+	}
+	etags := make([]string, 2)
+	for i, part := range []string{"part one, ", "part two"} {
+		resp, body := do(t, srv, "PUT", "/b/big.bin?partNumber="+string(rune('1'+i))+"&uploadId="+init.UploadId, part, nil)
+		expect(t, resp, body, http.StatusOK)
+		etags[i] = resp.Header.Get("ETag")
+	}
+
+	complete := func(etag1 string) (*http.Response, string) {
+		return do(t, srv, "POST", "/b/big.bin?uploadId="+init.UploadId,
+			"<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"+etag1+"</ETag></Part>"+
+				"<Part><PartNumber>2</PartNumber><ETag>"+etags[1]+"</ETag></Part></CompleteMultipartUpload>", nil)
+	}
+	resp, body = complete(`"0000"`)
+	expect(t, resp, body, http.StatusBadRequest)
+	if !strings.Contains(body, "<Code>InvalidPart</Code>") {
+		t.Errorf("error body = %s", body)
+	}
+	resp, body = complete(etags[0])
+	expect(t, resp, body, http.StatusOK)
+	resp, body = do(t, srv, "GET", "/b/big.bin", "", nil)
+	if body != "part one, part two" {
+		t.Errorf("completed object = %q", body)
+	}
+	if sbox.Supports(engine, sbox.CapMetadata) && resp.Header.Get("x-amz-meta-k") != "v" {
+		t.Errorf("completed object headers = %v", resp.Header)
+	}
+
+	resp, body = do(t, srv, "DELETE", "/b/big.bin?uploadId="+init.UploadId, "", nil)
+	expect(t, resp, body, http.StatusNotFound)
+	if !strings.Contains(body, "<Code>NoSuchUpload</Code>") {
+		t.Errorf("error body = %s", body)
+	}
+	resp, body = do(t, srv, "GET", "/", "", nil)
+	if strings.Contains(body, "sbox-multipart") {
+		t.Errorf("ListBuckets shows the staging bucket: %s", body)
+	}
+}
+
+func TestGateway_Multipart(t *testing.T) {
+	t.Run("staged", func(t *testing.T) {
+		testMultipart(t, memory.New())
+	})
+	t.Run("native", func(t *testing.T) {
+		testMultipart(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4))
+	})
+}
@@ -0,0 +1,140 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Glob returns the paths of all files and directories matching pattern,
+// sorted. Patterns use the path.Match syntax per path element, and an
+// element of "**" matches zero or more directories, so "logs/**/*.gz"
+// matches both "logs/a.gz" and "logs/2024/01/b.gz". Only the directories
+// below the pattern's literal prefix (see [GlobBase]) are listed, and
+// subtrees that cannot match are skipped.
+//
+// Engines implementing [Glober] are used natively. The only possible error
+// besides listing failures is path.ErrBadPattern.
+func Glob(ctx context.Context, engine StorageEngine, pattern string) ([]string, error) {
+	if err := checkGlob(pattern); err != nil {
+		return nil, err
+	}
+	if g, ok := engine.(Glober); ok {
+		return g.Glob(ctx, pattern)
+	}
+
+	pat := splitGlob(pattern)
+	var matches []string
+	err := Walk(ctx, engine, GlobBase(pattern), func(p string, info *EntryInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		elems := splitGlob(p)
+		if len(elems) > 0 && matchGlob(pat, elems) {
+			matches = append(matches, p)
+		}
+		if info.IsDir && !globCanDescend(pat, elems) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// MatchGlob reports whether name matches pattern, using the syntax of
+// [Glob]. It is meant for drivers implementing [Glober].
+func MatchGlob(pattern, name string) (bool, error) {
+	if err := checkGlob(pattern); err != nil {
+		return false, err
+	}
+	return matchGlob(splitGlob(pattern), splitGlob(name)), nil
+}
+
+// GlobBase returns the leading path elements of pattern that contain no
+// wildcards: the directory that every match lies in.
+func GlobBase(pattern string) string {
+	var base []string
+	for _, elem := range splitGlob(pattern) {
+		if strings.ContainsAny(elem, `*?[\`) {
+			break
+		}
+		base = append(base, elem)
+	}
+	return strings.Join(base, "/")
+}
+
+// checkGlob validates every element of pattern.
+func checkGlob(pattern string) error {
+	for _, elem := range splitGlob(pattern) {
+		if _, err := path.Match(elem, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitGlob splits a cleaned path or pattern into its elements.
+func splitGlob(p string) []string {
+	p = cleanRoutePath(p)
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// matchGlob reports whether the path elements name match pat.
+func matchGlob(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			// Collapse repeated "**" and try every split point.
+			for len(pat) > 0 && pat[0] == "**" {
+				pat = pat[1:]
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// globCanDescend reports whether something below the directory dir could
+// match pat.
+func globCanDescend(pat, dir []string) bool {
+	for len(dir) > 0 {
+		if len(pat) == 0 {
+			return false
+		}
+		if pat[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pat[0], dir[0]); !ok {
+			return false
+		}
+		pat, dir = pat[1:], dir[1:]
+	}
+	return len(pat) > 0
+}
@@ -0,0 +1,70 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestGlob(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	for _, p := range []string{
+		"logs/a.gz",
+		"logs/a.txt",
+		"logs/2024/01/b.gz",
+		"logs/2024/c.gz",
+		"other/d.gz",
+	} {
+		if err := sbox.PutAtomic(ctx, engine, p, strings.NewReader(p)); err != nil {
+			t.Fatalf("PutAtomic: %v", err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"logs/**/*.gz", []string{"logs/2024/01/b.gz", "logs/2024/c.gz", "logs/a.gz"}},
+		{"logs/*.gz", []string{"logs/a.gz"}},
+		{"logs/*/*.gz", []string{"logs/2024/c.gz"}},
+		{"**/*.gz", []string{"logs/2024/01/b.gz", "logs/2024/c.gz", "logs/a.gz", "other/d.gz"}},
+		{"logs/**", []string{"logs", "logs/2024", "logs/2024/01", "logs/2024/01/b.gz", "logs/2024/c.gz", "logs/a.gz", "logs/a.txt"}},
+		{"*", []string{"logs", "other"}},
+		{"logs/a.txt", []string{"logs/a.txt"}},
+		{"missing/**", nil},
+	}
+	for _, tt := range tests {
+		got, err := sbox.Glob(ctx, engine, tt.pattern)
+		if err != nil {
+			t.Errorf("Glob(%q): %v", tt.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := sbox.Glob(ctx, engine, "logs/[a"); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("bad pattern error = %v, want %v", err, path.ErrBadPattern)
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"logs/**/*.gz": "logs",
+		"a/b/c?.txt":   "a/b",
+		"*.gz":         "",
+		"/a/b/":        "a/b",
+	} {
+		if got := sbox.GlobBase(pattern); got != want {
+			t.Errorf("GlobBase(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
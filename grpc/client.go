@@ -0,0 +1,524 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// Auto-register the gRPC client driver. "grpc://host:7070" connects
+// without TLS; add "?tls=true" for TLS and "&token=..." for a bearer token.
+func init() {
+	sbox.Register("grpc", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
+		address, _ := cfg.Options["address"].(string)
+		if address == "" {
+			address = strings.Trim(cfg.BasePath, "/")
+		}
+		if address == "" {
+			return nil, fmt.Errorf("sbox/grpc: address is required (set Options[\"address\"] or BasePath)")
+		}
+		var opts []Option
+		if token, _ := cfg.Options["token"].(string); token != "" {
+			opts = append(opts, WithToken(token))
+		}
+		switch v := cfg.Options["tls"].(type) {
+		case bool:
+			if v {
+				opts = append(opts, WithTLS(&tls.Config{}))
+			}
+		case string:
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/grpc: invalid tls %q: %w", v, err)
+			}
+			if enabled {
+				opts = append(opts, WithTLS(&tls.Config{}))
+			}
+		}
+		return New(address, opts...)
+	})
+}
+
+// Engine implements sbox.StorageEngine as a client of a Server, or of any
+// server implementing the Storage service of storage.proto.
+type Engine struct {
+	base      string // Scheme and address
+	client    *http.Client
+	ownClient bool // client was created by New and is closed by Close
+	tls       *tls.Config
+	token     string
+}
+
+// New creates an Engine for the server at address ("host:port").
+func New(address string, opts ...Option) (*Engine, error) {
+	if address == "" || strings.Contains(address, "/") {
+		return nil, fmt.Errorf("sbox/grpc: invalid address %q", address)
+	}
+	e := &Engine{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.base = "http://" + address
+	if e.tls != nil {
+		e.base = "https://" + address
+	}
+	if e.client == nil {
+		protocols := new(http.Protocols)
+		if e.tls != nil {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		e.client = &http.Client{Transport: &http.Transport{Protocols: protocols, TLSClientConfig: e.tls}}
+		e.ownClient = true
+	}
+	return e, nil
+}
+
+// Close closes the idle connections to the server.
+func (e *Engine) Close() error {
+	if e.ownClient {
+		e.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// call starts a call of method, sending the request messages read from
+// body.
+func (e *Engine) call(ctx context.Context, method string, body io.Reader) (*responseStream, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.base+servicePath+method, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", encodeTimeout(time.Until(deadline)))
+	}
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sbox/grpc: %s: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		return nil, &Error{Code: codeUnavailable, Message: method + ": " + resp.Status}
+	}
+	return &responseStream{resp: resp}, nil
+}
+
+// unary makes a call with one request and one response message.
+func (e *Engine) unary(ctx context.Context, method string, req, resp message) error {
+	return e.clientStream(ctx, method, bytes.NewReader(appendFrame(nil, req)), resp)
+}
+
+// clientStream makes a call with the request messages read from body and
+// one response message.
+func (e *Engine) clientStream(ctx context.Context, method string, body io.Reader, resp message) error {
+	s, err := e.call(ctx, method, body)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.recv(resp); err != nil {
+		if err == io.EOF {
+			return &Error{Code: codeInternal, Message: method + ": missing response message"}
+		}
+		return err
+	}
+	if err := s.recv(emptyMessage{}); err != io.EOF {
+		if err == nil {
+			return &Error{Code: codeInternal, Message: method + ": unexpected response message"}
+		}
+		return err
+	}
+	return nil
+}
+
+// responseStream reads the response messages of a call.
+type responseStream struct {
+	resp *http.Response
+	buf  []byte
+}
+
+// recv reads the next message into m. At the end of the stream it returns
+// io.EOF if the call succeeded, and its error status otherwise.
+func (s *responseStream) recv(m message) error {
+	b, err := readFrame(s.resp.Body, s.buf)
+	if err == io.EOF {
+		return s.status()
+	}
+	if err != nil {
+		return err
+	}
+	s.buf = b
+	return m.unmarshal(b)
+}
+
+// status returns the status of the finished call, or io.EOF if it is OK.
+// Calls that fail before sending anything report it in the headers.
+func (s *responseStream) status() error {
+	get := func(key string) string {
+		if v := s.resp.Trailer.Get(key); v != "" {
+			return v
+		}
+		return s.resp.Header.Get(key)
+	}
+	code, err := strconv.Atoi(get("Grpc-Status"))
+	if err != nil {
+		return &Error{Code: codeInternal, Message: "missing or invalid grpc-status"}
+	}
+	if code == codeOK {
+		return io.EOF
+	}
+	return statusError(code, decodeMessage(get("Grpc-Message")), get("Sbox-Error"))
+}
+
+func (s *responseStream) close() {
+	_ = s.resp.Body.Close()
+}
+
+// wrapErr makes *err an *sbox.PathError of the grpc driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("grpc", op, path, *err)
+}
+
+func (e *Engine) Stat(ctx context.Context, p string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", p, &err)
+	var resp entry
+	if err := e.unary(ctx, "Stat", &pathRequest{Path: p}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.EntryInfo, nil
+}
+
+func (e *Engine) Open(ctx context.Context, p string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	info, err := e.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, sbox.ErrIsDir
+	}
+	// Nothing is read until the first Read; seeking starts a new call at
+	// the offset.
+	return &rangeReader{ctx: ctx, engine: e, path: p, size: info.Size}, nil
+}
+
+// rangeReader implements ReadSeekCloser over Read calls.
+type rangeReader struct {
+	ctx    context.Context
+	engine *Engine
+	path   string
+	size   int64
+	offset int64
+	rc     io.ReadCloser // Open stream positioned at offset, if any
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.engine.GetRange(r.ctx, r.path, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		newOffset = r.size + offset
+	default:
+		return 0, errors.New("sbox/grpc: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("sbox/grpc: negative seek offset")
+	}
+	if newOffset != r.offset && r.rc != nil {
+		_ = r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = newOffset
+	return r.offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// Create streams the file to the server, which replaces p atomically when
+// the writer is closed.
+func (e *Engine) Create(ctx context.Context, p string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", p, &err)
+	return e.newWriter(ctx, p, modeReplace), nil
+}
+
+// OpenFile opens p for writing. With os.O_APPEND, data is appended to the
+// file; with os.O_CREATE|os.O_EXCL, the file must not exist. Otherwise the
+// file is replaced atomically on Close, like Create. The writer streams to
+// the server, so it can only seek to its current offset.
+func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	mode := modeReplace
+	switch {
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		mode = modeExclusive
+	case flag&os.O_APPEND != 0 && flag&os.O_TRUNC == 0:
+		mode = modeAppend
+	}
+	if flag&os.O_CREATE == 0 {
+		info, err := e.Stat(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir {
+			return nil, sbox.ErrIsDir
+		}
+	}
+	return e.newWriter(ctx, p, mode), nil
+}
+
+// writer sends what is written as the data of a Write call running in the
+// background.
+type writer struct {
+	pw     *io.PipeWriter
+	done   chan error
+	resp   writeResponse
+	frame  []byte
+	offset int64
+	closed bool
+}
+
+func (e *Engine) newWriter(ctx context.Context, p string, mode int64) *writer {
+	pr, pw := io.Pipe()
+	w := &writer{pw: pw, done: make(chan error, 1)}
+	header := appendFrame(nil, &writeRequest{Path: p, Mode: mode, Metadata: sbox.MetadataFromContext(ctx)})
+	go func() {
+		err := e.clientStream(ctx, "Write", io.MultiReader(bytes.NewReader(header), pr), &w.resp)
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunkSize)
+		w.frame = appendFrame(w.frame[:0], &writeRequest{Data: p[:n]})
+		if _, err := w.pw.Write(w.frame); err != nil {
+			return written, err
+		}
+		p = p[n:]
+		written += n
+		w.offset += int64(n)
+	}
+	return written, nil
+}
+
+// Seek reports the offset; the writer cannot move.
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	if (whence == io.SeekCurrent && offset == 0) || (whence == io.SeekStart && offset == w.offset) {
+		return w.offset, nil
+	}
+	return 0, fmt.Errorf("sbox/grpc: seek in a streaming writer: %w", sbox.ErrNotSupported)
+}
+
+// Close ends the data and returns the result of the call.
+func (w *writer) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	_ = w.pw.Close()
+	return <-w.done
+}
+
+func (e *Engine) Remove(ctx context.Context, p string) (err error) {
+	defer wrapErr("remove", p, &err)
+	return e.unary(ctx, "Remove", &pathRequest{Path: p}, emptyMessage{})
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	return e.unary(ctx, "Rename", &renameRequest{From: oldPath, To: newPath}, emptyMessage{})
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, p string) (err error) {
+	defer wrapErr("mkdir", p, &err)
+	return e.unary(ctx, "MkdirAll", &pathRequest{Path: p}, emptyMessage{})
+}
+
+func (e *Engine) ReadDir(ctx context.Context, p string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", p, &err)
+	var resp entryList
+	if err := e.unary(ctx, "ReadDir", &pathRequest{Path: p}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+// === Extension: Copier ===
+
+// Copy copies a file or directory on the server.
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	return e.unary(ctx, "Copy", &renameRequest{From: src, To: dst}, emptyMessage{})
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	return e.GetRange(ctx, p, 0, -1)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, p string, reader io.Reader) error {
+	w := e.newWriter(ctx, p, modeReplace)
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.pw.CloseWithError(err)
+		<-w.done
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("sbox/grpc: negative range offset %d: %w", offset, sbox.ErrInvalid)
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	s, err := e.call(ctx, "Read", bytes.NewReader(appendFrame(nil, &readRequest{Path: p, Offset: offset, Length: max(length, 0)})))
+	if err != nil {
+		return nil, err
+	}
+	// Wait for the first chunk, so errors such as a missing file are
+	// returned here.
+	r := &chunkReader{s: s}
+	if err := s.recv(&r.chunk); err != nil && err != io.EOF {
+		s.close()
+		return nil, err
+	} else if err == io.EOF {
+		r.err = io.EOF
+	}
+	r.data = r.chunk.Data
+	return r, nil
+}
+
+// chunkReader reads the data of the Chunk messages of a Read call.
+type chunkReader struct {
+	s     *responseStream
+	chunk chunk
+	data  []byte
+	err   error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.chunk = chunk{}
+		if err := r.s.recv(&r.chunk); err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.data = r.chunk.Data
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.s.close()
+	return nil
+}
+
+// === Extension: Appender ===
+
+// Append streams r to the end of p on the server.
+func (e *Engine) Append(ctx context.Context, p string, r io.Reader) (int64, error) {
+	w := e.newWriter(ctx, p, modeAppend)
+	n, err := io.Copy(w, r)
+	if err != nil {
+		_ = w.pw.CloseWithError(err)
+		<-w.done
+		return n, err
+	}
+	if err := w.Close(); err != nil {
+		return n, err
+	}
+	return w.resp.Written, nil
+}
+
+// === Extension: Hasher ===
+
+// Hash computes the hash on the server, which uses its engine's hasher or
+// reads the file.
+func (e *Engine) Hash(ctx context.Context, p string, algorithm string) (string, error) {
+	var resp hashResponse
+	if err := e.unary(ctx, "Hash", &hashRequest{Path: p, Algorithm: algorithm}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Hash, nil
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks that the server answers a Stat of the root.
+func (e *Engine) Ping(ctx context.Context) error {
+	_, err := e.Stat(ctx, "")
+	return err
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine = (*Engine)(nil)
+	_ sbox.Copier        = (*Engine)(nil)
+	_ sbox.StreamReader  = (*Engine)(nil)
+	_ sbox.StreamWriter  = (*Engine)(nil)
+	_ sbox.RangeReader   = (*Engine)(nil)
+	_ sbox.Appender      = (*Engine)(nil)
+	_ sbox.Hasher        = (*Engine)(nil)
+	_ io.Closer          = (*Engine)(nil)
+	_ sbox.HealthChecker = (*Engine)(nil)
+)
@@ -0,0 +1,172 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/grpc"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+// serve serves backend on a local port and returns its address.
+func serve(t *testing.T, backend sbox.StorageEngine, opts ...grpc.ServerOption) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() { _ = grpc.NewServer(backend, opts...).Serve(l) }()
+	return l.Addr().String()
+}
+
+func newClient(t *testing.T) (*grpc.Engine, sbox.StorageEngine) {
+	t.Helper()
+	backend := memory.New()
+	engine, err := grpc.New(serve(t, backend))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = engine.Close() })
+	return engine, backend
+}
+
+func TestClient_Suite(t *testing.T) {
+	engine, _ := newClient(t)
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestClient_Operations(t *testing.T) {
+	ctx := context.Background()
+	engine, backend := newClient(t)
+
+	// Larger than one message, to exercise the streams.
+	data := strings.Repeat("0123456789", 100_000)
+	if err := engine.Put(ctx, "deep/dir/big.txt", strings.NewReader(data)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	info, err := backend.Stat(ctx, "deep/dir/big.txt")
+	if err != nil || info.Size != int64(len(data)) {
+		t.Fatalf("backend Stat = %+v, %v", info, err)
+	}
+	rc, err := engine.GetRange(ctx, "deep/dir/big.txt", 300_001, 5)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != "12345" {
+		t.Errorf("GetRange = %q, want %q", got, "12345")
+	}
+	r, err := engine.Open(ctx, "deep/dir/big.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := r.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	got, _ = io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "789" {
+		t.Errorf("read after Seek = %q, want %q", got, "789")
+	}
+
+	n, err := engine.Append(ctx, "log", strings.NewReader("one\n"))
+	if err != nil || n != 4 {
+		t.Fatalf("Append = %d, %v", n, err)
+	}
+	w, err := engine.OpenFile(ctx, "log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = w.Write([]byte("two\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if sum, err := engine.Hash(ctx, "log", "sha256"); err != nil || len(sum) != 64 {
+		t.Errorf("Hash = %q, %v", sum, err)
+	}
+	w, err = engine.OpenFile(ctx, "log", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(O_EXCL): %v", err)
+	}
+	if err := w.Close(); !errors.Is(err, sbox.ErrExist) {
+		t.Errorf("exclusive create of an existing file = %v, want %v", err, sbox.ErrExist)
+	}
+	if _, err := engine.OpenFile(ctx, "missing", os.O_WRONLY, 0644); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("OpenFile(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	if _, err := engine.Stat(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := engine.GetRange(ctx, "missing", 0, -1); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("GetRange(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := engine.Open(ctx, "deep"); !errors.Is(err, sbox.ErrIsDir) {
+		t.Errorf("Open(dir) = %v, want %v", err, sbox.ErrIsDir)
+	}
+	if err := engine.Copy(ctx, "deep", "copy"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	entries, err := engine.ReadDir(ctx, "copy/dir")
+	if err != nil || len(entries) != 1 || entries[0].Name != "big.txt" || entries[0].Size != int64(len(data)) {
+		t.Errorf("ReadDir = %+v, %v", entries, err)
+	}
+}
+
+func TestClient_CanceledWrite(t *testing.T) {
+	engine, backend := newClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := sbox.PutAtomic(context.Background(), engine, "f", strings.NewReader("old")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+
+	w, err := engine.Create(ctx, "f")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, _ = w.Write([]byte("partial"))
+	cancel()
+	if err := w.Close(); err == nil {
+		t.Fatal("Close after cancel succeeded")
+	}
+	r, err := backend.Open(context.Background(), "f")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "old" {
+		t.Errorf("content after a canceled write = %q, want %q", got, "old")
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	addr := serve(t, memory.New(), grpc.WithServerToken("secret"))
+	ctx := context.Background()
+
+	engine, err := sbox.OpenURL("grpc://" + addr + "?token=secret")
+	if err != nil {
+		t.Fatalf("OpenURL: %v", err)
+	}
+	if err := engine.MkdirAll(ctx, "docs"); err != nil {
+		t.Errorf("MkdirAll: %v", err)
+	}
+
+	anonymous, err := sbox.Open(&sbox.Config{Type: "grpc", BasePath: addr})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	var rerr *grpc.Error
+	if _, err := anonymous.Stat(ctx, "docs"); !errors.Is(err, sbox.ErrPermission) || !errors.As(err, &rerr) || rerr.Code != 16 {
+		t.Errorf("Stat without token = %v, want UNAUTHENTICATED", err)
+	}
+}
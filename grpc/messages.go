@@ -0,0 +1,425 @@
+package grpc
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/nuln/sbox"
+)
+
+// message is a message of storage.proto, encoded by hand.
+type message interface {
+	marshal(b []byte) []byte
+	unmarshal(b []byte) error
+}
+
+// fields calls f for each field of the encoded message b. f returns the
+// number of bytes of the value it consumed, or -1 to skip the field.
+func fields(b []byte, f func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = f(num, typ, b)
+		if n == -1 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeString stores the string field at b in *v.
+func consumeString(typ protowire.Type, b []byte, v *string) int {
+	if typ != protowire.BytesType {
+		return -1
+	}
+	s, n := protowire.ConsumeString(b)
+	*v = s
+	return n
+}
+
+// consumeInt stores the int64 field at b in *v.
+func consumeInt(typ protowire.Type, b []byte, v *int64) int {
+	if typ != protowire.VarintType {
+		return -1
+	}
+	x, n := protowire.ConsumeVarint(b)
+	*v = int64(x)
+	return n
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendMap encodes a map<string, string> field, sorted by key so the
+// encoding is deterministic.
+func appendMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, m[k])
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+// consumeMapEntry adds the map entry at b to *m.
+func consumeMapEntry(typ protowire.Type, b []byte, m *map[string]string) int {
+	if typ != protowire.BytesType {
+		return -1
+	}
+	entry, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n
+	}
+	var k, v string
+	err := fields(entry, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &k)
+		case 2:
+			return consumeString(typ, b, &v)
+		}
+		return -1
+	})
+	if err != nil {
+		return -1
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[k] = v
+	return n
+}
+
+type emptyMessage struct{}
+
+func (emptyMessage) marshal(b []byte) []byte { return b }
+
+func (emptyMessage) unmarshal(b []byte) error {
+	return fields(b, func(protowire.Number, protowire.Type, []byte) int { return -1 })
+}
+
+type pathRequest struct {
+	Path string
+}
+
+func (m *pathRequest) marshal(b []byte) []byte {
+	return appendString(b, 1, m.Path)
+}
+
+func (m *pathRequest) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num == 1 {
+			return consumeString(typ, b, &m.Path)
+		}
+		return -1
+	})
+}
+
+// entry is the Entry message.
+type entry struct {
+	sbox.EntryInfo
+}
+
+func (m *entry) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Path)
+	b = appendInt(b, 3, m.Size)
+	if !m.ModTime.IsZero() {
+		b = appendInt(b, 4, m.ModTime.UnixNano())
+	}
+	b = appendInt(b, 5, int64(m.Mode))
+	if m.IsDir {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	b = appendMap(b, 7, m.Metadata)
+	b = appendInt(b, 8, int64(m.Uid))
+	b = appendInt(b, 9, int64(m.Gid))
+	b = appendString(b, 10, m.LinkTarget)
+	return appendString(b, 11, m.ContentType)
+}
+
+func (m *entry) unmarshal(b []byte) error {
+	var modTime, mode, isDir, uid, gid int64
+	err := fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Name)
+		case 2:
+			return consumeString(typ, b, &m.Path)
+		case 3:
+			return consumeInt(typ, b, &m.Size)
+		case 4:
+			return consumeInt(typ, b, &modTime)
+		case 5:
+			return consumeInt(typ, b, &mode)
+		case 6:
+			return consumeInt(typ, b, &isDir)
+		case 7:
+			return consumeMapEntry(typ, b, &m.Metadata)
+		case 8:
+			return consumeInt(typ, b, &uid)
+		case 9:
+			return consumeInt(typ, b, &gid)
+		case 10:
+			return consumeString(typ, b, &m.LinkTarget)
+		case 11:
+			return consumeString(typ, b, &m.ContentType)
+		}
+		return -1
+	})
+	if modTime != 0 {
+		m.ModTime = time.Unix(0, modTime)
+	}
+	m.Mode = os.FileMode(uint32(mode))
+	m.IsDir = isDir != 0
+	m.Uid, m.Gid = int(uid), int(gid)
+	return err
+}
+
+type entryList struct {
+	Entries []*sbox.EntryInfo
+}
+
+func (m *entryList) marshal(b []byte) []byte {
+	for _, info := range m.Entries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, (&entry{*info}).marshal(nil))
+	}
+	return b
+}
+
+func (m *entryList) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num != 1 || typ != protowire.BytesType {
+			return -1
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n
+		}
+		var e entry
+		if err := e.unmarshal(v); err != nil {
+			return -1
+		}
+		m.Entries = append(m.Entries, &e.EntryInfo)
+		return n
+	})
+}
+
+type readRequest struct {
+	Path   string
+	Offset int64
+	Length int64
+}
+
+func (m *readRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Path)
+	b = appendInt(b, 2, m.Offset)
+	return appendInt(b, 3, m.Length)
+}
+
+func (m *readRequest) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Path)
+		case 2:
+			return consumeInt(typ, b, &m.Offset)
+		case 3:
+			return consumeInt(typ, b, &m.Length)
+		}
+		return -1
+	})
+}
+
+type chunk struct {
+	Data []byte
+}
+
+func (m *chunk) marshal(b []byte) []byte {
+	return appendBytes(b, 1, m.Data)
+}
+
+func (m *chunk) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num != 1 || typ != protowire.BytesType {
+			return -1
+		}
+		v, n := protowire.ConsumeBytes(b)
+		m.Data = v
+		return n
+	})
+}
+
+// Write modes of WriteRequest.
+const (
+	modeReplace   int64 = 0
+	modeAppend    int64 = 1
+	modeExclusive int64 = 2
+)
+
+type writeRequest struct {
+	Path     string
+	Mode     int64
+	Metadata map[string]string
+	Data     []byte
+}
+
+func (m *writeRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Path)
+	b = appendInt(b, 2, m.Mode)
+	b = appendMap(b, 3, m.Metadata)
+	return appendBytes(b, 4, m.Data)
+}
+
+func (m *writeRequest) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Path)
+		case 2:
+			return consumeInt(typ, b, &m.Mode)
+		case 3:
+			return consumeMapEntry(typ, b, &m.Metadata)
+		case 4:
+			if typ != protowire.BytesType {
+				return -1
+			}
+			v, n := protowire.ConsumeBytes(b)
+			m.Data = v
+			return n
+		}
+		return -1
+	})
+}
+
+type writeResponse struct {
+	Written int64
+}
+
+func (m *writeResponse) marshal(b []byte) []byte {
+	return appendInt(b, 1, m.Written)
+}
+
+func (m *writeResponse) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num == 1 {
+			return consumeInt(typ, b, &m.Written)
+		}
+		return -1
+	})
+}
+
+type renameRequest struct {
+	From, To string
+}
+
+func (m *renameRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.From)
+	return appendString(b, 2, m.To)
+}
+
+func (m *renameRequest) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.From)
+		case 2:
+			return consumeString(typ, b, &m.To)
+		}
+		return -1
+	})
+}
+
+type hashRequest struct {
+	Path      string
+	Algorithm string
+}
+
+func (m *hashRequest) marshal(b []byte) []byte {
+	b = appendString(b, 1, m.Path)
+	return appendString(b, 2, m.Algorithm)
+}
+
+func (m *hashRequest) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Path)
+		case 2:
+			return consumeString(typ, b, &m.Algorithm)
+		}
+		return -1
+	})
+}
+
+type hashResponse struct {
+	Hash string
+}
+
+func (m *hashResponse) marshal(b []byte) []byte {
+	return appendString(b, 1, m.Hash)
+}
+
+func (m *hashResponse) unmarshal(b []byte) error {
+	return fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num == 1 {
+			return consumeString(typ, b, &m.Hash)
+		}
+		return -1
+	})
+}
+
+// Compile-time interface checks.
+var (
+	_ message = emptyMessage{}
+	_ message = (*pathRequest)(nil)
+	_ message = (*entry)(nil)
+	_ message = (*entryList)(nil)
+	_ message = (*readRequest)(nil)
+	_ message = (*chunk)(nil)
+	_ message = (*writeRequest)(nil)
+	_ message = (*writeResponse)(nil)
+	_ message = (*renameRequest)(nil)
+	_ message = (*hashRequest)(nil)
+	_ message = (*hashResponse)(nil)
+)
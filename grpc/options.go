@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+)
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithTLS connects to the server over TLS with the given configuration,
+// which may hold client certificates. The default is plaintext HTTP/2.
+func WithTLS(config *tls.Config) Option {
+	return func(e *Engine) {
+		e.tls = config
+	}
+}
+
+// WithToken sends token as a bearer token with every call, for servers
+// configured with WithServerToken.
+func WithToken(token string) Option {
+	return func(e *Engine) {
+		e.token = token
+	}
+}
+
+// WithHTTPClient sets the client used for calls. Its transport must speak
+// HTTP/2 to the server; WithTLS is ignored.
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Engine) {
+		e.client = client
+	}
+}
+
+// ServerOption configures optional Server behavior.
+type ServerOption func(*Server)
+
+// WithServerToken rejects calls that do not carry token as a bearer
+// token with UNAUTHENTICATED. Without TLS, the token is sent in the clear.
+func WithServerToken(token string) ServerOption {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithLogger sets the logger for failed calls. The default is
+// slog.Default().
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
@@ -0,0 +1,332 @@
+// Package grpc serves an sbox.StorageEngine over the network and provides
+// the "grpc" driver, its client, so a fleet of machines can share one
+// storage daemon:
+//
+//	// Server
+//	l, _ := net.Listen("tcp", ":7070")
+//	go grpc.NewServer(engine).Serve(l)
+//
+//	// Clients
+//	remote, _ := sbox.OpenURL("grpc://storage-1:7070")
+//
+// The protocol is the Storage service of storage.proto, spoken over HTTP/2
+// as gRPC. The package implements the wire format on net/http and encodes
+// the messages by hand, so it depends on neither grpc-go nor generated
+// code, and clients in other languages can be generated from the file.
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// Server serves an engine over the Storage service of storage.proto. It is
+// an http.Handler for HTTP/2 requests.
+type Server struct {
+	engine  sbox.StorageEngine
+	token   string
+	logger  *slog.Logger
+	methods map[string]func(ctx context.Context, w http.ResponseWriter, body io.Reader) error
+}
+
+// NewServer returns a Server for engine.
+func NewServer(engine sbox.StorageEngine, opts ...ServerOption) *Server {
+	s := &Server{engine: engine, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.methods = map[string]func(context.Context, http.ResponseWriter, io.Reader) error{
+		"Stat":     s.stat,
+		"ReadDir":  s.readDir,
+		"Read":     s.read,
+		"Write":    s.write,
+		"Remove":   s.remove,
+		"Rename":   s.rename,
+		"Copy":     s.copy,
+		"MkdirAll": s.mkdirAll,
+		"Hash":     s.hash,
+	}
+	return s
+}
+
+// Serve accepts connections on l and serves them over HTTP/2 without TLS.
+// To serve over TLS, use an http.Server with ServeTLS, which enables
+// HTTP/2 by default.
+func (s *Server) Serve(l net.Listener) error {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{Handler: s, Protocols: protocols}
+	return srv.Serve(l)
+}
+
+// ServeHTTP handles a call of a Storage method.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "sbox/grpc: HTTP/2 required", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "sbox/grpc: not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	ctx := r.Context()
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		if d, ok := decodeTimeout(v); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+	}
+
+	var err error
+	method, ok := s.methods[strings.TrimPrefix(r.URL.Path, servicePath)]
+	switch {
+	case s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1:
+		err = &Error{Code: codeUnauthenticated, Message: "invalid or missing token", err: sbox.ErrPermission}
+	case !strings.HasPrefix(r.URL.Path, servicePath) || !ok:
+		err = &Error{Code: codeUnimplemented, Message: "unknown method " + r.URL.Path, err: sbox.ErrNotSupported}
+	default:
+		err = method(ctx, w, r.Body)
+	}
+
+	code, name := codeOK, ""
+	message := ""
+	if err != nil {
+		code, name = statusOf(err)
+		message = err.Error()
+		var rerr *Error
+		if errors.As(err, &rerr) {
+			message = rerr.Message
+		}
+		if code == codeUnknown {
+			s.logger.Error("sbox/grpc: call failed", "method", r.URL.Path, "err", err)
+		}
+	}
+	h := w.Header()
+	h.Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		h.Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(message))
+	}
+	if name != "" {
+		h.Set(http.TrailerPrefix+"Sbox-Error", name)
+	}
+}
+
+// recv reads the single request message of a unary or server-streaming
+// call.
+func recv(body io.Reader, m message) error {
+	b, err := readFrame(body, nil)
+	if err == io.EOF {
+		return &Error{Code: codeInvalidArgument, Message: "missing request message", err: sbox.ErrInvalid}
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.unmarshal(b); err != nil {
+		return &Error{Code: codeInvalidArgument, Message: err.Error(), err: sbox.ErrInvalid}
+	}
+	return nil
+}
+
+// send writes a response message and flushes it to the client.
+func send(w http.ResponseWriter, m message) error {
+	if _, err := w.Write(appendFrame(nil, m)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+func (s *Server) stat(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req pathRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	info, err := s.engine.Stat(ctx, req.Path)
+	if err != nil {
+		return err
+	}
+	return send(w, &entry{*info})
+}
+
+func (s *Server) readDir(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req pathRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	entries, err := s.engine.ReadDir(ctx, req.Path)
+	if err != nil {
+		return err
+	}
+	return send(w, &entryList{Entries: entries})
+}
+
+func (s *Server) read(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req readRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	length := req.Length
+	if length == 0 {
+		length = -1
+	}
+	r, err := sbox.Ranged(s.engine).GetRange(ctx, req.Path, req.Offset, length)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := send(w, &chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) write(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req writeRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	if len(req.Metadata) > 0 {
+		ctx = sbox.WithMetadata(ctx, req.Metadata)
+	}
+	r := &dataReader{body: body, data: req.Data}
+	var written int64
+	switch req.Mode {
+	case modeReplace:
+		if err := sbox.PutAtomic(ctx, s.engine, req.Path, r); err != nil {
+			return err
+		}
+		written = r.n
+	case modeAppend:
+		n, err := sbox.Append(ctx, s.engine, req.Path, r)
+		if err != nil {
+			return err
+		}
+		written = n
+	case modeExclusive:
+		f, err := s.engine.OpenFile(ctx, req.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = s.engine.Remove(context.WithoutCancel(ctx), req.Path)
+			return err
+		}
+		written = n
+	default:
+		return &Error{Code: codeInvalidArgument, Message: "unknown write mode " + strconv.FormatInt(req.Mode, 10), err: sbox.ErrInvalid}
+	}
+	return send(w, &writeResponse{Written: written})
+}
+
+// dataReader reads the data of the WriteRequest messages of a call.
+type dataReader struct {
+	body io.Reader
+	data []byte // Unread data of the current message
+	buf  []byte
+	n    int64
+}
+
+func (r *dataReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		b, err := readFrame(r.body, r.buf)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = b
+		var req writeRequest
+		if err := req.unmarshal(b); err != nil {
+			return 0, err
+		}
+		r.data = req.Data
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	r.n += int64(n)
+	return n, nil
+}
+
+func (s *Server) remove(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req pathRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	if err := s.engine.Remove(ctx, req.Path); err != nil {
+		return err
+	}
+	return send(w, emptyMessage{})
+}
+
+func (s *Server) rename(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req renameRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	if err := s.engine.Rename(ctx, req.From, req.To); err != nil {
+		return err
+	}
+	return send(w, emptyMessage{})
+}
+
+func (s *Server) copy(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req renameRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	if err := sbox.Copied(s.engine).Copy(ctx, req.From, req.To); err != nil {
+		return err
+	}
+	return send(w, emptyMessage{})
+}
+
+func (s *Server) mkdirAll(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req pathRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	if err := s.engine.MkdirAll(ctx, req.Path); err != nil {
+		return err
+	}
+	return send(w, emptyMessage{})
+}
+
+func (s *Server) hash(ctx context.Context, w http.ResponseWriter, body io.Reader) error {
+	var req hashRequest
+	if err := recv(body, &req); err != nil {
+		return err
+	}
+	sum, err := sbox.Hashed(s.engine).Hash(ctx, req.Path, req.Algorithm)
+	if err != nil {
+		return err
+	}
+	return send(w, &hashResponse{Hash: sum})
+}
+
+// Compile-time interface checks.
+var _ http.Handler = (*Server)(nil)
@@ -0,0 +1,214 @@
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// servicePath is the path prefix of the methods of the Storage service.
+const servicePath = "/sbox.v1.Storage/"
+
+// maxMessageSize bounds the messages either side accepts, like the 4MB
+// default of gRPC implementations.
+const maxMessageSize = 4 << 20
+
+// chunkSize is the amount of file data sent in one message.
+const chunkSize = 256 << 10
+
+// gRPC status codes.
+const (
+	codeOK                 = 0
+	codeCanceled           = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeNotFound           = 5
+	codeAlreadyExists      = 6
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeAborted            = 10
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeUnavailable        = 14
+	codeDataLoss           = 15
+	codeUnauthenticated    = 16
+)
+
+// errorKinds maps the sbox errors to status codes. The server also sends
+// the name in the "sbox-error" trailer, which lets the client restore
+// errors that share a code.
+var errorKinds = []struct {
+	err  error
+	code int
+	name string
+}{
+	{sbox.ErrNotFound, codeNotFound, "not-found"},
+	{sbox.ErrExist, codeAlreadyExists, "exist"},
+	{sbox.ErrPermission, codePermissionDenied, "permission"},
+	{sbox.ErrInvalid, codeInvalidArgument, "invalid"},
+	{sbox.ErrIsDir, codeFailedPrecondition, "is-dir"},
+	{sbox.ErrNotDir, codeFailedPrecondition, "not-dir"},
+	{sbox.ErrPreconditionFailed, codeFailedPrecondition, "precondition-failed"},
+	{sbox.ErrLocked, codeAborted, "locked"},
+	{sbox.ErrClosed, codeFailedPrecondition, "closed"},
+	{sbox.ErrNotSupported, codeUnimplemented, "not-supported"},
+	{sbox.ErrCorruptManifest, codeInternal, "corrupt-manifest"},
+	{sbox.ErrChecksumMismatch, codeDataLoss, "checksum-mismatch"},
+	{context.Canceled, codeCanceled, "canceled"},
+	{context.DeadlineExceeded, codeDeadlineExceeded, "deadline-exceeded"},
+}
+
+// Error is an error status returned by the server.
+type Error struct {
+	Code    int    // gRPC status code
+	Message string // Message sent by the server
+	err     error  // sbox error the status stands for, if any
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sbox/grpc: remote error (code %d): %s", e.Code, e.Message)
+}
+
+// Unwrap returns the sbox error the status stands for, so errors.Is works
+// across the connection.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// statusOf returns the status code and error name sent for err.
+func statusOf(err error) (int, string) {
+	var rerr *Error
+	if errors.As(err, &rerr) {
+		return rerr.Code, ""
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.code, k.name
+		}
+	}
+	return codeUnknown, ""
+}
+
+// statusError returns the error for a status received by the client.
+func statusError(code int, message, name string) error {
+	e := &Error{Code: code, Message: message}
+	for _, k := range errorKinds {
+		if k.name == name && name != "" {
+			e.err = k.err
+			return e
+		}
+	}
+	// A server other than ours: map the codes with one meaning.
+	switch code {
+	case codeNotFound:
+		e.err = sbox.ErrNotFound
+	case codeAlreadyExists:
+		e.err = sbox.ErrExist
+	case codePermissionDenied, codeUnauthenticated:
+		e.err = sbox.ErrPermission
+	case codeInvalidArgument:
+		e.err = sbox.ErrInvalid
+	case codeUnimplemented:
+		e.err = sbox.ErrNotSupported
+	case codeCanceled:
+		e.err = context.Canceled
+	case codeDeadlineExceeded:
+		e.err = context.DeadlineExceeded
+	}
+	return e
+}
+
+// encodeMessage percent-encodes a status message for the grpc-message
+// trailer.
+func encodeMessage(s string) string {
+	return url.PathEscape(s)
+}
+
+func decodeMessage(s string) string {
+	if m, err := url.PathUnescape(s); err == nil {
+		return m
+	}
+	return s
+}
+
+// appendFrame appends m to b as a length-prefixed message.
+func appendFrame(b []byte, m message) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0, 0)
+	b = m.marshal(b)
+	binary.BigEndian.PutUint32(b[start+1:], uint32(len(b)-start-5))
+	return b
+}
+
+// readFrame reads a length-prefixed message from r into buf, which it may
+// grow. It returns io.EOF if r ends before the message starts.
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("sbox/grpc: truncated message")
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, &Error{Code: codeUnimplemented, Message: "compressed messages are not supported", err: sbox.ErrNotSupported}
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxMessageSize {
+		return nil, &Error{Code: codeResourceExhausted, Message: fmt.Sprintf("message of %d bytes exceeds the limit of %d", n, maxMessageSize)}
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errors.New("sbox/grpc: truncated message")
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// encodeTimeout formats the grpc-timeout header for d.
+func encodeTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	// The value has at most 8 digits.
+	for _, u := range []struct {
+		unit string
+		d    time.Duration
+	}{{"n", time.Nanosecond}, {"u", time.Microsecond}, {"m", time.Millisecond}, {"S", time.Second}, {"M", time.Minute}} {
+		if v := d / u.d; v < 1e8 {
+			return strconv.FormatInt(int64(v), 10) + u.unit
+		}
+	}
+	return strconv.FormatInt(int64(min(d/time.Hour, 1e8-1)), 10) + "H"
+}
+
+// decodeTimeout parses the grpc-timeout header.
+func decodeTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(v) * unit, true
+}
@@ -0,0 +1,280 @@
+package sbox
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Hooks are callbacks run around the changes made through an engine
+// wrapped by [WithHooks], e.g. to generate thumbnails, update a search
+// index or invalidate caches. Nil hooks are skipped.
+//
+// Before hooks run before the change and can veto it by returning an
+// error, which the operation returns without touching the engine. After
+// hooks run once the change succeeded, in the goroutine that made it:
+// for files written through a writer, when Close returns without error.
+// Directories are created implicitly and are not reported.
+type Hooks struct {
+	// BeforeWrite and AfterWrite run for files written by Create,
+	// OpenFile, Put, Append, CreateAtomic and Copy (for the destination).
+	BeforeWrite func(ctx context.Context, path string) error
+	AfterWrite  func(ctx context.Context, path string)
+
+	// BeforeRemove and AfterRemove run for Remove, of files and trees.
+	BeforeRemove func(ctx context.Context, path string) error
+	AfterRemove  func(ctx context.Context, path string)
+
+	// BeforeRename and AfterRename run for Rename.
+	BeforeRename func(ctx context.Context, oldPath, newPath string) error
+	AfterRename  func(ctx context.Context, oldPath, newPath string)
+}
+
+// HookedEngine runs [Hooks] around the changes made through an engine.
+// Extensions that change files in other ways (metadata, permissions,
+// versions, multipart uploads, ...) are not passed through, so that no
+// change escapes the hooks; [Copier], [StreamWriter], [Appender] and
+// [AtomicWriter] are.
+type HookedEngine struct {
+	engine StorageEngine
+	hooks  Hooks
+}
+
+// WithHooks returns engine with hooks run around its changes.
+func WithHooks(engine StorageEngine, hooks Hooks) *HookedEngine {
+	return &HookedEngine{engine: engine, hooks: hooks}
+}
+
+// Inner returns the wrapped engine.
+func (h *HookedEngine) Inner() StorageEngine {
+	return h.engine
+}
+
+// Close closes the wrapped engine.
+func (h *HookedEngine) Close() error {
+	return Close(h.engine)
+}
+
+func (h *HookedEngine) beforeWrite(ctx context.Context, path string) error {
+	if h.hooks.BeforeWrite == nil {
+		return nil
+	}
+	return h.hooks.BeforeWrite(ctx, path)
+}
+
+func (h *HookedEngine) afterWrite(ctx context.Context, path string) {
+	if h.hooks.AfterWrite != nil {
+		h.hooks.AfterWrite(ctx, path)
+	}
+}
+
+func (h *HookedEngine) Stat(ctx context.Context, path string) (*EntryInfo, error) {
+	return h.engine.Stat(ctx, path)
+}
+
+func (h *HookedEngine) Open(ctx context.Context, path string) (ReadSeekCloser, error) {
+	return h.engine.Open(ctx, path)
+}
+
+func (h *HookedEngine) Create(ctx context.Context, path string) (WriteCloser, error) {
+	if err := h.beforeWrite(ctx, path); err != nil {
+		return nil, err
+	}
+	w, err := h.engine.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &hookWriter{WriteCloser: w, done: func() { h.afterWrite(ctx, path) }}, nil
+}
+
+func (h *HookedEngine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (WriteSeekCloser, error) {
+	if err := h.beforeWrite(ctx, path); err != nil {
+		return nil, err
+	}
+	w, err := h.engine.OpenFile(ctx, path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &hookWriteSeeker{WriteSeekCloser: w, done: func() { h.afterWrite(ctx, path) }}, nil
+}
+
+func (h *HookedEngine) Remove(ctx context.Context, path string) error {
+	if h.hooks.BeforeRemove != nil {
+		if err := h.hooks.BeforeRemove(ctx, path); err != nil {
+			return err
+		}
+	}
+	if err := h.engine.Remove(ctx, path); err != nil {
+		return err
+	}
+	if h.hooks.AfterRemove != nil {
+		h.hooks.AfterRemove(ctx, path)
+	}
+	return nil
+}
+
+func (h *HookedEngine) Rename(ctx context.Context, oldPath, newPath string) error {
+	if h.hooks.BeforeRename != nil {
+		if err := h.hooks.BeforeRename(ctx, oldPath, newPath); err != nil {
+			return err
+		}
+	}
+	if err := h.engine.Rename(ctx, oldPath, newPath); err != nil {
+		return err
+	}
+	if h.hooks.AfterRename != nil {
+		h.hooks.AfterRename(ctx, oldPath, newPath)
+	}
+	return nil
+}
+
+func (h *HookedEngine) MkdirAll(ctx context.Context, path string) error {
+	return h.engine.MkdirAll(ctx, path)
+}
+
+func (h *HookedEngine) ReadDir(ctx context.Context, path string) ([]*EntryInfo, error) {
+	return h.engine.ReadDir(ctx, path)
+}
+
+// hookWriter runs done after a successful Close.
+type hookWriter struct {
+	WriteCloser
+	done func()
+}
+
+func (w *hookWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.done()
+	return nil
+}
+
+// hookWriteSeeker is a hookWriter for OpenFile.
+type hookWriteSeeker struct {
+	WriteSeekCloser
+	done func()
+}
+
+func (w *hookWriteSeeker) Close() error {
+	if err := w.WriteSeekCloser.Close(); err != nil {
+		return err
+	}
+	w.done()
+	return nil
+}
+
+// === Extension: Copier ===
+
+func (h *HookedEngine) Copy(ctx context.Context, src, dst string) error {
+	if err := h.beforeWrite(ctx, dst); err != nil {
+		return err
+	}
+	if err := Copied(h.engine).Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	h.afterWrite(ctx, dst)
+	return nil
+}
+
+// === Extension: StreamReader ===
+
+func (h *HookedEngine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	if sr, ok := h.engine.(StreamReader); ok {
+		return sr.Get(ctx, path)
+	}
+	return h.engine.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (h *HookedEngine) Put(ctx context.Context, path string, reader io.Reader) error {
+	sw, ok := h.engine.(StreamWriter)
+	if !ok {
+		w, err := h.Create(ctx, path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, reader); err != nil {
+			_ = w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	if err := h.beforeWrite(ctx, path); err != nil {
+		return err
+	}
+	if err := sw.Put(ctx, path, reader); err != nil {
+		return err
+	}
+	h.afterWrite(ctx, path)
+	return nil
+}
+
+// === Extension: Appender ===
+
+func (h *HookedEngine) Append(ctx context.Context, path string, r io.Reader) (int64, error) {
+	if err := h.beforeWrite(ctx, path); err != nil {
+		return 0, err
+	}
+	n, err := Append(ctx, h.engine, path, r)
+	if err != nil {
+		return n, err
+	}
+	h.afterWrite(ctx, path)
+	return n, nil
+}
+
+// === Extension: AtomicWriter ===
+
+// CreateAtomic passes through to the wrapped engine, and fails with
+// ErrNotSupported if it does not support atomic writes.
+func (h *HookedEngine) CreateAtomic(ctx context.Context, path string) (AtomicWriteCloser, error) {
+	aw, ok := h.engine.(AtomicWriter)
+	if !ok || !Supports(h.engine, CapAtomicWrite) {
+		return nil, ErrNotSupported
+	}
+	if err := h.beforeWrite(ctx, path); err != nil {
+		return nil, err
+	}
+	w, err := aw.CreateAtomic(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &hookAtomicWriter{AtomicWriteCloser: w, done: func() { h.afterWrite(ctx, path) }}, nil
+}
+
+// hookAtomicWriter is a hookWriter for CreateAtomic.
+type hookAtomicWriter struct {
+	AtomicWriteCloser
+	done func()
+}
+
+func (w *hookAtomicWriter) Close() error {
+	if err := w.AtomicWriteCloser.Close(); err != nil {
+		return err
+	}
+	w.done()
+	return nil
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports atomic writes as the wrapped engine does.
+func (h *HookedEngine) Supports(c Capability) bool {
+	if c == CapAtomicWrite {
+		return Supports(h.engine, c)
+	}
+	return Implements(h, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ StorageEngine      = (*HookedEngine)(nil)
+	_ Copier             = (*HookedEngine)(nil)
+	_ StreamReader       = (*HookedEngine)(nil)
+	_ StreamWriter       = (*HookedEngine)(nil)
+	_ Appender           = (*HookedEngine)(nil)
+	_ AtomicWriter       = (*HookedEngine)(nil)
+	_ CapabilityReporter = (*HookedEngine)(nil)
+)
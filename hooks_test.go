@@ -0,0 +1,76 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func TestWithHooks(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	record := func(name string) func(context.Context, string) {
+		return func(_ context.Context, p string) { calls = append(calls, name+" "+p) }
+	}
+	denied := errors.New("denied")
+	engine := sbox.WithHooks(memory.New(), sbox.Hooks{
+		BeforeWrite: func(_ context.Context, p string) error {
+			if strings.HasSuffix(p, ".exe") {
+				return denied
+			}
+			return nil
+		},
+		AfterWrite:  record("write"),
+		AfterRemove: record("remove"),
+		AfterRename: func(_ context.Context, oldPath, newPath string) {
+			calls = append(calls, "rename "+oldPath+" "+newPath)
+		},
+	})
+
+	if err := sbox.PutAtomic(ctx, engine, "a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Append(ctx, "a.txt", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Copy(ctx, "a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := engine.Create(ctx, "c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 {
+		t.Errorf("write reported before Close: %v", calls)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Rename(ctx, "c.txt", "d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Remove(ctx, "d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	want := "write a.txt,write a.txt,write b.txt,write c.txt,rename c.txt d.txt,remove d.txt"
+	if got := strings.Join(calls, ","); got != want {
+		t.Errorf("hooks = %s, want %s", got, want)
+	}
+
+	if err := engine.Put(ctx, "virus.exe", strings.NewReader("x")); !errors.Is(err, denied) {
+		t.Errorf("Put vetoed by BeforeWrite = %v", err)
+	}
+	if _, err := engine.Stat(ctx, "virus.exe"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("vetoed file was written: %v", err)
+	}
+	if sbox.Supports(engine, sbox.CapMetadata) || !sbox.Supports(engine, sbox.CapAtomicWrite) {
+		t.Errorf("Capabilities = %v", sbox.Capabilities(engine))
+	}
+
+	sboxtest.StorageTestSuite(t, sbox.WithHooks(memory.New(), sbox.Hooks{}))
+}
@@ -0,0 +1,778 @@
+// Package kv provides a storage driver that keeps files as values in an
+// embedded key-value store. It suits workloads with millions of tiny
+// objects, where the per-file overhead of a filesystem (inodes, directory
+// entries, one open and fsync per file) dominates. Files above a size
+// threshold can be spilled to a secondary engine, so the store only holds
+// small values.
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/local"
+)
+
+// Auto-register the KV storage driver.
+//
+// BasePath is the bbolt store file. Set Options["spillPath"] to a
+// directory to keep files larger than Options["spillThreshold"] bytes
+// (default DefaultSpillThreshold) there instead. The store is synced on
+// every write unless Options["durability"] is "none".
+func init() {
+	sbox.Register("kv", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
+		if cfg.BasePath == "" {
+			return nil, fmt.Errorf("sbox/kv: BasePath is required")
+		}
+		durability, _ := cfg.Options["durability"].(string)
+		level, err := sbox.ParseDurability(durability)
+		if err != nil {
+			return nil, err
+		}
+		var storeOpts []BoltStoreOption
+		if durability != "" {
+			storeOpts = append(storeOpts, WithSync(level != sbox.DurabilityNone))
+		}
+		store, err := OpenBoltStore(cfg.BasePath, storeOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []Option
+		if spillPath, _ := cfg.Options["spillPath"].(string); spillPath != "" {
+			threshold := int64(DefaultSpillThreshold)
+			switch v := cfg.Options["spillThreshold"].(type) {
+			case int:
+				threshold = int64(v)
+			case int64:
+				threshold = v
+			case float64:
+				threshold = int64(v)
+			case string:
+				threshold, err = strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					_ = store.Close()
+					return nil, fmt.Errorf("sbox/kv: invalid spillThreshold %q: %w", v, err)
+				}
+			}
+			spill, err := local.New(spillPath, local.WithDurability(level))
+			if err != nil {
+				_ = store.Close()
+				return nil, err
+			}
+			opts = append(opts, WithSpill(spill, threshold))
+		}
+		return New(store, opts...), nil
+	})
+}
+
+// DefaultSpillThreshold is the size above which files are spilled when a
+// secondary engine is configured (64KB).
+const DefaultSpillThreshold = 64 * 1024
+
+// Engine implements sbox.StorageEngine on a Store.
+//
+// Every file and directory is one key: the parent directory and the name,
+// separated by a NUL byte, so the children of a directory are the keys
+// with its prefix. Values carry the entry kind and modification time,
+// followed by the content of inline files or the size of spilled ones.
+// Parent directories are created implicitly, as by the local driver.
+type Engine struct {
+	store     Store
+	mu        sync.Mutex // Serializes updates, which read before they write
+	spill     sbox.StorageEngine
+	threshold int64
+}
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithSpill stores files larger than threshold bytes in engine, at the same
+// path, instead of in the store. The store keeps their entries, so Stat and
+// ReadDir never touch engine.
+func WithSpill(engine sbox.StorageEngine, threshold int64) Option {
+	return func(e *Engine) {
+		e.spill, e.threshold = engine, threshold
+	}
+}
+
+// New creates an Engine on store. The Engine owns store and closes it on
+// Close.
+func New(store Store, opts ...Option) *Engine {
+	e := &Engine{store: store}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Entry kinds, the first byte of every value.
+const (
+	kindFile    = 1 // Content follows
+	kindSpilled = 2 // Size follows; the content is in the spill engine
+	kindDir     = 3
+)
+
+const valueHeaderSize = 9
+
+// entry is a decoded value.
+type entry struct {
+	kind    byte
+	modTime time.Time
+	size    int64
+	content []byte // Inline files only
+}
+
+func (en *entry) encode() []byte {
+	buf := make([]byte, valueHeaderSize, valueHeaderSize+len(en.content))
+	buf[0] = en.kind
+	binary.BigEndian.PutUint64(buf[1:], uint64(en.modTime.UnixNano()))
+	switch en.kind {
+	case kindFile:
+		buf = append(buf, en.content...)
+	case kindSpilled:
+		buf = binary.BigEndian.AppendUint64(buf, uint64(en.size))
+	}
+	return buf
+}
+
+func decodeEntry(value []byte) (*entry, error) {
+	if len(value) < valueHeaderSize {
+		return nil, errors.New("sbox/kv: corrupt entry")
+	}
+	en := &entry{kind: value[0], modTime: time.Unix(0, int64(binary.BigEndian.Uint64(value[1:])))}
+	switch en.kind {
+	case kindFile:
+		en.content = value[valueHeaderSize:]
+		en.size = int64(len(en.content))
+	case kindSpilled:
+		if len(value) != valueHeaderSize+8 {
+			return nil, errors.New("sbox/kv: corrupt entry")
+		}
+		en.size = int64(binary.BigEndian.Uint64(value[valueHeaderSize:]))
+	case kindDir:
+	default:
+		return nil, fmt.Errorf("sbox/kv: unknown entry kind %d", en.kind)
+	}
+	return en, nil
+}
+
+func (en *entry) info(p string) *sbox.EntryInfo {
+	info := &sbox.EntryInfo{Name: path.Base(p), Path: p, ModTime: en.modTime, Size: en.size, Mode: 0644}
+	if en.kind == kindDir {
+		info.IsDir, info.Mode = true, os.ModeDir|0755
+	}
+	if p == "" {
+		info.Name = "/"
+	}
+	return info
+}
+
+// clean normalizes p to a slash-separated path without leading slash; the
+// root is "".
+func clean(p string) (string, error) {
+	if strings.IndexByte(p, 0) >= 0 {
+		return "", sbox.ErrInvalid
+	}
+	return strings.TrimPrefix(path.Clean("/"+p), "/"), nil
+}
+
+// parent returns the parent directory of the cleaned path p.
+func parent(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+func key(p string) string {
+	return parent(p) + "\x00" + path.Base(p)
+}
+
+// childPrefix is the key prefix of the children of directory p.
+func childPrefix(p string) string {
+	return p + "\x00"
+}
+
+// lookup returns the entry at the cleaned path p; the root is always a
+// directory.
+func (e *Engine) lookup(p string) (*entry, error) {
+	if p == "" {
+		return &entry{kind: kindDir}, nil
+	}
+	value, err := e.store.Get(key(p))
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(value)
+}
+
+// children returns the names and entries of the directory p.
+func (e *Engine) children(p string) ([]string, []*entry, error) {
+	prefix := childPrefix(p)
+	keys, err := e.store.Keys(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(keys))
+	entries := make([]*entry, 0, len(keys))
+	for _, k := range keys {
+		value, err := e.store.Get(k)
+		if errors.Is(err, sbox.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		en, err := decodeEntry(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, strings.TrimPrefix(k, prefix))
+		entries = append(entries, en)
+	}
+	return names, entries, nil
+}
+
+// mkdirs adds the missing ancestors of p to b. Callers hold e.mu.
+func (e *Engine) mkdirs(b *Batch, p string, now time.Time) error {
+	var missing []string
+	for dir := parent(p); dir != ""; dir = parent(dir) {
+		en, err := e.lookup(dir)
+		if errors.Is(err, sbox.ErrNotFound) {
+			missing = append(missing, dir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if en.kind != kindDir {
+			return sbox.ErrNotDir
+		}
+		break
+	}
+	for _, dir := range missing {
+		b.Put(key(dir), (&entry{kind: kindDir, modTime: now}).encode())
+	}
+	return nil
+}
+
+// commit records en as the file at p, creating its parents. A previously
+// spilled copy that en no longer refers to is removed.
+func (e *Engine) commit(ctx context.Context, p string, en *entry) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old, err := e.lookup(p)
+	switch {
+	case err == nil && old.kind == kindDir:
+		return sbox.ErrIsDir
+	case err != nil && !errors.Is(err, sbox.ErrNotFound):
+		return err
+	}
+	var b Batch
+	if err := e.mkdirs(&b, p, en.modTime); err != nil {
+		return err
+	}
+	b.Put(key(p), en.encode())
+	if err := e.store.Write(&b); err != nil {
+		return err
+	}
+	if old != nil && old.kind == kindSpilled && en.kind != kindSpilled {
+		return e.spill.Remove(ctx, p)
+	}
+	return nil
+}
+
+// writeFile stores data as the file at p, spilling it if it is too large.
+func (e *Engine) writeFile(ctx context.Context, p string, data []byte) error {
+	if e.spill != nil && int64(len(data)) > e.threshold {
+		w, err := e.spill.Create(ctx, p)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			_ = w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return e.commit(ctx, p, &entry{kind: kindSpilled, modTime: time.Now(), size: int64(len(data))})
+	}
+	return e.commit(ctx, p, &entry{kind: kindFile, modTime: time.Now(), content: data})
+}
+
+// wrapErr makes *err an *sbox.PathError of the kv driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("kv", op, path, *err)
+}
+
+func (e *Engine) Stat(ctx context.Context, p string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return nil, err
+	}
+	en, err := e.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	return en.info(p), nil
+}
+
+func (e *Engine) Open(ctx context.Context, p string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return nil, err
+	}
+	en, err := e.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	switch en.kind {
+	case kindDir:
+		return nil, sbox.ErrIsDir
+	case kindSpilled:
+		return e.spill.Open(ctx, p)
+	}
+	return nopCloser{bytes.NewReader(en.content)}, nil
+}
+
+// nopCloser turns a bytes.Reader into a ReadSeekCloser.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (e *Engine) Create(ctx context.Context, p string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return nil, sbox.ErrIsDir
+	}
+	if en, err := e.lookup(p); err == nil && en.kind == kindDir {
+		return nil, sbox.ErrIsDir
+	}
+	return &writer{ctx: ctx, engine: e, path: p}, nil
+}
+
+// writer buffers a file until it outgrows the spill threshold, and from
+// then on streams it to the spill engine.
+type writer struct {
+	ctx    context.Context
+	engine *Engine
+	path   string
+	buf    []byte
+	spill  sbox.WriteCloser
+	size   int64
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	if w.spill == nil {
+		e := w.engine
+		if e.spill == nil || int64(len(w.buf)+len(p)) <= e.threshold {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		spill, err := e.spill.Create(w.ctx, w.path)
+		if err != nil {
+			return 0, err
+		}
+		w.spill = spill
+		if _, err := spill.Write(w.buf); err != nil {
+			return 0, err
+		}
+		w.size, w.buf = int64(len(w.buf)), nil
+	}
+	n, err := w.spill.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	if w.spill == nil {
+		return w.engine.commit(w.ctx, w.path, &entry{kind: kindFile, modTime: time.Now(), content: w.buf})
+	}
+	if err := w.spill.Close(); err != nil {
+		return err
+	}
+	return w.engine.commit(w.ctx, w.path, &entry{kind: kindSpilled, modTime: time.Now(), size: w.size})
+}
+
+// abort discards the file without storing it. Content already streamed to
+// the spill engine stays there until the path is written again.
+func (w *writer) abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if w.spill != nil {
+		_ = w.spill.Close()
+	}
+}
+
+// OpenFile opens p for writing. The file is kept in memory, including its
+// existing content unless os.O_TRUNC is given, and stored on Close.
+func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return nil, sbox.ErrIsDir
+	}
+	en, err := e.lookup(p)
+	switch {
+	case err == nil && en.kind == kindDir:
+		return nil, sbox.ErrIsDir
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, sbox.ErrExist
+	case err != nil && !errors.Is(err, sbox.ErrNotFound):
+		return nil, err
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+	w := &writeSeeker{ctx: ctx, engine: e, path: p, append: flag&os.O_APPEND != 0}
+	if err == nil && flag&os.O_TRUNC == 0 {
+		if w.buf, err = e.content(ctx, p, en); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// content returns the content of the file entry en at p.
+func (e *Engine) content(ctx context.Context, p string, en *entry) ([]byte, error) {
+	if en.kind != kindSpilled {
+		return bytes.Clone(en.content), nil
+	}
+	r, err := e.spill.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
+
+// writeSeeker implements WriteSeekCloser over an in-memory copy of the
+// file.
+type writeSeeker struct {
+	ctx    context.Context
+	engine *Engine
+	path   string
+	buf    []byte
+	offset int64
+	append bool
+	closed bool
+}
+
+// Write writes p at the current offset, growing the file as needed. In
+// append mode every write goes to the end.
+func (w *writeSeeker) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	if w.append {
+		w.offset = int64(len(w.buf))
+	}
+	end := w.offset + int64(len(p))
+	if end > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, end-int64(len(w.buf)))...)
+	}
+	copy(w.buf[w.offset:end], p)
+	w.offset = end
+	return len(p), nil
+}
+
+func (w *writeSeeker) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = w.offset + offset
+	case io.SeekEnd:
+		newOffset = int64(len(w.buf)) + offset
+	default:
+		return 0, errors.New("sbox/kv: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("sbox/kv: negative seek offset")
+	}
+	w.offset = newOffset
+	return w.offset, nil
+}
+
+func (w *writeSeeker) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	return w.engine.writeFile(w.ctx, w.path, w.buf)
+}
+
+// tree returns the keys below and including p, and whether any of the
+// files is spilled. Callers hold e.mu.
+func (e *Engine) tree(p string, en *entry) (keys []string, entries []*entry, spilled bool, err error) {
+	if p != "" {
+		keys, entries = []string{p}, []*entry{en}
+	}
+	spilled = en.kind == kindSpilled
+	if en.kind != kindDir {
+		return keys, entries, spilled, nil
+	}
+	names, children, err := e.children(p)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for i, name := range names {
+		subKeys, subEntries, subSpilled, err := e.tree(path.Join(p, name), children[i])
+		if err != nil {
+			return nil, nil, false, err
+		}
+		keys = append(keys, subKeys...)
+		entries = append(entries, subEntries...)
+		spilled = spilled || subSpilled
+	}
+	return keys, entries, spilled, nil
+}
+
+// Remove deletes p and everything below it. Removing a missing path
+// fails with sbox.ErrNotFound.
+func (e *Engine) Remove(ctx context.Context, p string) (err error) {
+	defer wrapErr("remove", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	en, err := e.lookup(p)
+	if err != nil {
+		return err
+	}
+	paths, _, spilled, err := e.tree(p, en)
+	if err != nil {
+		return err
+	}
+	var b Batch
+	for _, sub := range paths {
+		b.Delete(key(sub))
+	}
+	if err := e.store.Write(&b); err != nil {
+		return err
+	}
+	if spilled {
+		return e.spill.Remove(ctx, p)
+	}
+	return nil
+}
+
+// Rename moves a file or directory, replacing a file or empty directory at
+// newPath. Spilled files are renamed in the spill engine first.
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	oldPath, err = clean(oldPath)
+	if err != nil {
+		return err
+	}
+	newPath, err = clean(newPath)
+	if err != nil {
+		return err
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	if oldPath == "" || newPath == "" || strings.HasPrefix(newPath, oldPath+"/") {
+		return sbox.ErrInvalid
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	en, err := e.lookup(oldPath)
+	if err != nil {
+		return err
+	}
+	var b Batch
+	dst, err := e.lookup(newPath)
+	switch {
+	case err == nil && dst.kind == kindDir:
+		names, _, err := e.children(newPath)
+		if err != nil {
+			return err
+		}
+		if len(names) > 0 {
+			return sbox.ErrExist
+		}
+		if en.kind != kindDir {
+			return sbox.ErrIsDir
+		}
+	case err == nil && en.kind == kindDir:
+		return sbox.ErrNotDir
+	case err != nil && !errors.Is(err, sbox.ErrNotFound):
+		return err
+	}
+	if err := e.mkdirs(&b, newPath, time.Now()); err != nil {
+		return err
+	}
+
+	paths, entries, spilled, err := e.tree(oldPath, en)
+	if err != nil {
+		return err
+	}
+	for i, sub := range paths {
+		b.Delete(key(sub))
+		b.Put(key(newPath+strings.TrimPrefix(sub, oldPath)), entries[i].encode())
+	}
+	if dst != nil && dst.kind == kindSpilled && !spilled {
+		if err := e.spill.Remove(ctx, newPath); err != nil {
+			return err
+		}
+	}
+	if spilled {
+		if err := e.spill.Rename(ctx, oldPath, newPath); err != nil {
+			return err
+		}
+	}
+	if err := e.store.Write(&b); err != nil {
+		if spilled {
+			_ = e.spill.Rename(ctx, newPath, oldPath)
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, p string) (err error) {
+	defer wrapErr("mkdir", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	en, err := e.lookup(p)
+	if err == nil {
+		if en.kind != kindDir {
+			return sbox.ErrNotDir
+		}
+		return nil
+	}
+	if !errors.Is(err, sbox.ErrNotFound) {
+		return err
+	}
+	now := time.Now()
+	var b Batch
+	if err := e.mkdirs(&b, p, now); err != nil {
+		return err
+	}
+	b.Put(key(p), (&entry{kind: kindDir, modTime: now}).encode())
+	return e.store.Write(&b)
+}
+
+func (e *Engine) ReadDir(ctx context.Context, p string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", p, &err)
+	p, err = clean(p)
+	if err != nil {
+		return nil, err
+	}
+	en, err := e.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if en.kind != kindDir {
+		return nil, sbox.ErrNotDir
+	}
+	names, entries, err := e.children(p)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*sbox.EntryInfo, len(names))
+	for i, name := range names {
+		infos[i] = entries[i].info(path.Join(p, name))
+	}
+	return infos, nil
+}
+
+// Close closes the store. The spill engine is left open.
+func (e *Engine) Close() error {
+	return e.store.Close()
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	return e.Open(ctx, p)
+}
+
+// === Extension: StreamWriter ===
+
+// Put reads reader up to the spill threshold into memory; larger files are
+// streamed to the spill engine.
+func (e *Engine) Put(ctx context.Context, p string, reader io.Reader) error {
+	wc, err := e.Create(ctx, p)
+	if err != nil {
+		return err
+	}
+	w := wc.(*writer)
+	if _, err := io.Copy(w, reader); err != nil {
+		w.abort()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks the store, with its Ping method if it has one and otherwise
+// by reading the root, and the spill engine with sbox.Ping.
+func (e *Engine) Ping(ctx context.Context) error {
+	if h, ok := e.store.(sbox.HealthChecker); ok {
+		if err := h.Ping(ctx); err != nil {
+			return err
+		}
+	} else if _, err := e.Stat(ctx, ""); err != nil {
+		return err
+	}
+	if e.spill != nil {
+		return sbox.Ping(ctx, e.spill)
+	}
+	return nil
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine = (*Engine)(nil)
+	_ sbox.StreamReader  = (*Engine)(nil)
+	_ sbox.StreamWriter  = (*Engine)(nil)
+	_ io.Closer          = (*Engine)(nil)
+	_ sbox.HealthChecker = (*Engine)(nil)
+)
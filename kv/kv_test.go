@@ -0,0 +1,171 @@
+package kv_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/kv"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func openStore(t *testing.T, path string) *kv.BoltStore {
+	t.Helper()
+	store, err := kv.OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore: %v", err)
+	}
+	return store
+}
+
+func TestKV_Suite(t *testing.T) {
+	engine := kv.New(openStore(t, filepath.Join(t.TempDir(), "store.kv")))
+	defer func() { _ = engine.Close() }()
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestKV_SpillSuite(t *testing.T) {
+	engine := kv.New(openStore(t, filepath.Join(t.TempDir(), "store.kv")), kv.WithSpill(memory.New(), 4))
+	defer func() { _ = engine.Close() }()
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestKV_Spill(t *testing.T) {
+	ctx := context.Background()
+	spill := memory.New()
+	engine := kv.New(openStore(t, filepath.Join(t.TempDir(), "store.kv")), kv.WithSpill(spill, 8))
+	defer func() { _ = engine.Close() }()
+
+	if err := engine.Put(ctx, "dir/small.txt", strings.NewReader("tiny")); err != nil {
+		t.Fatalf("Put small: %v", err)
+	}
+	if err := engine.Put(ctx, "dir/large.txt", strings.NewReader("larger than eight")); err != nil {
+		t.Fatalf("Put large: %v", err)
+	}
+	if _, err := spill.Stat(ctx, "dir/small.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("small file spilled: %v", err)
+	}
+	if info, err := spill.Stat(ctx, "dir/large.txt"); err != nil || info.Size != 17 {
+		t.Errorf("spilled Stat = %+v, %v", info, err)
+	}
+	entries, err := engine.ReadDir(ctx, "dir")
+	if err != nil || len(entries) != 2 || entries[0].Name != "large.txt" || entries[0].Size != 17 {
+		t.Fatalf("ReadDir = %+v, %v", entries, err)
+	}
+
+	// Shrinking a spilled file moves it back into the store.
+	if err := engine.Put(ctx, "dir/large.txt", strings.NewReader("short")); err != nil {
+		t.Fatalf("Put short: %v", err)
+	}
+	if _, err := spill.Stat(ctx, "dir/large.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("spilled copy left behind: %v", err)
+	}
+
+	// Growing a file by appending spills it.
+	w, err := engine.OpenFile(ctx, "dir/small.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = io.WriteString(w, " no more")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := engine.Rename(ctx, "dir", "moved"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if info, err := spill.Stat(ctx, "moved/small.txt"); err != nil || info.Size != 12 {
+		t.Errorf("spilled Stat after Rename = %+v, %v", info, err)
+	}
+	if got := readFile(t, engine, "moved/small.txt"); got != "tiny no more" {
+		t.Errorf("moved/small.txt = %q", got)
+	}
+
+	if err := engine.Remove(ctx, "moved"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := spill.Stat(ctx, "moved"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("spilled files left after Remove: %v", err)
+	}
+}
+
+func TestKV_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.kv")
+	engine := kv.New(openStore(t, path))
+	for _, name := range []string{"a/1.txt", "a/2.txt", "b.txt"} {
+		if err := engine.Put(ctx, name, strings.NewReader("content of "+name)); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+	if err := engine.Remove(ctx, "a/2.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store := openStore(t, path)
+	engine = kv.New(store)
+	defer func() { _ = engine.Close() }()
+	if got := readFile(t, engine, "a/1.txt"); got != "content of a/1.txt" {
+		t.Errorf("a/1.txt = %q", got)
+	}
+	if _, err := engine.Stat(ctx, "a/2.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("removed file: %v", err)
+	}
+	if entries, err := engine.ReadDir(ctx, "a"); err != nil || len(entries) != 1 {
+		t.Errorf("ReadDir = %+v, %v", entries, err)
+	}
+
+	// The file is locked while open.
+	if _, err := kv.OpenBoltStore(path, kv.WithOpenTimeout(10*time.Millisecond)); err == nil {
+		t.Error("second OpenBoltStore succeeded")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := store.Get("x"); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Get after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+}
+
+func TestKV_Open(t *testing.T) {
+	dir := t.TempDir()
+	dsn := "kv://" + filepath.ToSlash(filepath.Join(dir, "store.kv")) + "?spillPath=" + filepath.ToSlash(filepath.Join(dir, "large")) + "&spillThreshold=4"
+	engine, err := sbox.OpenURL(dsn)
+	if err != nil {
+		t.Fatalf("OpenURL: %v", err)
+	}
+	defer func() { _ = sbox.Close(engine) }()
+	if err := engine.(sbox.StreamWriter).Put(context.Background(), "x.txt", strings.NewReader("spilled")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "large", "x.txt")); err != nil {
+		t.Errorf("spilled file: %v", err)
+	}
+
+	if _, err := sbox.Open(&sbox.Config{Type: "kv"}); err == nil {
+		t.Error("Open without BasePath succeeded")
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
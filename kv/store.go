@@ -0,0 +1,188 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/nuln/sbox"
+)
+
+// Store is an embedded key-value store with atomic batches. Get returns
+// sbox.ErrNotFound for missing keys; Keys returns the keys with the given
+// prefix in ascending order. Implementations must be safe for concurrent
+// use. BoltStore is the built-in implementation.
+type Store interface {
+	Get(key string) ([]byte, error)
+	Keys(prefix string) ([]string, error)
+	Write(b *Batch) error
+	Close() error
+}
+
+// Batch is a set of updates applied atomically by Store.Write.
+type Batch struct {
+	ops []op
+}
+
+type op struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// Put sets key to value.
+func (b *Batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, op{key: key, value: value})
+}
+
+// Delete removes key.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, op{key: key, delete: true})
+}
+
+// Len returns the number of updates in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// bucket holds every key of a BoltStore.
+var bucket = []byte("sbox")
+
+// DefaultOpenTimeout is how long OpenBoltStore waits for another process
+// to release the store file.
+const DefaultOpenTimeout = time.Second
+
+// BoltStore is a Store in a bbolt database file. Every batch is one bbolt
+// transaction, so a crash never leaves a batch half applied. Only one
+// process can open the file at a time.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// BoltStoreOption configures a BoltStore.
+type BoltStoreOption func(*bolt.Options)
+
+// WithSync makes every Write sync the file before returning, so committed
+// batches survive a power loss. Without it, a power loss can lose recent
+// batches or leave the file unreadable; a crash of the process cannot.
+// It is enabled by default.
+func WithSync(enabled bool) BoltStoreOption {
+	return func(o *bolt.Options) {
+		o.NoSync = !enabled
+	}
+}
+
+// WithOpenTimeout sets how long OpenBoltStore waits for another process
+// to release the store file (default DefaultOpenTimeout). Zero waits
+// indefinitely.
+func WithOpenTimeout(d time.Duration) BoltStoreOption {
+	return func(o *bolt.Options) {
+		o.Timeout = d
+	}
+}
+
+// OpenBoltStore opens the store in the bbolt database file at path,
+// creating it if needed.
+func OpenBoltStore(path string, opts ...BoltStoreOption) (*BoltStore, error) {
+	o := &bolt.Options{Timeout: DefaultOpenTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	db, err := bolt.Open(path, 0644, o)
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("sbox/kv: %s is in use by another process: %w", path, err)
+		}
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// storeError maps the errors of a closed database to sbox.ErrClosed.
+func storeError(err error) error {
+	if errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		return sbox.ErrClosed
+	}
+	return err
+}
+
+func (s *BoltStore) Get(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction.
+		v := tx.Bucket(bucket).Get([]byte(key))
+		if v == nil {
+			return sbox.ErrNotFound
+		}
+		value = bytes.Clone(v)
+		return nil
+	})
+	return value, storeError(err)
+}
+
+func (s *BoltStore) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, storeError(err)
+}
+
+func (s *BoltStore) Write(b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	return storeError(s.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(bucket)
+		for _, o := range b.ops {
+			var err error
+			if o.delete {
+				err = bk.Delete([]byte(o.key))
+			} else {
+				err = bk.Put([]byte(o.key), o.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// Close closes the database file. Closing a closed store does nothing.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the store is open and readable.
+func (s *BoltStore) Ping(ctx context.Context) error {
+	return storeError(s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucket) == nil {
+			return fmt.Errorf("sbox/kv: %s has no %s bucket", s.db.Path(), bucket)
+		}
+		return nil
+	}))
+}
+
+// Compile-time interface checks.
+var (
+	_ Store              = (*BoltStore)(nil)
+	_ sbox.HealthChecker = (*BoltStore)(nil)
+)
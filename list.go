@@ -0,0 +1,169 @@
+package sbox
+
+import (
+	"container/heap"
+	"context"
+	"iter"
+	"sort"
+	"strings"
+)
+
+// DefaultPageSize is the number of entries in a page when
+// ListOptions.PageSize is not set.
+const DefaultPageSize = 1000
+
+// ListOptions controls a paginated listing.
+type ListOptions struct {
+	// PageSize is the maximum number of entries returned.
+	PageSize int
+
+	// Cursor resumes a listing after the previous page; pass the
+	// NextCursor of that page. The empty string starts from the beginning.
+	Cursor string
+
+	// Prefix restricts the listing to entries whose name starts with it.
+	Prefix string
+}
+
+// ListPage is one page of a directory listing. Entries are sorted by name.
+type ListPage struct {
+	Entries []*EntryInfo
+
+	// NextCursor is the cursor for the following page, or "" if this is
+	// the last one.
+	NextCursor string
+}
+
+// Lister supports listing large directories in pages, holding at most one
+// page of entries in memory. Cursors are opaque to callers and are only
+// valid for the engine that returned them.
+type Lister interface {
+	List(ctx context.Context, path string, opts ListOptions) (*ListPage, error)
+}
+
+// ListDir returns one page of the entries in the directory at path. Engines
+// implementing [Lister] are used natively; for others the whole directory
+// is read with ReadDir and then paged.
+func ListDir(ctx context.Context, engine StorageEngine, path string, opts ListOptions) (*ListPage, error) {
+	if l, ok := engine.(Lister); ok {
+		return l.List(ctx, path, opts)
+	}
+	entries, err := engine.ReadDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	b := NewPageBuilder(opts)
+	for _, entry := range entries {
+		b.Add(entry.Name, func() *EntryInfo { return entry })
+	}
+	return b.Page(), nil
+}
+
+// PageBuilder assembles a page from directory entries received in any
+// order, keeping only the PageSize smallest names after the cursor. It lets
+// drivers implement [Lister] on top of streaming directory reads. Cursors
+// built by a PageBuilder are entry names.
+type PageBuilder struct {
+	opts    ListOptions
+	entries entryHeap
+	more    bool
+}
+
+// NewPageBuilder returns a PageBuilder for opts.
+func NewPageBuilder(opts ListOptions) *PageBuilder {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	return &PageBuilder{opts: opts}
+}
+
+// Add offers the entry called name. info is only called if the entry
+// belongs on the page, so expensive metadata lookups can be skipped; it may
+// return nil to drop the entry, e.g. if it vanished in the meantime.
+func (b *PageBuilder) Add(name string, info func() *EntryInfo) {
+	if name <= b.opts.Cursor || !strings.HasPrefix(name, b.opts.Prefix) {
+		return
+	}
+	full := len(b.entries) == b.opts.PageSize
+	if full && name > b.entries[0].Name {
+		b.more = true
+		return
+	}
+	entry := info()
+	if entry == nil {
+		return
+	}
+	if full {
+		b.more = true
+		heap.Pop(&b.entries)
+	}
+	heap.Push(&b.entries, entry)
+}
+
+// Page returns the page built so far.
+func (b *PageBuilder) Page() *ListPage {
+	entries := []*EntryInfo(b.entries)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	page := &ListPage{Entries: entries}
+	if b.more {
+		page.NextCursor = entries[len(entries)-1].Name
+	}
+	return page
+}
+
+// entryHeap is a max-heap of entries by name.
+type entryHeap []*EntryInfo
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].Name > h[j].Name }
+func (h entryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x any)        { *h = append(*h, x.(*EntryInfo)) }
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// ReadDirIter returns an iterator over the entries of the directory at
+// path. On engines implementing [Lister], entries are fetched a page at a
+// time, in name order, so giant directories never need to fit in memory;
+// for others the iterator ranges over ReadDir. A failure is yielded as a
+// final (nil, err) pair.
+func ReadDirIter(ctx context.Context, engine StorageEngine, path string) iter.Seq2[*EntryInfo, error] {
+	return func(yield func(*EntryInfo, error) bool) {
+		l, ok := engine.(Lister)
+		if !ok {
+			entries, err := engine.ReadDir(ctx, path)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, entry := range entries {
+				if !yield(entry, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		var opts ListOptions
+		for {
+			page, err := l.List(ctx, path, opts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, entry := range page.Entries {
+				if !yield(entry, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+// listAll pages through dir and returns the names seen and the number of
+// pages.
+func listAll(t *testing.T, engine sbox.StorageEngine, dir string, opts sbox.ListOptions) ([]string, int) {
+	t.Helper()
+	var names []string
+	pages := 0
+	for {
+		page, err := sbox.ListDir(context.Background(), engine, dir, opts)
+		if err != nil {
+			t.Fatalf("ListDir: %v", err)
+		}
+		pages++
+		for _, e := range page.Entries {
+			names = append(names, e.Name)
+		}
+		if page.NextCursor == "" {
+			return names, pages
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func TestListDir(t *testing.T) {
+	ctx := context.Background()
+	var want []string
+	base := memory.New()
+	for i := 24; i >= 0; i-- {
+		name := fmt.Sprintf("f%02d", i)
+		if err := sbox.PutAtomic(ctx, base, "dir/"+name, strings.NewReader(name)); err != nil {
+			t.Fatalf("PutAtomic: %v", err)
+		}
+		want = append([]string{name}, want...)
+	}
+	if err := base.MkdirAll(ctx, "dir/sub"); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	want = append(want, "sub")
+
+	engines := map[string]sbox.StorageEngine{
+		"native":   base,
+		"fallback": struct{ sbox.StorageEngine }{base},
+	}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			got, pages := listAll(t, engine, "dir", sbox.ListOptions{PageSize: 10})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("names = %q, want %q", got, want)
+			}
+			if pages != 3 {
+				t.Errorf("pages = %d, want 3", pages)
+			}
+
+			got, _ = listAll(t, engine, "dir", sbox.ListOptions{PageSize: 4, Prefix: "f1"})
+			if len(got) != 10 || got[0] != "f10" || got[9] != "f19" {
+				t.Errorf("prefix names = %q", got)
+			}
+
+			page, err := sbox.ListDir(ctx, engine, "dir", sbox.ListOptions{})
+			if err != nil {
+				t.Fatalf("ListDir: %v", err)
+			}
+			if len(page.Entries) != len(want) || page.NextCursor != "" {
+				t.Errorf("default page: %d entries, cursor %q", len(page.Entries), page.NextCursor)
+			}
+			if e := page.Entries[len(want)-1]; !e.IsDir || e.Path != "dir/sub" {
+				t.Errorf("last entry = %+v", e)
+			}
+		})
+	}
+}
+
+func TestPageBuilder_SkipsLoadingDroppedEntries(t *testing.T) {
+	b := sbox.NewPageBuilder(sbox.ListOptions{PageSize: 2, Cursor: "b"})
+	var loaded []string
+	for _, name := range []string{"a", "b", "c", "e", "f", "d"} {
+		b.Add(name, func() *sbox.EntryInfo {
+			loaded = append(loaded, name)
+			return &sbox.EntryInfo{Name: name}
+		})
+	}
+	page := b.Page()
+	if len(page.Entries) != 2 || page.Entries[0].Name != "c" || page.Entries[1].Name != "d" {
+		t.Errorf("entries = %+v", page.Entries)
+	}
+	if page.NextCursor != "d" {
+		t.Errorf("NextCursor = %q, want %q", page.NextCursor, "d")
+	}
+	if want := []string{"c", "e", "d"}; !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded = %q, want %q", loaded, want)
+	}
+}
+
+func TestReadDirIter(t *testing.T) {
+	ctx := context.Background()
+	base := memory.New()
+	for i := range 1500 {
+		if err := sbox.PutAtomic(ctx, base, fmt.Sprintf("dir/f%04d", i), strings.NewReader("x")); err != nil {
+			t.Fatalf("PutAtomic: %v", err)
+		}
+	}
+
+	engines := map[string]sbox.StorageEngine{
+		"native":   base,
+		"fallback": struct{ sbox.StorageEngine }{base},
+	}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for entry, err := range sbox.ReadDirIter(ctx, engine, "dir") {
+				if err != nil {
+					t.Fatalf("ReadDirIter: %v", err)
+				}
+				seen[entry.Name] = true
+			}
+			if len(seen) != 1500 {
+				t.Errorf("saw %d entries, want 1500", len(seen))
+			}
+
+			n := 0
+			for range sbox.ReadDirIter(ctx, engine, "dir") {
+				if n++; n == 3 {
+					break
+				}
+			}
+
+			var gotErr error
+			for entry, err := range sbox.ReadDirIter(ctx, engine, "missing") {
+				if entry != nil {
+					t.Errorf("unexpected entry %+v", entry)
+				}
+				gotErr = err
+			}
+			if !errors.Is(gotErr, sbox.ErrNotFound) {
+				t.Errorf("error = %v, want %v", gotErr, sbox.ErrNotFound)
+			}
+		})
+	}
+}
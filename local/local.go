@@ -5,31 +5,123 @@ import (
 	"crypto/md5" //nolint:gosec // md5 is intentionally supported
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/afero"
 
 	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/lockfile"
 )
 
 // Auto-register local storage driver.
 func init() {
 	sbox.Register("local", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
-		return New(cfg.BasePath)
+		var opts []Option
+		if s, ok := cfg.Options["durability"].(string); ok {
+			d, err := sbox.ParseDurability(s)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithDurability(d))
+		}
+		switch v := cfg.Options["trustedPaths"].(type) {
+		case bool:
+			opts = append(opts, WithTrustedPaths(v))
+		case string:
+			opts = append(opts, WithTrustedPaths(v == "true" || v == "1"))
+		}
+		switch v := cfg.Options["minFreeSpace"].(type) {
+		case int:
+			opts = append(opts, WithMinFreeSpace(int64(v)))
+		case int64:
+			opts = append(opts, WithMinFreeSpace(v))
+		case float64:
+			opts = append(opts, WithMinFreeSpace(int64(v)))
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/local: invalid minFreeSpace %q: %w", v, err)
+			}
+			opts = append(opts, WithMinFreeSpace(n))
+		}
+		if s, ok := cfg.Options["pollInterval"].(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/local: invalid pollInterval %q: %w", s, err)
+			}
+			opts = append(opts, WithPollInterval(d))
+		}
+		return New(cfg.BasePath, opts...)
 	})
 }
 
 // Engine implements sbox.StorageEngine for the local filesystem.
 type Engine struct {
-	fs   afero.Fs
-	root string
+	fs         afero.Fs
+	root       string
+	realRoot   string // root with symbolic links resolved, if native
+	native     bool   // fs is the OS filesystem under root
+	durability sbox.Durability
+	trusted    bool             // Skip path validation
+	space      *sbox.SpaceGuard // nil without WithMinFreeSpace
+	poll       time.Duration    // Watch poll interval without inotify
+}
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithDurability sets what Close guarantees after a power loss. With
+// sbox.DurabilityFlush, files written through Create, OpenFile, Put, Append
+// and Copy are synced on Close; with sbox.DurabilityFsync, the directories
+// on the path to them are synced as well, also after Rename. Atomic writes
+// always sync their data. The default is sbox.DurabilityNone.
+func WithDurability(d sbox.Durability) Option {
+	return func(e *Engine) {
+		e.durability = d
+	}
+}
+
+// WithTrustedPaths skips the validation of paths with sbox.ValidatePath
+// and of the symbolic links on them, for callers that only pass paths
+// they built themselves. Untrusted paths can then reach outside the root,
+// e.g. with ".." elements or links to other directories.
+func WithTrustedPaths(enabled bool) Option {
+	return func(e *Engine) {
+		e.trusted = enabled
+	}
+}
+
+// WithMinFreeSpace makes writes keep at least reserve bytes free on the
+// filesystem holding the root. Creating a file fails when the reserve is
+// reached, and a write that would cross it fails before writing anything,
+// with a *sbox.NoSpaceError. A full filesystem is reported the same way.
+// It applies to engines created with New.
+func WithMinFreeSpace(reserve int64) Option {
+	return func(e *Engine) {
+		e.space = sbox.NewSpaceGuard(e.root, reserve)
+	}
+}
+
+// WithPollInterval sets how often Watch polls for changes where it cannot
+// use inotify: on platforms other than Linux, and for engines not backed
+// by the OS filesystem. The default is sbox.DefaultPollInterval.
+func WithPollInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.poll = d
+	}
 }
 
 // New creates a new local storage Engine with the given root directory.
-func New(root string) (*Engine, error) {
+func New(root string, opts ...Option) (*Engine, error) {
 	absRoot, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
@@ -37,40 +129,180 @@ func New(root string) (*Engine, error) {
 	if err := os.MkdirAll(absRoot, 0750); err != nil {
 		return nil, err
 	}
-	return &Engine{
-		fs:   afero.NewBasePathFs(afero.NewOsFs(), absRoot),
-		root: absRoot,
-	}, nil
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		fs:       afero.NewBasePathFs(afero.NewOsFs(), absRoot),
+		root:     absRoot,
+		realRoot: realRoot,
+		native:   true,
+		poll:     sbox.DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
 }
 
 // NewWithFs creates a local Engine backed by a custom afero.Fs.
 // This is useful for testing with afero.MemMapFs.
-func NewWithFs(fs afero.Fs) *Engine {
-	return &Engine{fs: fs, root: "."}
+func NewWithFs(fs afero.Fs, opts ...Option) *Engine {
+	e := &Engine{fs: fs, root: ".", poll: sbox.DefaultPollInterval}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.space = nil // The root is not an OS directory
+	return e
+}
+
+// validate rejects paths that could reach outside the root, by their
+// elements or through symbolic links on them, unless the engine trusts
+// its callers.
+func (e *Engine) validate(paths ...string) error {
+	return e.check(true, paths)
+}
+
+// validateLink is validate for operations on a link itself, which do not
+// follow the last element of their paths.
+func (e *Engine) validateLink(paths ...string) error {
+	return e.check(false, paths)
+}
+
+func (e *Engine) check(follow bool, paths []string) error {
+	if e.trusted {
+		return nil
+	}
+	for _, p := range paths {
+		clean, err := sbox.CleanPath(p)
+		if err != nil {
+			return err
+		}
+		if err := e.confine(clean, follow, 0); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+// maxLinks is the number of symbolic links confine follows, as on Linux.
+const maxLinks = 40
+
+// confine rejects the clean path p if a symbolic link on it leads outside
+// the root, following the last element only if follow is set. The check
+// stops at the first element that does not exist, as it cannot be a link
+// yet. Links replaced concurrently by another process are not detected.
+func (e *Engine) confine(p string, follow bool, depth int) error {
+	if e.realRoot == "" || p == "" {
+		return nil
+	}
+	dir := e.realRoot
+	parts := strings.Split(p, "/")
+	for i, name := range parts {
+		if i == len(parts)-1 && !follow {
+			return nil
+		}
+		next := filepath.Join(dir, name)
+		info, err := os.Lstat(next)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			dir = next
+			continue
+		}
+		if depth >= maxLinks {
+			return fmt.Errorf("sbox/local: too many levels of symbolic links: %s: %w", p, sbox.ErrInvalid)
+		}
+		real, err := filepath.EvalSymlinks(next)
+		if err == nil {
+			if _, ok := e.rel(real); !ok {
+				return fmt.Errorf("sbox/local: %s leads outside the root: %w", p, sbox.ErrInvalid)
+			}
+			dir = real
+			continue
+		}
+		// A dangling link: check where its target would be created.
+		target, err := os.Readlink(next)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		rel, ok := e.rel(target)
+		if !ok {
+			return fmt.Errorf("sbox/local: %s leads outside the root: %w", p, sbox.ErrInvalid)
+		}
+		return e.confine(rel, true, depth+1)
+	}
+	return nil
+}
+
+// rel returns the OS path p relative to the root in slash form, and
+// whether p is within the root.
+func (e *Engine) rel(p string) (string, bool) {
+	rel, err := filepath.Rel(e.realRoot, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// wrapErr makes *err an *sbox.PathError of the local driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("local", op, path, *err)
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", path, &err)
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
 	info, err := e.fs.Stat(path)
 	if err != nil {
 		return nil, err
 	}
+	return e.entryInfo(path, info), nil
+}
+
+// entryInfo describes the file at path with info.
+func (e *Engine) entryInfo(path string, info os.FileInfo) *sbox.EntryInfo {
+	uid, gid := owner(info)
+	var md map[string]string
+	if info.Mode()&os.ModeSymlink == 0 {
+		// Reading the attributes of a link would follow it.
+		md = e.readMetadata(path)
+	}
 	return &sbox.EntryInfo{
-		Name:    info.Name(),
-		Size:    info.Size(),
-		ModTime: info.ModTime(),
-		Mode:    info.Mode(),
-		IsDir:   info.IsDir(),
-		Path:    path,
-	}, nil
+		Name:       info.Name(),
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		Mode:       info.Mode(),
+		IsDir:      info.IsDir(),
+		Path:       path,
+		Metadata:   md,
+		Uid:        uid,
+		Gid:        gid,
+		LinkTarget: e.linkTarget(path, info.Mode()),
+	}
 }
 
-func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+func (e *Engine) Open(ctx context.Context, path string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", path, &err)
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
 	f, err := e.fs.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	// afero.File implements ReadSeekCloser
-	rsc, ok := f.(sbox.ReadSeekCloser)
+	rsc, ok := withContext(ctx, f).(sbox.ReadSeekCloser)
 	if !ok {
 		_ = f.Close()
 		return nil, fmt.Errorf("sbox/local: file does not support seek")
@@ -78,21 +310,55 @@ func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, er
 	return rsc, nil
 }
 
-func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+func (e *Engine) Create(ctx context.Context, path string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", path, &err)
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
 	if err := e.fs.MkdirAll(filepath.Dir(path), 0750); err != nil {
 		return nil, err
 	}
-	return e.fs.Create(path)
+	if err := e.reserve(path); err != nil {
+		return nil, err
+	}
+	f, err := e.fs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.resetMetadata(path, sbox.MetadataFromContext(ctx)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return e.durable(e.guarded(withContext(ctx, f), path), path), nil
 }
 
-func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", path, &err)
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
 	if err := e.fs.MkdirAll(filepath.Dir(path), 0750); err != nil {
 		return nil, err
 	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := e.reserve(path); err != nil {
+			return nil, err
+		}
+	}
 	f, err := e.fs.OpenFile(path, flag, perm)
 	if err != nil {
 		return nil, err
 	}
+	if md := sbox.MetadataFromContext(ctx); md != nil || flag&os.O_TRUNC != 0 {
+		if err := e.resetMetadata(path, md); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	f = withContext(ctx, f)
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		f = e.durable(e.guarded(f, path), path)
+	}
 	wsc, ok := f.(sbox.WriteSeekCloser)
 	if !ok {
 		_ = f.Close()
@@ -101,22 +367,221 @@ func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.Fi
 	return wsc, nil
 }
 
-func (e *Engine) Remove(ctx context.Context, path string) error {
+func (e *Engine) Remove(ctx context.Context, path string) (err error) {
+	defer wrapErr("remove", path, &err)
+	if err := e.validateLink(path); err != nil {
+		return err
+	}
+	// RemoveAll succeeds for missing paths. Lstat, so that dangling links
+	// can be removed.
+	if l, ok := e.fs.(afero.Lstater); ok {
+		_, _, err = l.LstatIfPossible(path)
+	} else {
+		_, err = e.fs.Stat(path)
+	}
+	if err != nil {
+		return err
+	}
 	return e.fs.RemoveAll(path)
 }
 
-func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	if err := e.validateLink(oldPath, newPath); err != nil {
+		return err
+	}
 	if err := e.fs.MkdirAll(filepath.Dir(newPath), 0750); err != nil {
 		return err
 	}
-	return e.fs.Rename(oldPath, newPath)
+	if err := e.fs.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if e.durability != sbox.DurabilityFsync {
+		return nil
+	}
+	if err := syncDirs(e.fs, filepath.Dir(oldPath)); err != nil {
+		return err
+	}
+	return syncDirs(e.fs, filepath.Dir(newPath))
+}
+
+// durable returns f, made to sync on Close as the durability level
+// requires.
+func (e *Engine) durable(f afero.File, path string) afero.File {
+	if e.durability == "" || e.durability == sbox.DurabilityNone {
+		return f
+	}
+	return &syncedFile{File: f, fs: e.fs, path: path, dirs: e.durability == sbox.DurabilityFsync}
+}
+
+// reserve fails with a *sbox.NoSpaceError if the free space is down to
+// the reserve of WithMinFreeSpace.
+func (e *Engine) reserve(path string) error {
+	if e.space == nil {
+		return nil
+	}
+	return e.space.Reserve(path, 0)
 }
 
-func (e *Engine) MkdirAll(ctx context.Context, path string) error {
-	return e.fs.MkdirAll(path, 0750)
+// guarded returns f, reserving space for its writes with WithMinFreeSpace.
+func (e *Engine) guarded(f afero.File, path string) afero.File {
+	if e.space == nil {
+		return f
+	}
+	return &spaceFile{File: f, guard: e.space, path: path}
+}
+
+// spaceFile reserves space for its writes with a SpaceGuard.
+type spaceFile struct {
+	afero.File
+	guard *sbox.SpaceGuard
+	path  string
+}
+
+func (f *spaceFile) Write(p []byte) (int, error) {
+	if err := f.guard.Reserve(f.path, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := f.File.Write(p)
+	return n, f.guard.Check(f.path, int64(len(p)), err)
 }
 
-func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+func (f *spaceFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.guard.Reserve(f.path, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := f.File.WriteAt(p, off)
+	return n, f.guard.Check(f.path, int64(len(p)), err)
+}
+
+func (f *spaceFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// withContext returns f, failing reads and writes once ctx is done, so
+// long transfers through a handle stop when the request that opened it is
+// cancelled.
+func withContext(ctx context.Context, f afero.File) afero.File {
+	if ctx.Done() == nil {
+		return f
+	}
+	return &ctxFile{File: f, ctx: ctx}
+}
+
+// ctxFile is an afero.File bound to a context.
+type ctxFile struct {
+	afero.File
+	ctx context.Context
+}
+
+func (f *ctxFile) Read(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *ctxFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *ctxFile) Write(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *ctxFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *ctxFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// syncedFile syncs its data, and optionally its directories, on Close.
+type syncedFile struct {
+	afero.File
+	fs   afero.Fs
+	path string
+	dirs bool
+}
+
+func (f *syncedFile) Close() error {
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && f.dirs {
+		err = syncDirs(f.fs, filepath.Dir(f.path))
+	}
+	return err
+}
+
+// syncDirs syncs dir and its parents up to the root, so that the entries
+// created in them survive a power loss. Windows cannot sync directories;
+// its directory updates are durable once the files are.
+func syncDirs(fs afero.Fs, dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	for {
+		d, err := fs.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = d.Sync()
+		if cerr := d.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) (err error) {
+	defer wrapErr("mkdir", path, &err)
+	if err := e.validate(path); err != nil {
+		return err
+	}
+	// afero.MemMapFs silently succeeds when a file occupies the path or a
+	// parent, so check explicitly to keep the behavior consistent across
+	// filesystems. The parents of an existing directory are directories.
+	for p := filepath.Clean(path); p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if info, err := e.fs.Stat(p); err == nil {
+			if !info.IsDir() {
+				return sbox.ErrNotDir
+			}
+			break
+		}
+	}
+	if err := e.fs.MkdirAll(path, 0750); err != nil {
+		if errors.Is(err, syscall.ENOTDIR) {
+			return sbox.ErrNotDir
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", path, &err)
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
 	f, err := e.fs.Open(path)
 	if err != nil {
 		return nil, err
@@ -130,32 +595,71 @@ func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, e
 
 	result := make([]*sbox.EntryInfo, 0, len(infos))
 	for _, info := range infos {
-		result = append(result, &sbox.EntryInfo{
-			Name:    info.Name(),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
-			Mode:    info.Mode(),
-			IsDir:   info.IsDir(),
-			Path:    filepath.Join(path, info.Name()),
-		})
+		result = append(result, e.entryInfo(filepath.Join(path, info.Name()), info))
 	}
 	return result, nil
 }
 
+// === Extension: Lister ===
+
+// readdirChunk is the number of names read from a directory at a time.
+const readdirChunk = 1024
+
+// List reads the directory names in chunks and stats only the entries that
+// end up on the page, so memory use is bounded by the page size. Each page
+// rescans the directory.
+func (e *Engine) List(ctx context.Context, path string, opts sbox.ListOptions) (*sbox.ListPage, error) {
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	f, err := e.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	b := sbox.NewPageBuilder(opts)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		names, err := f.Readdirnames(readdirChunk)
+		for _, name := range names {
+			b.Add(name, func() *sbox.EntryInfo {
+				info, err := e.Lstat(ctx, filepath.Join(path, name))
+				if err != nil {
+					return nil
+				}
+				return info
+			})
+		}
+		if err == io.EOF || (err == nil && len(names) == 0) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Page(), nil
+}
+
 // === Extension: Copier ===
 
 func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	if err := e.validate(src, dst); err != nil {
+		return err
+	}
 	srcInfo, err := e.fs.Stat(src)
 	if err != nil {
 		return err
 	}
 	if srcInfo.IsDir() {
-		return e.copyDir(src, dst)
+		return e.copyDir(ctx, src, dst)
 	}
-	return e.copyFile(src, dst)
+	return e.copyFile(ctx, src, dst)
 }
 
-func (e *Engine) copyFile(src, dst string) error {
+func (e *Engine) copyFile(ctx context.Context, src, dst string) error {
 	if err := e.fs.MkdirAll(filepath.Dir(dst), 0750); err != nil {
 		return err
 	}
@@ -165,17 +669,35 @@ func (e *Engine) copyFile(src, dst string) error {
 	}
 	defer func() { _ = sf.Close() }()
 
+	// The size is known, so the space is reserved before copying.
+	var size int64
+	if e.space != nil {
+		info, err := sf.Stat()
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+		if err := e.space.Reserve(dst, size); err != nil {
+			return err
+		}
+	}
 	df, err := e.fs.Create(dst)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = df.Close() }()
+	df = e.durable(df, dst)
 
-	_, err = io.Copy(df, sf)
+	_, err = io.Copy(df, withContext(ctx, sf))
+	if cerr := df.Close(); err == nil {
+		err = cerr
+	}
+	if e.space != nil {
+		err = e.space.Check(dst, size, err)
+	}
 	return err
 }
 
-func (e *Engine) copyDir(src, dst string) error {
+func (e *Engine) copyDir(ctx context.Context, src, dst string) error {
 	if err := e.fs.MkdirAll(dst, 0750); err != nil {
 		return err
 	}
@@ -187,11 +709,11 @@ func (e *Engine) copyDir(src, dst string) error {
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 		if entry.IsDir() {
-			if err := e.copyDir(srcPath, dstPath); err != nil {
+			if err := e.copyDir(ctx, srcPath, dstPath); err != nil {
 				return err
 			}
 		} else {
-			if err := e.copyFile(srcPath, dstPath); err != nil {
+			if err := e.copyFile(ctx, srcPath, dstPath); err != nil {
 				return err
 			}
 		}
@@ -202,6 +724,9 @@ func (e *Engine) copyDir(src, dst string) error {
 // === Extension: Hasher ===
 
 func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (string, error) {
+	if err := e.validate(path); err != nil {
+		return "", err
+	}
 	f, err := e.fs.Open(path)
 	if err != nil {
 		return "", err
@@ -219,7 +744,7 @@ func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (strin
 	case "sha256":
 		h = sha256.New()
 	default:
-		return "", fmt.Errorf("sbox/local: unsupported hash algorithm: %s", algorithm)
+		return "", fmt.Errorf("sbox/local: unsupported hash algorithm %q: %w", algorithm, sbox.ErrNotSupported)
 	}
 
 	if _, err := io.Copy(h, f); err != nil {
@@ -231,16 +756,23 @@ func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (strin
 // === Extension: StreamReader ===
 
 func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
-	return e.fs.Open(path)
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	f, err := e.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return withContext(ctx, f), nil
 }
 
 // === Extension: StreamWriter ===
 
 func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
-	if err := e.fs.MkdirAll(filepath.Dir(path), 0750); err != nil {
+	if err := e.validate(path); err != nil {
 		return err
 	}
-	f, err := e.fs.Create(path)
+	f, err := e.Create(ctx, path)
 	if err != nil {
 		return err
 	}
@@ -249,11 +781,477 @@ func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
 	return err
 }
 
+// === Extension: Appender ===
+
+func (e *Engine) Append(ctx context.Context, path string, r io.Reader) (int64, error) {
+	if err := e.validate(path); err != nil {
+		return 0, err
+	}
+	f, err := e.OpenFile(ctx, path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// === Extension: RangeWriter ===
+
+// PutRange writes r at offset in the existing file at path. Files on the
+// OS filesystem keep a gap before offset as a hole where supported.
+func (e *Engine) PutRange(ctx context.Context, path string, offset int64, r io.Reader) (_ int64, err error) {
+	if offset < 0 {
+		return 0, sbox.ErrInvalid
+	}
+	if err := e.validate(path); err != nil {
+		return 0, err
+	}
+	f, err := e.OpenFile(ctx, path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// === Extension: MetadataWriter ===
+
+// SetModTime sets both the access and modification times of path to t.
+func (e *Engine) SetModTime(ctx context.Context, path string, t time.Time) error {
+	if err := e.validate(path); err != nil {
+		return err
+	}
+	return e.fs.Chtimes(path, t, t)
+}
+
+// SetMetadata stores metadata in extended attributes under "user.sbox.".
+// It returns sbox.ErrNotSupported for engines not backed by the OS
+// filesystem (including memory) and filesystems without extended
+// attributes.
+func (e *Engine) SetMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	if err := e.validate(path); err != nil {
+		return err
+	}
+	return e.writeMetadata(path, metadata)
+}
+
+// === Extension: PermissionManager ===
+
+func (e *Engine) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	if err := e.validate(path); err != nil {
+		return err
+	}
+	return e.fs.Chmod(path, mode)
+}
+
+// Chown changes the owner of path, which usually requires privileges.
+func (e *Engine) Chown(ctx context.Context, path string, uid, gid int) error {
+	if err := e.validate(path); err != nil {
+		return err
+	}
+	return e.fs.Chown(path, uid, gid)
+}
+
+// === Extension: Symlinker ===
+
+// Symlink creates an OS symbolic link. Links are resolved by the OS, so
+// targets that are absolute or climb above the root fail with
+// sbox.ErrInvalid, unless the engine trusts its callers. Engines not
+// backed by the OS filesystem return sbox.ErrNotSupported.
+func (e *Engine) Symlink(ctx context.Context, target, path string) error {
+	if err := e.validateLink(path); err != nil {
+		return err
+	}
+	if err := e.validateTarget(target, path); err != nil {
+		return err
+	}
+	p, ok := e.osPath(path)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	if err := e.fs.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	return os.Symlink(target, p)
+}
+
+// validateTarget rejects link targets that would resolve outside the root
+// from the link at path.
+func (e *Engine) validateTarget(target, path string) error {
+	if e.trusted {
+		return nil
+	}
+	if target == "" || filepath.IsAbs(target) || strings.HasPrefix(filepath.ToSlash(target), "/") || filepath.VolumeName(target) != "" {
+		return fmt.Errorf("sbox/local: link target %q is not relative to the link: %w", target, sbox.ErrInvalid)
+	}
+	dir, err := sbox.CleanPath(filepath.Dir(filepath.FromSlash(path)))
+	if err != nil {
+		return err
+	}
+	resolved := filepath.ToSlash(filepath.Join(dir, target))
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("sbox/local: link target %q leads outside the root: %w", target, sbox.ErrInvalid)
+	}
+	return nil
+}
+
+func (e *Engine) Readlink(ctx context.Context, path string) (string, error) {
+	if err := e.validateLink(path); err != nil {
+		return "", err
+	}
+	p, ok := e.osPath(path)
+	if !ok {
+		if _, err := e.fs.Stat(path); err != nil {
+			return "", err
+		}
+		return "", sbox.ErrInvalid
+	}
+	target, err := os.Readlink(p)
+	if errors.Is(err, syscall.EINVAL) {
+		return "", sbox.ErrInvalid
+	}
+	return target, err
+}
+
+// Lstat is Stat without following a final symbolic link. Engines not
+// backed by the OS filesystem have no links and behave like Stat.
+func (e *Engine) Lstat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	if err := e.validateLink(path); err != nil {
+		return nil, err
+	}
+	p, ok := e.osPath(path)
+	if !ok {
+		return e.Stat(ctx, path)
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return e.entryInfo(path, info), nil
+}
+
+// linkTarget returns the target of path if mode marks it as a link.
+func (e *Engine) linkTarget(path string, mode os.FileMode) string {
+	if mode&os.ModeSymlink == 0 {
+		return ""
+	}
+	target, _ := e.Readlink(context.Background(), path)
+	return target
+}
+
+// === Extension: Locker ===
+
+// lockDir is the directory below the root that holds lock files.
+const lockDir = ".sbox-locks"
+
+// Lock takes a lease on path with a lock file in the ".sbox-locks"
+// directory under the root, excluding every engine that shares the root,
+// also in other processes.
+func (e *Engine) Lock(ctx context.Context, path string, opts sbox.LockOptions) (sbox.Lease, error) {
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	return lockfile.New(e.fs, lockDir).Lock(ctx, path, opts)
+}
+
+// === Extension: AtomicWriter ===
+
+// CreateAtomic writes to a hidden temporary file next to path and renames it
+// into place on Close, so readers never see a partially written file.
+func (e *Engine) CreateAtomic(ctx context.Context, path string) (sbox.AtomicWriteCloser, error) {
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if err := e.fs.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	if err := e.reserve(path); err != nil {
+		return nil, err
+	}
+	f, err := afero.TempFile(e.fs, dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	a := &atomicFile{fs: e.fs, File: e.guarded(withContext(ctx, f), path), ctx: ctx, path: path, syncDirs: e.durability == sbox.DurabilityFsync}
+	if md := sbox.MetadataFromContext(ctx); md != nil {
+		if err := e.resetMetadata(f.Name(), md); err != nil {
+			_ = a.Abort()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// atomicFile is a temporary file that replaces path when closed.
+type atomicFile struct {
+	afero.File
+	ctx      context.Context
+	fs       afero.Fs
+	path     string
+	syncDirs bool // Sync the directories after the rename
+	failed   bool
+	done     bool
+}
+
+func (a *atomicFile) Write(p []byte) (int, error) {
+	n, err := a.File.Write(p)
+	if err != nil {
+		a.failed = true
+	}
+	return n, err
+}
+
+// Close publishes the file. If any write failed or the context is done,
+// the temporary file is discarded instead and an error is returned.
+func (a *atomicFile) Close() error {
+	if a.done {
+		return sbox.ErrClosed
+	}
+	if err := a.ctx.Err(); err != nil {
+		_ = a.Abort()
+		return err
+	}
+	if a.failed {
+		_ = a.Abort()
+		return fmt.Errorf("sbox/local: atomic write of %s failed, discarded", a.path)
+	}
+	a.done = true
+	tmp := a.File.Name()
+	if err := a.File.Sync(); err != nil {
+		_ = a.File.Close()
+		_ = a.fs.Remove(tmp)
+		return err
+	}
+	if err := a.File.Close(); err != nil {
+		_ = a.fs.Remove(tmp)
+		return err
+	}
+	if err := a.fs.Rename(tmp, a.path); err != nil {
+		_ = a.fs.Remove(tmp)
+		return err
+	}
+	if a.syncDirs {
+		return syncDirs(a.fs, filepath.Dir(a.path))
+	}
+	return nil
+}
+
+// Abort discards the temporary file, leaving path untouched.
+func (a *atomicFile) Abort() error {
+	if a.done {
+		return sbox.ErrClosed
+	}
+	a.done = true
+	tmp := a.File.Name()
+	_ = a.File.Close()
+	return a.fs.Remove(tmp)
+}
+
+// === Extension: ConditionalWriter ===
+
+// ETag identifies the current version of path by its inode, modification
+// time and size. Atomic writes give a file a new inode every time.
+func (e *Engine) ETag(ctx context.Context, path string) (string, error) {
+	if err := e.validate(path); err != nil {
+		return "", err
+	}
+	info, err := e.fs.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-%x-%x", inode(info), info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// CreateIf writes to a temporary file like CreateAtomic. Close checks the
+// precondition again and publishes the file while holding a lock under
+// ".sbox-locks", so conditional writers exclude each other; unconditional
+// writes are not held up.
+func (e *Engine) CreateIf(ctx context.Context, path string, cond sbox.Precondition) (sbox.AtomicWriteCloser, error) {
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	if err := cond.Check(e.ETag(ctx, path)); err != nil {
+		return nil, err
+	}
+	w, err := e.CreateAtomic(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalFile{atomicFile: w.(*atomicFile), ctx: ctx, engine: e, cond: cond}, nil
+}
+
+// conditionalFile is an atomic file published only if its precondition
+// still holds.
+type conditionalFile struct {
+	*atomicFile
+	ctx    context.Context
+	engine *Engine
+	cond   sbox.Precondition
+}
+
+func (c *conditionalFile) Close() error {
+	locker := lockfile.New(c.engine.fs, filepath.Join(lockDir, "commit"))
+	err := sbox.WithLock(c.ctx, locker, c.path, sbox.LockOptions{Wait: sbox.DefaultLockTTL}, func() error {
+		if err := c.cond.Check(c.engine.ETag(c.ctx, c.path)); err != nil {
+			return err
+		}
+		return c.atomicFile.Close()
+	})
+	if err != nil {
+		_ = c.atomicFile.Abort()
+	}
+	return err
+}
+
+// === Extension: TierManager ===
+
+// GetTier is not supported: the local filesystem has no storage tiers.
+func (e *Engine) GetTier(ctx context.Context, path string) (string, error) {
+	if err := e.validate(path); err != nil {
+		return "", err
+	}
+	return "", sbox.ErrNotSupported
+}
+
+// SetTier is not supported: the local filesystem has no storage tiers.
+func (e *Engine) SetTier(ctx context.Context, path string, tier string) error {
+	if err := e.validate(path); err != nil {
+		return err
+	}
+	return sbox.ErrNotSupported
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks that the root is a directory and writable, by creating and
+// removing a temporary file in it.
+func (e *Engine) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	info, err := e.fs.Stat("")
+	if err != nil {
+		return fmt.Errorf("sbox/local: root: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("sbox/local: root: %w", sbox.ErrNotDir)
+	}
+	f, err := afero.TempFile(e.fs, ".", ".sbox-ping-*")
+	if err != nil {
+		return fmt.Errorf("sbox/local: root is not writable: %w", err)
+	}
+	name := f.Name()
+	_ = f.Close()
+	return e.fs.Remove(name)
+}
+
+// === Extension: UsageReporter ===
+
+// Usage walks the tree below path like du, counting the blocks allocated
+// to files on the OS filesystem, and reports the capacity of the
+// filesystem holding the root.
+func (e *Engine) Usage(ctx context.Context, path string) (*sbox.UsageInfo, error) {
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	usage := &sbox.UsageInfo{}
+	root := filepath.Clean(path)
+	err := afero.Walk(e.fs, path, func(p string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			if filepath.Clean(p) != root {
+				usage.Dirs++
+			}
+		case info.Mode().IsRegular():
+			usage.Files++
+			usage.Bytes += info.Size()
+			if n, ok := allocated(info); ok && e.native {
+				usage.Physical += n
+			} else {
+				usage.Physical += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if e.native {
+		usage.Quota = quota(e.root)
+	}
+	return usage, nil
+}
+
+// === Extension: Watcher ===
+
+// Watch reports changes with inotify on Linux, for engines on the OS
+// filesystem, and otherwise polls every poll interval (see
+// WithPollInterval). With inotify, writes are reported when the writer
+// closes the file, and once path itself is removed nothing more is
+// reported.
+func (e *Engine) Watch(ctx context.Context, path string, recursive bool) (<-chan sbox.WatchEvent, error) {
+	if err := e.validate(path); err != nil {
+		return nil, err
+	}
+	if p, ok := e.osPath(path); ok {
+		events, err := watchOS(ctx, p, path, recursive)
+		if !errors.Is(err, sbox.ErrNotSupported) {
+			return events, err
+		}
+	}
+	return sbox.Poll(ctx, e, path, recursive, e.poll)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports the extensions that can succeed: symbolic links and
+// metadata need the OS filesystem, and there are no storage tiers.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapSymlink, sbox.CapMetadata:
+		return e.native
+	case sbox.CapTier:
+		return false
+	}
+	return sbox.Implements(e, c)
+}
+
 // Compile-time interface checks.
 var (
-	_ sbox.StorageEngine = (*Engine)(nil)
-	_ sbox.Copier        = (*Engine)(nil)
-	_ sbox.Hasher        = (*Engine)(nil)
-	_ sbox.StreamReader  = (*Engine)(nil)
-	_ sbox.StreamWriter  = (*Engine)(nil)
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.Hasher             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.TierManager        = (*Engine)(nil)
+	_ sbox.AtomicWriter       = (*Engine)(nil)
+	_ sbox.Appender           = (*Engine)(nil)
+	_ sbox.RangeWriter        = (*Engine)(nil)
+	_ sbox.MetadataWriter     = (*Engine)(nil)
+	_ sbox.Lister             = (*Engine)(nil)
+	_ sbox.Symlinker          = (*Engine)(nil)
+	_ sbox.PermissionManager  = (*Engine)(nil)
+	_ sbox.Locker             = (*Engine)(nil)
+	_ sbox.ConditionalWriter  = (*Engine)(nil)
+	_ sbox.HealthChecker      = (*Engine)(nil)
+	_ sbox.UsageReporter      = (*Engine)(nil)
+	_ sbox.Watcher            = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
 )
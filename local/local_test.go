@@ -1,10 +1,19 @@
 package local_test
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 
+	"github.com/nuln/sbox"
 	"github.com/nuln/sbox/local"
 	"github.com/nuln/sbox/sboxtest"
 )
@@ -13,3 +22,458 @@ func TestLocalEngine(t *testing.T) {
 	engine := local.NewWithFs(afero.NewMemMapFs())
 	sboxtest.StorageTestSuite(t, engine)
 }
+
+func TestLocalEngine_OS(t *testing.T) {
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sboxtest.Run(t, engine, sboxtest.Options{})
+}
+
+func TestLocalEngine_Durability(t *testing.T) {
+	engine, err := sbox.Open(&sbox.Config{
+		Type:     "local",
+		BasePath: t.TempDir(),
+		Options:  map[string]any{"durability": "fsync"},
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sboxtest.StorageTestSuite(t, engine)
+
+	_, err = sbox.Open(&sbox.Config{
+		Type:     "local",
+		BasePath: t.TempDir(),
+		Options:  map[string]any{"durability": "always"},
+	})
+	if err == nil {
+		t.Error("Open accepted an unknown durability")
+	}
+}
+
+func TestLocalEngine_MetadataOnAppendAndAtomic(t *testing.T) {
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	mctx := sbox.WithMetadata(ctx, map[string]string{"k": "v"})
+	if err := sbox.PutAtomic(mctx, engine, "f.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	info, err := engine.Stat(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Metadata["k"] != "v" {
+		if err := engine.SetMetadata(ctx, "f.txt", nil); errors.Is(err, sbox.ErrNotSupported) {
+			t.Skip("filesystem has no extended attributes")
+		}
+		t.Fatalf("Metadata = %v, want k=v", info.Metadata)
+	}
+	if _, err := engine.Append(ctx, "f.txt", strings.NewReader("b")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "f.txt"); info.Metadata["k"] != "v" || info.Size != 2 {
+		t.Errorf("after Append = %+v", info)
+	}
+}
+
+func TestLocalEngine_Chown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ownership is not exposed on Windows")
+	}
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	if err := engine.Put(ctx, "f.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := engine.Chown(ctx, "f.txt", os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("Chown: %v", err)
+	}
+	info, err := engine.Stat(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Uid != os.Getuid() || info.Gid != os.Getgid() {
+		t.Errorf("owner = %d:%d, want %d:%d", info.Uid, info.Gid, os.Getuid(), os.Getgid())
+	}
+}
+
+func TestLocalEngine_TierNotSupported(t *testing.T) {
+	engine := local.NewWithFs(afero.NewMemMapFs())
+	ctx := context.Background()
+	if _, err := engine.GetTier(ctx, "a.txt"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("GetTier = %v, want %v", err, sbox.ErrNotSupported)
+	}
+	if err := engine.SetTier(ctx, "a.txt", "GLACIER"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("SetTier = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+func TestLocalEngine_PathTraversal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.MkdirAll(dir+"/root", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/secret.txt", []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir+"/rootx", 0755); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := local.New(dir + "/root")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, p := range []string{"../secret.txt", "a/../../secret.txt", "../rootx/f", "a\x00b"} {
+		if _, err := engine.Open(ctx, p); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Open(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+		}
+		if _, err := engine.Create(ctx, p); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Create(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+		}
+	}
+	if err := engine.Rename(ctx, "a.txt", "../stolen.txt"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Rename out = %v, want %v", err, sbox.ErrInvalid)
+	}
+	if _, err := os.Stat(dir + "/rootx/f"); !os.IsNotExist(err) {
+		t.Errorf("file created next to the root: %v", err)
+	}
+
+	trusted, err := local.New(dir+"/root", local.WithTrustedPaths(true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := trusted.Stat(ctx, "sub/../x"); errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("trusted Stat = %v", err)
+	}
+}
+
+func TestLocalEngine_SymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on Windows")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	root, outside := filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+	for _, d := range []string{root + "/data", outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(outside+"/secret.txt", []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Links planted by another process, e.g. in an untrusted upload.
+	for link, target := range map[string]string{
+		"escape":   outside,
+		"rel":      "../outside/secret.txt",
+		"dangling": outside + "/new.txt",
+		"chain":    "escape",
+		"inside":   "data",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	engine, err := local.New(root)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, p := range []string{"escape/secret.txt", "rel", "chain/secret.txt"} {
+		if _, err := engine.Open(ctx, p); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Open(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+		}
+		if _, err := engine.Stat(ctx, p); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Stat(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+		}
+	}
+	for _, p := range []string{"escape/new.txt", "dangling"} {
+		if err := engine.Put(ctx, p, strings.NewReader("x")); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Put(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+		}
+	}
+	if err := engine.MkdirAll(ctx, "escape/sub"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("MkdirAll = %v, want %v", err, sbox.ErrInvalid)
+	}
+	if _, err := engine.ReadDir(ctx, "escape"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("ReadDir = %v, want %v", err, sbox.ErrInvalid)
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 1 {
+		t.Errorf("outside holds %v, %v; want only secret.txt", entries, err)
+	}
+
+	// The links themselves can be inspected and removed, and links within
+	// the root are followed.
+	if got, err := engine.Readlink(ctx, "escape"); err != nil || got != outside {
+		t.Errorf("Readlink = %q, %v", got, err)
+	}
+	if _, err := engine.Lstat(ctx, "rel"); err != nil {
+		t.Errorf("Lstat: %v", err)
+	}
+	if err := engine.Remove(ctx, "rel"); err != nil {
+		t.Errorf("Remove: %v", err)
+	}
+	if err := engine.Put(ctx, "inside/f.txt", strings.NewReader("f")); err != nil {
+		t.Errorf("Put through a link within the root: %v", err)
+	}
+	if _, err := os.Stat(root + "/data/f.txt"); err != nil {
+		t.Errorf("file written through a link: %v", err)
+	}
+
+	trusted, err := local.New(root, local.WithTrustedPaths(true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := trusted.Stat(ctx, "escape/secret.txt"); err != nil {
+		t.Errorf("trusted Stat = %v", err)
+	}
+}
+
+func TestLocalEngine_SymlinkTarget(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on Windows")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	engine, err := local.New(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, target := range []string{"/etc", dir, "../../outside", "a/../../../outside", "sub/../../../outside"} {
+		if err := engine.Symlink(ctx, target, "sub/link"); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Symlink(%q) = %v, want %v", target, err, sbox.ErrInvalid)
+		}
+	}
+	if _, err := engine.Lstat(ctx, "sub/link"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Lstat after rejected links = %v", err)
+	}
+	if err := engine.Symlink(ctx, "../data/f.txt", "sub/link"); err != nil {
+		t.Errorf("Symlink within the root: %v", err)
+	}
+
+	trusted, err := local.New(filepath.Join(dir, "root"), local.WithTrustedPaths(true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := trusted.Symlink(ctx, dir, "trusted"); err != nil {
+		t.Errorf("trusted Symlink = %v", err)
+	}
+}
+
+func TestLocalEngine_ContextCancel(t *testing.T) {
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := sbox.PutAtomic(context.Background(), engine, "old.txt", strings.NewReader("data")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := engine.Open(ctx, "old.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	w, err := engine.CreateAtomic(ctx, "new.txt")
+	if err != nil {
+		t.Fatalf("CreateAtomic: %v", err)
+	}
+	cancel()
+
+	if _, err := r.Read(make([]byte, 4)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read after cancel = %v, want %v", err, context.Canceled)
+	}
+	if _, err := w.Write([]byte("x")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write after cancel = %v, want %v", err, context.Canceled)
+	}
+	if err := w.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close after cancel = %v, want %v", err, context.Canceled)
+	}
+	if _, err := engine.Stat(context.Background(), "new.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("canceled file was published: %v", err)
+	}
+}
+
+func TestLocalEngine_Ping(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	engine, err := local.New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := engine.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("Ping left %v", entries)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Ping(ctx); !errors.Is(err, sbox.ErrNotFound) {
+		t.Fatalf("Ping with the root removed = %v", err)
+	}
+}
+
+func TestLocalEngine_Usage(t *testing.T) {
+	ctx := context.Background()
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, p := range []string{"a/b/c.txt", "a/d.txt", "e.txt"} {
+		if err := engine.Put(ctx, p, strings.NewReader("12345")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	u, err := engine.Usage(ctx, "a")
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if u.Files != 2 || u.Dirs != 1 || u.Bytes != 10 || u.Physical <= 0 {
+		t.Errorf("Usage(a) = %+v", u)
+	}
+	if runtime.GOOS == "linux" && (u.Quota == nil || u.Quota.Total <= 0 || u.Quota.Free > u.Quota.Total) {
+		t.Errorf("Quota = %+v", u.Quota)
+	}
+	if u, err := engine.Usage(ctx, "e.txt"); err != nil || u.Files != 1 || u.Bytes != 5 {
+		t.Errorf("Usage(e.txt) = %+v, %v", u, err)
+	}
+	if _, err := engine.Usage(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Usage(missing) = %v", err)
+	}
+}
+
+func TestLocalEngine_MinFreeSpace(t *testing.T) {
+	if _, err := sbox.FreeSpace(t.TempDir()); err != nil {
+		t.Skip("free space cannot be measured here")
+	}
+	ctx := context.Background()
+	engine, err := sbox.Open(&sbox.Config{
+		Type:     "local",
+		BasePath: t.TempDir(),
+		Options:  map[string]any{"minFreeSpace": "1000000000000000000"},
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := engine.Create(ctx, "f.txt"); !errors.Is(err, sbox.ErrNoSpace) {
+		t.Errorf("Create over the reserve = %v", err)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "g.txt", strings.NewReader("a")); !errors.Is(err, sbox.ErrNoSpace) {
+		t.Errorf("PutAtomic over the reserve = %v", err)
+	}
+
+	engine, err = local.New(t.TempDir(), local.WithMinFreeSpace(1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "f.txt", strings.NewReader("a")); err != nil {
+		t.Errorf("PutAtomic under the reserve: %v", err)
+	}
+}
+
+func TestLocalEngine_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine, err := local.New(t.TempDir(), local.WithPollInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := engine.MkdirAll(ctx, "in"); err != nil {
+		t.Fatal(err)
+	}
+	events, err := engine.Watch(ctx, "in", true)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	await := func(want string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Err != nil {
+					t.Fatalf("event error: %v", ev.Err)
+				}
+				if ev.String() == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %q event", want)
+			}
+		}
+	}
+
+	if err := engine.Put(ctx, "in/a/b.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	await("create in/a/b.txt")
+	if err := engine.Remove(ctx, "in/a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	await("remove in/a/b.txt")
+
+	if _, err := engine.Watch(ctx, "missing", false); runtime.GOOS == "linux" && !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Watch(missing) = %v", err)
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestLocalEngine_WalkFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on Windows")
+	}
+	ctx := context.Background()
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "data/sub/f.txt", strings.NewReader("f")); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"data/link": "sub", "data/sub/loop": "..", "data/dangling": "missing"} {
+		if err := engine.Symlink(ctx, target, link); err != nil {
+			t.Fatalf("Symlink %s: %v", link, err)
+		}
+	}
+
+	walk := func(opts ...sbox.WalkOption) string {
+		var got []string
+		err := sbox.Walk(ctx, engine, "data", func(p string, info *sbox.EntryInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			got = append(got, filepath.ToSlash(p))
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatalf("Walk: %v", err)
+		}
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	if got, want := walk(), "data,data/dangling,data/link,data/sub,data/sub/f.txt,data/sub/loop"; got != want {
+		t.Errorf("Walk = %s, want %s", got, want)
+	}
+	got := walk(sbox.WalkFollowSymlinks())
+	if want := "data,data/dangling,data/link,data/link/f.txt,data/link/loop,data/sub,data/sub/f.txt,data/sub/loop"; got != want {
+		t.Errorf("Walk following links = %s, want %s", got, want)
+	}
+	got = walk(sbox.WalkFollowSymlinks(), sbox.WalkOnly(sbox.WalkSymlinks))
+	if want := "data/dangling,data/link/loop,data/sub/loop"; got != want {
+		t.Errorf("Walk following links, links only = %s, want %s", got, want)
+	}
+}
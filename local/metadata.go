@@ -0,0 +1,100 @@
+package local
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/xattr"
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// metadataPrefix namespaces user metadata among the extended attributes of
+// a file.
+const metadataPrefix = "user.sbox."
+
+// osPath returns the path of a file on the OS filesystem. It reports false
+// for engines backed by another afero.Fs, which cannot store metadata.
+func (e *Engine) osPath(path string) (string, bool) {
+	bp, ok := e.fs.(*afero.BasePathFs)
+	if !ok || !e.native {
+		return "", false
+	}
+	p, err := bp.RealPath(path)
+	return p, err == nil
+}
+
+// readMetadata returns the user metadata of path, or nil.
+func (e *Engine) readMetadata(path string) map[string]string {
+	p, ok := e.osPath(path)
+	if !ok {
+		return nil
+	}
+	names, err := xattr.List(p)
+	if err != nil {
+		return nil
+	}
+	var md map[string]string
+	for _, name := range names {
+		key, ok := strings.CutPrefix(name, metadataPrefix)
+		if !ok {
+			continue
+		}
+		value, err := xattr.Get(p, name)
+		if err != nil {
+			continue
+		}
+		if md == nil {
+			md = make(map[string]string)
+		}
+		md[key] = string(value)
+	}
+	return md
+}
+
+// writeMetadata replaces the user metadata of path with md. It returns
+// sbox.ErrNotSupported if the filesystem cannot store extended attributes.
+func (e *Engine) writeMetadata(path string, md map[string]string) error {
+	p, ok := e.osPath(path)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	names, err := xattr.List(p)
+	if err != nil {
+		return xattrError(err)
+	}
+	for _, name := range names {
+		if key, ok := strings.CutPrefix(name, metadataPrefix); ok {
+			if _, keep := md[key]; !keep {
+				if err := xattr.Remove(p, name); err != nil {
+					return xattrError(err)
+				}
+			}
+		}
+	}
+	for key, value := range md {
+		if err := xattr.Set(p, metadataPrefix+key, []byte(value)); err != nil {
+			return xattrError(err)
+		}
+	}
+	return nil
+}
+
+// resetMetadata gives a file just created or overwritten the metadata
+// carried by its context, if any, and clears what it had before.
+// Filesystems without extended attributes silently drop the metadata.
+func (e *Engine) resetMetadata(path string, md map[string]string) error {
+	if err := e.writeMetadata(path, md); err != nil && !errors.Is(err, sbox.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+func xattrError(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) {
+		return sbox.ErrNotSupported
+	}
+	return err
+}
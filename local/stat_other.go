@@ -0,0 +1,15 @@
+//go:build !unix
+
+package local
+
+import "os"
+
+// owner returns zeros: ownership is not exposed on this platform.
+func owner(info os.FileInfo) (uid, gid int) {
+	return 0, 0
+}
+
+// inode returns zero: inode numbers are not exposed on this platform.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
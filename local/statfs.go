@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package local
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/nuln/sbox"
+)
+
+// allocated returns the space allocated to the file of info on disk.
+func allocated(info os.FileInfo) (int64, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512, true //nolint:unconvert // Blocks is not int64 on all platforms
+	}
+	return 0, false
+}
+
+// quota returns the capacity of the filesystem holding dir.
+func quota(dir string) *sbox.Quota {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return nil
+	}
+	bsize := int64(st.Bsize) //nolint:unconvert // Bsize is not int64 on all platforms
+	return &sbox.Quota{
+		Total: int64(st.Blocks) * bsize,
+		Used:  int64(st.Blocks-st.Bfree) * bsize,
+		Free:  int64(st.Bavail) * bsize,
+	}
+}
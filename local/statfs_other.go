@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+
+package local
+
+import (
+	"os"
+
+	"github.com/nuln/sbox"
+)
+
+// allocated reports false: allocation is not exposed on this platform.
+func allocated(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+// quota returns nil: filesystem capacity is not exposed on this platform.
+func quota(dir string) *sbox.Quota {
+	return nil
+}
@@ -0,0 +1,230 @@
+//go:build linux
+
+package local
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/nuln/sbox"
+)
+
+// Masks of the inotify events watched on directories and files.
+const (
+	dirMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+	fileMask = syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+)
+
+// inotify watches a directory tree or a file with an inotify instance.
+type inotify struct {
+	file      *os.File
+	fd        int    // Of file, which Fd would make blocking
+	isDir     bool   // The watched path is a directory
+	osRoot    string // OS path of the watched path
+	root      string // Engine path of the watched path
+	recursive bool
+	watches   map[int]string // Watch descriptor to engine path
+	events    chan sbox.WatchEvent
+}
+
+// watchOS watches osPath, the OS path of the engine path p, with inotify.
+func watchOS(ctx context.Context, osPath, p string, recursive bool) (<-chan sbox.WatchEvent, error) {
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	root := filepath.ToSlash(filepath.Clean(p))
+	if root == "." {
+		root = ""
+	}
+	w := &inotify{
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		fd:        fd,
+		isDir:     info.IsDir(),
+		osRoot:    osPath,
+		root:      root,
+		recursive: recursive,
+		watches:   make(map[int]string),
+		events:    make(chan sbox.WatchEvent, 64),
+	}
+	if w.isDir {
+		err = w.addTree(ctx, root, nil)
+	} else {
+		err = w.add(osPath, root, fileMask)
+	}
+	if err != nil {
+		_ = w.file.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = w.file.Close() // Ends the read loop
+	}()
+	go w.run(ctx)
+	return w.events, nil
+}
+
+// osPath returns the OS path of the engine path p below the watched path.
+func (w *inotify) osPath(p string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, w.root), "/")
+	return filepath.Join(w.osRoot, filepath.FromSlash(rel))
+}
+
+func (w *inotify) add(osPath, p string, mask uint32) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, osPath, mask)
+	if err != nil {
+		return &fs.PathError{Op: "inotify_add_watch", Path: osPath, Err: err}
+	}
+	w.watches[wd] = p
+	return nil
+}
+
+// addTree watches the directory p and, if recursive, the directories
+// below it. With created set, it reports everything below p to it, for
+// directories that appeared after the watch started.
+func (w *inotify) addTree(ctx context.Context, p string, created func(sbox.WatchEvent)) error {
+	if err := w.add(w.osPath(p), p, dirMask); err != nil {
+		return err
+	}
+	if !w.recursive {
+		return nil
+	}
+	osRoot := w.osPath(p)
+	return filepath.WalkDir(osRoot, func(osPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // Removed meanwhile
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if osPath == osRoot {
+			return nil
+		}
+		rel, err := filepath.Rel(osRoot, osPath)
+		if err != nil {
+			return err
+		}
+		sub := pathpkg.Join(p, filepath.ToSlash(rel))
+		if created != nil {
+			created(sbox.WatchEvent{Op: sbox.WatchCreate, Path: sub, IsDir: d.IsDir()})
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		err = w.add(osPath, sub, dirMask)
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		return err
+	})
+}
+
+// remove stops watching the directory p and the directories below it,
+// after it was moved away.
+func (w *inotify) remove(p string) {
+	for wd, wp := range w.watches {
+		if wp == p || strings.HasPrefix(wp, p+"/") {
+			_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
+
+// run reads events until the inotify instance is closed.
+func (w *inotify) run(ctx context.Context) {
+	defer close(w.events)
+	buf := make([]byte, 4096*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf[:])
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, os.ErrClosed) {
+				w.send(ctx, sbox.WatchEvent{Err: err})
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[off:off+int(raw.Len)]), "\x00")
+			off += int(raw.Len)
+			if !w.handle(ctx, int(raw.Wd), raw.Mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle reports one inotify event. It returns false if ctx is canceled.
+func (w *inotify) handle(ctx context.Context, wd int, mask uint32, name string) bool {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return w.send(ctx, sbox.WatchEvent{Err: errors.New("sbox/local: watch: inotify queue overflowed")})
+	}
+	dir, ok := w.watches[wd]
+	if !ok {
+		return true
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+		return true
+	}
+	p := dir
+	if name != "" {
+		p = pathpkg.Join(dir, name)
+	}
+	isDir := mask&syscall.IN_ISDIR != 0
+
+	switch {
+	case mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0:
+		// Below the root, the event in the parent reported it.
+		if dir == w.root {
+			return w.send(ctx, sbox.WatchEvent{Op: sbox.WatchRemove, Path: p, IsDir: w.isDir})
+		}
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		if !w.send(ctx, sbox.WatchEvent{Op: sbox.WatchCreate, Path: p, IsDir: isDir}) {
+			return false
+		}
+		if isDir && w.recursive {
+			ok := true
+			err := w.addTree(ctx, p, func(ev sbox.WatchEvent) {
+				ok = ok && w.send(ctx, ev)
+			})
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				ok = ok && w.send(ctx, sbox.WatchEvent{Err: err})
+			}
+			return ok
+		}
+	case mask&syscall.IN_CLOSE_WRITE != 0:
+		return w.send(ctx, sbox.WatchEvent{Op: sbox.WatchWrite, Path: p})
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		if isDir && mask&syscall.IN_MOVED_FROM != 0 {
+			w.remove(p)
+		}
+		return w.send(ctx, sbox.WatchEvent{Op: sbox.WatchRemove, Path: p, IsDir: isDir})
+	}
+	return true
+}
+
+func (w *inotify) send(ctx context.Context, ev sbox.WatchEvent) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package local
+
+import (
+	"context"
+
+	"github.com/nuln/sbox"
+)
+
+// watchOS returns sbox.ErrNotSupported: inotify is Linux only.
+func watchOS(ctx context.Context, osPath, p string, recursive bool) (<-chan sbox.WatchEvent, error) {
+	return nil, sbox.ErrNotSupported
+}
@@ -0,0 +1,51 @@
+package sbox
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultLockTTL is the lease duration when LockOptions.TTL is not set.
+const DefaultLockTTL = 30 * time.Second
+
+// LockOptions controls a lock acquisition.
+type LockOptions struct {
+	// TTL is how long the lease lasts unless renewed. A holder that dies
+	// blocks others for at most this long.
+	TTL time.Duration
+
+	// Wait is how long Lock waits for a lock held by someone else. Zero
+	// fails at once with ErrLocked.
+	Wait time.Duration
+}
+
+// Locker grants exclusive, time-limited leases on paths, so that several
+// processes writing the same file do not overwrite each other's changes.
+// Locks are advisory: they only exclude other callers of Lock.
+type Locker interface {
+	Lock(ctx context.Context, path string, opts LockOptions) (Lease, error)
+}
+
+// Lease is a held lock. The holder must Renew it before it expires and
+// Release it when done. Renew returns ErrLockLost once the lease has
+// expired; Release does so if the lock was taken over since.
+type Lease interface {
+	Renew(ctx context.Context) error
+	Release(ctx context.Context) error
+
+	// Expires returns when the lease runs out unless renewed.
+	Expires() time.Time
+}
+
+// WithLock runs fn while holding a lease on path from locker.
+func WithLock(ctx context.Context, locker Locker, path string, opts LockOptions, fn func() error) error {
+	lease, err := locker.Lock(ctx, path, opts)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if rerr := lease.Release(ctx); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+// Package lockfile implements sbox.Locker with lock files on an afero.Fs.
+//
+//	locker := lockfile.New(fs, ".locks")
+//	lease, err := locker.Lock(ctx, "db/index", sbox.LockOptions{Wait: time.Minute})
+//	if err != nil {
+//	    return err
+//	}
+//	defer lease.Release(ctx)
+//
+// A lock is a file, created exclusively, that records the holder's token
+// and when its lease expires. An expired lock is taken over by the next
+// caller, so a holder that crashed blocks others for at most the TTL. The
+// filesystem must support O_EXCL, which rules out some network
+// filesystems.
+package lockfile
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// Polling interval bounds while waiting for a held lock.
+const (
+	minRetry = 10 * time.Millisecond
+	maxRetry = time.Second
+)
+
+// Locker implements sbox.Locker. It is safe for concurrent use, also by
+// several processes sharing the filesystem.
+type Locker struct {
+	fs  afero.Fs
+	dir string
+}
+
+// New returns a Locker that keeps its lock files in dir on fs.
+func New(fs afero.Fs, dir string) *Locker {
+	return &Locker{fs: fs, dir: dir}
+}
+
+// state is the content of a lock file.
+type state struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lock acquires the lock on path, polling until opts.Wait has passed.
+func (l *Locker) Lock(ctx context.Context, p string, opts sbox.LockOptions) (sbox.Lease, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = sbox.DefaultLockTTL
+	}
+	if err := l.fs.MkdirAll(l.dir, 0750); err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	lp := l.lockPath(p)
+	deadline := time.Now().Add(opts.Wait)
+	for delay := minRetry; ; delay = min(2*delay, maxRetry) {
+		expires, ok, err := l.tryLock(lp, token, opts.TTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &lease{locker: l, lockPath: lp, token: token, ttl: opts.TTL, expires: expires}, nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, fmt.Errorf("sbox/lockfile: %s: %w", p, sbox.ErrLocked)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(delay, wait)):
+		}
+	}
+}
+
+// lockPath returns the lock file for a logical path.
+func (l *Locker) lockPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+	sum := sha256.Sum256([]byte(p))
+	return filepath.Join(l.dir, hex.EncodeToString(sum[:])+".lock")
+}
+
+// tryLock tries to create the lock file, taking over an expired one. It
+// reports whether the lock was acquired.
+func (l *Locker) tryLock(lp, token string, ttl time.Duration) (time.Time, bool, error) {
+	// A second attempt follows a takeover or a concurrent release.
+	for range 2 {
+		expires := time.Now().Add(ttl)
+		err := l.create(lp, state{Token: token, Expires: expires})
+		if err == nil {
+			return expires, true, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return time.Time{}, false, err
+		}
+
+		held, err := l.read(lp, ttl)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err != nil:
+			return time.Time{}, false, err
+		case time.Now().Before(held.Expires):
+			return time.Time{}, false, nil
+		}
+		if err := l.takeOver(lp, token, held); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// takeOver removes the expired lock held. The lock file is first renamed
+// aside, so that a lock created by someone else in the meantime is put
+// back instead of being deleted.
+func (l *Locker) takeOver(lp, token string, held state) error {
+	aside := lp + "." + token + ".stale"
+	if err := l.fs.Rename(lp, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if moved, err := l.read(aside, 0); err == nil && moved.Token != held.Token {
+		if exists, _ := afero.Exists(l.fs, lp); !exists {
+			return l.fs.Rename(aside, lp)
+		}
+	}
+	return l.fs.Remove(aside)
+}
+
+// create writes a new lock file, failing with os.ErrExist if there is one.
+func (l *Locker) create(lp string, s state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := l.fs.OpenFile(lp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = l.fs.Remove(lp)
+	}
+	return err
+}
+
+// read returns the state of a lock file. A file that cannot be decoded,
+// e.g. because its holder is still writing it or crashed while doing so,
+// counts as held by nobody until ttl after it was last modified.
+func (l *Locker) read(lp string, ttl time.Duration) (state, error) {
+	data, err := afero.ReadFile(l.fs, lp)
+	if err != nil {
+		return state{}, err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		info, serr := l.fs.Stat(lp)
+		if serr != nil {
+			return state{}, serr
+		}
+		return state{Expires: info.ModTime().Add(ttl)}, nil
+	}
+	return s, nil
+}
+
+// newToken returns a random token identifying a lease.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lease is a lock held by this process.
+type lease struct {
+	locker   *Locker
+	lockPath string
+	token    string
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	expires  time.Time
+	released bool
+}
+
+// Renew extends the lease by its TTL. An expired lease cannot be renewed,
+// even if nobody has taken it over yet.
+func (le *lease) Renew(ctx context.Context) error {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if err := le.check(); err != nil {
+		return err
+	}
+	expires := time.Now().Add(le.ttl)
+	data, err := json.Marshal(state{Token: le.token, Expires: expires})
+	if err != nil {
+		return err
+	}
+	tmp := le.lockPath + "." + le.token + ".tmp"
+	if err := afero.WriteFile(le.locker.fs, tmp, data, 0644); err != nil {
+		_ = le.locker.fs.Remove(tmp)
+		return err
+	}
+	if err := le.locker.fs.Rename(tmp, le.lockPath); err != nil {
+		_ = le.locker.fs.Remove(tmp)
+		return err
+	}
+	le.expires = expires
+	return nil
+}
+
+// Release removes the lock, unless it expired and was taken over, in which
+// case it returns sbox.ErrLockLost. Releasing a lease twice is a no-op.
+func (le *lease) Release(ctx context.Context) error {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if le.released {
+		return nil
+	}
+	le.released = true
+	held, err := le.locker.read(le.lockPath, le.ttl)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && held.Token != le.token) {
+		return sbox.ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+	return le.locker.fs.Remove(le.lockPath)
+}
+
+func (le *lease) Expires() time.Time {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.expires
+}
+
+// check verifies that the lease is still held and unexpired.
+func (le *lease) check() error {
+	if le.released || !time.Now().Before(le.expires) {
+		return sbox.ErrLockLost
+	}
+	held, err := le.locker.read(le.lockPath, le.ttl)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && held.Token != le.token) {
+		return sbox.ErrLockLost
+	}
+	return err
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.Locker = (*Locker)(nil)
+	_ sbox.Lease  = (*lease)(nil)
+)
@@ -0,0 +1,138 @@
+package lockfile_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/lockfile"
+)
+
+func TestLock_Exclusive(t *testing.T) {
+	ctx := context.Background()
+	locker := lockfile.New(afero.NewMemMapFs(), "locks")
+	lease, err := locker.Lock(ctx, "a/b", sbox.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := locker.Lock(ctx, "/a//b", sbox.LockOptions{}); !errors.Is(err, sbox.ErrLocked) {
+		t.Errorf("second Lock = %v, want %v", err, sbox.ErrLocked)
+	}
+	other, err := locker.Lock(ctx, "a/c", sbox.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock of another path: %v", err)
+	}
+	_ = other.Release(ctx)
+
+	if err := lease.Renew(ctx); err != nil {
+		t.Errorf("Renew: %v", err)
+	}
+	if err := lease.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := lease.Release(ctx); err != nil {
+		t.Errorf("second Release: %v", err)
+	}
+	if err := lease.Renew(ctx); !errors.Is(err, sbox.ErrLockLost) {
+		t.Errorf("Renew after Release = %v, want %v", err, sbox.ErrLockLost)
+	}
+	again, err := locker.Lock(ctx, "a/b", sbox.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock after Release: %v", err)
+	}
+	_ = again.Release(ctx)
+}
+
+func TestLock_Wait(t *testing.T) {
+	ctx := context.Background()
+	locker := lockfile.New(afero.NewMemMapFs(), "locks")
+	lease, err := locker.Lock(ctx, "f", sbox.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = lease.Release(ctx)
+	}()
+	next, err := locker.Lock(ctx, "f", sbox.LockOptions{Wait: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("waiting Lock: %v", err)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(cctx, "f", sbox.LockOptions{Wait: time.Hour}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock with expiring context = %v", err)
+	}
+	_ = next.Release(ctx)
+}
+
+func TestLock_Expiry(t *testing.T) {
+	ctx := context.Background()
+	locker := lockfile.New(afero.NewMemMapFs(), "locks")
+	stale, err := locker.Lock(ctx, "f", sbox.LockOptions{TTL: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	// An expired lease is taken over and cannot come back.
+	lease, err := locker.Lock(ctx, "f", sbox.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock of an expired lock: %v", err)
+	}
+	if err := stale.Renew(ctx); !errors.Is(err, sbox.ErrLockLost) {
+		t.Errorf("Renew of an expired lease = %v, want %v", err, sbox.ErrLockLost)
+	}
+	if err := stale.Release(ctx); !errors.Is(err, sbox.ErrLockLost) {
+		t.Errorf("Release of a lost lease = %v, want %v", err, sbox.ErrLockLost)
+	}
+	if _, err := locker.Lock(ctx, "f", sbox.LockOptions{}); !errors.Is(err, sbox.ErrLocked) {
+		t.Errorf("Lock after takeover = %v, want %v", err, sbox.ErrLocked)
+	}
+	if err := lease.Release(ctx); err != nil {
+		t.Errorf("Release: %v", err)
+	}
+}
+
+func TestLock_MutualExclusion(t *testing.T) {
+	ctx := context.Background()
+	locker := lockfile.New(afero.NewMemMapFs(), "locks")
+	var (
+		wg      sync.WaitGroup
+		holders int
+		mu      sync.Mutex
+	)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				lease, err := locker.Lock(ctx, "counter", sbox.LockOptions{Wait: 10 * time.Second})
+				if err != nil {
+					t.Errorf("Lock: %v", err)
+					return
+				}
+				mu.Lock()
+				holders++
+				if holders != 1 {
+					t.Errorf("%d holders", holders)
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				if err := lease.Release(ctx); err != nil {
+					t.Errorf("Release: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
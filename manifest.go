@@ -0,0 +1,66 @@
+package sbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// ManifestVersion is the manifest format written by MarshalManifest.
+//
+// Version 1 manifests carry no version field. Version 2 adds the whole-file
+// Hash, CreatedBy and a Checksum over the manifest itself.
+const ManifestVersion = 2
+
+// MarshalManifest encodes m in the current manifest format. It sets
+// m.Version and m.Checksum.
+func MarshalManifest(m *Manifest) ([]byte, error) {
+	m.Version = ManifestVersion
+	sum, err := m.checksum()
+	if err != nil {
+		return nil, err
+	}
+	m.Checksum = sum
+	return json.Marshal(m)
+}
+
+// UnmarshalManifest decodes a manifest of any supported version and
+// encoding into m. Version 2 manifests are checked against their checksum;
+// a mismatch returns an error wrapping ErrCorruptManifest.
+func UnmarshalManifest(data []byte, m *Manifest) error {
+	*m = Manifest{}
+	if isBinaryManifest(data) {
+		return unmarshalBinaryManifest(data, m)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptManifest, err)
+	}
+	switch {
+	case m.Version <= 1:
+		return nil
+	case m.Version > ManifestVersion:
+		return fmt.Errorf("sbox: manifest version %d: %w", m.Version, ErrNotSupported)
+	}
+	sum, err := m.checksum()
+	if err != nil {
+		return err
+	}
+	if sum != m.Checksum {
+		return ErrCorruptManifest
+	}
+	return nil
+}
+
+// checksum returns the SHA-256 of the manifest encoded without its
+// checksum.
+func (m *Manifest) checksum() (string, error) {
+	c := *m
+	c.Checksum = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,152 @@
+package sbox_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+func TestManifest_RoundTrip(t *testing.T) {
+	m := &sbox.Manifest{
+		Chunks:    []string{"aa", "bb"},
+		Size:      10,
+		ModTime:   time.Now(),
+		Hash:      "cafe",
+		CreatedBy: "test",
+	}
+	data, err := sbox.MarshalManifest(m)
+	if err != nil {
+		t.Fatalf("MarshalManifest: %v", err)
+	}
+	if m.Version != sbox.ManifestVersion || m.Checksum == "" {
+		t.Errorf("version %d, checksum %q after marshal", m.Version, m.Checksum)
+	}
+
+	var got sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &got); err != nil {
+		t.Fatalf("UnmarshalManifest: %v", err)
+	}
+	if got.Size != 10 || got.Hash != "cafe" || got.CreatedBy != "test" || len(got.Chunks) != 2 {
+		t.Errorf("decoded %+v", got)
+	}
+
+	// Any change to the encoded manifest is detected.
+	tampered := bytes.Replace(data, []byte(`"size":10`), []byte(`"size":11`), 1)
+	if err := sbox.UnmarshalManifest(tampered, &got); !errors.Is(err, sbox.ErrCorruptManifest) {
+		t.Errorf("tampered manifest: %v, want %v", err, sbox.ErrCorruptManifest)
+	}
+	if err := sbox.UnmarshalManifest(data[:len(data)/2], &got); !errors.Is(err, sbox.ErrCorruptManifest) {
+		t.Errorf("truncated manifest: %v, want %v", err, sbox.ErrCorruptManifest)
+	}
+}
+
+func TestManifest_Versions(t *testing.T) {
+	var m sbox.Manifest
+	v1 := []byte(`{"chunks":["aa"],"size":3,"modTime":"2024-01-01T00:00:00Z"}`)
+	if err := sbox.UnmarshalManifest(v1, &m); err != nil {
+		t.Fatalf("version 1: %v", err)
+	}
+	if m.Version != 0 || m.Size != 3 {
+		t.Errorf("version 1 decoded as %+v", m)
+	}
+
+	future := []byte(`{"version":99,"chunks":[],"size":0,"modTime":"2024-01-01T00:00:00Z"}`)
+	if err := sbox.UnmarshalManifest(future, &m); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("version 99: %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+func TestManifest_Binary(t *testing.T) {
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	m := &sbox.Manifest{
+		Chunks:      []string{hash, sbox.HoleChunk, "not-a-sha256"},
+		ChunkSizes:  []int64{4, 8, 2},
+		Compressed:  []bool{true, false, true},
+		StoredSizes: []int64{3, 0, 2},
+		Keys:        []string{"k1", "", "k3"},
+		Size:        14,
+		ModTime:     time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Hash:        "cafe",
+		CreatedBy:   "test",
+		Mode:        0640,
+		Uid:         1000,
+		Gid:         -1,
+		LinkTarget:  "target",
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"a": "1", "b": "2"},
+	}
+	data, err := sbox.MarshalManifestAs(m, sbox.ManifestBinary)
+	if err != nil {
+		t.Fatalf("MarshalManifestAs: %v", err)
+	}
+	if m.Version != sbox.ManifestVersion || m.Checksum != "" {
+		t.Errorf("version %d, checksum %q after marshal", m.Version, m.Checksum)
+	}
+	if enc := sbox.ManifestEncodingOf(data); enc != sbox.ManifestBinary {
+		t.Errorf("ManifestEncodingOf = %q, want %q", enc, sbox.ManifestBinary)
+	}
+
+	var got sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &got); err != nil {
+		t.Fatalf("UnmarshalManifest: %v", err)
+	}
+	if !got.ModTime.Equal(m.ModTime) {
+		t.Errorf("ModTime = %v, want %v", got.ModTime, m.ModTime)
+	}
+	got.ModTime = m.ModTime
+	if !reflect.DeepEqual(&got, m) {
+		t.Errorf("decoded %+v, want %+v", got, *m)
+	}
+
+	// Any change to the encoded manifest is detected.
+	for i := len(data) / 2; i < len(data); i += 7 {
+		tampered := bytes.Clone(data)
+		tampered[i] ^= 1
+		if err := sbox.UnmarshalManifest(tampered, &got); !errors.Is(err, sbox.ErrCorruptManifest) {
+			t.Errorf("byte %d flipped: %v, want %v", i, err, sbox.ErrCorruptManifest)
+		}
+	}
+	if err := sbox.UnmarshalManifest(data[:len(data)/2], &got); !errors.Is(err, sbox.ErrCorruptManifest) {
+		t.Errorf("truncated manifest: %v, want %v", err, sbox.ErrCorruptManifest)
+	}
+	future := bytes.Clone(data)
+	future[4]++
+	if err := sbox.UnmarshalManifest(future, &got); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("future format: %v, want %v", err, sbox.ErrNotSupported)
+	}
+	if _, err := sbox.MarshalManifestAs(m, "xml"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("unknown encoding: %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+func TestManifest_BinarySize(t *testing.T) {
+	m := &sbox.Manifest{ModTime: time.Now()}
+	for i := range 10000 {
+		m.Chunks = append(m.Chunks, fmt.Sprintf("%064x", i))
+		m.ChunkSizes = append(m.ChunkSizes, 65536)
+		m.Size += 65536
+	}
+	jsonData, err := sbox.MarshalManifestAs(m, sbox.ManifestJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binData, err := sbox.MarshalManifestAs(m, sbox.ManifestBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbox.ManifestEncodingOf(jsonData) != sbox.ManifestJSON {
+		t.Error("JSON manifest not reported as JSON")
+	}
+	if 2*len(binData) > len(jsonData) {
+		t.Errorf("binary manifest of %d bytes, JSON of %d", len(binData), len(jsonData))
+	}
+	var got sbox.Manifest
+	if err := sbox.UnmarshalManifest(binData, &got); err != nil || len(got.Chunks) != 10000 || got.Chunks[9999] != m.Chunks[9999] {
+		t.Errorf("UnmarshalManifest = %v, %d chunks", err, len(got.Chunks))
+	}
+}
@@ -0,0 +1,331 @@
+package sbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"slices"
+)
+
+// ManifestEncoding selects how MarshalManifestAs encodes manifests.
+// UnmarshalManifest reads all of them.
+type ManifestEncoding string
+
+const (
+	// ManifestJSON is the JSON encoding of MarshalManifest, readable by
+	// every version of sbox.
+	ManifestJSON ManifestEncoding = "json"
+
+	// ManifestBinary is a compact binary encoding, several times smaller
+	// and faster to decode than JSON for files of many chunks, which
+	// versions of sbox before it cannot read. See MarshalManifestAs.
+	ManifestBinary ManifestEncoding = "binary"
+)
+
+// binaryManifestMagic starts every binary manifest, followed by the
+// version of the binary format. JSON cannot start with a zero byte, so it
+// tells the encodings apart.
+var binaryManifestMagic = []byte("\x00sbm")
+
+// binaryManifestFormat is the version of the binary format written.
+const binaryManifestFormat = 1
+
+// Flags of the optional per-chunk fields present in a binary manifest.
+const (
+	binChunkSizes = 1 << iota
+	binCompressed
+	binStoredSizes
+	binKeys
+)
+
+// Forms of a chunk hash in a binary manifest.
+const (
+	binChunkHole   = iota // HoleChunk
+	binChunkSHA256        // 32 bytes, for lowercase hex SHA-256 hashes
+	binChunkString        // Any other hash, as a string
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalManifestAs encodes m in the current manifest format with enc;
+// an empty enc selects ManifestJSON. It sets m.Version, and m.Checksum for
+// JSON. Binary manifests hold the magic bytes "\x00sbm", the version of
+// the binary format, the fields of the manifest as varints and
+// length-prefixed strings, with SHA-256 chunk hashes as raw bytes, and a
+// CRC-32C of all that in place of the Checksum field, left empty.
+func MarshalManifestAs(m *Manifest, enc ManifestEncoding) ([]byte, error) {
+	switch enc {
+	case "", ManifestJSON:
+		return MarshalManifest(m)
+	case ManifestBinary:
+	default:
+		return nil, fmt.Errorf("sbox: manifest encoding %q: %w", enc, ErrNotSupported)
+	}
+	m.Version = ManifestVersion
+	m.Checksum = ""
+	modTime, err := m.ModTime.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	b := append(slices.Clone(binaryManifestMagic), binaryManifestFormat)
+	b = binary.AppendUvarint(b, uint64(m.Version))
+	b = binary.AppendVarint(b, m.Size)
+	b = appendBytes(b, modTime)
+	b = binary.AppendUvarint(b, uint64(len(m.Chunks)))
+	var raw [32]byte
+	for _, c := range m.Chunks {
+		switch {
+		case c == HoleChunk:
+			b = append(b, binChunkHole)
+		case len(c) == 64 && isLowerHex(c):
+			_, _ = hex.Decode(raw[:], []byte(c))
+			b = append(append(b, binChunkSHA256), raw[:]...)
+		default:
+			b = appendBytes(append(b, binChunkString), []byte(c))
+		}
+	}
+
+	var flags uint64
+	if m.ChunkSizes != nil {
+		flags |= binChunkSizes
+	}
+	if m.Compressed != nil {
+		flags |= binCompressed
+	}
+	if m.StoredSizes != nil {
+		flags |= binStoredSizes
+	}
+	if m.Keys != nil {
+		flags |= binKeys
+	}
+	b = binary.AppendUvarint(b, flags)
+	if m.ChunkSizes != nil {
+		b = appendInts(b, m.ChunkSizes)
+	}
+	if m.Compressed != nil {
+		b = binary.AppendUvarint(b, uint64(len(m.Compressed)))
+		bits := make([]byte, (len(m.Compressed)+7)/8)
+		for i, c := range m.Compressed {
+			if c {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		b = append(b, bits...)
+	}
+	if m.StoredSizes != nil {
+		b = appendInts(b, m.StoredSizes)
+	}
+	if m.Keys != nil {
+		b = binary.AppendUvarint(b, uint64(len(m.Keys)))
+		for _, k := range m.Keys {
+			b = appendBytes(b, []byte(k))
+		}
+	}
+
+	for _, s := range []string{m.Hash, m.CreatedBy, m.LinkTarget, m.ContentType} {
+		b = appendBytes(b, []byte(s))
+	}
+	b = binary.AppendUvarint(b, uint64(m.Mode))
+	b = binary.AppendVarint(b, int64(m.Uid))
+	b = binary.AppendVarint(b, int64(m.Gid))
+	keys := make([]string, 0, len(m.Metadata))
+	for k := range m.Metadata {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendBytes(appendBytes(b, []byte(k)), []byte(m.Metadata[k]))
+	}
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(b, castagnoli)), nil
+}
+
+func appendBytes(b, s []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+func appendInts(b []byte, s []int64) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	for _, v := range s {
+		b = binary.AppendVarint(b, v)
+	}
+	return b
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// errBinaryManifest reports a binary manifest that ends early or holds
+// impossible values.
+var errBinaryManifest = errors.New("malformed binary manifest")
+
+// manifestDecoder reads the fields of a binary manifest, recording the
+// first error.
+type manifestDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *manifestDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errBinaryManifest
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *manifestDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errBinaryManifest
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads the number of elements of a list, at most the bytes left
+// so that a corrupt count allocates nothing large.
+func (d *manifestDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.err = errBinaryManifest
+		return 0
+	}
+	return int(n)
+}
+
+func (d *manifestDecoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.err = errBinaryManifest
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *manifestDecoder) string() string {
+	return string(d.bytes(d.count()))
+}
+
+func (d *manifestDecoder) ints() []int64 {
+	s := make([]int64, d.count())
+	for i := range s {
+		s[i] = d.varint()
+	}
+	return s
+}
+
+// unmarshalBinaryManifest decodes a manifest written with ManifestBinary.
+func unmarshalBinaryManifest(data []byte, m *Manifest) error {
+	if len(data) > len(binaryManifestMagic) && data[len(binaryManifestMagic)] > binaryManifestFormat {
+		return fmt.Errorf("sbox: binary manifest format %d: %w", data[len(binaryManifestMagic)], ErrNotSupported)
+	}
+	body := data[:max(len(data)-4, 0)]
+	if len(body) <= len(binaryManifestMagic) || crc32.Checksum(body, castagnoli) != binary.BigEndian.Uint32(data[len(body):]) {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptManifest)
+	}
+	d := &manifestDecoder{data: body[len(binaryManifestMagic)+1:]}
+	m.Version = int(d.uvarint())
+	if m.Version > ManifestVersion {
+		return fmt.Errorf("sbox: manifest version %d: %w", m.Version, ErrNotSupported)
+	}
+	m.Size = d.varint()
+	if err := m.ModTime.UnmarshalBinary(d.bytes(d.count())); err != nil && d.err == nil {
+		d.err = err
+	}
+
+	m.Chunks = make([]string, d.count())
+	for i := range m.Chunks {
+		switch form := d.bytes(1); {
+		case form == nil:
+		case form[0] == binChunkHole:
+			m.Chunks[i] = HoleChunk
+		case form[0] == binChunkSHA256:
+			m.Chunks[i] = hex.EncodeToString(d.bytes(32))
+		case form[0] == binChunkString:
+			m.Chunks[i] = d.string()
+		default:
+			d.err = errBinaryManifest
+		}
+	}
+
+	flags := d.uvarint()
+	if flags&binChunkSizes != 0 {
+		m.ChunkSizes = d.ints()
+	}
+	if flags&binCompressed != 0 {
+		m.Compressed = make([]bool, d.count())
+		bits := d.bytes((len(m.Compressed) + 7) / 8)
+		for i := range m.Compressed {
+			if bits != nil {
+				m.Compressed[i] = bits[i/8]&(1<<(i%8)) != 0
+			}
+		}
+	}
+	if flags&binStoredSizes != 0 {
+		m.StoredSizes = d.ints()
+	}
+	if flags&binKeys != 0 {
+		m.Keys = make([]string, d.count())
+		for i := range m.Keys {
+			m.Keys[i] = d.string()
+		}
+	}
+
+	m.Hash, m.CreatedBy, m.LinkTarget, m.ContentType = d.string(), d.string(), d.string(), d.string()
+	m.Mode = os.FileMode(d.uvarint())
+	m.Uid, m.Gid = int(d.varint()), int(d.varint())
+	if n := d.count(); n > 0 {
+		m.Metadata = make(map[string]string, n)
+		for range n {
+			k := d.string()
+			m.Metadata[k] = d.string()
+		}
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errBinaryManifest
+	}
+	if d.err != nil {
+		*m = Manifest{}
+		return fmt.Errorf("%w: %v", ErrCorruptManifest, d.err)
+	}
+	return nil
+}
+
+// isBinaryManifest reports whether data is a binary manifest.
+func isBinaryManifest(data []byte) bool {
+	return bytes.HasPrefix(data, binaryManifestMagic)
+}
+
+// ManifestEncodingOf returns the encoding of the manifest data, which it
+// does not validate.
+func ManifestEncodingOf(data []byte) ManifestEncoding {
+	if isBinaryManifest(data) {
+		return ManifestBinary
+	}
+	return ManifestJSON
+}
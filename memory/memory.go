@@ -0,0 +1,77 @@
+// Package memory provides an in-memory storage driver backed by
+// afero.MemMapFs. It is intended for tests and ephemeral data.
+package memory
+
+import (
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/local"
+)
+
+// Auto-register memory storage driver.
+func init() {
+	sbox.Register("memory", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
+		name := ""
+		if v, ok := cfg.Options["name"]; ok {
+			name, _ = v.(string)
+		}
+		if name == "" {
+			return New(), nil
+		}
+		return NewNamed(name), nil
+	})
+}
+
+// Engine implements sbox.StorageEngine entirely in memory. It supports the
+// same extensions as the local driver.
+type Engine struct {
+	*local.Engine
+}
+
+var (
+	mu     sync.Mutex
+	stores = make(map[string]afero.Fs)
+)
+
+// New creates an empty, private in-memory Engine.
+func New() *Engine {
+	return &Engine{Engine: local.NewWithFs(afero.NewMemMapFs())}
+}
+
+// NewNamed returns an Engine backed by the process-wide store with the given
+// name, creating it on first use. Engines opened with the same name share
+// their contents, which lets separately configured components see each
+// other's files.
+func NewNamed(name string) *Engine {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fs, ok := stores[name]
+	if !ok {
+		fs = afero.NewMemMapFs()
+		stores[name] = fs
+	}
+	return &Engine{Engine: local.NewWithFs(fs)}
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine     = (*Engine)(nil)
+	_ sbox.Copier            = (*Engine)(nil)
+	_ sbox.Hasher            = (*Engine)(nil)
+	_ sbox.StreamReader      = (*Engine)(nil)
+	_ sbox.StreamWriter      = (*Engine)(nil)
+	_ sbox.Appender          = (*Engine)(nil)
+	_ sbox.Lister            = (*Engine)(nil)
+	_ sbox.MetadataWriter    = (*Engine)(nil)
+	_ sbox.Symlinker         = (*Engine)(nil)
+	_ sbox.PermissionManager = (*Engine)(nil)
+	_ sbox.Locker            = (*Engine)(nil)
+	_ sbox.ConditionalWriter = (*Engine)(nil)
+	_ sbox.HealthChecker     = (*Engine)(nil)
+	_ sbox.UsageReporter     = (*Engine)(nil)
+	_ sbox.Watcher           = (*Engine)(nil)
+)
@@ -0,0 +1,46 @@
+package memory_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func TestMemoryEngine(t *testing.T) {
+	engine, err := sbox.Open(&sbox.Config{Type: "memory"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sboxtest.Run(t, engine, sboxtest.Options{})
+}
+
+func TestMemoryEngine_Named(t *testing.T) {
+	ctx := context.Background()
+	a, err := sbox.OpenURL("memory://?name=shared-test")
+	if err != nil {
+		t.Fatalf("OpenURL: %v", err)
+	}
+	b := memory.NewNamed("shared-test")
+
+	w, _ := a.Create(ctx, "shared.txt")
+	_, _ = io.WriteString(w, "visible to both")
+	_ = w.Close()
+
+	r, err := b.Open(ctx, "shared.txt")
+	if err != nil {
+		t.Fatalf("Open via second engine: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "visible to both" {
+		t.Errorf("content = %q, want %q", string(data), "visible to both")
+	}
+
+	if _, err := memory.New().Stat(ctx, "shared.txt"); err == nil {
+		t.Error("private engine should not see named store contents")
+	}
+}
@@ -0,0 +1,25 @@
+package sbox
+
+import (
+	"context"
+	"maps"
+)
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying user metadata for the files
+// written with it. Engines that persist metadata store it with every file
+// created or overwritten through Create, OpenFile, Put, CreateAtomic or
+// Append using the returned context, replacing any metadata the file had;
+// Stat reports it in EntryInfo.Metadata. Without metadata in the context,
+// overwriting a file clears its metadata while appending keeps it.
+func WithMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, maps.Clone(metadata))
+}
+
+// MetadataFromContext returns the metadata attached to ctx by
+// [WithMetadata], or nil. It is meant for engine implementations.
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
@@ -0,0 +1,407 @@
+// Package cache provides a storage middleware that fronts a slow engine,
+// typically rclone, with a fast one such as local or memory.
+//
+//	engine := cache.New(remote, local.New("/var/cache/sbox"),
+//	    cache.WithMaxSize(10<<30), cache.WithTTL(time.Hour))
+//
+// Reads are served from the cache tier when the file is present and fresh,
+// and otherwise fetched from the remote and stored in the cache. Writes go
+// to both tiers. Cached files are evicted least-recently-used first once the
+// cache exceeds its size limit, and are refetched after their TTL expires.
+//
+// The cache tier should be dedicated to one Engine: its index lives in
+// memory, so files left in the cache tier by a previous process are not
+// accounted for and are simply overwritten on the next miss.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// DefaultMaxSize is the cache size limit used when WithMaxSize is not given.
+const DefaultMaxSize = 1 << 30
+
+// Stats reports cache effectiveness counters.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Size      int64
+}
+
+// entry is a cached file.
+type entry struct {
+	path    string
+	info    *sbox.EntryInfo // remote metadata at the time of caching
+	expires time.Time       // zero if entries never expire
+}
+
+// Engine is a read-through, write-through cache over a remote engine.
+type Engine struct {
+	remote  sbox.StorageEngine
+	cache   sbox.StorageEngine
+	maxSize int64
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used at the front
+	entries map[string]*list.Element
+	size    int64
+	stats   Stats
+}
+
+// New returns an Engine caching remote in cache.
+func New(remote, cache sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{
+		remote:  remote,
+		cache:   cache,
+		maxSize: DefaultMaxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Remote returns the engine being cached.
+func (e *Engine) Remote() sbox.StorageEngine {
+	return e.remote
+}
+
+// Stats returns a snapshot of the cache counters.
+func (e *Engine) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.stats
+	s.Entries = len(e.entries)
+	s.Size = e.size
+	return s
+}
+
+// Close closes both tiers if they implement io.Closer.
+func (e *Engine) Close() error {
+	return errors.Join(sbox.Close(e.remote), sbox.Close(e.cache))
+}
+
+// lookup returns the fresh entry for p, marking it recently used. Expired
+// entries are evicted.
+func (e *Engine) lookup(p string) *entry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	el, ok := e.entries[p]
+	if !ok {
+		e.stats.Misses++
+		return nil
+	}
+	ent := el.Value.(*entry)
+	if !ent.expires.IsZero() && time.Now().After(ent.expires) {
+		e.removeLocked(el)
+		e.stats.Misses++
+		return nil
+	}
+	e.lru.MoveToFront(el)
+	e.stats.Hits++
+	return ent
+}
+
+// add records p as cached and evicts old entries beyond the size limit.
+func (e *Engine) add(p string, info *sbox.EntryInfo) {
+	ent := &entry{path: p, info: info}
+	if e.ttl > 0 {
+		ent.expires = time.Now().Add(e.ttl)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if el, ok := e.entries[p]; ok {
+		e.size -= el.Value.(*entry).info.Size
+		e.lru.Remove(el)
+	}
+	e.entries[p] = e.lru.PushFront(ent)
+	e.size += info.Size
+
+	for e.size > e.maxSize && e.lru.Len() > 1 {
+		e.removeLocked(e.lru.Back())
+		e.stats.Evictions++
+	}
+}
+
+// removeLocked drops el from the index and deletes the cached copy.
+func (e *Engine) removeLocked(el *list.Element) {
+	ent := el.Value.(*entry)
+	e.lru.Remove(el)
+	delete(e.entries, ent.path)
+	e.size -= ent.info.Size
+	_ = e.cache.Remove(context.Background(), ent.path)
+}
+
+// invalidate drops p and everything below it from the cache.
+func (e *Engine) invalidate(p string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for key, el := range e.entries {
+		if key == p || p == "" || strings.HasPrefix(key, prefix) {
+			e.removeLocked(el)
+		}
+	}
+}
+
+// fetch copies p from the remote into the cache tier.
+func (e *Engine) fetch(ctx context.Context, p string, info *sbox.EntryInfo) error {
+	r, err := e.remote.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	if dir := path.Dir(p); dir != "." {
+		if err := e.cache.MkdirAll(ctx, dir); err != nil {
+			return err
+		}
+	}
+	if err := sbox.PutAtomic(ctx, e.cache, p, r); err != nil {
+		return err
+	}
+	e.add(p, info)
+	return nil
+}
+
+// Stat returns cached metadata for fresh entries and asks the remote
+// otherwise.
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	if ent := e.lookup(path); ent != nil {
+		info := *ent.info
+		return &info, nil
+	}
+	return e.remote.Stat(ctx, path)
+}
+
+// Open serves path from the cache tier, fetching it from the remote on a
+// miss. Files larger than the cache, and files that cannot be cached, are
+// read from the remote directly.
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	if e.lookup(path) != nil {
+		r, err := e.cache.Open(ctx, path)
+		if err == nil {
+			return r, nil
+		}
+		// The cached copy vanished underneath us; forget it.
+		e.invalidate(path)
+	}
+
+	info, err := e.remote.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir || info.Size > e.maxSize {
+		return e.remote.Open(ctx, path)
+	}
+	if err := e.fetch(ctx, path, info); err != nil {
+		return e.remote.Open(ctx, path)
+	}
+	r, err := e.cache.Open(ctx, path)
+	if err != nil {
+		return e.remote.Open(ctx, path)
+	}
+	return r, nil
+}
+
+// Create writes to the remote and, when the cache tier supports atomic
+// writes, to the cache at the same time.
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	e.invalidate(path)
+	w, err := e.remote.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return e.newWriter(ctx, path, w), nil
+}
+
+// OpenFile writes to the remote only; the cached copy is dropped when the
+// file is opened and again when it is closed.
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	e.invalidate(path)
+	w, err := e.remote.OpenFile(ctx, path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingFile{WriteSeekCloser: w, engine: e, path: path}, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	err := e.remote.Remove(ctx, path)
+	e.invalidate(path)
+	return err
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	err := e.remote.Rename(ctx, oldPath, newPath)
+	e.invalidate(oldPath)
+	e.invalidate(newPath)
+	return err
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	return e.remote.MkdirAll(ctx, path)
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return e.remote.ReadDir(ctx, path)
+}
+
+// === Extension: Copier ===
+
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.remote.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	err := c.Copy(ctx, src, dst)
+	e.invalidate(dst)
+	return err
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writer tees data to the remote and to an atomic writer on the cache
+// tier. The cached copy is published only once the remote write succeeds.
+type writer struct {
+	engine *Engine
+	ctx    context.Context
+	path   string
+	remote io.WriteCloser
+	cached sbox.AtomicWriteCloser // nil once caching has been abandoned
+	size   int64
+}
+
+func (e *Engine) newWriter(ctx context.Context, p string, remote io.WriteCloser) *writer {
+	w := &writer{engine: e, ctx: ctx, path: p, remote: remote}
+	if aw, ok := e.cache.(sbox.AtomicWriter); ok {
+		if dir := path.Dir(p); dir == "." || e.cache.MkdirAll(ctx, dir) == nil {
+			w.cached, _ = aw.CreateAtomic(ctx, p)
+		}
+	}
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.remote.Write(p)
+	w.size += int64(n)
+	if w.cached != nil {
+		if w.size > w.engine.maxSize {
+			w.abandon()
+		} else if _, cerr := w.cached.Write(p[:n]); cerr != nil {
+			w.abandon()
+		}
+	}
+	return n, err
+}
+
+// abandon stops caching; the remote write continues.
+func (w *writer) abandon() {
+	_ = w.cached.Abort()
+	w.cached = nil
+}
+
+func (w *writer) Close() error {
+	if err := w.remote.Close(); err != nil {
+		if w.cached != nil {
+			w.abandon()
+		}
+		return err
+	}
+	if w.cached == nil {
+		return nil
+	}
+	if err := w.cached.Close(); err != nil {
+		w.cached = nil
+		return nil
+	}
+	w.cached = nil
+	if info, err := w.engine.remote.Stat(w.ctx, w.path); err == nil {
+		w.engine.add(w.path, info)
+	} else {
+		_ = w.engine.cache.Remove(w.ctx, w.path)
+	}
+	return nil
+}
+
+// invalidatingFile drops the cached copy of path when it is closed.
+type invalidatingFile struct {
+	sbox.WriteSeekCloser
+	engine *Engine
+	path   string
+}
+
+func (f *invalidatingFile) Close() error {
+	err := f.WriteSeekCloser.Close()
+	f.engine.invalidate(f.path)
+	return err
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks the remote and the cache engine with sbox.Ping.
+func (e *Engine) Ping(ctx context.Context) error {
+	if err := sbox.Ping(ctx, e.remote); err != nil {
+		return err
+	}
+	return sbox.Ping(ctx, e.cache)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier only if the remote engine supports it.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy:
+		return sbox.Supports(e.remote, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+	_ sbox.HealthChecker      = (*Engine)(nil)
+)
@@ -0,0 +1,159 @@
+package cache_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/cache"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+// countingEngine counts Open calls on the remote tier.
+type countingEngine struct {
+	*memory.Engine
+	opens atomic.Int64
+}
+
+func (c *countingEngine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	c.opens.Add(1)
+	return c.Engine.Open(ctx, path)
+}
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	w, err := engine.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestCache_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, cache.New(memory.New(), memory.New()))
+}
+
+func TestCache_ReadThrough(t *testing.T) {
+	remote := &countingEngine{Engine: memory.New()}
+	engine := cache.New(remote, memory.New())
+
+	writeFile(t, remote, "dir/remote.txt", "from remote")
+	for i := 0; i < 3; i++ {
+		if got := readFile(t, engine, "dir/remote.txt"); got != "from remote" {
+			t.Fatalf("read %d = %q", i, got)
+		}
+	}
+	if n := remote.opens.Load(); n != 1 {
+		t.Errorf("remote opens = %d, want 1", n)
+	}
+	if s := engine.Stats(); s.Hits != 2 || s.Misses != 1 || s.Entries != 1 {
+		t.Errorf("stats = %+v, want 2 hits, 1 miss, 1 entry", s)
+	}
+}
+
+func TestCache_WriteThrough(t *testing.T) {
+	remote := &countingEngine{Engine: memory.New()}
+	local := memory.New()
+	engine := cache.New(remote, local)
+
+	writeFile(t, engine, "new.txt", "written once")
+	if got := readFile(t, remote, "new.txt"); got != "written once" {
+		t.Errorf("remote copy = %q", got)
+	}
+	remote.opens.Store(0)
+
+	if got := readFile(t, engine, "new.txt"); got != "written once" {
+		t.Errorf("cached read = %q", got)
+	}
+	if n := remote.opens.Load(); n != 0 {
+		t.Errorf("remote opens after write-through = %d, want 0", n)
+	}
+
+	// Removing through the cache drops both copies.
+	if err := engine.Remove(context.Background(), "new.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := local.Stat(context.Background(), "new.txt"); err == nil {
+		t.Error("cached copy survived Remove")
+	}
+}
+
+func TestCache_EvictionBySize(t *testing.T) {
+	remote := memory.New()
+	local := memory.New()
+	engine := cache.New(remote, local, cache.WithMaxSize(25))
+
+	for _, name := range []string{"a", "b", "c"} {
+		writeFile(t, remote, name, strings.Repeat(name, 10))
+	}
+	readFile(t, engine, "a")
+	readFile(t, engine, "b")
+	readFile(t, engine, "a") // a is now more recently used than b
+	readFile(t, engine, "c")
+
+	if _, err := local.Stat(context.Background(), "b"); err == nil {
+		t.Error("least recently used file b was not evicted")
+	}
+	for _, name := range []string{"a", "c"} {
+		if _, err := local.Stat(context.Background(), name); err != nil {
+			t.Errorf("%s evicted: %v", name, err)
+		}
+	}
+	if s := engine.Stats(); s.Evictions != 1 || s.Size != 20 {
+		t.Errorf("stats = %+v, want 1 eviction and size 20", s)
+	}
+
+	// Files larger than the cache bypass it.
+	writeFile(t, remote, "huge", strings.Repeat("x", 100))
+	if got := readFile(t, engine, "huge"); len(got) != 100 {
+		t.Errorf("huge read %d bytes", len(got))
+	}
+	if _, err := local.Stat(context.Background(), "huge"); err == nil {
+		t.Error("file larger than the cache was cached")
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	remote := &countingEngine{Engine: memory.New()}
+	engine := cache.New(remote, memory.New(), cache.WithTTL(20*time.Millisecond))
+
+	writeFile(t, remote, "f.txt", "v1")
+	readFile(t, engine, "f.txt")
+	readFile(t, engine, "f.txt")
+	if n := remote.opens.Load(); n != 1 {
+		t.Fatalf("remote opens before expiry = %d, want 1", n)
+	}
+
+	writeFile(t, remote, "f.txt", "v2")
+	time.Sleep(50 * time.Millisecond)
+	if got := readFile(t, engine, "f.txt"); got != "v2" {
+		t.Errorf("read after expiry = %q, want %q", got, "v2")
+	}
+	if n := remote.opens.Load(); n != 2 {
+		t.Errorf("remote opens after expiry = %d, want 2", n)
+	}
+}
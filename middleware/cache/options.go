@@ -0,0 +1,25 @@
+package cache
+
+import "time"
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithMaxSize limits the total size of cached files in bytes. Least recently
+// used files are evicted once the limit is exceeded, and files larger than
+// the limit are never cached.
+func WithMaxSize(n int64) Option {
+	return func(e *Engine) {
+		if n > 0 {
+			e.maxSize = n
+		}
+	}
+}
+
+// WithTTL makes cached files expire after d, after which they are fetched
+// from the remote again. The default is to keep files until evicted.
+func WithTTL(d time.Duration) Option {
+	return func(e *Engine) {
+		e.ttl = d
+	}
+}
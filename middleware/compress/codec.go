@@ -0,0 +1,60 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is a streaming compression algorithm. Name identifies the codec in
+// the trailer of every file it writes and must be at most 8 bytes long.
+type Codec interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip returns a gzip codec using the given compression level, for example
+// gzip.DefaultCompression or gzip.BestSpeed.
+func Gzip(level int) Codec {
+	return gzipCodec{level: level}
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Zstd returns a zstd codec using the given encoder level, for example
+// zstd.SpeedDefault or zstd.SpeedBetterCompression.
+func Zstd(level zstd.EncoderLevel) Codec {
+	return zstdCodec{level: level}
+}
+
+type zstdCodec struct {
+	level zstd.EncoderLevel
+}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (c zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(c.level), zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
@@ -0,0 +1,373 @@
+// Package compress provides a storage middleware that transparently
+// compresses file data on write and decompresses it on read, for any
+// sbox.StorageEngine.
+//
+//	engine := compress.New(inner, compress.WithCodec(compress.Gzip(gzip.BestSpeed)))
+//
+// Each compressed file ends with a small trailer recording the codec and
+// the original size, so Stat and ReadDir report logical sizes and files
+// written with different codecs can be mixed in one store. Files matching
+// an excluded extension or sniffed content type are stored as-is, without
+// a trailer, as are files written to the inner engine directly.
+package compress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/nuln/sbox"
+)
+
+const (
+	// trailerMagic marks a file written by this middleware.
+	trailerMagic = "SBOXCMP1"
+	// codecNameLen is the space reserved for the codec name in the trailer.
+	codecNameLen = 8
+	// trailerSize is magic + codec name + big-endian uint64 original size.
+	trailerSize = len(trailerMagic) + codecNameLen + 8
+	// sniffLen is the number of bytes http.DetectContentType considers.
+	sniffLen = 512
+)
+
+// DefaultExcludeExtensions lists extensions of formats that are already
+// compressed and are stored as-is by default.
+var DefaultExcludeExtensions = []string{
+	".7z", ".br", ".bz2", ".gz", ".rar", ".tgz", ".xz", ".zip", ".zst",
+	".avif", ".gif", ".heic", ".jpeg", ".jpg", ".png", ".webp",
+	".aac", ".flac", ".m4a", ".mp3", ".ogg", ".opus",
+	".avi", ".m4v", ".mkv", ".mov", ".mp4", ".webm",
+}
+
+// DefaultExcludeContentTypes lists sniffed content types that are stored
+// as-is by default.
+var DefaultExcludeContentTypes = []string{
+	"image/", "audio/", "video/",
+	"application/zip", "application/x-gzip", "application/x-rar-compressed",
+}
+
+// Engine wraps a StorageEngine and compresses file contents.
+type Engine struct {
+	inner        sbox.StorageEngine
+	codec        Codec
+	codecs       map[string]Codec
+	excludeExts  map[string]bool
+	excludeTypes []string
+}
+
+// New returns an Engine that stores compressed data in inner. The default
+// codec is zstd; gzip and zstd files are always readable.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{
+		inner:        inner,
+		codec:        Zstd(zstd.SpeedDefault),
+		codecs:       make(map[string]Codec),
+		excludeTypes: DefaultExcludeContentTypes,
+	}
+	for _, c := range []Codec{Gzip(-1), e.codec} {
+		e.codecs[c.Name()] = c
+	}
+	WithExcludeExtensions(DefaultExcludeExtensions...)(e)
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// trailer describes a compressed file.
+type trailer struct {
+	codec Codec
+	size  int64 // original (logical) size
+}
+
+func encodeTrailer(codec string, size int64) []byte {
+	b := make([]byte, trailerSize)
+	copy(b, trailerMagic)
+	copy(b[len(trailerMagic):], codec)
+	binary.BigEndian.PutUint64(b[len(trailerMagic)+codecNameLen:], uint64(size))
+	return b
+}
+
+// decodeTrailer parses b, returning nil if it is not a trailer.
+func (e *Engine) decodeTrailer(b []byte) (*trailer, error) {
+	if len(b) != trailerSize || string(b[:len(trailerMagic)]) != trailerMagic {
+		return nil, nil
+	}
+	name := string(bytes.TrimRight(b[len(trailerMagic):len(trailerMagic)+codecNameLen], "\x00"))
+	codec, ok := e.codecs[name]
+	if !ok {
+		return nil, errors.New("sbox/compress: unknown codec " + name)
+	}
+	return &trailer{codec: codec, size: int64(binary.BigEndian.Uint64(b[len(trailerMagic)+codecNameLen:]))}, nil
+}
+
+// readTrailer reads the trailer of the inner file r of the given stored
+// size. It returns nil for files stored uncompressed.
+func (e *Engine) readTrailer(r io.ReadSeeker, stored int64) (*trailer, error) {
+	if stored < int64(trailerSize) {
+		return nil, nil
+	}
+	if _, err := r.Seek(stored-int64(trailerSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, trailerSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return e.decodeTrailer(b)
+}
+
+// logical returns info with Size replaced by the original size if the file
+// is compressed.
+func (e *Engine) logical(ctx context.Context, info *sbox.EntryInfo, p string) (*sbox.EntryInfo, error) {
+	if info.IsDir {
+		return info, nil
+	}
+	r, err := e.inner.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	t, err := e.readTrailer(r, info.Size)
+	if err != nil || t == nil {
+		return info, err
+	}
+	c := *info
+	c.Size = t.size
+	return &c, nil
+}
+
+// excluded reports whether p is stored uncompressed because of its
+// extension.
+func (e *Engine) excluded(p string) bool {
+	return e.excludeExts[strings.ToLower(path.Ext(p))]
+}
+
+// excludedType reports whether data sniffs as an excluded content type.
+func (e *Engine) excludedType(data []byte) bool {
+	ct := http.DetectContentType(data)
+	for _, t := range e.excludeTypes {
+		if strings.HasPrefix(ct, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	info, err := e.inner.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return e.logical(ctx, info, path)
+}
+
+// Open returns a reader over the original content. Backward seeks on a
+// compressed file restart decompression from the beginning.
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	info, err := e.inner.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, sbox.ErrIsDir
+	}
+	r, err := e.inner.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := e.readTrailer(r, info.Size)
+	if err == nil {
+		_, err = r.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if t == nil {
+		return r, nil
+	}
+	return newReader(t, info.Size-int64(trailerSize), r), nil
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	w, err := e.inner.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return e.newWriter(w, nil, path), nil
+}
+
+// OpenFile buffers the file's original content in memory and compresses it
+// again on Close, since compressed data cannot be modified in place.
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	_, err := e.inner.Stat(ctx, path)
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, sbox.ErrExist
+	case errors.Is(err, sbox.ErrNotFound) && flag&os.O_CREATE == 0:
+		return nil, err
+	case err != nil && !errors.Is(err, sbox.ErrNotFound):
+		return nil, err
+	}
+
+	f := &bufferFile{engine: e, ctx: ctx, path: path, append: flag&os.O_APPEND != 0}
+	if err == nil && flag&os.O_TRUNC == 0 {
+		r, err := e.Open(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		f.buf, err = io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.append {
+		f.offset = int64(len(f.buf))
+	}
+	return f, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	return e.inner.Remove(ctx, path)
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	return e.inner.Rename(ctx, oldPath, newPath)
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	return e.inner.MkdirAll(ctx, path)
+}
+
+// ReadDir lists dirPath with logical file sizes. Reporting sizes requires
+// reading the trailer of every file in the directory.
+func (e *Engine) ReadDir(ctx context.Context, dirPath string) ([]*sbox.EntryInfo, error) {
+	entries, err := e.inner.ReadDir(ctx, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		p := entry.Path
+		if p == "" {
+			p = path.Join(dirPath, entry.Name)
+		}
+		if entries[i], err = e.logical(ctx, entry, p); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// === Extension: Copier ===
+
+// Copy copies the stored bytes using the inner engine's Copier, so the data
+// is not decompressed and recompressed.
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return c.Copy(ctx, src, dst)
+}
+
+// === Extension: AtomicWriter ===
+
+// CreateAtomic compresses into an atomic writer of the inner engine. It
+// returns ErrNotSupported if the inner engine is not an AtomicWriter.
+func (e *Engine) CreateAtomic(ctx context.Context, path string) (sbox.AtomicWriteCloser, error) {
+	aw, ok := e.inner.(sbox.AtomicWriter)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	w, err := aw.CreateAtomic(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicWriter{writer: e.newWriter(w, w.Abort, path)}, nil
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+// GetRange decompresses from the start of the file and discards data up to
+// offset.
+func (e *Engine) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	r, err := e.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return r, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(r, length), r}, nil
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier and AtomicWriter only if the inner engine supports
+// them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy, sbox.CapAtomicWrite:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.AtomicWriter       = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.RangeReader        = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
@@ -0,0 +1,169 @@
+package compress_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/compress"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	w, err := engine.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func storedSize(t *testing.T, engine sbox.StorageEngine, path string) int64 {
+	t.Helper()
+	info, err := engine.Stat(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Stat %s: %v", path, err)
+	}
+	return info.Size
+}
+
+func TestCompress_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, compress.New(memory.New()))
+}
+
+func TestCompress_GzipSuite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, compress.New(memory.New(), compress.WithCodec(compress.Gzip(gzip.BestSpeed))))
+}
+
+func TestCompress_LogicalSize(t *testing.T) {
+	inner := memory.New()
+	engine := compress.New(inner)
+	ctx := context.Background()
+	content := strings.Repeat("compressible ", 1000)
+
+	writeFile(t, engine, "dir/big.txt", content)
+
+	if got := storedSize(t, engine, "dir/big.txt"); got != int64(len(content)) {
+		t.Errorf("logical size = %d, want %d", got, len(content))
+	}
+	if got := storedSize(t, inner, "dir/big.txt"); got >= int64(len(content))/10 {
+		t.Errorf("stored size = %d, expected strong compression of %d bytes", got, len(content))
+	}
+
+	entries, err := engine.ReadDir(ctx, "dir")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Size != int64(len(content)) {
+		t.Errorf("ReadDir = %+v, want one entry of size %d", entries, len(content))
+	}
+}
+
+func TestCompress_Exclusions(t *testing.T) {
+	inner := memory.New()
+	engine := compress.New(inner)
+	text := strings.Repeat("a", 4096)
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 4096)...)
+
+	writeFile(t, engine, "photo.JPG", text)
+	writeFile(t, engine, "sniffed.bin", string(png))
+	writeFile(t, engine, "plain.bin", text)
+
+	if got := storedSize(t, inner, "photo.JPG"); got != int64(len(text)) {
+		t.Errorf("excluded extension stored size = %d, want %d", got, len(text))
+	}
+	if got := storedSize(t, inner, "sniffed.bin"); got != int64(len(png)) {
+		t.Errorf("excluded content type stored size = %d, want %d", got, len(png))
+	}
+	if got := storedSize(t, inner, "plain.bin"); got >= int64(len(text)) {
+		t.Errorf("plain.bin stored size = %d, want compressed", got)
+	}
+
+	// Files written to the inner engine directly remain readable.
+	writeFile(t, inner, "raw.txt", "written underneath")
+	if got := readFile(t, engine, "raw.txt"); got != "written underneath" {
+		t.Errorf("raw.txt = %q", got)
+	}
+}
+
+func TestCompress_MixedCodecs(t *testing.T) {
+	inner := memory.New()
+	writeFile(t, compress.New(inner, compress.WithCodec(compress.Gzip(gzip.BestCompression))), "a.txt", "written with gzip")
+
+	engine := compress.New(inner)
+	writeFile(t, engine, "b.txt", "written with zstd")
+	if got := readFile(t, engine, "a.txt"); got != "written with gzip" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got := readFile(t, engine, "b.txt"); got != "written with zstd" {
+		t.Errorf("b.txt = %q", got)
+	}
+}
+
+func TestCompress_SeekAndRange(t *testing.T) {
+	engine := compress.New(memory.New())
+	ctx := context.Background()
+	writeFile(t, engine, "digits.txt", "0123456789")
+
+	r, err := engine.Open(ctx, "digits.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+
+	buf := make([]byte, 3)
+	for _, tc := range []struct {
+		offset int64
+		whence int
+		want   string
+	}{
+		{7, io.SeekStart, "789"},
+		{2, io.SeekStart, "234"},
+		{-4, io.SeekEnd, "678"},
+		{-6, io.SeekCurrent, "345"},
+	} {
+		if _, err := r.Seek(tc.offset, tc.whence); err != nil {
+			t.Fatalf("Seek(%d, %d): %v", tc.offset, tc.whence, err)
+		}
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("Read after Seek(%d, %d): %v", tc.offset, tc.whence, err)
+		}
+		if string(buf) != tc.want {
+			t.Errorf("Seek(%d, %d) read %q, want %q", tc.offset, tc.whence, buf, tc.want)
+		}
+	}
+
+	rc, err := engine.GetRange(ctx, "digits.txt", 4, 3)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "456" {
+		t.Errorf("GetRange = %q, want %q", data, "456")
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,45 @@
+package compress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithCodec sets the codec used for new files. Files written with any other
+// known codec remain readable. It panics if the codec name does not fit in
+// the trailer.
+func WithCodec(c Codec) Option {
+	if len(c.Name()) == 0 || len(c.Name()) > codecNameLen {
+		panic(fmt.Sprintf("sbox/compress: invalid codec name %q", c.Name()))
+	}
+	return func(e *Engine) {
+		e.codec = c
+		e.codecs[c.Name()] = c
+	}
+}
+
+// WithExcludeExtensions replaces the list of file extensions that are
+// stored uncompressed. Extensions are matched case-insensitively and
+// include the leading dot, e.g. ".jpg". Calling it with no arguments
+// disables extension-based exclusion.
+func WithExcludeExtensions(exts ...string) Option {
+	return func(e *Engine) {
+		e.excludeExts = make(map[string]bool, len(exts))
+		for _, ext := range exts {
+			e.excludeExts[strings.ToLower(ext)] = true
+		}
+	}
+}
+
+// WithExcludeContentTypes replaces the list of content types that are stored
+// uncompressed. The type is sniffed from the first 512 bytes written with
+// http.DetectContentType and matched by prefix, so "image/" excludes every
+// image type. Calling it with no arguments disables sniffing.
+func WithExcludeContentTypes(types ...string) Option {
+	return func(e *Engine) {
+		e.excludeTypes = append([]string(nil), types...)
+	}
+}
@@ -0,0 +1,100 @@
+package compress
+
+import (
+	"errors"
+	"io"
+
+	"github.com/nuln/sbox"
+)
+
+// reader decompresses a stored file and supports seeking in the original
+// content. Forward seeks discard decompressed data; backward seeks reopen
+// the decompressor.
+type reader struct {
+	trailer *trailer
+	payload int64 // compressed bytes preceding the trailer
+
+	src sbox.ReadSeekCloser // inner file, positioned within the payload
+	dec io.ReadCloser       // decompressor over src, nil until first read
+	cur int64               // logical offset of dec
+	pos int64               // logical offset requested by the caller
+
+	closed bool
+}
+
+func newReader(t *trailer, payload int64, src sbox.ReadSeekCloser) *reader {
+	return &reader{trailer: t, payload: payload, src: src}
+}
+
+// reset starts decompressing from the beginning of the file.
+func (r *reader) reset() error {
+	if r.dec != nil {
+		_ = r.dec.Close()
+		r.dec = nil
+	}
+	if _, err := r.src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec, err := r.trailer.codec.NewReader(io.LimitReader(r.src, r.payload))
+	if err != nil {
+		return err
+	}
+	r.dec, r.cur = dec, 0
+	return nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, sbox.ErrClosed
+	}
+	if r.pos >= r.trailer.size {
+		return 0, io.EOF
+	}
+	if r.dec == nil || r.cur > r.pos {
+		if err := r.reset(); err != nil {
+			return 0, err
+		}
+	}
+	if r.cur < r.pos {
+		n, err := io.CopyN(io.Discard, r.dec, r.pos-r.cur)
+		r.cur += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.dec.Read(p)
+	r.cur += int64(n)
+	r.pos = r.cur
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.trailer.size + offset
+	default:
+		return 0, errors.New("sbox/compress: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("sbox/compress: negative seek offset")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *reader) Close() error {
+	if r.closed {
+		return sbox.ErrClosed
+	}
+	r.closed = true
+	if r.dec != nil {
+		_ = r.dec.Close()
+		r.dec = nil
+	}
+	return r.src.Close()
+}
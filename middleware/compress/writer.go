@@ -0,0 +1,228 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/nuln/sbox"
+)
+
+// writer compresses into an inner writer. When content-type exclusions are
+// configured, the first sniffLen bytes are held back until the type is
+// known.
+type writer struct {
+	engine *Engine
+	w      io.WriteCloser
+	abort  func() error // aborts w, nil if w is not atomic
+
+	sniff    []byte
+	decided  bool
+	compress bool
+	cw       io.WriteCloser // codec writer over w when compressing
+	size     int64          // original bytes written
+	closed   bool
+}
+
+func (e *Engine) newWriter(w io.WriteCloser, abort func() error, p string) *writer {
+	cw := &writer{engine: e, w: w, abort: abort, compress: !e.excluded(p)}
+	cw.decided = !cw.compress || len(e.excludeTypes) == 0
+	return cw
+}
+
+// start opens the codec writer once the compression decision is final.
+func (w *writer) start() error {
+	w.decided = true
+	if !w.compress {
+		return nil
+	}
+	cw, err := w.engine.codec.NewWriter(w.w)
+	if err != nil {
+		return err
+	}
+	w.cw = cw
+	return nil
+}
+
+// decide sniffs the buffered prefix and flushes it.
+func (w *writer) decide() error {
+	w.compress = !w.engine.excludedType(w.sniff)
+	if err := w.start(); err != nil {
+		return err
+	}
+	data := w.sniff
+	w.sniff = nil
+	_, err := w.write(data)
+	return err
+}
+
+func (w *writer) write(p []byte) (int, error) {
+	if w.compress && w.cw == nil {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if w.compress {
+		n, err = w.cw.Write(p)
+	} else {
+		n, err = w.w.Write(p)
+	}
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	if w.decided {
+		return w.write(p)
+	}
+	w.sniff = append(w.sniff, p...)
+	if len(w.sniff) >= sniffLen {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes the compressed stream and appends the trailer.
+func (w *writer) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+
+	err := w.finish()
+	if err != nil {
+		if w.abort != nil {
+			_ = w.abort()
+		} else {
+			_ = w.w.Close()
+		}
+		return err
+	}
+	return w.w.Close()
+}
+
+func (w *writer) finish() error {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if !w.compress {
+		return nil
+	}
+	if w.cw == nil {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if err := w.cw.Close(); err != nil {
+		return err
+	}
+	_, err := w.w.Write(encodeTrailer(w.engine.codec.Name(), w.size))
+	return err
+}
+
+// atomicWriter is a writer over an inner AtomicWriteCloser.
+type atomicWriter struct {
+	*writer
+}
+
+func (w *atomicWriter) Abort() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	if w.cw != nil {
+		_ = w.cw.Close()
+	}
+	return w.abort()
+}
+
+// bufferFile holds the original content of a file opened with OpenFile and
+// stores it through a compressing writer on Close.
+type bufferFile struct {
+	engine *Engine
+	ctx    context.Context
+	path   string
+	buf    []byte
+	offset int64
+	append bool
+	closed bool
+}
+
+// Write writes p at the current offset, overwriting existing bytes and
+// growing the buffer as needed. In append mode every write goes to the end.
+func (f *bufferFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, sbox.ErrClosed
+	}
+	if f.append {
+		f.offset = int64(len(f.buf))
+	}
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.buf)) {
+		if end > int64(cap(f.buf)) {
+			grown := make([]byte, end, end+end/2)
+			copy(grown, f.buf)
+			f.buf = grown
+		} else {
+			f.buf = f.buf[:end]
+		}
+	}
+	copy(f.buf[f.offset:end], p)
+	f.offset = end
+	return len(p), nil
+}
+
+func (f *bufferFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = f.offset + offset
+	case io.SeekEnd:
+		pos = int64(len(f.buf)) + offset
+	default:
+		return 0, errors.New("sbox/compress: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("sbox/compress: negative seek offset")
+	}
+	f.offset = pos
+	return pos, nil
+}
+
+func (f *bufferFile) Close() error {
+	if f.closed {
+		return sbox.ErrClosed
+	}
+	f.closed = true
+
+	var w io.WriteCloser
+	var err error
+	if _, ok := f.engine.inner.(sbox.AtomicWriter); ok {
+		w, err = f.engine.CreateAtomic(f.ctx, f.path)
+	} else {
+		w, err = f.engine.Create(f.ctx, f.path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(f.buf); err != nil {
+		if aw, ok := w.(sbox.AtomicWriteCloser); ok {
+			_ = aw.Abort()
+		} else {
+			_ = w.Close()
+		}
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,386 @@
+// Package metrics provides a storage middleware that instruments every
+// sbox.StorageEngine call with Prometheus metrics.
+//
+//	engine := metrics.New(inner, "rclone")
+//	prometheus.MustRegister(engine)
+//
+// Engines created with the same Collector share one set of metrics, told
+// apart by the driver label:
+//
+//	c := metrics.NewCollector("sbox")
+//	prometheus.MustRegister(c)
+//	hot := metrics.New(local, "local", metrics.WithCollector(c))
+//	cold := metrics.New(remote, "rclone", metrics.WithCollector(c))
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nuln/sbox"
+)
+
+// Collector holds the metrics of one or more instrumented engines.
+type Collector struct {
+	duration     *prometheus.HistogramVec
+	errors       *prometheus.CounterVec
+	bytesRead    *prometheus.CounterVec
+	bytesWritten *prometheus.CounterVec
+}
+
+// NewCollector returns a Collector whose metric names are prefixed with
+// namespace, e.g. "sbox_operation_duration_seconds".
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of storage engine operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"driver", "op"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operation_errors_total",
+			Help:      "Storage engine operations that returned an error.",
+		}, []string{"driver", "op"}),
+		bytesRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "read_bytes_total",
+			Help:      "Bytes read from files.",
+		}, []string{"driver"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "written_bytes_total",
+			Help:      "Bytes written to files.",
+		}, []string{"driver"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.duration.Describe(ch)
+	c.errors.Describe(ch)
+	c.bytesRead.Describe(ch)
+	c.bytesWritten.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.duration.Collect(ch)
+	c.errors.Collect(ch)
+	c.bytesRead.Collect(ch)
+	c.bytesWritten.Collect(ch)
+}
+
+// Engine instruments calls to an inner engine.
+type Engine struct {
+	inner     sbox.StorageEngine
+	driver    string
+	collector *Collector
+}
+
+// New returns an Engine recording metrics for inner under the given driver
+// label. Unless WithCollector is given, the Engine has its own Collector
+// with the "sbox" namespace, and the Engine itself can be registered.
+func New(inner sbox.StorageEngine, driver string, opts ...Option) *Engine {
+	e := &Engine{inner: inner, driver: driver}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.collector == nil {
+		e.collector = NewCollector("sbox")
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Collector returns the collector the Engine records into.
+func (e *Engine) Collector() *Collector {
+	return e.collector
+}
+
+// Describe implements prometheus.Collector.
+func (e *Engine) Describe(ch chan<- *prometheus.Desc) {
+	e.collector.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (e *Engine) Collect(ch chan<- prometheus.Metric) {
+	e.collector.Collect(ch)
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// observe records the outcome of op started at start.
+func (e *Engine) observe(op string, start time.Time, err error) {
+	e.collector.duration.WithLabelValues(e.driver, op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		e.collector.errors.WithLabelValues(e.driver, op).Inc()
+	}
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	start := time.Now()
+	info, err := e.inner.Stat(ctx, path)
+	e.observe("stat", start, err)
+	return info, err
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	start := time.Now()
+	r, err := e.inner.Open(ctx, path)
+	e.observe("open", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{ReadSeekCloser: r, engine: e}, nil
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	start := time.Now()
+	w, err := e.inner.Create(ctx, path)
+	e.observe("create", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, c: w, engine: e}, nil
+}
+
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	start := time.Now()
+	w, err := e.inner.OpenFile(ctx, path, flag, perm)
+	e.observe("openfile", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &seekWriter{writer: writer{w: w, c: w, engine: e}, Seeker: w}, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	start := time.Now()
+	err := e.inner.Remove(ctx, path)
+	e.observe("remove", start, err)
+	return err
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	start := time.Now()
+	err := e.inner.Rename(ctx, oldPath, newPath)
+	e.observe("rename", start, err)
+	return err
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	start := time.Now()
+	err := e.inner.MkdirAll(ctx, path)
+	e.observe("mkdirall", start, err)
+	return err
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	start := time.Now()
+	entries, err := e.inner.ReadDir(ctx, path)
+	e.observe("readdir", start, err)
+	return entries, err
+}
+
+// === Extension: Copier ===
+
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	start := time.Now()
+	err := c.Copy(ctx, src, dst)
+	e.observe("copy", start, err)
+	return err
+}
+
+// === Extension: Hasher ===
+
+func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (string, error) {
+	h, ok := e.inner.(sbox.Hasher)
+	if !ok {
+		return "", sbox.ErrNotSupported
+	}
+	start := time.Now()
+	sum, err := h.Hash(ctx, path, algorithm)
+	e.observe("hash", start, err)
+	return sum, err
+}
+
+// === Extension: Walker ===
+
+// Walk records a native walk of the inner engine as one "walk" operation.
+// Engines without one are walked through the Engine by sbox.Walk, which
+// records every Stat and ReadDir.
+func (e *Engine) Walk(ctx context.Context, root string, fn sbox.WalkFunc) error {
+	w, ok := e.inner.(sbox.Walker)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	start := time.Now()
+	err := w.Walk(ctx, root, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		err = nil
+	}
+	e.observe("walk", start, err)
+	return err
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	start := time.Now()
+	err := e.put(ctx, path, reader)
+	e.observe("put", start, err)
+	return err
+}
+
+func (e *Engine) put(ctx context.Context, path string, reader io.Reader) error {
+	counted := &countingReader{r: reader}
+	defer func() { e.collector.bytesWritten.WithLabelValues(e.driver).Add(float64(counted.n)) }()
+	if sw, ok := e.inner.(sbox.StreamWriter); ok {
+		return sw.Put(ctx, path, counted)
+	}
+	w, err := e.inner.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, counted); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	rr, ok := e.inner.(sbox.RangeReader)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	start := time.Now()
+	rc, err := rr.GetRange(ctx, path, offset, length)
+	e.observe("getrange", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: &reader{ReadSeekCloser: nopSeeker{rc}, engine: e}, Closer: rc}, nil
+}
+
+// reader counts bytes read and read errors.
+type reader struct {
+	sbox.ReadSeekCloser
+	engine *Engine
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeekCloser.Read(p)
+	r.engine.collector.bytesRead.WithLabelValues(r.engine.driver).Add(float64(n))
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.engine.collector.errors.WithLabelValues(r.engine.driver, "read").Inc()
+	}
+	return n, err
+}
+
+// writer counts bytes written and write errors.
+type writer struct {
+	w      io.Writer
+	c      io.Closer
+	engine *Engine
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.engine.collector.bytesWritten.WithLabelValues(w.engine.driver).Add(float64(n))
+	if err != nil {
+		w.engine.collector.errors.WithLabelValues(w.engine.driver, "write").Inc()
+	}
+	return n, err
+}
+
+func (w *writer) Close() error {
+	start := time.Now()
+	err := w.c.Close()
+	w.engine.observe("close", start, err)
+	return err
+}
+
+type seekWriter struct {
+	writer
+	io.Seeker
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// nopSeeker adapts a ReadCloser for reader; Seek is never called on it.
+type nopSeeker struct {
+	io.ReadCloser
+}
+
+func (nopSeeker) Seek(int64, int) (int64, error) {
+	return 0, sbox.ErrNotSupported
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier, Hasher, RangeReader and Walker only if the
+// inner engine supports them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy, sbox.CapHash, sbox.CapRangeRead, sbox.CapWalk:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.Hasher             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.RangeReader        = (*Engine)(nil)
+	_ sbox.Walker             = (*Engine)(nil)
+	_ prometheus.Collector    = (*Engine)(nil)
+	_ prometheus.Collector    = (*Collector)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
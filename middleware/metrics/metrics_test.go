@@ -0,0 +1,120 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/metrics"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func TestMetrics_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, metrics.New(memory.New(), "memory"))
+}
+
+func TestMetrics_Recorded(t *testing.T) {
+	engine := metrics.New(memory.New(), "memory")
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(engine); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := engine.Put(ctx, "f.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, err := engine.Open(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_, _ = io.ReadAll(r)
+	_ = r.Close()
+	if _, err := engine.Stat(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Fatalf("Stat missing = %v", err)
+	}
+
+	expected := `
+# HELP sbox_operation_errors_total Storage engine operations that returned an error.
+# TYPE sbox_operation_errors_total counter
+sbox_operation_errors_total{driver="memory",op="stat"} 1
+# HELP sbox_read_bytes_total Bytes read from files.
+# TYPE sbox_read_bytes_total counter
+sbox_read_bytes_total{driver="memory"} 5
+# HELP sbox_written_bytes_total Bytes written to files.
+# TYPE sbox_written_bytes_total counter
+sbox_written_bytes_total{driver="memory"} 5
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"sbox_operation_errors_total", "sbox_read_bytes_total", "sbox_written_bytes_total"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(engine, "sbox_operation_duration_seconds"); n != 3 {
+		t.Errorf("duration series = %d, want 3 (put, open, stat)", n)
+	}
+}
+
+func TestMetrics_SharedCollector(t *testing.T) {
+	c := metrics.NewCollector("storage")
+	hot := metrics.New(memory.New(), "hot", metrics.WithCollector(c))
+	cold := metrics.New(memory.New(), "cold", metrics.WithCollector(c))
+	ctx := context.Background()
+
+	_ = hot.MkdirAll(ctx, "a")
+	_ = cold.MkdirAll(ctx, "a")
+	_ = cold.MkdirAll(ctx, "b")
+
+	if n := testutil.CollectAndCount(c, "storage_operation_duration_seconds"); n != 2 {
+		t.Errorf("duration series = %d, want one per driver", n)
+	}
+}
+
+// nativeWalker walks with its own Walk method.
+type nativeWalker struct {
+	sbox.StorageEngine
+}
+
+func (w nativeWalker) Walk(ctx context.Context, root string, fn sbox.WalkFunc) error {
+	return sbox.Walk(ctx, w.StorageEngine, root, fn)
+}
+
+func TestMetrics_Walk(t *testing.T) {
+	ctx := context.Background()
+	walk := func(engine *metrics.Engine) int {
+		n := 0
+		err := sbox.Walk(ctx, engine, "", func(string, *sbox.EntryInfo, error) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk: %v", err)
+		}
+		return n
+	}
+
+	native := metrics.New(nativeWalker{memory.New()}, "native")
+	_ = native.MkdirAll(ctx, "a/b")
+	if n := walk(native); n != 3 {
+		t.Errorf("Walk visited %d entries, want 3", n)
+	}
+	if n := testutil.CollectAndCount(native, "sbox_operation_duration_seconds"); n != 2 {
+		t.Errorf("duration series = %d, want 2 (mkdirall, walk)", n)
+	}
+
+	generic := metrics.New(memory.New(), "generic")
+	if sbox.Supports(generic, sbox.CapWalk) {
+		t.Error("Walker reported for an inner engine without one")
+	}
+	_ = generic.MkdirAll(ctx, "a/b")
+	if n := walk(generic); n != 3 {
+		t.Errorf("Walk visited %d entries, want 3", n)
+	}
+}
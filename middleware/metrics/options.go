@@ -0,0 +1,12 @@
+package metrics
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithCollector records into c instead of a Collector private to the
+// Engine, so several engines can be registered once.
+func WithCollector(c *Collector) Option {
+	return func(e *Engine) {
+		e.collector = c
+	}
+}
@@ -0,0 +1,384 @@
+// Package mirror provides a storage middleware that replicates every write
+// on a primary engine to one or more replicas.
+//
+//	engine := mirror.New(primary, []sbox.StorageEngine{replica},
+//	    mirror.WithAsync(1024))
+//	defer engine.Close() // drains pending replication
+//
+// In the default synchronous mode a call returns only after every replica
+// has been updated, and replica failures are reported to the caller even
+// though the primary succeeded. In asynchronous mode replication runs in a
+// background worker fed by a bounded queue, and failures are logged.
+//
+// Reads are served by the primary. If the primary fails with anything other
+// than ErrNotFound, replicas are tried in order. Repair reconciles replicas
+// that have diverged.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nuln/sbox"
+)
+
+// task is a replication step applied to a single replica.
+type task struct {
+	name string // for logging
+	path string
+	fn   func(ctx context.Context, replica sbox.StorageEngine) error
+}
+
+// Engine mirrors writes on a primary engine to replicas.
+type Engine struct {
+	primary  sbox.StorageEngine
+	replicas []sbox.StorageEngine
+	logger   *slog.Logger
+
+	async   bool
+	queue   chan task
+	wg      sync.WaitGroup
+	closeMu sync.RWMutex // excludes enqueues while the queue is closed
+	closed  atomic.Bool
+}
+
+// New returns an Engine writing to primary and replicas.
+func New(primary sbox.StorageEngine, replicas []sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{
+		primary:  primary,
+		replicas: replicas,
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.async {
+		e.wg.Add(1)
+		go e.worker()
+	}
+	return e
+}
+
+// Primary returns the primary engine.
+func (e *Engine) Primary() sbox.StorageEngine {
+	return e.primary
+}
+
+// Replicas returns the replica engines.
+func (e *Engine) Replicas() []sbox.StorageEngine {
+	return e.replicas
+}
+
+// Close waits for queued replication to finish and closes all engines that
+// implement io.Closer.
+func (e *Engine) Close() error {
+	if !e.closed.CompareAndSwap(false, true) {
+		return sbox.ErrClosed
+	}
+	if e.async {
+		e.closeMu.Lock()
+		close(e.queue)
+		e.closeMu.Unlock()
+		e.wg.Wait()
+	}
+	errs := []error{sbox.Close(e.primary)}
+	for _, r := range e.replicas {
+		errs = append(errs, sbox.Close(r))
+	}
+	return errors.Join(errs...)
+}
+
+// worker applies queued tasks to every replica.
+func (e *Engine) worker() {
+	defer e.wg.Done()
+	for t := range e.queue {
+		for i, r := range e.replicas {
+			if err := t.fn(context.Background(), r); err != nil {
+				e.logger.Error("sbox/mirror: replication failed",
+					"op", t.name, "path", t.path, "replica", i, "error", err)
+			}
+		}
+	}
+}
+
+// replicate applies t to every replica, synchronously or via the queue.
+func (e *Engine) replicate(ctx context.Context, t task) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	if !e.async {
+		var errs []error
+		for i, r := range e.replicas {
+			if err := t.fn(ctx, r); err != nil {
+				errs = append(errs, fmt.Errorf("sbox/mirror: replica %d: %s %s: %w", i, t.name, t.path, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	e.closeMu.RLock()
+	defer e.closeMu.RUnlock()
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	select {
+	case e.queue <- t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copyTask copies path from the primary to a replica.
+func (e *Engine) copyTask(path string) task {
+	return task{name: "copy", path: path, fn: func(ctx context.Context, replica sbox.StorageEngine) error {
+		return copyFile(ctx, e.primary, replica, path)
+	}}
+}
+
+// failover runs fn against the primary and, if it fails with an error
+// other than ErrNotFound, against each replica in turn.
+func failover[T any](e *Engine, fn func(sbox.StorageEngine) (T, error)) (T, error) {
+	v, err := fn(e.primary)
+	if err == nil || errors.Is(err, sbox.ErrNotFound) {
+		return v, err
+	}
+	for _, r := range e.replicas {
+		if rv, rerr := fn(r); rerr == nil {
+			return rv, nil
+		}
+	}
+	return v, err
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	return failover(e, func(s sbox.StorageEngine) (*sbox.EntryInfo, error) { return s.Stat(ctx, path) })
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	return failover(e, func(s sbox.StorageEngine) (sbox.ReadSeekCloser, error) { return s.Open(ctx, path) })
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return failover(e, func(s sbox.StorageEngine) ([]*sbox.EntryInfo, error) { return s.ReadDir(ctx, path) })
+}
+
+// Create writes to the primary. In synchronous mode the data is written to
+// every replica at the same time; in asynchronous mode the file is copied
+// from the primary once it has been closed.
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	w, err := e.primary.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	mw := &writer{engine: e, ctx: ctx, path: path, primary: w}
+	if !e.async {
+		for i, r := range e.replicas {
+			rw, err := r.Create(ctx, path)
+			if err != nil {
+				mw.errs = append(mw.errs, fmt.Errorf("sbox/mirror: replica %d: create %s: %w", i, path, err))
+				continue
+			}
+			mw.replicas = append(mw.replicas, rw)
+		}
+	}
+	return mw, nil
+}
+
+// OpenFile writes to the primary and replicates the resulting file when it
+// is closed.
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	w, err := e.primary.OpenFile(ctx, path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatingFile{WriteSeekCloser: w, engine: e, ctx: ctx, path: path}, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	if err := e.primary.Remove(ctx, path); err != nil {
+		return err
+	}
+	return e.replicate(ctx, task{name: "remove", path: path, fn: func(ctx context.Context, r sbox.StorageEngine) error {
+		if err := r.Remove(ctx, path); err != nil && !errors.Is(err, sbox.ErrNotFound) {
+			return err
+		}
+		return nil
+	}})
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	if err := e.primary.Rename(ctx, oldPath, newPath); err != nil {
+		return err
+	}
+	return e.replicate(ctx, task{name: "rename", path: oldPath, fn: func(ctx context.Context, r sbox.StorageEngine) error {
+		err := r.Rename(ctx, oldPath, newPath)
+		if errors.Is(err, sbox.ErrNotFound) {
+			// The replica missed the source; copy the result instead.
+			return copyTree(ctx, e.primary, r, newPath)
+		}
+		return err
+	}})
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	if err := e.primary.MkdirAll(ctx, path); err != nil {
+		return err
+	}
+	return e.replicate(ctx, task{name: "mkdir", path: path, fn: func(ctx context.Context, r sbox.StorageEngine) error {
+		return r.MkdirAll(ctx, path)
+	}})
+}
+
+// === Extension: Copier ===
+
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.primary.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	if err := c.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	return e.replicate(ctx, task{name: "copy", path: dst, fn: func(ctx context.Context, r sbox.StorageEngine) error {
+		if rc, ok := r.(sbox.Copier); ok {
+			if err := rc.Copy(ctx, src, dst); err == nil {
+				return nil
+			}
+		}
+		return copyTree(ctx, e.primary, r, dst)
+	}})
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writer writes to the primary and, in synchronous mode, to every replica.
+type writer struct {
+	engine   *Engine
+	ctx      context.Context
+	path     string
+	primary  io.WriteCloser
+	replicas []io.WriteCloser
+	errs     []error // replica failures
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.primary.Write(p)
+	if err != nil {
+		return n, err
+	}
+	for i, r := range w.replicas {
+		if r == nil {
+			continue
+		}
+		if _, err := r.Write(p); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("sbox/mirror: replica write %s: %w", w.path, err))
+			_ = r.Close()
+			w.replicas[i] = nil
+		}
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	err := w.primary.Close()
+	for _, r := range w.replicas {
+		if r == nil {
+			continue
+		}
+		if cerr := r.Close(); cerr != nil {
+			w.errs = append(w.errs, fmt.Errorf("sbox/mirror: replica close %s: %w", w.path, cerr))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if w.engine.async {
+		return w.engine.replicate(w.ctx, w.engine.copyTask(w.path))
+	}
+	return errors.Join(w.errs...)
+}
+
+// replicatingFile replicates the file once the primary handle is closed.
+type replicatingFile struct {
+	sbox.WriteSeekCloser
+	engine *Engine
+	ctx    context.Context
+	path   string
+}
+
+func (f *replicatingFile) Close() error {
+	if err := f.WriteSeekCloser.Close(); err != nil {
+		return err
+	}
+	return f.engine.replicate(f.ctx, f.engine.copyTask(f.path))
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks the primary and every replica with sbox.Ping, since writes
+// reach all of them.
+func (e *Engine) Ping(ctx context.Context) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	errs := []error{sbox.Ping(ctx, e.primary)}
+	for i, r := range e.replicas {
+		if err := sbox.Ping(ctx, r); err != nil {
+			errs = append(errs, fmt.Errorf("replica %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier only if the primary engine supports it.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy:
+		return sbox.Supports(e.primary, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+	_ sbox.HealthChecker      = (*Engine)(nil)
+)
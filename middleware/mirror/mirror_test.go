@@ -0,0 +1,155 @@
+package mirror_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/mirror"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	w, err := engine.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// downEngine fails every call, like an unreachable backend.
+type downEngine struct {
+	sbox.StorageEngine
+}
+
+var errDown = errors.New("backend unreachable")
+
+func (downEngine) Stat(context.Context, string) (*sbox.EntryInfo, error)     { return nil, errDown }
+func (downEngine) Open(context.Context, string) (sbox.ReadSeekCloser, error) { return nil, errDown }
+
+func TestMirror_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, mirror.New(memory.New(), []sbox.StorageEngine{memory.New(), memory.New()}))
+}
+
+func TestMirror_AsyncSuite(t *testing.T) {
+	engine := mirror.New(memory.New(), []sbox.StorageEngine{memory.New()}, mirror.WithAsync(16))
+	defer func() { _ = engine.Close() }()
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestMirror_SyncReplication(t *testing.T) {
+	primary, replica := memory.New(), memory.New()
+	engine := mirror.New(primary, []sbox.StorageEngine{replica})
+	ctx := context.Background()
+
+	writeFile(t, engine, "docs/a.txt", "replicated")
+	if got := readFile(t, replica, "docs/a.txt"); got != "replicated" {
+		t.Errorf("replica a.txt = %q", got)
+	}
+	if err := engine.Rename(ctx, "docs/a.txt", "docs/b.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got := readFile(t, replica, "docs/b.txt"); got != "replicated" {
+		t.Errorf("replica b.txt = %q", got)
+	}
+	if err := engine.Remove(ctx, "docs"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := replica.Stat(ctx, "docs"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("replica docs after Remove: %v", err)
+	}
+}
+
+func TestMirror_AsyncDrainOnClose(t *testing.T) {
+	primary, replica := memory.New(), memory.New()
+	engine := mirror.New(primary, []sbox.StorageEngine{replica}, mirror.WithAsync(4))
+
+	for _, name := range []string{"1", "2", "3", "4", "5", "6"} {
+		writeFile(t, engine, "q/"+name, name)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for _, name := range []string{"1", "2", "3", "4", "5", "6"} {
+		if got := readFile(t, replica, "q/"+name); got != name {
+			t.Errorf("replica q/%s = %q", name, got)
+		}
+	}
+	if _, err := engine.Create(context.Background(), "late"); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Create after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+}
+
+func TestMirror_ReadFailover(t *testing.T) {
+	replica := memory.New()
+	writeFile(t, replica, "f.txt", "from replica")
+	engine := mirror.New(downEngine{memory.New()}, []sbox.StorageEngine{replica})
+
+	if got := readFile(t, engine, "f.txt"); got != "from replica" {
+		t.Errorf("failover read = %q", got)
+	}
+}
+
+func TestMirror_Repair(t *testing.T) {
+	primary, replica := memory.New(), memory.New()
+	ctx := context.Background()
+	writeFile(t, primary, "tree/same.txt", "same")
+	writeFile(t, primary, "tree/changed.txt", "new content")
+	writeFile(t, primary, "tree/sub/missing.txt", "missing")
+	writeFile(t, replica, "tree/same.txt", "same")
+	writeFile(t, replica, "tree/changed.txt", "old content")
+	writeFile(t, replica, "tree/extra/stale.txt", "stale")
+
+	engine := mirror.New(primary, []sbox.StorageEngine{replica})
+	if err := engine.Repair(ctx, "tree"); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"tree/same.txt":        "same",
+		"tree/changed.txt":     "new content",
+		"tree/sub/missing.txt": "missing",
+	} {
+		if got := readFile(t, replica, path); got != want {
+			t.Errorf("replica %s = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := replica.Stat(ctx, "tree/extra"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("stale directory survived repair: %v", err)
+	}
+
+	// Same size, different bytes, on an engine without Hasher.
+	writeFile(t, primary, "x.txt", "aaaa")
+	plain := struct{ sbox.StorageEngine }{memory.New()}
+	writeFile(t, plain, "x.txt", "bbbb")
+	engine = mirror.New(primary, []sbox.StorageEngine{plain})
+	if err := engine.Repair(ctx, "x.txt"); err != nil {
+		t.Fatalf("Repair file: %v", err)
+	}
+	if got := readFile(t, plain, "x.txt"); got != "aaaa" {
+		t.Errorf("repaired x.txt = %q", got)
+	}
+}
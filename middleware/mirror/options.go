@@ -0,0 +1,25 @@
+package mirror
+
+import "log/slog"
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithAsync replicates in the background through a queue holding up to
+// queueSize pending operations. Writers block when the queue is full.
+func WithAsync(queueSize int) Option {
+	return func(e *Engine) {
+		e.async = true
+		e.queue = make(chan task, max(queueSize, 0))
+	}
+}
+
+// WithLogger sets the logger used to report asynchronous replication
+// failures. The default is slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(e *Engine) {
+		if logger != nil {
+			e.logger = logger
+		}
+	}
+}
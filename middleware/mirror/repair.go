@@ -0,0 +1,166 @@
+package mirror
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// Repair makes every replica match the primary at path, which may be a
+// file or a directory tree. Files that are missing or differ are copied
+// from the primary, and entries that exist only on a replica are removed.
+// Files are compared by size and then by SHA-256 when both engines
+// implement sbox.Hasher, or byte by byte otherwise.
+func (e *Engine) Repair(ctx context.Context, path string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	var errs []error
+	for i, r := range e.replicas {
+		if err := e.repairReplica(ctx, r, path); err != nil {
+			errs = append(errs, fmt.Errorf("sbox/mirror: repair replica %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Engine) repairReplica(ctx context.Context, replica sbox.StorageEngine, root string) error {
+	if _, err := e.primary.Stat(ctx, root); errors.Is(err, sbox.ErrNotFound) {
+		if err := replica.Remove(ctx, root); err != nil && !errors.Is(err, sbox.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	// Bring everything on the primary over.
+	err := sbox.Walk(ctx, e.primary, root, func(p string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir {
+			return replica.MkdirAll(ctx, p)
+		}
+		differ, err := differs(ctx, e.primary, replica, p, info)
+		if err != nil || !differ {
+			return err
+		}
+		return copyFile(ctx, e.primary, replica, p)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove what the primary doesn't have.
+	var extra []string
+	err = sbox.Walk(ctx, replica, root, func(p string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, err := e.primary.Stat(ctx, p); errors.Is(err, sbox.ErrNotFound) {
+			extra = append(extra, p)
+			if info.IsDir {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range extra {
+		if err := replica.Remove(ctx, p); err != nil && !errors.Is(err, sbox.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// differs reports whether the replica's copy of p differs from the
+// primary's.
+func differs(ctx context.Context, primary, replica sbox.StorageEngine, p string, info *sbox.EntryInfo) (bool, error) {
+	rinfo, err := replica.Stat(ctx, p)
+	if errors.Is(err, sbox.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if rinfo.IsDir || rinfo.Size != info.Size {
+		return true, nil
+	}
+
+	ph, pok := primary.(sbox.Hasher)
+	rh, rok := replica.(sbox.Hasher)
+	if pok && rok {
+		a, aerr := ph.Hash(ctx, p, "sha256")
+		b, berr := rh.Hash(ctx, p, "sha256")
+		if aerr == nil && berr == nil {
+			return !strings.EqualFold(a, b), nil
+		}
+	}
+	return contentDiffers(ctx, primary, replica, p)
+}
+
+// contentDiffers compares two files byte by byte.
+func contentDiffers(ctx context.Context, a, b sbox.StorageEngine, p string) (bool, error) {
+	ra, err := a.Open(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = ra.Close() }()
+	rb, err := b.Open(ctx, p)
+	if err != nil {
+		return true, nil
+	}
+	defer func() { _ = rb.Close() }()
+
+	ba, bb := bufio.NewReader(ra), bufio.NewReader(rb)
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(ba, bufA)
+		nb, errb := io.ReadFull(bb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return true, nil
+		}
+		doneA := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		doneB := errb == io.EOF || errb == io.ErrUnexpectedEOF
+		switch {
+		case erra != nil && !doneA:
+			return false, erra
+		case errb != nil && !doneB:
+			return true, nil
+		case doneA || doneB:
+			return doneA != doneB, nil
+		}
+	}
+}
+
+// copyFile copies p from src to dst.
+func copyFile(ctx context.Context, src, dst sbox.StorageEngine, p string) error {
+	r, err := src.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	return sbox.PutAtomic(ctx, dst, p, r)
+}
+
+// copyTree copies the file or directory tree at p from src to dst.
+func copyTree(ctx context.Context, src, dst sbox.StorageEngine, p string) error {
+	return sbox.Walk(ctx, src, p, func(fp string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir {
+			return dst.MkdirAll(ctx, fp)
+		}
+		return copyFile(ctx, src, dst, fp)
+	})
+}
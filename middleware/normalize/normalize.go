@@ -0,0 +1,323 @@
+// Package normalize provides a storage middleware that normalizes the
+// Unicode form and optionally the case of paths, so an engine behaves the
+// same on macOS (which decomposes names), Windows (which ignores case) and
+// S3-style backends (which compare bytes).
+//
+//	engine := normalize.New(inner, normalize.WithCaseFold(true))
+//
+// Every path is converted before it reaches the inner engine, and names
+// are stored in the normalized form: NFC unless WithForm says otherwise,
+// and case folded with WithCaseFold. Files written to the inner engine
+// directly under other forms of their names are still listed, but can
+// only be opened through the inner engine.
+//
+// Importing the package also enables the "pathNormalization" option of
+// sbox.Config, whose value is a spec for Parse:
+//
+//	sbox.Open(&sbox.Config{Type: "local", BasePath: "/data",
+//		Options: map[string]any{"pathNormalization": "nfc,casefold"}})
+package normalize
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/nuln/sbox"
+)
+
+func init() {
+	sbox.RegisterWrapper("pathNormalization", func(engine sbox.StorageEngine, value any) (sbox.StorageEngine, error) {
+		spec, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("sbox/normalize: pathNormalization must be a string, got %T", value)
+		}
+		opts, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		return New(engine, opts...), nil
+	})
+}
+
+// Engine normalizes the paths passed to an inner engine.
+type Engine struct {
+	inner sbox.StorageEngine
+	form  norm.Form
+	fold  bool
+}
+
+// New returns an Engine normalizing paths for inner.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{inner: inner, form: norm.NFC}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the inner engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// Path returns the normalized form of p, as passed to the inner engine.
+func (e *Engine) Path(p string) string {
+	if e.fold {
+		// Folding can leave a string that is not in normal form, so it
+		// runs first. A Caser is stateful and cannot be shared.
+		p = cases.Fold().String(p)
+	}
+	return e.form.String(p)
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	return e.inner.Stat(ctx, e.Path(path))
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	return e.inner.Open(ctx, e.Path(path))
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	return e.inner.Create(ctx, e.Path(path))
+}
+
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	return e.inner.OpenFile(ctx, e.Path(path), flag, perm)
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	return e.inner.Remove(ctx, e.Path(path))
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	return e.inner.Rename(ctx, e.Path(oldPath), e.Path(newPath))
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	return e.inner.MkdirAll(ctx, e.Path(path))
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return e.inner.ReadDir(ctx, e.Path(path))
+}
+
+// === Extension: Lister ===
+
+func (e *Engine) List(ctx context.Context, path string, opts sbox.ListOptions) (*sbox.ListPage, error) {
+	opts.Prefix = e.Path(opts.Prefix)
+	return sbox.ListDir(ctx, e.inner, e.Path(path), opts)
+}
+
+// === Extension: Copier ===
+
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return c.Copy(ctx, e.Path(src), e.Path(dst))
+}
+
+// === Extension: Hasher ===
+
+func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (string, error) {
+	h, ok := e.inner.(sbox.Hasher)
+	if !ok {
+		return "", sbox.ErrNotSupported
+	}
+	return h.Hash(ctx, e.Path(path), algorithm)
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	if sr, ok := e.inner.(sbox.StreamReader); ok {
+		return sr.Get(ctx, e.Path(path))
+	}
+	return e.inner.Open(ctx, e.Path(path))
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	if sw, ok := e.inner.(sbox.StreamWriter); ok {
+		return sw.Put(ctx, e.Path(path), reader)
+	}
+	w, err := e.inner.Create(ctx, e.Path(path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	rr, ok := e.inner.(sbox.RangeReader)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	return rr.GetRange(ctx, e.Path(path), offset, length)
+}
+
+// === Extension: Appender ===
+
+func (e *Engine) Append(ctx context.Context, path string, r io.Reader) (int64, error) {
+	a, ok := e.inner.(sbox.Appender)
+	if !ok {
+		return 0, sbox.ErrNotSupported
+	}
+	return a.Append(ctx, e.Path(path), r)
+}
+
+// === Extension: ModTimeSetter ===
+
+func (e *Engine) SetModTime(ctx context.Context, path string, t time.Time) error {
+	m, ok := e.inner.(sbox.ModTimeSetter)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return m.SetModTime(ctx, e.Path(path), t)
+}
+
+// === Extension: MetadataWriter ===
+
+func (e *Engine) SetMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	m, ok := e.inner.(sbox.MetadataWriter)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return m.SetMetadata(ctx, e.Path(path), metadata)
+}
+
+// === Extension: Symlinker ===
+
+// Symlink normalizes target too, since it names a path in the engine.
+func (e *Engine) Symlink(ctx context.Context, target, path string) error {
+	s, ok := e.inner.(sbox.Symlinker)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return s.Symlink(ctx, e.Path(target), e.Path(path))
+}
+
+func (e *Engine) Readlink(ctx context.Context, path string) (string, error) {
+	s, ok := e.inner.(sbox.Symlinker)
+	if !ok {
+		return "", sbox.ErrNotSupported
+	}
+	return s.Readlink(ctx, e.Path(path))
+}
+
+func (e *Engine) Lstat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	s, ok := e.inner.(sbox.Symlinker)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	return s.Lstat(ctx, e.Path(path))
+}
+
+// === Extension: PermissionManager ===
+
+func (e *Engine) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	p, ok := e.inner.(sbox.PermissionManager)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return p.Chmod(ctx, e.Path(path), mode)
+}
+
+func (e *Engine) Chown(ctx context.Context, path string, uid, gid int) error {
+	p, ok := e.inner.(sbox.PermissionManager)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return p.Chown(ctx, e.Path(path), uid, gid)
+}
+
+// === Extension: ConditionalWriter ===
+
+func (e *Engine) ETag(ctx context.Context, path string) (string, error) {
+	c, ok := e.inner.(sbox.ConditionalWriter)
+	if !ok {
+		return "", sbox.ErrNotSupported
+	}
+	return c.ETag(ctx, e.Path(path))
+}
+
+func (e *Engine) CreateIf(ctx context.Context, path string, cond sbox.Precondition) (sbox.AtomicWriteCloser, error) {
+	c, ok := e.inner.(sbox.ConditionalWriter)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	return c.CreateIf(ctx, e.Path(path), cond)
+}
+
+// === Extension: AtomicWriter ===
+
+func (e *Engine) CreateAtomic(ctx context.Context, path string) (sbox.AtomicWriteCloser, error) {
+	a, ok := e.inner.(sbox.AtomicWriter)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	return a.CreateAtomic(ctx, e.Path(path))
+}
+
+// === Extension: Locker ===
+
+func (e *Engine) Lock(ctx context.Context, path string, opts sbox.LockOptions) (sbox.Lease, error) {
+	l, ok := e.inner.(sbox.Locker)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	return l.Lock(ctx, e.Path(path), opts)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports the extensions forwarded to the inner engine only if
+// the inner engine supports them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapStreamRead, sbox.CapStreamWrite, sbox.CapList:
+		return true
+	}
+	return sbox.Implements(e, c) && sbox.Supports(e.inner, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Lister             = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.Hasher             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.RangeReader        = (*Engine)(nil)
+	_ sbox.Appender           = (*Engine)(nil)
+	_ sbox.ModTimeSetter      = (*Engine)(nil)
+	_ sbox.MetadataWriter     = (*Engine)(nil)
+	_ sbox.Symlinker          = (*Engine)(nil)
+	_ sbox.PermissionManager  = (*Engine)(nil)
+	_ sbox.ConditionalWriter  = (*Engine)(nil)
+	_ sbox.AtomicWriter       = (*Engine)(nil)
+	_ sbox.Locker             = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
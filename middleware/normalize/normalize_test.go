@@ -0,0 +1,122 @@
+package normalize_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/normalize"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	w, err := engine.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestNormalize_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, normalize.New(memory.New(), normalize.WithCaseFold(true)))
+}
+
+func TestNormalize_Unicode(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	engine := normalize.New(inner)
+
+	nfd := norm.NFD.String("café.txt")
+	writeFile(t, engine, nfd, "coffee")
+	if got := readFile(t, engine, "café.txt"); got != "coffee" {
+		t.Errorf("NFC read = %q", got)
+	}
+	if _, err := inner.Stat(ctx, "café.txt"); err != nil {
+		t.Errorf("name not stored as NFC: %v", err)
+	}
+
+	decomposed := normalize.New(inner, normalize.WithForm(norm.NFD))
+	if decomposed.Path("café.txt") != nfd {
+		t.Errorf("NFD Path = %q", decomposed.Path("café.txt"))
+	}
+}
+
+func TestNormalize_CaseFold(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	engine := normalize.New(inner, normalize.WithCaseFold(true))
+
+	writeFile(t, engine, "Docs/README.md", "hello")
+	if got := readFile(t, engine, "docs/readme.MD"); got != "hello" {
+		t.Errorf("read = %q", got)
+	}
+	writeFile(t, engine, "DOCS/readme.md", "again")
+	entries, err := engine.ReadDir(ctx, "docs")
+	if err != nil || len(entries) != 1 || entries[0].Name != "readme.md" {
+		t.Fatalf("ReadDir = %+v, %v", entries, err)
+	}
+	if got := readFile(t, engine, "Docs/Readme.md"); got != "again" {
+		t.Errorf("overwritten read = %q", got)
+	}
+	if err := engine.Rename(ctx, "docs/README.md", "Docs/Old.md"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := inner.Stat(ctx, "docs/old.md"); err != nil {
+		t.Errorf("renamed name not folded: %v", err)
+	}
+}
+
+func TestNormalize_Config(t *testing.T) {
+	engine, err := sbox.Open(&sbox.Config{
+		Type:    "memory",
+		Options: map[string]any{"name": t.Name(), "pathNormalization": "nfc,casefold"},
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	n, ok := engine.(*normalize.Engine)
+	if !ok {
+		t.Fatalf("Open returned %T", engine)
+	}
+	if got := n.Path("Ça.TXT"); got != "ça.txt" {
+		t.Errorf("Path = %q", got)
+	}
+
+	_, err = sbox.Open(&sbox.Config{
+		Type:    "memory",
+		Options: map[string]any{"pathNormalization": "nfkc"},
+	})
+	if err == nil {
+		t.Error("Open with unknown normalization succeeded")
+	}
+	if _, err := normalize.Parse("nfd, CaseFold"); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+	if _, err := sbox.Open(&sbox.Config{Type: "memory", Options: map[string]any{"pathNormalization": true}}); err == nil {
+		t.Error("Open with non-string spec succeeded")
+	}
+}
@@ -0,0 +1,48 @@
+package normalize
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithForm sets the Unicode normalization form applied to paths, norm.NFC
+// (the default) or norm.NFD.
+func WithForm(form norm.Form) Option {
+	return func(e *Engine) {
+		e.form = form
+	}
+}
+
+// WithCaseFold makes paths case-insensitive by case folding them, so
+// "Docs/A.txt" and "docs/a.txt" name the same file. Names are stored
+// folded.
+func WithCaseFold(enabled bool) Option {
+	return func(e *Engine) {
+		e.fold = enabled
+	}
+}
+
+// Parse returns the options for a pathNormalization spec: a comma
+// separated list of "nfc", "nfd" and "casefold", e.g. "nfc,casefold".
+func Parse(spec string) ([]Option, error) {
+	var opts []Option
+	for _, field := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "nfc":
+			opts = append(opts, WithForm(norm.NFC))
+		case "nfd":
+			opts = append(opts, WithForm(norm.NFD))
+		case "casefold":
+			opts = append(opts, WithCaseFold(true))
+		case "":
+		default:
+			return nil, fmt.Errorf("sbox/normalize: unknown normalization %q", field)
+		}
+	}
+	return opts, nil
+}
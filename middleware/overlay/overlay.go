@@ -0,0 +1,437 @@
+// Package overlay provides a union mount: an upper writable engine over an
+// ordered list of read-only lower engines, like overlayfs but independent
+// of the backends.
+//
+//	engine := overlay.New(cache, []sbox.StorageEngine{dataset})
+//
+// Reads see the upper engine first and then each lower engine in order;
+// directories merge the entries of every layer. All writes go to the upper
+// engine. A file from a lower engine is copied up before it is modified,
+// and removing it leaves a whiteout in the upper engine that hides it. The
+// lower engines are never written.
+//
+// Whiteouts are empty files named WhiteoutPrefix followed by the hidden
+// name, in the upper directory of the hidden entry. A directory created
+// where one was removed contains an OpaqueMarker file, which hides the
+// lower contents of the directory. These names are reserved: they are
+// never listed, and creating them fails with sbox.ErrInvalid.
+package overlay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// Reserved names in the upper engine.
+const (
+	WhiteoutPrefix = ".wh."
+	OpaqueMarker   = ".wh..wh..opq"
+)
+
+// Engine merges an upper engine and lower engines.
+type Engine struct {
+	upper  sbox.StorageEngine
+	lowers []sbox.StorageEngine
+}
+
+// New returns an Engine writing to upper over lowers, which are searched
+// in order.
+func New(upper sbox.StorageEngine, lowers []sbox.StorageEngine) *Engine {
+	return &Engine{upper: upper, lowers: lowers}
+}
+
+// Upper returns the upper engine.
+func (e *Engine) Upper() sbox.StorageEngine {
+	return e.upper
+}
+
+// Lowers returns the lower engines.
+func (e *Engine) Lowers() []sbox.StorageEngine {
+	return e.lowers
+}
+
+// Close closes all engines that implement io.Closer.
+func (e *Engine) Close() error {
+	errs := []error{sbox.Close(e.upper)}
+	for _, l := range e.lowers {
+		errs = append(errs, sbox.Close(l))
+	}
+	return errors.Join(errs...)
+}
+
+func clean(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// reserved reports whether a name on the path p is reserved for
+// whiteouts.
+func reserved(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, WhiteoutPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func whiteoutPath(p string) string {
+	return path.Join(path.Dir(p), WhiteoutPrefix+path.Base(p))
+}
+
+// exists reports whether p exists in the upper engine.
+func (e *Engine) exists(ctx context.Context, p string) (bool, error) {
+	_, err := e.upper.Stat(ctx, p)
+	if errors.Is(err, sbox.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// layers returns the lower engines p is visible in, i.e. none if p or one
+// of its parents is whited out or below an opaque directory.
+func (e *Engine) layers(ctx context.Context, p string) ([]sbox.StorageEngine, error) {
+	if p == "" {
+		return e.lowers, nil
+	}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		dir := strings.Join(parts[:i], "/")
+		if i > 0 {
+			if ok, err := e.exists(ctx, path.Join(dir, OpaqueMarker)); ok || err != nil {
+				return nil, err
+			}
+		}
+		if ok, err := e.exists(ctx, path.Join(dir, WhiteoutPrefix+part)); ok || err != nil {
+			return nil, err
+		}
+	}
+	return e.lowers, nil
+}
+
+// find returns the topmost engine holding p, its entry, and the lower
+// engines p is visible in.
+func (e *Engine) find(ctx context.Context, p string) (sbox.StorageEngine, *sbox.EntryInfo, []sbox.StorageEngine, error) {
+	if reserved(p) {
+		return nil, nil, nil, sbox.ErrNotFound
+	}
+	lowers, err := e.layers(ctx, p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, layer := range append([]sbox.StorageEngine{e.upper}, lowers...) {
+		info, err := layer.Stat(ctx, p)
+		if err == nil {
+			return layer, info, lowers, nil
+		}
+		if !errors.Is(err, sbox.ErrNotFound) {
+			return nil, nil, nil, err
+		}
+	}
+	return nil, nil, lowers, sbox.ErrNotFound
+}
+
+// inLowers reports whether p exists in one of lowers.
+func inLowers(ctx context.Context, lowers []sbox.StorageEngine, p string) (bool, error) {
+	for _, l := range lowers {
+		_, err := l.Stat(ctx, p)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, sbox.ErrNotFound) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// prepare makes p writable in the upper engine. Whiteouts on the path are
+// removed, and a directory that replaces a removed one is made opaque, so
+// that the removed lower contents stay hidden.
+func (e *Engine) prepare(ctx context.Context, p string, dir bool) error {
+	if reserved(p) {
+		return sbox.ErrInvalid
+	}
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		cur := strings.Join(parts[:i+1], "/")
+		wh := whiteoutPath(cur)
+		ok, err := e.exists(ctx, wh)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := e.upper.Remove(ctx, wh); err != nil {
+			return err
+		}
+		if i == len(parts)-1 && !dir {
+			continue
+		}
+		if err := e.upper.MkdirAll(ctx, cur); err != nil {
+			return err
+		}
+		if err := e.touch(ctx, path.Join(cur, OpaqueMarker)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touch creates an empty file in the upper engine.
+func (e *Engine) touch(ctx context.Context, p string) error {
+	w, err := e.upper.Create(ctx, p)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// wrapErr makes *err an *sbox.PathError of the overlay, unless it already
+// is one from a layer.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("overlay", op, path, *err)
+}
+
+func (e *Engine) Stat(ctx context.Context, p string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", p, &err)
+	_, info, _, err := e.find(ctx, clean(p))
+	return info, err
+}
+
+func (e *Engine) Open(ctx context.Context, p string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	p = clean(p)
+	layer, _, _, err := e.find(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Open(ctx, p)
+}
+
+// ReadDir merges the directory across the layers, down to the first layer
+// in which p is not a directory.
+func (e *Engine) ReadDir(ctx context.Context, p string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", p, &err)
+	p = clean(p)
+	_, info, lowers, err := e.find(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, sbox.ErrNotDir
+	}
+
+	seen := make(map[string]bool)
+	var entries []*sbox.EntryInfo
+	opaque := false
+	upper, err := e.upper.ReadDir(ctx, p)
+	if err != nil && !errors.Is(err, sbox.ErrNotFound) {
+		return nil, err
+	}
+	for _, entry := range upper {
+		switch {
+		case entry.Name == OpaqueMarker:
+			opaque = true
+		case strings.HasPrefix(entry.Name, WhiteoutPrefix):
+			seen[strings.TrimPrefix(entry.Name, WhiteoutPrefix)] = true
+		case !seen[entry.Name]:
+			seen[entry.Name] = true
+			entries = append(entries, entry)
+		}
+	}
+	if opaque {
+		lowers = nil
+	}
+	for _, l := range lowers {
+		info, err := l.Stat(ctx, p)
+		if errors.Is(err, sbox.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir {
+			break
+		}
+		lower, err := l.ReadDir(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range lower {
+			if !seen[entry.Name] {
+				seen[entry.Name] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func (e *Engine) Create(ctx context.Context, p string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", p, &err)
+	p = clean(p)
+	if err := e.prepare(ctx, p, false); err != nil {
+		return nil, err
+	}
+	return e.upper.Create(ctx, p)
+}
+
+// OpenFile opens p in the upper engine. A file from a lower engine is
+// copied up first, unless os.O_TRUNC discards its content anyway.
+func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	p = clean(p)
+	layer, info, _, err := e.find(ctx, p)
+	switch {
+	case err == nil && info.IsDir:
+		return nil, sbox.ErrIsDir
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, sbox.ErrExist
+	case err != nil && !errors.Is(err, sbox.ErrNotFound):
+		return nil, err
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+	if err == nil && layer != e.upper {
+		if flag&os.O_TRUNC == 0 {
+			if err := sbox.CopyTree(ctx, layer, p, e.upper, p, sbox.CopyTreeOptions{}); err != nil {
+				return nil, err
+			}
+		}
+		flag |= os.O_CREATE
+	}
+	if err := e.prepare(ctx, p, false); err != nil {
+		return nil, err
+	}
+	return e.upper.OpenFile(ctx, p, flag, perm)
+}
+
+// Remove deletes p from the upper engine and whites it out if a lower
+// engine has it. Removing a missing path fails with sbox.ErrNotFound.
+func (e *Engine) Remove(ctx context.Context, p string) (err error) {
+	defer wrapErr("remove", p, &err)
+	p = clean(p)
+	if p == "" {
+		entries, err := e.ReadDir(ctx, p)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := e.Remove(ctx, entry.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, _, lowers, err := e.find(ctx, p)
+	if err != nil {
+		return err
+	}
+	if err := e.upper.Remove(ctx, p); err != nil && !errors.Is(err, sbox.ErrNotFound) {
+		return err
+	}
+	if ok, err := inLowers(ctx, lowers, p); !ok || err != nil {
+		return err
+	}
+	return e.touch(ctx, whiteoutPath(p))
+}
+
+// Rename renames p in the upper engine if no lower engine has it.
+// Otherwise the merged tree is copied up to newPath and oldPath is
+// removed, which can take a while for large directories.
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	oldPath, newPath = clean(oldPath), clean(newPath)
+	_, info, lowers, err := e.find(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	lower, err := inLowers(ctx, lowers, oldPath)
+	if err != nil {
+		return err
+	}
+	if !lower {
+		if err := e.prepare(ctx, newPath, info.IsDir); err != nil {
+			return err
+		}
+		return e.upper.Rename(ctx, oldPath, newPath)
+	}
+	if reserved(newPath) {
+		return sbox.ErrInvalid
+	}
+	if err := sbox.CopyTree(ctx, e, oldPath, e, newPath, sbox.CopyTreeOptions{}); err != nil {
+		return err
+	}
+	return e.Remove(ctx, oldPath)
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, p string) (err error) {
+	defer wrapErr("mkdir", p, &err)
+	p = clean(p)
+	_, info, _, err := e.find(ctx, p)
+	switch {
+	case err == nil && info.IsDir:
+		return nil
+	case err == nil:
+		return sbox.ErrNotDir
+	case !errors.Is(err, sbox.ErrNotFound):
+		return err
+	}
+	if err := e.prepare(ctx, p, true); err != nil {
+		return err
+	}
+	return e.upper.MkdirAll(ctx, p)
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	return e.Open(ctx, p)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, p string, reader io.Reader) error {
+	w, err := e.Create(ctx, p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks the upper and lower layers with sbox.Ping.
+func (e *Engine) Ping(ctx context.Context) error {
+	for _, layer := range append([]sbox.StorageEngine{e.upper}, e.lowers...) {
+		if err := sbox.Ping(ctx, layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine = (*Engine)(nil)
+	_ sbox.StreamReader  = (*Engine)(nil)
+	_ sbox.StreamWriter  = (*Engine)(nil)
+	_ io.Closer          = (*Engine)(nil)
+	_ sbox.HealthChecker = (*Engine)(nil)
+)
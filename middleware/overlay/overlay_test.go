@@ -0,0 +1,176 @@
+package overlay_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/overlay"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	w, err := engine.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func names(t *testing.T, engine sbox.StorageEngine, dir string) []string {
+	t.Helper()
+	entries, err := engine.ReadDir(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("ReadDir %s: %v", dir, err)
+	}
+	var out []string
+	for _, entry := range entries {
+		out = append(out, entry.Name)
+	}
+	return out
+}
+
+// newOverlay returns an overlay over two lower layers with overlapping
+// files.
+func newOverlay(t *testing.T) (*overlay.Engine, sbox.StorageEngine, []sbox.StorageEngine) {
+	t.Helper()
+	top, bottom := memory.New(), memory.New()
+	writeFile(t, top, "data/a.txt", "a from top")
+	writeFile(t, bottom, "data/a.txt", "a from bottom")
+	writeFile(t, bottom, "data/b.txt", "b from bottom")
+	writeFile(t, bottom, "data/sub/c.txt", "c from bottom")
+	upper := memory.New()
+	lowers := []sbox.StorageEngine{top, bottom}
+	return overlay.New(upper, lowers), upper, lowers
+}
+
+func TestOverlay_Suite(t *testing.T) {
+	engine, _, _ := newOverlay(t)
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestOverlay_Merge(t *testing.T) {
+	ctx := context.Background()
+	engine, upper, _ := newOverlay(t)
+
+	if got := readFile(t, engine, "data/a.txt"); got != "a from top" {
+		t.Errorf("a.txt = %q", got)
+	}
+	writeFile(t, engine, "data/new.txt", "new")
+	if got := names(t, engine, "data"); len(got) != 4 || got[0] != "a.txt" || got[2] != "new.txt" || got[3] != "sub" {
+		t.Errorf("ReadDir = %v", got)
+	}
+	if _, err := upper.Stat(ctx, "data/new.txt"); err != nil {
+		t.Errorf("new file not in upper: %v", err)
+	}
+}
+
+func TestOverlay_CopyUp(t *testing.T) {
+	ctx := context.Background()
+	engine, upper, lowers := newOverlay(t)
+
+	w, err := engine.OpenFile(ctx, "data/b.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = io.WriteString(w, "!")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := readFile(t, engine, "data/b.txt"); got != "b from bottom!" {
+		t.Errorf("b.txt = %q", got)
+	}
+	if got := readFile(t, upper, "data/b.txt"); got != "b from bottom!" {
+		t.Errorf("upper b.txt = %q", got)
+	}
+	if got := readFile(t, lowers[1], "data/b.txt"); got != "b from bottom" {
+		t.Errorf("lower b.txt changed to %q", got)
+	}
+}
+
+func TestOverlay_Whiteout(t *testing.T) {
+	ctx := context.Background()
+	engine, _, lowers := newOverlay(t)
+
+	if err := engine.Remove(ctx, "data/a.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := engine.Stat(ctx, "data/a.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat removed = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if got := names(t, engine, "data"); len(got) != 2 || got[0] != "b.txt" || got[1] != "sub" {
+		t.Errorf("ReadDir after Remove = %v", got)
+	}
+	if _, err := lowers[0].Stat(ctx, "data/a.txt"); err != nil {
+		t.Errorf("lower file removed: %v", err)
+	}
+	if _, err := engine.Create(ctx, "data/"+overlay.WhiteoutPrefix+"x"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Create reserved name = %v, want %v", err, sbox.ErrInvalid)
+	}
+
+	// A directory recreated over a removed one starts out empty.
+	if err := engine.Remove(ctx, "data"); err != nil {
+		t.Fatalf("Remove dir: %v", err)
+	}
+	writeFile(t, engine, "data/sub/d.txt", "d")
+	if got := names(t, engine, "data"); len(got) != 1 || got[0] != "sub" {
+		t.Errorf("recreated data = %v", got)
+	}
+	if got := names(t, engine, "data/sub"); len(got) != 1 || got[0] != "d.txt" {
+		t.Errorf("recreated data/sub = %v", got)
+	}
+
+	// Recreating a removed file shows the new content.
+	if err := engine.Remove(ctx, "data/sub/d.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	writeFile(t, engine, "data/a.txt", "again")
+	if got := readFile(t, engine, "data/a.txt"); got != "again" {
+		t.Errorf("a.txt = %q", got)
+	}
+}
+
+func TestOverlay_RenameLower(t *testing.T) {
+	ctx := context.Background()
+	engine, _, lowers := newOverlay(t)
+
+	if err := engine.Rename(ctx, "data", "moved"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := engine.Stat(ctx, "data"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat old = %v", err)
+	}
+	if got := readFile(t, engine, "moved/a.txt"); got != "a from top" {
+		t.Errorf("moved/a.txt = %q", got)
+	}
+	if got := readFile(t, engine, "moved/sub/c.txt"); got != "c from bottom" {
+		t.Errorf("moved/sub/c.txt = %q", got)
+	}
+	if _, err := lowers[1].Stat(ctx, "data/sub/c.txt"); err != nil {
+		t.Errorf("lower file moved: %v", err)
+	}
+}
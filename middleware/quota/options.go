@@ -0,0 +1,26 @@
+package quota
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithLimit limits the bytes and files stored under prefix. Zero means no
+// limit for that dimension. Nested prefixes are allowed; a write must fit
+// within every limit covering its path.
+func WithLimit(prefix string, maxBytes, maxFiles int64) Option {
+	return func(e *Engine) {
+		e.limits = append(e.limits, &tracked{Limit: Limit{
+			Prefix:   cleanPath(prefix),
+			MaxBytes: maxBytes,
+			MaxFiles: maxFiles,
+		}})
+	}
+}
+
+// WithStore sets where usage is persisted. The default is a MemoryStore.
+func WithStore(s Store) Option {
+	return func(e *Engine) {
+		if s != nil {
+			e.store = s
+		}
+	}
+}
@@ -0,0 +1,476 @@
+// Package quota provides a storage middleware that limits the total bytes
+// and number of files under path prefixes of any sbox.StorageEngine.
+//
+//	store := quota.NewEngineStore(local.New("/var/lib/app"), "quota.json")
+//	engine := quota.New(inner,
+//	    quota.WithLimit("users/alice", 10<<30, 100_000),
+//	    quota.WithStore(store))
+//
+// Writes that would take a prefix over its limit fail with an error that
+// matches ErrQuotaExceeded. Usage is computed by walking each prefix the
+// first time it is needed and is then maintained incrementally and saved to
+// the Store, so a persistent Store avoids rescanning after a restart. The
+// Engine assumes it is the only writer to the limited prefixes.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/nuln/sbox"
+)
+
+// ErrQuotaExceeded is matched by every *QuotaError.
+var ErrQuotaExceeded = errors.New("sbox/quota: quota exceeded")
+
+// Limit caps the usage under Prefix. Zero means unlimited.
+type Limit struct {
+	Prefix   string
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// QuotaError reports a write rejected by a Limit.
+type QuotaError struct {
+	Limit Limit
+	Usage Usage // usage before the rejected change
+	Delta Usage // the rejected change
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("sbox/quota: quota exceeded for %q: %d+%d bytes (limit %d), %d+%d files (limit %d)",
+		e.Limit.Prefix, e.Usage.Bytes, e.Delta.Bytes, e.Limit.MaxBytes, e.Usage.Files, e.Delta.Files, e.Limit.MaxFiles)
+}
+
+// Is makes errors.Is(err, ErrQuotaExceeded) report true.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// tracked is the live usage of one Limit.
+type tracked struct {
+	Limit
+	usage  Usage
+	loaded bool
+	dirty  bool
+}
+
+func (t *tracked) covers(p string) bool {
+	return t.Prefix == "" || p == t.Prefix || strings.HasPrefix(p, t.Prefix+"/")
+}
+
+// change is a usage delta for a single file.
+type change struct {
+	path  string
+	delta Usage
+}
+
+// Engine enforces quotas on an inner engine.
+type Engine struct {
+	inner  sbox.StorageEngine
+	store  Store
+	mu     sync.Mutex
+	limits []*tracked
+}
+
+// New returns an Engine enforcing the configured limits on inner.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{inner: inner, store: NewMemoryStore()}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// cleanPath normalizes p to the slash-separated form without leading or
+// trailing slashes used for prefix matching. The root is "".
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// Usage returns the current usage under prefix, which must be the prefix
+// of a configured limit.
+func (e *Engine) Usage(ctx context.Context, prefix string) (Usage, error) {
+	prefix = cleanPath(prefix)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, t := range e.limits {
+		if t.Prefix == prefix {
+			if err := e.ensure(ctx, t); err != nil {
+				return Usage{}, err
+			}
+			return t.usage, nil
+		}
+	}
+	return Usage{}, fmt.Errorf("sbox/quota: no limit configured for %q: %w", prefix, sbox.ErrInvalid)
+}
+
+// ensure loads t's usage from the store, scanning the prefix if the store
+// has none. It must be called with e.mu held.
+func (e *Engine) ensure(ctx context.Context, t *tracked) error {
+	if t.loaded {
+		return nil
+	}
+	u, ok, err := e.store.Load(ctx, t.Prefix)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		files, err := e.files(ctx, t.Prefix)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			u.Bytes += f.delta.Bytes
+			u.Files++
+		}
+		if err := e.store.Save(ctx, t.Prefix, u); err != nil {
+			return err
+		}
+	}
+	t.usage, t.loaded = u, true
+	return nil
+}
+
+// files returns one change per file at or below p, each carrying the
+// file's size. A missing p yields no files.
+func (e *Engine) files(ctx context.Context, p string) ([]change, error) {
+	var files []change
+	err := sbox.Walk(ctx, e.inner, p, func(fp string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, sbox.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir {
+			files = append(files, change{path: cleanPath(fp), delta: Usage{Bytes: info.Size, Files: 1}})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// adjust applies changes to every limit covering the changed paths. When
+// check is set, a limit whose usage would grow past its maximum rejects the
+// whole set with a *QuotaError and nothing is applied.
+func (e *Engine) adjust(ctx context.Context, changes []change, check bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deltas := make(map[*tracked]Usage)
+	for _, t := range e.limits {
+		for _, c := range changes {
+			if t.covers(c.path) {
+				d := deltas[t]
+				d.Bytes += c.delta.Bytes
+				d.Files += c.delta.Files
+				deltas[t] = d
+			}
+		}
+	}
+	for t, d := range deltas {
+		if err := e.ensure(ctx, t); err != nil {
+			return err
+		}
+		if !check {
+			continue
+		}
+		if (d.Bytes > 0 && t.MaxBytes > 0 && t.usage.Bytes+d.Bytes > t.MaxBytes) ||
+			(d.Files > 0 && t.MaxFiles > 0 && t.usage.Files+d.Files > t.MaxFiles) {
+			return &QuotaError{Limit: t.Limit, Usage: t.usage, Delta: d}
+		}
+	}
+	for t, d := range deltas {
+		t.usage.Bytes += d.Bytes
+		t.usage.Files += d.Files
+		t.dirty = true
+	}
+	return nil
+}
+
+// persist saves the usage of every limit changed since the last call.
+func (e *Engine) persist(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var errs []error
+	for _, t := range e.limits {
+		if t.dirty {
+			if err := e.store.Save(ctx, t.Prefix, t.usage); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			t.dirty = false
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// negate returns changes with every delta reversed.
+func negate(changes []change) []change {
+	out := make([]change, len(changes))
+	for i, c := range changes {
+		out[i] = change{path: c.path, delta: Usage{Bytes: -c.delta.Bytes, Files: -c.delta.Files}}
+	}
+	return out
+}
+
+// mutate checks and applies changes, runs op, and rolls the changes back if
+// op fails.
+func (e *Engine) mutate(ctx context.Context, changes []change, op func() error) error {
+	if err := e.adjust(ctx, changes, true); err != nil {
+		return err
+	}
+	if err := op(); err != nil {
+		_ = e.adjust(ctx, negate(changes), false)
+		return err
+	}
+	return e.persist(ctx)
+}
+
+// existing returns the size of the regular file at p, and whether there is
+// one.
+func (e *Engine) existing(ctx context.Context, p string) (int64, bool, error) {
+	info, err := e.inner.Stat(ctx, p)
+	switch {
+	case errors.Is(err, sbox.ErrNotFound):
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	case info.IsDir:
+		return 0, false, sbox.ErrIsDir
+	}
+	return info.Size, true, nil
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	return e.inner.Stat(ctx, path)
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	return e.inner.Open(ctx, path)
+}
+
+// Create truncates path, releasing its old size, and counts one file if it
+// did not exist. Bytes are charged as they are written.
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	w, err := e.open(ctx, path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, func() (sbox.WriteSeekCloser, error) {
+		w, err := e.inner.Create(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return &noSeek{w}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	w, err := e.open(ctx, path, flag, func() (sbox.WriteSeekCloser, error) {
+		return e.inner.OpenFile(ctx, path, flag, perm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (e *Engine) open(ctx context.Context, p string, flag int, open func() (sbox.WriteSeekCloser, error)) (*writer, error) {
+	cp := cleanPath(p)
+	size, exists, err := e.existing(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []change
+	switch {
+	case !exists && flag&os.O_CREATE != 0:
+		changes = []change{{path: cp, delta: Usage{Files: 1}}}
+	case exists && flag&os.O_TRUNC != 0:
+		changes = []change{{path: cp, delta: Usage{Bytes: -size}}}
+		size = 0
+	}
+
+	var inner sbox.WriteSeekCloser
+	err = e.mutate(ctx, changes, func() error {
+		var err error
+		inner, err = open()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &writer{engine: e, ctx: ctx, path: cp, w: inner, size: size, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	files, err := e.files(ctx, path)
+	if err != nil {
+		return err
+	}
+	return e.mutate(ctx, negate(files), func() error {
+		return e.inner.Remove(ctx, path)
+	})
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	changes, err := e.transfer(ctx, oldPath, newPath, true)
+	if err != nil {
+		return err
+	}
+	return e.mutate(ctx, changes, func() error {
+		return e.inner.Rename(ctx, oldPath, newPath)
+	})
+}
+
+// transfer returns the changes of copying or moving src to dst, replacing
+// whatever is at dst.
+func (e *Engine) transfer(ctx context.Context, src, dst string, move bool) ([]change, error) {
+	srcFiles, err := e.files(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	dstFiles, err := e.files(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	from, to := cleanPath(src), cleanPath(dst)
+	changes := negate(dstFiles)
+	for _, f := range srcFiles {
+		moved := to + strings.TrimPrefix(f.path, from)
+		changes = append(changes, change{path: strings.TrimPrefix(moved, "/"), delta: f.delta})
+	}
+	if move {
+		changes = append(changes, negate(srcFiles)...)
+	}
+	return changes, nil
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	return e.inner.MkdirAll(ctx, path)
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return e.inner.ReadDir(ctx, path)
+}
+
+// === Extension: Copier ===
+
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	changes, err := e.transfer(ctx, src, dst, false)
+	if err != nil {
+		return err
+	}
+	return e.mutate(ctx, changes, func() error {
+		return c.Copy(ctx, src, dst)
+	})
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writer charges file growth against the quota before each write.
+type writer struct {
+	engine *Engine
+	ctx    context.Context
+	path   string
+	w      sbox.WriteSeekCloser
+	offset int64
+	size   int64
+	append bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.append {
+		w.offset = w.size
+	}
+	grow := max(0, w.offset+int64(len(p))-w.size)
+	if grow > 0 {
+		if err := w.engine.adjust(w.ctx, []change{{path: w.path, delta: Usage{Bytes: grow}}}, true); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.w.Write(p)
+	if grown := max(0, w.offset+int64(n)-w.size); grown < grow {
+		_ = w.engine.adjust(w.ctx, []change{{path: w.path, delta: Usage{Bytes: grown - grow}}}, false)
+	}
+	w.offset += int64(n)
+	w.size = max(w.size, w.offset)
+	return n, err
+}
+
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	pos, err := w.w.Seek(offset, whence)
+	if err == nil {
+		w.offset = pos
+	}
+	return pos, err
+}
+
+func (w *writer) Close() error {
+	err := w.w.Close()
+	return errors.Join(err, w.engine.persist(w.ctx))
+}
+
+// noSeek adapts a WriteCloser returned by Create.
+type noSeek struct {
+	io.WriteCloser
+}
+
+func (noSeek) Seek(int64, int) (int64, error) {
+	return 0, sbox.ErrNotSupported
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier only if the inner engine supports it.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ error                   = (*QuotaError)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
@@ -0,0 +1,137 @@
+package quota_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/quota"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func put(engine sbox.StorageEngine, path, content string) error {
+	return engine.(sbox.StreamWriter).Put(context.Background(), path, strings.NewReader(content))
+}
+
+func usage(t *testing.T, engine *quota.Engine, prefix string) quota.Usage {
+	t.Helper()
+	u, err := engine.Usage(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("Usage(%q): %v", prefix, err)
+	}
+	return u
+}
+
+func TestQuota_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, quota.New(memory.New(), quota.WithLimit("", 1<<20, 1000)))
+}
+
+func TestQuota_Bytes(t *testing.T) {
+	engine := quota.New(memory.New(), quota.WithLimit("users/alice", 10, 0))
+	ctx := context.Background()
+
+	if err := put(engine, "users/alice/a.txt", "123456"); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	err := put(engine, "users/alice/b.txt", "123456")
+	var qe *quota.QuotaError
+	if !errors.Is(err, quota.ErrQuotaExceeded) || !errors.As(err, &qe) {
+		t.Fatalf("write over quota = %v, want %v", err, quota.ErrQuotaExceeded)
+	}
+	if qe.Limit.Prefix != "users/alice" {
+		t.Errorf("QuotaError prefix = %q", qe.Limit.Prefix)
+	}
+
+	// Other prefixes are not limited.
+	if err := put(engine, "users/bob/big.txt", strings.Repeat("x", 100)); err != nil {
+		t.Errorf("write outside prefix: %v", err)
+	}
+
+	// Overwriting and removing release space.
+	if err := engine.Remove(ctx, "users/alice/b.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := put(engine, "users/alice/a.txt", "1234567890"); err != nil {
+		t.Fatalf("overwrite within quota: %v", err)
+	}
+	if u := usage(t, engine, "users/alice"); u.Bytes != 10 || u.Files != 1 {
+		t.Errorf("usage = %+v, want 10 bytes in 1 file", u)
+	}
+
+	// Appending past the limit fails.
+	w, err := engine.OpenFile(ctx, "users/alice/a.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := io.WriteString(w, "!"); !errors.Is(err, quota.ErrQuotaExceeded) {
+		t.Errorf("append over quota = %v", err)
+	}
+	_ = w.Close()
+}
+
+func TestQuota_FilesAndRename(t *testing.T) {
+	engine := quota.New(memory.New(), quota.WithLimit("inbox", 0, 2))
+	ctx := context.Background()
+
+	for _, name := range []string{"outbox/1", "outbox/2", "outbox/3"} {
+		if err := put(engine, name, "x"); err != nil {
+			t.Fatalf("put %s: %v", name, err)
+		}
+	}
+	if err := engine.Rename(ctx, "outbox/1", "inbox/1"); err != nil {
+		t.Fatalf("Rename 1: %v", err)
+	}
+	if err := put(engine, "inbox/2", "x"); err != nil {
+		t.Fatalf("put inbox/2: %v", err)
+	}
+	if err := engine.Rename(ctx, "outbox/3", "inbox/3"); !errors.Is(err, quota.ErrQuotaExceeded) {
+		t.Errorf("Rename over file quota = %v", err)
+	}
+	if _, err := engine.Stat(ctx, "outbox/3"); err != nil {
+		t.Errorf("rejected rename moved the file: %v", err)
+	}
+
+	// Moving a directory out of the prefix frees its files.
+	if err := engine.Rename(ctx, "inbox", "archive"); err != nil {
+		t.Fatalf("Rename dir: %v", err)
+	}
+	if u := usage(t, engine, "inbox"); u.Files != 0 {
+		t.Errorf("usage after moving inbox away = %+v", u)
+	}
+}
+
+func TestQuota_PersistentStore(t *testing.T) {
+	inner := memory.New()
+	state := memory.New()
+	ctx := context.Background()
+
+	// Usage of existing data is computed on first use.
+	if err := put(inner, "data/old.bin", "12345"); err != nil {
+		t.Fatal(err)
+	}
+	engine := quota.New(inner, quota.WithLimit("data", 100, 0), quota.WithStore(quota.NewEngineStore(state, "usage.json")))
+	if err := put(engine, "data/new.bin", "123"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if u := usage(t, engine, "data"); u.Bytes != 8 || u.Files != 2 {
+		t.Errorf("usage = %+v, want 8 bytes in 2 files", u)
+	}
+
+	// A new engine trusts the stored usage instead of rescanning.
+	if err := put(inner, "data/untracked.bin", "ignored"); err != nil {
+		t.Fatal(err)
+	}
+	reopened := quota.New(inner, quota.WithLimit("data", 100, 0), quota.WithStore(quota.NewEngineStore(state, "usage.json")))
+	if u := usage(t, reopened, "data"); u.Bytes != 8 || u.Files != 2 {
+		t.Errorf("reloaded usage = %+v, want 8 bytes in 2 files", u)
+	}
+
+	if _, err := reopened.Usage(ctx, "unknown"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Usage of unconfigured prefix = %v", err)
+	}
+}
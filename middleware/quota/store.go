@@ -0,0 +1,125 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/nuln/sbox"
+)
+
+// Usage is the space consumed under a prefix.
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// Store persists usage per prefix so that it survives restarts. When a
+// Store has no usage recorded for a prefix, the Engine computes it by
+// walking the prefix once.
+type Store interface {
+	// Load returns the usage of prefix and whether it was known.
+	Load(ctx context.Context, prefix string) (Usage, bool, error)
+	// Save records the usage of prefix.
+	Save(ctx context.Context, prefix string, u Usage) error
+}
+
+// MemoryStore keeps usage in memory only. It is the default Store; usage is
+// recomputed after a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	usage map[string]Usage
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{usage: make(map[string]Usage)}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, prefix string) (Usage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.usage[prefix]
+	return u, ok, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, prefix string, u Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage[prefix] = u
+	return nil
+}
+
+// EngineStore keeps usage for all prefixes in one JSON file on a storage
+// engine, typically a small local engine separate from the one being
+// limited.
+type EngineStore struct {
+	engine sbox.StorageEngine
+	path   string
+
+	mu    sync.Mutex
+	usage map[string]Usage // nil until loaded
+}
+
+// NewEngineStore returns a Store persisting usage to path on engine.
+func NewEngineStore(engine sbox.StorageEngine, path string) *EngineStore {
+	return &EngineStore{engine: engine, path: path}
+}
+
+func (s *EngineStore) load(ctx context.Context) error {
+	if s.usage != nil {
+		return nil
+	}
+	r, err := s.engine.Open(ctx, s.path)
+	if errors.Is(err, sbox.ErrNotFound) {
+		s.usage = make(map[string]Usage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	usage := make(map[string]Usage)
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return err
+	}
+	s.usage = usage
+	return nil
+}
+
+func (s *EngineStore) Load(ctx context.Context, prefix string) (Usage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return Usage{}, false, err
+	}
+	u, ok := s.usage[prefix]
+	return u, ok, nil
+}
+
+func (s *EngineStore) Save(ctx context.Context, prefix string, u Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	s.usage[prefix] = u
+	data, err := json.Marshal(s.usage)
+	if err != nil {
+		return err
+	}
+	return sbox.PutAtomic(ctx, s.engine, s.path, bytes.NewReader(data))
+}
+
+// Compile-time interface checks.
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*EngineStore)(nil)
+)
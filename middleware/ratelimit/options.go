@@ -0,0 +1,31 @@
+package ratelimit
+
+import "golang.org/x/time/rate"
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithOpsLimit limits engine calls to perSecond on average, allowing bursts
+// of up to burst calls. Every StorageEngine method and extension call
+// counts as one operation; reads and writes on open files do not.
+func WithOpsLimit(perSecond float64, burst int) Option {
+	return func(e *Engine) {
+		e.ops = rate.NewLimiter(rate.Limit(perSecond), max(burst, 1))
+	}
+}
+
+// WithReadLimit limits the bytes read from open files to bytesPerSecond,
+// allowing one second's worth as a burst.
+func WithReadLimit(bytesPerSecond int) Option {
+	return func(e *Engine) {
+		e.read = rate.NewLimiter(rate.Limit(bytesPerSecond), max(bytesPerSecond, 1))
+	}
+}
+
+// WithWriteLimit limits the bytes written to files to bytesPerSecond,
+// allowing one second's worth as a burst.
+func WithWriteLimit(bytesPerSecond int) Option {
+	return func(e *Engine) {
+		e.write = rate.NewLimiter(rate.Limit(bytesPerSecond), max(bytesPerSecond, 1))
+	}
+}
@@ -0,0 +1,268 @@
+// Package ratelimit provides a storage middleware that throttles operations
+// per second and bytes per second, with separate read and write budgets,
+// on any sbox.StorageEngine.
+//
+//	engine := ratelimit.New(nas,
+//	    ratelimit.WithOpsLimit(50, 10),
+//	    ratelimit.WithReadLimit(20<<20),
+//	    ratelimit.WithWriteLimit(5<<20))
+//
+// Limits use token buckets, so short bursts are allowed while the long-run
+// rate stays at the configured value. Waiting honours the caller's context.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"golang.org/x/time/rate"
+
+	"github.com/nuln/sbox"
+)
+
+// Engine throttles calls to an inner engine.
+type Engine struct {
+	inner sbox.StorageEngine
+	ops   *rate.Limiter // nil means unlimited
+	read  *rate.Limiter
+	write *rate.Limiter
+}
+
+// New returns an Engine throttling inner. Without options it is unlimited.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{inner: inner}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// op waits for an operation token.
+func (e *Engine) op(ctx context.Context) error {
+	if e.ops == nil {
+		return nil
+	}
+	return e.ops.Wait(ctx)
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	if err := e.op(ctx); err != nil {
+		return nil, err
+	}
+	return e.inner.Stat(ctx, path)
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	if err := e.op(ctx); err != nil {
+		return nil, err
+	}
+	r, err := e.inner.Open(ctx, path)
+	if err != nil || e.read == nil {
+		return r, err
+	}
+	return &reader{ReadSeekCloser: r, ctx: ctx, limiter: e.read}, nil
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	if err := e.op(ctx); err != nil {
+		return nil, err
+	}
+	w, err := e.inner.Create(ctx, path)
+	if err != nil || e.write == nil {
+		return w, err
+	}
+	return &writer{Writer: w, Closer: w, ctx: ctx, limiter: e.write}, nil
+}
+
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	if err := e.op(ctx); err != nil {
+		return nil, err
+	}
+	w, err := e.inner.OpenFile(ctx, path, flag, perm)
+	if err != nil || e.write == nil {
+		return w, err
+	}
+	return &seekWriter{writer: writer{Writer: w, Closer: w, ctx: ctx, limiter: e.write}, Seeker: w}, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	if err := e.op(ctx); err != nil {
+		return err
+	}
+	return e.inner.Remove(ctx, path)
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	if err := e.op(ctx); err != nil {
+		return err
+	}
+	return e.inner.Rename(ctx, oldPath, newPath)
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	if err := e.op(ctx); err != nil {
+		return err
+	}
+	return e.inner.MkdirAll(ctx, path)
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	if err := e.op(ctx); err != nil {
+		return nil, err
+	}
+	return e.inner.ReadDir(ctx, path)
+}
+
+// === Extension: Copier ===
+
+// Copy counts as a single operation; no bytes pass through the engine.
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	if err := e.op(ctx); err != nil {
+		return err
+	}
+	return c.Copy(ctx, src, dst)
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	rr, ok := e.inner.(sbox.RangeReader)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	if err := e.op(ctx); err != nil {
+		return nil, err
+	}
+	rc, err := rr.GetRange(ctx, path, offset, length)
+	if err != nil || e.read == nil {
+		return rc, err
+	}
+	return &readCloser{Reader: &limitedReader{r: rc, ctx: ctx, limiter: e.read}, Closer: rc}, nil
+}
+
+// limitedReader charges bytes to the read limiter after they are read, and
+// caps each read at the bucket size.
+type limitedReader struct {
+	r       io.Reader
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.limiter.WaitN(l.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// reader is a throttled ReadSeekCloser.
+type reader struct {
+	sbox.ReadSeekCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return (&limitedReader{r: r.ReadSeekCloser, ctx: r.ctx, limiter: r.limiter}).Read(p)
+}
+
+// writer charges bytes to the write limiter before passing them on.
+type writer struct {
+	io.Writer
+	io.Closer
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.limiter.Burst())]
+		if err := w.limiter.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.Writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// seekWriter is a throttled WriteSeekCloser.
+type seekWriter struct {
+	writer
+	io.Seeker
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier and RangeReader only if the inner engine supports
+// them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy, sbox.CapRangeRead:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.RangeReader        = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.WriteSeekCloser    = (*seekWriter)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
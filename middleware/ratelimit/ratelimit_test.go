@@ -0,0 +1,74 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/ratelimit"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func TestRateLimit_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, ratelimit.New(memory.New(),
+		ratelimit.WithOpsLimit(1e6, 1000),
+		ratelimit.WithReadLimit(1<<30),
+		ratelimit.WithWriteLimit(1<<30)))
+}
+
+func TestRateLimit_Bandwidth(t *testing.T) {
+	engine := ratelimit.New(memory.New(),
+		ratelimit.WithReadLimit(10_000),
+		ratelimit.WithWriteLimit(10_000))
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("x"), 15_000)
+
+	// The first 10 KB fit in the initial burst; the rest waits ~0.5s.
+	start := time.Now()
+	if err := engine.Put(ctx, "f.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("writing 15 KB at 10 KB/s took %v, want >= 400ms", elapsed)
+	}
+
+	start = time.Now()
+	r, err := engine.Open(ctx, "f.bin")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || len(got) != len(data) {
+		t.Fatalf("ReadAll = %d bytes, %v", len(got), err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("reading 15 KB at 10 KB/s took %v, want >= 400ms", elapsed)
+	}
+}
+
+func TestRateLimit_OpsContext(t *testing.T) {
+	engine := ratelimit.New(memory.New(), ratelimit.WithOpsLimit(1, 1))
+	ctx := context.Background()
+
+	if err := engine.MkdirAll(ctx, "a"); err != nil {
+		t.Fatalf("first op: %v", err)
+	}
+
+	// The bucket is empty; the next token arrives in one second, after
+	// the context deadline.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := engine.Stat(ctx, "a")
+	if err == nil {
+		t.Fatal("Stat succeeded, want rate limit wait to fail")
+	}
+	if errors.Is(err, sbox.ErrNotFound) {
+		t.Fatalf("Stat reached the engine: %v", err)
+	}
+}
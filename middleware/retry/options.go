@@ -0,0 +1,35 @@
+package retry
+
+import "time"
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithMaxAttempts sets how many times an operation is tried in total,
+// including the first attempt. Values below 1 are ignored.
+func WithMaxAttempts(n int) Option {
+	return func(e *Engine) {
+		if n >= 1 {
+			e.maxAttempts = n
+		}
+	}
+}
+
+// WithBackoff sets the delay before the first retry and the cap the
+// exponentially growing delay is limited to.
+func WithBackoff(initial, maxDelay time.Duration) Option {
+	return func(e *Engine) {
+		e.initialBackoff = initial
+		e.maxBackoff = maxDelay
+	}
+}
+
+// WithClassifier sets the function deciding which errors are retried. The
+// default is DefaultClassifier.
+func WithClassifier(c Classifier) Option {
+	return func(e *Engine) {
+		if c != nil {
+			e.retryable = c
+		}
+	}
+}
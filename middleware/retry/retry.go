@@ -0,0 +1,318 @@
+// Package retry provides a storage middleware that retries transient
+// failures of any sbox.StorageEngine with exponential backoff and jitter.
+//
+//	engine := retry.New(remote, retry.WithMaxAttempts(5))
+//
+// Every metadata operation and every open is retried. Reads on an open
+// file are resumed by reopening the file at the current offset. Data
+// written through Create or OpenFile is not replayed, since the engine
+// cannot know what the backend kept; use Put with an io.Seeker to get
+// whole-upload retries.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// Defaults used when the corresponding option is not given.
+const (
+	DefaultMaxAttempts    = 4
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+)
+
+// Classifier reports whether err is transient and the operation should be
+// retried.
+type Classifier func(err error) bool
+
+// permanentErrors are outcomes that retrying cannot change.
+var permanentErrors = []error{
+	sbox.ErrNotFound, sbox.ErrExist, sbox.ErrPermission, sbox.ErrInvalid,
+	sbox.ErrIsDir, sbox.ErrNotDir, sbox.ErrClosed, sbox.ErrNotSupported,
+	context.Canceled, context.DeadlineExceeded, io.EOF,
+}
+
+// DefaultClassifier retries every error except the sbox sentinel errors,
+// io.EOF and context cancellation.
+func DefaultClassifier(err error) bool {
+	for _, p := range permanentErrors {
+		if errors.Is(err, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Engine retries failed operations of an inner engine.
+type Engine struct {
+	inner          sbox.StorageEngine
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retryable      Classifier
+}
+
+// New returns an Engine retrying operations on inner.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{
+		inner:          inner,
+		maxAttempts:    DefaultMaxAttempts,
+		initialBackoff: DefaultInitialBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+		retryable:      DefaultClassifier,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// backoff returns the delay before retry number attempt (starting at 1):
+// exponential growth capped at maxBackoff, with jitter over the upper half.
+func (e *Engine) backoff(attempt int) time.Duration {
+	d := e.initialBackoff
+	for i := 1; i < attempt && d < e.maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, e.maxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// do runs fn until it succeeds, fails permanently, the attempts are used
+// up or ctx is done.
+func do[T any](ctx context.Context, e *Engine, fn func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		v, err := fn()
+		if err == nil || attempt >= e.maxAttempts || !e.retryable(err) {
+			return v, err
+		}
+		if werr := e.wait(ctx, attempt); werr != nil {
+			return v, err
+		}
+	}
+}
+
+// wait sleeps for the backoff of attempt or until ctx is done.
+func (e *Engine) wait(ctx context.Context, attempt int) error {
+	t := time.NewTimer(e.backoff(attempt))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// do0 is do for operations without a result.
+func do0(ctx context.Context, e *Engine, fn func() error) error {
+	_, err := do(ctx, e, func() (struct{}, error) { return struct{}{}, fn() })
+	return err
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	return do(ctx, e, func() (*sbox.EntryInfo, error) { return e.inner.Stat(ctx, path) })
+}
+
+// Open retries opening path and returns a reader that resumes at the
+// current offset when a read fails transiently.
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	r, err := do(ctx, e, func() (sbox.ReadSeekCloser, error) { return e.inner.Open(ctx, path) })
+	if err != nil {
+		return nil, err
+	}
+	return &reader{ctx: ctx, engine: e, path: path, r: r}, nil
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	return do(ctx, e, func() (sbox.WriteCloser, error) { return e.inner.Create(ctx, path) })
+}
+
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	return do(ctx, e, func() (sbox.WriteSeekCloser, error) { return e.inner.OpenFile(ctx, path, flag, perm) })
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	return do0(ctx, e, func() error { return e.inner.Remove(ctx, path) })
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	return do0(ctx, e, func() error { return e.inner.Rename(ctx, oldPath, newPath) })
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	return do0(ctx, e, func() error { return e.inner.MkdirAll(ctx, path) })
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return do(ctx, e, func() ([]*sbox.EntryInfo, error) { return e.inner.ReadDir(ctx, path) })
+}
+
+// === Extension: Copier ===
+
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return do0(ctx, e, func() error { return c.Copy(ctx, src, dst) })
+}
+
+// === Extension: Hasher ===
+
+func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (string, error) {
+	h, ok := e.inner.(sbox.Hasher)
+	if !ok {
+		return "", sbox.ErrNotSupported
+	}
+	return do(ctx, e, func() (string, error) { return h.Hash(ctx, path, algorithm) })
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+// Put uploads reader to path. If reader is an io.Seeker, a failed upload is
+// retried from the start; otherwise it is attempted once.
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return e.put(ctx, path, reader)
+	}
+	first := true
+	return do0(ctx, e, func() error {
+		if !first {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		first = false
+		return e.put(ctx, path, reader)
+	})
+}
+
+func (e *Engine) put(ctx context.Context, path string, reader io.Reader) error {
+	if sw, ok := e.inner.(sbox.StreamWriter); ok {
+		return sw.Put(ctx, path, reader)
+	}
+	w, err := e.inner.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	rr, ok := e.inner.(sbox.RangeReader)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	return do(ctx, e, func() (io.ReadCloser, error) { return rr.GetRange(ctx, path, offset, length) })
+}
+
+// reader resumes reading after transient failures by reopening the file
+// and seeking back to the current offset.
+type reader struct {
+	ctx    context.Context
+	engine *Engine
+	path   string
+	r      sbox.ReadSeekCloser
+	pos    int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	// A failed reopen counts as a failed attempt, so it is retried too.
+	for attempt := 1; n == 0 && err != nil && attempt < r.engine.maxAttempts && r.engine.retryable(err); attempt++ {
+		if werr := r.engine.wait(r.ctx, attempt); werr != nil {
+			break
+		}
+		if err = r.reopen(); err == nil {
+			n, err = r.r.Read(p)
+		}
+	}
+	r.pos += int64(n)
+	return n, err
+}
+
+// reopen replaces the underlying reader with a fresh one at r.pos.
+func (r *reader) reopen() error {
+	fresh, err := r.engine.inner.Open(r.ctx, r.path)
+	if err != nil {
+		return err
+	}
+	if _, err := fresh.Seek(r.pos, io.SeekStart); err != nil {
+		_ = fresh.Close()
+		return err
+	}
+	_ = r.r.Close()
+	r.r = fresh
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.r.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier, Hasher and RangeReader only if the inner engine
+// supports them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy, sbox.CapHash, sbox.CapRangeRead:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.Hasher             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.RangeReader        = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
@@ -0,0 +1,191 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/retry"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+var errTransient = errors.New("503 service unavailable")
+
+// flakyEngine fails the next `failures` Stat and Open calls, and the first
+// read of every opened file when failReads is set.
+type flakyEngine struct {
+	*memory.Engine
+	failures  int
+	failReads bool
+	calls     int
+}
+
+func (f *flakyEngine) fail() error {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return errTransient
+	}
+	return nil
+}
+
+func (f *flakyEngine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.Engine.Stat(ctx, path)
+}
+
+func (f *flakyEngine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	r, err := f.Engine.Open(ctx, path)
+	if err != nil || !f.failReads {
+		return r, err
+	}
+	f.failReads = false
+	return &brokenReader{ReadSeekCloser: r, after: 3}, nil
+}
+
+// brokenReader fails once after returning `after` bytes.
+type brokenReader struct {
+	sbox.ReadSeekCloser
+	after int
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.after == 0 {
+		return 0, errTransient
+	}
+	if len(p) > b.after {
+		p = p[:b.after]
+	}
+	n, err := b.ReadSeekCloser.Read(p)
+	b.after -= n
+	return n, err
+}
+
+func fastRetry(opts ...retry.Option) []retry.Option {
+	return append([]retry.Option{retry.WithBackoff(time.Millisecond, 2*time.Millisecond)}, opts...)
+}
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	if err := sbox.PutAtomic(context.Background(), engine, path, strings.NewReader(content)); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestRetry_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, retry.New(memory.New(), fastRetry()...))
+}
+
+func TestRetry_TransientErrors(t *testing.T) {
+	flaky := &flakyEngine{Engine: memory.New()}
+	engine := retry.New(flaky, fastRetry(retry.WithMaxAttempts(3))...)
+	ctx := context.Background()
+	writeFile(t, flaky.Engine, "f.txt", "data")
+
+	flaky.failures, flaky.calls = 2, 0
+	if _, err := engine.Stat(ctx, "f.txt"); err != nil {
+		t.Fatalf("Stat after 2 failures: %v", err)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("calls = %d, want 3", flaky.calls)
+	}
+
+	flaky.failures, flaky.calls = 5, 0
+	if _, err := engine.Stat(ctx, "f.txt"); !errors.Is(err, errTransient) {
+		t.Errorf("Stat with attempts exhausted = %v, want %v", err, errTransient)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("calls = %d, want 3", flaky.calls)
+	}
+	flaky.failures = 0
+
+	// Permanent errors are not retried.
+	flaky.calls = 0
+	if _, err := engine.Stat(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat missing = %v", err)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("calls for permanent error = %d, want 1", flaky.calls)
+	}
+}
+
+func TestRetry_ResumeRead(t *testing.T) {
+	flaky := &flakyEngine{Engine: memory.New()}
+	engine := retry.New(flaky, fastRetry()...)
+	writeFile(t, flaky.Engine, "f.txt", "0123456789")
+
+	flaky.failReads = true
+	r, err := engine.Open(context.Background(), "f.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(data) != "0123456789" {
+		t.Errorf("data = %q", data)
+	}
+}
+
+func TestRetry_ContextCancel(t *testing.T) {
+	flaky := &flakyEngine{Engine: memory.New(), failures: 100}
+	engine := retry.New(flaky, retry.WithMaxAttempts(100), retry.WithBackoff(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := engine.Stat(ctx, "f.txt"); !errors.Is(err, errTransient) {
+		t.Errorf("Stat = %v, want last error %v", err, errTransient)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stat took %v after context deadline", elapsed)
+	}
+}
+
+func TestRetry_Classifier(t *testing.T) {
+	flaky := &flakyEngine{Engine: memory.New(), failures: 1}
+	never := func(error) bool { return false }
+	engine := retry.New(flaky, fastRetry(retry.WithClassifier(never))...)
+
+	if _, err := engine.Stat(context.Background(), "f.txt"); !errors.Is(err, errTransient) {
+		t.Errorf("Stat = %v, want %v", err, errTransient)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("calls = %d, want 1", flaky.calls)
+	}
+}
+
+func TestRetry_FlakyEngine(t *testing.T) {
+	flaky := sboxtest.NewFlakyEngine(memory.New(), sboxtest.Policy{Seed: 7, ErrorRate: 0.3, ReadErrorRate: 0.3})
+	engine := retry.New(flaky, retry.WithMaxAttempts(10), retry.WithBackoff(0, 0))
+	ctx := context.Background()
+	for range 20 {
+		if err := engine.Put(ctx, "f.txt", strings.NewReader("resilient")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		r, err := engine.Open(ctx, "f.txt")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil || string(data) != "resilient" {
+			t.Fatalf("ReadAll = %q, %v", data, err)
+		}
+	}
+	if flaky.Faults() == 0 {
+		t.Error("no faults injected")
+	}
+}
@@ -0,0 +1,31 @@
+package timeout
+
+import "time"
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithMetadataTimeout bounds Stat, Remove, Rename, MkdirAll and ReadDir,
+// and opening a file for reading or writing, to d.
+func WithMetadataTimeout(d time.Duration) Option {
+	return func(e *Engine) {
+		e.metadata = d
+	}
+}
+
+// WithTransferTimeout bounds a whole data transfer to d: from opening a
+// file until it is closed, and server-side copies.
+func WithTransferTimeout(d time.Duration) Option {
+	return func(e *Engine) {
+		e.transfer = d
+	}
+}
+
+// WithIdleTimeout fails a transfer when a single read or write on an open
+// file makes no progress for d. The timer restarts with every call, so
+// slow but steady transfers are not affected.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(e *Engine) {
+		e.idle = d
+	}
+}
@@ -0,0 +1,437 @@
+// Package timeout provides a storage middleware that bounds the time an
+// inner engine may take, so a hung remote fails calls instead of wedging
+// the goroutines that make them.
+//
+//	engine := timeout.New(remote,
+//	    timeout.WithMetadataTimeout(10*time.Second),
+//	    timeout.WithTransferTimeout(time.Hour),
+//	    timeout.WithIdleTimeout(time.Minute))
+//
+// Metadata operations, whole transfers and single reads or writes on open
+// files have separate limits; zero disables a limit. The inner engine
+// receives a context that expires with the limit, and calls that ignore it
+// are abandoned: they keep running in the background while the caller gets
+// an error wrapping context.DeadlineExceeded. Files opened by abandoned
+// calls are closed when the calls return, and a file whose read or write
+// timed out fails every later call.
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// Engine applies timeouts to calls to an inner engine.
+type Engine struct {
+	inner    sbox.StorageEngine
+	metadata time.Duration // zero means no limit
+	transfer time.Duration
+	idle     time.Duration
+}
+
+// New returns an Engine applying timeouts to inner. Without options it
+// applies none.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{inner: inner}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// run calls f in a goroutine and waits until it returns or ctx is done. In
+// the latter case done is false, and discard, if not nil, receives f's
+// result when it eventually returns without an error.
+func run[T any](ctx context.Context, f func() (T, error), discard func(T)) (v T, done bool, err error) {
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := f()
+		ch <- result{v, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.v, true, r.err
+	case <-ctx.Done():
+		if discard != nil {
+			go func() {
+				if r := <-ch; r.err == nil {
+					discard(r.v)
+				}
+			}()
+		}
+		return v, false, ctx.Err()
+	}
+}
+
+// bounded calls f with a context that expires after d, returning an error
+// once it has expired even if f ignores it.
+func bounded[T any](ctx context.Context, d time.Duration, op, path string, f func(context.Context) (T, error), discard func(T)) (T, error) {
+	if d <= 0 {
+		return f(ctx)
+	}
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	v, _, err := run(tctx, func() (T, error) { return f(tctx) }, discard)
+	if err != nil && ctx.Err() == nil && tctx.Err() != nil {
+		err = fmt.Errorf("sbox/timeout: %s %s: no result within %v: %w", op, path, d, context.DeadlineExceeded)
+	}
+	return v, err
+}
+
+// none adapts a function returning only an error to bounded.
+func none(f func(ctx context.Context) error) func(context.Context) (struct{}, error) {
+	return func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, f(ctx)
+	}
+}
+
+func closeFile[T io.Closer](f T) {
+	_ = f.Close()
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	return bounded(ctx, e.metadata, "stat", path, func(ctx context.Context) (*sbox.EntryInfo, error) {
+		return e.inner.Stat(ctx, path)
+	}, nil)
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	s := e.newStream(ctx, "read", path)
+	if s == nil {
+		return bounded(ctx, e.metadata, "open", path, func(ctx context.Context) (sbox.ReadSeekCloser, error) {
+			return e.inner.Open(ctx, path)
+		}, closeFile)
+	}
+	r, err := bounded(ctx, e.metadata, "open", path, func(context.Context) (sbox.ReadSeekCloser, error) {
+		return e.inner.Open(s.ctx, path)
+	}, closeFile)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.r, s.seeker, s.closer = r, r, r
+	return &reader{s}, nil
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	s := e.newStream(ctx, "write", path)
+	if s == nil {
+		return bounded(ctx, e.metadata, "create", path, func(ctx context.Context) (sbox.WriteCloser, error) {
+			return e.inner.Create(ctx, path)
+		}, closeFile)
+	}
+	w, err := bounded(ctx, e.metadata, "create", path, func(context.Context) (sbox.WriteCloser, error) {
+		return e.inner.Create(s.ctx, path)
+	}, closeFile)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.w, s.closer = w, w
+	return &writer{s}, nil
+}
+
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	s := e.newStream(ctx, "write", path)
+	if s == nil {
+		return bounded(ctx, e.metadata, "open", path, func(ctx context.Context) (sbox.WriteSeekCloser, error) {
+			return e.inner.OpenFile(ctx, path, flag, perm)
+		}, closeFile)
+	}
+	w, err := bounded(ctx, e.metadata, "open", path, func(context.Context) (sbox.WriteSeekCloser, error) {
+		return e.inner.OpenFile(s.ctx, path, flag, perm)
+	}, closeFile)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.w, s.seeker, s.closer = w, w, w
+	return &seekWriter{writer{s}}, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	_, err := bounded(ctx, e.metadata, "remove", path, none(func(ctx context.Context) error {
+		return e.inner.Remove(ctx, path)
+	}), nil)
+	return err
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	_, err := bounded(ctx, e.metadata, "rename", oldPath, none(func(ctx context.Context) error {
+		return e.inner.Rename(ctx, oldPath, newPath)
+	}), nil)
+	return err
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	_, err := bounded(ctx, e.metadata, "mkdir", path, none(func(ctx context.Context) error {
+		return e.inner.MkdirAll(ctx, path)
+	}), nil)
+	return err
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return bounded(ctx, e.metadata, "readdir", path, func(ctx context.Context) ([]*sbox.EntryInfo, error) {
+		return e.inner.ReadDir(ctx, path)
+	}, nil)
+}
+
+// === Extension: Copier ===
+
+// Copy is a transfer and bounded by the transfer timeout.
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	c, ok := e.inner.(sbox.Copier)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	_, err := bounded(ctx, e.transfer, "copy", src, none(func(ctx context.Context) error {
+		return c.Copy(ctx, src, dst)
+	}), nil)
+	return err
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.Open(ctx, path)
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	rr, ok := e.inner.(sbox.RangeReader)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	s := e.newStream(ctx, "read", path)
+	if s == nil {
+		return bounded(ctx, e.metadata, "open", path, func(ctx context.Context) (io.ReadCloser, error) {
+			return rr.GetRange(ctx, path, offset, length)
+		}, closeFile)
+	}
+	rc, err := bounded(ctx, e.metadata, "open", path, func(context.Context) (io.ReadCloser, error) {
+		return rr.GetRange(s.ctx, path, offset, length)
+	}, closeFile)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.r, s.closer = rc, rc
+	return &readCloser{s}, nil
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports Copier and RangeReader only if the inner engine supports
+// them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapCopy, sbox.CapRangeRead:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// stream bounds the calls on an open file. Its context expires with the
+// transfer timeout and is passed to the inner engine when the file is
+// opened.
+type stream struct {
+	parent   context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	op, path string
+	transfer time.Duration
+	idle     time.Duration
+
+	r      io.Reader
+	w      io.Writer
+	seeker io.Seeker
+	closer io.Closer
+
+	buf []byte // owned by the call in flight
+	err error  // set once a call has timed out
+}
+
+// newStream returns a stream for a file opened for op, or nil if transfers
+// have no limits.
+func (e *Engine) newStream(ctx context.Context, op, path string) *stream {
+	if e.transfer <= 0 && e.idle <= 0 {
+		return nil
+	}
+	s := &stream{parent: ctx, op: op, path: path, transfer: e.transfer, idle: e.idle}
+	if e.transfer > 0 {
+		s.ctx, s.cancel = context.WithTimeout(ctx, e.transfer)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(ctx)
+	}
+	return s
+}
+
+// timedOut records and returns the error for a call that did not return in
+// time.
+func (s *stream) timedOut() error {
+	switch {
+	case s.parent.Err() != nil:
+		s.err = s.parent.Err()
+	case s.ctx.Err() != nil:
+		s.err = fmt.Errorf("sbox/timeout: %s %s: transfer exceeded %v: %w", s.op, s.path, s.transfer, context.DeadlineExceeded)
+	default:
+		s.err = fmt.Errorf("sbox/timeout: %s %s: no progress for %v: %w", s.op, s.path, s.idle, context.DeadlineExceeded)
+	}
+	s.cancel()
+	return s.err
+}
+
+// io calls f on a private copy of p, waiting at most for the idle timeout.
+// The copy stays with f if it is abandoned, so a late result cannot touch
+// the caller's buffer.
+func (s *stream) io(p []byte, write bool, f func([]byte) (int, error)) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.ctx.Err() != nil {
+		return 0, s.timedOut()
+	}
+	if cap(s.buf) < len(p) {
+		s.buf = make([]byte, len(p))
+	}
+	buf := s.buf[:len(p)]
+	if write {
+		copy(buf, p)
+	}
+	ctx := s.ctx
+	if s.idle > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(s.ctx, s.idle)
+		defer cancel()
+	}
+	n, done, err := run(ctx, func() (int, error) { return f(buf) }, nil)
+	if !done {
+		s.buf = nil
+		return 0, s.timedOut()
+	}
+	if !write {
+		copy(p, buf[:n])
+	}
+	return n, err
+}
+
+func (s *stream) Seek(offset int64, whence int) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.seeker.Seek(offset, whence)
+}
+
+// Close waits for the inner file to close until the transfer timeout. After
+// a timeout it only starts closing the file and returns the timeout error.
+func (s *stream) Close() error {
+	defer s.cancel()
+	if s.err != nil {
+		go func() { _ = s.closer.Close() }()
+		return s.err
+	}
+	_, done, err := run(s.ctx, func() (struct{}, error) { return struct{}{}, s.closer.Close() }, nil)
+	if !done {
+		return s.timedOut()
+	}
+	return err
+}
+
+// reader is a ReadSeekCloser with timeouts.
+type reader struct {
+	s *stream
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.s.io(p, false, r.s.r.Read)
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	return r.s.Seek(offset, whence)
+}
+
+func (r *reader) Close() error {
+	return r.s.Close()
+}
+
+// readCloser is a ReadCloser with timeouts.
+type readCloser struct {
+	s *stream
+}
+
+func (r *readCloser) Read(p []byte) (int, error) {
+	return r.s.io(p, false, r.s.r.Read)
+}
+
+func (r *readCloser) Close() error {
+	return r.s.Close()
+}
+
+// writer is a WriteCloser with timeouts.
+type writer struct {
+	s *stream
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.s.io(p, true, w.s.w.Write)
+}
+
+func (w *writer) Close() error {
+	return w.s.Close()
+}
+
+// seekWriter is a WriteSeekCloser with timeouts.
+type seekWriter struct {
+	writer
+}
+
+func (w *seekWriter) Seek(offset int64, whence int) (int64, error) {
+	return w.s.Seek(offset, whence)
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.StreamReader       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.RangeReader        = (*Engine)(nil)
+	_ io.Closer               = (*Engine)(nil)
+	_ sbox.ReadSeekCloser     = (*reader)(nil)
+	_ sbox.WriteSeekCloser    = (*seekWriter)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+)
@@ -0,0 +1,133 @@
+package timeout_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/timeout"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+// hung blocks Stat and reads until release is closed, ignoring the context
+// like a wedged remote would.
+type hung struct {
+	sbox.StorageEngine
+	release chan struct{}
+}
+
+func (h *hung) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	<-h.release
+	return h.StorageEngine.Stat(ctx, path)
+}
+
+func (h *hung) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	r, err := h.StorageEngine.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &hungReader{ReadSeekCloser: r, release: h.release}, nil
+}
+
+type hungReader struct {
+	sbox.ReadSeekCloser
+	release chan struct{}
+	reads   int
+}
+
+// Read returns one byte at a time, hanging after the first two.
+func (r *hungReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads > 2 {
+		<-r.release
+	}
+	return r.ReadSeekCloser.Read(p[:1])
+}
+
+func newHung(t *testing.T) *hung {
+	t.Helper()
+	h := &hung{StorageEngine: memory.New(), release: make(chan struct{})}
+	t.Cleanup(func() { close(h.release) })
+	if err := sbox.PutAtomic(context.Background(), h.StorageEngine, "f.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	return h
+}
+
+func TestTimeout_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, timeout.New(memory.New(),
+		timeout.WithMetadataTimeout(time.Minute),
+		timeout.WithTransferTimeout(time.Minute),
+		timeout.WithIdleTimeout(time.Minute)))
+}
+
+func TestTimeout_Metadata(t *testing.T) {
+	engine := timeout.New(newHung(t), timeout.WithMetadataTimeout(50*time.Millisecond))
+	start := time.Now()
+	_, err := engine.Stat(context.Background(), "f.txt")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stat = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stat returned after %v", elapsed)
+	}
+}
+
+func TestTimeout_Idle(t *testing.T) {
+	engine := timeout.New(newHung(t), timeout.WithIdleTimeout(50*time.Millisecond))
+	r, err := engine.Open(context.Background(), "f.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	buf := make([]byte, 5)
+	for i := 0; i < 2; i++ {
+		if n, err := r.Read(buf); n != 1 || err != nil {
+			t.Fatalf("Read %d = %d, %v", i, n, err)
+		}
+	}
+	if _, err := r.Read(buf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("hung Read = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := r.Read(buf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Read after timeout = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := r.Close(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close after timeout = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTimeout_Transfer(t *testing.T) {
+	engine := timeout.New(memory.New(), timeout.WithTransferTimeout(50*time.Millisecond))
+	ctx := context.Background()
+	w, err := engine.Create(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := w.Write([]byte("b")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Write after transfer timeout = %v, want %v", err, context.DeadlineExceeded)
+	}
+	_ = w.Close()
+
+	// Reads that keep making progress are not affected by the idle timeout.
+	engine = timeout.New(memory.New(), timeout.WithIdleTimeout(time.Second))
+	if err := engine.Put(ctx, "g.txt", strings.NewReader("data")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, err := engine.Get(ctx, "g.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	if data, err := io.ReadAll(r); err != nil || string(data) != "data" {
+		t.Errorf("ReadAll = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,29 @@
+package verify
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithAlgorithm sets the hash computed for writes that carry no checksum,
+// e.g. "md5" when the backend reports MD5 hashes. The default is "sha256".
+func WithAlgorithm(algorithm string) Option {
+	return func(e *Engine) {
+		e.algorithm = algorithm
+	}
+}
+
+// WithAllWrites verifies every write, not only those asking for it with
+// sbox.WithVerification or sbox.WithChecksum.
+func WithAllWrites() Option {
+	return func(e *Engine) {
+		e.all = true
+	}
+}
+
+// WithReadBack hashes stored files by reading them back when the inner
+// engine cannot report their hash. Without it, such writes are only
+// checked against the checksum given with sbox.WithChecksum.
+func WithReadBack() Option {
+	return func(e *Engine) {
+		e.readBack = true
+	}
+}
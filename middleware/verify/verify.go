@@ -0,0 +1,316 @@
+// Package verify provides a storage middleware that checks the integrity
+// of writes end to end, for any sbox.StorageEngine.
+//
+//	engine := verify.New(inner)
+//	w, err := sbox.CreateWith(ctx, engine, "backup.tar", sbox.WithChecksum("sha256", digest))
+//
+// Writes asking for it with sbox.WithChecksum or sbox.WithVerification
+// are hashed while they stream to the inner engine. On Close, the hash is
+// compared with the expected checksum, if given, and then with the hash
+// the inner engine reports for the stored file, where it has one. A write
+// that does not match fails with an error wrapping
+// sbox.ErrChecksumMismatch and leaves no file behind: atomic writes are
+// aborted before the file is published, and other files are removed.
+package verify
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/nuln/sbox"
+)
+
+// Engine verifies the writes to an inner engine.
+type Engine struct {
+	inner     sbox.StorageEngine
+	algorithm string
+	all       bool
+	readBack  bool
+}
+
+// New returns an Engine verifying the writes to inner.
+func New(inner sbox.StorageEngine, opts ...Option) *Engine {
+	e := &Engine{inner: inner, algorithm: "sha256"}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Inner returns the wrapped engine.
+func (e *Engine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *Engine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// newWriter returns a writer verifying w, or nil if the write does not ask
+// for verification. whole reports whether w writes the whole file, so that
+// its stored hash can be checked.
+func (e *Engine) newWriter(ctx context.Context, path string, whole bool) (*writer, error) {
+	opts := sbox.FileOptionsFromContext(ctx)
+	if !e.all && !opts.Verify && opts.Checksum == "" {
+		return nil, nil
+	}
+	algorithm := e.algorithm
+	if opts.Checksum != "" {
+		algorithm = opts.ChecksumAlgorithm
+	}
+	h, err := sbox.NewHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{
+		engine:    e,
+		ctx:       ctx,
+		path:      path,
+		h:         h,
+		algorithm: algorithm,
+		want:      opts.Checksum,
+		whole:     whole,
+	}, nil
+}
+
+// storedHash returns the hash of the file at path as the inner engine
+// reports it, or an error wrapping sbox.ErrNotSupported if it cannot.
+func (e *Engine) storedHash(ctx context.Context, path, algorithm string) (string, error) {
+	if e.readBack {
+		return sbox.Hashed(e.inner).Hash(ctx, path, algorithm)
+	}
+	h, ok := e.inner.(sbox.Hasher)
+	if !ok || !sbox.Supports(e.inner, sbox.CapHash) {
+		return "", sbox.ErrNotSupported
+	}
+	return h.Hash(ctx, path, algorithm)
+}
+
+func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	return e.inner.Stat(ctx, path)
+}
+
+func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	return e.inner.Open(ctx, path)
+}
+
+func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	v, err := e.newWriter(ctx, path, true)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return e.inner.Create(ctx, path)
+	}
+	w, err := e.inner.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	v.w = w
+	return v, nil
+}
+
+// OpenFile verifies the data written through the returned writer. Its
+// stored hash is checked only with os.O_TRUNC, when the writer writes the
+// whole file. Verified writers cannot seek.
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	v, err := e.newWriter(ctx, path, flag&os.O_TRUNC != 0)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return e.inner.OpenFile(ctx, path, flag, perm)
+	}
+	w, err := e.inner.OpenFile(ctx, path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	v.w = w
+	return v, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, path string) error {
+	return e.inner.Remove(ctx, path)
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+	return e.inner.Rename(ctx, oldPath, newPath)
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+	return e.inner.MkdirAll(ctx, path)
+}
+
+func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	return e.inner.ReadDir(ctx, path)
+}
+
+// === Extension: AtomicWriter ===
+
+// CreateAtomic verifies writes to an atomic writer of the inner engine,
+// which are aborted instead of published when they do not match their
+// checksum. It returns ErrNotSupported if the inner engine is not an
+// AtomicWriter.
+func (e *Engine) CreateAtomic(ctx context.Context, path string) (sbox.AtomicWriteCloser, error) {
+	aw, ok := e.inner.(sbox.AtomicWriter)
+	if !ok {
+		return nil, sbox.ErrNotSupported
+	}
+	v, err := e.newWriter(ctx, path, true)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return aw.CreateAtomic(ctx, path)
+	}
+	w, err := aw.CreateAtomic(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	v.w, v.abort = w, w.Abort
+	return &atomicWriter{v}, nil
+}
+
+// === Extension: StreamWriter ===
+
+func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
+	w, err := e.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// === Extension: Hasher ===
+
+func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (string, error) {
+	h, ok := e.inner.(sbox.Hasher)
+	if !ok {
+		return "", sbox.ErrNotSupported
+	}
+	return h.Hash(ctx, path, algorithm)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports AtomicWriter and Hasher only if the inner engine
+// supports them.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapAtomicWrite, sbox.CapHash:
+		return sbox.Supports(e.inner, c)
+	}
+	return sbox.Implements(e, c)
+}
+
+// writer hashes the data written to an inner writer and verifies it on
+// Close.
+type writer struct {
+	engine *Engine
+	ctx    context.Context
+	path   string
+	w      io.WriteCloser
+	abort  func() error // aborts w, nil if w is not atomic
+
+	h         hash.Hash
+	algorithm string
+	want      string // expected hex digest, if given
+	whole     bool   // w writes the whole file
+	closed    bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	n, err := w.w.Write(p)
+	w.h.Write(p[:n])
+	return n, err
+}
+
+// Seek only reports the offset: data written elsewhere than after the
+// data already written cannot be verified.
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	s, ok := w.w.(io.Seeker)
+	if !ok || offset != 0 || whence != io.SeekCurrent {
+		return 0, sbox.ErrNotSupported
+	}
+	return s.Seek(offset, whence)
+}
+
+// Close checks the data against the expected checksum before committing
+// it, and the stored file against the data afterwards.
+func (w *writer) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+
+	sum := hex.EncodeToString(w.h.Sum(nil))
+	if w.want != "" && sum != w.want {
+		if w.abort != nil {
+			_ = w.abort()
+		} else {
+			_ = w.w.Close()
+			_ = w.engine.inner.Remove(w.ctx, w.path)
+		}
+		return w.mismatch("data written", sum)
+	}
+	if err := w.w.Close(); err != nil || !w.whole {
+		return err
+	}
+
+	stored, err := w.engine.storedHash(w.ctx, w.path, w.algorithm)
+	switch {
+	case errors.Is(err, sbox.ErrNotSupported):
+		return nil
+	case err != nil:
+		return fmt.Errorf("sbox/verify: %s: hash of the stored file: %w", w.path, err)
+	case stored != sum:
+		_ = w.engine.inner.Remove(w.ctx, w.path)
+		return w.mismatch("stored file", stored)
+	}
+	return nil
+}
+
+func (w *writer) mismatch(what, got string) error {
+	want := w.want
+	if want == "" {
+		want = hex.EncodeToString(w.h.Sum(nil))
+	}
+	return fmt.Errorf("sbox/verify: %s: %s has %s %s, want %s: %w", w.path, what, w.algorithm, got, want, sbox.ErrChecksumMismatch)
+}
+
+// atomicWriter is a verifying sbox.AtomicWriteCloser.
+type atomicWriter struct {
+	*writer
+}
+
+func (w *atomicWriter) Abort() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	return w.abort()
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.AtomicWriter       = (*Engine)(nil)
+	_ sbox.StreamWriter       = (*Engine)(nil)
+	_ sbox.Hasher             = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
+	_ sbox.WriteSeekCloser    = (*writer)(nil)
+	_ sbox.AtomicWriteCloser  = (*atomicWriter)(nil)
+)
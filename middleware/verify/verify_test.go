@@ -0,0 +1,153 @@
+package verify_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/middleware/verify"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func read(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// corrupting stores a different first byte than written, and has no
+// native hash.
+type corrupting struct {
+	sbox.StorageEngine
+}
+
+func (c corrupting) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	w, err := c.StorageEngine.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &corruptWriter{WriteCloser: w}, nil
+}
+
+type corruptWriter struct {
+	sbox.WriteCloser
+	started bool
+}
+
+func (w *corruptWriter) Write(p []byte) (int, error) {
+	if !w.started && len(p) > 0 {
+		w.started = true
+		q := append([]byte{p[0] ^ 0xff}, p[1:]...)
+		return w.WriteCloser.Write(q)
+	}
+	return w.WriteCloser.Write(p)
+}
+
+func TestVerify_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, verify.New(memory.New(), verify.WithAllWrites()))
+}
+
+func TestVerify_Checksum(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	engine := verify.New(inner)
+
+	good := sbox.WithFileOptions(ctx, sbox.WithChecksum("sha256", sha("hello")))
+	if err := engine.Put(good, "a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put with a matching checksum: %v", err)
+	}
+	if got := read(t, engine, "a.txt"); got != "hello" {
+		t.Errorf("content = %q", got)
+	}
+
+	// Atomic writes are aborted: the previous content stays.
+	bad := sbox.WithFileOptions(ctx, sbox.WithChecksum("sha256", sha("other")))
+	if err := sbox.PutAtomic(bad, engine, "a.txt", strings.NewReader("hello!")); !errors.Is(err, sbox.ErrChecksumMismatch) {
+		t.Fatalf("PutAtomic with a wrong checksum = %v, want %v", err, sbox.ErrChecksumMismatch)
+	}
+	if got := read(t, engine, "a.txt"); got != "hello" {
+		t.Errorf("content after an aborted write = %q", got)
+	}
+
+	// Other writes are removed.
+	w, err := engine.Create(bad, "b.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, _ = io.WriteString(w, "hello")
+	if err := w.Close(); !errors.Is(err, sbox.ErrChecksumMismatch) {
+		t.Fatalf("Close with a wrong checksum = %v, want %v", err, sbox.ErrChecksumMismatch)
+	}
+	if _, err := inner.Stat(ctx, "b.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat after a mismatch = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	unknown := sbox.WithFileOptions(ctx, sbox.WithChecksum("crc32c", "00000000"))
+	if _, err := engine.Create(unknown, "c.txt"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Create with an unknown algorithm = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+func TestVerify_StoredHash(t *testing.T) {
+	ctx := sbox.WithFileOptions(context.Background(), sbox.WithVerification())
+	inner := corrupting{memory.New()}
+
+	// Without a native hash, corruption goes unnoticed...
+	if err := verify.New(inner).Put(ctx, "a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put without a stored hash: %v", err)
+	}
+
+	// ...unless the file is read back.
+	engine := verify.New(inner, verify.WithReadBack())
+	if err := engine.Put(ctx, "a.txt", strings.NewReader("hello")); !errors.Is(err, sbox.ErrChecksumMismatch) {
+		t.Fatalf("Put of a corrupted file = %v, want %v", err, sbox.ErrChecksumMismatch)
+	}
+	if _, err := inner.Stat(ctx, "a.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat after a mismatch = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	// The native hash of an intact file matches.
+	engine = verify.New(memory.New(), verify.WithAlgorithm("md5"))
+	if err := engine.Put(ctx, "a.txt", strings.NewReader("hello")); err != nil {
+		t.Errorf("Put with a native hash: %v", err)
+	}
+}
+
+func TestVerify_Seek(t *testing.T) {
+	ctx := sbox.WithFileOptions(context.Background(), sbox.WithVerification())
+	engine := verify.New(memory.New())
+	w, err := engine.OpenFile(ctx, "a.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if _, err := io.WriteString(w, "abc"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if n, err := w.Seek(0, io.SeekCurrent); err != nil || n != 3 {
+		t.Errorf("Seek(0, SeekCurrent) = %d, %v", n, err)
+	}
+	if _, err := w.Seek(0, io.SeekStart); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Seek(0, SeekStart) = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
@@ -0,0 +1,153 @@
+package sbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"strconv"
+)
+
+// MoveChunkSize is the size of the pieces in which Move appends large
+// files to the destination. Files of at least this size are copied
+// resumably when the destination supports [Appender].
+const MoveChunkSize = 16 << 20
+
+// Move moves the file or directory tree at srcPath on src to dstPath on
+// dst, which may be a different engine:
+//
+//   - If src and dst are the same instance, the tree is renamed.
+//   - If that is not supported, the engine's [Copier] copies it before the
+//     source is removed.
+//   - Otherwise every file is streamed to dst and removed from src once it
+//     has been copied, along with its modification time, metadata and
+//     permission bits where dst supports them. The source directories are
+//     removed at the end.
+//
+// A streamed move that fails can be resumed by calling Move again: files
+// already moved are gone from src, and large files are appended to dst in
+// chunks of MoveChunkSize, continuing after the data written by the
+// previous attempt. Partial files are named ".<name>.sbox-move-<id>" next
+// to their destination and renamed into place when complete; the id
+// changes with the source file's size and modification time, so a source
+// modified between attempts is copied again from the start.
+func Move(ctx context.Context, src StorageEngine, srcPath string, dst StorageEngine, dstPath string) error {
+	if sameEngine(src, dst) {
+		err := src.Rename(ctx, srcPath, dstPath)
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+		if c, ok := src.(Copier); ok && Supports(src, CapCopy) {
+			err := c.Copy(ctx, srcPath, dstPath)
+			if err == nil {
+				return src.Remove(ctx, srcPath)
+			}
+			if !errors.Is(err, ErrNotSupported) {
+				return err
+			}
+		}
+	}
+
+	info, err := src.Stat(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return moveFile(ctx, src, info, dst, dstPath)
+	}
+	err = Walk(ctx, src, srcPath, func(p string, info *EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dp := copyTreeDst(srcPath, dstPath, p)
+		if info.IsDir {
+			return dst.MkdirAll(ctx, dp)
+		}
+		if err := moveFile(ctx, src, info, dst, dp); err != nil {
+			return fmt.Errorf("sbox: move %s: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return src.Remove(ctx, srcPath)
+}
+
+// sameEngine reports whether a and b are the same instance. Engines of
+// types that cannot be compared are never the same.
+func sameEngine(a, b StorageEngine) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	return ta == tb && ta != nil && ta.Comparable() && a == b
+}
+
+// moveFile copies a single file to dst and removes it from src.
+func moveFile(ctx context.Context, src StorageEngine, info *EntryInfo, dst StorageEngine, dstPath string) error {
+	var err error
+	if a, ok := dst.(Appender); ok && info.Size >= MoveChunkSize && Supports(dst, CapAppend) {
+		err = moveResumable(ctx, src, info, dst, a, dstPath)
+	} else {
+		err = copyTreeFile(ctx, src, info, dst, dstPath, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return src.Remove(ctx, info.Path)
+}
+
+// moveResumable appends the file to a partial file on dst, continuing
+// after any data written by a previous attempt, and renames it into place.
+func moveResumable(ctx context.Context, src StorageEngine, info *EntryInfo, dst StorageEngine, a Appender, dstPath string) error {
+	partial := movePartialPath(info, dstPath)
+	var offset int64
+	if pi, err := dst.Stat(ctx, partial); err == nil && !pi.IsDir && pi.Size <= info.Size {
+		offset = pi.Size
+	} else if err == nil {
+		// Longer than the source, so not written by Move: start over.
+		if err := dst.Remove(ctx, partial); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if offset < info.Size {
+		rc, err := Ranged(src).GetRange(ctx, info.Path, offset, -1)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rc.Close() }()
+		for offset < info.Size {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := a.Append(ctx, partial, io.LimitReader(rc, MoveChunkSize))
+			offset += n
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return fmt.Errorf("sbox: move %s: source ended at %d of %d bytes: %w", info.Path, offset, info.Size, io.ErrUnexpectedEOF)
+			}
+		}
+	}
+	if err := dst.Rename(ctx, partial, dstPath); err != nil {
+		return err
+	}
+	return copyAttributes(ctx, info, dst, dstPath)
+}
+
+// movePartialPath returns the name of the partial file for a move of the
+// file described by info to dstPath.
+func movePartialPath(info *EntryInfo, dstPath string) string {
+	sum := sha256.Sum256([]byte(info.Path + "\x00" + strconv.FormatInt(info.Size, 10) + "\x00" + strconv.FormatInt(info.ModTime.UnixNano(), 10)))
+	dir, name := path.Split(cleanRoutePath(dstPath))
+	return path.Join(dir, "."+name+".sbox-move-"+hex.EncodeToString(sum[:8]))
+}
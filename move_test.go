@@ -0,0 +1,85 @@
+package sbox_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/local"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestMove_Engines(t *testing.T) {
+	ctx := context.Background()
+	src, dst := memory.New(), memory.New()
+	routerWrite(t, src, "photos/a.jpg", "a")
+	routerWrite(t, src, "photos/2024/b.jpg", "b")
+
+	if err := sbox.Move(ctx, src, "photos", dst, "backup/photos"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if got := routerRead(t, dst, "backup/photos/2024/b.jpg"); got != "b" {
+		t.Errorf("moved b.jpg = %q", got)
+	}
+	if _, err := src.Stat(ctx, "photos"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("source still exists: %v", err)
+	}
+
+	// Within one engine the tree is renamed.
+	if err := sbox.Move(ctx, dst, "backup/photos/a.jpg", dst, "a.jpg"); err != nil {
+		t.Fatalf("Move within engine: %v", err)
+	}
+	if got := routerRead(t, dst, "a.jpg"); got != "a" {
+		t.Errorf("renamed a.jpg = %q", got)
+	}
+}
+
+// flakyAppender fails every Append after the first few.
+type flakyAppender struct {
+	*local.Engine
+	left int
+}
+
+func (f *flakyAppender) Append(ctx context.Context, path string, r io.Reader) (int64, error) {
+	if f.left == 0 {
+		return 0, errors.New("connection reset")
+	}
+	f.left--
+	return f.Engine.Append(ctx, path, r)
+}
+
+func TestMove_Resume(t *testing.T) {
+	ctx := context.Background()
+	src := memory.New()
+	data := bytes.Repeat([]byte("0123456789abcdef"), sbox.MoveChunkSize/16*2+1)
+	routerWrite(t, src, "big.bin", string(data))
+	inner, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	dst := &flakyAppender{Engine: inner, left: 1}
+
+	if err := sbox.Move(ctx, src, "big.bin", dst, "big.bin"); err == nil {
+		t.Fatal("interrupted Move succeeded")
+	}
+	if _, err := dst.Stat(ctx, "big.bin"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("partial file visible: %v", err)
+	}
+
+	dst.left = 2
+	if err := sbox.Move(ctx, src, "big.bin", dst, "big.bin"); err != nil {
+		t.Fatalf("resumed Move: %v", err)
+	}
+	if got := routerRead(t, dst, "big.bin"); got != string(data) {
+		t.Errorf("moved %d bytes, want %d", len(got), len(data))
+	}
+	if entries, _ := dst.ReadDir(ctx, ""); len(entries) != 1 {
+		t.Errorf("destination has %d entries, want 1", len(entries))
+	}
+	if _, err := src.Stat(ctx, "big.bin"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("source still exists: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/nuln/sbox"
+)
+
+// file is an open file of a Filesystem. go-nfs opens a file for every read
+// and write request, so reads fetch exactly the requested range, and
+// writes are stored on Close.
+//
+// Writes continuing at the end of the file, the usual pattern of NFS
+// clients copying a file in, collect in the spool and are appended with
+// sbox.Append. Any other write or a truncation loads the whole file into
+// the spool, which then replaces the file with PutAtomic.
+type file struct {
+	fs       *Filesystem
+	name     string
+	path     string
+	info     *sbox.EntryInfo // Attributes when opened
+	flag     int
+	writable bool
+
+	mu     sync.Mutex
+	off    int64 // Offset of Read, Write and Seek
+	size   int64
+	closed bool
+
+	spool  *os.File
+	loaded bool // The spool holds the whole file, not only the bytes after info.Size
+	dirty  bool
+}
+
+// Name returns the name the file was opened with.
+func (f *file) Name() string {
+	return f.name
+}
+
+// openSpool creates an empty spool file.
+func (f *file) openSpool() (*os.File, error) {
+	spool, err := os.CreateTemp(f.fs.spoolDir, "sbox-nfs-*")
+	if err != nil {
+		return nil, err
+	}
+	// The spool is only reachable through the file.
+	_ = os.Remove(spool.Name())
+	return spool, nil
+}
+
+// load makes sure the spool holds the whole file, fetching the stored
+// content and keeping the bytes written after it.
+func (f *file) load(ctx context.Context) error {
+	if f.loaded {
+		return nil
+	}
+	spool, err := f.openSpool()
+	if err != nil {
+		return err
+	}
+	if err := f.fill(ctx, spool); err != nil {
+		_ = spool.Close()
+		return err
+	}
+	if f.spool != nil {
+		_ = f.spool.Close()
+	}
+	f.spool, f.loaded = spool, true
+	return nil
+}
+
+// fill copies the stored content and the spooled tail to spool.
+func (f *file) fill(ctx context.Context, spool *os.File) error {
+	if f.info.Size > 0 {
+		r, err := sbox.Ranged(f.fs.engine).GetRange(ctx, f.path, 0, f.info.Size)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = r.Close() }()
+		if _, err := io.CopyN(spool, r, f.info.Size); err != nil {
+			return err
+		}
+	}
+	if f.spool != nil {
+		if _, err := io.Copy(spool, io.NewSectionReader(f.spool, 0, f.size-f.info.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads from the current offset.
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.readAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off; fewer bytes are only returned at the
+// end of the file, together with io.EOF.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *file) readAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, sbox.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("sbox/nfs: read %s: negative offset: %w", f.path, sbox.ErrInvalid)
+	}
+	ctx := context.Background()
+	if f.dirty {
+		if err := f.load(ctx); err != nil {
+			return 0, err
+		}
+		return f.spool.ReadAt(p, off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), f.size-off)
+	r, err := sbox.Ranged(f.fs.engine).GetRange(ctx, f.path, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = r.Close() }()
+	n, err := io.ReadFull(r, p[:length])
+	if err == io.ErrUnexpectedEOF || (err == nil && n < len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write writes at the current offset, or at the end of the file if it was
+// opened with os.O_APPEND.
+func (f *file) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	off := f.off
+	if f.flag&os.O_APPEND != 0 {
+		off = f.size
+	}
+	n, err := f.writeAt(p, off)
+	f.off = off + int64(n)
+	return n, err
+}
+
+func (f *file) writeAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, sbox.ErrClosed
+	}
+	if !f.writable {
+		return 0, fmt.Errorf("sbox/nfs: write %s: %w", f.path, sbox.ErrPermission)
+	}
+	pos := off
+	if f.loaded || off != f.size {
+		if err := f.load(context.Background()); err != nil {
+			return 0, err
+		}
+	} else {
+		// Continue the tail written after the stored content.
+		if f.spool == nil {
+			spool, err := f.openSpool()
+			if err != nil {
+				return 0, err
+			}
+			f.spool = spool
+		}
+		pos -= f.info.Size
+	}
+	n, err := f.spool.WriteAt(p, pos)
+	if end := off + int64(n); end > f.size {
+		f.size = end
+	}
+	f.dirty = true
+	return n, err
+}
+
+// Seek sets the offset of the next Read or Write.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return f.off, fmt.Errorf("sbox/nfs: seek %s: invalid whence: %w", f.path, sbox.ErrInvalid)
+	}
+	if offset < 0 {
+		return f.off, fmt.Errorf("sbox/nfs: seek %s: negative offset: %w", f.path, sbox.ErrInvalid)
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the file.
+func (f *file) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return sbox.ErrClosed
+	}
+	if !f.writable {
+		return fmt.Errorf("sbox/nfs: truncate %s: %w", f.path, sbox.ErrPermission)
+	}
+	if size == f.size {
+		return nil
+	}
+	if err := f.load(context.Background()); err != nil {
+		return err
+	}
+	if err := f.spool.Truncate(size); err != nil {
+		return err
+	}
+	f.size, f.dirty = size, true
+	return nil
+}
+
+// Lock does nothing; NFSv3 locking is a separate protocol go-nfs does not
+// implement.
+func (f *file) Lock() error {
+	return nil
+}
+
+// Unlock does nothing.
+func (f *file) Unlock() error {
+	return nil
+}
+
+// Close stores the changes, if any, and frees the spool.
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return sbox.ErrClosed
+	}
+	f.closed = true
+	if f.spool == nil {
+		return nil
+	}
+	defer func() { _ = f.spool.Close() }()
+	if !f.dirty {
+		return nil
+	}
+	ctx := context.Background()
+	if !f.loaded {
+		tail := f.size - f.info.Size
+		n, err := sbox.Append(ctx, f.fs.engine, f.path, io.NewSectionReader(f.spool, 0, tail))
+		if err == nil && n != tail {
+			err = fmt.Errorf("sbox/nfs: append %s: %w", f.path, io.ErrShortWrite)
+		}
+		return err
+	}
+	return sbox.PutAtomic(ctx, f.fs.engine, f.path, io.NewSectionReader(f.spool, 0, f.size))
+}
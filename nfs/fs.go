@@ -0,0 +1,406 @@
+// Package nfs exposes any sbox.StorageEngine read-write over NFSv3, for
+// environments where FUSE is not available, such as restricted containers,
+// or to share storage with network clients.
+//
+// Filesystem adapts an engine to billy.Filesystem, the interface served by
+// the go-nfs server, and keeps a HandleCache that maps NFS file handles to
+// engine paths. NFS clients issue each read and write as a separate
+// request at an offset. Reads go to the engine's RangeReader. Writes that
+// extend a file are appended with sbox.Append; other writes load the file
+// into a spool file on local disk and upload it with PutAtomic when the
+// request completes.
+//
+// Building with -tags nfs adds [Serve] and [NewHandler], which serve a
+// Filesystem with go-nfs (this also requires github.com/willscott/go-nfs
+// in your module):
+//
+//	l, _ := net.Listen("tcp", ":2049")
+//	log.Fatal(nfs.Serve(l, engine))
+//
+// Clients mount with e.g. mount -t nfs -o vers=3,tcp,port=2049,mountport=2049,nolock host:/ /mnt.
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/nuln/sbox"
+)
+
+// ErrNotEmpty is returned by Remove for a directory with entries.
+var ErrNotEmpty = errors.New("sbox/nfs: directory not empty")
+
+// Filesystem is a billy.Filesystem backed by an engine. Operations use
+// context.Background, since billy has no contexts.
+type Filesystem struct {
+	engine   sbox.StorageEngine
+	root     string
+	readOnly bool
+	spoolDir string
+	handles  *HandleCache
+}
+
+// New returns a Filesystem for engine.
+func New(engine sbox.StorageEngine, opts ...Option) *Filesystem {
+	f := &Filesystem{engine: engine}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.handles == nil {
+		f.handles = NewHandleCache(DefaultHandleLimit)
+	}
+	return f
+}
+
+// Engine returns the engine the Filesystem is backed by.
+func (f *Filesystem) Engine() sbox.StorageEngine {
+	return f.engine
+}
+
+// Handles returns the file handle cache. Handles are shared by the
+// Filesystem and the filesystems returned by Chroot, and refer to engine
+// paths.
+func (f *Filesystem) Handles() *HandleCache {
+	return f.handles
+}
+
+// ReadOnly reports whether the Filesystem rejects changes.
+func (f *Filesystem) ReadOnly() bool {
+	return f.readOnly
+}
+
+// clean converts a slash-separated name to an engine path. The root is
+// represented by the empty string.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// path returns the engine path of name.
+func (f *Filesystem) path(name string) string {
+	return clean(path.Join(f.root, name))
+}
+
+// checkWrite fails if the Filesystem is read-only.
+func (f *Filesystem) checkWrite(op, p string) error {
+	if f.readOnly {
+		return fmt.Errorf("sbox/nfs: %s %s: %w", op, p, billy.ErrReadOnly)
+	}
+	return nil
+}
+
+// osError passes *err through sbox.OSError, as go-nfs tests errors with
+// os.IsNotExist and the like.
+func osError(err *error) {
+	*err = sbox.OSError(*err)
+}
+
+// stat returns the attributes of p, with the modes NFS clients expect.
+func (f *Filesystem) stat(ctx context.Context, p string) (*sbox.EntryInfo, error) {
+	if p == "" {
+		return &sbox.EntryInfo{Name: "/", IsDir: true, Mode: os.ModeDir | 0755}, nil
+	}
+	info, err := f.engine.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return withMode(info), nil
+}
+
+// withMode returns info with os.ModeDir set for directories and default
+// permissions, since not every engine fills in Mode.
+func withMode(info *sbox.EntryInfo) *sbox.EntryInfo {
+	mode := info.Mode
+	if info.IsDir {
+		mode |= os.ModeDir
+	}
+	if mode.Perm() == 0 {
+		mode |= 0644
+		if info.IsDir {
+			mode |= 0111
+		}
+	}
+	if mode == info.Mode {
+		return info
+	}
+	c := *info
+	c.Mode = mode
+	return &c
+}
+
+// Create creates or truncates the file name and opens it for reading and
+// writing.
+func (f *Filesystem) Create(name string) (billy.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Open opens the file name for reading.
+func (f *Filesystem) Open(name string) (billy.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the file name with the os.O_* flags in flag. Files
+// created with os.O_CREATE or truncated with os.O_TRUNC are stored empty
+// right away; writes are stored when the file is closed.
+func (f *Filesystem) OpenFile(name string, flag int, perm os.FileMode) (_ billy.File, err error) {
+	defer osError(&err)
+	ctx := context.Background()
+	p := f.path(name)
+	writable := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0
+	if writable || flag&os.O_CREATE != 0 {
+		if err := f.checkWrite("open", p); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := f.stat(ctx, p)
+	switch {
+	case err == nil && info.IsDir:
+		return nil, fmt.Errorf("sbox/nfs: open %s: %w", p, sbox.ErrIsDir)
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, fmt.Errorf("sbox/nfs: open %s: %w", p, sbox.ErrExist)
+	case err != nil && (!errors.Is(err, sbox.ErrNotFound) || flag&os.O_CREATE == 0):
+		return nil, err
+	}
+	if err != nil || (writable && flag&os.O_TRUNC != 0 && info.Size > 0) {
+		// Create or truncate the file; writes are then appended to it.
+		if err := sbox.PutAtomic(ctx, f.engine, p, strings.NewReader("")); err != nil {
+			return nil, err
+		}
+		if info, err = f.stat(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+	return &file{fs: f, name: name, path: p, info: info, flag: flag, writable: writable, size: info.Size}, nil
+}
+
+// Stat returns the attributes of name.
+func (f *Filesystem) Stat(name string) (_ os.FileInfo, err error) {
+	defer osError(&err)
+	info, err := f.stat(context.Background(), f.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return info.ToFileInfo(), nil
+}
+
+// Rename renames oldpath to newpath, replacing a file at newpath. The
+// handles of oldpath move to newpath.
+func (f *Filesystem) Rename(oldpath, newpath string) (err error) {
+	defer osError(&err)
+	oldp, newp := f.path(oldpath), f.path(newpath)
+	if err := f.checkWrite("rename", oldp); err != nil {
+		return err
+	}
+	if err := f.engine.Rename(context.Background(), oldp, newp); err != nil {
+		return err
+	}
+	f.handles.Rename(oldp, newp)
+	return nil
+}
+
+// Remove removes the file or empty directory name and drops its handles.
+func (f *Filesystem) Remove(name string) (err error) {
+	defer osError(&err)
+	ctx := context.Background()
+	p := f.path(name)
+	if err := f.checkWrite("remove", p); err != nil {
+		return err
+	}
+	info, err := f.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		entries, err := f.engine.ReadDir(ctx, p)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("sbox/nfs: remove %s: %w", p, ErrNotEmpty)
+		}
+	}
+	if err := f.engine.Remove(ctx, p); err != nil {
+		return err
+	}
+	f.handles.Remove(p)
+	return nil
+}
+
+// Join joins path elements.
+func (f *Filesystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// TempFile creates a new file in dir whose name begins with prefix and
+// opens it for reading and writing.
+func (f *Filesystem) TempFile(dir, prefix string) (billy.File, error) {
+	for {
+		var b [8]byte
+		_, _ = rand.Read(b[:])
+		fl, err := f.OpenFile(path.Join(dir, prefix+hex.EncodeToString(b[:])), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, sbox.ErrExist) {
+			return fl, err
+		}
+	}
+}
+
+// ReadDir lists the directory name.
+func (f *Filesystem) ReadDir(name string) (_ []os.FileInfo, err error) {
+	defer osError(&err)
+	entries, err := f.engine.ReadDir(context.Background(), f.path(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, len(entries))
+	for i, e := range entries {
+		infos[i] = withMode(e).ToFileInfo()
+	}
+	return infos, nil
+}
+
+// MkdirAll creates the directory name and any missing parents.
+func (f *Filesystem) MkdirAll(name string, perm os.FileMode) (err error) {
+	defer osError(&err)
+	p := f.path(name)
+	if err := f.checkWrite("mkdir", p); err != nil {
+		return err
+	}
+	return f.engine.MkdirAll(context.Background(), p)
+}
+
+// Lstat returns the attributes of name without following a symbolic link
+// if the engine supports links, and is Stat otherwise.
+func (f *Filesystem) Lstat(name string) (_ os.FileInfo, err error) {
+	defer osError(&err)
+	p := f.path(name)
+	sl, ok := f.engine.(sbox.Symlinker)
+	if !ok || p == "" || !sbox.Supports(f.engine, sbox.CapSymlink) {
+		return f.Stat(name)
+	}
+	info, err := sl.Lstat(context.Background(), p)
+	if err != nil {
+		return nil, err
+	}
+	return withMode(info).ToFileInfo(), nil
+}
+
+// Symlink creates a symbolic link at link pointing to target. Clients
+// cannot be trusted to keep links within the export, and engines such as
+// local resolve them through the host filesystem, so targets that are
+// absolute or climb above the root of the Filesystem fail with
+// sbox.ErrInvalid.
+func (f *Filesystem) Symlink(target, link string) error {
+	p := f.path(link)
+	if err := f.checkWrite("symlink", p); err != nil {
+		return err
+	}
+	if target == "" || strings.HasPrefix(target, "/") || strings.HasPrefix(target, `\`) {
+		return fmt.Errorf("sbox/nfs: symlink %s: absolute target %q: %w", p, target, sbox.ErrInvalid)
+	}
+	if _, err := sbox.CleanPath(path.Join(path.Dir(clean(link)), target)); err != nil {
+		return fmt.Errorf("sbox/nfs: symlink %s: target %q: %w", p, target, err)
+	}
+	sl, ok := f.engine.(sbox.Symlinker)
+	if !ok || !sbox.Supports(f.engine, sbox.CapSymlink) {
+		return fmt.Errorf("sbox/nfs: symlink %s: %w", p, sbox.ErrNotSupported)
+	}
+	return sl.Symlink(context.Background(), target, p)
+}
+
+// Readlink returns the target of the symbolic link name.
+func (f *Filesystem) Readlink(name string) (string, error) {
+	p := f.path(name)
+	sl, ok := f.engine.(sbox.Symlinker)
+	if !ok || !sbox.Supports(f.engine, sbox.CapSymlink) {
+		return "", fmt.Errorf("sbox/nfs: readlink %s: %w", p, sbox.ErrNotSupported)
+	}
+	return sl.Readlink(context.Background(), p)
+}
+
+// Chroot returns a Filesystem rooted at the directory p.
+func (f *Filesystem) Chroot(p string) (billy.Filesystem, error) {
+	c := *f
+	c.root = f.path(p)
+	return &c, nil
+}
+
+// Root returns the root of the Filesystem in the engine.
+func (f *Filesystem) Root() string {
+	return "/" + f.root
+}
+
+// Capabilities reports that files can be read, written, seeked and
+// truncated, or only read and seeked if the Filesystem is read-only.
+func (f *Filesystem) Capabilities() billy.Capability {
+	if f.readOnly {
+		return billy.ReadCapability | billy.SeekCapability
+	}
+	return billy.WriteCapability | billy.ReadCapability | billy.ReadAndWriteCapability |
+		billy.SeekCapability | billy.TruncateCapability
+}
+
+// === Extension: billy.Change ===
+
+// Chmod changes the permissions of name if the engine supports them, and
+// otherwise does nothing, so tools like cp -p keep working.
+func (f *Filesystem) Chmod(name string, mode os.FileMode) error {
+	p := f.path(name)
+	if err := f.checkWrite("chmod", p); err != nil {
+		return err
+	}
+	pm, ok := f.engine.(sbox.PermissionManager)
+	if !ok || !sbox.Supports(f.engine, sbox.CapPermissions) {
+		return nil
+	}
+	return pm.Chmod(context.Background(), p, mode)
+}
+
+// Lchown is Chown; engines follow links when changing ownership.
+func (f *Filesystem) Lchown(name string, uid, gid int) error {
+	return f.Chown(name, uid, gid)
+}
+
+// Chown changes the owner of name if the engine supports it, and otherwise
+// does nothing.
+func (f *Filesystem) Chown(name string, uid, gid int) error {
+	p := f.path(name)
+	if err := f.checkWrite("chown", p); err != nil {
+		return err
+	}
+	pm, ok := f.engine.(sbox.PermissionManager)
+	if !ok || !sbox.Supports(f.engine, sbox.CapPermissions) {
+		return nil
+	}
+	return pm.Chown(context.Background(), p, uid, gid)
+}
+
+// Chtimes changes the modification time of name if the engine supports it,
+// and otherwise does nothing, so tools like touch keep working. The access
+// time is not stored.
+func (f *Filesystem) Chtimes(name string, atime, mtime time.Time) error {
+	p := f.path(name)
+	if err := f.checkWrite("chtimes", p); err != nil {
+		return err
+	}
+	mts, ok := f.engine.(sbox.ModTimeSetter)
+	if !ok || !sbox.Supports(f.engine, sbox.CapSetModTime) {
+		return nil
+	}
+	return mts.SetModTime(context.Background(), p, mtime)
+}
+
+// Compile-time interface checks.
+var (
+	_ billy.Filesystem = (*Filesystem)(nil)
+	_ billy.Change     = (*Filesystem)(nil)
+	_ billy.Capable    = (*Filesystem)(nil)
+)
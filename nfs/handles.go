@@ -0,0 +1,167 @@
+package nfs
+
+import (
+	"container/list"
+	"crypto/rand"
+	"strings"
+	"sync"
+)
+
+// DefaultHandleLimit is the number of file handles kept by default.
+const DefaultHandleLimit = 1 << 16
+
+// handleSize is the length of the handles issued, well below the 64 bytes
+// NFSv3 allows.
+const handleSize = 16
+
+// HandleCache maps NFS file handles to engine paths and back. NFS clients
+// refer to files by opaque handles that must stay valid across requests,
+// renames and server restarts where possible; since engines only know
+// paths, the cache assigns each path a random handle and keeps the mapping
+// for the most recently used limit paths. Clients using an evicted handle
+// get a stale handle error and look the path up again.
+//
+// The cache is keyed on paths: a path keeps its handle while cached, and
+// Rename moves the handles of a renamed file or directory tree along with
+// it, so open files survive a rename as they would on a local filesystem.
+type HandleCache struct {
+	limit int
+
+	mu       sync.Mutex
+	lru      *list.List // of *handleEntry, most recently used at the front
+	byPath   map[string]*list.Element
+	byHandle map[string]*list.Element
+}
+
+// handleEntry is a cached handle.
+type handleEntry struct {
+	path   string
+	handle string
+}
+
+// NewHandleCache returns a cache holding up to limit handles, or
+// DefaultHandleLimit if limit is not positive.
+func NewHandleCache(limit int) *HandleCache {
+	if limit <= 0 {
+		limit = DefaultHandleLimit
+	}
+	return &HandleCache{
+		limit:    limit,
+		lru:      list.New(),
+		byPath:   make(map[string]*list.Element),
+		byHandle: make(map[string]*list.Element),
+	}
+}
+
+// Limit returns the maximum number of handles kept.
+func (c *HandleCache) Limit() int {
+	return c.limit
+}
+
+// Len returns the number of handles cached.
+func (c *HandleCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// ToHandle returns the handle of p, assigning one if p has none.
+func (c *HandleCache) ToHandle(p string) []byte {
+	p = clean(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.byPath[p]; ok {
+		c.lru.MoveToFront(el)
+		return []byte(el.Value.(*handleEntry).handle)
+	}
+
+	var b [handleSize]byte
+	for {
+		_, _ = rand.Read(b[:])
+		if _, taken := c.byHandle[string(b[:])]; !taken {
+			break
+		}
+	}
+	e := &handleEntry{path: p, handle: string(b[:])}
+	el := c.lru.PushFront(e)
+	c.byPath[p] = el
+	c.byHandle[e.handle] = el
+	for c.lru.Len() > c.limit {
+		c.remove(c.lru.Back())
+	}
+	return b[:]
+}
+
+// FromHandle returns the path of handle, and false if the handle is not
+// cached.
+func (c *HandleCache) FromHandle(handle []byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.byHandle[string(handle)]
+	if !ok {
+		return "", false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*handleEntry).path, true
+}
+
+// Invalidate drops handle.
+func (c *HandleCache) Invalidate(handle []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.byHandle[string(handle)]; ok {
+		c.remove(el)
+	}
+}
+
+// Remove drops the handles of p and of everything below it.
+func (c *HandleCache) Remove(p string) {
+	p = clean(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for q, el := range c.byPath {
+		if within(q, p) {
+			c.remove(el)
+		}
+	}
+}
+
+// Rename moves the handles of oldPath and of everything below it to
+// newPath, replacing the handles of newPath and its descendants.
+func (c *HandleCache) Rename(oldPath, newPath string) {
+	oldPath, newPath = clean(oldPath), clean(newPath)
+	if oldPath == newPath {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var moved []*list.Element
+	for q, el := range c.byPath {
+		switch {
+		case within(q, oldPath):
+			moved = append(moved, el)
+		case within(q, newPath):
+			c.remove(el)
+		}
+	}
+	for _, el := range moved {
+		delete(c.byPath, el.Value.(*handleEntry).path)
+	}
+	for _, el := range moved {
+		e := el.Value.(*handleEntry)
+		e.path = newPath + strings.TrimPrefix(e.path, oldPath)
+		c.byPath[e.path] = el
+	}
+}
+
+// remove drops el. c.mu must be held.
+func (c *HandleCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*handleEntry)
+	delete(c.byPath, e.path)
+	delete(c.byHandle, e.handle)
+}
+
+// within reports whether p is dir or below it.
+func within(p, dir string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,279 @@
+package nfs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/local"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/nfs"
+)
+
+func get(t *testing.T, engine sbox.StorageEngine, p string) string {
+	t.Helper()
+	r, err := sbox.Ranged(engine).GetRange(context.Background(), p, 0, -1)
+	if err != nil {
+		t.Fatalf("GetRange %s: %v", p, err)
+	}
+	defer func() { _ = r.Close() }()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", p, err)
+	}
+	return string(b)
+}
+
+// write writes data at off the way go-nfs handles a WRITE request.
+func write(t *testing.T, fs billy.Filesystem, name string, off int64, data string) {
+	t.Helper()
+	f, err := fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestFilesystem_Write(t *testing.T) {
+	engine := memory.New()
+	fs := nfs.New(engine, nfs.WithSpoolDir(t.TempDir()))
+
+	if err := fs.MkdirAll("/dir", 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	f, err := fs.Create("/dir/a.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Sequential writes are appended.
+	data := strings.Repeat("0123456789", 10_000)
+	for off := 0; off < len(data); off += 32 << 10 {
+		write(t, fs, "/dir/a.txt", int64(off), data[off:min(off+32<<10, len(data))])
+	}
+	if get(t, engine, "dir/a.txt") != data {
+		t.Fatalf("sequential writes differ")
+	}
+
+	// Other writes rewrite the file.
+	write(t, fs, "/dir/a.txt", 5, "XYZ")
+	want := data[:5] + "XYZ" + data[8:]
+	if get(t, engine, "dir/a.txt") != want {
+		t.Fatalf("overwrite differs")
+	}
+
+	f, err = fs.OpenFile("/dir/a.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if err := f.Truncate(4); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if _, err := f.Write([]byte("!")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := get(t, engine, "dir/a.txt"); got != "0123!" {
+		t.Fatalf("content = %q", got)
+	}
+
+	info, err := fs.Stat("dir/a.txt")
+	if err != nil || info.Size() != 5 || info.Mode().Perm() == 0 {
+		t.Fatalf("Stat = %v, %v", info, err)
+	}
+	if _, err := fs.OpenFile("dir/a.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, sbox.ErrExist) {
+		t.Fatalf("OpenFile O_EXCL: %v", err)
+	}
+}
+
+func TestFilesystem_Read(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	data := strings.Repeat("abcdefghij", 1000)
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader(data)); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	fs := nfs.New(engine)
+
+	f, err := fs.Open("/f")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 9995); n != 4 || err != nil || string(buf) != "fghi" {
+		t.Fatalf("ReadAt = %d %q, %v", n, buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, 9998); n != 2 || err != io.EOF || string(buf[:n]) != "ij" {
+		t.Fatalf("ReadAt at end = %d %q, %v", n, buf[:n], err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(got, []byte(data)) {
+		t.Fatalf("ReadAll = %d bytes, %v", len(got), err)
+	}
+	if _, err := f.Write([]byte("x")); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Write on read-only file: %v", err)
+	}
+}
+
+func TestFilesystem_Directories(t *testing.T) {
+	fs := nfs.New(memory.New())
+	if err := fs.MkdirAll("d/e", 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := fs.Remove("d"); !errors.Is(err, nfs.ErrNotEmpty) {
+		t.Fatalf("Remove non-empty: %v", err)
+	}
+	infos, err := fs.ReadDir("d")
+	if err != nil || len(infos) != 1 || !infos[0].IsDir() || !infos[0].Mode().IsDir() {
+		t.Fatalf("ReadDir = %v, %v", infos, err)
+	}
+	if err := fs.Rename("d/e", "e"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := fs.Remove("d"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := fs.Stat("d"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Fatalf("Stat removed: %v", err)
+	}
+
+	sub, err := fs.Chroot("e")
+	if err != nil {
+		t.Fatalf("Chroot: %v", err)
+	}
+	f, err := sub.TempFile("", "tmp")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	_ = f.Close()
+	if infos, _ := fs.ReadDir("e"); len(infos) != 1 || !strings.HasPrefix(infos[0].Name(), "tmp") {
+		t.Fatalf("ReadDir after TempFile = %v", infos)
+	}
+}
+
+func TestFilesystem_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	engine := memory.New()
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader("x")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	fs := nfs.New(engine, nfs.WithReadOnly())
+
+	if _, err := fs.Create("g"); !errors.Is(err, billy.ErrReadOnly) {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := fs.OpenFile("f", os.O_RDWR, 0); !errors.Is(err, billy.ErrReadOnly) {
+		t.Fatalf("OpenFile for writing: %v", err)
+	}
+	if err := fs.Remove("f"); !errors.Is(err, billy.ErrReadOnly) {
+		t.Fatalf("Remove: %v", err)
+	}
+	if billy.CapabilityCheck(fs, billy.WriteCapability) {
+		t.Fatal("read-only Filesystem reports WriteCapability")
+	}
+	if _, err := fs.Open("f"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+}
+
+func TestFilesystem_SymlinkTarget(t *testing.T) {
+	ctx := context.Background()
+	// An engine that resolves links through the host filesystem without
+	// confining them.
+	engine, err := local.New(t.TempDir(), local.WithTrustedPaths(true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "export/dir/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	root, err := nfs.New(engine).Chroot("export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := root.(*nfs.Filesystem)
+
+	for _, target := range []string{"/", "/etc/passwd", "../..", "../../secret", "../dir/../../secret", `\etc`} {
+		if err := fs.Symlink(target, "dir/link"); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Symlink(%q) = %v, want %v", target, err, sbox.ErrInvalid)
+		}
+	}
+	if _, err := fs.Lstat("dir/link"); !os.IsNotExist(err) {
+		t.Errorf("Lstat after rejected links = %v", err)
+	}
+	if err := fs.Symlink("../dir/a.txt", "dir/link"); err != nil {
+		t.Fatalf("Symlink within the export: %v", err)
+	}
+	if got := get(t, engine, "export/dir/link"); got != "a" {
+		t.Errorf("content through link = %q", got)
+	}
+}
+
+func TestHandleCache(t *testing.T) {
+	c := nfs.NewHandleCache(3)
+
+	a := c.ToHandle("/dir/a")
+	if !bytes.Equal(c.ToHandle("dir/a"), a) {
+		t.Fatal("path got a second handle")
+	}
+	if p, ok := c.FromHandle(a); !ok || p != "dir/a" {
+		t.Fatalf("FromHandle = %q, %v", p, ok)
+	}
+
+	// Renaming the directory moves the handles below it.
+	dir := c.ToHandle("dir")
+	c.Rename("dir", "moved")
+	if p, _ := c.FromHandle(a); p != "moved/a" {
+		t.Fatalf("FromHandle after Rename = %q", p)
+	}
+	if p, _ := c.FromHandle(dir); p != "moved" {
+		t.Fatalf("FromHandle after Rename = %q", p)
+	}
+
+	c.Remove("moved")
+	if _, ok := c.FromHandle(a); ok {
+		t.Fatal("handle survived Remove")
+	}
+
+	// The least recently used handle is evicted.
+	h1, h2, h3 := c.ToHandle("1"), c.ToHandle("2"), c.ToHandle("3")
+	c.FromHandle(h1)
+	c.ToHandle("4")
+	if _, ok := c.FromHandle(h2); ok {
+		t.Fatal("least recently used handle kept")
+	}
+	if _, ok := c.FromHandle(h3); !ok {
+		t.Fatal("recent handle evicted")
+	}
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", c.Len())
+	}
+	c.Invalidate(h3)
+	if _, ok := c.FromHandle(h3); ok {
+		t.Fatal("handle survived Invalidate")
+	}
+}
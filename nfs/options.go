@@ -0,0 +1,28 @@
+package nfs
+
+// Option configures optional Filesystem behavior.
+type Option func(*Filesystem)
+
+// WithReadOnly rejects all changes.
+func WithReadOnly() Option {
+	return func(f *Filesystem) {
+		f.readOnly = true
+	}
+}
+
+// WithSpoolDir keeps files being rewritten in dir instead of the default
+// directory for temporary files.
+func WithSpoolDir(dir string) Option {
+	return func(f *Filesystem) {
+		f.spoolDir = dir
+	}
+}
+
+// WithHandleLimit sets how many file handles are kept; clients using an
+// evicted handle look the file up again. The default is
+// DefaultHandleLimit.
+func WithHandleLimit(n int) Option {
+	return func(f *Filesystem) {
+		f.handles = NewHandleCache(n)
+	}
+}
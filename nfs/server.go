@@ -0,0 +1,75 @@
+//go:build nfs
+
+package nfs
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+	nfshelper "github.com/willscott/go-nfs/helpers"
+
+	"github.com/nuln/sbox"
+)
+
+// Serve serves engine over NFSv3 on l until l is closed. The mount and NFS
+// services share the listener, and clients are not authenticated.
+func Serve(l net.Listener, engine sbox.StorageEngine, opts ...Option) error {
+	return nfs.Serve(l, NewHandler(New(engine, opts...)))
+}
+
+// NewHandler returns a go-nfs handler serving fs without authentication.
+// File handles are mapped through fs.Handles.
+func NewHandler(fs *Filesystem) nfs.Handler {
+	return &handler{Handler: nfshelper.NewNullAuthHandler(fs), fs: fs}
+}
+
+// handler serves a Filesystem, replacing the handle methods of the null
+// authentication handler.
+type handler struct {
+	nfs.Handler
+	fs *Filesystem
+}
+
+// Change returns nil for a read-only Filesystem, which go-nfs reports to
+// clients as such.
+func (h *handler) Change(fs billy.Filesystem) billy.Change {
+	if h.fs.readOnly {
+		return nil
+	}
+	return h.Handler.Change(fs)
+}
+
+func (h *handler) ToHandle(fs billy.Filesystem, p []string) []byte {
+	f, ok := fs.(*Filesystem)
+	if !ok {
+		f = h.fs
+	}
+	return h.fs.handles.ToHandle(f.path(path.Join(p...)))
+}
+
+func (h *handler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
+	p, ok := h.fs.handles.FromHandle(fh)
+	if !ok || !within(p, h.fs.root) {
+		return nil, nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, h.fs.root), "/")
+	if rel == "" {
+		return h.fs, []string{}, nil
+	}
+	return h.fs, strings.Split(rel, "/"), nil
+}
+
+func (h *handler) InvalidateHandle(fs billy.Filesystem, fh []byte) error {
+	h.fs.handles.Invalidate(fh)
+	return nil
+}
+
+func (h *handler) HandleLimit() int {
+	return h.fs.handles.Limit()
+}
+
+// Compile-time interface checks.
+var _ nfs.Handler = (*handler)(nil)
@@ -0,0 +1,44 @@
+package sbox
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CleanPath returns the canonical form of an engine path: slash-separated,
+// relative to the engine root, without "." elements or duplicate and
+// trailing slashes; the root is "". Backslashes are treated as separators
+// and leading slashes are dropped, so "/docs\\a.txt" and "docs/a.txt" name
+// the same file on every driver.
+//
+// Paths that could reach outside the engine root fail with ErrInvalid
+// instead of being cleaned: those with a ".." element, a null byte, or a
+// Windows volume name such as "C:". Drivers call CleanPath before touching
+// their backend, unless configured to trust their callers.
+func CleanPath(p string) (string, error) {
+	if err := ValidatePath(p); err != nil {
+		return "", err
+	}
+	return strings.Trim(path.Clean("/"+strings.ReplaceAll(p, `\`, "/")), "/"), nil
+}
+
+// ValidatePath reports whether CleanPath accepts p, without cleaning it.
+func ValidatePath(p string) error {
+	if strings.IndexByte(p, 0) >= 0 {
+		return fmt.Errorf("sbox: path %q contains a null byte: %w", p, ErrInvalid)
+	}
+	if len(p) >= 2 && p[1] == ':' && isLetter(p[0]) {
+		return fmt.Errorf("sbox: path %q has a volume name: %w", p, ErrInvalid)
+	}
+	for _, part := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("sbox: path %q escapes the root: %w", p, ErrInvalid)
+		}
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,72 @@
+package sbox_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "docs/a.txt", want: "docs/a.txt"},
+		{in: "/docs//a.txt/", want: "docs/a.txt"},
+		{in: `docs\a.txt`, want: "docs/a.txt"},
+		{in: "./docs/./a.txt", want: "docs/a.txt"},
+		{in: "docs/..a", want: "docs/..a"},
+		{in: "..", err: true},
+		{in: "docs/../a.txt", err: true},
+		{in: `docs\..\..\etc`, err: true},
+		{in: "a\x00b", err: true},
+		{in: `C:\Windows`, err: true},
+		{in: "c:a", err: true},
+	}
+	for _, tt := range tests {
+		got, err := sbox.CleanPath(tt.in)
+		if tt.err {
+			if !errors.Is(err, sbox.ErrInvalid) {
+				t.Errorf("CleanPath(%q) = %q, %v, want %v", tt.in, got, err, sbox.ErrInvalid)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func FuzzCleanPath(f *testing.F) {
+	for _, p := range []string{"", "/", "docs/a.txt", `docs\..\etc`, "./a//b/", "C:x", "a\x00b", "..a/b.."} {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		got, err := sbox.CleanPath(p)
+		if verr := sbox.ValidatePath(p); (err == nil) != (verr == nil) {
+			t.Fatalf("CleanPath(%q) = %v but ValidatePath = %v", p, err, verr)
+		}
+		if err != nil {
+			if !errors.Is(err, sbox.ErrInvalid) {
+				t.Fatalf("CleanPath(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+			}
+			return
+		}
+		if strings.HasPrefix(got, "/") || strings.HasSuffix(got, "/") ||
+			strings.Contains(got, "//") || strings.Contains(got, `\`) {
+			t.Fatalf("CleanPath(%q) = %q is not canonical", p, got)
+		}
+		for _, part := range strings.Split(got, "/") {
+			if part == ".." || part == "." {
+				t.Fatalf("CleanPath(%q) = %q has a %q element", p, got, part)
+			}
+		}
+		if again, err := sbox.CleanPath(got); err != nil || again != got {
+			t.Fatalf("CleanPath(%q) = %q, %v; not idempotent", got, again, err)
+		}
+	})
+}
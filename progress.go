@@ -0,0 +1,124 @@
+package sbox
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Progress receives updates from long operations, e.g. to draw a progress
+// bar. Attach one to a context with [WithProgress]; [CopyTree], the sync
+// package and the sharded engine's GC and Verify report to it. Calls are
+// serialized per operation and frequent, so implementations should return
+// quickly.
+type Progress interface {
+	Report(p ProgressInfo)
+}
+
+// ProgressFunc adapts a function to the [Progress] interface.
+type ProgressFunc func(p ProgressInfo)
+
+// Report calls f(p).
+func (f ProgressFunc) Report(p ProgressInfo) {
+	f(p)
+}
+
+// ProgressInfo is a snapshot of a long operation.
+type ProgressInfo struct {
+	// Op names the operation: "copy", "sync", "gc" or "verify".
+	Op string
+
+	// Current is the file, shard or manifest being processed.
+	Current string
+
+	// Bytes and Items count the data and the files (or shards, or
+	// manifests) processed so far.
+	Bytes int64
+	Items int
+
+	// TotalBytes and TotalItems are the work known so far; operations that
+	// discover their work as they go raise them until they are done. Zero
+	// means unknown.
+	TotalBytes int64
+	TotalItems int
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes long operations report to p.
+func WithProgress(ctx context.Context, p Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// ProgressFrom returns the Progress attached to ctx, or nil.
+func ProgressFrom(ctx context.Context) Progress {
+	p, _ := ctx.Value(progressKey{}).(Progress)
+	return p
+}
+
+// ProgressTracker accumulates the progress of one operation and reports
+// every change to the Progress attached to a context. It is safe for
+// concurrent use, and all methods of a nil tracker do nothing, so
+// operations can track progress unconditionally.
+type ProgressTracker struct {
+	mu   sync.Mutex
+	p    Progress
+	info ProgressInfo
+}
+
+// NewProgressTracker returns a tracker for the operation op, or nil if ctx
+// has no Progress.
+func NewProgressTracker(ctx context.Context, op string) *ProgressTracker {
+	p := ProgressFrom(ctx)
+	if p == nil {
+		return nil
+	}
+	return &ProgressTracker{p: p, info: ProgressInfo{Op: op}}
+}
+
+// AddTotal raises the known amount of work.
+func (t *ProgressTracker) AddTotal(bytes int64, items int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info.TotalBytes += bytes
+	t.info.TotalItems += items
+	t.p.Report(t.info)
+}
+
+// Advance records bytes and items processed while working on current.
+func (t *ProgressTracker) Advance(current string, bytes int64, items int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info.Current = current
+	t.info.Bytes += bytes
+	t.info.Items += items
+	t.p.Report(t.info)
+}
+
+// Reader returns r, advancing the tracker by the bytes read from it.
+func (t *ProgressTracker) Reader(current string, r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &progressReader{r: r, t: t, current: current}
+}
+
+type progressReader struct {
+	r       io.Reader
+	t       *ProgressTracker
+	current string
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.Advance(r.current, int64(n), 0)
+	}
+	return n, err
+}
@@ -0,0 +1,35 @@
+package sbox_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestCopyTree_ReportsProgress(t *testing.T) {
+	src := memory.New()
+	routerWrite(t, src, "data/a.txt", "alpha")
+	routerWrite(t, src, "data/sub/b.txt", "bravo!")
+
+	var last sbox.ProgressInfo
+	ctx := sbox.WithProgress(context.Background(), sbox.ProgressFunc(func(p sbox.ProgressInfo) {
+		if p.Bytes < last.Bytes || p.Items < last.Items {
+			t.Errorf("progress went backwards: %+v after %+v", p, last)
+		}
+		last = p
+	}))
+	if err := sbox.CopyTree(ctx, src, "data", memory.New(), "copy", sbox.CopyTreeOptions{}); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+	want := sbox.ProgressInfo{Op: "copy", Current: last.Current, Bytes: 11, Items: 2, TotalBytes: 11, TotalItems: 2}
+	if last != want {
+		t.Errorf("final progress = %+v, want %+v", last, want)
+	}
+
+	// Without a Progress in the context, trackers are nil and do nothing.
+	if tr := sbox.NewProgressTracker(context.Background(), "copy"); tr != nil {
+		t.Errorf("tracker without Progress = %v", tr)
+	}
+}
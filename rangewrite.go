@@ -0,0 +1,78 @@
+package sbox
+
+import (
+	"context"
+	"io"
+)
+
+// PutRange writes the contents of r at offset in the existing file at
+// path, with the semantics of [RangeWriter], and returns the number of
+// bytes written. Engines implementing RangeWriter patch the file in place.
+//
+// For other engines, PutRange is a read-modify-write like [Append]: the
+// file is rewritten with [PutAtomic], streaming the existing content
+// around the new data, so the cost grows with the size of the file.
+func PutRange(ctx context.Context, engine StorageEngine, path string, offset int64, r io.Reader) (int64, error) {
+	if offset < 0 {
+		return 0, ErrInvalid
+	}
+	if rw, ok := engine.(RangeWriter); ok && Supports(engine, CapRangeWrite) {
+		return rw.PutRange(ctx, path, offset, r)
+	}
+
+	old, err := engine.Open(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = old.Close() }()
+	size, err := old.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = old.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	src := &countingReader{r: r}
+	content := io.MultiReader(
+		io.LimitReader(old, offset),
+		io.LimitReader(zeros{}, max(offset-size, 0)),
+		src,
+		&tailReader{old: old, size: size, start: func() int64 { return offset + src.n }},
+	)
+	if err := PutAtomic(ctx, engine, path, content); err != nil {
+		return 0, err
+	}
+	return src.n, nil
+}
+
+// zeros reads an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// tailReader reads the existing content of a file from start, which is
+// only known once the new data before it has been read.
+type tailReader struct {
+	old   io.ReadSeeker
+	size  int64
+	start func() int64
+	r     io.Reader
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	if t.r == nil {
+		start := t.start()
+		if start >= t.size {
+			return 0, io.EOF
+		}
+		if _, err := t.old.Seek(start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		t.r = t.old
+	}
+	return t.r.Read(p)
+}
@@ -0,0 +1,55 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+func TestPutRange(t *testing.T) {
+	ctx := context.Background()
+	// Hide the extensions of the memory engine to use the fallback.
+	engine := struct{ sbox.StorageEngine }{memory.New()}
+	if err := sbox.PutAtomic(ctx, engine, "f", strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+
+	tests := []struct {
+		offset int64
+		data   string
+		want   string
+	}{
+		{2, "ab", "01ab456789"},
+		{0, "", "01ab456789"},
+		{8, "xyz", "01ab4567xyz"},
+		{13, "!", "01ab4567xyz\x00\x00!"},
+		{0, "ABCDEFGHIJKLMNOP", "ABCDEFGHIJKLMNOP"},
+	}
+	for _, tt := range tests {
+		n, err := sbox.PutRange(ctx, engine, "f", tt.offset, strings.NewReader(tt.data))
+		if err != nil || n != int64(len(tt.data)) {
+			t.Fatalf("PutRange(%d, %q) = %d, %v", tt.offset, tt.data, n, err)
+		}
+		r, err := engine.Open(ctx, "f")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		got, _ := io.ReadAll(r)
+		_ = r.Close()
+		if string(got) != tt.want {
+			t.Errorf("after PutRange(%d, %q) = %q, want %q", tt.offset, tt.data, got, tt.want)
+		}
+	}
+
+	if _, err := sbox.PutRange(ctx, engine, "missing", 0, strings.NewReader("x")); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("PutRange of a missing file = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := sbox.PutRange(ctx, engine, "f", -1, strings.NewReader("x")); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("PutRange at a negative offset = %v, want %v", err, sbox.ErrInvalid)
+	}
+}
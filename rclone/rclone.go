@@ -1,20 +1,30 @@
 package rclone
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fspath"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/operations"
 	rcloneWalk "github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/version"
+	"github.com/spf13/afero"
 
 	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/lockfile"
 )
 
 // Auto-register rclone storage driver.
@@ -30,25 +40,165 @@ func init() {
 		if remote == "" {
 			return nil, fmt.Errorf("sbox/rclone: remote path is required (set Options[\"remote\"] or BasePath)")
 		}
-		return New(remote)
+		var opts []Option
+		switch n := cfg.Options["memoryCap"].(type) {
+		case int:
+			opts = append(opts, WithMemoryCap(int64(n)))
+		case int64:
+			opts = append(opts, WithMemoryCap(n))
+		case float64:
+			opts = append(opts, WithMemoryCap(int64(n)))
+		case string:
+			parsed, err := strconv.ParseInt(n, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/rclone: invalid memoryCap %q: %w", n, err)
+			}
+			opts = append(opts, WithMemoryCap(parsed))
+		}
+		if v, _ := cfg.Options["versions"].(bool); v {
+			versionsRemote, err := versionsRemote(remote)
+			if err != nil {
+				return nil, err
+			}
+			versions, err := fs.NewFs(context.Background(), versionsRemote)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithVersionsFs(versions))
+		}
+		if s, ok := cfg.Options["pollInterval"].(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/rclone: invalid pollInterval %q: %w", s, err)
+			}
+			opts = append(opts, WithPollInterval(d))
+		}
+		switch v := cfg.Options["fastList"].(type) {
+		case bool:
+			opts = append(opts, WithFastList(v))
+		case string:
+			opts = append(opts, WithFastList(v == "true" || v == "1"))
+		}
+		if l, ok := cfg.Options["locker"].(sbox.Locker); ok {
+			opts = append(opts, WithLocker(l))
+		} else if dir, _ := cfg.Options["lockDir"].(string); dir != "" {
+			opts = append(opts, WithLocker(lockfile.New(afero.NewOsFs(), dir)))
+		}
+		return New(remote, opts...)
+	})
+
+	// The whole DSN body is an rclone remote path, e.g. "rclone://gdrive:backup".
+	sbox.RegisterDSN("rclone", func(rest string) (*sbox.Config, error) {
+		return &sbox.Config{Options: map[string]any{"remote": rest}}, nil
 	})
 }
 
+// DefaultMemoryCap is the default number of bytes a writer buffers before
+// switching to a streaming upload (8MB).
+const DefaultMemoryCap = 8 * 1024 * 1024
+
 // Engine implements sbox.StorageEngine using rclone's fs.Fs.
 type Engine struct {
-	remote fs.Fs
+	remote    fs.Fs
+	versions  fs.Fs // view of remote including old versions, if enabled
+	memoryCap int64
+	system    map[string]bool // Metadata keys managed by the backend
+	locker    sbox.Locker
+	poll      time.Duration // Watch poll interval
+	fastList  bool
+}
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithMemoryCap sets how many bytes Create buffers in memory before it
+// starts streaming to the remote. Files that fit are uploaded with a known
+// size, which some backends require; larger files are streamed without
+// being held in memory. Zero or less streams immediately.
+func WithMemoryCap(n int64) Option {
+	return func(e *Engine) {
+		e.memoryCap = n
+	}
+}
+
+// WithVersionsFs enables sbox.Versioner. versions must be a view of the same
+// remote that lists old object versions next to the current ones, named with
+// rclone's "-vYYYY-MM-DD-HHMMSS-mmm" suffix. Backends with native versioning
+// (S3 buckets with versioning enabled, B2) provide such a view when opened
+// with their "versions" option, e.g. "s3remote,versions:bucket/path".
+func WithVersionsFs(versions fs.Fs) Option {
+	return func(e *Engine) {
+		e.versions = versions
+	}
+}
+
+// WithPollInterval sets how often Watch lists the remote for changes.
+// Every poll lists the watched tree, which costs requests on most
+// backends. The default is sbox.DefaultPollInterval.
+func WithPollInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.poll = d
+	}
+}
+
+// WithFastList makes Walk list the whole tree with recursive listings, in
+// one request per page on S3-style backends, instead of one or more per
+// directory. This is rclone's --fast-list: the tree is held in memory
+// before it is walked. Backends without recursive listings ignore it.
+func WithFastList(enabled bool) Option {
+	return func(e *Engine) {
+		e.fastList = enabled
+	}
+}
+
+// WithLocker enables sbox.Locker by delegating to locker. Remotes offer no
+// exclusive create, so locks must come from elsewhere: a shared
+// filesystem, e.g. lockfile.New, or a coordination service.
+func WithLocker(locker sbox.Locker) Option {
+	return func(e *Engine) {
+		e.locker = locker
+	}
+}
+
+// versionsRemote returns remotePath with the backend's "versions" option
+// set.
+func versionsRemote(remotePath string) (string, error) {
+	parsed, err := fspath.Parse(remotePath)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Name == "" {
+		return "", fmt.Errorf("sbox/rclone: versions require a versioned backend, got local path %q: %w", remotePath, sbox.ErrNotSupported)
+	}
+	return strings.TrimSuffix(parsed.ConfigString, ":") + ",versions:" + parsed.Path, nil
 }
 
 // New creates a new rclone Engine from a remote path (e.g., "gdrive:backup").
-func New(remotePath string) (*Engine, error) {
+func New(remotePath string, opts ...Option) (*Engine, error) {
 	remote, err := fs.NewFs(context.Background(), remotePath)
 	if err != nil {
 		return nil, err
 	}
-	return &Engine{remote: remote}, nil
+	return NewWithFs(remote, opts...), nil
 }
 
-func (e *Engine) Stat(ctx context.Context, p string) (*sbox.EntryInfo, error) {
+// NewWithFs creates an rclone Engine backed by an existing fs.Fs.
+// This is useful for wrapping remotes configured elsewhere and for testing.
+func NewWithFs(remote fs.Fs, opts ...Option) *Engine {
+	e := &Engine{remote: remote, memoryCap: DefaultMemoryCap, system: systemMetadata(remote), poll: sbox.DefaultPollInterval}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// wrapErr makes *err an *sbox.PathError of the rclone driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("rclone", op, path, convertError(*err))
+}
+
+func (e *Engine) Stat(ctx context.Context, p string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", p, &err)
 	obj, err := e.remote.NewObject(ctx, p)
 	if err != nil {
 		// Might be a directory
@@ -63,65 +213,100 @@ func (e *Engine) Stat(ctx context.Context, p string) (*sbox.EntryInfo, error) {
 		return nil, convertError(err)
 	}
 
-	return &sbox.EntryInfo{
+	info := &sbox.EntryInfo{
 		Name:    path.Base(obj.Remote()),
 		Path:    p,
 		Size:    obj.Size(),
 		ModTime: obj.ModTime(ctx),
 		IsDir:   false,
-	}, nil
+	}
+	info.Metadata = e.objectMetadata(ctx, obj)
+	if mt, ok := obj.(fs.MimeTyper); ok {
+		info.ContentType = mt.MimeType(ctx)
+	}
+	return info, nil
 }
 
-func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+func (e *Engine) Open(ctx context.Context, path string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", path, &err)
 	obj, err := e.remote.NewObject(ctx, path)
 	if err != nil {
 		return nil, convertError(err)
 	}
 
-	// Rclone objects don't natively support Seek. Download to a temp file.
-	tmp, err := os.CreateTemp("", "sbox-rclone-*")
-	if err != nil {
-		return nil, err
-	}
+	// Rclone objects don't natively support Seek, so open the object lazily
+	// and reopen it with a range request whenever the reader is repositioned.
+	return &objectReader{ctx: ctx, obj: obj, size: obj.Size()}, nil
+}
 
-	rc, err := obj.Open(ctx)
-	if err != nil {
-		_ = tmp.Close()
-		_ = os.Remove(tmp.Name())
-		return nil, err
-	}
+// objectReader implements ReadSeekCloser over an rclone object using range
+// requests. Nothing is downloaded until the first Read.
+type objectReader struct {
+	ctx    context.Context
+	obj    fs.Object
+	size   int64 // -1 if unknown
+	offset int64
+	rc     io.ReadCloser // Open stream positioned at offset, if any
+}
 
-	if _, err := io.Copy(tmp, rc); err != nil {
-		_ = rc.Close()
-		_ = tmp.Close()
-		_ = os.Remove(tmp.Name())
-		return nil, err
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.size >= 0 && r.offset >= r.size {
+		return 0, io.EOF
 	}
-	_ = rc.Close()
-
-	// Seek back to start
-	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
-		_ = tmp.Close()
-		_ = os.Remove(tmp.Name())
-		return nil, err
+	if r.rc == nil {
+		var options []fs.OpenOption
+		if r.offset > 0 {
+			options = append(options, &fs.RangeOption{Start: r.offset, End: -1})
+		}
+		rc, err := r.obj.Open(r.ctx, options...)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
 	}
-
-	return &tempFileReader{File: tmp}, nil
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
 }
 
-// tempFileReader wraps an os.File and deletes it on Close.
-type tempFileReader struct {
-	*os.File
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		if r.size < 0 {
+			return 0, errors.New("sbox/rclone: cannot seek from end of object with unknown size")
+		}
+		newOffset = r.size + offset
+	default:
+		return 0, errors.New("sbox/rclone: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("sbox/rclone: negative seek offset")
+	}
+
+	if newOffset != r.offset && r.rc != nil {
+		_ = r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = newOffset
+	return r.offset, nil
 }
 
-func (t *tempFileReader) Close() error {
-	name := t.File.Name()
-	err := t.File.Close()
-	_ = os.Remove(name)
+func (r *objectReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
 	return err
 }
 
-func (e *Engine) Create(ctx context.Context, p string) (sbox.WriteCloser, error) {
+func (e *Engine) Create(ctx context.Context, p string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", p, &err)
 	return &rcloneWriter{
 		engine: e,
 		path:   p,
@@ -129,45 +314,79 @@ func (e *Engine) Create(ctx context.Context, p string) (sbox.WriteCloser, error)
 	}, nil
 }
 
-func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
-	w := &rcloneWriteSeeker{
+func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	// Appends stream the existing content instead of loading it.
+	if flag&os.O_APPEND != 0 && flag&os.O_TRUNC == 0 {
+		return e.newAppendWriter(ctx, p)
+	}
+
+	return &rcloneWriteSeeker{
 		engine: e,
 		path:   p,
 		ctx:    ctx,
-	}
-
-	// If appending, download existing content first
-	if flag&os.O_APPEND != 0 {
-		obj, err := e.remote.NewObject(ctx, p)
-		if err == nil {
-			rc, err := obj.Open(ctx)
-			if err == nil {
-				existing, _ := io.ReadAll(rc)
-				_ = rc.Close()
-				w.buf = existing
-			}
-		}
-	}
-
-	return w, nil
+		append: flag&os.O_APPEND != 0,
+	}, nil
 }
 
-// rcloneWriter implements WriteCloser for rclone.
+// rcloneWriter implements WriteCloser for rclone. Data is buffered up to the
+// engine's memory cap; beyond that it is streamed to the remote through a
+// pipe while the caller keeps writing.
 type rcloneWriter struct {
 	engine *Engine
 	path   string
 	ctx    context.Context
 	buf    []byte
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
 }
 
 func (w *rcloneWriter) Write(p []byte) (n int, err error) {
-	w.buf = append(w.buf, p...)
-	return len(p), nil
+	if w.pw == nil {
+		if int64(len(w.buf)+len(p)) <= w.engine.memoryCap {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.startStream(); err != nil {
+			return 0, err
+		}
+	}
+	return w.pw.Write(p)
+}
+
+// startStream begins a streaming upload and feeds it the buffered prefix.
+func (w *rcloneWriter) startStream() error {
+	pr, pw := io.Pipe()
+	w.pw = pw
+	w.done = make(chan error, 1)
+	go func() {
+		err := w.engine.rcat(w.ctx, w.path, pr, nil)
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) > 0 {
+		if _, err := pw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (w *rcloneWriter) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	if w.pw != nil {
+		_ = w.pw.Close()
+		return <-w.done
+	}
 	rc := io.NopCloser(io.NewSectionReader(newBytesReaderAt(w.buf), 0, int64(len(w.buf))))
-	_, err := operations.Rcat(w.ctx, w.engine.remote, w.path, rc, time.Now(), nil)
+	err := w.engine.rcat(w.ctx, w.path, rc, nil)
 	return err
 }
 
@@ -178,50 +397,98 @@ type rcloneWriteSeeker struct {
 	ctx    context.Context
 	buf    []byte
 	offset int64
+	append bool
 }
 
+// Write writes p at the current offset, overwriting existing bytes and
+// growing the buffer as needed. Seeking past the end and writing leaves a
+// zero-filled gap, matching os.File semantics. In append mode every write
+// goes to the end of the buffer regardless of the offset.
 func (w *rcloneWriteSeeker) Write(p []byte) (n int, err error) {
-	w.buf = append(w.buf, p...)
-	w.offset += int64(len(p))
+	if w.append {
+		w.offset = int64(len(w.buf))
+	}
+	end := w.offset + int64(len(p))
+	if end > int64(len(w.buf)) {
+		if end > int64(cap(w.buf)) {
+			grown := make([]byte, end, end+end/2)
+			copy(grown, w.buf)
+			w.buf = grown
+		} else {
+			w.buf = w.buf[:end]
+		}
+	}
+	copy(w.buf[w.offset:end], p)
+	w.offset = end
 	return len(p), nil
 }
 
 func (w *rcloneWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
 	switch whence {
 	case io.SeekStart:
-		w.offset = offset
+		newOffset = offset
 	case io.SeekCurrent:
-		w.offset += offset
+		newOffset = w.offset + offset
 	case io.SeekEnd:
-		w.offset = int64(len(w.buf)) + offset
+		newOffset = int64(len(w.buf)) + offset
+	default:
+		return 0, errors.New("sbox/rclone: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("sbox/rclone: negative seek offset")
 	}
+	w.offset = newOffset
 	return w.offset, nil
 }
 
 func (w *rcloneWriteSeeker) Close() error {
 	rc := io.NopCloser(io.NewSectionReader(newBytesReaderAt(w.buf), 0, int64(len(w.buf))))
-	_, err := operations.Rcat(w.ctx, w.engine.remote, w.path, rc, time.Now(), nil)
+	err := w.engine.rcat(w.ctx, w.path, rc, nil)
 	return err
 }
 
-func (e *Engine) Remove(ctx context.Context, path string) error {
+func (e *Engine) Remove(ctx context.Context, path string) (err error) {
+	defer wrapErr("remove", path, &err)
 	obj, err := e.remote.NewObject(ctx, path)
+	if err == nil {
+		return obj.Remove(ctx)
+	}
+	if !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorIsDir) && !errors.Is(err, fs.ErrorNotAFile) {
+		return err
+	}
+	// Try as directory. Purge of a missing directory fails with whatever
+	// the backend reports, so check it exists first; on backends without
+	// real directories, one with no entries does not.
+	entries, err := e.remote.List(ctx, path)
 	if err != nil {
-		// Try as directory
-		return operations.Purge(ctx, e.remote, path)
+		return err
 	}
-	return obj.Remove(ctx)
+	if len(entries) == 0 && path != "" && !e.remote.Features().CanHaveEmptyDirectories {
+		return fs.ErrorDirNotFound
+	}
+	return operations.Purge(ctx, e.remote, path)
 }
 
-func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
 	return operations.MoveFile(ctx, e.remote, e.remote, newPath, oldPath)
 }
 
-func (e *Engine) MkdirAll(ctx context.Context, path string) error {
-	return e.remote.Mkdir(ctx, path)
+func (e *Engine) MkdirAll(ctx context.Context, dirPath string) (err error) {
+	defer wrapErr("mkdir", dirPath, &err)
+	// Backends create the missing parents of path, and some do so even
+	// where a file occupies them.
+	for p := strings.Trim(dirPath, "/"); p != "" && p != "."; p = path.Dir(p) {
+		if _, err := e.remote.NewObject(ctx, p); err == nil {
+			return sbox.ErrNotDir
+		}
+	}
+	return e.remote.Mkdir(ctx, dirPath)
 }
 
-func (e *Engine) ReadDir(ctx context.Context, dirPath string) ([]*sbox.EntryInfo, error) {
+func (e *Engine) ReadDir(ctx context.Context, dirPath string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", dirPath, &err)
 	entries, err := e.remote.List(ctx, dirPath)
 	if err != nil {
 		return nil, convertError(err)
@@ -229,22 +496,30 @@ func (e *Engine) ReadDir(ctx context.Context, dirPath string) ([]*sbox.EntryInfo
 
 	var result []*sbox.EntryInfo
 	for _, entry := range entries {
-		info := &sbox.EntryInfo{
-			Name: path.Base(entry.Remote()),
-			Path: filepath.Join(dirPath, path.Base(entry.Remote())),
-		}
-		if obj, ok := entry.(fs.Object); ok {
-			info.Size = obj.Size()
-			info.ModTime = obj.ModTime(ctx)
-			info.IsDir = false
-		} else {
-			info.IsDir = true
-		}
-		result = append(result, info)
+		result = append(result, entryInfo(ctx, dirPath, entry))
 	}
 	return result, nil
 }
 
+// entryInfo converts a directory entry listed in dirPath.
+func entryInfo(ctx context.Context, dirPath string, entry fs.DirEntry) *sbox.EntryInfo {
+	info := &sbox.EntryInfo{
+		Name: path.Base(entry.Remote()),
+		Path: filepath.Join(dirPath, path.Base(entry.Remote())),
+	}
+	if obj, ok := entry.(fs.Object); ok {
+		info.Size = obj.Size()
+		info.ModTime = obj.ModTime(ctx)
+		info.IsDir = false
+		if mt, ok := obj.(fs.MimeTyper); ok {
+			info.ContentType = mt.MimeType(ctx)
+		}
+	} else {
+		info.IsDir = true
+	}
+	return info
+}
+
 // === Extension: StreamReader ===
 
 func (e *Engine) Get(ctx context.Context, path string) (io.ReadCloser, error) {
@@ -322,6 +597,32 @@ func (e *Engine) SignedURL(ctx context.Context, path string, expiry time.Duratio
 	return do.PublicLink(ctx, path, fs.Duration(expiry), false)
 }
 
+// === Extension: TierManager ===
+
+func (e *Engine) GetTier(ctx context.Context, path string) (string, error) {
+	obj, err := e.remote.NewObject(ctx, path)
+	if err != nil {
+		return "", convertError(err)
+	}
+	do, ok := obj.(fs.GetTierer)
+	if !ok || !e.remote.Features().GetTier {
+		return "", sbox.ErrNotSupported
+	}
+	return do.GetTier(), nil
+}
+
+func (e *Engine) SetTier(ctx context.Context, path string, tier string) error {
+	obj, err := e.remote.NewObject(ctx, path)
+	if err != nil {
+		return convertError(err)
+	}
+	do, ok := obj.(fs.SetTierer)
+	if !ok || !e.remote.Features().SetTier {
+		return sbox.ErrNotSupported
+	}
+	return do.SetTier(tier)
+}
+
 // === Extension: StreamWriter ===
 
 func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
@@ -329,51 +630,626 @@ func (e *Engine) Put(ctx context.Context, path string, reader io.Reader) error {
 	if !ok {
 		rc = io.NopCloser(reader)
 	}
-	_, err := operations.Rcat(ctx, e.remote, path, rc, time.Now(), nil)
+	err := e.rcat(ctx, path, rc, nil)
 	return err
 }
 
-// === Walk helper (used by sbox.Walk but rclone has native support) ===
+// === Extension: MetadataWriter ===
+
+// SetModTime sets the modification time of an object. Remotes that cannot
+// store modification times return sbox.ErrNotSupported.
+func (e *Engine) SetModTime(ctx context.Context, path string, t time.Time) error {
+	if e.remote.Precision() == fs.ModTimeNotSupported {
+		return sbox.ErrNotSupported
+	}
+	obj, err := e.remote.NewObject(ctx, path)
+	if err != nil {
+		return convertError(err)
+	}
+	if err := obj.SetModTime(ctx, t); err != nil {
+		if errors.Is(err, fs.ErrorCantSetModTime) || errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			return sbox.ErrNotSupported
+		}
+		return err
+	}
+	return nil
+}
+
+// SetMetadata writes metadata on remotes that can update it in place (see
+// "rclone help backends" for the metadata each backend supports). Keys are
+// interpreted by the backend, which may also keep keys that are absent.
+func (e *Engine) SetMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	if !e.remote.Features().WriteMetadata {
+		return sbox.ErrNotSupported
+	}
+	obj, err := e.remote.NewObject(ctx, path)
+	if err != nil {
+		return convertError(err)
+	}
+	do, ok := obj.(fs.SetMetadataer)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	if err := do.SetMetadata(ctx, metadata); err != nil {
+		if errors.Is(err, fs.ErrorNotImplemented) {
+			return sbox.ErrNotSupported
+		}
+		return err
+	}
+	return nil
+}
+
+// === Extension: Appender ===
 
-// WalkNative performs a native rclone walk, which is more efficient than
-// the generic sbox.Walk for remote backends.
-func (e *Engine) WalkNative(ctx context.Context, p string, fn sbox.WalkFunc) error {
-	return rcloneWalk.Walk(ctx, e.remote, p, true, -1, func(walkPath string, entries fs.DirEntries, err error) error {
+// Append streams the existing object followed by r to a hidden temporary
+// object and moves it over p, so memory use does not depend on the file
+// size. Remotes cannot append in place, so the object is still rewritten
+// remotely; on remotes with server-side move the data is uploaded once.
+func (e *Engine) Append(ctx context.Context, p string, r io.Reader) (int64, error) {
+	src := &countingReader{r: r}
+	obj, err := e.remote.NewObject(ctx, p)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		err = e.rcat(ctx, p, io.NopCloser(src), nil)
+		return src.n, err
+	}
+	if err != nil {
+		return 0, convertError(err)
+	}
+	old, err := obj.Open(ctx)
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	// The old content is fully read, and closed, before the move.
+	tmp := tempPath(p)
+	err = e.rcat(ctx, tmp, io.NopCloser(io.MultiReader(old, src)), e.objectMetadata(ctx, obj))
+	_ = old.Close()
+	if err == nil {
+		err = operations.MoveFile(ctx, e.remote, e.remote, p, tmp)
+	}
+	if err != nil {
+		if o, oerr := e.remote.NewObject(ctx, tmp); oerr == nil {
+			_ = o.Remove(ctx)
+		}
+		return 0, err
+	}
+	return src.n, nil
+}
+
+// pipeWriter feeds writes through a pipe to a function consuming them in
+// the background, e.g. an upload. Close waits for it to finish.
+type pipeWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+}
+
+func newPipeWriter(consume func(r io.Reader) error) *pipeWriter {
+	pr, pw := io.Pipe()
+	w := &pipeWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := consume(pr)
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *pipeWriter) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	_ = w.pw.Close()
+	return <-w.done
+}
+
+// Abort makes the consumer fail, so that it leaves the remote untouched.
+func (w *pipeWriter) Abort() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	_ = w.pw.CloseWithError(errors.New("sbox/rclone: write aborted"))
+	<-w.done
+	return nil
+}
+
+// appendWriter feeds writes to Append through a pipe. Writes always go to
+// the end of the file.
+type appendWriter struct {
+	*pipeWriter
+	size   int64 // Existing size plus bytes written
+	offset int64
+}
+
+func (e *Engine) newAppendWriter(ctx context.Context, p string) (*appendWriter, error) {
+	w := &appendWriter{}
+	if obj, err := e.remote.NewObject(ctx, p); err == nil {
+		w.size = obj.Size()
+	} else if !errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, convertError(err)
+	}
+	w.offset = w.size
+	w.pipeWriter = newPipeWriter(func(r io.Reader) error {
+		_, err := e.Append(ctx, p, r)
+		return err
+	})
+	return w, nil
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	n, err := w.pipeWriter.Write(p)
+	w.size += int64(n)
+	w.offset = w.size
+	return n, err
+}
+
+func (w *appendWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += w.offset
+	case io.SeekEnd:
+		offset += w.size
+	default:
+		return 0, errors.New("sbox/rclone: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("sbox/rclone: negative seek offset")
+	}
+	w.offset = offset
+	return offset, nil
+}
+
+// === Extension: ConditionalWriter ===
+
+// ETag is the object's hash of the backend's preferred type, such as the
+// MD5 on S3, or else derived from its modification time and size.
+func (e *Engine) ETag(ctx context.Context, p string) (string, error) {
+	obj, err := e.remote.NewObject(ctx, p)
+	if err != nil {
+		return "", convertError(err)
+	}
+	if ht := e.remote.Hashes().GetOne(); ht != hash.None {
+		if sum, err := obj.Hash(ctx, ht); err == nil && sum != "" {
+			return ht.String() + ":" + sum, nil
+		}
+	}
+	return fmt.Sprintf("%x-%x", obj.ModTime(ctx).UnixNano(), obj.Size()), nil
+}
+
+// CreateIf uploads to a hidden temporary object, then checks the
+// precondition again and moves the object into place. rclone exposes no
+// conditional uploads, so without a locker (see WithLocker) another writer
+// can slip in between the check and the move; with one, conditional
+// writers exclude each other.
+func (e *Engine) CreateIf(ctx context.Context, p string, cond sbox.Precondition) (sbox.AtomicWriteCloser, error) {
+	if err := cond.Check(e.ETag(ctx, p)); err != nil {
+		return nil, err
+	}
+	return newPipeWriter(func(r io.Reader) error {
+		tmp := tempPath(p)
+		err := e.rcat(ctx, tmp, io.NopCloser(r), nil)
+		if err == nil {
+			err = e.commit(ctx, p, func() error {
+				if err := cond.Check(e.ETag(ctx, p)); err != nil {
+					return err
+				}
+				return operations.MoveFile(ctx, e.remote, e.remote, p, tmp)
+			})
+		}
 		if err != nil {
-			return fn(walkPath, nil, err)
+			if o, oerr := e.remote.NewObject(ctx, tmp); oerr == nil {
+				_ = o.Remove(ctx)
+			}
+		}
+		return err
+	}), nil
+}
+
+// commit runs fn under the configured locker, if any. Its locks are kept
+// apart from those taken through Lock.
+func (e *Engine) commit(ctx context.Context, p string, fn func() error) error {
+	if e.locker == nil {
+		return fn()
+	}
+	return sbox.WithLock(ctx, e.locker, path.Join(".sbox-commit", p), sbox.LockOptions{Wait: sbox.DefaultLockTTL}, fn)
+}
+
+// tempPath returns a hidden temporary name next to p.
+func tempPath(p string) string {
+	dir, name := path.Split(p)
+	return path.Join(dir, "."+name+"."+strconv.FormatInt(time.Now().UnixNano(), 36)+".tmp")
+}
+
+// === Extension: Versioner ===
+
+// ListVersions returns the old versions of p kept by the backend, newest
+// first. Version IDs are the versioned object names.
+func (e *Engine) ListVersions(ctx context.Context, p string) ([]sbox.VersionInfo, error) {
+	if e.versions == nil {
+		return nil, sbox.ErrNotSupported
+	}
+	dir, name := path.Split(p)
+	entries, err := e.versions.List(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	type candidate struct {
+		info sbox.VersionInfo
+		at   time.Time
+	}
+	var found []candidate
+	for _, entry := range entries {
+		obj, ok := entry.(fs.Object)
+		if !ok {
+			continue
+		}
+		id := path.Base(obj.Remote())
+		at, orig := version.Remove(id)
+		if at.IsZero() || orig != name {
+			continue
+		}
+		found = append(found, candidate{
+			info: sbox.VersionInfo{ID: id, Size: obj.Size(), ModTime: obj.ModTime(ctx)},
+			at:   at,
+		})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].at.After(found[j].at) })
+
+	result := make([]sbox.VersionInfo, len(found))
+	for i, v := range found {
+		result[i] = v.info
+	}
+	return result, nil
+}
+
+// versionObject returns the object holding a version of p.
+func (e *Engine) versionObject(ctx context.Context, p, versionID string) (fs.Object, error) {
+	if e.versions == nil {
+		return nil, sbox.ErrNotSupported
+	}
+	at, orig := version.Remove(versionID)
+	if at.IsZero() || orig != path.Base(p) || strings.ContainsAny(versionID, `/\`) {
+		return nil, fmt.Errorf("sbox/rclone: invalid version ID %q for %s: %w", versionID, p, sbox.ErrInvalid)
+	}
+	obj, err := e.versions.NewObject(ctx, path.Join(path.Dir(p), versionID))
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return obj, nil
+}
+
+func (e *Engine) OpenVersion(ctx context.Context, p, versionID string) (sbox.ReadSeekCloser, error) {
+	obj, err := e.versionObject(ctx, p, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return &objectReader{ctx: ctx, obj: obj, size: obj.Size()}, nil
+}
+
+// RestoreVersion copies the version over the current object. On versioned
+// backends the replaced content becomes a version itself.
+func (e *Engine) RestoreVersion(ctx context.Context, p, versionID string) error {
+	obj, err := e.versionObject(ctx, p, versionID)
+	if err != nil {
+		return err
+	}
+	_, err = operations.Copy(ctx, e.remote, nil, p, obj)
+	return err
+}
+
+func (e *Engine) DeleteVersion(ctx context.Context, p, versionID string) error {
+	obj, err := e.versionObject(ctx, p, versionID)
+	if err != nil {
+		return err
+	}
+	return obj.Remove(ctx)
+}
+
+// === Extension: Locker ===
+
+// Lock delegates to the locker set with WithLocker, or returns
+// sbox.ErrNotSupported.
+func (e *Engine) Lock(ctx context.Context, p string, opts sbox.LockOptions) (sbox.Lease, error) {
+	if e.locker == nil {
+		return nil, sbox.ErrNotSupported
+	}
+	return e.locker.Lock(ctx, p, opts)
+}
+
+// === Extension: Lister ===
+
+// List streams the directory listing in tranches on remotes that support
+// paged listing (e.g. S3), keeping only the entries of the requested page,
+// and fetches metadata such as modification times for those entries only.
+// Each page lists the directory again from the start.
+func (e *Engine) List(ctx context.Context, dirPath string, opts sbox.ListOptions) (*sbox.ListPage, error) {
+	b := sbox.NewPageBuilder(opts)
+	add := func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			b.Add(path.Base(entry.Remote()), func() *sbox.EntryInfo { return entryInfo(ctx, dirPath, entry) })
+		}
+		return ctx.Err()
+	}
+
+	var err error
+	if listP := e.remote.Features().ListP; listP != nil {
+		err = listP(ctx, dirPath, add)
+	} else {
+		var entries fs.DirEntries
+		if entries, err = e.remote.List(ctx, dirPath); err == nil {
+			err = add(entries)
+		}
+	}
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return b.Page(), nil
+}
+
+// === Extension: Glober ===
+
+// Glob lists the tree below the pattern's literal prefix with a single
+// recursive listing where the remote supports it (e.g. S3), instead of one
+// request per directory. Without "**", the listing stops at the pattern's
+// depth.
+func (e *Engine) Glob(ctx context.Context, pattern string) ([]string, error) {
+	if _, err := sbox.MatchGlob(pattern, ""); err != nil {
+		return nil, err
+	}
+	base := sbox.GlobBase(pattern)
+	maxLevel := -1
+	if !strings.Contains(pattern, "**") {
+		maxLevel = pathDepth(pattern) - pathDepth(base)
+	}
+	if maxLevel == 0 {
+		// No wildcards: the pattern matches at most itself.
+		if base == "" {
+			return nil, nil
+		}
+		if _, err := e.Stat(ctx, base); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []string{base}, nil
+	}
+
+	var matches []string
+	match := func(p string) {
+		if ok, _ := sbox.MatchGlob(pattern, p); ok {
+			matches = append(matches, p)
 		}
+	}
+	err := rcloneWalk.ListR(ctx, e.remote, base, true, maxLevel, rcloneWalk.ListAll, func(entries fs.DirEntries) error {
 		for _, entry := range entries {
-			info := &sbox.EntryInfo{
-				Name: path.Base(entry.Remote()),
-				Path: entry.Remote(),
+			match(entry.Remote())
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, fs.ErrorDirNotFound):
+		// The literal prefix may name a file, or nothing at all.
+		if base != "" {
+			if _, oerr := e.remote.NewObject(ctx, base); oerr == nil {
+				match(base)
 			}
-			if obj, ok := entry.(fs.Object); ok {
-				info.Size = obj.Size()
-				info.ModTime = obj.ModTime(ctx)
-				info.IsDir = false
-			} else {
-				info.IsDir = true
+		}
+	case err != nil:
+		return nil, err
+	case base != "":
+		match(base)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// === Extension: Walker ===
+
+// Walk walks the tree with rclone's walker, which reads directories
+// concurrently (up to the --checkers setting), or lists the tree
+// recursively with WithFastList. Entries are reported in no particular
+// order, every directory before its entries.
+func (e *Engine) Walk(ctx context.Context, root string, fn sbox.WalkFunc) error {
+	if e.fastList && e.remote.Features().ListR != nil {
+		var ci *fs.ConfigInfo
+		ctx, ci = fs.AddConfig(ctx)
+		ci.UseListR = true
+	}
+	info, err := e.Stat(ctx, root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if err := fn(root, info, nil); err != nil || !info.IsDir {
+		return err
+	}
+
+	// Skipping and stopping are done with ErrorSkipDir, as rclone logs
+	// and counts other errors.
+	skip := make(map[string]bool)
+	var stop error
+	err = rcloneWalk.Walk(ctx, e.remote, root, true, -1, func(dir string, entries fs.DirEntries, err error) error {
+		if stop != nil || skip[dir] {
+			return rcloneWalk.ErrorSkipDir
+		}
+		if err != nil {
+			if err := fn(dir, nil, convertError(err)); err != nil && err != filepath.SkipDir {
+				stop = err
 			}
-			if err := fn(entry.Remote(), info, nil); err != nil {
-				return err
+			return rcloneWalk.ErrorSkipDir
+		}
+		for i, entry := range entries {
+			info := entryInfo(ctx, dir, entry)
+			err := fn(entry.Remote(), info, nil)
+			switch {
+			case err == filepath.SkipDir && info.IsDir:
+				skip[entry.Remote()] = true
+			case err == filepath.SkipDir:
+				for _, rest := range entries[i+1:] {
+					if _, ok := rest.(fs.Directory); ok {
+						skip[rest.Remote()] = true
+					}
+				}
+				return nil
+			case err != nil:
+				stop = err
+				return rcloneWalk.ErrorSkipDir
 			}
 		}
 		return nil
 	})
+	if stop != nil {
+		return stop
+	}
+	return convertError(err)
 }
 
 // Helpers
 
+// convertError makes the errors of rclone match the sbox sentinel errors,
+// keeping their messages.
 func convertError(err error) error {
-	if err == nil {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrorObjectNotFound), errors.Is(err, fs.ErrorDirNotFound):
+		return sbox.ClassifyAs(err, sbox.ErrNotFound)
+	case errors.Is(err, fs.ErrorIsDir):
+		return sbox.ClassifyAs(err, sbox.ErrIsDir)
+	case errors.Is(err, fs.ErrorIsFile):
+		return sbox.ClassifyAs(err, sbox.ErrNotDir)
+	case errors.Is(err, fs.ErrorDirExists):
+		return sbox.ClassifyAs(err, sbox.ErrExist)
+	case errors.Is(err, fs.ErrorPermissionDenied):
+		return sbox.ClassifyAs(err, sbox.ErrPermission)
+	case errors.Is(err, fs.ErrorNotImplemented), errors.Is(err, fs.ErrorCantMove),
+		errors.Is(err, fs.ErrorCantCopy), errors.Is(err, fs.ErrorCantDirMove):
+		return sbox.ClassifyAs(err, sbox.ErrNotSupported)
+	}
+	return sbox.Classify(err)
+}
+
+// pathDepth returns the number of elements in p.
+func pathDepth(p string) int {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return 0
+	}
+	return strings.Count(p, "/") + 1
+}
+
+// systemMetadata returns the metadata keys that the backend of remote
+// derives from the object itself, such as "mtime" or "mode".
+func systemMetadata(remote fs.Fs) map[string]bool {
+	info, err := fs.Find(remote.Name())
+	if err != nil {
+		if typ, ok := fs.ConfigFileGet(remote.Name(), "type"); ok {
+			info, err = fs.Find(typ)
+		}
+	}
+	keys := make(map[string]bool)
+	if err == nil && info.MetadataInfo != nil {
+		for key := range info.MetadataInfo.System {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// objectMetadata returns the user metadata of obj, without the keys the
+// backend manages itself, or nil.
+func (e *Engine) objectMetadata(ctx context.Context, obj fs.Object) map[string]string {
+	if !e.remote.Features().ReadMetadata {
+		return nil
+	}
+	md, err := fs.GetMetadata(ctx, obj)
+	if err != nil {
 		return nil
 	}
-	if err == fs.ErrorObjectNotFound || err == fs.ErrorDirNotFound {
-		return os.ErrNotExist
+	var user map[string]string
+	for key, value := range md {
+		if e.system[key] {
+			continue
+		}
+		if user == nil {
+			user = make(map[string]string)
+		}
+		user[key] = value
+	}
+	return user
+}
+
+// rcat uploads in to p. The object gets the metadata attached to ctx with
+// sbox.WithMetadata or, failing that, keep, and the content type and cache
+// control of the file options in ctx if the backend stores them. With an
+// expected size, the upload is sized up front.
+func (e *Engine) rcat(ctx context.Context, p string, in io.ReadCloser, keep map[string]string) error {
+	md := fs.Metadata(sbox.MetadataFromContext(ctx))
+	if md == nil {
+		md = keep
+	}
+	opts := sbox.FileOptionsFromContext(ctx)
+	if opts.ContentType == "" && opts.DetectContentType && e.system["content-type"] {
+		// Sniff the start of the stream and put it back in front.
+		head := make([]byte, 512)
+		n, err := io.ReadFull(in, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		opts.ContentType = sbox.DetectContentType(p, head[:n])
+		in = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head[:n]), in), in}
+	}
+	cloned := false
+	for key, value := range map[string]string{"content-type": opts.ContentType, "cache-control": opts.CacheControl} {
+		if value == "" || !e.system[key] {
+			continue
+		}
+		if !cloned {
+			// Do not modify the caller's map.
+			md, cloned = maps.Clone(md), true
+			if md == nil {
+				md = make(fs.Metadata)
+			}
+		}
+		md[key] = value
+	}
+	if md != nil {
+		var ci *fs.ConfigInfo
+		ctx, ci = fs.AddConfig(ctx)
+		ci.Metadata = true
+	}
+	var err error
+	if opts.ExpectedSize >= 0 {
+		_, err = operations.RcatSize(ctx, e.remote, p, in, opts.ExpectedSize, time.Now(), md)
+	} else {
+		_, err = operations.Rcat(ctx, e.remote, p, in, time.Now(), md)
 	}
 	return err
 }
 
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // bytesReaderAt implements io.ReaderAt for a byte slice.
 type bytesReaderAt struct {
 	data []byte
@@ -394,6 +1270,107 @@ func (r *bytesReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 	return
 }
 
+// === Extension: HealthChecker ===
+
+// Ping checks that the remote is reachable, with the backend's About call
+// if it has one and otherwise by listing the root. A root that does not
+// exist yet, as on bucket-based remotes before the first write, is
+// healthy.
+func (e *Engine) Ping(ctx context.Context) error {
+	if about := e.remote.Features().About; about != nil {
+		_, err := about(ctx)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, fs.ErrorNotImplemented) {
+			return fmt.Errorf("sbox/rclone: %s: %w", e.remote, err)
+		}
+	}
+	if _, err := e.remote.List(ctx, ""); err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+		return fmt.Errorf("sbox/rclone: %s: %w", e.remote, err)
+	}
+	return nil
+}
+
+// === Extension: UsageReporter ===
+
+// Usage lists the tree below p recursively, with a single listing on
+// backends that support it, and reports the quota the backend's About
+// call returns.
+func (e *Engine) Usage(ctx context.Context, p string) (*sbox.UsageInfo, error) {
+	usage := &sbox.UsageInfo{}
+	obj, err := e.remote.NewObject(ctx, p)
+	if p != "" && err == nil {
+		usage.Files, usage.Bytes = 1, max(obj.Size(), 0)
+	} else {
+		err = rcloneWalk.ListR(ctx, e.remote, p, true, -1, rcloneWalk.ListAll, func(entries fs.DirEntries) error {
+			for _, entry := range entries {
+				switch entry := entry.(type) {
+				case fs.Object:
+					usage.Files++
+					usage.Bytes += max(entry.Size(), 0)
+				case fs.Directory:
+					usage.Dirs++
+				}
+			}
+			return nil
+		})
+		if p == "" && errors.Is(err, fs.ErrorDirNotFound) {
+			err = nil // Nothing written yet
+		}
+		if err != nil {
+			return nil, convertError(err)
+		}
+	}
+	usage.Physical = usage.Bytes
+
+	if about := e.remote.Features().About; about != nil {
+		if u, err := about(ctx); err == nil {
+			value := func(v *int64) int64 {
+				if v == nil {
+					return -1
+				}
+				return *v
+			}
+			usage.Quota = &sbox.Quota{Total: value(u.Total), Used: value(u.Used), Free: value(u.Free)}
+		}
+	}
+	return usage, nil
+}
+
+// === Extension: Watcher ===
+
+// Watch polls the remote below path every poll interval (see
+// WithPollInterval).
+func (e *Engine) Watch(ctx context.Context, path string, recursive bool) (<-chan sbox.WatchEvent, error) {
+	return sbox.Poll(ctx, e, path, recursive, e.poll)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports the extensions the remote backend and the engine
+// options allow.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapHash:
+		return e.remote.Hashes().Count() > 0
+	case sbox.CapSignedURL:
+		_, ok := e.remote.(fs.PublicLinker)
+		return ok
+	case sbox.CapTier:
+		return e.remote.Features().GetTier && e.remote.Features().SetTier
+	case sbox.CapSetModTime:
+		return e.remote.Precision() != fs.ModTimeNotSupported
+	case sbox.CapMetadata:
+		return e.remote.Features().WriteMetadata
+	case sbox.CapVersions:
+		return e.versions != nil
+	case sbox.CapLock:
+		return e.locker != nil
+	}
+	return sbox.Implements(e, c)
+}
+
 // Compile-time interface checks.
 var (
 	_ sbox.StorageEngine      = (*Engine)(nil)
@@ -403,4 +1380,17 @@ var (
 	_ sbox.Hasher             = (*Engine)(nil)
 	_ sbox.Copier             = (*Engine)(nil)
 	_ sbox.SignedURLGenerator = (*Engine)(nil)
+	_ sbox.TierManager        = (*Engine)(nil)
+	_ sbox.Versioner          = (*Engine)(nil)
+	_ sbox.Appender           = (*Engine)(nil)
+	_ sbox.MetadataWriter     = (*Engine)(nil)
+	_ sbox.Glober             = (*Engine)(nil)
+	_ sbox.Lister             = (*Engine)(nil)
+	_ sbox.Locker             = (*Engine)(nil)
+	_ sbox.ConditionalWriter  = (*Engine)(nil)
+	_ sbox.HealthChecker      = (*Engine)(nil)
+	_ sbox.UsageReporter      = (*Engine)(nil)
+	_ sbox.Watcher            = (*Engine)(nil)
+	_ sbox.Walker             = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
 )
@@ -2,18 +2,27 @@ package rclone_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/rclone/rclone/backend/local"
 	_ "github.com/rclone/rclone/backend/webdav"
 	_ "github.com/rclone/rclone/cmd/serve"
 	_ "github.com/rclone/rclone/cmd/serve/webdav"
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/walk"
 
 	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/rclone"
 	"github.com/nuln/sbox/sboxtest"
 )
 
@@ -82,3 +91,660 @@ func TestRcloneEngine_WebDAV(t *testing.T) {
 	// 5. Run the universal storage test suite
 	sboxtest.StorageTestSuite(t, engine)
 }
+
+func TestRcloneEngine_OpenFileSeekWrite(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	path := "seek_write.txt"
+
+	w, err := engine.OpenFile(ctx, path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := io.WriteString(w, "hello world"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := w.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if _, err := io.WriteString(w, "WORLD"); err != nil {
+		t.Fatalf("Write after seek: %v", err)
+	}
+	if _, err := w.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("Seek end: %v", err)
+	}
+	if _, err := io.WriteString(w, "!"); err != nil {
+		t.Fatalf("Write at end: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := engine.Open(ctx, path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "hello WORLD!" {
+		t.Errorf("content = %q, want %q", string(data), "hello WORLD!")
+	}
+}
+
+// tierFs wraps an fs.Fs and simulates per-object storage tiers.
+type tierFs struct {
+	fs.Fs
+	tiers map[string]string
+}
+
+func (f *tierFs) Features() *fs.Features {
+	ft := *f.Fs.Features()
+	ft.GetTier = true
+	ft.SetTier = true
+	return &ft
+}
+
+func (f *tierFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	obj, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return &tierObject{Object: obj, fs: f}, nil
+}
+
+type tierObject struct {
+	fs.Object
+	fs *tierFs
+}
+
+func (o *tierObject) GetTier() string {
+	if tier, ok := o.fs.tiers[o.Remote()]; ok {
+		return tier
+	}
+	return "STANDARD"
+}
+
+func (o *tierObject) SetTier(tier string) error {
+	o.fs.tiers[o.Remote()] = tier
+	return nil
+}
+
+func TestRcloneEngine_TierManager(t *testing.T) {
+	ctx := context.Background()
+	base, err := fs.NewFs(ctx, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFs: %v", err)
+	}
+	engine := rclone.NewWithFs(&tierFs{Fs: base, tiers: map[string]string{}})
+
+	w, _ := engine.Create(ctx, "cold.txt")
+	_, _ = io.WriteString(w, "archive me")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	tier, err := engine.GetTier(ctx, "cold.txt")
+	if err != nil {
+		t.Fatalf("GetTier: %v", err)
+	}
+	if tier != "STANDARD" {
+		t.Errorf("initial tier = %q, want %q", tier, "STANDARD")
+	}
+
+	if err := engine.SetTier(ctx, "cold.txt", "GLACIER"); err != nil {
+		t.Fatalf("SetTier: %v", err)
+	}
+	tier, err = engine.GetTier(ctx, "cold.txt")
+	if err != nil {
+		t.Fatalf("GetTier after set: %v", err)
+	}
+	if tier != "GLACIER" {
+		t.Errorf("tier = %q, want %q", tier, "GLACIER")
+	}
+
+	if _, err := engine.GetTier(ctx, "missing.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("GetTier missing = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	// The plain local backend has no tier concept.
+	plain := rclone.NewWithFs(base)
+	if _, err := plain.GetTier(ctx, "cold.txt"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("GetTier on local = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+// countingFs wraps an fs.Fs and counts how often objects are opened.
+type countingFs struct {
+	fs.Fs
+	opens int
+}
+
+func (f *countingFs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	obj, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return &countingObject{Object: obj, fs: f}, nil
+}
+
+type countingObject struct {
+	fs.Object
+	fs *countingFs
+}
+
+func (o *countingObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	o.fs.opens++
+	return o.Object.Open(ctx, options...)
+}
+
+func TestRcloneEngine_LazyRangeReads(t *testing.T) {
+	ctx := context.Background()
+	base, err := fs.NewFs(ctx, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFs: %v", err)
+	}
+	cfs := &countingFs{Fs: base}
+	engine := rclone.NewWithFs(cfs)
+
+	w, _ := engine.Create(ctx, "big.bin")
+	_, _ = io.WriteString(w, "0123456789abcdef")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := engine.Open(ctx, "big.bin")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	if cfs.opens != 0 {
+		t.Fatalf("Open downloaded eagerly: %d opens", cfs.opens)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "0123" {
+		t.Fatalf("first read = %q, %v", buf, err)
+	}
+	// Sequential reads reuse the stream.
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "4567" {
+		t.Fatalf("second read = %q, %v", buf, err)
+	}
+	if cfs.opens != 1 {
+		t.Errorf("sequential reads opened %d streams, want 1", cfs.opens)
+	}
+
+	if _, err := r.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "def" {
+		t.Errorf("after seek = %q, want %q", rest, "def")
+	}
+	if cfs.opens != 2 {
+		t.Errorf("opens after seek = %d, want 2", cfs.opens)
+	}
+}
+
+func TestRcloneEngine_StreamingCreate(t *testing.T) {
+	ctx := context.Background()
+	content := strings.Repeat("0123456789", 100)
+
+	for _, memoryCap := range []int64{0, 64, int64(len(content))} {
+		t.Run(fmt.Sprintf("cap=%d", memoryCap), func(t *testing.T) {
+			engine, err := rclone.New(t.TempDir(), rclone.WithMemoryCap(memoryCap))
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			w, err := engine.Create(ctx, "stream.txt")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			for i := 0; i < len(content); i += 37 {
+				end := min(i+37, len(content))
+				if _, err := io.WriteString(w, content[i:end]); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if err := w.Close(); !errors.Is(err, sbox.ErrClosed) {
+				t.Errorf("second Close = %v, want %v", err, sbox.ErrClosed)
+			}
+
+			r, err := engine.Open(ctx, "stream.txt")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			data, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(data) != content {
+				t.Errorf("content length = %d, want %d", len(data), len(content))
+			}
+		})
+	}
+}
+
+func TestRcloneEngine_Versioner(t *testing.T) {
+	ctx := context.Background()
+	base, err := fs.NewFs(ctx, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFs: %v", err)
+	}
+	// A local directory laid out like an S3 "versions" listing stands in
+	// for a versioned backend.
+	engine := rclone.NewWithFs(base, rclone.WithVersionsFs(base))
+	for name, content := range map[string]string{
+		"docs/report.txt":                            "current",
+		"docs/report-v2024-01-02-030405-000.txt":     "january",
+		"docs/report-v2024-02-02-030405-000.txt":     "february",
+		"docs/other-v2024-03-02-030405-000.txt":      "unrelated",
+		"docs/report.txt-v2024-03-02-030405-000.bak": "unrelated",
+	} {
+		if err := engine.Put(ctx, name, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+
+	versions, err := engine.ListVersions(ctx, "docs/report.txt")
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].ID != "report-v2024-02-02-030405-000.txt" {
+		t.Fatalf("versions = %+v", versions)
+	}
+
+	r, err := engine.OpenVersion(ctx, "docs/report.txt", versions[1].ID)
+	if err != nil {
+		t.Fatalf("OpenVersion: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "january" {
+		t.Errorf("january version = %q", data)
+	}
+
+	if err := engine.RestoreVersion(ctx, "docs/report.txt", versions[1].ID); err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	r, _ = engine.Open(ctx, "docs/report.txt")
+	data, _ = io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "january" {
+		t.Errorf("restored content = %q", data)
+	}
+
+	if err := engine.DeleteVersion(ctx, "docs/report.txt", versions[0].ID); err != nil {
+		t.Fatalf("DeleteVersion: %v", err)
+	}
+	if versions, _ = engine.ListVersions(ctx, "docs/report.txt"); len(versions) != 1 {
+		t.Errorf("versions after delete = %+v", versions)
+	}
+
+	if _, err := engine.OpenVersion(ctx, "docs/report.txt", "other-v2024-03-02-030405-000.txt"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("OpenVersion of another file = %v, want %v", err, sbox.ErrInvalid)
+	}
+	if _, err := rclone.NewWithFs(base).ListVersions(ctx, "docs/report.txt"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("ListVersions without versions = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+func TestRcloneEngine_Append(t *testing.T) {
+	dir := t.TempDir()
+	engine, err := rclone.New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+
+	if n, err := engine.Append(ctx, "log.txt", strings.NewReader("one\n")); err != nil || n != 4 {
+		t.Fatalf("Append new = %d, %v", n, err)
+	}
+	if n, err := engine.Append(ctx, "log.txt", strings.NewReader("two\n")); err != nil || n != 4 {
+		t.Fatalf("Append existing = %d, %v", n, err)
+	}
+
+	w, err := engine.OpenFile(ctx, "log.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if off, err := w.Seek(0, io.SeekEnd); err != nil || off != 8 {
+		t.Errorf("Seek end = %d, %v, want 8", off, err)
+	}
+	if _, err := io.WriteString(w, "three\n"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	w, err = engine.OpenFile(ctx, "log.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = io.WriteString(w, "discarded\n")
+	if err := w.(interface{ Abort() error }).Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+
+	r, err := engine.Open(ctx, "log.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if want := "one\ntwo\nthree\n"; string(data) != want {
+		t.Errorf("content = %q, want %q", data, want)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("remote holds %d objects, want 1 (temporary objects left behind)", len(entries))
+	}
+}
+
+func TestRcloneEngine_Glob(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	for _, p := range []string{"logs/a.gz", "logs/a.txt", "logs/2024/01/b.gz", "logs/2024/c.gz", "other/d.gz"} {
+		if err := engine.Put(ctx, p, strings.NewReader(p)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// The native listing must agree with the generic walk.
+	generic := struct{ sbox.StorageEngine }{engine}
+	for _, pattern := range []string{"logs/**/*.gz", "logs/*/*.gz", "**/*.gz", "logs/**", "*", "logs/a.txt", "logs", "missing/*"} {
+		got, err := engine.Glob(ctx, pattern)
+		if err != nil {
+			t.Fatalf("Glob(%q): %v", pattern, err)
+		}
+		want, err := sbox.Glob(ctx, generic, pattern)
+		if err != nil {
+			t.Fatalf("sbox.Glob(%q): %v", pattern, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Glob(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+
+	got, _ := engine.Glob(ctx, "logs/**/*.gz")
+	if len(got) != 3 {
+		t.Errorf("Glob(logs/**/*.gz) = %q, want 3 matches", got)
+	}
+}
+
+func TestRcloneEngine_Usage(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	for _, p := range []string{"a/b/c.txt", "a/d.txt", "e.txt"} {
+		if err := engine.Put(ctx, p, strings.NewReader(p)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// The native listing must agree with the generic walk.
+	generic := struct{ sbox.StorageEngine }{engine}
+	for _, p := range []string{"", "a", "a/d.txt"} {
+		got, err := engine.Usage(ctx, p)
+		if err != nil {
+			t.Fatalf("Usage(%q): %v", p, err)
+		}
+		want, err := sbox.Usage(ctx, generic, p)
+		if err != nil {
+			t.Fatalf("sbox.Usage(%q): %v", p, err)
+		}
+		if got.Files != want.Files || got.Dirs != want.Dirs || got.Bytes != want.Bytes || got.Physical != got.Bytes {
+			t.Errorf("Usage(%q) = %+v, want %+v", p, got, want)
+		}
+	}
+	if u, _ := engine.Usage(ctx, ""); u.Quota == nil || u.Quota.Total <= 0 {
+		t.Errorf("Quota = %+v, want the local disk", u.Quota)
+	}
+	if _, err := engine.Usage(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Usage(missing) error = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestRcloneEngine_Walk(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	for _, p := range []string{"a/b/c.txt", "a/b/d/e.txt", "a/f.txt", "g/h.txt", "i.txt"} {
+		if err := engine.Put(ctx, p, strings.NewReader(p)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	walk := func(engine sbox.StorageEngine, root string) []string {
+		var paths []string
+		err := sbox.Walk(ctx, engine, root, func(p string, info *sbox.EntryInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, fmt.Sprintf("%s:%d", p, info.Size))
+			if p == "a/b" {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk(%q): %v", root, err)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// The native walk must agree with the generic one.
+	generic := struct{ sbox.StorageEngine }{engine}
+	for _, root := range []string{"", "a", "i.txt"} {
+		got, want := walk(engine, root), walk(generic, root)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Walk(%q) = %v, want %v", root, got, want)
+		}
+	}
+
+	n := 0
+	err = engine.Walk(ctx, "", func(p string, info *sbox.EntryInfo, err error) error {
+		if n++; n == 2 {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != filepath.SkipAll || n != 2 {
+		t.Errorf("Walk stopped after %d with %v", n, err)
+	}
+}
+
+// listRFs wraps an fs.Fs and gives it recursive listings, counting them.
+type listRFs struct {
+	fs.Fs
+	calls int
+}
+
+func (f *listRFs) Features() *fs.Features {
+	ft := *f.Fs.Features()
+	ft.ListR = func(ctx context.Context, dir string, callback fs.ListRCallback) error {
+		f.calls++
+		return walk.ListR(ctx, f.Fs, dir, true, -1, walk.ListAll, callback)
+	}
+	return &ft
+}
+
+func TestRcloneEngine_WalkFastList(t *testing.T) {
+	base, err := fs.NewFs(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFs: %v", err)
+	}
+	ctx := context.Background()
+	for _, p := range []string{"a/b/c.txt", "a/d.txt", "e.txt"} {
+		if err := rclone.NewWithFs(base).Put(ctx, p, strings.NewReader(p)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	lfs := &listRFs{Fs: base}
+	engine := rclone.NewWithFs(lfs, rclone.WithFastList(true))
+	var paths []string
+	err = engine.Walk(ctx, "", func(p string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, ","); got != ",a,a/b,a/b/c.txt,a/d.txt,e.txt" || lfs.calls != 1 {
+		t.Errorf("Walk = %s with %d recursive listings", got, lfs.calls)
+	}
+}
+
+func TestRcloneEngine_List(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	for i := range 7 {
+		if err := engine.Put(ctx, fmt.Sprintf("dir/f%d", i), strings.NewReader("x")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	var names []string
+	opts := sbox.ListOptions{PageSize: 3}
+	for pages := 1; ; pages++ {
+		page, err := engine.List(ctx, "dir", opts)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		for _, e := range page.Entries {
+			names = append(names, e.Name)
+			if e.Size != 1 || e.Path != "dir/"+e.Name {
+				t.Errorf("entry = %+v", e)
+			}
+		}
+		if page.NextCursor == "" {
+			if pages != 3 {
+				t.Errorf("pages = %d, want 3", pages)
+			}
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if got := strings.Join(names, ","); got != "f0,f1,f2,f3,f4,f5,f6" {
+		t.Errorf("names = %s", got)
+	}
+
+	if _, err := engine.List(ctx, "missing", sbox.ListOptions{}); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("List(missing) error = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestRcloneEngine_SetModTime(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	if err := engine.Put(ctx, "f.txt", strings.NewReader("x")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	mtime := time.Date(2019, 5, 4, 3, 2, 1, 0, time.UTC)
+	if err := engine.SetModTime(ctx, "f.txt", mtime); err != nil {
+		t.Fatalf("SetModTime: %v", err)
+	}
+	info, err := engine.Stat(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !info.ModTime.Equal(mtime) {
+		t.Errorf("modtime = %v, want %v", info.ModTime, mtime)
+	}
+	if err := engine.SetModTime(ctx, "missing.txt", mtime); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("SetModTime(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestRcloneEngine_Metadata(t *testing.T) {
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	mctx := sbox.WithMetadata(ctx, map[string]string{"owner": "alice"})
+	if err := engine.Put(mctx, "f.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	info, err := engine.Stat(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Metadata["owner"] != "alice" {
+		t.Fatalf("Metadata = %v, want owner=alice", info.Metadata)
+	}
+	if _, ok := info.Metadata["mtime"]; ok {
+		t.Errorf("Metadata includes system key mtime: %v", info.Metadata)
+	}
+
+	// Appending rewrites the object but keeps its metadata.
+	if _, err := engine.Append(ctx, "f.txt", strings.NewReader("b")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "f.txt"); info.Metadata["owner"] != "alice" || info.Size != 2 {
+		t.Errorf("after Append = %+v", info)
+	}
+
+	if err := engine.SetMetadata(ctx, "f.txt", map[string]string{"owner": "bob"}); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "f.txt"); info.Metadata["owner"] != "bob" {
+		t.Errorf("after SetMetadata = %v", info.Metadata)
+	}
+}
+
+func TestRcloneEngine_Locker(t *testing.T) {
+	ctx := context.Background()
+	engine, err := rclone.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := engine.Lock(ctx, "f.txt", sbox.LockOptions{}); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Lock without locker = %v, want %v", err, sbox.ErrNotSupported)
+	}
+
+	// Engines sharing a lock directory exclude each other.
+	cfg := &sbox.Config{Type: "rclone", BasePath: t.TempDir(), Options: map[string]any{"lockDir": t.TempDir()}}
+	a, err := sbox.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	b, err := sbox.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	lease, err := a.(sbox.Locker).Lock(ctx, "f.txt", sbox.LockOptions{})
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := b.(sbox.Locker).Lock(ctx, "f.txt", sbox.LockOptions{}); !errors.Is(err, sbox.ErrLocked) {
+		t.Errorf("Lock from another engine = %v, want %v", err, sbox.ErrLocked)
+	}
+	_ = lease.Release(ctx)
+}
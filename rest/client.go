@@ -0,0 +1,469 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// Auto-register the REST client driver.
+func init() {
+	sbox.Register("rest", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
+		endpoint, _ := cfg.Options["url"].(string)
+		if endpoint == "" {
+			endpoint = cfg.BasePath
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("sbox/rest: url is required (set Options[\"url\"] or BasePath)")
+		}
+		var opts []Option
+		if token, _ := cfg.Options["token"].(string); token != "" {
+			opts = append(opts, WithToken(token))
+		}
+		return New(endpoint, opts...)
+	})
+
+	// "rest://host/prefix?token=..." connects over HTTPS; add
+	// "&scheme=http" for plain HTTP.
+	sbox.RegisterDSN("rest", func(rest string) (*sbox.Config, error) {
+		u, err := url.Parse("https://" + rest)
+		if err != nil {
+			return nil, err
+		}
+		opts := make(map[string]any)
+		query := u.Query()
+		for key := range query {
+			opts[key] = query.Get(key)
+		}
+		if scheme := query.Get("scheme"); scheme != "" {
+			u.Scheme = scheme
+			delete(opts, "scheme")
+		}
+		u.RawQuery = ""
+		opts["url"] = u.String()
+		return &sbox.Config{Options: opts}, nil
+	})
+}
+
+// Engine implements sbox.StorageEngine as a client of a Handler.
+type Engine struct {
+	base   *url.URL // Handler URL, without a trailing slash
+	client *http.Client
+	token  string
+}
+
+// New creates an Engine for the Handler at endpoint, e.g.
+// "https://storage.example.com/storage".
+func New(endpoint string, opts ...Option) (*Engine, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("sbox/rest: unsupported URL scheme %q", base.Scheme)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+	e := &Engine{base: base, client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
+}
+
+// Error is an error response of the server.
+type Error struct {
+	Status  int    // HTTP status code
+	Message string // Message sent by the server
+	err     error  // sbox error the response stands for, if any
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sbox/rest: remote error (%d): %s", e.Status, e.Message)
+}
+
+// Unwrap returns the sbox error the response stands for, so errors.Is
+// works across the connection.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// do sends a request for the engine path p with the given query.
+func (e *Engine) do(ctx context.Context, method, p string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := *e.base
+	u.Path += "/" + enginePath(p)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError converts an error response into an error and closes its
+// body.
+func responseError(resp *http.Response) error {
+	defer func() { _ = resp.Body.Close() }()
+	var body errorResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err != nil || body.Error == "" {
+		body.Error = resp.Status
+	}
+	e := &Error{Status: resp.StatusCode, Message: body.Error}
+	for _, k := range errorKinds {
+		if k.name == body.Code && body.Code != "" {
+			e.err = k.err
+			return e
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		e.err = sbox.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		e.err = sbox.ErrPermission
+	case http.StatusBadRequest:
+		e.err = sbox.ErrInvalid
+	case http.StatusPreconditionFailed:
+		e.err = sbox.ErrPreconditionFailed
+	case http.StatusNotImplemented, http.StatusMethodNotAllowed:
+		e.err = sbox.ErrNotSupported
+	}
+	return e
+}
+
+// call sends a request and decodes its JSON response into v, if not nil.
+func (e *Engine) call(ctx context.Context, method, p string, query url.Values, body io.Reader, header http.Header, v any) error {
+	resp, err := e.do(ctx, method, p, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("sbox/rest: %s %s: decode response: %w", method, p, err)
+	}
+	return nil
+}
+
+// wrapErr makes *err an *sbox.PathError of the rest driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("rest", op, path, *err)
+}
+
+func (e *Engine) Stat(ctx context.Context, p string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", p, &err)
+	var info sbox.EntryInfo
+	if err := e.call(ctx, http.MethodGet, p, url.Values{"stat": {""}}, nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (e *Engine) Open(ctx context.Context, p string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	info, err := e.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, sbox.ErrIsDir
+	}
+	// Nothing is downloaded until the first Read; seeking starts a new
+	// range request.
+	return &rangeReader{ctx: ctx, engine: e, path: p, size: info.Size}, nil
+}
+
+// rangeReader implements ReadSeekCloser over range requests.
+type rangeReader struct {
+	ctx    context.Context
+	engine *Engine
+	path   string
+	size   int64
+	offset int64
+	rc     io.ReadCloser // Open response body positioned at offset, if any
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.engine.GetRange(r.ctx, r.path, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		newOffset = r.size + offset
+	default:
+		return 0, errors.New("sbox/rest: invalid whence")
+	}
+	if newOffset < 0 {
+		return 0, errors.New("sbox/rest: negative seek offset")
+	}
+	if newOffset != r.offset && r.rc != nil {
+		_ = r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = newOffset
+	return r.offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// Create streams the file to the server with chunked transfer encoding.
+// The server replaces p atomically when the writer is closed.
+func (e *Engine) Create(ctx context.Context, p string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", p, &err)
+	return e.newWriter(ctx, p, nil, nil), nil
+}
+
+// OpenFile opens p for writing. With os.O_APPEND, data is appended to the
+// file; with os.O_CREATE|os.O_EXCL, the file must not exist. Otherwise the
+// file is replaced atomically on Close, like Create. The writer streams to
+// the server, so it can only seek to its current offset.
+func (e *Engine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", p, &err)
+	if flag&os.O_CREATE == 0 {
+		info, err := e.Stat(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir {
+			return nil, sbox.ErrIsDir
+		}
+	}
+	switch {
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return e.newWriter(ctx, p, nil, http.Header{"If-None-Match": {"*"}}), nil
+	case flag&os.O_APPEND != 0 && flag&os.O_TRUNC == 0:
+		return e.newWriter(ctx, p, url.Values{"append": {""}}, nil), nil
+	}
+	return e.newWriter(ctx, p, nil, nil), nil
+}
+
+// writer streams what is written as the body of a PUT request running in
+// the background.
+type writer struct {
+	pw      *io.PipeWriter
+	done    chan error
+	written int64 // Bytes stored, as reported by the server
+	offset  int64
+	closed  bool
+}
+
+func (e *Engine) newWriter(ctx context.Context, p string, query url.Values, header http.Header) *writer {
+	pr, pw := io.Pipe()
+	w := &writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		n, err := e.put(ctx, p, query, pr, header)
+		w.written = n
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	n, err := w.pw.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+// Seek reports the offset; the writer cannot move.
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	if (whence == io.SeekCurrent && offset == 0) || (whence == io.SeekStart && offset == w.offset) {
+		return w.offset, nil
+	}
+	return 0, fmt.Errorf("sbox/rest: seek in a streaming writer: %w", sbox.ErrNotSupported)
+}
+
+// Close ends the body and returns the result of the request.
+func (w *writer) Close() error {
+	if w.closed {
+		return sbox.ErrClosed
+	}
+	w.closed = true
+	_ = w.pw.Close()
+	return <-w.done
+}
+
+// put uploads r to p and returns the number of bytes the server stored.
+// Metadata in ctx is sent as X-Sbox-Meta-* headers, and the file options
+// as the headers of setFileOptions.
+func (e *Engine) put(ctx context.Context, p string, query url.Values, r io.Reader, header http.Header) (int64, error) {
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	for k, v := range sbox.MetadataFromContext(ctx) {
+		header.Set(metaHeaderPrefix+k, v)
+	}
+	setFileOptions(header, sbox.FileOptionsFromContext(ctx))
+	var resp struct {
+		Written int64 `json:"written"`
+	}
+	// Hide any length of r so the body is always streamed.
+	body := struct{ io.Reader }{r}
+	if err := e.call(ctx, http.MethodPut, p, query, body, header, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Written, nil
+}
+
+func (e *Engine) Remove(ctx context.Context, p string) (err error) {
+	defer wrapErr("remove", p, &err)
+	return e.call(ctx, http.MethodDelete, p, nil, nil, nil, nil)
+}
+
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	return e.call(ctx, http.MethodPost, oldPath, url.Values{"rename": {"/" + enginePath(newPath)}}, nil, nil, nil)
+}
+
+func (e *Engine) MkdirAll(ctx context.Context, p string) (err error) {
+	defer wrapErr("mkdir", p, &err)
+	return e.call(ctx, http.MethodPost, p, url.Values{"mkdir": {""}}, nil, nil, nil)
+}
+
+func (e *Engine) ReadDir(ctx context.Context, p string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", p, &err)
+	var entries []*sbox.EntryInfo
+	if err := e.call(ctx, http.MethodGet, p, url.Values{"list": {""}}, nil, nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// === Extension: Copier ===
+
+// Copy copies a file or directory on the server.
+func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	return e.call(ctx, http.MethodPost, src, url.Values{"copy": {"/" + enginePath(dst)}}, nil, nil, nil)
+}
+
+// === Extension: StreamReader ===
+
+func (e *Engine) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	return e.GetRange(ctx, p, 0, -1)
+}
+
+// === Extension: StreamWriter ===
+
+// Put streams reader to p. If reading fails, the request is aborted and
+// the file keeps its old content.
+func (e *Engine) Put(ctx context.Context, p string, reader io.Reader) error {
+	_, err := e.put(ctx, p, nil, reader, nil)
+	return err
+}
+
+// === Extension: RangeReader ===
+
+func (e *Engine) GetRange(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	var header http.Header
+	switch {
+	case offset < 0:
+		return nil, fmt.Errorf("sbox/rest: negative range offset %d: %w", offset, sbox.ErrInvalid)
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case offset > 0 && length < 0:
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	case length > 0:
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+	}
+	resp, err := e.do(ctx, http.MethodGet, p, nil, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		_ = resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	return resp.Body, nil
+}
+
+// === Extension: Appender ===
+
+// Append streams r to the end of p on the server.
+func (e *Engine) Append(ctx context.Context, p string, r io.Reader) (int64, error) {
+	return e.put(ctx, p, url.Values{"append": {""}}, r, nil)
+}
+
+// === Extension: Hasher ===
+
+// Hash computes the hash on the server, which uses its engine's hasher or
+// reads the file.
+func (e *Engine) Hash(ctx context.Context, p string, algorithm string) (string, error) {
+	var resp struct {
+		Hash string `json:"hash"`
+	}
+	if err := e.call(ctx, http.MethodGet, p, url.Values{"hash": {algorithm}}, nil, nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Hash, nil
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks that the server answers a Stat of the root.
+func (e *Engine) Ping(ctx context.Context) error {
+	_, err := e.Stat(ctx, "")
+	return err
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine = (*Engine)(nil)
+	_ sbox.Copier        = (*Engine)(nil)
+	_ sbox.StreamReader  = (*Engine)(nil)
+	_ sbox.StreamWriter  = (*Engine)(nil)
+	_ sbox.RangeReader   = (*Engine)(nil)
+	_ sbox.Appender      = (*Engine)(nil)
+	_ sbox.Hasher        = (*Engine)(nil)
+	_ sbox.HealthChecker = (*Engine)(nil)
+)
@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithToken sends token as a bearer token with every request, for servers
+// configured with WithServerToken.
+func WithToken(token string) Option {
+	return func(e *Engine) {
+		e.token = token
+	}
+}
+
+// WithHTTPClient sets the client used for requests, e.g. to configure
+// timeouts, TLS or proxies. The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Engine) {
+		e.client = client
+	}
+}
+
+// ServerOption configures optional Handler behavior.
+type ServerOption func(*Handler)
+
+// WithServerToken rejects requests that do not carry token as a bearer
+// token with 401 Unauthorized. Serve over HTTPS, or the token is sent in
+// the clear.
+func WithServerToken(token string) ServerOption {
+	return func(h *Handler) {
+		h.token = token
+	}
+}
+
+// WithLogger sets the logger for failed requests. The default is
+// slog.Default().
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
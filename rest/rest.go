@@ -0,0 +1,349 @@
+// Package rest serves an sbox.StorageEngine over plain HTTP with JSON
+// responses, and provides the "rest" driver, its client. It does the job of
+// package grpc for deployments behind reverse proxies, load balancers and
+// caches that only speak HTTP/1.1:
+//
+//	// Server
+//	http.Handle("/storage/", rest.NewHandler(engine, "/storage", rest.WithServerToken(token)))
+//
+//	// Clients
+//	remote, _ := sbox.OpenURL("rest://storage.example.com/storage?token=" + token)
+//
+// Each engine path is a URL below the prefix. The API is:
+//
+//	GET    /p              file content; supports Range and conditional headers
+//	GET    /p?stat         EntryInfo as JSON
+//	GET    /p?list         directory entries as a JSON array of EntryInfo
+//	GET    /p?hash=sha256  {"hash": "..."}
+//	PUT    /p              replace the file atomically with the body
+//	PUT    /p?append       append the body to the file
+//	DELETE /p              remove the file or directory tree
+//	POST   /p?mkdir        create the directory and its parents
+//	POST   /p?rename=/q    rename p to q
+//	POST   /p?copy=/q      copy p to q
+//
+// PUT responds with {"written": n}. A PUT with "If-None-Match: *" only
+// creates files that do not exist, and X-Sbox-Meta-* headers set the
+// metadata of the file. Errors are JSON objects, {"error": message, "code":
+// kind}, where kind names the sbox error, e.g. "not-found".
+package rest
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// metaHeaderPrefix starts the request headers holding file metadata.
+const metaHeaderPrefix = "X-Sbox-Meta-"
+
+// Request headers carrying the sbox.FileOptions of uploads, besides
+// Content-Type and Cache-Control.
+const (
+	expectedSizeHeader = "X-Sbox-Expected-Size"
+	checksumHeader     = "X-Sbox-Checksum" // "<algorithm>=<hex digest>"
+	detectTypeHeader   = "X-Sbox-Detect-Content-Type"
+)
+
+// setFileOptions sets the headers of an upload for o.
+func setFileOptions(h http.Header, o sbox.FileOptions) {
+	if o.ContentType != "" {
+		h.Set("Content-Type", o.ContentType)
+	}
+	if o.CacheControl != "" {
+		h.Set("Cache-Control", o.CacheControl)
+	}
+	if o.ExpectedSize >= 0 {
+		h.Set(expectedSizeHeader, strconv.FormatInt(o.ExpectedSize, 10))
+	}
+	if o.Checksum != "" {
+		h.Set(checksumHeader, o.ChecksumAlgorithm+"="+o.Checksum)
+	}
+	if o.DetectContentType {
+		h.Set(detectTypeHeader, "true")
+	}
+}
+
+// fileOptions returns the sbox.FileOptions set by the headers of an
+// upload.
+func fileOptions(h http.Header) []sbox.FileOption {
+	var opts []sbox.FileOption
+	if v := h.Get("Content-Type"); v != "" {
+		opts = append(opts, sbox.WithContentType(v))
+	}
+	if v := h.Get("Cache-Control"); v != "" {
+		opts = append(opts, sbox.WithCacheControl(v))
+	}
+	if n, err := strconv.ParseInt(h.Get(expectedSizeHeader), 10, 64); err == nil && n >= 0 {
+		opts = append(opts, sbox.WithExpectedSize(n))
+	}
+	if algorithm, digest, ok := strings.Cut(h.Get(checksumHeader), "="); ok {
+		opts = append(opts, sbox.WithChecksum(algorithm, digest))
+	}
+	if h.Get(detectTypeHeader) == "true" {
+		opts = append(opts, sbox.WithDetectedContentType())
+	}
+	return opts
+}
+
+// errorKinds maps the sbox errors to HTTP statuses and to the kinds sent
+// in error responses, which let the client restore errors that share a
+// status.
+var errorKinds = []struct {
+	err    error
+	status int
+	name   string
+}{
+	{sbox.ErrNotFound, http.StatusNotFound, "not-found"},
+	{sbox.ErrExist, http.StatusConflict, "exist"},
+	{sbox.ErrPermission, http.StatusForbidden, "permission"},
+	{sbox.ErrInvalid, http.StatusBadRequest, "invalid"},
+	{sbox.ErrIsDir, http.StatusConflict, "is-dir"},
+	{sbox.ErrNotDir, http.StatusConflict, "not-dir"},
+	{sbox.ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition-failed"},
+	{sbox.ErrLocked, http.StatusLocked, "locked"},
+	{sbox.ErrNotSupported, http.StatusNotImplemented, "not-supported"},
+	{sbox.ErrCorruptManifest, http.StatusInternalServerError, "corrupt-manifest"},
+	{sbox.ErrChecksumMismatch, http.StatusBadRequest, "checksum-mismatch"},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "deadline-exceeded"},
+}
+
+// errorResponse is the body of an error response.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Handler serves an engine over the REST API.
+type Handler struct {
+	engine sbox.StorageEngine
+	prefix string
+	token  string
+	logger *slog.Logger
+}
+
+// NewHandler returns a Handler serving engine under the URL path prefix.
+func NewHandler(engine sbox.StorageEngine, prefix string, opts ...ServerOption) *Handler {
+	h := &Handler{engine: engine, prefix: strings.TrimSuffix(prefix, "/"), logger: slog.Default()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// enginePath converts a slash-separated URL path to an engine path. The
+// root is represented by the empty string.
+func enginePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// ServeHTTP handles a request of the REST API.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sbox"`)
+		h.writeJSON(w, http.StatusUnauthorized, &errorResponse{Error: "invalid or missing token", Code: "permission"})
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, h.prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		h.error(w, r, fmt.Errorf("sbox/rest: %s is outside %s: %w", r.URL.Path, h.prefix, sbox.ErrNotFound))
+		return
+	}
+	p := enginePath(rest)
+	q := r.URL.Query()
+
+	var err error
+	switch {
+	case r.Method == http.MethodGet && q.Has("stat"):
+		err = h.stat(w, r, p)
+	case r.Method == http.MethodGet && q.Has("list"):
+		err = h.list(w, r, p)
+	case r.Method == http.MethodGet && q.Has("hash"):
+		err = h.hash(w, r, p, q.Get("hash"))
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		err = h.get(w, r, p)
+	case r.Method == http.MethodPut:
+		err = h.put(w, r, p, q.Has("append"))
+	case r.Method == http.MethodDelete:
+		err = h.engine.Remove(r.Context(), p)
+	case r.Method == http.MethodPost && q.Has("mkdir"):
+		err = h.engine.MkdirAll(r.Context(), p)
+	case r.Method == http.MethodPost && q.Has("rename"):
+		err = h.engine.Rename(r.Context(), p, enginePath(q.Get("rename")))
+	case r.Method == http.MethodPost && q.Has("copy"):
+		err = sbox.Copied(h.engine).Copy(r.Context(), p, enginePath(q.Get("copy")))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE, POST")
+		h.writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Error: "unsupported request", Code: "not-supported"})
+		return
+	}
+	switch {
+	case err != nil:
+		h.error(w, r, err)
+	case r.Method == http.MethodDelete || r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) stat(w http.ResponseWriter, r *http.Request, p string) error {
+	info, err := h.engine.Stat(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(w, http.StatusOK, info)
+	return nil
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, p string) error {
+	entries, err := h.engine.ReadDir(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []*sbox.EntryInfo{}
+	}
+	h.writeJSON(w, http.StatusOK, entries)
+	return nil
+}
+
+func (h *Handler) hash(w http.ResponseWriter, r *http.Request, p, algorithm string) error {
+	sum, err := sbox.Hashed(h.engine).Hash(r.Context(), p, algorithm)
+	if err != nil {
+		return err
+	}
+	h.writeJSON(w, http.StatusOK, map[string]string{"hash": sum})
+	return nil
+}
+
+// get serves the content of a file. Ranges and conditional headers are
+// handled by http.ServeContent.
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, p string) error {
+	ctx := r.Context()
+	info, err := h.engine.Stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return sbox.ErrIsDir
+	}
+	f, err := h.engine.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", info.ModTime, f)
+	return nil
+}
+
+// put stores the request body. Replacements are atomic, so a request that
+// is cut off leaves the old content in place.
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, p string, appending bool) error {
+	ctx := r.Context()
+	var md map[string]string
+	for k, v := range r.Header {
+		if key, ok := strings.CutPrefix(k, metaHeaderPrefix); ok && len(v) > 0 {
+			if md == nil {
+				md = make(map[string]string)
+			}
+			md[strings.ToLower(key)] = v[0]
+		}
+	}
+	if md != nil {
+		ctx = sbox.WithMetadata(ctx, md)
+	}
+	ctx = sbox.WithFileOptions(ctx, fileOptions(r.Header)...)
+
+	body := &countingReader{r: r.Body}
+	var err error
+	switch {
+	case appending:
+		_, err = sbox.Append(ctx, h.engine, p, body)
+	case r.Header.Get("If-None-Match") == "*":
+		err = h.create(ctx, p, body)
+	default:
+		err = sbox.PutAtomic(ctx, h.engine, p, body)
+	}
+	if err != nil {
+		return err
+	}
+	h.writeJSON(w, http.StatusOK, map[string]int64{"written": body.n})
+	return nil
+}
+
+// create writes a file that must not exist yet.
+func (h *Handler) create(ctx context.Context, p string, r io.Reader) error {
+	f, err := h.engine.OpenFile(ctx, p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = h.engine.Remove(context.WithoutCancel(ctx), p)
+	}
+	return err
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("sbox/rest: encode response", "err", err)
+	}
+}
+
+// error writes the error response for err.
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	status, name := http.StatusInternalServerError, ""
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			status, name = k.status, k.name
+			break
+		}
+	}
+	if name == "" {
+		h.logger.Error("sbox/rest: request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	h.writeJSON(w, status, &errorResponse{Error: err.Error(), Code: name})
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Compile-time interface checks.
+var _ http.Handler = (*Handler)(nil)
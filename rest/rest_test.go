@@ -0,0 +1,224 @@
+package rest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/rest"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+// newClient serves a memory engine under prefix and returns a client for it.
+func newClient(t *testing.T, prefix string) (*rest.Engine, sbox.StorageEngine) {
+	t.Helper()
+	backend := memory.New()
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", rest.NewHandler(backend, prefix))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	engine, err := rest.New(srv.URL + prefix)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return engine, backend
+}
+
+func TestClient_Suite(t *testing.T) {
+	engine, _ := newClient(t, "/api/files")
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestClient_Operations(t *testing.T) {
+	ctx := context.Background()
+	engine, backend := newClient(t, "/storage")
+
+	data := strings.Repeat("0123456789", 100_000)
+	if err := engine.Put(ctx, "deep/dir/a b.txt", strings.NewReader(data)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	info, err := backend.Stat(ctx, "deep/dir/a b.txt")
+	if err != nil || info.Size != int64(len(data)) {
+		t.Fatalf("backend Stat = %+v, %v", info, err)
+	}
+	rc, err := engine.GetRange(ctx, "deep/dir/a b.txt", 300_001, 5)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != "12345" {
+		t.Errorf("GetRange = %q, want %q", got, "12345")
+	}
+
+	n, err := engine.Append(ctx, "log", strings.NewReader("one\n"))
+	if err != nil || n != 4 {
+		t.Fatalf("Append = %d, %v", n, err)
+	}
+	w, err := engine.OpenFile(ctx, "log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = w.Write([]byte("two\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if info, err := engine.Stat(ctx, "log"); err != nil || info.Size != 8 {
+		t.Errorf("Stat(log) = %+v, %v", info, err)
+	}
+	if sum, err := engine.Hash(ctx, "log", "sha256"); err != nil || len(sum) != 64 {
+		t.Errorf("Hash = %q, %v", sum, err)
+	}
+	w, err = engine.OpenFile(ctx, "log", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(O_EXCL): %v", err)
+	}
+	if err := w.Close(); !errors.Is(err, sbox.ErrExist) {
+		t.Errorf("exclusive create of an existing file = %v, want %v", err, sbox.ErrExist)
+	}
+
+	if _, err := engine.Stat(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := engine.Open(ctx, "deep"); !errors.Is(err, sbox.ErrIsDir) {
+		t.Errorf("Open(dir) = %v, want %v", err, sbox.ErrIsDir)
+	}
+	if err := engine.Copy(ctx, "deep", "copy"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if err := engine.Rename(ctx, "copy/dir/a b.txt", "moved/b.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	entries, err := engine.ReadDir(ctx, "moved")
+	if err != nil || len(entries) != 1 || entries[0].Name != "b.txt" || entries[0].Size != int64(len(data)) {
+		t.Errorf("ReadDir = %+v, %v", entries, err)
+	}
+}
+
+// failingReader returns its data and then an error.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("source failed")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestClient_FailedPut(t *testing.T) {
+	ctx := context.Background()
+	engine, backend := newClient(t, "")
+	if err := engine.Put(ctx, "f", strings.NewReader("old")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := engine.Put(ctx, "f", &failingReader{data: "partial"}); err == nil {
+		t.Fatal("Put from a failing reader succeeded")
+	}
+	r, err := backend.Open(ctx, "f")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "old" {
+		t.Errorf("content after a failed Put = %q, want %q", got, "old")
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	srv := httptest.NewServer(rest.NewHandler(memory.New(), "", rest.WithServerToken("secret")))
+	defer srv.Close()
+	ctx := context.Background()
+
+	dsn := "rest://" + strings.TrimPrefix(srv.URL, "http://") + "/?scheme=http&token=secret"
+	engine, err := sbox.OpenURL(dsn)
+	if err != nil {
+		t.Fatalf("OpenURL: %v", err)
+	}
+	if err := engine.MkdirAll(ctx, "docs"); err != nil {
+		t.Errorf("MkdirAll: %v", err)
+	}
+
+	anonymous, err := sbox.Open(&sbox.Config{Type: "rest", BasePath: srv.URL})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := anonymous.Stat(ctx, "docs"); !errors.Is(err, sbox.ErrPermission) {
+		t.Errorf("Stat without token = %v, want %v", err, sbox.ErrPermission)
+	}
+}
+
+func TestClient_Metadata(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(rest.NewHandler(sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4), ""))
+	defer srv.Close()
+	engine, err := rest.New(srv.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	w, err := engine.Create(sbox.WithMetadata(ctx, map[string]string{"owner": "ops"}), "f")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, _ = w.Write([]byte("data"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	info, err := engine.Stat(ctx, "f")
+	if err != nil || info.Metadata["owner"] != "ops" {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+}
+
+func TestClient_FileOptions(t *testing.T) {
+	ctx := context.Background()
+	var got sbox.FileOptions
+	backend := sbox.WithHooks(memory.New(), sbox.Hooks{
+		BeforeWrite: func(ctx context.Context, _ string) error {
+			got = sbox.FileOptionsFromContext(ctx)
+			return nil
+		},
+	})
+	srv := httptest.NewServer(rest.NewHandler(backend, ""))
+	t.Cleanup(srv.Close)
+	engine, err := rest.New(srv.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	w, err := sbox.CreateWith(ctx, engine, "page.html",
+		sbox.WithContentType("text/html"), sbox.WithCacheControl("max-age=60"),
+		sbox.WithExpectedSize(6), sbox.WithChecksum("md5", "ABC123"))
+	if err != nil {
+		t.Fatalf("CreateWith: %v", err)
+	}
+	_, _ = io.WriteString(w, "<html>")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	want := sbox.FileOptions{
+		ContentType:       "text/html",
+		CacheControl:      "max-age=60",
+		ExpectedSize:      6,
+		ChecksumAlgorithm: "md5",
+		Checksum:          "abc123",
+	}
+	if got != want {
+		t.Errorf("server engine saw %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,322 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Router is a StorageEngine that mounts other engines at path prefixes and
+// dispatches every call to the engine owning the path, with the mount
+// prefix stripped:
+//
+//	r := sbox.NewRouter(map[string]sbox.StorageEngine{
+//	    "/hot":     localEngine,
+//	    "/archive": rcloneEngine,
+//	})
+//	w, _ := r.Create(ctx, "archive/2024/report.pdf") // archive engine, "2024/report.pdf"
+//
+// The longest matching prefix wins, and a mount at "" or "/" catches every
+// path not covered by another mount. Directories leading to mount points
+// exist implicitly. Rename and Copy between mounts fall back to copying
+// the data through the router.
+type Router struct {
+	mounts []mount // longest prefix first
+}
+
+type mount struct {
+	prefix string
+	engine StorageEngine
+}
+
+// NewRouter returns a Router serving the given mounts, keyed by prefix.
+func NewRouter(mounts map[string]StorageEngine) *Router {
+	r := &Router{}
+	for prefix, engine := range mounts {
+		r.mounts = append(r.mounts, mount{prefix: cleanRoutePath(prefix), engine: engine})
+	}
+	sort.Slice(r.mounts, func(i, j int) bool {
+		return len(r.mounts[i].prefix) > len(r.mounts[j].prefix)
+	})
+	return r
+}
+
+// cleanRoutePath normalizes p to a slash-separated path without leading or
+// trailing slashes. The root is "".
+func cleanRoutePath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// under reports whether p is prefix or lies below it.
+func under(p, prefix string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// resolve returns the mount owning p and the path within it.
+func (r *Router) resolve(p string) (*mount, string, bool) {
+	p = cleanRoutePath(p)
+	for i := range r.mounts {
+		m := &r.mounts[i]
+		if under(p, m.prefix) {
+			return m, strings.TrimPrefix(strings.TrimPrefix(p, m.prefix), "/"), true
+		}
+	}
+	return nil, "", false
+}
+
+// children returns the names of mount points directly or indirectly below
+// the virtual directory p, one path component deep.
+func (r *Router) children(p string) []string {
+	p = cleanRoutePath(p)
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range r.mounts {
+		if m.prefix == p || !under(m.prefix, p) {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(m.prefix, p), "/"), "/")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// virtualDir describes a directory that exists only because mount points
+// lie below it.
+func virtualDir(p string) *EntryInfo {
+	p = cleanRoutePath(p)
+	name := path.Base(p)
+	if p == "" {
+		name = "/"
+	}
+	return &EntryInfo{Name: name, Path: p, IsDir: true, Mode: os.ModeDir | 0755}
+}
+
+// mountRoot reports whether p is exactly a mount point or a virtual
+// directory above one; such paths cannot be removed or renamed.
+func (r *Router) mountRoot(p string) bool {
+	m, rel, ok := r.resolve(p)
+	return (ok && rel == "" && m.prefix == cleanRoutePath(p)) || len(r.children(p)) > 0
+}
+
+// outer maps an entry of m back into the router's namespace.
+func outer(m *mount, e *EntryInfo, rel string) *EntryInfo {
+	c := *e
+	inner := e.Path
+	if inner == "" {
+		inner = rel
+	}
+	c.Path = strings.TrimPrefix(path.Join(m.prefix, cleanRoutePath(inner)), "/")
+	if cleanRoutePath(rel) == "" && m.prefix != "" {
+		c.Name = path.Base(m.prefix)
+	}
+	return &c
+}
+
+func (r *Router) Stat(ctx context.Context, p string) (*EntryInfo, error) {
+	m, rel, ok := r.resolve(p)
+	if ok {
+		info, err := m.engine.Stat(ctx, rel)
+		if err == nil {
+			return outer(m, info, rel), nil
+		}
+		if len(r.children(p)) == 0 {
+			return nil, err
+		}
+	}
+	if len(r.children(p)) > 0 {
+		return virtualDir(p), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *Router) Open(ctx context.Context, p string) (ReadSeekCloser, error) {
+	m, rel, ok := r.resolve(p)
+	if !ok {
+		if len(r.children(p)) > 0 {
+			return nil, ErrIsDir
+		}
+		return nil, ErrNotFound
+	}
+	return m.engine.Open(ctx, rel)
+}
+
+func (r *Router) Create(ctx context.Context, p string) (WriteCloser, error) {
+	if len(r.children(p)) > 0 {
+		return nil, ErrIsDir
+	}
+	m, rel, ok := r.resolve(p)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.engine.Create(ctx, rel)
+}
+
+func (r *Router) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (WriteSeekCloser, error) {
+	if len(r.children(p)) > 0 {
+		return nil, ErrIsDir
+	}
+	m, rel, ok := r.resolve(p)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.engine.OpenFile(ctx, rel, flag, perm)
+}
+
+// Remove removes a file or directory inside a mount. Mount points and the
+// directories leading to them cannot be removed.
+func (r *Router) Remove(ctx context.Context, p string) error {
+	if r.mountRoot(p) {
+		return ErrPermission
+	}
+	m, rel, ok := r.resolve(p)
+	if !ok {
+		return ErrNotFound
+	}
+	return m.engine.Remove(ctx, rel)
+}
+
+// Rename moves oldPath to newPath. Within one mount it uses the engine's
+// Rename; across mounts it moves the tree with [Move].
+func (r *Router) Rename(ctx context.Context, oldPath, newPath string) error {
+	if r.mountRoot(oldPath) || r.mountRoot(newPath) {
+		return ErrPermission
+	}
+	src, srcRel, ok := r.resolve(oldPath)
+	if !ok {
+		return ErrNotFound
+	}
+	dst, dstRel, ok := r.resolve(newPath)
+	if !ok {
+		return ErrNotFound
+	}
+	if src == dst {
+		return src.engine.Rename(ctx, srcRel, dstRel)
+	}
+	return Move(ctx, src.engine, srcRel, dst.engine, dstRel)
+}
+
+func (r *Router) MkdirAll(ctx context.Context, p string) error {
+	m, rel, ok := r.resolve(p)
+	if !ok || rel == "" {
+		if ok || len(r.children(p)) > 0 {
+			return nil
+		}
+		return ErrNotFound
+	}
+	return m.engine.MkdirAll(ctx, rel)
+}
+
+// ReadDir lists p. Mount points below p appear as directories, merged with
+// the entries of the engine owning p, if any.
+func (r *Router) ReadDir(ctx context.Context, p string) ([]*EntryInfo, error) {
+	var result []*EntryInfo
+	seen := make(map[string]bool)
+
+	m, rel, ok := r.resolve(p)
+	if ok {
+		entries, err := m.engine.ReadDir(ctx, rel)
+		if err != nil && len(r.children(p)) == 0 {
+			return nil, err
+		}
+		for _, e := range entries {
+			seen[e.Name] = true
+			result = append(result, outer(m, e, path.Join(rel, e.Name)))
+		}
+	}
+
+	children := r.children(p)
+	if !ok && len(children) == 0 {
+		return nil, ErrNotFound
+	}
+	for _, name := range children {
+		if !seen[name] {
+			result = append(result, virtualDir(path.Join(cleanRoutePath(p), name)))
+		}
+	}
+	return result, nil
+}
+
+// Close closes every mounted engine that implements io.Closer.
+func (r *Router) Close() error {
+	var errs []error
+	for _, m := range r.mounts {
+		errs = append(errs, Close(m.engine))
+	}
+	return errors.Join(errs...)
+}
+
+// === Extension: Copier ===
+
+// Copy copies src to dst, using the engine's Copier when both paths are in
+// the same mount and copying through the router otherwise.
+func (r *Router) Copy(ctx context.Context, src, dst string) error {
+	if r.mountRoot(dst) {
+		return ErrPermission
+	}
+	s, srcRel, ok := r.resolve(src)
+	if !ok {
+		return ErrNotFound
+	}
+	d, dstRel, ok := r.resolve(dst)
+	if !ok {
+		return ErrNotFound
+	}
+	if s == d {
+		if c, ok := s.engine.(Copier); ok {
+			err := c.Copy(ctx, srcRel, dstRel)
+			if !errors.Is(err, ErrNotSupported) {
+				return err
+			}
+		}
+	}
+	return CopyTree(ctx, s.engine, srcRel, d.engine, dstRel, CopyTreeOptions{})
+}
+
+// === Extension: StreamReader ===
+
+func (r *Router) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	m, rel, ok := r.resolve(p)
+	if ok {
+		if sr, ok := m.engine.(StreamReader); ok {
+			return sr.Get(ctx, rel)
+		}
+	}
+	return r.Open(ctx, p)
+}
+
+// === Extension: StreamWriter ===
+
+func (r *Router) Put(ctx context.Context, p string, reader io.Reader) error {
+	m, rel, ok := r.resolve(p)
+	if ok && len(r.children(p)) == 0 {
+		if sw, ok := m.engine.(StreamWriter); ok {
+			return sw.Put(ctx, rel, reader)
+		}
+	}
+	w, err := r.Create(ctx, p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Compile-time interface checks.
+var (
+	_ StorageEngine = (*Router)(nil)
+	_ Copier        = (*Router)(nil)
+	_ StreamReader  = (*Router)(nil)
+	_ StreamWriter  = (*Router)(nil)
+	_ io.Closer     = (*Router)(nil)
+)
@@ -0,0 +1,137 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func routerRead(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func routerWrite(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	if err := sbox.PutAtomic(context.Background(), engine, path, strings.NewReader(content)); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestRouter_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, sbox.NewRouter(map[string]sbox.StorageEngine{
+		"/":           memory.New(),
+		"/mnt/hot":    memory.New(),
+		"/mnt/backup": memory.New(),
+	}))
+}
+
+func TestRouter_Dispatch(t *testing.T) {
+	hot, archive := memory.New(), memory.New()
+	r := sbox.NewRouter(map[string]sbox.StorageEngine{
+		"/hot":          hot,
+		"/archive/":     archive,
+		"archive/cold/": memory.New(),
+	})
+	ctx := context.Background()
+
+	routerWrite(t, r, "/hot/a.txt", "hot data")
+	routerWrite(t, r, "archive/2024/b.txt", "archived")
+
+	if got := routerRead(t, hot, "a.txt"); got != "hot data" {
+		t.Errorf("hot engine a.txt = %q", got)
+	}
+	if got := routerRead(t, archive, "2024/b.txt"); got != "archived" {
+		t.Errorf("archive engine 2024/b.txt = %q", got)
+	}
+
+	info, err := r.Stat(ctx, "archive/2024/b.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Path != "archive/2024/b.txt" {
+		t.Errorf("Stat path = %q, want router path", info.Path)
+	}
+
+	// Paths outside every mount do not exist.
+	if _, err := r.Create(ctx, "elsewhere/c.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Create outside mounts = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	// The root is a virtual directory listing the mounts.
+	entries, err := r.ReadDir(ctx, "")
+	if err != nil {
+		t.Fatalf("ReadDir root: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir {
+			t.Errorf("%s is not a directory", e.Name)
+		}
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "archive,hot" {
+		t.Errorf("root entries = %v", names)
+	}
+
+	// A nested mount shows up in its parent mount's listing.
+	entries, err = r.ReadDir(ctx, "archive")
+	if err != nil {
+		t.Fatalf("ReadDir archive: %v", err)
+	}
+	names = names[:0]
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "2024,cold" {
+		t.Errorf("archive entries = %v", names)
+	}
+
+	if err := r.Remove(ctx, "hot"); !errors.Is(err, sbox.ErrPermission) {
+		t.Errorf("Remove mount point = %v, want %v", err, sbox.ErrPermission)
+	}
+}
+
+func TestRouter_CrossMount(t *testing.T) {
+	hot, archive := memory.New(), memory.New()
+	r := sbox.NewRouter(map[string]sbox.StorageEngine{"hot": hot, "archive": archive})
+	ctx := context.Background()
+
+	routerWrite(t, r, "hot/project/a.txt", "a")
+	routerWrite(t, r, "hot/project/sub/b.txt", "b")
+
+	if err := r.Copy(ctx, "hot/project/a.txt", "archive/a-copy.txt"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if got := routerRead(t, archive, "a-copy.txt"); got != "a" {
+		t.Errorf("copied file = %q", got)
+	}
+
+	if err := r.Rename(ctx, "hot/project", "archive/project"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got := routerRead(t, r, "archive/project/sub/b.txt"); got != "b" {
+		t.Errorf("moved file = %q", got)
+	}
+	if _, err := hot.Stat(ctx, "project"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("source after cross-mount rename: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package sboxtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+// Concurrency checks that engine can be used from several goroutines at
+// once: writes of different files, reads of one file while it is being
+// overwritten, creating the same directories, and listing a directory
+// while its files are written, renamed and removed. Run it with -race to
+// find data races in the driver.
+func Concurrency(t *testing.T, engine sbox.StorageEngine, opts Options) {
+	t.Helper()
+	s := newSuite(t, "Concurrency", engine, opts)
+	ctx := context.Background()
+	n := opts.Concurrency
+	if n <= 0 {
+		n = DefaultConcurrency
+	}
+
+	s.run("Writes", func(t *testing.T) {
+		defer func() { _ = engine.Remove(ctx, "concurrency") }()
+		errs := parallel(n, func(i int) error {
+			p := fmt.Sprintf("concurrency/w%d/file.txt", i)
+			w, err := engine.Create(ctx, p)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, strings.Repeat(fmt.Sprint(i), 1000)); err != nil {
+				_ = w.Close()
+				return err
+			}
+			return w.Close()
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("writer %d: %v", i, err)
+			}
+		}
+		for i := range n {
+			p := fmt.Sprintf("concurrency/w%d/file.txt", i)
+			if got, want := readAll(t, engine, p), strings.Repeat(fmt.Sprint(i), 1000); got != want {
+				t.Errorf("%s holds %d bytes, want %d", p, len(got), len(want))
+			}
+		}
+		entries, err := engine.ReadDir(ctx, "concurrency")
+		if err != nil || len(entries) != n {
+			t.Errorf("ReadDir after concurrent writes = %d entries, %v; want %d", len(entries), err, n)
+		}
+	})
+
+	s.run("Reads", func(t *testing.T) {
+		const path = "concurrency_read.txt"
+		content := strings.Repeat("0123456789", 10000)
+		write(t, engine, path, content)
+		defer func() { _ = engine.Remove(ctx, path) }()
+		errs := parallel(n, func(int) error {
+			r, err := engine.Open(ctx, path)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = r.Close() }()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if string(data) != content {
+				return fmt.Errorf("read %d bytes, want %d", len(data), len(content))
+			}
+			return nil
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("reader %d: %v", i, err)
+			}
+		}
+	})
+
+	s.run("MkdirAll", func(t *testing.T) {
+		defer func() { _ = engine.Remove(ctx, "concurrency_dirs") }()
+		errs := parallel(n, func(int) error {
+			return engine.MkdirAll(ctx, "concurrency_dirs/a/b/c")
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("MkdirAll %d: %v", i, err)
+			}
+		}
+		if info, err := engine.Stat(ctx, "concurrency_dirs/a/b/c"); err != nil || !info.IsDir {
+			t.Errorf("Stat after concurrent MkdirAll = %+v, %v", info, err)
+		}
+	})
+
+	s.run("ReadDirWhileWriting", func(t *testing.T) {
+		defer func() { _ = engine.Remove(ctx, "concurrency_list") }()
+		if err := engine.MkdirAll(ctx, "concurrency_list"); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		errs := parallel(n, func(i int) error {
+			if i%2 == 0 {
+				_, err := engine.ReadDir(ctx, "concurrency_list")
+				return err
+			}
+			w, err := engine.Create(ctx, fmt.Sprintf("concurrency_list/f%d.txt", i))
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("goroutine %d: %v", i, err)
+			}
+		}
+	})
+
+	s.run("ReadsDuringWrites", func(t *testing.T) {
+		const path = "concurrency_overwrite.txt"
+		version := func(i int) string { return strings.Repeat(string(rune('a'+i%26)), 10000) }
+		write(t, engine, path, version(0))
+		defer func() { _ = engine.Remove(ctx, path) }()
+		errs := parallel(n, func(i int) error {
+			if i == 0 {
+				// One writer, so the file always ends as its last version.
+				for v := 1; v <= n; v++ {
+					w, err := engine.Create(ctx, path)
+					if err != nil {
+						return err
+					}
+					if _, err := io.WriteString(w, version(v)); err != nil {
+						_ = w.Close()
+						return err
+					}
+					if err := w.Close(); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			for range n {
+				r, err := engine.Open(ctx, path)
+				if errors.Is(err, sbox.ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				data, err := io.ReadAll(r)
+				_ = r.Close()
+				if err != nil {
+					return err
+				}
+				if len(data) > 10000 || strings.Trim(string(data), "abcdefghijklmnopqrstuvwxyz") != "" {
+					return fmt.Errorf("read %d bytes that no writer wrote", len(data))
+				}
+			}
+			return nil
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("goroutine %d: %v", i, err)
+			}
+		}
+		if got := readAll(t, engine, path); got != version(n) {
+			t.Errorf("content after the writes is %d bytes, not the last version", len(got))
+		}
+	})
+
+	s.run("ReadDirDuringRename", func(t *testing.T) {
+		const dir = "concurrency_rename"
+		defer func() { _ = engine.Remove(ctx, dir) }()
+		for i := range n {
+			write(t, engine, fmt.Sprintf("%s/f%d.txt", dir, i), fmt.Sprint(i))
+		}
+		errs := parallel(2*n, func(i int) error {
+			if i%2 == 0 {
+				_, err := engine.ReadDir(ctx, dir)
+				return err
+			}
+			return engine.Rename(ctx, fmt.Sprintf("%s/f%d.txt", dir, i/2), fmt.Sprintf("%s/g%d.txt", dir, i/2))
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("goroutine %d: %v", i, err)
+			}
+		}
+		entries, err := engine.ReadDir(ctx, dir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		if len(entries) != n {
+			t.Errorf("ReadDir after renames = %d entries, want %d", len(entries), n)
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name, "g") {
+				t.Errorf("entry %q was not renamed", e.Name)
+			}
+		}
+	})
+
+	s.run("Removes", func(t *testing.T) {
+		const dir = "concurrency_remove"
+		defer func() { _ = engine.Remove(ctx, dir) }()
+		for i := range n {
+			write(t, engine, fmt.Sprintf("%s/f%d.txt", dir, i), fmt.Sprint(i))
+		}
+		errs := parallel(2*n, func(i int) error {
+			if i%2 == 0 {
+				_, err := engine.ReadDir(ctx, dir)
+				return err
+			}
+			return engine.Remove(ctx, fmt.Sprintf("%s/f%d.txt", dir, i/2))
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("goroutine %d: %v", i, err)
+			}
+		}
+		if entries, err := engine.ReadDir(ctx, dir); err == nil && len(entries) != 0 {
+			t.Errorf("ReadDir after removes = %v, want none", entries)
+		}
+	})
+}
+
+// parallel runs fn(0) to fn(n-1) in their own goroutines, released at
+// once, and returns their errors.
+func parallel(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,261 @@
+package sboxtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+// Core checks the StorageEngine methods every driver must support: reads,
+// writes, directories, renames, appends and the errors for missing paths.
+func Core(t *testing.T, engine sbox.StorageEngine, opts Options) {
+	t.Helper()
+	s := newSuite(t, "Core", engine, opts)
+	ctx := context.Background()
+
+	s.run("Create_Open_Stat_Remove", func(t *testing.T) {
+		path := "test/hello.txt"
+		content := "hello world"
+
+		// Create
+		w, err := engine.Create(ctx, path)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, writeErr := io.WriteString(w, content); writeErr != nil {
+			t.Fatalf("Write: %v", writeErr)
+		}
+		if closeErr := w.Close(); closeErr != nil {
+			t.Fatalf("Close writer: %v", closeErr)
+		}
+
+		// Stat
+		info, err := engine.Stat(ctx, path)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if info.Name != "hello.txt" {
+			t.Errorf("Name = %q, want %q", info.Name, "hello.txt")
+		}
+		if info.Size != int64(len(content)) {
+			t.Errorf("Size = %d, want %d", info.Size, len(content))
+		}
+		if info.IsDir {
+			t.Error("IsDir = true, want false")
+		}
+
+		// Open + Read
+		r, err := engine.Open(ctx, path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		_ = r.Close()
+		if string(data) != content {
+			t.Errorf("content = %q, want %q", string(data), content)
+		}
+
+		// Seek
+		r2, err := engine.Open(ctx, path)
+		if err != nil {
+			t.Fatalf("Open for seek: %v", err)
+		}
+		if _, seekErr := r2.Seek(6, io.SeekStart); seekErr != nil {
+			t.Fatalf("Seek: %v", seekErr)
+		}
+		partial, _ := io.ReadAll(r2)
+		_ = r2.Close()
+		if string(partial) != "world" {
+			t.Errorf("after seek = %q, want %q", string(partial), "world")
+		}
+
+		// Remove
+		if removeErr := engine.Remove(ctx, path); removeErr != nil {
+			t.Fatalf("Remove: %v", removeErr)
+		}
+		_, err = engine.Stat(ctx, path)
+		if err == nil {
+			t.Error("Stat after Remove: expected error, got nil")
+		}
+	})
+
+	s.run("Errors", func(t *testing.T) {
+		_, err := engine.Stat(ctx, "missing/file.txt")
+		var pe *sbox.PathError
+		if !errors.As(err, &pe) || !errors.Is(err, sbox.ErrNotFound) {
+			t.Fatalf("Stat of a missing file = %v, want an *sbox.PathError matching sbox.ErrNotFound", err)
+		}
+		if pe.Op != "stat" || pe.Driver == "" {
+			t.Errorf("Stat error Op = %q, Driver = %q", pe.Op, pe.Driver)
+		}
+	})
+
+	s.run("NotFound", func(t *testing.T) {
+		const missing = "missing/file.txt"
+		for op, err := range map[string]error{
+			"Stat":    second(engine.Stat(ctx, missing)),
+			"Open":    second(engine.Open(ctx, missing)),
+			"ReadDir": second(engine.ReadDir(ctx, "missing/dir")),
+			"Remove":  engine.Remove(ctx, missing),
+			"Rename":  engine.Rename(ctx, missing, "missing/renamed.txt"),
+		} {
+			if !errors.Is(err, sbox.ErrNotFound) {
+				t.Errorf("%s of a missing path = %v, want sbox.ErrNotFound", op, err)
+			}
+		}
+	})
+
+	s.run("MkdirAll_ReadDir", func(t *testing.T) {
+		dir := "test/dirops"
+		if err := engine.MkdirAll(ctx, dir); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+
+		// Create files
+		for _, name := range []string{"a.txt", "b.txt"} {
+			w, err := engine.Create(ctx, dir+"/"+name)
+			if err != nil {
+				t.Fatalf("Create %s: %v", name, err)
+			}
+			_, _ = io.WriteString(w, name)
+			_ = w.Close()
+		}
+
+		// ReadDir
+		entries, err := engine.ReadDir(ctx, dir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("ReadDir: got %d entries, want 2", len(entries))
+		}
+
+		// Cleanup
+		_ = engine.Remove(ctx, "test")
+	})
+
+	s.run("MkdirAll_Existing", func(t *testing.T) {
+		dir := "mkdir_existing"
+		if err := engine.MkdirAll(ctx, dir); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		// Creating an existing directory again must succeed.
+		if err := engine.MkdirAll(ctx, dir); err != nil {
+			t.Errorf("MkdirAll on existing dir: %v", err)
+		}
+
+		// A file occupying the path must be reported as ErrNotDir.
+		path := dir + "/file.txt"
+		w, err := engine.Create(ctx, path)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		_, _ = io.WriteString(w, "data")
+		_ = w.Close()
+
+		if err := engine.MkdirAll(ctx, path); !errors.Is(err, sbox.ErrNotDir) {
+			t.Errorf("MkdirAll on file = %v, want %v", err, sbox.ErrNotDir)
+		}
+		// So must a file occupying a parent.
+		if err := engine.MkdirAll(ctx, path+"/sub/dir"); !errors.Is(err, sbox.ErrNotDir) {
+			t.Errorf("MkdirAll below a file = %v, want %v", err, sbox.ErrNotDir)
+		}
+
+		_ = engine.Remove(ctx, dir)
+	})
+
+	s.run("Rename", func(t *testing.T) {
+		src := "rename_src.txt"
+		dst := "rename_dst.txt"
+
+		w, _ := engine.Create(ctx, src)
+		_, _ = io.WriteString(w, "data")
+		_ = w.Close()
+
+		if err := engine.Rename(ctx, src, dst); err != nil {
+			t.Fatalf("Rename: %v", err)
+		}
+
+		// src should not exist
+		_, err := engine.Stat(ctx, src)
+		if err == nil {
+			t.Error("Stat src after Rename: expected error")
+		}
+		// dst should exist
+		info, err := engine.Stat(ctx, dst)
+		if err != nil {
+			t.Fatalf("Stat dst: %v", err)
+		}
+		if info.Size != 4 {
+			t.Errorf("dst size = %d, want 4", info.Size)
+		}
+
+		_ = engine.Remove(ctx, dst)
+	})
+
+	s.run("OpenFile_Append", func(t *testing.T) {
+		path := "append_test.txt"
+
+		// Create initial file
+		w, _ := engine.Create(ctx, path)
+		_, _ = io.WriteString(w, "hello")
+		_ = w.Close()
+
+		// Append
+		aw, err := engine.OpenFile(ctx, path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile append: %v", err)
+		}
+		_, _ = io.WriteString(aw, " world")
+		_ = aw.Close()
+
+		// Verify
+		r, _ := engine.Open(ctx, path)
+		data, _ := io.ReadAll(r)
+		_ = r.Close()
+
+		if string(data) != "hello world" {
+			t.Errorf("after append = %q, want %q", string(data), "hello world")
+		}
+
+		_ = engine.Remove(ctx, path)
+	})
+
+	s.run("Walk", func(t *testing.T) {
+		// Create structure
+		_ = engine.MkdirAll(ctx, "walk/sub")
+		w1, _ := engine.Create(ctx, "walk/f1.txt")
+		_, _ = io.WriteString(w1, "1")
+		_ = w1.Close()
+		w2, _ := engine.Create(ctx, "walk/sub/f2.txt")
+		_, _ = io.WriteString(w2, "2")
+		_ = w2.Close()
+
+		var files []string
+		err := sbox.Walk(ctx, engine, "walk", func(path string, info *sbox.EntryInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir {
+				files = append(files, info.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk: %v", err)
+		}
+
+		if len(files) != 2 {
+			t.Errorf("Walk found %d files, want 2: %v", len(files), files)
+		}
+
+		_ = engine.Remove(ctx, "walk")
+	})
+}
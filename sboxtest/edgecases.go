@@ -0,0 +1,94 @@
+package sboxtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+// EdgeCases checks the inputs drivers tend to get wrong: empty files,
+// names with spaces and non-ASCII characters, names of opts.MaxNameLength
+// bytes, deep paths and overwrites of existing files.
+func EdgeCases(t *testing.T, engine sbox.StorageEngine, opts Options) {
+	t.Helper()
+	s := newSuite(t, "EdgeCases", engine, opts)
+	ctx := context.Background()
+	defer func() { _ = engine.Remove(ctx, "edge") }()
+
+	s.run("EmptyFile", func(t *testing.T) {
+		const path = "edge/empty.txt"
+		write(t, engine, path, "")
+		info, err := engine.Stat(ctx, path)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if info.Size != 0 || info.IsDir {
+			t.Errorf("Stat = %+v, want an empty file", info)
+		}
+		if got := readAll(t, engine, path); got != "" {
+			t.Errorf("content = %q, want empty", got)
+		}
+	})
+
+	s.run("SpacesInNames", func(t *testing.T) {
+		const path = "edge/with space/file name.txt"
+		write(t, engine, path, "spaced")
+		if got := readAll(t, engine, path); got != "spaced" {
+			t.Errorf("content = %q, want %q", got, "spaced")
+		}
+		entries, err := engine.ReadDir(ctx, "edge/with space")
+		if err != nil || len(entries) != 1 || entries[0].Name != "file name.txt" {
+			t.Errorf("ReadDir = %v, %v", entries, err)
+		}
+	})
+
+	s.run("UnicodeNames", func(t *testing.T) {
+		const path = "edge/ünïcødé/日本語.txt"
+		write(t, engine, path, "unicode")
+		if got := readAll(t, engine, path); got != "unicode" {
+			t.Errorf("content = %q, want %q", got, "unicode")
+		}
+		if info, err := engine.Stat(ctx, path); err != nil || info.Name != "日本語.txt" {
+			t.Errorf("Stat = %+v, %v", info, err)
+		}
+	})
+
+	s.run("LongNames", func(t *testing.T) {
+		n := opts.MaxNameLength
+		if n <= 0 {
+			n = DefaultMaxNameLength
+		}
+		name := strings.Repeat("n", n-len(".txt")) + ".txt"
+		path := "edge/long/" + name
+		write(t, engine, path, "long")
+		if got := readAll(t, engine, path); got != "long" {
+			t.Errorf("content = %q, want %q", got, "long")
+		}
+		entries, err := engine.ReadDir(ctx, "edge/long")
+		if err != nil || len(entries) != 1 || entries[0].Name != name {
+			t.Errorf("ReadDir = %d entries, %v; want the %d-byte name", len(entries), err, n)
+		}
+	})
+
+	s.run("DeepPaths", func(t *testing.T) {
+		path := "edge/" + strings.Repeat("nested/", 64) + "deep.txt"
+		write(t, engine, path, "deep")
+		if got := readAll(t, engine, path); got != "deep" {
+			t.Errorf("content = %q, want %q", got, "deep")
+		}
+	})
+
+	s.run("Overwrite", func(t *testing.T) {
+		const path = "edge/overwrite.txt"
+		write(t, engine, path, "a longer first version")
+		write(t, engine, path, "short")
+		if got := readAll(t, engine, path); got != "short" {
+			t.Errorf("content after overwrite = %q, want %q", got, "short")
+		}
+		if info, err := engine.Stat(ctx, path); err != nil || info.Size != 5 {
+			t.Errorf("Stat after overwrite = %+v, %v", info, err)
+		}
+	})
+}
@@ -0,0 +1,390 @@
+package sboxtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+// Extensions checks the extension interfaces engine implements. Those it
+// does not implement are left to the capability matrix of the Report.
+func Extensions(t *testing.T, engine sbox.StorageEngine, opts Options) {
+	t.Helper()
+	s := newSuite(t, "Extensions", engine, opts)
+	ctx := context.Background()
+
+	if copier, ok := engine.(sbox.Copier); ok {
+		s.run("Copier", func(t *testing.T) {
+			src := "copy_src.txt"
+			dst := "copy_dst.txt"
+
+			w, _ := engine.Create(ctx, src)
+			_, _ = io.WriteString(w, "copy me")
+			_ = w.Close()
+
+			if err := copier.Copy(ctx, src, dst); err != nil {
+				if err == sbox.ErrNotSupported {
+					t.Skip("Copy not supported by this backend")
+				}
+				t.Fatalf("Copy: %v", err)
+			}
+
+			r, _ := engine.Open(ctx, dst)
+			data, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(data) != "copy me" {
+				t.Errorf("Copy content = %q, want %q", string(data), "copy me")
+			}
+
+			_ = engine.Remove(ctx, src)
+			_ = engine.Remove(ctx, dst)
+		})
+	}
+
+	if hasher, ok := engine.(sbox.Hasher); ok {
+		s.run("Hasher", func(t *testing.T) {
+			path := "hash_test.txt"
+			w, _ := engine.Create(ctx, path)
+			_, _ = io.WriteString(w, "hash me")
+			_ = w.Close()
+
+			hash, err := hasher.Hash(ctx, path, "sha256")
+			if err == sbox.ErrNotSupported {
+				t.Skip("Hash not supported by this backend")
+			}
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if hash == "" {
+				t.Error("Hash returned empty string")
+			}
+
+			// Verify deterministic
+			hash2, _ := hasher.Hash(ctx, path, "sha256")
+			if hash != hash2 {
+				t.Errorf("Hash not deterministic: %q != %q", hash, hash2)
+			}
+
+			_ = engine.Remove(ctx, path)
+		})
+	}
+
+	if aw, ok := engine.(sbox.AtomicWriter); ok {
+		s.run("AtomicWriter", func(t *testing.T) {
+			path := "atomic_test.txt"
+			w, _ := engine.Create(ctx, path)
+			_, _ = io.WriteString(w, "old")
+			_ = w.Close()
+
+			// Aborted writes leave the existing file untouched.
+			aborted, err := aw.CreateAtomic(ctx, path)
+			if err != nil {
+				t.Fatalf("CreateAtomic: %v", err)
+			}
+			_, _ = io.WriteString(aborted, "discarded")
+			if err := aborted.Abort(); err != nil {
+				t.Fatalf("Abort: %v", err)
+			}
+
+			// Data is invisible until Close.
+			aw2, err := aw.CreateAtomic(ctx, path)
+			if err != nil {
+				t.Fatalf("CreateAtomic: %v", err)
+			}
+			_, _ = io.WriteString(aw2, "new content")
+			if got := readAll(t, engine, path); got != "old" {
+				t.Errorf("before Close = %q, want %q", got, "old")
+			}
+			if err := aw2.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if got := readAll(t, engine, path); got != "new content" {
+				t.Errorf("after Close = %q, want %q", got, "new content")
+			}
+
+			// No temporary files are left behind.
+			entries, _ := engine.ReadDir(ctx, "")
+			for _, e := range entries {
+				if strings.Contains(e.Name, "atomic_test.txt") && e.Name != path {
+					t.Errorf("leftover temporary entry %q", e.Name)
+				}
+			}
+
+			_ = engine.Remove(ctx, path)
+		})
+	}
+
+	if rw, ok := engine.(sbox.RangeWriter); ok && sbox.Supports(engine, sbox.CapRangeWrite) {
+		s.run("RangeWriter", func(t *testing.T) {
+			path := "range_test.txt"
+			write(t, engine, path, "hello world")
+
+			if n, err := rw.PutRange(ctx, path, 6, strings.NewReader("WORLD")); err != nil || n != 5 {
+				t.Fatalf("PutRange = %d, %v", n, err)
+			}
+			if got := readAll(t, engine, path); got != "hello WORLD" {
+				t.Errorf("after PutRange = %q, want %q", got, "hello WORLD")
+			}
+
+			// Writing past the end extends the file with zeros.
+			if _, err := rw.PutRange(ctx, path, 13, strings.NewReader("!")); err != nil {
+				t.Fatalf("PutRange past the end: %v", err)
+			}
+			if got := readAll(t, engine, path); got != "hello WORLD\x00\x00!" {
+				t.Errorf("after PutRange past the end = %q", got)
+			}
+
+			if _, err := rw.PutRange(ctx, "range_missing.txt", 0, strings.NewReader("x")); !errors.Is(err, sbox.ErrNotFound) {
+				t.Errorf("PutRange of a missing file = %v, want %v", err, sbox.ErrNotFound)
+			}
+			_ = engine.Remove(ctx, path)
+		})
+	}
+
+	if mw, ok := engine.(sbox.MetadataWriter); ok {
+		s.run("Metadata", func(t *testing.T) {
+			path := "metadata_test.txt"
+			mctx := sbox.WithMetadata(ctx, map[string]string{"owner": "alice"})
+			w, err := engine.Create(mctx, path)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			_, _ = io.WriteString(w, "data")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			defer func() { _ = engine.Remove(ctx, path) }()
+
+			info, err := engine.Stat(ctx, path)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Metadata["owner"] != "alice" {
+				if err := mw.SetMetadata(ctx, path, nil); errors.Is(err, sbox.ErrNotSupported) {
+					t.Skip("engine cannot store metadata")
+				}
+				t.Fatalf("Metadata after Create = %v, want owner=alice", info.Metadata)
+			}
+
+			if err := mw.SetMetadata(ctx, path, map[string]string{"owner": "bob"}); err != nil {
+				t.Fatalf("SetMetadata: %v", err)
+			}
+			if info, _ := engine.Stat(ctx, path); info.Metadata["owner"] != "bob" {
+				t.Errorf("Metadata after SetMetadata = %v, want owner=bob", info.Metadata)
+			}
+
+			// Overwriting without metadata clears it.
+			w, err = engine.Create(ctx, path)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			_, _ = io.WriteString(w, "new")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if info, _ := engine.Stat(ctx, path); len(info.Metadata) != 0 {
+				t.Errorf("Metadata after overwrite = %v, want none", info.Metadata)
+			}
+		})
+	}
+
+	if pm, ok := engine.(sbox.PermissionManager); ok {
+		s.run("Permissions", func(t *testing.T) {
+			path := "permissions_test.txt"
+			w, err := engine.Create(ctx, path)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			_, _ = io.WriteString(w, "data")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			defer func() { _ = engine.Remove(ctx, path) }()
+
+			if err := pm.Chmod(ctx, path, 0600); err != nil {
+				t.Fatalf("Chmod: %v", err)
+			}
+			info, err := engine.Stat(ctx, path)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Mode.Perm() != 0600 {
+				t.Errorf("Mode = %v, want %v", info.Mode.Perm(), os.FileMode(0600))
+			}
+			if err := pm.Chown(ctx, path, -1, -1); err != nil {
+				t.Errorf("Chown(-1, -1): %v", err)
+			}
+			if err := pm.Chmod(ctx, "missing.txt", 0600); !errors.Is(err, sbox.ErrNotFound) {
+				t.Errorf("Chmod(missing) = %v, want %v", err, sbox.ErrNotFound)
+			}
+		})
+	}
+
+	if cw, ok := engine.(sbox.ConditionalWriter); ok {
+		s.run("ConditionalWrite", func(t *testing.T) {
+			path := "conditional_test.txt"
+			if _, err := cw.ETag(ctx, path); !errors.Is(err, sbox.ErrNotFound) {
+				t.Errorf("ETag of a missing file = %v, want %v", err, sbox.ErrNotFound)
+			}
+			create := sbox.Precondition{IfNoneMatch: true}
+			if err := sbox.PutIf(ctx, engine, path, strings.NewReader("v1"), create); err != nil {
+				t.Fatalf("PutIf(IfNoneMatch): %v", err)
+			}
+			defer func() { _ = engine.Remove(ctx, path) }()
+			if err := sbox.PutIf(ctx, engine, path, strings.NewReader("v1b"), create); !errors.Is(err, sbox.ErrPreconditionFailed) {
+				t.Errorf("second PutIf(IfNoneMatch) = %v, want %v", err, sbox.ErrPreconditionFailed)
+			}
+
+			etag1, err := cw.ETag(ctx, path)
+			if err != nil {
+				t.Fatalf("ETag: %v", err)
+			}
+			if err := sbox.PutIf(ctx, engine, path, strings.NewReader("v2-"), sbox.Precondition{IfMatch: etag1}); err != nil {
+				t.Fatalf("PutIf(IfMatch): %v", err)
+			}
+			etag2, _ := cw.ETag(ctx, path)
+			if etag2 == etag1 {
+				t.Errorf("ETag unchanged by a write: %q", etag2)
+			}
+			if err := sbox.PutIf(ctx, engine, path, strings.NewReader("stale"), sbox.Precondition{IfMatch: etag1}); !errors.Is(err, sbox.ErrPreconditionFailed) {
+				t.Errorf("PutIf with a stale ETag = %v, want %v", err, sbox.ErrPreconditionFailed)
+			}
+
+			// A write between CreateIf and Close makes Close fail.
+			w, err := cw.CreateIf(ctx, path, sbox.Precondition{IfMatch: etag2})
+			if err != nil {
+				t.Fatalf("CreateIf: %v", err)
+			}
+			_, _ = io.WriteString(w, "lost update")
+			other, _ := engine.Create(ctx, path)
+			_, _ = io.WriteString(other, "concurrent")
+			if err := other.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if err := w.Close(); !errors.Is(err, sbox.ErrPreconditionFailed) {
+				t.Errorf("Close after a concurrent write = %v, want %v", err, sbox.ErrPreconditionFailed)
+			}
+			if got := readAll(t, engine, path); got != "concurrent" {
+				t.Errorf("content = %q, want %q", got, "concurrent")
+			}
+		})
+	}
+
+	if l, ok := engine.(sbox.Locker); ok {
+		s.run("Lock", func(t *testing.T) {
+			path := "lock_test.txt"
+			lease, err := l.Lock(ctx, path, sbox.LockOptions{})
+			if errors.Is(err, sbox.ErrNotSupported) {
+				t.Skip("engine has no locker")
+			}
+			if err != nil {
+				t.Fatalf("Lock: %v", err)
+			}
+			if _, err := l.Lock(ctx, path, sbox.LockOptions{}); !errors.Is(err, sbox.ErrLocked) {
+				t.Errorf("second Lock = %v, want %v", err, sbox.ErrLocked)
+			}
+			if err := lease.Renew(ctx); err != nil {
+				t.Errorf("Renew: %v", err)
+			}
+			if err := lease.Release(ctx); err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+			again, err := l.Lock(ctx, path, sbox.LockOptions{})
+			if err != nil {
+				t.Fatalf("Lock after Release: %v", err)
+			}
+			_ = again.Release(ctx)
+		})
+	}
+
+	if sl, ok := engine.(sbox.Symlinker); ok {
+		s.run("Symlink", func(t *testing.T) {
+			target := "symlink_test/target.txt"
+			w, err := engine.Create(ctx, target)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			_, _ = io.WriteString(w, "linked")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			defer func() { _ = engine.Remove(ctx, "symlink_test") }()
+
+			link := "symlink_test/sub/link"
+			if err := sl.Symlink(ctx, "../target.txt", link); err != nil {
+				if errors.Is(err, sbox.ErrNotSupported) {
+					t.Skip("engine cannot store symlinks")
+				}
+				t.Fatalf("Symlink: %v", err)
+			}
+			if got, err := sl.Readlink(ctx, link); err != nil || got != "../target.txt" {
+				t.Errorf("Readlink = %q, %v", got, err)
+			}
+			if _, err := sl.Readlink(ctx, target); !errors.Is(err, sbox.ErrInvalid) {
+				t.Errorf("Readlink of a file: %v, want %v", err, sbox.ErrInvalid)
+			}
+
+			info, err := sl.Lstat(ctx, link)
+			if err != nil {
+				t.Fatalf("Lstat: %v", err)
+			}
+			if info.Mode&os.ModeSymlink == 0 || info.LinkTarget != "../target.txt" {
+				t.Errorf("Lstat = %+v", info)
+			}
+			if info, err := engine.Stat(ctx, link); err != nil || info.Size != 6 || info.Name != "link" {
+				t.Errorf("Stat = %+v, %v", info, err)
+			}
+			r, err := engine.Open(ctx, link)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			data, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(data) != "linked" {
+				t.Errorf("content through link = %q", data)
+			}
+
+			entries, err := engine.ReadDir(ctx, "symlink_test/sub")
+			if err != nil || len(entries) != 1 || entries[0].LinkTarget != "../target.txt" {
+				t.Errorf("ReadDir = %v, %v", entries, err)
+			}
+			if err := sl.Symlink(ctx, "elsewhere", link); !errors.Is(err, sbox.ErrExist) {
+				t.Errorf("Symlink over a link: %v, want %v", err, sbox.ErrExist)
+			}
+
+			// Removing the link leaves the target alone.
+			if err := engine.Remove(ctx, link); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+			if _, err := engine.Stat(ctx, target); err != nil {
+				t.Errorf("target after removing link: %v", err)
+			}
+		})
+	}
+
+	if sr, ok := engine.(sbox.StreamReader); ok {
+		s.run("StreamReader", func(t *testing.T) {
+			path := "stream_test.txt"
+			w, _ := engine.Create(ctx, path)
+			_, _ = io.WriteString(w, "stream data")
+			_ = w.Close()
+
+			rc, err := sr.Get(ctx, path)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			if !strings.Contains(string(data), "stream data") {
+				t.Errorf("Get content = %q, want containing %q", string(data), "stream data")
+			}
+
+			_ = engine.Remove(ctx, path)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package sboxtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nuln/sbox"
+)
+
+// ErrInjected is the error a FlakyEngine injects unless Policy.Err is set.
+// It is not an sbox sentinel, so middleware treats it as transient.
+var ErrInjected = errors.New("sboxtest: injected fault")
+
+// Policy decides which faults a FlakyEngine injects. Rates are
+// probabilities between 0 and 1, drawn from a source seeded with Seed, so
+// the same sequence of calls sees the same faults.
+type Policy struct {
+	Seed uint64
+
+	// ErrorRate is the probability that an operation fails with Err
+	// before reaching the inner engine.
+	ErrorRate float64
+
+	// ReadErrorRate is the probability that a Read of an open file fails
+	// with Err.
+	ReadErrorRate float64
+
+	// PartialWriteRate is the probability that a Write stores only part
+	// of its data, at least one byte less, and fails with Err.
+	PartialWriteRate float64
+
+	// DropCloseRate is the probability that Close of a writer fails with
+	// Err without closing the inner writer, so engines that commit on
+	// Close never store the data.
+	DropCloseRate float64
+
+	// Latency is the longest delay added before each operation, Read and
+	// Write; the delay is uniform in [0, Latency]. Delays end early with
+	// the context's error when it is done.
+	Latency time.Duration
+
+	// Ops limits ErrorRate and Latency to these operations, by method
+	// name, e.g. "Stat" or "Open". All operations if empty.
+	Ops []string
+
+	// Err is the injected error; ErrInjected if nil.
+	Err error
+}
+
+// FlakyEngine is a StorageEngine that injects errors, partial writes,
+// latency and dropped Closes into the calls to an inner engine, so
+// applications and middleware can be tested against realistic failures:
+//
+//	flaky := sboxtest.NewFlakyEngine(memory.New(), sboxtest.Policy{Seed: 1, ErrorRate: 0.3})
+//	engine := retry.New(flaky)
+type FlakyEngine struct {
+	inner  sbox.StorageEngine
+	policy Policy
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	faults int
+}
+
+// NewFlakyEngine returns a FlakyEngine injecting faults into inner
+// according to policy.
+func NewFlakyEngine(inner sbox.StorageEngine, policy Policy) *FlakyEngine {
+	if policy.Err == nil {
+		policy.Err = ErrInjected
+	}
+	return &FlakyEngine{
+		inner:  inner,
+		policy: policy,
+		rng:    rand.New(rand.NewPCG(policy.Seed, policy.Seed)),
+	}
+}
+
+// Inner returns the wrapped engine.
+func (e *FlakyEngine) Inner() sbox.StorageEngine {
+	return e.inner
+}
+
+// Close closes the wrapped engine if it implements io.Closer.
+func (e *FlakyEngine) Close() error {
+	return sbox.Close(e.inner)
+}
+
+// Faults returns the number of faults injected so far, delays excluded.
+func (e *FlakyEngine) Faults() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.faults
+}
+
+// roll reports whether an event of probability rate happens, counting it
+// as a fault if so.
+func (e *FlakyEngine) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rng.Float64() >= rate {
+		return false
+	}
+	e.faults++
+	return true
+}
+
+// delay sleeps for a random part of the policy's latency, or until ctx is
+// done.
+func (e *FlakyEngine) delay(ctx context.Context) error {
+	if e.policy.Latency <= 0 {
+		return nil
+	}
+	e.mu.Lock()
+	d := time.Duration(e.rng.Int64N(int64(e.policy.Latency) + 1))
+	e.mu.Unlock()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// before runs before operation op: it delays and decides whether op fails.
+func (e *FlakyEngine) before(ctx context.Context, op string) error {
+	if len(e.policy.Ops) > 0 && !slices.Contains(e.policy.Ops, op) {
+		return nil
+	}
+	if err := e.delay(ctx); err != nil {
+		return err
+	}
+	if e.roll(e.policy.ErrorRate) {
+		return e.policy.Err
+	}
+	return nil
+}
+
+func (e *FlakyEngine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	if err := e.before(ctx, "Stat"); err != nil {
+		return nil, err
+	}
+	return e.inner.Stat(ctx, path)
+}
+
+func (e *FlakyEngine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
+	if err := e.before(ctx, "Open"); err != nil {
+		return nil, err
+	}
+	r, err := e.inner.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyReader{ReadSeekCloser: r, ctx: ctx, engine: e}, nil
+}
+
+func (e *FlakyEngine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	if err := e.before(ctx, "Create"); err != nil {
+		return nil, err
+	}
+	w, err := e.inner.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyWriter{w: w, ctx: ctx, engine: e}, nil
+}
+
+func (e *FlakyEngine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	if err := e.before(ctx, "OpenFile"); err != nil {
+		return nil, err
+	}
+	w, err := e.inner.OpenFile(ctx, path, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyWriter{w: w, ctx: ctx, engine: e}, nil
+}
+
+func (e *FlakyEngine) Remove(ctx context.Context, path string) error {
+	if err := e.before(ctx, "Remove"); err != nil {
+		return err
+	}
+	return e.inner.Remove(ctx, path)
+}
+
+func (e *FlakyEngine) Rename(ctx context.Context, oldPath, newPath string) error {
+	if err := e.before(ctx, "Rename"); err != nil {
+		return err
+	}
+	return e.inner.Rename(ctx, oldPath, newPath)
+}
+
+func (e *FlakyEngine) MkdirAll(ctx context.Context, path string) error {
+	if err := e.before(ctx, "MkdirAll"); err != nil {
+		return err
+	}
+	return e.inner.MkdirAll(ctx, path)
+}
+
+func (e *FlakyEngine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+	if err := e.before(ctx, "ReadDir"); err != nil {
+		return nil, err
+	}
+	return e.inner.ReadDir(ctx, path)
+}
+
+// flakyReader injects read errors and latency into an open file.
+type flakyReader struct {
+	sbox.ReadSeekCloser
+	ctx    context.Context
+	engine *FlakyEngine
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if err := r.engine.delay(r.ctx); err != nil {
+		return 0, err
+	}
+	if r.engine.roll(r.engine.policy.ReadErrorRate) {
+		return 0, r.engine.policy.Err
+	}
+	return r.ReadSeekCloser.Read(p)
+}
+
+// flakyWriter injects partial writes, dropped Closes and latency into a
+// file being written.
+type flakyWriter struct {
+	w      sbox.WriteCloser
+	ctx    context.Context
+	engine *FlakyEngine
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if err := w.engine.delay(w.ctx); err != nil {
+		return 0, err
+	}
+	if len(p) > 0 && w.engine.roll(w.engine.policy.PartialWriteRate) {
+		w.engine.mu.Lock()
+		n := w.engine.rng.IntN(len(p))
+		w.engine.mu.Unlock()
+		n, _ = w.w.Write(p[:n])
+		return n, w.engine.policy.Err
+	}
+	return w.w.Write(p)
+}
+
+func (w *flakyWriter) Seek(offset int64, whence int) (int64, error) {
+	s, ok := w.w.(io.Seeker)
+	if !ok {
+		return 0, sbox.ErrNotSupported
+	}
+	return s.Seek(offset, whence)
+}
+
+func (w *flakyWriter) Close() error {
+	if w.engine.roll(w.engine.policy.DropCloseRate) {
+		return w.engine.policy.Err
+	}
+	return w.w.Close()
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine   = (*FlakyEngine)(nil)
+	_ sbox.WriteSeekCloser = (*flakyWriter)(nil)
+)
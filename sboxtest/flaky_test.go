@@ -0,0 +1,88 @@
+package sboxtest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestFlakyEngine_NoFaults(t *testing.T) {
+	sboxtest.StorageTestSuite(t, sboxtest.NewFlakyEngine(memory.New(), sboxtest.Policy{}))
+}
+
+func TestFlakyEngine_Errors(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	flaky := sboxtest.NewFlakyEngine(inner, sboxtest.Policy{ErrorRate: 1, Ops: []string{"Stat"}})
+	if _, err := flaky.Stat(ctx, "f.txt"); !errors.Is(err, sboxtest.ErrInjected) {
+		t.Errorf("Stat = %v, want %v", err, sboxtest.ErrInjected)
+	}
+	if _, err := flaky.ReadDir(ctx, ""); err != nil {
+		t.Errorf("ReadDir outside Ops = %v", err)
+	}
+	if flaky.Faults() != 1 {
+		t.Errorf("Faults = %d, want 1", flaky.Faults())
+	}
+
+	// The same seed gives the same faults.
+	outcomes := func() string {
+		e := sboxtest.NewFlakyEngine(inner, sboxtest.Policy{Seed: 42, ErrorRate: 0.5})
+		var b strings.Builder
+		for range 64 {
+			_, err := e.ReadDir(ctx, "")
+			b.WriteString(map[bool]string{true: "x", false: "."}[err != nil])
+		}
+		return b.String()
+	}
+	if a, b := outcomes(), outcomes(); a != b || !strings.Contains(a, "x") || !strings.Contains(a, ".") {
+		t.Errorf("outcomes %q and %q, want equal mixes", a, b)
+	}
+}
+
+func TestFlakyEngine_Writes(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+
+	partial := sboxtest.NewFlakyEngine(inner, sboxtest.Policy{PartialWriteRate: 1})
+	w, err := partial.Create(ctx, "partial.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if n, err := io.WriteString(w, "0123456789"); n >= 10 || !errors.Is(err, sboxtest.ErrInjected) {
+		t.Errorf("Write = %d, %v; want a short write and %v", n, err, sboxtest.ErrInjected)
+	}
+	_ = w.Close()
+
+	store := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64)
+	dropped := sboxtest.NewFlakyEngine(store, sboxtest.Policy{DropCloseRate: 1})
+	w, err = dropped.Create(ctx, "dropped.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, _ = io.WriteString(w, "lost")
+	if err := w.Close(); !errors.Is(err, sboxtest.ErrInjected) {
+		t.Errorf("Close = %v, want %v", err, sboxtest.ErrInjected)
+	}
+	if _, err := store.Stat(ctx, "dropped.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat after a dropped Close = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestFlakyEngine_Latency(t *testing.T) {
+	flaky := sboxtest.NewFlakyEngine(memory.New(), sboxtest.Policy{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := flaky.Stat(ctx, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stat = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,161 @@
+package sboxtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+// LargeFiles checks files much larger than the buffers and chunks drivers
+// use: a round trip of opts.LargeFileSize bytes and seeks across it, files
+// of exactly one chunk and one byte either side of it, and, if
+// opts.HugeFileSize is set, a file streamed through without buffering.
+func LargeFiles(t *testing.T, engine sbox.StorageEngine, opts Options) {
+	t.Helper()
+	s := newSuite(t, "LargeFiles", engine, opts)
+	ctx := context.Background()
+	size := opts.LargeFileSize
+	if size <= 0 {
+		size = DefaultLargeFileSize
+	}
+	if testing.Short() {
+		size = min(size, 1<<20)
+	}
+	chunk := opts.ChunkSize
+	if chunk <= 0 {
+		chunk = DefaultChunkSize
+	}
+	const path = "large/file.bin"
+	defer func() { _ = engine.Remove(ctx, "large") }()
+
+	s.run("RoundTrip", func(t *testing.T) {
+		roundTrip(t, engine, path, size)
+	})
+
+	s.run("Seek", func(t *testing.T) {
+		if _, err := engine.Stat(ctx, path); err != nil {
+			t.Skip("RoundTrip did not leave a file to seek in")
+		}
+		r, err := engine.Open(ctx, path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer func() { _ = r.Close() }()
+		buf := make([]byte, 4096)
+		for _, off := range []int64{size - 1, size / 2, 1, size - int64(len(buf)), 0} {
+			if _, err := r.Seek(off, io.SeekStart); err != nil {
+				t.Fatalf("Seek(%d): %v", off, err)
+			}
+			n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-off)])
+			if err != nil {
+				t.Fatalf("Read at %d: %v", off, err)
+			}
+			if want := patternAt(off, n); !bytes.Equal(buf[:n], want) {
+				t.Errorf("%d bytes at offset %d differ", n, off)
+			}
+		}
+	})
+
+	s.run("ChunkBoundaries", func(t *testing.T) {
+		for _, n := range []int64{chunk - 1, chunk, chunk + 1, 2 * chunk} {
+			roundTrip(t, engine, fmt.Sprintf("large/chunk-%d.bin", n), n)
+		}
+	})
+
+	s.run("Streaming", func(t *testing.T) {
+		if opts.HugeFileSize <= 0 {
+			t.Skip("Options.HugeFileSize not set")
+		}
+		if testing.Short() {
+			t.Skip("skipping huge file in short mode")
+		}
+		roundTrip(t, engine, "large/huge.bin", opts.HugeFileSize)
+		_ = engine.Remove(ctx, "large/huge.bin")
+	})
+}
+
+// roundTrip streams size bytes of pattern to path and checks that they
+// read back the same.
+func roundTrip(t *testing.T, engine sbox.StorageEngine, path string, size int64) {
+	t.Helper()
+	ctx := context.Background()
+	w, err := engine.Create(ctx, path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.Copy(w, io.LimitReader(&pattern{}, size)); err != nil {
+		_ = w.Close()
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+	if info, err := engine.Stat(ctx, path); err != nil || info.Size != size {
+		t.Fatalf("Stat %s = %+v, %v; want size %d", path, info, err, size)
+	}
+	r, err := engine.Open(ctx, path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	if n, err := compare(r, io.LimitReader(&pattern{}, size)); err != nil {
+		t.Fatalf("%s differs at offset %d: %v", path, n, err)
+	}
+}
+
+// errContent reports data read back differently than it was written.
+var errContent = errors.New("content differs")
+
+// pattern is an endless reader of a byte sequence that does not repeat
+// within a chunk, so misplaced chunks are noticed.
+type pattern struct{ off int64 }
+
+func (p *pattern) Read(b []byte) (int, error) {
+	copy(b, patternAt(p.off, len(b)))
+	p.off += int64(len(b))
+	return len(b), nil
+}
+
+// patternAt returns n bytes of pattern from offset off.
+func patternAt(off int64, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		x := off + int64(i)
+		b[i] = byte(x ^ x>>8 ^ x>>16)
+	}
+	return b
+}
+
+// compare reads got and want to the end and returns the offset of the
+// first difference, or an error if they differ.
+func compare(got, want io.Reader) (int64, error) {
+	bg, bw := make([]byte, 32<<10), make([]byte, 32<<10)
+	var off int64
+	for {
+		nw, errw := io.ReadFull(want, bw)
+		ng, errg := io.ReadFull(got, bg[:nw])
+		if errg != nil && errg != io.ErrUnexpectedEOF && errg != io.EOF {
+			return off, errg
+		}
+		for i := range ng {
+			if bg[i] != bw[i] {
+				return off + int64(i), errContent
+			}
+		}
+		off += int64(ng)
+		if ng < nw {
+			return off, io.ErrUnexpectedEOF
+		}
+		if errw != nil {
+			if n, _ := got.Read(bg[:1]); n > 0 {
+				return off, errContent
+			}
+			return off, nil
+		}
+	}
+}
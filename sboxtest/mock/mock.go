@@ -0,0 +1,322 @@
+// Package mock provides a StorageEngine for unit tests that answers calls
+// from expectations instead of storing anything:
+//
+//	m := mock.New(t)
+//	m.On("Stat", "docs/a.txt").Return(&sbox.EntryInfo{Name: "a.txt", Size: 3}, nil)
+//	m.On("Open", "docs/a.txt").Return(mock.File("abc"), nil).Once()
+//	m.On("Remove", mock.Anything).Return(sbox.ErrPermission)
+//
+// Arguments are matched after the context, in the order of the method's
+// parameters and with their types, e.g. os.FileMode(0644) for the
+// permissions of OpenFile. Expectations are tried in the order they were added; a call
+// that matches none fails the test and returns ErrUnexpectedCall. When the
+// test ends, New checks that every expectation was met.
+package mock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+// ErrUnexpectedCall is returned by calls that match no expectation.
+var ErrUnexpectedCall = errors.New("mock: unexpected call")
+
+// Anything matches any argument.
+const Anything = anything("mock.Anything")
+
+type anything string
+
+// Invocation is a call made to an Engine.
+type Invocation struct {
+	Method string
+	Args   []any
+}
+
+func (i Invocation) String() string {
+	args := make([]string, len(i.Args))
+	for n, a := range i.Args {
+		args[n] = fmt.Sprintf("%#v", a)
+	}
+	return i.Method + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Call is an expected call and its canned response.
+type Call struct {
+	method  string
+	args    []any
+	returns []any
+	run     func(args []any)
+	times   int // 0 for any number
+	calls   int
+	maybe   bool
+}
+
+// Return sets the values the call returns, in the order of the method's
+// results.
+func (c *Call) Return(values ...any) *Call {
+	c.returns = values
+	return c
+}
+
+// Times limits the call to be matched exactly n times.
+func (c *Call) Times(n int) *Call {
+	c.times = n
+	return c
+}
+
+// Once is Times(1).
+func (c *Call) Once() *Call {
+	return c.Times(1)
+}
+
+// Maybe makes the call optional: it is not reported if never made.
+func (c *Call) Maybe() *Call {
+	c.maybe = true
+	return c
+}
+
+// Run sets a function run with the arguments each time the call is
+// matched, before it returns.
+func (c *Call) Run(fn func(args []any)) *Call {
+	c.run = fn
+	return c
+}
+
+func (c *Call) matches(method string, args []any) bool {
+	if c.method != method || len(c.args) != len(args) || (c.times > 0 && c.calls >= c.times) {
+		return false
+	}
+	for i, want := range c.args {
+		if want != Anything && !reflect.DeepEqual(want, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Engine is a StorageEngine answering calls from expectations.
+type Engine struct {
+	t testing.TB
+
+	mu       sync.Mutex
+	expected []*Call
+	calls    []Invocation
+}
+
+// New returns an Engine without expectations that reports to t, and
+// checks its expectations when t ends.
+func New(t testing.TB) *Engine {
+	m := &Engine{t: t}
+	t.Cleanup(func() { m.AssertExpectations(t) })
+	return m
+}
+
+// On adds an expectation of a call to method with args. It answers with
+// zero values until Return is called.
+func (m *Engine) On(method string, args ...any) *Call {
+	c := &Call{method: method, args: args}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expected = append(m.expected, c)
+	return c
+}
+
+// Calls returns the calls made so far, in order.
+func (m *Engine) Calls() []Invocation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Invocation(nil), m.calls...)
+}
+
+// AssertExpectations reports the expectations not met on t: calls never
+// made, unless Maybe, and calls made fewer times than Times.
+func (m *Engine) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok := true
+	for _, c := range m.expected {
+		switch {
+		case c.times > 0 && c.calls < c.times:
+			t.Errorf("mock: %s called %d times, want %d", Invocation{c.method, c.args}, c.calls, c.times)
+			ok = false
+		case c.calls == 0 && !c.maybe:
+			t.Errorf("mock: %s never called", Invocation{c.method, c.args})
+			ok = false
+		}
+	}
+	return ok
+}
+
+// called records a call and returns the canned results of the first
+// expectation it matches. Calls that match none get ErrUnexpectedCall.
+func (m *Engine) called(method string, args ...any) results {
+	m.t.Helper()
+	inv := Invocation{Method: method, Args: args}
+	m.mu.Lock()
+	m.calls = append(m.calls, inv)
+	var c *Call
+	for _, e := range m.expected {
+		if e.matches(method, args) {
+			c = e
+			c.calls++
+			break
+		}
+	}
+	m.mu.Unlock()
+	if c == nil {
+		m.t.Errorf("mock: unexpected call %s", inv)
+		return results{m: m, method: method}
+	}
+	if c.run != nil {
+		c.run(args)
+	}
+	return results{m: m, method: method, values: c.returns, matched: true}
+}
+
+// results are the canned results of a call.
+type results struct {
+	m       *Engine
+	method  string
+	values  []any
+	matched bool
+}
+
+// result returns canned result i as a T, failing the test if it has
+// another type.
+func result[T any](r results, i int) T {
+	var zero T
+	if i >= len(r.values) || r.values[i] == nil {
+		return zero
+	}
+	v, ok := r.values[i].(T)
+	if !ok {
+		r.m.t.Errorf("mock: %s result %d is a %T, want a %T", r.method, i, r.values[i], zero)
+	}
+	return v
+}
+
+// err returns canned result i as an error, or ErrUnexpectedCall if the
+// call matched no expectation.
+func (r results) err(i int) error {
+	if !r.matched {
+		return ErrUnexpectedCall
+	}
+	return result[error](r, i)
+}
+
+func (m *Engine) Stat(_ context.Context, path string) (*sbox.EntryInfo, error) {
+	r := m.called("Stat", path)
+	return result[*sbox.EntryInfo](r, 0), r.err(1)
+}
+
+func (m *Engine) Open(_ context.Context, path string) (sbox.ReadSeekCloser, error) {
+	r := m.called("Open", path)
+	return result[sbox.ReadSeekCloser](r, 0), r.err(1)
+}
+
+func (m *Engine) Create(_ context.Context, path string) (sbox.WriteCloser, error) {
+	r := m.called("Create", path)
+	return result[sbox.WriteCloser](r, 0), r.err(1)
+}
+
+func (m *Engine) OpenFile(_ context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+	r := m.called("OpenFile", path, flag, perm)
+	return result[sbox.WriteSeekCloser](r, 0), r.err(1)
+}
+
+func (m *Engine) Remove(_ context.Context, path string) error {
+	return m.called("Remove", path).err(0)
+}
+
+func (m *Engine) Rename(_ context.Context, oldPath, newPath string) error {
+	return m.called("Rename", oldPath, newPath).err(0)
+}
+
+func (m *Engine) MkdirAll(_ context.Context, path string) error {
+	return m.called("MkdirAll", path).err(0)
+}
+
+func (m *Engine) ReadDir(_ context.Context, path string) ([]*sbox.EntryInfo, error) {
+	r := m.called("ReadDir", path)
+	return result[[]*sbox.EntryInfo](r, 0), r.err(1)
+}
+
+// File returns a reader of content to return from Open.
+func File(content string) sbox.ReadSeekCloser {
+	return nopCloser{strings.NewReader(content)}
+}
+
+type nopCloser struct{ io.ReadSeeker }
+
+func (nopCloser) Close() error { return nil }
+
+// Writer is a file to return from Create and OpenFile that keeps what is
+// written to it.
+type Writer struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+// NewWriter returns an empty Writer.
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, sbox.ErrClosed
+	}
+	return w.buf.Write(p)
+}
+
+// Seek only reports the offset, which is the end; moving it is not
+// supported.
+func (w *Writer) Seek(offset int64, whence int) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if offset != 0 || (whence == io.SeekStart && w.buf.Len() != 0) {
+		return 0, sbox.ErrNotSupported
+	}
+	return int64(w.buf.Len()), nil
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+// String returns what was written.
+func (w *Writer) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// Closed reports whether Close was called.
+func (w *Writer) Closed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.StorageEngine   = (*Engine)(nil)
+	_ sbox.WriteSeekCloser = (*Writer)(nil)
+)
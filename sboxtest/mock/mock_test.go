@@ -0,0 +1,105 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest/mock"
+)
+
+// recorder is a testing.TB keeping the errors reported to it.
+type recorder struct {
+	testing.TB
+	mu   sync.Mutex
+	errs []string
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	m := mock.New(t)
+	m.On("Stat", "a.txt").Return(&sbox.EntryInfo{Name: "a.txt", Size: 3}, nil)
+	m.On("Open", "a.txt").Return(mock.File("abc"), nil).Once()
+	m.On("Open", mock.Anything).Return(nil, sbox.ErrNotFound)
+	m.On("Remove", "a.txt").Return(nil)
+	w := mock.NewWriter()
+	m.On("OpenFile", "b.txt", os.O_WRONLY|os.O_APPEND, os.FileMode(0644)).Return(w, nil)
+
+	if info, err := m.Stat(ctx, "a.txt"); err != nil || info.Size != 3 {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+	r, err := m.Open(ctx, "a.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "abc" {
+		t.Errorf("content = %q", data)
+	}
+	// The first Open expectation is used up.
+	if _, err := m.Open(ctx, "a.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("second Open = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if err := m.Remove(ctx, "a.txt"); err != nil {
+		t.Errorf("Remove = %v", err)
+	}
+
+	aw, err := m.OpenFile(ctx, "b.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = io.WriteString(aw, "appended")
+	_ = aw.Close()
+	if w.String() != "appended" || !w.Closed() {
+		t.Errorf("writer = %q, closed %v", w.String(), w.Closed())
+	}
+
+	if calls := m.Calls(); len(calls) != 5 || calls[4].String() != `OpenFile("b.txt", 1025, 0x1a4)` {
+		t.Errorf("Calls = %v", calls)
+	}
+}
+
+func TestEngine_Failures(t *testing.T) {
+	ctx := context.Background()
+	rec := &recorder{TB: t}
+	m := mock.New(rec)
+	m.On("MkdirAll", "dir").Return(nil).Times(2)
+	m.On("Rename", "a", "b").Return(nil)
+	m.On("ReadDir", "").Return("not entries", nil).Maybe()
+	m.On("Stat", "optional").Maybe()
+
+	_ = m.MkdirAll(ctx, "dir")
+	if err := m.Remove(ctx, "x"); !errors.Is(err, mock.ErrUnexpectedCall) {
+		t.Errorf("Remove = %v, want %v", err, mock.ErrUnexpectedCall)
+	}
+	if _, err := m.ReadDir(ctx, ""); err != nil {
+		t.Errorf("ReadDir = %v", err)
+	}
+	if m.AssertExpectations(rec) {
+		t.Error("AssertExpectations = true")
+	}
+	want := []string{
+		`mock: unexpected call Remove("x")`,
+		`mock: ReadDir result 0 is a string, want a []*sbox.EntryInfo`,
+		`mock: MkdirAll("dir") called 1 times, want 2`,
+		`mock: Rename("a", "b") never called`,
+	}
+	if fmt.Sprint(rec.errs) != fmt.Sprint(want) {
+		t.Errorf("errors = %q, want %q", rec.errs, want)
+	}
+
+	// Meet the expectations for the check when the test ends.
+	_ = m.MkdirAll(ctx, "dir")
+	_ = m.Rename(ctx, "a", "b")
+	rec.errs = nil
+}
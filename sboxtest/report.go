@@ -0,0 +1,108 @@
+package sboxtest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nuln/sbox"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	StatusPass    Status = "pass"
+	StatusFail    Status = "fail"
+	StatusSkip    Status = "skip"    // The engine reported the feature unsupported
+	StatusLimited Status = "limited" // A known limitation of Options
+)
+
+// Result is the outcome of one check of a suite.
+type Result struct {
+	Suite  string
+	Check  string
+	Status Status
+	Reason string // For StatusLimited
+}
+
+// Report collects the results of the suites run against an engine, and
+// the capabilities the engine reports, so driver authors can see what
+// their driver is compliant with.
+type Report struct {
+	Engine       string                   // The engine's type
+	Capabilities map[sbox.Capability]bool // Per sbox.Supports
+
+	mu      sync.Mutex
+	results []Result
+}
+
+// NewReport returns an empty Report for engine, with its capabilities.
+func NewReport(engine sbox.StorageEngine) *Report {
+	r := &Report{
+		Engine:       fmt.Sprintf("%T", engine),
+		Capabilities: make(map[sbox.Capability]bool, len(sbox.AllCapabilities)),
+	}
+	for _, c := range sbox.AllCapabilities {
+		r.Capabilities[c] = sbox.Supports(engine, c)
+	}
+	return r
+}
+
+func (r *Report) add(res Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, res)
+}
+
+// Results returns the results in the order the checks ran.
+func (r *Report) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.results...)
+}
+
+// Passed reports whether no check failed.
+func (r *Report) Passed() bool {
+	for _, res := range r.Results() {
+		if res.Status == StatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the report as a capability matrix followed by a line per
+// check.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sboxtest report for %s\n\ncapabilities:\n", r.Engine)
+	for _, c := range sbox.AllCapabilities {
+		mark := " "
+		if r.Capabilities[c] {
+			mark = "x"
+		}
+		fmt.Fprintf(&b, "  [%s] %s\n", mark, c)
+	}
+
+	results := r.Results()
+	counts := make(map[Status]int)
+	width := 0
+	for _, res := range results {
+		counts[res.Status]++
+		width = max(width, len(res.Suite)+1+len(res.Check))
+	}
+	fmt.Fprintf(&b, "\nchecks: %d passed, %d failed, %d skipped, %d limited\n",
+		counts[StatusPass], counts[StatusFail], counts[StatusSkip], counts[StatusLimited])
+	for _, res := range results {
+		fmt.Fprintf(&b, "  %-*s  %s", width, res.Suite+"/"+res.Check, res.Status)
+		if res.Reason != "" {
+			fmt.Fprintf(&b, " (%s)", res.Reason)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Compile-time interface checks.
+var _ fmt.Stringer = (*Report)(nil)
@@ -0,0 +1,46 @@
+package sboxtest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func TestReport(t *testing.T) {
+	engine := memory.New()
+	report := sboxtest.Run(t, engine, sboxtest.Options{
+		Limitations:   map[string]string{"EdgeCases/DeepPaths": "not today"},
+		LargeFileSize: 1 << 20,
+		HugeFileSize:  8 << 20,
+	})
+	if !report.Passed() {
+		t.Fatal("Passed = false")
+	}
+
+	statuses := make(map[string]sboxtest.Status)
+	for _, res := range report.Results() {
+		statuses[res.Suite+"/"+res.Check] = res.Status
+	}
+	for check, want := range map[string]sboxtest.Status{
+		"Core/Rename":          sboxtest.StatusPass,
+		"Concurrency/Writes":   sboxtest.StatusPass,
+		"LargeFiles/RoundTrip": sboxtest.StatusPass,
+		"EdgeCases/DeepPaths":  sboxtest.StatusLimited,
+		"EdgeCases/EmptyFile":  sboxtest.StatusPass,
+		"Extensions/Copier":    sboxtest.StatusPass,
+	} {
+		if got := statuses[check]; got != want {
+			t.Errorf("%s = %q, want %q", check, got, want)
+		}
+	}
+
+	out := report.String()
+	for _, want := range []string{"[x] " + string(sbox.CapCopy), "EdgeCases/DeepPaths", "(not today)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package sharded
+
+import "math/bits"
+
+// Chunking selects how writers split files into shards.
+type Chunking string
+
+const (
+	// ChunkingFixed cuts every chunkSize bytes. Inserting a byte shifts
+	// every following chunk, so edited files share few shards with their
+	// previous versions.
+	ChunkingFixed Chunking = "fixed"
+
+	// ChunkingCDC cuts at content-defined boundaries found with the FastCDC
+	// gear hash, so boundaries move with the data and an insertion only
+	// changes the chunks around it. chunkSize is the maximum chunk size;
+	// chunks average a quarter of it and are at least a sixteenth.
+	ChunkingCDC Chunking = "cdc"
+)
+
+// gear is the FastCDC gear table. It is generated from a fixed seed and
+// must never change: chunk boundaries, and therefore deduplication against
+// existing shards, depend on it.
+var gear = func() (t [256]uint64) {
+	// splitmix64
+	x := uint64(0x5b0c_d1f0_2b8e_a7c3)
+	for i := range t {
+		x += 0x9e37_79b9_7f4a_7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58_476d_1ce4_e5b9
+		z = (z ^ (z >> 27)) * 0x94d0_49bb_1331_11eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// cdc finds content-defined chunk boundaries with normalized chunking:
+// a stricter mask before the average size and a looser one after it pull
+// chunk sizes towards the average.
+type cdc struct {
+	min, avg, max int
+	maskS, maskL  uint64
+}
+
+func newCDC(maxSize int64) *cdc {
+	c := &cdc{max: int(maxSize), avg: int(maxSize / 4), min: int(maxSize / 16)}
+	b := bits.Len(uint(max(c.avg, 1))) - 1 // log2(avg)
+	c.maskS = ^uint64(0) << (64 - min(b+2, 63))
+	c.maskL = ^uint64(0) << (64 - max(b-2, 1))
+	return c
+}
+
+// cut returns the length of the first chunk of data. data shorter than the
+// minimum chunk size is returned whole.
+func (c *cdc) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	n = min(n, c.max)
+	normal := min(c.avg, n)
+
+	var h uint64
+	i := c.min
+	for ; i < normal; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package sharded_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestCDC_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+		sharded.WithChunking(sharded.ChunkingCDC)))
+}
+
+// sharedShards writes data and a copy with one byte inserted near the start,
+// and returns how many shards the second file added.
+func sharedShards(t *testing.T, chunking sharded.Chunking, data []byte) (total, added int) {
+	t.Helper()
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithChunking(chunking))
+	ctx := context.Background()
+
+	edited := append(append(append([]byte{}, data[:100]...), 'X'), data[100:]...)
+	if err := sbox.PutAtomic(ctx, engine, "a.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("write a.bin: %v", err)
+	}
+	countShards(t, shardsFs, "", &total)
+	if err := sbox.PutAtomic(ctx, engine, "b.bin", bytes.NewReader(edited)); err != nil {
+		t.Fatalf("write b.bin: %v", err)
+	}
+	var after int
+	countShards(t, shardsFs, "", &after)
+
+	r, err := engine.Open(ctx, "b.bin")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	got, _ := io.ReadAll(r)
+	if !bytes.Equal(got, edited) {
+		t.Fatalf("%s: edited file does not round-trip", chunking)
+	}
+	return total, after - total
+}
+
+func TestCDC_InsertionKeepsShards(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+
+	// With fixed-size chunks the insertion shifts every boundary.
+	total, added := sharedShards(t, sharded.ChunkingFixed, data)
+	if added < total {
+		t.Errorf("fixed: insertion added %d of %d shards, want all", added, total)
+	}
+
+	total, added = sharedShards(t, sharded.ChunkingCDC, data)
+	if added > 3 {
+		t.Errorf("cdc: insertion added %d of %d shards, want at most 3", added, total)
+	}
+	if total < len(data)/1024 {
+		t.Errorf("cdc: %d shards for %d bytes, want chunks below the 1024-byte maximum", total, len(data))
+	}
+}
@@ -0,0 +1,86 @@
+package sharded
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how shards are compressed before they are stored.
+// Shards stay addressed by the hash of their uncompressed content, so
+// engines with different settings can share a shard store and still
+// deduplicate.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionZstd Compression = "zstd"
+	CompressionGzip Compression = "gzip"
+)
+
+// Magic numbers used to recognize the codec of a stored shard. A shard
+// that was stored by an engine with a different setting can therefore
+// still be read.
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, _ := zstd.NewWriter(nil)
+		return enc
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, _ := zstd.NewReader(nil)
+		return dec
+	})
+)
+
+// compressChunk compresses data with the engine's codec. It returns data
+// unchanged and false when compression is disabled or does not make the
+// shard smaller, so a stored shard is compressed exactly when it is smaller
+// than its chunk.
+func (e *Engine) compressChunk(data []byte) ([]byte, bool) {
+	var out []byte
+	switch e.compression {
+	case CompressionZstd:
+		out = zstdEncoder().EncodeAll(data, nil)
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil || zw.Close() != nil {
+			return data, false
+		}
+		out = buf.Bytes()
+	default:
+		return data, false
+	}
+	if len(out) >= len(data) {
+		return data, false
+	}
+	return out, true
+}
+
+// decodeShard returns the content of a stored shard, decompressing it if
+// compressed is set.
+func decodeShard(data []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		return data, nil
+	}
+	switch {
+	case bytes.HasPrefix(data, zstdMagic):
+		return zstdDecoder().DecodeAll(data, nil)
+	case bytes.HasPrefix(data, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("%w: unknown shard compression", ErrCorruptShard)
+}
@@ -0,0 +1,102 @@
+package sharded_test
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+// storedBytes sums the size of every file in fs.
+func storedBytes(t *testing.T, fs afero.Fs) int64 {
+	t.Helper()
+	var total int64
+	err := afero.Walk(fs, "", func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	return total
+}
+
+func TestCompression_Suite(t *testing.T) {
+	for _, c := range []sharded.Compression{sharded.CompressionZstd, sharded.CompressionGzip} {
+		t.Run(string(c), func(t *testing.T) {
+			sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+				sharded.WithCompression(c)))
+		})
+	}
+	t.Run("verify", func(t *testing.T) {
+		sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+			sharded.WithCompression(sharded.CompressionZstd), sharded.WithVerifyOnRead(true)))
+	})
+}
+
+func TestCompression_Shrinks(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 4096, sharded.WithCompression(sharded.CompressionZstd))
+	content := strings.Repeat("2024-06-01 INFO request served in 3ms\n", 1000)
+
+	writeFile(t, engine, "app.log", content)
+	if stored := storedBytes(t, shardsFs); stored*5 > int64(len(content)) {
+		t.Errorf("stored %d bytes for %d bytes of logs, want at least 5x smaller", stored, len(content))
+	}
+	if got := readFile(t, engine, "app.log"); got != content {
+		t.Errorf("round trip mismatch: got %d bytes", len(got))
+	}
+
+	// Random data does not compress and is stored as is.
+	random := make([]byte, 4096)
+	_, _ = rand.Read(random)
+	writeFile(t, engine, "random.bin", string(random))
+	if got := readFile(t, engine, "random.bin"); got != string(random) {
+		t.Error("random data round trip mismatch")
+	}
+
+	// Appending keeps the flags of the inherited chunks.
+	w, err := engine.OpenFile(context.Background(), "app.log", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = io.WriteString(w, "tail\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := readFile(t, engine, "app.log"); got != content+"tail\n" {
+		t.Errorf("appended file has %d bytes, want %d", len(got), len(content)+5)
+	}
+}
+
+func TestCompression_SharedStore(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	zstdEngine := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithCompression(sharded.CompressionZstd))
+	gzipEngine := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithCompression(sharded.CompressionGzip))
+	plain := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithVerifyOnRead(true))
+	content := strings.Repeat("shared content ", 500)
+
+	writeFile(t, zstdEngine, "a.txt", content)
+	before := storedBytes(t, shardsFs)
+
+	// The other engines reuse the zstd shards instead of storing their own.
+	writeFile(t, gzipEngine, "a.txt", content)
+	writeFile(t, plain, "a.txt", content)
+	if after := storedBytes(t, shardsFs); after != before {
+		t.Errorf("shard store grew from %d to %d bytes, want deduplicated", before, after)
+	}
+	for name, engine := range map[string]*sharded.Engine{"gzip": gzipEngine, "plain": plain} {
+		if got := readFile(t, engine, "a.txt"); got != content {
+			t.Errorf("%s engine read %d bytes, want %d", name, len(got), len(content))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package sharded
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// writeFile writes data to name on fs, syncing it unless durability is
+// sbox.DurabilityNone.
+func (e *Engine) writeFile(fs afero.Fs, name string, data []byte) error {
+	if e.space != nil {
+		if err := e.space.Reserve(name, int64(len(data))); err != nil {
+			return err
+		}
+	}
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && e.syncs() {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if e.space != nil {
+		err = e.space.Check(name, int64(len(data)), err)
+	}
+	return err
+}
+
+// syncs reports whether file data is synced.
+func (e *Engine) syncs() bool {
+	return e.durability == sbox.DurabilityFlush || e.durability == sbox.DurabilityFsync
+}
+
+// syncDirs syncs dir on fs and its parents when durability is
+// sbox.DurabilityFsync, so that the entries created in them survive a
+// power loss. Windows cannot sync directories.
+func (e *Engine) syncDirs(fs afero.Fs, dir string) error {
+	if e.durability != sbox.DurabilityFsync || runtime.GOOS == "windows" {
+		return nil
+	}
+	for {
+		d, err := fs.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = d.Sync()
+		if cerr := d.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
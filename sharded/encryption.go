@@ -0,0 +1,123 @@
+package sharded
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/nuln/sbox"
+)
+
+// EncryptionMode selects how chunk keys are chosen.
+type EncryptionMode string
+
+const (
+	// EncryptionRandom encrypts every chunk with a fresh random key. Equal
+	// chunks produce different shards, so nothing about the content can be
+	// learned from the shard store, at the cost of deduplication.
+	EncryptionRandom EncryptionMode = "random"
+
+	// EncryptionConvergent derives each chunk key from the chunk's hash.
+	// Equal chunks produce equal shards, so deduplication keeps working,
+	// even across engines with different master keys. Someone who already
+	// has a chunk can tell whether the store holds it.
+	EncryptionConvergent EncryptionMode = "convergent"
+)
+
+// MasterKeySize is the required size of an encryption master key.
+const MasterKeySize = 32
+
+// convergentKeyInfo separates convergent chunk keys from other uses of the
+// chunk hash.
+var convergentKeyInfo = []byte("sbox/sharded convergent chunk key")
+
+// encryptor seals chunks with per-chunk AES-256-GCM keys. Chunk keys are
+// stored in manifests, wrapped with the master key.
+type encryptor struct {
+	mode   EncryptionMode
+	master cipher.AEAD
+}
+
+func newEncryptor(mode EncryptionMode, masterKey []byte) (*encryptor, error) {
+	if mode != EncryptionRandom && mode != EncryptionConvergent {
+		return nil, fmt.Errorf("sbox/sharded: unknown encryption mode %q: %w", mode, sbox.ErrInvalid)
+	}
+	if len(masterKey) != MasterKeySize {
+		return nil, fmt.Errorf("sbox/sharded: master key must be %d bytes: %w", MasterKeySize, sbox.ErrInvalid)
+	}
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptor{mode: mode, master: master}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data and returns the shard contents and the wrapped key.
+// Each chunk key encrypts exactly one plaintext, so a zero nonce is safe and
+// keeps convergent shards deterministic.
+func (x *encryptor) seal(data []byte) ([]byte, string, error) {
+	key := make([]byte, 32)
+	if x.mode == EncryptionConvergent {
+		sum := sha256.Sum256(data)
+		mac := hmac.New(sha256.New, sum[:])
+		mac.Write(convergentKeyInfo)
+		key = mac.Sum(key[:0])
+	} else if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, "", err
+	}
+	sealed := gcm.Seal(nil, make([]byte, gcm.NonceSize()), data, nil)
+
+	nonce := make([]byte, x.master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	wrapped := x.master.Seal(nonce, nonce, key, nil)
+	return sealed, base64.RawStdEncoding.EncodeToString(wrapped), nil
+}
+
+// open unwraps key and decrypts a shard.
+func (x *encryptor) open(data []byte, wrappedKey string) ([]byte, error) {
+	wrapped, err := base64.RawStdEncoding.DecodeString(wrappedKey)
+	if err != nil || len(wrapped) < x.master.NonceSize() {
+		return nil, errors.New("sbox/sharded: malformed chunk key")
+	}
+	n := x.master.NonceSize()
+	key, err := x.master.Open(nil, wrapped[:n], wrapped[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("sbox/sharded: cannot unwrap chunk key (wrong master key?): %w", sbox.ErrPermission)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, make([]byte, gcm.NonceSize()), data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decryption failed", ErrCorruptShard)
+	}
+	return plain, nil
+}
+
+// decryptChunk decrypts a shard of an encrypted chunk.
+func (e *Engine) decryptChunk(data []byte, wrappedKey string) ([]byte, error) {
+	if e.encryptor == nil {
+		return nil, fmt.Errorf("sbox/sharded: file is encrypted but no master key is configured: %w", sbox.ErrPermission)
+	}
+	return e.encryptor.open(data, wrappedKey)
+}
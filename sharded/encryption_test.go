@@ -0,0 +1,110 @@
+package sharded_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func masterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, sharded.MasterKeySize)
+}
+
+func TestEncryption_Suite(t *testing.T) {
+	for _, mode := range []sharded.EncryptionMode{sharded.EncryptionRandom, sharded.EncryptionConvergent} {
+		t.Run(string(mode), func(t *testing.T) {
+			sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+				sharded.WithEncryption(mode, masterKey(1))))
+		})
+	}
+	t.Run("compressed+verify", func(t *testing.T) {
+		sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+			sharded.WithEncryption(sharded.EncryptionConvergent, masterKey(1)),
+			sharded.WithCompression(sharded.CompressionZstd),
+			sharded.WithVerifyOnRead(true)))
+	})
+}
+
+func TestEncryption_HidesContent(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 1024,
+		sharded.WithEncryption(sharded.EncryptionRandom, masterKey(1)))
+	secret := "the launch code is 0000"
+
+	writeFile(t, engine, "secret.txt", secret)
+	err := afero.Walk(shardsFs, "", func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := afero.ReadFile(shardsFs, p)
+		if strings.Contains(string(data), secret) {
+			t.Errorf("shard %s contains the plaintext", p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if got := readFile(t, engine, "secret.txt"); got != secret {
+		t.Errorf("read back %q", got)
+	}
+}
+
+func TestEncryption_Dedup(t *testing.T) {
+	content := strings.Repeat("shared document ", 200)
+
+	for _, tc := range []struct {
+		mode   sharded.EncryptionMode
+		shared bool
+	}{
+		{sharded.EncryptionConvergent, true},
+		{sharded.EncryptionRandom, false},
+	} {
+		shardsFs := afero.NewMemMapFs()
+		alice := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithEncryption(tc.mode, masterKey(1)))
+		bob := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithEncryption(tc.mode, masterKey(2)))
+
+		writeFile(t, alice, "doc.txt", content)
+		var before, after int
+		countShards(t, shardsFs, "", &before)
+		writeFile(t, bob, "doc.txt", content)
+		countShards(t, shardsFs, "", &after)
+
+		if shared := after == before; shared != tc.shared {
+			t.Errorf("%s: shards %d -> %d, want shared=%v", tc.mode, before, after, tc.shared)
+		}
+		if got := readFile(t, bob, "doc.txt"); got != content {
+			t.Errorf("%s: bob read %d bytes", tc.mode, len(got))
+		}
+	}
+}
+
+func TestEncryption_WrongKey(t *testing.T) {
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writer := sharded.New(manifestFs, shardsFs, 1024, sharded.WithEncryption(sharded.EncryptionRandom, masterKey(1)))
+	writeFile(t, writer, "f.txt", "private")
+
+	for name, engine := range map[string]*sharded.Engine{
+		"wrong key": sharded.New(manifestFs, shardsFs, 1024, sharded.WithEncryption(sharded.EncryptionRandom, masterKey(2))),
+		"no key":    sharded.New(manifestFs, shardsFs, 1024),
+	} {
+		r, err := engine.Open(context.Background(), "f.txt")
+		if err != nil {
+			t.Fatalf("%s: Open: %v", name, err)
+		}
+		_, err = r.Read(make([]byte, 16))
+		_ = r.Close()
+		if !errors.Is(err, sbox.ErrPermission) {
+			t.Errorf("%s: Read = %v, want %v", name, err, sbox.ErrPermission)
+		}
+	}
+}
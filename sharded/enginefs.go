@@ -0,0 +1,347 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// EngineFs returns an afero.Fs storing its files in engine, so that any
+// StorageEngine can hold the manifests or shards of a sharded Engine, e.g.
+// shards in an object store and manifests on a local disk:
+//
+//	engine := sharded.New(manifestFs, sharded.EngineFs(remote), 0)
+//
+// Calls run with context.Background. Files opened for writing are written
+// through engine.OpenFile, so what is written becomes visible when the
+// engine makes it so, usually on Close. O_EXCL creates a file with
+// sbox.ConditionalWriter where the engine supports it, and checks that
+// the file does not exist otherwise. The reference count index and the
+// journal append to their logs, which object stores do not support;
+// enable them only with engines that do.
+func EngineFs(engine sbox.StorageEngine) afero.Fs {
+	return &engineFs{engine: engine}
+}
+
+// engineFs is an afero.Fs over a StorageEngine.
+type engineFs struct {
+	engine sbox.StorageEngine
+}
+
+// enginePath converts an afero name to an engine path.
+func enginePath(name string) string {
+	p := filepath.ToSlash(name)
+	if p == "." || p == "/" {
+		return ""
+	}
+	return p
+}
+
+// fsError converts an engine error into one the os predicates recognize,
+// such as os.IsNotExist, which do not look into wrapped errors.
+func fsError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, sentinel := range []error{os.ErrNotExist, os.ErrExist, os.ErrPermission} {
+		if errors.Is(err, sentinel) {
+			return &os.PathError{Op: op, Path: name, Err: sentinel}
+		}
+	}
+	return err
+}
+
+func (f *engineFs) Name() string { return "sbox" }
+
+func (f *engineFs) Create(name string) (afero.File, error) {
+	w, err := f.engine.Create(context.Background(), enginePath(name))
+	if err != nil {
+		return nil, fsError("create", name, err)
+	}
+	return &engineFile{fs: f, name: name, w: w}, nil
+}
+
+func (f *engineFs) Mkdir(name string, perm os.FileMode) error {
+	return f.MkdirAll(name, perm)
+}
+
+func (f *engineFs) MkdirAll(path string, perm os.FileMode) error {
+	return fsError("mkdir", path, f.engine.MkdirAll(context.Background(), enginePath(path)))
+}
+
+// Open opens a file for reading, or a directory for listing. Engines may
+// open directories as files, so it stats name first.
+func (f *engineFs) Open(name string) (afero.File, error) {
+	ctx := context.Background()
+	info, err := f.engine.Stat(ctx, enginePath(name))
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+	file := &engineFile{fs: f, name: name, info: info.ToFileInfo()}
+	if !info.IsDir {
+		if file.r, err = f.engine.Open(ctx, enginePath(name)); err != nil {
+			return nil, fsError("open", name, err)
+		}
+	}
+	return file, nil
+}
+
+func (f *engineFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return f.Open(name)
+	}
+	ctx := context.Background()
+	p := enginePath(name)
+	if flag&os.O_EXCL != 0 {
+		if cw, ok := f.engine.(sbox.ConditionalWriter); ok {
+			w, err := cw.CreateIf(ctx, p, sbox.Precondition{IfNoneMatch: true})
+			if errors.Is(err, sbox.ErrPreconditionFailed) {
+				err = os.ErrExist
+			}
+			if err != nil {
+				return nil, fsError("open", name, err)
+			}
+			return &engineFile{fs: f, name: name, w: w}, nil
+		}
+		if _, err := f.engine.Stat(ctx, p); err == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+		flag &^= os.O_EXCL
+	}
+	w, err := f.engine.OpenFile(ctx, p, flag, perm)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+	return &engineFile{fs: f, name: name, w: w}, nil
+}
+
+// Remove removes a file or an empty directory; engines remove directories
+// with their content.
+func (f *engineFs) Remove(name string) error {
+	ctx := context.Background()
+	p := enginePath(name)
+	info, err := f.engine.Stat(ctx, p)
+	if err != nil {
+		return fsError("remove", name, err)
+	}
+	if info.IsDir {
+		entries, err := f.engine.ReadDir(ctx, p)
+		if err != nil {
+			return fsError("remove", name, err)
+		}
+		if len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return fsError("remove", name, f.engine.Remove(ctx, p))
+}
+
+func (f *engineFs) RemoveAll(path string) error {
+	err := f.engine.Remove(context.Background(), enginePath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return fsError("remove", path, err)
+}
+
+func (f *engineFs) Rename(oldname, newname string) error {
+	return fsError("rename", oldname, f.engine.Rename(context.Background(), enginePath(oldname), enginePath(newname)))
+}
+
+func (f *engineFs) Stat(name string) (os.FileInfo, error) {
+	info, err := f.engine.Stat(context.Background(), enginePath(name))
+	if err != nil {
+		return nil, fsError("stat", name, err)
+	}
+	return info.ToFileInfo(), nil
+}
+
+func (f *engineFs) Chmod(name string, mode os.FileMode) error {
+	pm, ok := f.engine.(sbox.PermissionManager)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return fsError("chmod", name, pm.Chmod(context.Background(), enginePath(name), mode))
+}
+
+func (f *engineFs) Chown(name string, uid, gid int) error {
+	pm, ok := f.engine.(sbox.PermissionManager)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return fsError("chown", name, pm.Chown(context.Background(), enginePath(name), uid, gid))
+}
+
+// Chtimes sets the modification time; engines do not record access times.
+func (f *engineFs) Chtimes(name string, atime, mtime time.Time) error {
+	ms, ok := f.engine.(sbox.ModTimeSetter)
+	if !ok {
+		return sbox.ErrNotSupported
+	}
+	return fsError("chtimes", name, ms.SetModTime(context.Background(), enginePath(name), mtime))
+}
+
+// engineFile is a file of an engineFs: a reader, a writer or a directory.
+type engineFile struct {
+	fs   *engineFs
+	name string
+	r    sbox.ReadSeekCloser // Set for files opened for reading
+	w    sbox.WriteCloser    // Set for files opened for writing
+	info os.FileInfo         // Loaded by Open or Stat
+
+	mu      sync.Mutex    // Serializes ReadAt without io.ReaderAt
+	entries []os.FileInfo // Directory entries not returned yet
+	listed  bool
+}
+
+func (f *engineFile) Name() string { return f.name }
+
+func (f *engineFile) Stat() (os.FileInfo, error) {
+	if f.info == nil {
+		info, err := f.fs.Stat(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.info = info
+	}
+	return f.info, nil
+}
+
+func (f *engineFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: sbox.ErrNotSupported}
+	}
+	return f.r.Read(p)
+}
+
+func (f *engineFile) ReadAt(p []byte, off int64) (int, error) {
+	if ra, ok := f.r.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+	if f.r == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: sbox.ErrNotSupported}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *engineFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r != nil {
+		return f.r.Seek(offset, whence)
+	}
+	if s, ok := f.w.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: sbox.ErrNotSupported}
+}
+
+func (f *engineFile) Write(p []byte) (int, error) {
+	if f.w == nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: sbox.ErrNotSupported}
+	}
+	return f.w.Write(p)
+}
+
+func (f *engineFile) WriteAt(p []byte, off int64) (int, error) {
+	wa, ok := f.w.(io.WriterAt)
+	if !ok {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: sbox.ErrNotSupported}
+	}
+	return wa.WriteAt(p, off)
+}
+
+func (f *engineFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Sync syncs writers that support it; others store their content on
+// Close.
+func (f *engineFile) Sync() error {
+	if s, ok := f.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (f *engineFile) Truncate(size int64) error {
+	t, ok := f.w.(interface{ Truncate(int64) error })
+	if !ok {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: sbox.ErrNotSupported}
+	}
+	return t.Truncate(size)
+}
+
+func (f *engineFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.r != nil || f.w != nil {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	if !f.listed {
+		entries, err := f.fs.engine.ReadDir(context.Background(), enginePath(f.name))
+		if err != nil {
+			return nil, fsError("readdir", f.name, err)
+		}
+		for _, e := range entries {
+			f.entries = append(f.entries, e.ToFileInfo())
+		}
+		f.listed = true
+	}
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *engineFile) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	names := make([]string, len(entries))
+	for i, info := range entries {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (f *engineFile) Close() error {
+	switch {
+	case f.r != nil:
+		return f.r.Close()
+	case f.w != nil:
+		err := f.w.Close()
+		if errors.Is(err, sbox.ErrPreconditionFailed) {
+			// Created with O_EXCL by another writer meanwhile.
+			err = &os.PathError{Op: "close", Path: f.name, Err: os.ErrExist}
+		}
+		return err
+	}
+	return nil
+}
+
+// Compile-time interface checks.
+var (
+	_ afero.Fs   = (*engineFs)(nil)
+	_ afero.File = (*engineFile)(nil)
+)
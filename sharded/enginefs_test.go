@@ -0,0 +1,79 @@
+package sharded_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+// bareEngine hides the extensions of an engine, as a minimal backend.
+type bareEngine struct{ sbox.StorageEngine }
+
+func TestEngineFs_Suite(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		engine := sharded.New(sharded.EngineFs(memory.New()), sharded.EngineFs(memory.New()), 16)
+		sboxtest.StorageTestSuite(t, engine)
+	})
+	t.Run("bare shards", func(t *testing.T) {
+		engine := sharded.New(sharded.EngineFs(memory.New()), sharded.EngineFs(bareEngine{memory.New()}), 16,
+			sharded.WithVerifyOnRead(true))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+}
+
+func TestEngineFs_Shards(t *testing.T) {
+	ctx := context.Background()
+	shards := memory.New()
+	engine := sharded.New(sharded.EngineFs(memory.New()), sharded.EngineFs(shards), 8)
+	writeFile(t, engine, "a.txt", "aaaaaaaabbbbbbbb")
+	writeFile(t, engine, "b.txt", "aaaaaaaacccccccc")
+
+	// The shards are files of the engine, deduplicated.
+	var n int
+	err := sbox.Walk(ctx, shards, "", func(path string, info *sbox.EntryInfo, err error) error {
+		if err == nil && !info.IsDir {
+			n++
+		}
+		return err
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("shards = %d, %v; want 3", n, err)
+	}
+
+	if err := engine.Remove(ctx, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := engine.GC(ctx); err != nil || stats.Deleted != 1 {
+		t.Errorf("GC = %+v, %v", stats, err)
+	}
+	if got := readFile(t, engine, "a.txt"); got != "aaaaaaaabbbbbbbb" {
+		t.Errorf("a.txt = %q", got)
+	}
+}
+
+func TestEngineFs_Exclusive(t *testing.T) {
+	for name, engine := range map[string]sbox.StorageEngine{
+		"conditional": memory.New(),
+		"bare":        bareEngine{memory.New()},
+	} {
+		fs := sharded.EngineFs(engine)
+		f, err := fs.OpenFile("lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			t.Fatalf("%s: first OpenFile: %v", name, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.OpenFile("lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+			t.Errorf("%s: second OpenFile = %v, want an os.IsExist error", name, err)
+		}
+		if _, err := fs.Stat("missing"); !os.IsNotExist(err) {
+			t.Errorf("%s: Stat(missing) = %v, want an os.IsNotExist error", name, err)
+		}
+	}
+}
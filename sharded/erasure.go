@@ -0,0 +1,545 @@
+package sharded
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/mem"
+
+	"github.com/nuln/sbox"
+)
+
+// pieceHeaderSize is the size of the header of every piece file: the
+// length of the shard, then the CRC-32 (IEEE) of the piece, big-endian.
+const pieceHeaderSize = 12
+
+// ReconstructStats describes a Reconstruct run.
+type ReconstructStats struct {
+	Scanned int // Shards examined
+	Rebuilt int // Shards with pieces rewritten
+	Pieces  int // Pieces rewritten
+
+	// Lost lists the shards with fewer pieces left than the data pieces of
+	// the code, which cannot be read or rebuilt.
+	Lost []string
+}
+
+// Reconstruct rewrites the missing and corrupt pieces of every shard of an
+// engine created with WithErasureCoding, so that the full parity protects
+// it again after a store was lost or replaced. Reads rebuild the pieces of
+// the shards they find damaged in the background; Reconstruct covers the
+// others, and like GC is meant to run periodically. It stops promptly when
+// ctx is cancelled, returning the statistics gathered so far together with
+// the context error. Every shard examined is also reported as the
+// "reconstruct" operation to the sbox.Progress attached to ctx.
+func (e *Engine) Reconstruct(ctx context.Context) (*ReconstructStats, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	if e.erasure == nil {
+		return nil, fmt.Errorf("sbox/sharded: erasure coding disabled: %w", sbox.ErrNotSupported)
+	}
+	stats := &ReconstructStats{}
+	tracker := sbox.NewProgressTracker(ctx, "reconstruct")
+	hashes, err := e.erasure.shards()
+	if err != nil {
+		return stats, err
+	}
+	for _, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		stats.Scanned++
+		p := e.shardPath(hash)
+		n, err := e.erasure.rebuild(p, hash)
+		switch {
+		case errors.Is(err, errTooFewPieces):
+			stats.Lost = append(stats.Lost, hash)
+		case err != nil:
+			return stats, err
+		case n > 0:
+			stats.Rebuilt++
+			stats.Pieces += n
+		}
+		tracker.Advance(p, 0, 1)
+	}
+	return stats, nil
+}
+
+// erasureFs is the shard store of an engine with WithErasureCoding: it
+// splits each shard into the data and parity pieces of a Reed-Solomon code,
+// stored as "<hash>.<index>" next to where the shard would be in one store
+// each, and reads a shard from any data pieces of them. Other files are
+// kept in the first store, and directories list the union of all stores,
+// with the shards that can be read in place of their pieces.
+type erasureFs struct {
+	stores []afero.Fs
+	code   *rsCode
+	engine *Engine
+
+	healing sync.Map       // Hashes of the shards being rebuilt after a read
+	heals   sync.WaitGroup // Background rebuilds, waited for by Close
+}
+
+// piece is the location of a piece of a shard.
+type piece struct {
+	fs   afero.Fs
+	name string
+}
+
+// pieces returns the locations of the pieces of the shard at name: the
+// stores of its placement in turn, wrapping around with fewer stores than
+// pieces.
+func (f *erasureFs) pieces(name, hash string) []piece {
+	order := placement(f.stores, hash)
+	pieces := make([]piece, f.code.data+f.code.parity)
+	for i := range pieces {
+		pieces[i] = piece{fs: order[i%len(order)], name: name + "." + strconv.Itoa(i)}
+	}
+	return pieces
+}
+
+// pieceOf returns the hash of the shard of which name is a piece, or "".
+func (f *erasureFs) pieceOf(name string) string {
+	base, index, ok := strings.Cut(filepath.Base(name), ".")
+	if !ok || !isShardName(base) {
+		return ""
+	}
+	if i, err := strconv.Atoi(index); err != nil || i < 0 || i >= f.code.data+f.code.parity {
+		return ""
+	}
+	return base
+}
+
+// readPiece returns the content of a piece and the length of its shard.
+func readPiece(p piece) ([]byte, int64, error) {
+	data, err := afero.ReadFile(p.fs, p.name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < pieceHeaderSize || crc32.ChecksumIEEE(data[pieceHeaderSize:]) != binary.BigEndian.Uint32(data[8:]) {
+		return nil, 0, fmt.Errorf("%w: piece %s", ErrCorruptShard, p.name)
+	}
+	return data[pieceHeaderSize:], int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// load reads the pieces of the shard at name, the parity ones only as
+// needed, and returns the shard. It reports whether a piece it read was
+// missing or corrupt.
+func (f *erasureFs) load(name, hash string) ([]byte, bool, error) {
+	pieces := make([][]byte, f.code.data+f.code.parity)
+	length, size, found, damaged := int64(-1), 0, 0, false
+	var firstErr error
+	for i, p := range f.pieces(name, hash) {
+		if found == f.code.data {
+			break
+		}
+		data, n, err := readPiece(p)
+		if err == nil && length >= 0 && (n != length || len(data) != size) {
+			err = fmt.Errorf("%w: piece %s", ErrCorruptShard, p.name)
+		}
+		if err != nil {
+			damaged = true
+			if firstErr == nil || os.IsNotExist(firstErr) {
+				firstErr = err
+			}
+			continue
+		}
+		length, size = n, len(data)
+		pieces[i] = data
+		found++
+	}
+	switch {
+	case found == 0:
+		return nil, damaged, firstErr
+	case found < f.code.data:
+		return nil, damaged, fmt.Errorf("%w: %s: %d of %d pieces left", ErrCorruptShard, hash, found, f.code.data)
+	}
+	if err := f.code.reconstruct(pieces, size); err != nil {
+		return nil, damaged, err
+	}
+	shard := make([]byte, 0, size*f.code.data)
+	for _, p := range pieces[:f.code.data] {
+		shard = append(shard, p...)
+	}
+	if length > int64(len(shard)) {
+		return nil, damaged, fmt.Errorf("%w: %s", ErrCorruptShard, hash)
+	}
+	return shard[:length], damaged, nil
+}
+
+// put writes every piece of the shard at name.
+func (f *erasureFs) put(name, hash string, data []byte) error {
+	split := f.code.split(data)
+	for i, p := range f.pieces(name, hash) {
+		if err := f.putPiece(p, int64(len(data)), split[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putPiece writes a piece of a shard of length bytes.
+func (f *erasureFs) putPiece(p piece, length int64, data []byte) error {
+	buf := make([]byte, pieceHeaderSize+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(length))
+	binary.BigEndian.PutUint32(buf[8:], crc32.ChecksumIEEE(data))
+	copy(buf[pieceHeaderSize:], data)
+	if err := p.fs.MkdirAll(filepath.Dir(p.name), 0755); err != nil {
+		return err
+	}
+	return f.engine.writeFile(p.fs, p.name, buf)
+}
+
+// rebuild rewrites the missing and corrupt pieces of the shard at name and
+// returns how many it wrote. It returns errTooFewPieces if the shard
+// cannot be rebuilt.
+func (f *erasureFs) rebuild(name, hash string) (int, error) {
+	locs := f.pieces(name, hash)
+	pieces := make([][]byte, len(locs))
+	var damaged []int
+	length, size := int64(-1), 0
+	for i, p := range locs {
+		data, n, err := readPiece(p)
+		if err == nil && length >= 0 && (n != length || len(data) != size) {
+			err = fmt.Errorf("%w: piece %s", ErrCorruptShard, p.name)
+		}
+		if err != nil && !os.IsNotExist(err) && !errors.Is(err, ErrCorruptShard) {
+			return 0, err
+		}
+		if err != nil {
+			damaged = append(damaged, i)
+			continue
+		}
+		length, size = n, len(data)
+		pieces[i] = data
+	}
+	if len(damaged) == 0 {
+		return 0, nil
+	}
+	if len(locs)-len(damaged) < f.code.data {
+		return 0, errTooFewPieces
+	}
+	if err := f.code.reconstruct(pieces, size); err != nil {
+		return 0, err
+	}
+	for _, i := range damaged {
+		if err := f.putPiece(locs[i], length, pieces[i]); err != nil {
+			return 0, err
+		}
+	}
+	return len(damaged), nil
+}
+
+// heal rebuilds the shard at name in the background, once at a time.
+func (f *erasureFs) heal(name, hash string) {
+	if _, busy := f.healing.LoadOrStore(hash, true); busy {
+		return
+	}
+	f.heals.Add(1)
+	go func() {
+		defer f.heals.Done()
+		defer f.healing.Delete(hash)
+		if n, err := f.rebuild(name, hash); err != nil {
+			f.engine.logger.Warn("sbox/sharded: failed to rebuild shard", "hash", hash, "error", err)
+		} else if n > 0 {
+			f.engine.logger.Info("sbox/sharded: rebuilt shard pieces", "hash", hash, "pieces", n)
+		}
+	}()
+}
+
+// shards returns the hashes of the shards with pieces in any store, in
+// hash order.
+func (f *erasureFs) shards() ([]string, error) {
+	seen := make(map[string]bool)
+	for _, fs := range f.stores {
+		err := afero.Walk(fs, "", func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if p != "" && !isHashDir(p) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if hash := f.pieceOf(p); hash != "" && filepath.Dir(p) == filepath.Dir(sbox.HashPath(hash)) {
+				seen[hash] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	hashes := make([]string, 0, len(seen))
+	for hash := range seen {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// eachPiece calls fn with every piece of the shard at name that exists.
+// It returns a not-exist error if there is none.
+func (f *erasureFs) eachPiece(name, hash string, fn func(afero.Fs, string) error) error {
+	var notExist error
+	found := false
+	for _, p := range f.pieces(name, hash) {
+		switch err := fn(p.fs, p.name); {
+		case err == nil:
+			found = true
+		case os.IsNotExist(err):
+			notExist = err
+		default:
+			return err
+		}
+	}
+	if found {
+		return nil
+	}
+	return notExist
+}
+
+func (f *erasureFs) Name() string { return "sharded-erasure" }
+
+func (f *erasureFs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (f *erasureFs) Mkdir(name string, perm os.FileMode) error {
+	return f.MkdirAll(name, perm)
+}
+
+// MkdirAll creates the directory in every store, since the pieces below a
+// hash directory are spread over all of them.
+func (f *erasureFs) MkdirAll(path string, perm os.FileMode) error {
+	for _, fs := range f.stores {
+		if err := fs.MkdirAll(path, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open reads a shard whole, rebuilding its damaged pieces in the
+// background. A directory lists the entries of all stores.
+func (f *erasureFs) Open(name string) (afero.File, error) {
+	if hash := shardOf(name); hash != "" {
+		data, damaged, err := f.load(name, hash)
+		if damaged && err == nil {
+			f.heal(name, hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fd := mem.CreateFile(name)
+		w := mem.NewFileHandle(fd)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		return mem.NewReadOnlyFileHandle(fd), nil
+	}
+	var firstErr error
+	for _, fs := range f.stores {
+		info, err := fs.Stat(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !info.IsDir() {
+			return fs.Open(name)
+		}
+		return &unionDir{File: &emptyDir{name: name}, more: func() ([]os.FileInfo, error) {
+			return f.list(name)
+		}}, nil
+	}
+	return nil, firstErr
+}
+
+// list returns the entries of the directory name in all stores, with the
+// shards that can be read in place of their pieces.
+func (f *erasureFs) list(name string) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	seen := make(map[string]bool)
+	for _, fs := range f.stores {
+		infos, err := afero.ReadDir(fs, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			entry := info.Name()
+			if hash := f.pieceOf(entry); hash != "" {
+				entry = hash
+			}
+			if seen[entry] {
+				continue
+			}
+			seen[entry] = true
+			if entry != info.Name() {
+				if info, err = f.Stat(filepath.Join(name, entry)); err != nil {
+					continue // Lost
+				}
+			}
+			entries = append(entries, info)
+		}
+	}
+	return entries, nil
+}
+
+// OpenFile opens shards for writing as a buffer split into pieces on
+// Close; other files are written to the first store.
+func (f *erasureFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return f.Open(name)
+	}
+	hash := shardOf(name)
+	if hash == "" {
+		return f.stores[0].OpenFile(name, flag, perm)
+	}
+	if flag&os.O_EXCL != 0 {
+		if _, err := f.Stat(name); err == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+	}
+	return &shardWriter{name: name, hash: hash, put: f.put}, nil
+}
+
+// Remove removes every piece of a shard, or name from every store that
+// has it.
+func (f *erasureFs) Remove(name string) error {
+	if hash := shardOf(name); hash != "" {
+		return f.eachPiece(name, hash, func(fs afero.Fs, p string) error { return fs.Remove(p) })
+	}
+	return allStores(f.stores, func(fs afero.Fs) error { return fs.Remove(name) })
+}
+
+func (f *erasureFs) RemoveAll(path string) error {
+	for _, fs := range f.stores {
+		if err := fs.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename renames within the first store, except to a shard path, as when
+// a shard is promoted: the file is then split into pieces, and removed.
+func (f *erasureFs) Rename(oldname, newname string) error {
+	hash := shardOf(newname)
+	if hash == "" {
+		return f.stores[0].Rename(oldname, newname)
+	}
+	data, err := afero.ReadFile(f, oldname)
+	if err != nil {
+		return err
+	}
+	if err := f.put(newname, hash, data); err != nil {
+		return err
+	}
+	return f.Remove(oldname)
+}
+
+// Stat describes a shard as stored whole, and finds it only if enough of
+// its pieces exist to read it.
+func (f *erasureFs) Stat(name string) (os.FileInfo, error) {
+	hash := shardOf(name)
+	if hash == "" {
+		var firstErr error
+		for _, fs := range f.stores {
+			info, err := fs.Stat(name)
+			if err == nil {
+				return info, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+	var info packedInfo
+	found := 0
+	var firstErr error
+	for _, p := range f.pieces(name, hash) {
+		if found == f.code.data {
+			break
+		}
+		pi, err := p.fs.Stat(p.name)
+		if err == nil && found == 0 {
+			info, err = pieceInfo(p, hash, pi.ModTime())
+		}
+		if err != nil {
+			if firstErr == nil || os.IsNotExist(firstErr) {
+				firstErr = err
+			}
+			continue
+		}
+		found++
+	}
+	if found < f.code.data {
+		if firstErr == nil || found > 0 {
+			firstErr = &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, firstErr
+	}
+	return info, nil
+}
+
+// pieceInfo describes the shard of a piece from its header.
+func pieceInfo(p piece, hash string, modTime time.Time) (packedInfo, error) {
+	file, err := p.fs.Open(p.name)
+	if err != nil {
+		return packedInfo{}, err
+	}
+	defer file.Close()
+	var header [pieceHeaderSize]byte
+	if _, err := io.ReadFull(file, header[:]); err != nil {
+		return packedInfo{}, fmt.Errorf("%w: piece %s", ErrCorruptShard, p.name)
+	}
+	length := int64(binary.BigEndian.Uint64(header[:]))
+	return packedInfo{name: hash, entry: packEntry{length: length, modTime: modTime.Unix()}}, nil
+}
+
+func (f *erasureFs) Chmod(name string, mode os.FileMode) error {
+	if hash := shardOf(name); hash != "" {
+		return f.eachPiece(name, hash, func(fs afero.Fs, p string) error { return fs.Chmod(p, mode) })
+	}
+	return allStores(f.stores, func(fs afero.Fs) error { return fs.Chmod(name, mode) })
+}
+
+func (f *erasureFs) Chown(name string, uid, gid int) error {
+	if hash := shardOf(name); hash != "" {
+		return f.eachPiece(name, hash, func(fs afero.Fs, p string) error { return fs.Chown(p, uid, gid) })
+	}
+	return allStores(f.stores, func(fs afero.Fs) error { return fs.Chown(name, uid, gid) })
+}
+
+func (f *erasureFs) Chtimes(name string, atime, mtime time.Time) error {
+	if hash := shardOf(name); hash != "" {
+		return f.eachPiece(name, hash, func(fs afero.Fs, p string) error { return fs.Chtimes(p, atime, mtime) })
+	}
+	return allStores(f.stores, func(fs afero.Fs) error { return fs.Chtimes(name, atime, mtime) })
+}
+
+// Compile-time interface checks.
+var _ afero.Fs = (*erasureFs)(nil)
@@ -0,0 +1,147 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+// loseStore removes everything in fs, as if its disk were replaced.
+func loseStore(t *testing.T, fs afero.Fs) {
+	t.Helper()
+	entries, err := afero.ReadDir(fs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := fs.RemoveAll(e.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestErasure_Suite(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+			sharded.WithErasureCoding(2, 1, afero.NewMemMapFs(), afero.NewMemMapFs()))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+	t.Run("one store+refcount+verify", func(t *testing.T) {
+		engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+			sharded.WithErasureCoding(4, 2), sharded.WithRefcount(true), sharded.WithVerifyOnRead(true))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+}
+
+func TestErasure(t *testing.T) {
+	ctx := context.Background()
+	stores := make([]afero.Fs, 5)
+	for i := range stores {
+		stores[i] = afero.NewMemMapFs()
+	}
+	manifestFs := afero.NewMemMapFs()
+	open := func() *sharded.Engine {
+		return sharded.New(manifestFs, stores[0], 64, sharded.WithErasureCoding(3, 2, stores[1:]...))
+	}
+	engine := open()
+	content := strings.Repeat("a", 64) + strings.Repeat("b", 64) + strings.Repeat("c", 64) + "tail"
+	writeFile(t, engine, "a.txt", content)
+
+	// Four shards of five pieces, one per store.
+	for i, fs := range stores {
+		var n int
+		countShards(t, fs, "", &n)
+		if n != 4 {
+			t.Errorf("store %d holds %d pieces, want 4", i, n)
+		}
+	}
+
+	// Two stores can be lost; the damaged pieces are rebuilt after the
+	// read, which Close waits for.
+	for _, fs := range stores[:2] {
+		loseStore(t, fs)
+	}
+	if got := readFile(t, engine, "a.txt"); got != content {
+		t.Fatalf("a.txt with two stores lost = %q", got)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, fs := range stores[:2] {
+		var n int
+		countShards(t, fs, "", &n)
+		if n != 4 {
+			t.Errorf("store %d holds %d pieces after the read, want 4", i, n)
+		}
+	}
+
+	// Reconstruct rebuilds corrupt and missing pieces of every shard.
+	engine = open()
+	loseStore(t, stores[2])
+	var corrupt string
+	_ = afero.Walk(stores[3], "", func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && corrupt == "" {
+			corrupt = p
+		}
+		return err
+	})
+	if err := afero.WriteFile(stores[3], corrupt, []byte("corrupt piece"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := engine.Reconstruct(ctx)
+	if err != nil || stats.Scanned != 4 || stats.Rebuilt != 4 || stats.Pieces != 5 || len(stats.Lost) != 0 {
+		t.Errorf("Reconstruct = %+v, %v", stats, err)
+	}
+	if stats, err := engine.Reconstruct(ctx); err != nil || stats.Rebuilt != 0 {
+		t.Errorf("second Reconstruct = %+v, %v", stats, err)
+	}
+
+	// GC removes the pieces of unreferenced shards from every store.
+	writeFile(t, engine, "b.txt", strings.Repeat("d", 64))
+	if err := engine.Remove(ctx, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := engine.GC(ctx); err != nil || stats.Deleted != 1 {
+		t.Errorf("GC = %+v, %v", stats, err)
+	}
+	for i, fs := range stores {
+		var n int
+		countShards(t, fs, "", &n)
+		if n != 4 {
+			t.Errorf("store %d holds %d pieces after GC, want 4", i, n)
+		}
+	}
+	if got := readFile(t, engine, "a.txt"); got != content {
+		t.Errorf("a.txt after GC = %q", got)
+	}
+
+	// Losing more stores than parity loses the shards.
+	for _, fs := range stores[:3] {
+		loseStore(t, fs)
+	}
+	if r, err := engine.Open(ctx, "a.txt"); err == nil {
+		_, err = io.ReadAll(r)
+		r.Close()
+		if err == nil {
+			t.Error("read with three stores lost succeeded")
+		}
+	}
+	if stats, err := engine.Reconstruct(ctx); err != nil || len(stats.Lost) != 4 {
+		t.Errorf("Reconstruct with three stores lost = %+v, %v", stats, err)
+	}
+}
+
+func TestErasure_Disabled(t *testing.T) {
+	if _, err := newTestEngine().Reconstruct(context.Background()); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Reconstruct = %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,117 @@
+package sharded_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+// FuzzPaths writes files at arbitrary paths and checks that their
+// manifests stay inside the manifest directory and read back.
+func FuzzPaths(f *testing.F) {
+	for _, p := range []string{"a.txt", "/dir//b.txt", "../../etc/passwd", "a/./b/../c", `x\..\y`, "ünï/cødé", "."} {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		ctx := context.Background()
+		manifestFs := afero.NewMemMapFs()
+		engine := sharded.New(manifestFs, afero.NewMemMapFs(), 16)
+		w, err := engine.Create(ctx, p)
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(w, "fuzzed content")
+		if err := w.Close(); err != nil {
+			return
+		}
+
+		_ = afero.Walk(manifestFs, "/", func(name string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && !strings.HasPrefix(strings.TrimPrefix(name, "/"), "manifests/") {
+				t.Fatalf("Create(%q) wrote %q outside the manifest directory", p, name)
+			}
+			return nil
+		})
+		info, err := engine.Stat(ctx, p)
+		if err != nil {
+			t.Fatalf("Stat(%q) after Create: %v", p, err)
+		}
+		if info.Size != int64(len("fuzzed content")) {
+			t.Fatalf("Stat(%q).Size = %d", p, info.Size)
+		}
+	})
+}
+
+// FuzzReader reads arbitrary manifests, over the shards of a real file,
+// from arbitrary offsets. Reads must not panic, hang or return more than
+// the manifest's size past the offset.
+func FuzzReader(f *testing.F) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	seed := sharded.New(manifestFs, shardsFs, 16)
+	w, err := seed.Create(ctx, "seed.txt")
+	if err != nil {
+		f.Fatalf("Create: %v", err)
+	}
+	_, _ = io.WriteString(w, strings.Repeat("0123456789", 10))
+	if err := w.Close(); err != nil {
+		f.Fatalf("Close: %v", err)
+	}
+	data, err := afero.ReadFile(manifestFs, "manifests/seed.txt.json")
+	if err != nil {
+		f.Fatalf("ReadFile: %v", err)
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		f.Fatalf("UnmarshalManifest: %v", err)
+	}
+	f.Add(data, int64(0), false)
+	f.Add(data, int64(95), true)
+	m.Checksum = ""
+	m.ChunkSizes = []int64{16, 0, -4, 1 << 40}
+	m.Size = 1 << 41
+	bad, _ := json.Marshal(m)
+	f.Add(bad, int64(17), false)
+	f.Add([]byte(`{"chunks":["missing"],"size":10}`), int64(3), true)
+
+	f.Fuzz(func(t *testing.T, manifest []byte, offset int64, verify bool) {
+		engine := sharded.New(manifestFs, shardsFs, 16, sharded.WithVerifyOnRead(verify))
+		if err := afero.WriteFile(manifestFs, "manifests/fuzz.bin.json", manifest, 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := engine.Open(ctx, "fuzz.bin")
+		if err != nil {
+			return
+		}
+		defer func() { _ = r.Close() }()
+		info, err := engine.Stat(ctx, "fuzz.bin")
+		if err != nil {
+			return
+		}
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+
+		done := make(chan int64, 1)
+		go func() {
+			n, _ := io.Copy(io.Discard, io.LimitReader(r, 1<<20))
+			done <- n
+		}()
+		select {
+		case n := <-done:
+			if n > max(info.Size-offset, 0) {
+				t.Fatalf("read %d bytes from offset %d of a %d-byte file", n, offset, info.Size)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("read from offset %d did not return", offset)
+		}
+	})
+}
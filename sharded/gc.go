@@ -0,0 +1,186 @@
+package sharded
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// GCOptions controls a garbage collection run.
+type GCOptions struct {
+	// DryRun reports which shards would be deleted without removing them.
+	DryRun bool
+
+	// Progress, if set, is called after every shard is examined with a
+	// snapshot of the statistics gathered so far.
+	Progress func(stats GCStats)
+
+	// DeleteRate limits deletions to this many shards per second to avoid
+	// I/O storms on busy disks. Zero means unlimited.
+	DeleteRate float64
+
+	// MinAge protects shards modified more recently than this from deletion.
+	// Writers store shards before their manifest, so a small grace period
+	// keeps GC from racing in-flight uploads.
+	MinAge time.Duration
+
+	// SharedManifests lists additional manifest filesystems that reference
+	// the same shard store (e.g. other users in a cross-user dedup setup).
+	// Shards referenced from any of them are kept.
+	SharedManifests []afero.Fs
+}
+
+// GCStats reports the outcome of a garbage collection run.
+type GCStats struct {
+	Manifests  int   // Manifests read during the mark phase
+	Live       int   // Distinct shards referenced by manifests
+	Scanned    int   // Shards examined during the sweep phase
+	Deleted    int   // Orphaned shards deleted (or that would be, in dry-run mode)
+	BytesFreed int64 // Total size of deleted shards
+}
+
+// GC removes shards that are no longer referenced by any manifest. With
+// WithRefcount, it also drops the reference counts of the shards it removes,
+// such as those left by writers that were never closed or aborted.
+func (e *Engine) GC(ctx context.Context) (*GCStats, error) {
+	return e.GCWithProgress(ctx, GCOptions{})
+}
+
+// GCWithProgress removes orphaned shards with progress reporting, dry-run
+// and rate limiting. It stops promptly when ctx is cancelled and returns the
+// statistics gathered so far together with the context error. Every shard
+// examined is also reported as the "gc" operation to the sbox.Progress
+// attached to ctx.
+func (e *Engine) GCWithProgress(ctx context.Context, opts GCOptions) (*GCStats, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	stats := &GCStats{}
+	tracker := sbox.NewProgressTracker(ctx, "gc")
+
+	live := make(map[string]struct{})
+	for _, mfs := range append([]afero.Fs{e.manifestFs}, opts.SharedManifests...) {
+		if err := markManifests(ctx, mfs, live, stats); err != nil {
+			return stats, err
+		}
+	}
+	stats.Live = len(live)
+
+	var interval time.Duration
+	if opts.DeleteRate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.DeleteRate)
+	}
+	var lastDelete time.Time
+	cutoff := time.Now().Add(-opts.MinAge)
+
+	err := afero.Walk(e.shardsFs, "", func(p string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			// Manifests may live in the same filesystem as shards.
+			if p == "manifests" || p == snapshotsDir || p == versionsDir || p == txsDir || p == uploadsDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := info.Name()
+		if !isShardName(name) || filepath.ToSlash(p) != filepath.ToSlash(e.shardPath(name)) {
+			return nil
+		}
+
+		stats.Scanned++
+		if _, ok := live[name]; !ok && !info.ModTime().After(cutoff) {
+			if !opts.DryRun {
+				if interval > 0 && !lastDelete.IsZero() {
+					if wait := interval - time.Since(lastDelete); wait > 0 {
+						timer := time.NewTimer(wait)
+						select {
+						case <-ctx.Done():
+							timer.Stop()
+							return ctx.Err()
+						case <-timer.C:
+						}
+					}
+				}
+				if err := e.removeOrphan(p, name); err != nil {
+					return err
+				}
+				lastDelete = time.Now()
+			}
+			stats.Deleted++
+			stats.BytesFreed += info.Size()
+		}
+		tracker.Advance(p, info.Size(), 1)
+		if opts.Progress != nil {
+			opts.Progress(*stats)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// markManifests records every chunk hash referenced by manifests in mfs,
+// including those held by snapshots, versions, transactions and multipart
+// uploads.
+func markManifests(ctx context.Context, mfs afero.Fs, live map[string]struct{}, stats *GCStats) error {
+	for _, root := range []string{"manifests", snapshotsDir, versionsDir, txsDir, uploadsDir} {
+		if err := markTree(ctx, mfs, root, live, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markTree records every chunk hash referenced by manifests below root.
+func markTree(ctx context.Context, mfs afero.Fs, root string, live map[string]struct{}, stats *GCStats) error {
+	return afero.Walk(mfs, root, func(p string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".json") || info.Name() == snapshotInfoFile {
+			return nil
+		}
+		data, err := afero.ReadFile(mfs, p)
+		if err != nil {
+			return err
+		}
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return err
+		}
+		stats.Manifests++
+		for _, h := range m.Chunks {
+			live[h] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// isShardName reports whether name looks like a hex-encoded SHA-256 digest.
+func isShardName(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
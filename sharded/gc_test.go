@@ -0,0 +1,161 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+// setupGC creates an engine holding one live file and n orphaned shards.
+func setupGC(t *testing.T, n int) (*sharded.Engine, afero.Fs) {
+	t.Helper()
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, sharded.DefaultChunkSize)
+	ctx := context.Background()
+
+	writeFile(t, engine, "keep.txt", "live content")
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("orphan-%d.txt", i)
+		writeFile(t, engine, path, fmt.Sprintf("orphan content %d", i))
+		if err := engine.Remove(ctx, path); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+	}
+	return engine, shardsFs
+}
+
+func writeFile(t *testing.T, engine *sharded.Engine, path, content string) {
+	t.Helper()
+	w, err := engine.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatalf("Write %s: %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, engine *sharded.Engine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestGC_RemovesOrphans(t *testing.T) {
+	engine, shardsFs := setupGC(t, 3)
+
+	var calls int
+	var last sbox.ProgressInfo
+	ctx := sbox.WithProgress(context.Background(), sbox.ProgressFunc(func(p sbox.ProgressInfo) { last = p }))
+	stats, err := engine.GCWithProgress(ctx, sharded.GCOptions{
+		Progress: func(sharded.GCStats) { calls++ },
+	})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Scanned != 4 || stats.Deleted != 3 || stats.Live != 1 {
+		t.Errorf("stats = %+v, want Scanned=4 Deleted=3 Live=1", *stats)
+	}
+	if calls != stats.Scanned {
+		t.Errorf("progress called %d times, want %d", calls, stats.Scanned)
+	}
+	if last.Op != "gc" || last.Items != stats.Scanned {
+		t.Errorf("last progress = %+v, want %d gc items", last, stats.Scanned)
+	}
+
+	count := 0
+	countShards(t, shardsFs, "", &count)
+	if count != 1 {
+		t.Errorf("shards after GC = %d, want 1", count)
+	}
+	if got := readFile(t, engine, "keep.txt"); got != "live content" {
+		t.Errorf("live file = %q, want %q", got, "live content")
+	}
+}
+
+func TestGC_DryRun(t *testing.T) {
+	engine, shardsFs := setupGC(t, 2)
+
+	stats, err := engine.GCWithProgress(context.Background(), sharded.GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Deleted != 2 {
+		t.Errorf("Deleted = %d, want 2", stats.Deleted)
+	}
+
+	count := 0
+	countShards(t, shardsFs, "", &count)
+	if count != 3 {
+		t.Errorf("shards after dry run = %d, want 3", count)
+	}
+}
+
+func TestGC_Cancel(t *testing.T) {
+	engine, _ := setupGC(t, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stats, err := engine.GCWithProgress(ctx, sharded.GCOptions{
+		Progress: func(s sharded.GCStats) {
+			if s.Deleted == 1 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if stats == nil || stats.Deleted != 1 {
+		t.Errorf("partial stats = %+v, want Deleted=1", stats)
+	}
+}
+
+func TestGC_DeleteRate(t *testing.T) {
+	engine, _ := setupGC(t, 4)
+
+	start := time.Now()
+	stats, err := engine.GCWithProgress(context.Background(), sharded.GCOptions{DeleteRate: 20})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Deleted != 4 {
+		t.Fatalf("Deleted = %d, want 4", stats.Deleted)
+	}
+	// Four deletions at 20/s need at least three 50ms gaps.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("GC took %v, want at least 150ms with rate limit", elapsed)
+	}
+}
+
+func TestGC_MinAge(t *testing.T) {
+	engine, _ := setupGC(t, 2)
+
+	stats, err := engine.GCWithProgress(context.Background(), sharded.GCOptions{MinAge: time.Hour})
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Deleted != 0 {
+		t.Errorf("Deleted = %d, want 0 for fresh shards", stats.Deleted)
+	}
+}
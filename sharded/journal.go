@@ -0,0 +1,308 @@
+package sharded
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// journalDir holds the intent records of writes in progress when
+// journaling is enabled:
+//
+//	journal/<id>.log    the logical path, then every shard stored
+//	journal/<id>.json   the manifest to publish, written before it is
+//	                    installed; the commit point of the write
+//
+// Both are removed once the manifest is in place or the write is aborted.
+const journalDir = "journal"
+
+// journalRecord is one line of a write journal log.
+type journalRecord struct {
+	Path  string `json:"path,omitempty"`
+	Shard string `json:"shard,omitempty"`
+}
+
+// writeJournal records a write in progress so that Recover can finish or
+// undo it after a crash. A nil *writeJournal records nothing.
+type writeJournal struct {
+	engine *Engine
+	id     string // Journal path without extension
+
+	mu  sync.Mutex
+	log afero.File
+}
+
+// startJournal returns *j, starting a journal for a write to path on first
+// use. It returns nil when journaling is disabled.
+func (e *Engine) startJournal(j **writeJournal, path string) (*writeJournal, error) {
+	if !e.journal || *j != nil {
+		return *j, nil
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if err := e.manifestFs.MkdirAll(journalDir, 0755); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(journalDir, strconv.FormatInt(time.Now().UnixNano(), 36)+"-"+hex.EncodeToString(id))
+	f, err := e.manifestFs.OpenFile(name+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	*j = &writeJournal{engine: e, id: name, log: f}
+	err = (*j).append(journalRecord{Path: cleanPath(path)})
+	if err == nil {
+		err = e.syncDirs(e.manifestFs, journalDir)
+	}
+	if err != nil {
+		_ = (*j).done()
+		*j = nil
+		return nil, err
+	}
+	return *j, nil
+}
+
+// append writes one record to the log.
+func (j *writeJournal) append(r journalRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.log.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if j.engine.syncs() {
+		return j.log.Sync()
+	}
+	return nil
+}
+
+// record notes that the shard hash is about to be stored. It must be
+// called before the shard is written, so that no shard escapes the log.
+func (j *writeJournal) record(hash string) error {
+	if j == nil {
+		return nil
+	}
+	return j.append(journalRecord{Shard: hash})
+}
+
+// commit records the manifest about to be published.
+func (j *writeJournal) commit(data []byte) error {
+	if j == nil {
+		return nil
+	}
+	return j.engine.writeManifest(j.id+".json", data)
+}
+
+// abandon withdraws the commit of a write whose manifest could not be
+// published. The log stays behind, so Recover collects the shards.
+func (j *writeJournal) abandon() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_ = j.log.Close()
+	_ = j.engine.manifestFs.Remove(j.id + ".json")
+}
+
+// done removes the journal of a write that completed or was aborted.
+func (j *writeJournal) done() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	err := j.log.Close()
+	if rerr := j.engine.manifestFs.Remove(j.id + ".json"); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	if rerr := j.engine.manifestFs.Remove(j.id + ".log"); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Recover finishes or undoes the writes interrupted by a crash when
+// journaling is enabled (see WithJournal). A write that reached its commit
+// point is published; any other is discarded and the shards it stored are
+// deleted unless another manifest references them. With reference
+// counting, the index is then rebuilt from the manifests. Recover also
+// runs RecoverTransactions.
+//
+// Like GC, Recover must not run while files are being written; run it at
+// startup. It returns the number of writes and transactions recovered or
+// discarded.
+func (e *Engine) Recover(ctx context.Context) (int, error) {
+	if e.closed.Load() {
+		return 0, sbox.ErrClosed
+	}
+	entries, err := afero.ReadDir(e.manifestFs, journalDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	n := 0
+	var shards []string
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		id, ok := strings.CutSuffix(filepath.Join(journalDir, entry.Name()), ".log")
+		if !ok {
+			continue
+		}
+		stored, err := e.recoverWrite(id)
+		if err != nil {
+			return n, err
+		}
+		shards = append(shards, stored...)
+		n++
+	}
+	if n > 0 {
+		if err := e.collectShards(shards); err != nil {
+			return n, err
+		}
+		// Whatever remains in the journal directory are manifest temporary
+		// files of commits that never happened.
+		if err := e.manifestFs.RemoveAll(journalDir); err != nil {
+			return n, err
+		}
+	}
+
+	txs, err := e.RecoverTransactions(ctx)
+	return n + txs, err
+}
+
+// recoverWrite publishes the committed manifest of the write journaled at
+// id, if any, and removes the journal. It returns the shards that may have
+// become unreferenced: those the write stored and those it released.
+func (e *Engine) recoverWrite(id string) ([]string, error) {
+	f, err := e.manifestFs.Open(id + ".log")
+	if err != nil {
+		return nil, err
+	}
+	var path string
+	var shards []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// A torn final line left by a crash is ignored.
+		var r journalRecord
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		if r.Path != "" {
+			path = r.Path
+		}
+		if isShardName(r.Shard) {
+			shards = append(shards, r.Shard)
+		}
+	}
+	_ = f.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		mPath := e.manifestPath(path)
+		if err := e.removeManifestTmps(mPath); err != nil {
+			return nil, err
+		}
+		data, err := afero.ReadFile(e.manifestFs, id+".json")
+		switch {
+		case err == nil:
+			released, err := e.replayWrite(mPath, data)
+			if err != nil {
+				return nil, err
+			}
+			shards = append(shards, released...)
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+
+	if err := e.manifestFs.Remove(id + ".json"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return shards, e.manifestFs.Remove(id + ".log")
+}
+
+// removeManifestTmps removes the temporary files that manifest writes to
+// mPath interrupted before their rename left behind.
+func (e *Engine) removeManifestTmps(mPath string) error {
+	entries, err := afero.ReadDir(e.manifestFs, filepath.Dir(mPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	prefix := filepath.Base(mPath) + manifestTmpSuffix
+	for _, entry := range entries {
+		// Manifests of other files end in .json.
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if err := e.manifestFs.Remove(filepath.Join(filepath.Dir(mPath), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayWrite installs the committed manifest data at mPath unless the
+// write got that far before the crash, and returns the chunks released by
+// the manifest it replaced. References are not adjusted; Recover rebuilds
+// them.
+func (e *Engine) replayWrite(mPath string, data []byte) ([]string, error) {
+	if current, err := afero.ReadFile(e.manifestFs, mPath); err == nil && bytes.Equal(current, data) {
+		return nil, nil
+	}
+	if err := e.manifestFs.MkdirAll(filepath.Dir(mPath), 0755); err != nil {
+		return nil, err
+	}
+	replaced, err := e.manifestChunks(mPath)
+	if err != nil {
+		return nil, err
+	}
+	old := e.previousManifest(mPath)
+	if err := e.writeManifest(mPath, data); err != nil {
+		return nil, err
+	}
+	return e.supersede(mPath, old, replaced)
+}
+
+// collectShards deletes the shards among stored that no manifest
+// references and, with reference counting, rebuilds the index.
+func (e *Engine) collectShards(stored []string) error {
+	counts, err := e.countManifestRefs()
+	if err != nil {
+		return err
+	}
+	for _, h := range stored {
+		if counts[h] > 0 {
+			continue
+		}
+		if err := e.shardsFs.Remove(e.shardPath(h)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return e.rebuildRefs(counts)
+}
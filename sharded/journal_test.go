@@ -0,0 +1,142 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func newJournalEngine(manifestFs, shardsFs afero.Fs) *sharded.Engine {
+	return sharded.New(manifestFs, shardsFs, 8, sharded.WithJournal(true), sharded.WithRefcount(true))
+}
+
+// crashingFs simulates a crash: once a rename onto a path ending in at is
+// attempted, it and every later rename and removal fail.
+type crashingFs struct {
+	afero.Fs
+	at      string
+	crashed bool
+}
+
+func (f *crashingFs) Rename(oldname, newname string) error {
+	if f.crashed || strings.HasSuffix(newname, f.at) {
+		f.crashed = true
+		return os.ErrPermission
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+func (f *crashingFs) Remove(name string) error {
+	if f.crashed {
+		return os.ErrPermission
+	}
+	return f.Fs.Remove(name)
+}
+
+func (f *crashingFs) RemoveAll(path string) error {
+	if f.crashed {
+		return os.ErrPermission
+	}
+	return f.Fs.RemoveAll(path)
+}
+
+func TestJournal_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, newJournalEngine(afero.NewMemMapFs(), afero.NewMemMapFs()))
+}
+
+func TestJournal_DiscardsIncompleteWrite(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := newJournalEngine(manifestFs, shardsFs)
+	writeFile(t, engine, "keep.txt", "shared!!")
+	kept := shardCount(t, shardsFs)
+
+	// The writer stores shards but never closes, as if the process died.
+	w, err := engine.Create(ctx, "lost.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, _ = io.WriteString(w, "shared!!orphaned shard!!")
+	if shardCount(t, shardsFs) == kept {
+		t.Fatal("no shards stored before Close")
+	}
+
+	restarted := newJournalEngine(manifestFs, shardsFs)
+	if n, err := restarted.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("Recover = %d, %v", n, err)
+	}
+	if got := shardCount(t, shardsFs); got != kept {
+		t.Errorf("shards after Recover = %d, want %d", got, kept)
+	}
+	if _, err := restarted.Stat(ctx, "lost.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("lost.txt: %v", err)
+	}
+	if got := readFile(t, restarted, "keep.txt"); got != "shared!!" {
+		t.Errorf("keep.txt = %q", got)
+	}
+
+	// The rebuilt index still counts the shared shard correctly.
+	if err := restarted.Remove(ctx, "keep.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := shardCount(t, shardsFs); got != 0 {
+		t.Errorf("shards after Remove = %d, want 0", got)
+	}
+	if n, err := restarted.Recover(ctx); err != nil || n != 0 {
+		t.Errorf("second Recover = %d, %v", n, err)
+	}
+}
+
+func TestJournal_ReplaysCommittedWrite(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	writeFile(t, newJournalEngine(manifestFs, shardsFs), "a.txt", "old content")
+
+	// The process dies after the commit point, before the manifest is
+	// renamed into place.
+	crashing := &crashingFs{Fs: manifestFs, at: "a.txt.json"}
+	w, err := newJournalEngine(crashing, shardsFs).Create(ctx, "a.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, _ = io.WriteString(w, "new content")
+	if err := w.Close(); err == nil {
+		t.Fatal("Close succeeded")
+	}
+
+	restarted := newJournalEngine(manifestFs, shardsFs)
+	if got := readFile(t, restarted, "a.txt"); got != "old content" {
+		t.Errorf("a.txt before Recover = %q", got)
+	}
+	if n, err := restarted.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("Recover = %d, %v", n, err)
+	}
+	if got := readFile(t, restarted, "a.txt"); got != "new content" {
+		t.Errorf("a.txt after Recover = %q", got)
+	}
+	if entries, err := afero.ReadDir(manifestFs, "manifests"); err != nil || len(entries) != 1 {
+		t.Errorf("manifest directory after Recover holds %d entries, %v; want the temporary file removed", len(entries), err)
+	}
+	if err := restarted.Remove(ctx, "a.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := shardCount(t, shardsFs); got != 0 {
+		t.Errorf("shards after Remove = %d, want 0", got)
+	}
+}
+
+func TestJournal_Durability(t *testing.T) {
+	manifestFs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	shardsFs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	sboxtest.StorageTestSuite(t, sharded.New(manifestFs, shardsFs, 8,
+		sharded.WithJournal(true), sharded.WithDurability(sbox.DurabilityFsync)))
+}
@@ -0,0 +1,197 @@
+package sharded
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// DefaultManifestCacheEntries is the number of manifests cached when
+// WithManifestCache is given no limit.
+const DefaultManifestCacheEntries = 10000
+
+// manifestCache holds parsed manifests by manifest path, the most recently
+// used ones first. It is kept current by manifestCacheFs, through which
+// the engine writes its manifests.
+type manifestCache struct {
+	max int
+	ttl time.Duration // Zero if entries never expire
+
+	mu      sync.Mutex
+	lru     *list.List // of *cachedManifest, most recently used at the front
+	entries map[string]*list.Element
+	gen     uint64 // Incremented by every invalidation
+}
+
+// cachedManifest is a parsed manifest.
+type cachedManifest struct {
+	path     string
+	manifest sbox.Manifest
+	expires  time.Time // Zero if the entry never expires
+}
+
+func newManifestCache(max int, ttl time.Duration) *manifestCache {
+	return &manifestCache{max: max, ttl: ttl, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// cacheKey normalizes a manifest path.
+func cacheKey(p string) string {
+	return filepath.ToSlash(dirKey(p))
+}
+
+// get returns the fresh manifest cached for p, marking it recently used.
+func (c *manifestCache) get(p string) (sbox.Manifest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[cacheKey(p)]
+	if !ok {
+		return sbox.Manifest{}, false
+	}
+	ent := el.Value.(*cachedManifest)
+	if !ent.expires.IsZero() && time.Now().After(ent.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, ent.path)
+		return sbox.Manifest{}, false
+	}
+	c.lru.MoveToFront(el)
+	return ent.manifest, true
+}
+
+// generation returns a value to pass to put with a manifest read after
+// the call.
+func (c *manifestCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches m for p, unless a manifest was invalidated since gen was
+// returned by generation, and evicts the least recently used entries
+// beyond the limit.
+func (c *manifestCache) put(p string, m sbox.Manifest, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		// m may have been read before a write that the invalidation was
+		// for.
+		return
+	}
+	ent := &cachedManifest{path: cacheKey(p), manifest: m}
+	if c.ttl > 0 {
+		ent.expires = time.Now().Add(c.ttl)
+	}
+	if el, ok := c.entries[ent.path]; ok {
+		c.lru.Remove(el)
+	}
+	c.entries[ent.path] = c.lru.PushFront(ent)
+	for c.lru.Len() > c.max {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cachedManifest).path)
+	}
+}
+
+// invalidate drops p and everything below it.
+func (c *manifestCache) invalidate(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	key := cacheKey(p)
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+	prefix := key + "/"
+	if key == "" {
+		prefix = ""
+	}
+	for k, el := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			c.lru.Remove(el)
+			delete(c.entries, k)
+		}
+	}
+}
+
+// loadManifest reads and parses the manifest at mPath, from the cache if
+// enabled.
+func (e *Engine) loadManifest(mPath string) (sbox.Manifest, error) {
+	var gen uint64
+	if e.manifests != nil {
+		if m, ok := e.manifests.get(mPath); ok {
+			return m, nil
+		}
+		gen = e.manifests.generation()
+	}
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	if err != nil {
+		return sbox.Manifest{}, err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return sbox.Manifest{}, err
+	}
+	if e.manifests != nil {
+		e.manifests.put(mPath, m, gen)
+	}
+	return m, nil
+}
+
+// manifestCacheFs is the manifest store of an engine with a manifest
+// cache: it invalidates the manifests it writes, renames and removes.
+// Files opened for writing invalidate theirs again on Close, so that the
+// content they replace cannot be cached meanwhile.
+type manifestCacheFs struct {
+	afero.Fs
+	cache *manifestCache
+}
+
+func (f *manifestCacheFs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *manifestCacheFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := f.Fs.OpenFile(name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return file, err
+	}
+	f.cache.invalidate(name)
+	return &invalidatingFile{File: file, invalidate: func() { f.cache.invalidate(name) }}, nil
+}
+
+func (f *manifestCacheFs) Remove(name string) error {
+	defer f.cache.invalidate(name)
+	return f.Fs.Remove(name)
+}
+
+func (f *manifestCacheFs) RemoveAll(path string) error {
+	defer f.cache.invalidate(path)
+	return f.Fs.RemoveAll(path)
+}
+
+func (f *manifestCacheFs) Rename(oldname, newname string) error {
+	defer f.cache.invalidate(newname)
+	defer f.cache.invalidate(oldname)
+	return f.Fs.Rename(oldname, newname)
+}
+
+// invalidatingFile is a file opened for writing by manifestCacheFs.
+type invalidatingFile struct {
+	afero.File
+	invalidate func()
+}
+
+func (f *invalidatingFile) Close() error {
+	defer f.invalidate()
+	return f.File.Close()
+}
+
+// Compile-time interface checks.
+var _ afero.Fs = (*manifestCacheFs)(nil)
@@ -0,0 +1,97 @@
+package sharded_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestManifestCache_Suite(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+		sharded.WithManifestCache(0, 0), sharded.WithVersions(2))
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestManifestCache(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, shardsFs, 8, sharded.WithManifestCache(2, 0))
+	// Another process writing to the same stores, which the cache does not
+	// see.
+	other := sharded.New(manifestFs, shardsFs, 8)
+	size := func(p string) int64 {
+		t.Helper()
+		info, err := engine.Stat(ctx, p)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", p, err)
+		}
+		return info.Size
+	}
+
+	writeFile(t, engine, "a.txt", "aaaa")
+	if n := size("a.txt"); n != 4 {
+		t.Fatalf("a.txt size = %d, want 4", n)
+	}
+	writeFile(t, other, "a.txt", "aaaaaaaa")
+	if n := size("a.txt"); n != 4 {
+		t.Errorf("cached a.txt size = %d, want 4", n)
+	}
+	if got := readFile(t, engine, "a.txt"); got != "aaaa" {
+		t.Errorf("cached a.txt = %q", got)
+	}
+
+	// Writes, renames and removes of the engine invalidate.
+	writeFile(t, engine, "a.txt", "aaaaaaaaaaaa")
+	if n := size("a.txt"); n != 12 {
+		t.Errorf("a.txt size after writing = %d, want 12", n)
+	}
+	writeFile(t, engine, "dir/b.txt", "bb")
+	if n := size("dir/b.txt"); n != 2 {
+		t.Fatalf("dir/b.txt size = %d, want 2", n)
+	}
+	if err := engine.Rename(ctx, "dir", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Stat(ctx, "dir/b.txt"); err == nil {
+		t.Error("dir/b.txt still found after renaming dir")
+	}
+	if n := size("moved/b.txt"); n != 2 {
+		t.Errorf("moved/b.txt size = %d, want 2", n)
+	}
+	if err := engine.Remove(ctx, "moved/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Stat(ctx, "moved/b.txt"); err == nil {
+		t.Error("moved/b.txt still found after removing it")
+	}
+
+	// The least recently used manifests are evicted.
+	writeFile(t, engine, "c.txt", "c")
+	writeFile(t, engine, "d.txt", "d")
+	size("c.txt")
+	size("d.txt")
+	writeFile(t, other, "a.txt", "a")
+	if n := size("a.txt"); n != 1 {
+		t.Errorf("evicted a.txt size = %d, want 1", n)
+	}
+}
+
+func TestManifestCache_TTL(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, shardsFs, 8, sharded.WithManifestCache(0, 10*time.Millisecond))
+	writeFile(t, engine, "a.txt", "aaaa")
+	if _, err := engine.Stat(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, sharded.New(manifestFs, shardsFs, 8), "a.txt", "aaaaaaaa")
+	time.Sleep(20 * time.Millisecond)
+	if info, err := engine.Stat(ctx, "a.txt"); err != nil || info.Size != 8 {
+		t.Errorf("Stat after the TTL = %+v, %v; want size 8", info, err)
+	}
+}
@@ -0,0 +1,96 @@
+package sharded
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// createdBy is recorded in every manifest written by this driver.
+const createdBy = "sbox/sharded"
+
+// MigrateManifests rewrites every manifest older than sbox.ManifestVersion
+// or in another encoding than the engine's, see WithManifestEncoding,
+// including those held by snapshots and versions, in the current format.
+// The content of each file older than version 2 is read once to record its
+// hash. It returns the number of manifests rewritten.
+//
+// Manifests written concurrently may be overwritten with their previous
+// content, so migrate while the engine is otherwise idle.
+func (e *Engine) MigrateManifests(ctx context.Context) (int, error) {
+	if e.closed.Load() {
+		return 0, sbox.ErrClosed
+	}
+	var migrated int
+	for _, root := range []string{"manifests", snapshotsDir, versionsDir} {
+		err := afero.Walk(e.manifestFs, root, func(p string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if strings.HasPrefix(info.Name(), snapshotTmpPrefix) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(p, ".json") || info.Name() == snapshotInfoFile {
+				return nil
+			}
+			ok, err := e.migrateManifest(ctx, p)
+			if ok {
+				migrated++
+			}
+			return err
+		})
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// migrateManifest rewrites the manifest at mPath in the current format and
+// the engine's encoding if it is older or in another encoding, and reports
+// whether it did.
+func (e *Engine) migrateManifest(ctx context.Context, mPath string) (bool, error) {
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	if err != nil {
+		return false, err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return false, err
+	}
+	if m.Version >= sbox.ManifestVersion && sbox.ManifestEncodingOf(data) == e.manifestEncoding {
+		return false, nil
+	}
+
+	if m.Version < 2 {
+		r := newShardedReader(ctx, e, m)
+		h := sha256.New()
+		_, err = copyBuffered(h, r)
+		_ = r.Close()
+		if err != nil {
+			return false, err
+		}
+		m.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if data, err = e.marshalManifest(&m); err != nil {
+		return false, err
+	}
+	return true, e.writeManifest(mPath, data)
+}
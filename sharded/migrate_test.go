@@ -0,0 +1,168 @@
+package sharded_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func readManifest(t *testing.T, fs afero.Fs, path string) sbox.Manifest {
+	t.Helper()
+	data, err := afero.ReadFile(fs, path)
+	if err != nil {
+		t.Fatalf("ReadFile %s: %v", path, err)
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		t.Fatalf("UnmarshalManifest %s: %v", path, err)
+	}
+	return m
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestManifest_WritesVersion2(t *testing.T) {
+	manifestFs := afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, afero.NewMemMapFs(), 16)
+	content := "hello manifest version two"
+
+	writeFile(t, engine, "a.txt", content)
+	m := readManifest(t, manifestFs, "manifests/a.txt.json")
+	if m.Version != sbox.ManifestVersion || m.CreatedBy == "" || m.Checksum == "" {
+		t.Errorf("manifest %+v, want version 2 fields", m)
+	}
+	if m.Hash != sha256Hex(content) {
+		t.Errorf("Hash = %q, want %q", m.Hash, sha256Hex(content))
+	}
+	if h, err := engine.Hash(context.Background(), "a.txt", "sha256"); err != nil || h != m.Hash {
+		t.Errorf("Hash() = %q, %v", h, err)
+	}
+
+	// A corrupted manifest is reported instead of returning wrong data.
+	m.Size++
+	data, _ := json.Marshal(m)
+	_ = afero.WriteFile(manifestFs, "manifests/a.txt.json", data, 0644)
+	if _, err := engine.Open(context.Background(), "a.txt"); !errors.Is(err, sbox.ErrCorruptManifest) {
+		t.Errorf("Open corrupt manifest: %v, want %v", err, sbox.ErrCorruptManifest)
+	}
+}
+
+func TestMigrateManifests(t *testing.T) {
+	ctx := context.Background()
+	manifestFs := afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, afero.NewMemMapFs(), 16, sharded.WithVersions(2))
+	content := "content written before version 2 manifests"
+
+	writeFile(t, engine, "dir/a.txt", "first")
+	writeFile(t, engine, "dir/a.txt", content)
+	if err := engine.Snapshot(ctx, "snap"); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// Downgrade every manifest to version 1.
+	var paths []string
+	_ = afero.Walk(manifestFs, "", func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(p, ".json") && info.Name() != "snapshot.json" {
+			paths = append(paths, p)
+		}
+		return err
+	})
+	for _, p := range paths {
+		m := readManifest(t, manifestFs, p)
+		m.Version, m.Hash, m.CreatedBy, m.Checksum = 0, "", "", ""
+		data, _ := json.Marshal(m)
+		_ = afero.WriteFile(manifestFs, p, data, 0644)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("found %d manifests, want live, version and snapshot", len(paths))
+	}
+	if got := readFile(t, engine, "dir/a.txt"); got != content {
+		t.Fatalf("version 1 manifest read %q", got)
+	}
+
+	n, err := engine.MigrateManifests(ctx)
+	if err != nil || n != len(paths) {
+		t.Fatalf("MigrateManifests = %d, %v; want %d", n, err, len(paths))
+	}
+	m := readManifest(t, manifestFs, "manifests/dir/a.txt.json")
+	if m.Version != sbox.ManifestVersion || m.Hash != sha256Hex(content) {
+		t.Errorf("migrated manifest %+v", m)
+	}
+	if n, err := engine.MigrateManifests(ctx); err != nil || n != 0 {
+		t.Errorf("second MigrateManifests = %d, %v; want 0", n, err)
+	}
+	if got := readFile(t, engine, "dir/a.txt"); got != content {
+		t.Errorf("migrated file read %q", got)
+	}
+}
+
+func TestManifestEncoding_Suite(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+		sharded.WithManifestEncoding(sbox.ManifestBinary), sharded.WithVersions(2))
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestManifestEncoding(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	jsonEngine := sharded.New(manifestFs, shardsFs, 8)
+	content := "aaaaaaaabbbbbbbbcccc"
+	writeFile(t, jsonEngine, "a.txt", content)
+	encoding := func(p string) sbox.ManifestEncoding {
+		t.Helper()
+		data, err := afero.ReadFile(manifestFs, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sbox.ManifestEncodingOf(data)
+	}
+	if enc := encoding("manifests/a.txt.json"); enc != sbox.ManifestJSON {
+		t.Fatalf("default encoding %q", enc)
+	}
+
+	// Engines read manifests of either encoding.
+	engine := sharded.New(manifestFs, shardsFs, 8, sharded.WithManifestEncoding(sbox.ManifestBinary))
+	writeFile(t, engine, "b.txt", content)
+	if enc := encoding("manifests/b.txt.json"); enc != sbox.ManifestBinary {
+		t.Errorf("b.txt manifest encoding %q, want binary", enc)
+	}
+	m := readManifest(t, manifestFs, "manifests/b.txt.json")
+	if m.Version != sbox.ManifestVersion || m.Hash != sha256Hex(content) || len(m.Chunks) != 3 {
+		t.Errorf("binary manifest %+v", m)
+	}
+	for _, e := range []*sharded.Engine{jsonEngine, engine} {
+		for _, p := range []string{"a.txt", "b.txt"} {
+			if got := readFile(t, e, p); got != content {
+				t.Errorf("%s = %q", p, got)
+			}
+		}
+	}
+
+	// MigrateManifests rewrites manifests in the configured encoding.
+	if n, err := engine.MigrateManifests(ctx); err != nil || n != 1 {
+		t.Fatalf("MigrateManifests = %d, %v; want 1", n, err)
+	}
+	if enc := encoding("manifests/a.txt.json"); enc != sbox.ManifestBinary {
+		t.Errorf("migrated a.txt manifest encoding %q, want binary", enc)
+	}
+	if n, err := jsonEngine.MigrateManifests(ctx); err != nil || n != 2 {
+		t.Errorf("MigrateManifests back to JSON = %d, %v; want 2", n, err)
+	}
+	if got := readFile(t, engine, "a.txt"); got != content {
+		t.Errorf("a.txt after migrating = %q", got)
+	}
+}
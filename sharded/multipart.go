@@ -0,0 +1,262 @@
+package sharded
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// uploadsDir holds multipart uploads in progress:
+//
+//	uploads/<id>/upload     the logical path and metadata of the upload
+//	uploads/<id>/<n>.json   the manifest of part n
+//
+// Parts are chunked and stored like files as they are uploaded, and their
+// manifests keep their shards alive. CompleteUpload concatenates the
+// chunk lists of the parts into the file's manifest, so no data is copied.
+const uploadsDir = "uploads"
+
+// uploadFile is the name of the record of an upload inside its directory.
+const uploadFile = "upload"
+
+// uploadRecord describes a multipart upload.
+type uploadRecord struct {
+	Path        string            `json:"path"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+}
+
+// === Extension: MultipartUploader ===
+
+// InitiateUpload starts a multipart upload to path. Metadata attached to
+// ctx with sbox.WithMetadata, and the content type set with
+// sbox.WithContentType, are given to the file. Uploads that are
+// neither completed nor aborted keep their parts until AbortUpload.
+func (e *Engine) InitiateUpload(ctx context.Context, path string) (string, error) {
+	if e.closed.Load() {
+		return "", sbox.ErrClosed
+	}
+	if cleanPath(path) == "" {
+		return "", sbox.ErrIsDir
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + hex.EncodeToString(id)
+	dir := filepath.Join(uploadsDir, uploadID)
+	if err := e.manifestFs.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&uploadRecord{
+		Path:        cleanPath(path),
+		Metadata:    sbox.MetadataFromContext(ctx),
+		ContentType: sbox.FileOptionsFromContext(ctx).ContentType,
+	})
+	if err == nil {
+		err = e.writeManifest(filepath.Join(dir, uploadFile), data)
+	}
+	if err != nil {
+		_ = e.manifestFs.RemoveAll(dir)
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// uploadDir returns the directory of the upload uploadID to path, or an
+// error wrapping sbox.ErrNotFound if there is no such upload.
+func (e *Engine) uploadDir(path, uploadID string) (string, *uploadRecord, error) {
+	if e.closed.Load() {
+		return "", nil, sbox.ErrClosed
+	}
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\`) || strings.HasPrefix(uploadID, ".") {
+		return "", nil, fmt.Errorf("sbox/sharded: upload %q: %w", uploadID, sbox.ErrNotFound)
+	}
+	dir := filepath.Join(uploadsDir, uploadID)
+	data, err := afero.ReadFile(e.manifestFs, filepath.Join(dir, uploadFile))
+	if os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("sbox/sharded: upload %q: %w", uploadID, sbox.ErrNotFound)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var rec uploadRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return "", nil, err
+	}
+	if rec.Path != cleanPath(path) {
+		return "", nil, fmt.Errorf("sbox/sharded: upload %q is not for %s: %w", uploadID, path, sbox.ErrNotFound)
+	}
+	return dir, &rec, nil
+}
+
+// partPath returns the path of the manifest of part n in the upload dir.
+func partPath(dir string, n int) string {
+	return filepath.Join(dir, strconv.Itoa(n)+".json")
+}
+
+// UploadPart stores part partNumber of an upload. The part is chunked
+// like a file, so parts can be uploaded in parallel, and its ETag is the
+// SHA-256 of its content.
+func (e *Engine) UploadPart(ctx context.Context, path, uploadID string, partNumber int, r io.Reader) (sbox.PartInfo, error) {
+	dir, _, err := e.uploadDir(path, uploadID)
+	if err != nil {
+		return sbox.PartInfo{}, err
+	}
+	if partNumber < 1 {
+		return sbox.PartInfo{}, fmt.Errorf("sbox/sharded: invalid part number %d: %w", partNumber, sbox.ErrInvalid)
+	}
+	// Without O_CREATE, OpenFile creates no manifest directory.
+	w, err := e.OpenFile(ctx, path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return sbox.PartInfo{}, err
+	}
+	sw := w.(*shardedWriter)
+	part := &partStager{engine: e, path: partPath(dir, partNumber)}
+	sw.stager = part
+	if _, err := io.Copy(sw, r); err != nil {
+		_ = sw.Abort()
+		return sbox.PartInfo{}, err
+	}
+	if err := sw.Close(); err != nil {
+		return sbox.PartInfo{}, err
+	}
+	return sbox.PartInfo{Number: partNumber, Size: part.size, ETag: part.etag}, nil
+}
+
+// partStager stages the manifest of an uploaded part, replacing an earlier
+// upload of the part.
+type partStager struct {
+	engine *Engine
+	path   string
+
+	size int64
+	etag string
+}
+
+func (p *partStager) stage(_ string, data []byte) error {
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return err
+	}
+	p.size, p.etag = m.Size, m.Hash
+	replaced, err := p.engine.refChunks(p.engine.manifestChunks, p.path)
+	if err != nil {
+		return err
+	}
+	if err := p.engine.writeManifest(p.path, data); err != nil {
+		return err
+	}
+	return p.engine.adjustRefs(nil, replaced)
+}
+
+// CompleteUpload publishes the parts as the content of path. The chunks
+// of the parts become the chunks of the file; the previous content of
+// path is kept as a version if versioning is enabled.
+func (e *Engine) CompleteUpload(ctx context.Context, path, uploadID string, parts []sbox.PartInfo) error {
+	dir, rec, err := e.uploadDir(path, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("sbox/sharded: complete upload %s: no parts: %w", uploadID, sbox.ErrInvalid)
+	}
+
+	manifest := sbox.Manifest{
+		Chunks:     []string{},
+		ChunkSizes: []int64{},
+		ModTime:    time.Now(),
+		CreatedBy:  createdBy,
+		Metadata:   rec.Metadata,
+
+		ContentType: rec.ContentType,
+	}
+	var compressed []bool
+	var storedSizes []int64
+	var keys []string
+	for i, p := range parts {
+		if i > 0 && p.Number <= parts[i-1].Number {
+			return fmt.Errorf("sbox/sharded: complete upload %s: parts not in ascending order: %w", uploadID, sbox.ErrInvalid)
+		}
+		data, err := afero.ReadFile(e.manifestFs, partPath(dir, p.Number))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("sbox/sharded: complete upload %s: part %d not uploaded: %w", uploadID, p.Number, sbox.ErrInvalid)
+		}
+		if err != nil {
+			return err
+		}
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return err
+		}
+		if p.ETag != "" && p.ETag != m.Hash {
+			return fmt.Errorf("sbox/sharded: complete upload %s: part %d: %w", uploadID, p.Number, sbox.ErrPreconditionFailed)
+		}
+		sizes := e.chunkSizes(&m)
+		manifest.Chunks = append(manifest.Chunks, m.Chunks...)
+		manifest.ChunkSizes = append(manifest.ChunkSizes, sizes...)
+		manifest.Size += m.Size
+		if len(m.Compressed) > 0 {
+			compressed = append(compressed, m.Compressed...)
+			storedSizes = append(storedSizes, m.StoredSizes...)
+		} else {
+			compressed = append(compressed, make([]bool, len(m.Chunks))...)
+			storedSizes = append(storedSizes, sizes...)
+		}
+		if len(m.Keys) > 0 {
+			keys = append(keys, m.Keys...)
+		} else {
+			keys = append(keys, make([]string, len(m.Chunks))...)
+		}
+		if len(parts) == 1 {
+			manifest.Hash = m.Hash
+		}
+	}
+	if slices.Contains(compressed, true) {
+		manifest.Compressed = compressed
+		manifest.StoredSizes = storedSizes
+	}
+	if slices.ContainsFunc(keys, func(k string) bool { return k != "" }) {
+		manifest.Keys = keys
+	}
+	data, err := e.marshalManifest(&manifest)
+	if err != nil {
+		return err
+	}
+
+	mPath := e.manifestPath(rec.Path)
+	if err := e.manifestFs.MkdirAll(filepath.Dir(mPath), 0755); err != nil {
+		return err
+	}
+	// The file takes its own references on the chunks before the parts
+	// release theirs, so shared chunks are never dropped in between.
+	err = e.replaceManifest(mPath, manifest.Chunks, func() error {
+		return e.writeManifest(mPath, data)
+	})
+	if err != nil {
+		return err
+	}
+	return e.discardStaged(dir, e.treeChunks)
+}
+
+// AbortUpload discards an upload and its parts.
+func (e *Engine) AbortUpload(ctx context.Context, path, uploadID string) error {
+	dir, _, err := e.uploadDir(path, uploadID)
+	if err != nil {
+		return err
+	}
+	return e.discardStaged(dir, e.treeChunks)
+}
@@ -0,0 +1,125 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestMultipart_Complete(t *testing.T) {
+	ctx := context.Background()
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 4, sharded.WithRefcount(true))
+	writeFile(t, engine, "big.bin", "old")
+
+	id, err := engine.InitiateUpload(ctx, "big.bin")
+	if err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	contents := []string{"first part ", "second ", "third"}
+	parts := make([]sbox.PartInfo, len(contents))
+	var wg sync.WaitGroup
+	for i, c := range contents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := engine.UploadPart(ctx, "big.bin", id, i+1, strings.NewReader(c))
+			if err != nil {
+				t.Errorf("UploadPart %d: %v", i+1, err)
+			}
+			parts[i] = p
+		}()
+	}
+	wg.Wait()
+	// Uploading a part again replaces it.
+	if _, err := engine.UploadPart(ctx, "big.bin", id, 3, strings.NewReader("abandoned")); err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
+	if parts[1].Size != 7 || parts[1].ETag == "" {
+		t.Errorf("part 2 = %+v", parts[1])
+	}
+	if got := readFile(t, engine, "big.bin"); got != "old" {
+		t.Errorf("content before CompleteUpload = %q", got)
+	}
+	// The stale ETag of part 3 no longer matches.
+	if err := engine.CompleteUpload(ctx, "big.bin", id, parts); !errors.Is(err, sbox.ErrPreconditionFailed) {
+		t.Fatalf("CompleteUpload with a stale ETag = %v, want %v", err, sbox.ErrPreconditionFailed)
+	}
+	parts[2] = sbox.PartInfo{Number: 3}
+	if err := engine.CompleteUpload(ctx, "big.bin", id, parts); err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if got := readFile(t, engine, "big.bin"); got != "first part second abandoned" {
+		t.Errorf("content = %q", got)
+	}
+	if err := engine.AbortUpload(ctx, "big.bin", id); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("AbortUpload after CompleteUpload = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	// Only the chunks of the file are left.
+	if err := engine.Remove(ctx, "big.bin"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 0 {
+		t.Errorf("%d shards left after Remove", n)
+	}
+}
+
+func TestMultipart_AbortAndGC(t *testing.T) {
+	ctx := context.Background()
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 4)
+
+	id, err := engine.InitiateUpload(ctx, "f")
+	if err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	if _, err := engine.UploadPart(ctx, "f", id, 1, strings.NewReader("pending data")); err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
+	if _, err := engine.UploadPart(ctx, "other", id, 2, strings.NewReader("x")); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("UploadPart to another path = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := engine.UploadPart(ctx, "f", "../txs", 1, strings.NewReader("x")); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("UploadPart with an invalid id = %v, want %v", err, sbox.ErrNotFound)
+	}
+
+	// Parts in progress survive GC.
+	if _, err := engine.GC(ctx); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if err := engine.CompleteUpload(ctx, "f", id, []sbox.PartInfo{{Number: 1}}); err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if got := readFile(t, engine, "f"); got != "pending data" {
+		t.Errorf("content = %q", got)
+	}
+
+	id, err = engine.InitiateUpload(ctx, "g")
+	if err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	if _, err := engine.UploadPart(ctx, "g", id, 1, strings.NewReader("discarded")); err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
+	if err := engine.AbortUpload(ctx, "g", id); err != nil {
+		t.Fatalf("AbortUpload: %v", err)
+	}
+	if err := engine.CompleteUpload(ctx, "g", id, []sbox.PartInfo{{Number: 1}}); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("CompleteUpload after AbortUpload = %v, want %v", err, sbox.ErrNotFound)
+	}
+	stats, err := engine.GC(ctx)
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Deleted != 3 {
+		t.Errorf("GC deleted %d shards of the aborted upload, want 3", stats.Deleted)
+	}
+}
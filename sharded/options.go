@@ -0,0 +1,275 @@
+package sharded
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithVerifyOnRead makes readers check every shard against its content hash
+// before returning data. Corrupt shards are reported as ErrCorruptShard
+// unless read repair is enabled.
+func WithVerifyOnRead(enabled bool) Option {
+	return func(e *Engine) {
+		e.verifyOnRead = enabled
+	}
+}
+
+// WithReadRepair enables verify-on-read and heals corrupt shards by copying
+// a good replica of the same hash from one of sources over the bad blob.
+// Sources are tried in order; each is laid out like the primary shard store.
+func WithReadRepair(sources ...afero.Fs) Option {
+	return func(e *Engine) {
+		e.verifyOnRead = true
+		e.repairSources = append(e.repairSources, sources...)
+	}
+}
+
+// WithMinFreeSpace makes writes keep at least reserve bytes free on the
+// filesystem holding dir, the OS directory of the shard store. Opening a
+// file for writing fails when the reserve is reached, and storing a shard
+// or manifest that would cross it fails before writing it, with a
+// *sbox.NoSpaceError. A full filesystem is reported the same way.
+func WithMinFreeSpace(dir string, reserve int64) Option {
+	return func(e *Engine) {
+		e.space = sbox.NewSpaceGuard(dir, reserve)
+	}
+}
+
+// WithPollInterval sets how often Watch lists the manifests for changes.
+// The default is sbox.DefaultPollInterval.
+func WithPollInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.poll = d
+	}
+}
+
+// WithReadAhead makes readers fetch the next n chunks concurrently while
+// the current one is consumed, which speeds up large sequential reads on
+// high-latency shard stores. Each reader buffers up to n+1 chunks. Zero,
+// the default, reads one chunk at a time.
+func WithReadAhead(n int) Option {
+	return func(e *Engine) {
+		e.readAhead = max(n, 0)
+	}
+}
+
+// WithWriteConcurrency lets writers hash, compress and store up to n
+// chunks in the background while more data arrives, which matters when the
+// shard store is slow. Each writer buffers up to n extra chunks. With
+// reference counting, shard writes are serialized, so only hashing and
+// compression overlap. Values below 2, the default, store chunks inline.
+func WithWriteConcurrency(n int) Option {
+	return func(e *Engine) {
+		e.writeConcurrency = n
+	}
+}
+
+// WithRefcount maintains a reference count index in the shard store so that
+// shards are deleted as soon as no manifest references them, instead of
+// waiting for a full-scan GC. The index assumes a single Engine owns the
+// shard store; it is built from existing manifests on first use. A writer
+// dropped without Close or Abort keeps its references until GC removes its
+// shards.
+func WithRefcount(enabled bool) Option {
+	return func(e *Engine) {
+		e.refcount = enabled
+	}
+}
+
+// WithJournal records every write in a journal before its shards and
+// manifest are stored, so that Engine.Recover can publish or discard
+// writes interrupted by a crash instead of leaving orphaned shards behind.
+// It costs one journal line per stored shard.
+func WithJournal(enabled bool) Option {
+	return func(e *Engine) {
+		e.journal = enabled
+	}
+}
+
+// WithDurability sets what Close guarantees after a power loss. With
+// sbox.DurabilityFlush, shards, manifests and journal records are synced
+// before a manifest is published; with sbox.DurabilityFsync, the
+// directories they are created in are synced as well. The default is
+// sbox.DurabilityNone.
+func WithDurability(d sbox.Durability) Option {
+	return func(e *Engine) {
+		e.durability = d
+	}
+}
+
+// WithChunking selects how files are split into shards. The default is
+// ChunkingFixed.
+func WithChunking(c Chunking) Option {
+	return func(e *Engine) {
+		e.chunking = c
+	}
+}
+
+// WithCompression compresses shards before they are stored. Shards that
+// do not shrink are stored as is. The default is CompressionNone.
+func WithCompression(c Compression) Option {
+	return func(e *Engine) {
+		e.compression = c
+	}
+}
+
+// WithSparseFiles stores chunks made only of zero bytes as holes:
+// manifest entries without a shard, which read back as zeros. Disk images,
+// database files and other sparse content then take space only for their
+// data, and growing a file with Truncate writes nothing. Holes are visible
+// in the manifests, so with encryption they reveal where the zero chunks
+// are.
+func WithSparseFiles(enabled bool) Option {
+	return func(e *Engine) {
+		e.sparse = enabled
+	}
+}
+
+// WithPacking appends shards of at most threshold bytes to pack files of
+// about packSize bytes instead of storing each in a file of its own, which
+// saves the inode and block overhead of millions of small files. Zero
+// selects DefaultPackThreshold and DefaultPackSize. Removed shards keep
+// their space in their pack until Engine.Repack. The index of the packs
+// is held in memory and assumes a single Engine owns the shard store.
+func WithPacking(threshold, packSize int64) Option {
+	return func(e *Engine) {
+		if threshold <= 0 {
+			threshold = DefaultPackThreshold
+		}
+		if packSize <= 0 {
+			packSize = DefaultPackSize
+		}
+		e.packThreshold, e.packSize = threshold, packSize
+	}
+}
+
+// WithManifestEncoding writes manifests with enc. sbox.ManifestBinary
+// manifests are several times smaller, and faster to read, than JSON ones
+// for files of many chunks, but versions of sbox before it cannot read
+// them. Manifests are read in either encoding, so the option can change at
+// any time; Engine.MigrateManifests rewrites existing ones in enc.
+func WithManifestEncoding(enc sbox.ManifestEncoding) Option {
+	return func(e *Engine) {
+		if enc == "" {
+			enc = sbox.ManifestJSON
+		}
+		e.manifestEncoding = enc
+	}
+}
+
+// WithManifestCache keeps up to entries parsed manifests in memory, the
+// least recently used evicted first, so that Stat, Open, ReadDir and List
+// do not read and decode them again. Zero entries selects
+// DefaultManifestCacheEntries. The engine invalidates the manifests it
+// writes, renames and removes; a ttl above zero bounds how long it sees
+// stale manifests when other processes write to the same manifest store.
+func WithManifestCache(entries int, ttl time.Duration) Option {
+	return func(e *Engine) {
+		if entries <= 0 {
+			entries = DefaultManifestCacheEntries
+		}
+		e.manifests = newManifestCache(entries, ttl)
+	}
+}
+
+// WithErasureCoding splits every shard into data pieces and parity
+// pieces of a Reed-Solomon code, stored in the shard stores, which are the
+// store given to New followed by stores, so that any data pieces of a
+// shard rebuild it. With at least data+parity stores, every piece of a
+// shard is in a store of its own, and parity stores can be lost for the
+// space of parity/data times the shards, instead of a full copy of them.
+// The stores of a shard are chosen from its hash. Reads rebuild the pieces
+// they find missing or corrupt in the background; Engine.Reconstruct
+// rebuilds those of all shards. Files other than shards, such as indexes,
+// are kept in the first store. Zero data selects the number of stores
+// less the parity ones, and zero parity selects one; data and parity are
+// at most 256 pieces in all. Erasure coding replaces WithReplicas.
+func WithErasureCoding(data, parity int, stores ...afero.Fs) Option {
+	return func(e *Engine) {
+		if parity <= 0 {
+			parity = 1
+		}
+		e.erasureData, e.erasureParity = data, min(parity, 255)
+		e.erasureStores = append(e.erasureStores, stores...)
+	}
+}
+
+// WithReplicas writes every shard to n of the shard stores, which are
+// the store given to New followed by stores, and makes reads fail over to
+// another copy when a store fails or lacks a shard. The stores of a shard
+// are chosen from its hash, so that shards spread evenly, and a write
+// fails unless n of them store it. The stores are also read repair
+// sources, so with WithVerifyOnRead a corrupt copy is replaced. Files
+// other than shards, such as indexes, are kept in the first store. Zero,
+// or more than the number of stores, replicates to all of them.
+func WithReplicas(n int, stores ...afero.Fs) Option {
+	return func(e *Engine) {
+		e.replicas = n
+		e.replicaStores = append(e.replicaStores, stores...)
+	}
+}
+
+// WithColdShards adds a cold shard store, typically cheaper and slower
+// than the one given to New, which becomes the hot store. New shards are
+// written to the hot store; Engine.Demote moves those not read for
+// policy.DemoteAfter to the cold store, and reads find shards in either.
+// The time of the last read of every hot shard is kept in a small index
+// in the hot store.
+func WithColdShards(cold afero.Fs, policy TierPolicy) Option {
+	return func(e *Engine) {
+		if policy.DemoteAfter <= 0 {
+			policy.DemoteAfter = DefaultDemoteAfter
+		}
+		e.tier = &tiering{cold: cold, policy: policy}
+	}
+}
+
+// WithEncryption encrypts every chunk with AES-256-GCM under a per-chunk
+// key, which is stored in the manifest wrapped with masterKey. mode chooses
+// between random keys and convergent keys that preserve deduplication.
+// Shards written without encryption remain readable. It panics if mode is
+// unknown or masterKey is not MasterKeySize bytes long.
+func WithEncryption(mode EncryptionMode, masterKey []byte) Option {
+	x, err := newEncryptor(mode, masterKey)
+	if err != nil {
+		panic(err)
+	}
+	return func(e *Engine) {
+		e.encryptor = x
+	}
+}
+
+// WithVersions keeps up to n previous manifests for every path, exposed
+// through sbox.Versioner. Overwritten and removed files can then be rolled
+// back or undeleted. Versions share shards with the live files, so only
+// changed chunks take extra space. Zero disables versioning.
+func WithVersions(n int) Option {
+	return func(e *Engine) {
+		e.versions = n
+	}
+}
+
+// WithLogger sets the logger used to report repairs and other background
+// events. The default is slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(e *Engine) {
+		if logger != nil {
+			e.logger = logger
+		}
+	}
+}
+
+// closing makes Close close engines after the engine's own state is
+// flushed.
+func closing(engines ...sbox.StorageEngine) Option {
+	return func(e *Engine) {
+		e.owned = append(e.owned, engines...)
+	}
+}
@@ -0,0 +1,815 @@
+package sharded
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// packsDir holds the pack files of the shard store. "<id>.pack" holds the
+// shards appended to it, and "<id>.idx" one "<hash> <offset> <length>
+// <unix seconds>" line per shard, followed by a "<hash> -" line once the
+// shard is removed.
+const packsDir = "packs"
+
+// Defaults of WithPacking.
+const (
+	DefaultPackThreshold = 64 << 10
+	DefaultPackSize      = 16 << 20
+)
+
+// RepackOptions controls a Repack run.
+type RepackOptions struct {
+	// MinLive is the fraction of a pack that must hold live shards for
+	// the pack to be kept as is; the live shards of other packs are copied
+	// to a new pack and the old one is deleted. 0.5 if zero.
+	MinLive float64
+}
+
+// RepackStats reports the outcome of a Repack run.
+type RepackStats struct {
+	Packs      int   // Packs examined
+	Repacked   int   // Packs deleted after copying their live shards
+	Shards     int   // Live shards copied
+	BytesFreed int64 // Size of the packs deleted, less the shards copied
+}
+
+// packEntry locates a shard in a pack.
+type packEntry struct {
+	pack    string
+	offset  int64
+	length  int64
+	modTime int64 // Unix seconds
+}
+
+// packInfo describes a pack file.
+type packInfo struct {
+	size int64 // Bytes in the pack file
+	live int64 // Bytes of the shards the index locates in it
+}
+
+// packStore is the shard store of an engine with packing: shards up to
+// threshold bytes are appended to pack files of about packSize bytes
+// instead of being stored as files of their own. Packed shards are still
+// read, listed and removed at their usual path, so the rest of the engine
+// does not tell them apart. The hash directories of packed shards are not
+// created. The index of all packs is kept in memory; removed shards keep
+// their space until Repack.
+type packStore struct {
+	fs        afero.Fs
+	engine    *Engine
+	threshold int64
+	packSize  int64
+
+	loadOnce sync.Once
+	loadErr  error
+
+	mu    sync.Mutex
+	index map[string]packEntry
+	packs map[string]*packInfo
+	dirs  map[string]map[string]bool // Directories of packed shards and their entries
+	cur   string                     // Pack being appended to; "" if none
+	data  afero.File                 // Handles of the pack being appended to
+	idx   afero.File
+}
+
+// load reads the indexes of the packs on first use. Lines torn by a crash,
+// or locating data that never reached the pack, are ignored.
+func (s *packStore) load() error {
+	s.loadOnce.Do(func() {
+		s.index = make(map[string]packEntry)
+		s.packs = make(map[string]*packInfo)
+		s.dirs = make(map[string]map[string]bool)
+		entries, err := afero.ReadDir(s.fs, packsDir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				s.loadErr = err
+			}
+			return
+		}
+		for _, info := range entries {
+			if id, ok := strings.CutSuffix(info.Name(), ".pack"); ok {
+				s.packs[id] = &packInfo{size: info.Size()}
+			}
+		}
+		for _, id := range slices.Sorted(maps.Keys(s.packs)) {
+			if err := s.loadIndex(id); err != nil {
+				s.loadErr = err
+				return
+			}
+		}
+	})
+	return s.loadErr
+}
+
+// loadIndex adds the shards of pack id to the index.
+func (s *packStore) loadIndex(id string) error {
+	f, err := s.fs.Open(filepath.Join(packsDir, id+".idx"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info := s.packs[id]
+	shards := make(map[string]packEntry)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !isShardName(fields[0]) {
+			continue
+		}
+		if len(fields) == 2 && fields[1] == "-" {
+			delete(shards, fields[0])
+			continue
+		}
+		if len(fields) != 4 {
+			continue
+		}
+		var n [3]int64
+		var err error
+		for i := range n {
+			if n[i], err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+				break
+			}
+		}
+		if err != nil || n[0] < 0 || n[1] < 0 || n[0]+n[1] > info.size {
+			continue
+		}
+		shards[fields[0]] = packEntry{pack: id, offset: n[0], length: n[1], modTime: n[2]}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for hash, entry := range shards {
+		s.locate(hash, entry)
+	}
+	return nil
+}
+
+// locate records that hash is stored at entry, replacing another copy.
+func (s *packStore) locate(hash string, entry packEntry) {
+	if old, ok := s.index[hash]; ok {
+		s.packs[old.pack].live -= old.length
+	}
+	s.index[hash] = entry
+	s.packs[entry.pack].live += entry.length
+	child := hash
+	for dir := filepath.Dir(sbox.HashPath(hash)); ; dir = filepath.Dir(dir) {
+		key := dirKey(dir)
+		if s.dirs[key] == nil {
+			s.dirs[key] = make(map[string]bool)
+		}
+		s.dirs[key][child] = true
+		if key == "" {
+			break
+		}
+		child = filepath.Base(dir)
+	}
+}
+
+// dirKey normalizes a directory name, with "" for the root.
+func dirKey(name string) string {
+	if name = filepath.Clean(name); name == "." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+// shardOf returns the hash of the shard stored at name, or "".
+func shardOf(name string) string {
+	hash := filepath.Base(name)
+	if !isShardName(hash) || filepath.Clean(name) != sbox.HashPath(hash) {
+		return ""
+	}
+	return hash
+}
+
+// isHashDir reports whether name is a hash directory, e.g. "ab/cd".
+func isHashDir(name string) bool {
+	parts := strings.Split(filepath.ToSlash(dirKey(name)), "/")
+	if len(parts) > 3 {
+		return false
+	}
+	for _, p := range parts {
+		if _, err := hex.DecodeString(p); len(p) != 2 || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the location of a packed shard.
+func (s *packStore) lookup(hash string) (packEntry, bool, error) {
+	if err := s.load(); err != nil {
+		return packEntry{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.index[hash]
+	return entry, ok, nil
+}
+
+// put stores a shard: in the current pack if it is small enough, or as a
+// file otherwise.
+func (s *packStore) put(name, hash string, data []byte) error {
+	if int64(len(data)) > s.threshold {
+		return s.writeLoose(name, data)
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendLocked(hash, data, time.Now().Unix())
+}
+
+// writeLoose stores a shard as a file of its own.
+func (s *packStore) writeLoose(name string, data []byte) error {
+	if err := s.fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := s.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && s.engine.syncs() {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// appendLocked appends a shard to the current pack, starting one if
+// needed, and records it in the pack's index. The data is written, and
+// synced with durability enabled, before the index line.
+func (s *packStore) appendLocked(hash string, data []byte, modTime int64) error {
+	if s.cur == "" || s.packs[s.cur].size >= s.packSize {
+		if err := s.startPack(); err != nil {
+			return err
+		}
+	}
+	info := s.packs[s.cur]
+	if _, err := s.data.Write(data); err != nil {
+		return err
+	}
+	offset := info.size
+	info.size += int64(len(data))
+	if s.engine.syncs() {
+		if err := s.data.Sync(); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.idx, "%s %d %d %d\n", hash, offset, len(data), modTime); err != nil {
+		return err
+	}
+	if s.engine.syncs() {
+		if err := s.idx.Sync(); err != nil {
+			return err
+		}
+	}
+	s.locate(hash, packEntry{pack: s.cur, offset: offset, length: int64(len(data)), modTime: modTime})
+	return nil
+}
+
+// startPack closes the current pack and starts a new one.
+func (s *packStore) startPack() error {
+	if err := s.closePack(); err != nil {
+		return err
+	}
+	rnd := make([]byte, 4)
+	if _, err := rand.Read(rnd); err != nil {
+		return err
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + hex.EncodeToString(rnd)
+	if err := s.fs.MkdirAll(packsDir, 0755); err != nil {
+		return err
+	}
+	data, err := s.fs.OpenFile(filepath.Join(packsDir, id+".pack"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	idx, err := s.fs.OpenFile(filepath.Join(packsDir, id+".idx"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		_ = data.Close()
+		return err
+	}
+	if err := s.engine.syncDirs(s.fs, packsDir); err != nil {
+		_ = data.Close()
+		_ = idx.Close()
+		return err
+	}
+	s.cur, s.data, s.idx = id, data, idx
+	s.packs[id] = &packInfo{}
+	return nil
+}
+
+// closePack closes the handles of the current pack, if any. The next
+// shard starts a new pack.
+func (s *packStore) closePack() error {
+	if s.cur == "" {
+		return nil
+	}
+	err := s.data.Close()
+	if ierr := s.idx.Close(); err == nil {
+		err = ierr
+	}
+	s.cur, s.data, s.idx = "", nil, nil
+	return err
+}
+
+// close closes the current pack when the engine is closed.
+func (s *packStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closePack()
+}
+
+// forgetLocked removes a packed shard from the index, recording it in the
+// index of its pack.
+func (s *packStore) forgetLocked(hash string, entry packEntry) error {
+	line := hash + " -\n"
+	var err error
+	if entry.pack == s.cur {
+		_, err = s.idx.WriteString(line)
+	} else {
+		var f afero.File
+		if f, err = s.fs.OpenFile(filepath.Join(packsDir, entry.pack+".idx"), os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			_, err = f.WriteString(line)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	delete(s.index, hash)
+	s.packs[entry.pack].live -= entry.length
+	dir := dirKey(filepath.Dir(sbox.HashPath(hash)))
+	delete(s.dirs[dir], hash)
+	return nil
+}
+
+// repack copies the live shards of the packs that are less than minLive
+// full to the current pack and deletes them.
+func (s *packStore) repack(ctx context.Context, minLive float64, stats *RepackStats) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	ids := slices.Sorted(maps.Keys(s.packs))
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.repackOne(id, minLive, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *packStore) repackOne(id string, minLive float64, stats *RepackStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.packs[id]
+	if !ok || id == s.cur {
+		return nil
+	}
+	stats.Packs++
+	if info.size > 0 && float64(info.live) >= minLive*float64(info.size) {
+		return nil
+	}
+
+	freed := info.size - info.live
+	var hashes []string
+	for hash, entry := range s.index {
+		if entry.pack == id {
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) > 0 {
+		f, err := s.fs.Open(filepath.Join(packsDir, id+".pack"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		slices.Sort(hashes)
+		for _, hash := range hashes {
+			entry := s.index[hash]
+			data := make([]byte, entry.length)
+			if _, err := f.ReadAt(data, entry.offset); err != nil {
+				return err
+			}
+			if err := s.appendLocked(hash, data, entry.modTime); err != nil {
+				return err
+			}
+			stats.Shards++
+		}
+	}
+
+	for _, ext := range []string{".idx", ".pack"} {
+		if err := s.fs.Remove(filepath.Join(packsDir, id+ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(s.packs, id)
+	stats.Repacked++
+	stats.BytesFreed += freed
+	return nil
+}
+
+// Repack reclaims the space of removed shards: the live shards of every
+// pack that holds less than opts.MinLive live data are copied to a new
+// pack, and the old pack is deleted. GC and reference counting only mark
+// packed shards as removed, so Repack is meant to run after them. It
+// returns sbox.ErrNotSupported unless the engine was created with
+// WithPacking.
+func (e *Engine) Repack(ctx context.Context, opts RepackOptions) (*RepackStats, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	if len(e.packs) == 0 {
+		return nil, fmt.Errorf("sbox/sharded: packing disabled: %w", sbox.ErrNotSupported)
+	}
+	if opts.MinLive <= 0 {
+		opts.MinLive = 0.5
+	}
+	stats := &RepackStats{}
+	for _, p := range e.packs {
+		if err := p.repack(ctx, opts.MinLive, stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// === afero.Fs ===
+
+func (s *packStore) Name() string { return "sharded-packs" }
+
+func (s *packStore) Create(name string) (afero.File, error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// Mkdir and MkdirAll do not create hash directories, which are created
+// for the shards stored as files only.
+func (s *packStore) Mkdir(name string, perm os.FileMode) error {
+	if isHashDir(name) {
+		return nil
+	}
+	return s.fs.Mkdir(name, perm)
+}
+
+func (s *packStore) MkdirAll(path string, perm os.FileMode) error {
+	if isHashDir(path) {
+		return nil
+	}
+	return s.fs.MkdirAll(path, perm)
+}
+
+func (s *packStore) Open(name string) (afero.File, error) {
+	if hash := shardOf(name); hash != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		entry, ok := s.index[hash]
+		var f afero.File
+		var err error
+		if ok {
+			f, err = s.fs.Open(filepath.Join(packsDir, entry.pack+".pack"))
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &packedFile{
+				File: f,
+				r:    io.NewSectionReader(f, entry.offset, entry.length),
+				info: packedInfo{name: hash, entry: entry},
+				name: name,
+			}, nil
+		}
+	}
+
+	f, err := s.fs.Open(name)
+	if os.IsNotExist(err) {
+		if _, ok := s.virtualDir(name); ok {
+			return &unionDir{File: &emptyDir{name: name}, more: s.listing(name)}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return &unionDir{File: f, more: s.listing(name)}, nil
+	}
+	return f, nil
+}
+
+// virtualDir reports whether packed shards are stored below dir.
+func (s *packStore) virtualDir(dir string) (map[string]bool, bool) {
+	if s.load() != nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, ok := s.dirs[dirKey(dir)]
+	return entries, ok
+}
+
+// listing returns the entries of dir for the packed shards below it.
+func (s *packStore) listing(dir string) func() ([]os.FileInfo, error) {
+	return func() ([]os.FileInfo, error) {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var infos []os.FileInfo
+		for name := range s.dirs[dirKey(dir)] {
+			if entry, ok := s.index[name]; ok {
+				infos = append(infos, packedInfo{name: name, entry: entry})
+			} else if _, ok := s.dirs[dirKey(filepath.Join(dir, name))]; ok {
+				infos = append(infos, dirInfo(name))
+			}
+		}
+		return infos, nil
+	}
+}
+
+func (s *packStore) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return s.Open(name)
+	}
+	if hash := shardOf(name); hash != "" && flag&os.O_APPEND == 0 {
+		return &packWriter{store: s, name: name, hash: hash}, nil
+	}
+	if dir := filepath.Dir(name); isHashDir(dir) {
+		if err := s.fs.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return s.fs.OpenFile(name, flag, perm)
+}
+
+// Remove removes a shard from its pack, and the file of the same name if
+// any.
+func (s *packStore) Remove(name string) error {
+	hash := shardOf(name)
+	if hash == "" {
+		return s.fs.Remove(name)
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	entry, packed := s.index[hash]
+	var err error
+	if packed {
+		err = s.forgetLocked(hash, entry)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := s.fs.Remove(name); err != nil && !(packed && os.IsNotExist(err)) {
+		return err
+	}
+	return nil
+}
+
+func (s *packStore) RemoveAll(path string) error {
+	return s.fs.RemoveAll(path)
+}
+
+func (s *packStore) Rename(oldname, newname string) error {
+	if dir := filepath.Dir(newname); isHashDir(dir) {
+		if err := s.fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return s.fs.Rename(oldname, newname)
+}
+
+func (s *packStore) Stat(name string) (os.FileInfo, error) {
+	if hash := shardOf(name); hash != "" {
+		entry, ok, err := s.lookup(hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return packedInfo{name: hash, entry: entry}, nil
+		}
+	}
+	info, err := s.fs.Stat(name)
+	if os.IsNotExist(err) {
+		if _, ok := s.virtualDir(name); ok {
+			return dirInfo(filepath.Base(name)), nil
+		}
+	}
+	return info, err
+}
+
+func (s *packStore) Chmod(name string, mode os.FileMode) error {
+	if _, ok, _ := s.lookup(shardOf(name)); ok {
+		return nil
+	}
+	return s.fs.Chmod(name, mode)
+}
+
+func (s *packStore) Chown(name string, uid, gid int) error {
+	if _, ok, _ := s.lookup(shardOf(name)); ok {
+		return nil
+	}
+	return s.fs.Chown(name, uid, gid)
+}
+
+func (s *packStore) Chtimes(name string, atime, mtime time.Time) error {
+	hash := shardOf(name)
+	if _, ok, _ := s.lookup(hash); !ok {
+		return s.fs.Chtimes(name, atime, mtime)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.index[hash]
+	if !ok {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	}
+	line := fmt.Sprintf("%s %d %d %d\n", hash, entry.offset, entry.length, mtime.Unix())
+	var err error
+	if entry.pack == s.cur {
+		_, err = s.idx.WriteString(line)
+	} else {
+		var f afero.File
+		if f, err = s.fs.OpenFile(filepath.Join(packsDir, entry.pack+".idx"), os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			_, err = f.WriteString(line)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err == nil {
+		entry.modTime = mtime.Unix()
+		s.index[hash] = entry
+	}
+	return err
+}
+
+// packedInfo describes a packed shard.
+type packedInfo struct {
+	name  string
+	entry packEntry
+}
+
+func (i packedInfo) Name() string       { return i.name }
+func (i packedInfo) Size() int64        { return i.entry.length }
+func (i packedInfo) Mode() os.FileMode  { return 0644 }
+func (i packedInfo) ModTime() time.Time { return time.Unix(i.entry.modTime, 0) }
+func (i packedInfo) IsDir() bool        { return false }
+func (i packedInfo) Sys() any           { return nil }
+
+// dirInfo describes a directory holding only packed shards.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
+
+// packedFile reads a packed shard from its pack.
+type packedFile struct {
+	afero.File // The pack
+	r          *io.SectionReader
+	info       packedInfo
+	name       string
+}
+
+func (f *packedFile) Name() string                                 { return f.name }
+func (f *packedFile) Stat() (os.FileInfo, error)                   { return f.info, nil }
+func (f *packedFile) Read(p []byte) (int, error)                   { return f.r.Read(p) }
+func (f *packedFile) ReadAt(p []byte, off int64) (int, error)      { return f.r.ReadAt(p, off) }
+func (f *packedFile) Seek(offset int64, whence int) (int64, error) { return f.r.Seek(offset, whence) }
+
+func (f *packedFile) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *packedFile) WriteAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *packedFile) WriteString(string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *packedFile) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *packedFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+func (f *packedFile) Readdirnames(int) ([]string, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+// packWriter buffers a shard and stores it on Close.
+type packWriter struct {
+	store  *packStore
+	name   string
+	hash   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *packWriter) Name() string { return w.name }
+
+func (w *packWriter) Stat() (os.FileInfo, error) {
+	return packedInfo{name: w.hash, entry: packEntry{length: int64(w.buf.Len()), modTime: time.Now().Unix()}}, nil
+}
+
+func (w *packWriter) Write(p []byte) (int, error)        { return w.buf.Write(p) }
+func (w *packWriter) WriteString(s string) (int, error)  { return w.buf.WriteString(s) }
+func (w *packWriter) Read([]byte) (int, error)           { return 0, os.ErrPermission }
+func (w *packWriter) ReadAt([]byte, int64) (int, error)  { return 0, os.ErrPermission }
+func (w *packWriter) WriteAt([]byte, int64) (int, error) { return 0, sbox.ErrNotSupported }
+func (w *packWriter) Truncate(int64) error               { return sbox.ErrNotSupported }
+func (w *packWriter) Readdir(int) ([]os.FileInfo, error) { return nil, syscall.ENOTDIR }
+func (w *packWriter) Readdirnames(int) ([]string, error) { return nil, syscall.ENOTDIR }
+
+// Sync does nothing: the shard is written, and synced, by Close.
+func (w *packWriter) Sync() error { return nil }
+
+// Seek only reports the offset, which is the end.
+func (w *packWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence == io.SeekStart && w.buf.Len() != 0 {
+		return 0, sbox.ErrNotSupported
+	}
+	return int64(w.buf.Len()), nil
+}
+
+func (w *packWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	return w.store.put(w.name, w.hash, w.buf.Bytes())
+}
+
+// emptyDir is a directory that exists only for the packed shards below it.
+type emptyDir struct {
+	name string
+}
+
+func (d *emptyDir) Name() string                       { return d.name }
+func (d *emptyDir) Stat() (os.FileInfo, error)         { return dirInfo(filepath.Base(d.name)), nil }
+func (d *emptyDir) Readdir(int) ([]os.FileInfo, error) { return nil, nil }
+func (d *emptyDir) Readdirnames(int) ([]string, error) { return nil, nil }
+func (d *emptyDir) Sync() error                        { return nil }
+func (d *emptyDir) Close() error                       { return nil }
+func (d *emptyDir) Read([]byte) (int, error)           { return 0, syscall.EISDIR }
+func (d *emptyDir) ReadAt([]byte, int64) (int, error)  { return 0, syscall.EISDIR }
+func (d *emptyDir) Seek(int64, int) (int64, error)     { return 0, syscall.EISDIR }
+func (d *emptyDir) Write([]byte) (int, error)          { return 0, syscall.EISDIR }
+func (d *emptyDir) WriteAt([]byte, int64) (int, error) { return 0, syscall.EISDIR }
+func (d *emptyDir) WriteString(string) (int, error)    { return 0, syscall.EISDIR }
+func (d *emptyDir) Truncate(int64) error               { return syscall.EISDIR }
+
+// Compile-time interface checks.
+var (
+	_ afero.Fs   = (*packStore)(nil)
+	_ afero.File = (*packedFile)(nil)
+	_ afero.File = (*packWriter)(nil)
+	_ afero.File = (*emptyDir)(nil)
+)
@@ -0,0 +1,108 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestPacking_Suite(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16, sharded.WithPacking(0, 64))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+	t.Run("refcount+verify", func(t *testing.T) {
+		engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16, sharded.WithPacking(0, 64),
+			sharded.WithRefcount(true), sharded.WithVerifyOnRead(true), sharded.WithCompression(sharded.CompressionZstd))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+}
+
+func TestPacking(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	open := func() *sharded.Engine {
+		return sharded.New(manifestFs, shardsFs, 8, sharded.WithPacking(8, 24))
+	}
+	engine := open()
+
+	writeFile(t, engine, "a.txt", "aaaaaaaabbbbbbbbcccccccc")
+	writeFile(t, engine, "b.txt", "ddddddddeeeeeeeeffffffff")
+	// Six 8-byte shards fill two packs, each with its index.
+	var files int
+	countShards(t, shardsFs, "", &files)
+	if files != 4 {
+		t.Errorf("shard store holds %d files, want 4", files)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	engine = open()
+	if got := readFile(t, engine, "a.txt"); got != "aaaaaaaabbbbbbbbcccccccc" {
+		t.Errorf("a.txt after reopening = %q", got)
+	}
+	if report, err := engine.Verify(ctx, sharded.VerifyOptions{}); err != nil || !report.OK() || report.Shards != 6 {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+	if u, err := engine.Usage(ctx, ""); err != nil || u.Physical != 48 {
+		t.Errorf("Usage = %+v, %v", u, err)
+	}
+
+	// Shards removed by GC keep their space until Repack.
+	writeFile(t, engine, "b.txt", "ddddddddgggggggg")
+	if stats, err := engine.GC(ctx); err != nil || stats.Deleted != 2 {
+		t.Fatalf("GC = %+v, %v", stats, err)
+	}
+	stats, err := engine.Repack(ctx, sharded.RepackOptions{})
+	if err != nil {
+		t.Fatalf("Repack: %v", err)
+	}
+	// The second pack holds only "dddddddd" live: it is copied out.
+	if stats.Repacked != 1 || stats.Shards != 1 || stats.BytesFreed != 16 {
+		t.Errorf("Repack = %+v", stats)
+	}
+	for p, want := range map[string]string{"a.txt": "aaaaaaaabbbbbbbbcccccccc", "b.txt": "ddddddddgggggggg"} {
+		if got := readFile(t, engine, p); got != want {
+			t.Errorf("%s after Repack = %q, want %q", p, got, want)
+		}
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatal(err)
+	}
+	engine = open()
+	if got := readFile(t, engine, "b.txt"); got != "ddddddddgggggggg" {
+		t.Errorf("b.txt after reopening = %q", got)
+	}
+}
+
+func TestPacking_LargeShards(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 1024, sharded.WithPacking(16, 0))
+	content := strings.Repeat("x", 1024) + "tail"
+	writeFile(t, engine, "big.txt", content)
+
+	// The full chunk is a file of its own, the tail is packed.
+	if exists, _ := afero.Exists(shardsFs, sbox.HashPath(sha256Hex(strings.Repeat("x", 1024)))); !exists {
+		t.Error("large shard not stored as a file")
+	}
+	if exists, _ := afero.Exists(shardsFs, sbox.HashPath(sha256Hex("tail"))); exists {
+		t.Error("small shard stored as a file")
+	}
+	if got := readFile(t, engine, "big.txt"); got != content {
+		t.Errorf("big.txt = %d bytes", len(got))
+	}
+}
+
+func TestPacking_Disabled(t *testing.T) {
+	if _, err := newTestEngine().Repack(context.Background(), sharded.RepackOptions{}); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Repack = %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,461 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// maxDirtyChunks bounds the memory of a random-access writer. Beyond it,
+// modified chunks other than the one being written are stored early.
+const maxDirtyChunks = 16
+
+// randomWriter implements random-access reads and writes for files opened
+// with O_RDWR. Chunks are loaded when first touched, modified in memory and
+// stored as new shards on Close; unmodified chunks keep their shards.
+//
+// Chunk boundaries do not move: overwrites change chunks in place, writes
+// past the end grow the last chunk up to the chunk size before starting a
+// new one, and Truncate cuts the chunk at the new end.
+//
+// Like shardedWriter, it stops loading and storing chunks once the context
+// it was opened with is done, and Close then discards the changes.
+type randomWriter struct {
+	ctx      context.Context
+	engine   *Engine
+	path     string
+	append   bool
+	modified bool
+	metadata map[string]string
+	perms    permissions
+	ctype    string
+
+	chunks []randomChunk
+	starts []int64 // Offset of each chunk
+	size   int64
+	offset int64
+	dirty  int // Chunks with modified content
+
+	owned   []string      // Shards referenced when stored by this writer
+	journal *writeJournal // Started when the first shard is stored
+
+	// Last unmodified chunk loaded for reading.
+	cached    []byte
+	cachedIdx int
+}
+
+// randomChunk is a chunk of a file open for random access.
+type randomChunk struct {
+	storedChunk
+	data []byte // Modified content; nil if the stored shard is current
+}
+
+// openRandom opens path for random-access writes.
+func (e *Engine) openRandom(ctx context.Context, path string, flag int) (*randomWriter, error) {
+	w := &randomWriter{ctx: ctx, engine: e, path: path, append: flag&os.O_APPEND != 0}
+
+	mPath := e.manifestPath(path)
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	switch {
+	case err == nil && flag&os.O_TRUNC != 0:
+		w.modified = true
+	case err == nil:
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return nil, err
+		}
+		w.metadata, w.perms, w.ctype = m.Metadata, permissionsOf(&m), m.ContentType
+		sizes := e.chunkSizes(&m)
+		for i, hash := range m.Chunks {
+			c := storedChunk{hash: hash, size: sizes[i], stored: sizes[i]}
+			if i < len(m.Compressed) {
+				c.compressed, c.stored = m.Compressed[i], m.StoredSizes[i]
+			}
+			if i < len(m.Keys) {
+				c.key = m.Keys[i]
+			}
+			w.chunks = append(w.chunks, randomChunk{storedChunk: c})
+			w.starts = append(w.starts, w.size)
+			w.size += c.size
+		}
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := e.manifestFs.MkdirAll(filepath.Dir(mPath), 0755); err != nil {
+			return nil, err
+		}
+		w.modified = true
+	default:
+		return nil, err
+	}
+	if md := sbox.MetadataFromContext(ctx); md != nil {
+		w.metadata, w.modified = md, true
+	}
+	if ct := sbox.FileOptionsFromContext(ctx).ContentType; ct != "" {
+		w.ctype, w.modified = ct, true
+	}
+	return w, nil
+}
+
+// locate returns the chunk holding offset off, which must be below the
+// file size, and the offset within that chunk.
+func (w *randomWriter) locate(off int64) (int, int64) {
+	i := sort.Search(len(w.starts), func(i int) bool { return w.starts[i] > off }) - 1
+	return i, off - w.starts[i]
+}
+
+// load returns the content of chunk i for modification.
+func (w *randomWriter) load(i int) ([]byte, error) {
+	c := &w.chunks[i]
+	if c.data != nil {
+		return c.data, nil
+	}
+	data, err := w.read(i)
+	if err != nil {
+		return nil, err
+	}
+	c.data = append(make([]byte, 0, max(int64(len(data)), w.engine.chunkSize)), data...)
+	w.dirty++
+	w.cached = nil
+	return c.data, w.spill(i)
+}
+
+// read returns the content of chunk i for reading.
+func (w *randomWriter) read(i int) ([]byte, error) {
+	c := &w.chunks[i]
+	if c.data != nil {
+		return c.data, nil
+	}
+	if w.cached != nil && w.cachedIdx == i {
+		return w.cached, nil
+	}
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	var data []byte
+	if c.hash == sbox.HoleChunk {
+		data = make([]byte, c.size)
+	} else {
+		var err error
+		if data, err = w.engine.loadChunk(c.hash, c.key, c.compressed); err != nil {
+			return nil, err
+		}
+	}
+	w.cached, w.cachedIdx = data, i
+	return data, nil
+}
+
+// spill stores modified chunks other than keep once too many are held in
+// memory.
+func (w *randomWriter) spill(keep int) error {
+	if w.dirty <= maxDirtyChunks {
+		return nil
+	}
+	for i := range w.chunks {
+		if i != keep && w.chunks[i].data != nil {
+			if err := w.store(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// store writes the modified content of chunk i as a shard.
+func (w *randomWriter) store(i int) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	j, err := w.engine.startJournal(&w.journal, w.path)
+	if err != nil {
+		return err
+	}
+	c, err := w.engine.storeChunk(w.chunks[i].data, j)
+	if err != nil {
+		return err
+	}
+	w.owned = append(w.owned, c.hash)
+	w.chunks[i] = randomChunk{storedChunk: c}
+	w.dirty--
+	return nil
+}
+
+// WriteAt writes p at offset off. Writing past the end fills the gap with
+// zeros.
+func (w *randomWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, sbox.ErrInvalid
+	}
+	if err := w.grow(off); err != nil {
+		return 0, err
+	}
+	w.modified = true
+
+	n := 0
+	for n < len(p) && off+int64(n) < w.size {
+		i, co := w.locate(off + int64(n))
+		data, err := w.load(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(data[co:], p[n:])
+	}
+	if n < len(p) {
+		if err := w.extend(p[n:]); err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
+
+// extend appends p to the end of the file.
+func (w *randomWriter) extend(p []byte) error {
+	for len(p) > 0 {
+		last := len(w.chunks) - 1
+		if last >= 0 && w.chunks[last].size < w.engine.chunkSize {
+			data, err := w.load(last)
+			if err != nil {
+				return err
+			}
+			k := min(w.engine.chunkSize-int64(len(data)), int64(len(p)))
+			w.chunks[last].data = append(data, p[:k]...)
+			w.chunks[last].size += k
+			w.size += k
+			p = p[k:]
+			continue
+		}
+
+		k := min(w.engine.chunkSize, int64(len(p)))
+		data := append(make([]byte, 0, w.engine.chunkSize), p[:k]...)
+		w.chunks = append(w.chunks, randomChunk{storedChunk: storedChunk{size: k}, data: data})
+		w.starts = append(w.starts, w.size)
+		w.dirty++
+		w.size += k
+		p = p[k:]
+		if err := w.spill(len(w.chunks) - 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grow extends the file with zeros up to size. With sparse files, whole
+// chunks of zeros are added as holes.
+func (w *randomWriter) grow(size int64) error {
+	if size <= w.size {
+		return nil
+	}
+	cs := w.engine.chunkSize
+	zeros := make([]byte, min(size-w.size, cs))
+	for w.size < size {
+		if last := len(w.chunks) - 1; w.engine.sparse && size-w.size >= cs && (last < 0 || w.chunks[last].size == cs) {
+			w.chunks = append(w.chunks, randomChunk{storedChunk: storedChunk{hash: sbox.HoleChunk, size: cs}})
+			w.starts = append(w.starts, w.size)
+			w.size += cs
+			continue
+		}
+		if err := w.extend(zeros[:min(size-w.size, int64(len(zeros)))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate changes the size of the file.
+func (w *randomWriter) Truncate(size int64) error {
+	if size < 0 {
+		return sbox.ErrInvalid
+	}
+	w.modified = true
+	if size >= w.size {
+		return w.grow(size)
+	}
+
+	i, co := w.locate(size)
+	if co > 0 {
+		data, err := w.load(i)
+		if err != nil {
+			return err
+		}
+		w.chunks[i].data = data[:co]
+		w.chunks[i].size = co
+		i++
+	}
+	for _, c := range w.chunks[i:] {
+		if c.data != nil {
+			w.dirty--
+		}
+	}
+	w.chunks, w.starts = w.chunks[:i], w.starts[:i]
+	w.size = size
+	w.cached = nil
+	return nil
+}
+
+// ReadAt reads len(p) bytes at offset off, including unsaved changes.
+func (w *randomWriter) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, sbox.ErrInvalid
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < w.size {
+		i, co := w.locate(off + int64(n))
+		data, err := w.read(i)
+		if err != nil {
+			return n, err
+		}
+		if co >= int64(len(data)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], data[co:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (w *randomWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadAt(p, w.offset)
+	w.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (w *randomWriter) Write(p []byte) (int, error) {
+	if w.append {
+		w.offset = w.size
+	}
+	n, err := w.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read or Write. Seeking past the end is
+// allowed; a later write fills the gap with zeros.
+func (w *randomWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += w.offset
+	case io.SeekEnd:
+		offset += w.size
+	default:
+		return 0, errors.New("sbox/sharded: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("sbox/sharded: seek offset out of range")
+	}
+	w.offset = offset
+	return offset, nil
+}
+
+// Close stores the modified chunks and writes the new manifest. Nothing is
+// written if the file was not modified.
+func (w *randomWriter) Close() error {
+	if !w.modified {
+		return nil
+	}
+	if err := w.ctx.Err(); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	for i := range w.chunks {
+		if w.chunks[i].data != nil {
+			if err := w.store(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	manifest := sbox.Manifest{
+		Chunks:     make([]string, len(w.chunks)),
+		ChunkSizes: make([]int64, len(w.chunks)),
+		Size:       w.size,
+		ModTime:    time.Now(),
+		CreatedBy:  createdBy,
+		Metadata:   w.metadata,
+
+		ContentType: w.ctype,
+	}
+	w.perms.apply(&manifest)
+	compressed := make([]bool, len(w.chunks))
+	storedSizes := make([]int64, len(w.chunks))
+	keys := make([]string, len(w.chunks))
+	var anyCompressed, anyKey bool
+	for i, c := range w.chunks {
+		manifest.Chunks[i], manifest.ChunkSizes[i] = c.hash, c.size
+		compressed[i], storedSizes[i], keys[i] = c.compressed, c.stored, c.key
+		anyCompressed = anyCompressed || c.compressed
+		anyKey = anyKey || c.key != ""
+	}
+	if anyCompressed {
+		manifest.Compressed, manifest.StoredSizes = compressed, storedSizes
+	}
+	if anyKey {
+		manifest.Keys = keys
+	}
+	data, err := w.engine.marshalManifest(&manifest)
+	if err != nil {
+		return err
+	}
+
+	// Every chunk of the new manifest takes a reference, the shards stored
+	// by this writer drop the one taken when they were stored, and the
+	// manifest being replaced releases its references.
+	mPath := w.engine.manifestPath(w.path)
+	replaced, err := w.engine.refChunks(w.engine.manifestChunks, mPath)
+	if err != nil {
+		return err
+	}
+	old := w.engine.previousManifest(mPath)
+	err = w.journal.commit(data)
+	if err == nil {
+		err = w.engine.writeManifest(mPath, data)
+	}
+	if err != nil {
+		w.journal.abandon()
+		w.journal = nil
+		return err
+	}
+	w.modified = false
+	release, err := w.engine.supersede(mPath, old, replaced)
+	if rerr := w.engine.adjustRefs(manifest.Chunks, append(release, w.owned...)); err == nil {
+		err = rerr
+	}
+	w.owned = nil
+	if jerr := w.journal.done(); err == nil {
+		err = jerr
+	}
+	w.journal = nil
+	return err
+}
+
+// Abort discards all changes.
+func (w *randomWriter) Abort() error {
+	w.modified = false
+	owned := w.owned
+	w.owned = nil
+	err := w.engine.adjustRefs(nil, owned)
+	if jerr := w.journal.done(); err == nil {
+		err = jerr
+	}
+	w.journal = nil
+	return err
+}
+
+// Compile-time interface checks.
+var (
+	_ sbox.WriteSeekCloser = (*randomWriter)(nil)
+	_ io.ReaderAt          = (*randomWriter)(nil)
+	_ io.WriterAt          = (*randomWriter)(nil)
+	_ io.Reader            = (*randomWriter)(nil)
+)
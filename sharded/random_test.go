@@ -0,0 +1,169 @@
+package sharded_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+func openRW(t *testing.T, engine *sharded.Engine, path string, flag int) sbox.WriteSeekCloser {
+	t.Helper()
+	f, err := engine.OpenFile(context.Background(), path, os.O_RDWR|flag, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile %s: %v", path, err)
+	}
+	return f
+}
+
+func TestRandomWrite(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 16)
+	want := []byte(string(bytes.Repeat([]byte("abcdefghijklmnop"), 6)) + "tail")
+	writeFile(t, engine, "f.bin", string(want))
+	var before int
+	countShards(t, shardsFs, "", &before)
+
+	f := openRW(t, engine, "f.bin", 0)
+	_, _ = f.Seek(20, io.SeekStart)
+	_, _ = f.Write([]byte("XYZ"))
+	copy(want[20:], "XYZ")
+
+	// Reads see changes that are not saved yet.
+	buf := make([]byte, 5)
+	if _, err := f.(io.ReaderAt).ReadAt(buf, 18); err != nil || string(buf) != "cdXYZ" {
+		t.Errorf("ReadAt = %q, %v", buf, err)
+	}
+
+	// Writing past the end fills the gap with zeros.
+	_, _ = f.Seek(110, io.SeekStart)
+	_, _ = f.Write([]byte("end"))
+	want = append(append(want, make([]byte, 110-len(want))...), "end"...)
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := readFile(t, engine, "f.bin"); got != string(want) {
+		t.Errorf("content = %q\nwant      %q", got, want)
+	}
+	var after int
+	countShards(t, shardsFs, "", &after)
+	if added := after - before; added > 4 {
+		t.Errorf("%d shards added, want only the modified and new chunks", added)
+	}
+
+	f = openRW(t, engine, "f.bin", 0)
+	if err := f.(interface{ Truncate(int64) error }).Truncate(30); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := readFile(t, engine, "f.bin"); got != string(want[:30]) {
+		t.Errorf("truncated content = %q", got)
+	}
+}
+
+func TestRandomWrite_Flags(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16)
+
+	if _, err := engine.OpenFile(ctx, "missing.txt", os.O_RDWR, 0644); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("O_RDWR on missing file = %v, want %v", err, os.ErrNotExist)
+	}
+
+	f := openRW(t, engine, "new.txt", os.O_CREATE)
+	_, _ = f.Write([]byte("hello"))
+	_ = f.Close()
+
+	f = openRW(t, engine, "new.txt", os.O_APPEND)
+	_, _ = f.Seek(0, io.SeekStart)
+	_, _ = f.Write([]byte(" world"))
+	_ = f.Close()
+	if got := readFile(t, engine, "new.txt"); got != "hello world" {
+		t.Errorf("after append = %q", got)
+	}
+
+	f = openRW(t, engine, "new.txt", 0)
+	_, _ = f.Write([]byte("HELLO"))
+	if err := f.(interface{ Abort() error }).Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	if got := readFile(t, engine, "new.txt"); got != "hello world" {
+		t.Errorf("after abort = %q", got)
+	}
+}
+
+func TestRandomWrite_Model(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, shardsFs, 16, sharded.WithRefcount(true))
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	var model []byte
+	for round := range 3 {
+		f := openRW(t, engine, "db.bin", os.O_CREATE)
+		for range 200 {
+			off := rng.IntN(len(model) + 64)
+			p := make([]byte, rng.IntN(40)+1)
+			for i := range p {
+				p[i] = byte(rng.Uint32())
+			}
+			if _, err := f.(io.WriterAt).WriteAt(p, int64(off)); err != nil {
+				t.Fatalf("WriteAt: %v", err)
+			}
+			if end := off + len(p); end > len(model) {
+				model = append(model, make([]byte, end-len(model))...)
+			}
+			copy(model[off:], p)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("round %d: Close: %v", round, err)
+		}
+		if got := readFile(t, engine, "db.bin"); got != string(model) {
+			t.Fatalf("round %d: content differs from model", round)
+		}
+	}
+
+	// Shards stored and replaced along the way were released.
+	stats, err := engine.GCWithProgress(ctx, sharded.GCOptions{DryRun: true})
+	if err != nil || stats.Deleted != 0 {
+		t.Errorf("GC found %+v orphaned shards, %v", stats, err)
+	}
+	report, err := engine.Verify(ctx, sharded.VerifyOptions{})
+	if err != nil || !report.OK() {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+}
+
+func TestPutRange(t *testing.T) {
+	ctx := context.Background()
+	manifestFs := afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, afero.NewMemMapFs(), 4)
+	if err := sbox.PutAtomic(ctx, engine, "f", bytes.NewReader([]byte("aaaabbbbcccc"))); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	before := readManifest(t, manifestFs, "manifests/f.json")
+
+	if n, err := engine.PutRange(ctx, "f", 5, bytes.NewReader([]byte("XY"))); err != nil || n != 2 {
+		t.Fatalf("PutRange = %d, %v", n, err)
+	}
+	after := readManifest(t, manifestFs, "manifests/f.json")
+	if after.Size != 12 || after.Chunks[0] != before.Chunks[0] || after.Chunks[1] == before.Chunks[1] || after.Chunks[2] != before.Chunks[2] {
+		t.Errorf("chunks after PutRange = %v, was %v; want only the middle one rewritten", after.Chunks, before.Chunks)
+	}
+	r, _ := engine.Open(ctx, "f")
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "aaaabXYbcccc" {
+		t.Errorf("content = %q", got)
+	}
+}
@@ -1,22 +1,47 @@
 package sharded
 
 import (
+	"context"
 	"errors"
 	"io"
 
+	"github.com/spf13/afero"
+
 	"github.com/nuln/sbox"
 )
 
 // shardedReader implements sbox.ReadSeekCloser by transparently stitching
 // shards together. It supports seeking to any offset within the logical file.
+// Reads fail with the context error once the context it was opened with is
+// done.
 type shardedReader struct {
+	ctx      context.Context
 	engine   *Engine
 	manifest sbox.Manifest
 	offset   int64
+
+	// Cached chunk for verified reads.
+	chunk    []byte
+	chunkIdx int
+
+	// Open shard for direct reads, kept until the reader moves on.
+	shard     afero.File
+	shardHash string
+
+	// Chunks being fetched ahead of the reader, by index.
+	ahead map[int]*prefetch
 }
 
-func newShardedReader(e *Engine, m sbox.Manifest) *shardedReader {
+// prefetch is a chunk being loaded in the background.
+type prefetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newShardedReader(ctx context.Context, e *Engine, m sbox.Manifest) *shardedReader {
 	return &shardedReader{
+		ctx:      ctx,
 		engine:   e,
 		manifest: m,
 		offset:   0,
@@ -30,6 +55,9 @@ func (r *shardedReader) Read(p []byte) (n int, err error) { //nolint:gocyclo
 
 	totalRead := 0
 	for len(p) > 0 && r.offset < r.manifest.Size {
+		if err := r.ctx.Err(); err != nil {
+			return totalRead, err
+		}
 		var chunkIdx int
 		var chunkOffset int64
 
@@ -58,17 +86,6 @@ func (r *shardedReader) Read(p []byte) (n int, err error) { //nolint:gocyclo
 		}
 
 		hash := r.manifest.Chunks[chunkIdx]
-		shardPath := r.engine.shardPath(hash)
-
-		f, err := r.engine.shardsFs.Open(shardPath)
-		if err != nil {
-			return totalRead, err
-		}
-
-		if _, err := f.Seek(chunkOffset, io.SeekStart); err != nil {
-			_ = f.Close()
-			return totalRead, err
-		}
 
 		// Calculate how much can be read from this chunk
 		var remainingInChunk int64
@@ -83,8 +100,16 @@ func (r *shardedReader) Read(p []byte) (n int, err error) { //nolint:gocyclo
 			toRead = len(p)
 		}
 
-		read, readErr := f.Read(p[:toRead])
-		_ = f.Close()
+		var read int
+		var readErr error
+		if hash == sbox.HoleChunk {
+			clear(p[:toRead])
+			read = toRead
+		} else if r.engine.readAhead > 0 || r.engine.verifyOnRead || r.compressed(chunkIdx) || r.key(chunkIdx) != "" {
+			read, readErr = r.readWhole(chunkIdx, hash, chunkOffset, p[:toRead])
+		} else {
+			read, readErr = r.readDirect(hash, chunkOffset, p[:toRead])
+		}
 
 		if read > 0 {
 			totalRead += read
@@ -104,6 +129,104 @@ func (r *shardedReader) Read(p []byte) (n int, err error) { //nolint:gocyclo
 	return totalRead, nil
 }
 
+// readDirect reads straight from the shard file without verification.
+func (r *shardedReader) readDirect(hash string, chunkOffset int64, p []byte) (int, error) {
+	if r.shard == nil || r.shardHash != hash {
+		r.closeShard()
+		r.engine.accessShard(hash)
+		f, err := r.engine.shardsFs.Open(r.engine.shardPath(hash))
+		if err != nil {
+			return 0, err
+		}
+		r.shard, r.shardHash = f, hash
+	}
+	if _, err := r.shard.Seek(chunkOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return r.shard.Read(p)
+}
+
+// closeShard closes the shard kept open for direct reads.
+func (r *shardedReader) closeShard() {
+	if r.shard != nil {
+		_ = r.shard.Close()
+		r.shard = nil
+	}
+}
+
+// compressed reports whether the shard of a chunk is stored compressed.
+func (r *shardedReader) compressed(chunkIdx int) bool {
+	return chunkIdx < len(r.manifest.Compressed) && r.manifest.Compressed[chunkIdx]
+}
+
+// key returns the wrapped key of an encrypted chunk, or "".
+func (r *shardedReader) key(chunkIdx int) string {
+	if chunkIdx < len(r.manifest.Keys) {
+		return r.manifest.Keys[chunkIdx]
+	}
+	return ""
+}
+
+// readWhole reads from a fully loaded, decoded and, with verify-on-read,
+// hash-checked copy of the chunk. The current chunk is cached so sequential
+// reads load each shard once.
+func (r *shardedReader) readWhole(chunkIdx int, hash string, chunkOffset int64, p []byte) (int, error) {
+	if r.chunk == nil || r.chunkIdx != chunkIdx {
+		var data []byte
+		var err error
+		if r.engine.readAhead > 0 {
+			data, err = r.fetch(chunkIdx)
+		} else {
+			data, err = r.engine.loadChunk(hash, r.key(chunkIdx), r.compressed(chunkIdx))
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = data
+		r.chunkIdx = chunkIdx
+	}
+	if chunkOffset >= int64(len(r.chunk)) {
+		return 0, io.EOF
+	}
+	return copy(p, r.chunk[chunkOffset:]), nil
+}
+
+// fetch returns chunk chunkIdx and starts loading the chunks after it in
+// the background. Chunks outside that window, e.g. after a seek, are
+// dropped.
+func (r *shardedReader) fetch(chunkIdx int) ([]byte, error) {
+	if r.ahead == nil {
+		r.ahead = make(map[int]*prefetch)
+	}
+	last := min(chunkIdx+r.engine.readAhead, len(r.manifest.Chunks)-1)
+	for i := range r.ahead {
+		if i < chunkIdx || i > last {
+			delete(r.ahead, i)
+		}
+	}
+	for i := chunkIdx; i <= last; i++ {
+		if _, ok := r.ahead[i]; ok || r.manifest.Chunks[i] == sbox.HoleChunk {
+			continue
+		}
+		pf := &prefetch{done: make(chan struct{})}
+		r.ahead[i] = pf
+		hash, key, compressed := r.manifest.Chunks[i], r.key(i), r.compressed(i)
+		go func() {
+			defer close(pf.done)
+			pf.data, pf.err = r.engine.loadChunk(hash, key, compressed)
+		}()
+	}
+
+	pf := r.ahead[chunkIdx]
+	delete(r.ahead, chunkIdx)
+	select {
+	case <-pf.done:
+		return pf.data, pf.err
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+}
+
 func (r *shardedReader) Seek(offset int64, whence int) (int64, error) {
 	var newOffset int64
 	switch whence {
@@ -126,5 +249,8 @@ func (r *shardedReader) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (r *shardedReader) Close() error {
+	r.chunk = nil
+	r.ahead = nil
+	r.closeShard()
 	return nil
 }
@@ -0,0 +1,86 @@
+package sharded_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+// slowFs delays every open and records how many are in flight at once.
+type slowFs struct {
+	afero.Fs
+	inFlight, peak atomic.Int32
+}
+
+func (fs *slowFs) delay() {
+	n := fs.inFlight.Add(1)
+	defer fs.inFlight.Add(-1)
+	for {
+		p := fs.peak.Load()
+		if n <= p || fs.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+}
+
+func (fs *slowFs) Open(name string) (afero.File, error) {
+	fs.delay()
+	return fs.Fs.Open(name)
+}
+
+func (fs *slowFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fs.delay()
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func TestReadAhead_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+		sharded.WithReadAhead(4)))
+}
+
+func TestReadAhead_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, memFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	writeFile(t, sharded.New(manifestFs, memFs, 1024), "big.bin", string(data))
+
+	shards := &slowFs{Fs: memFs}
+	engine := sharded.New(manifestFs, shards, 1024, sharded.WithReadAhead(8))
+	r, err := engine.Open(ctx, "big.bin")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("content mismatch")
+	}
+	if peak := shards.peak.Load(); peak < 4 {
+		t.Errorf("at most %d shards were read at once, want read-ahead", peak)
+	}
+
+	// Seeking backwards drops the window and refetches.
+	if _, err := r.Seek(1000, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, data[1000:1100]) {
+		t.Errorf("read after seek = %v", err)
+	}
+	_ = r.Close()
+}
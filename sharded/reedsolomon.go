@@ -0,0 +1,199 @@
+package sharded
+
+import "errors"
+
+// errTooFewPieces is returned when fewer pieces than the data pieces of a
+// code are left to reconstruct from.
+var errTooFewPieces = errors.New("too few pieces to reconstruct")
+
+// gfExp and gfLog are the exponent and logarithm tables of GF(2^8) with
+// the polynomial x^8+x^4+x^3+x^2+1, the field of the Reed-Solomon code.
+// gfExp is doubled so that sums of two logarithms need no reduction.
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i], gfExp[i+255] = byte(x), byte(x)
+		gfLog[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the inverse of a, which is not zero.
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	switch {
+	case n == 0:
+		return 1
+	case a == 0:
+		return 0
+	}
+	return gfExp[int(gfLog[a])*n%255]
+}
+
+// gfMulAdd adds c times in to out.
+func gfMulAdd(out, in []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	lc := int(gfLog[c])
+	for i, b := range in {
+		if b != 0 {
+			out[i] ^= gfExp[lc+int(gfLog[b])]
+		}
+	}
+}
+
+// gfInvert returns the inverse of the square matrix m, by Gauss-Jordan
+// elimination, or nil if m is singular.
+func gfInvert(m [][]byte) [][]byte {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if c := work[col][col]; c != 1 {
+			inv := gfInv(c)
+			for j := range work[col] {
+				work[col][j] = gfMul(work[col][j], inv)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				gfMulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = work[i][n:]
+	}
+	return inv
+}
+
+// rsCode is a systematic Reed-Solomon code: a block is split into data
+// pieces, kept as they are, and parity pieces, and any data pieces of the
+// total rebuild the block.
+type rsCode struct {
+	data, parity int
+	matrix       [][]byte // One row per piece; the data rows are the identity
+}
+
+// newRSCode returns a code of data and parity pieces, at most 256 in all.
+// Its matrix is a Vandermonde matrix, of which any data rows are linearly
+// independent, multiplied by the inverse of its top rows to make the code
+// systematic.
+func newRSCode(data, parity int) *rsCode {
+	v := make([][]byte, data+parity)
+	for r := range v {
+		v[r] = make([]byte, data)
+		for c := range v[r] {
+			v[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top := gfInvert(v[:data])
+	matrix := make([][]byte, len(v))
+	for r := range matrix {
+		matrix[r] = make([]byte, data)
+		for c := range matrix[r] {
+			var x byte
+			for i := 0; i < data; i++ {
+				x ^= gfMul(v[r][i], top[i][c])
+			}
+			matrix[r][c] = x
+		}
+	}
+	return &rsCode{data: data, parity: parity, matrix: matrix}
+}
+
+// split pads block to a multiple of the data pieces and returns all pieces
+// of it, the parity ones computed.
+func (c *rsCode) split(block []byte) [][]byte {
+	size := (len(block) + c.data - 1) / c.data
+	padded := make([]byte, size*(c.data+c.parity))
+	copy(padded, block)
+	pieces := make([][]byte, c.data+c.parity)
+	for i := range pieces {
+		pieces[i] = padded[i*size : (i+1)*size : (i+1)*size]
+	}
+	c.encode(pieces)
+	return pieces
+}
+
+// encode computes the parity pieces from the data pieces.
+func (c *rsCode) encode(pieces [][]byte) {
+	for p := c.data; p < len(pieces); p++ {
+		clear(pieces[p])
+		for d := 0; d < c.data; d++ {
+			gfMulAdd(pieces[p], pieces[d], c.matrix[p][d])
+		}
+	}
+}
+
+// reconstruct fills in the nil pieces, of size bytes, from the others.
+func (c *rsCode) reconstruct(pieces [][]byte, size int) error {
+	rows := make([]int, 0, c.data)
+	for i, p := range pieces {
+		if p != nil && len(rows) < c.data {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < c.data {
+		return errTooFewPieces
+	}
+	sub := make([][]byte, c.data)
+	for i, r := range rows {
+		sub[i] = c.matrix[r]
+	}
+	// sub maps the data pieces to the pieces present, so its inverse maps
+	// them back.
+	inv := gfInvert(sub)
+	missingParity := false
+	for d := 0; d < c.data; d++ {
+		if pieces[d] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for i, r := range rows {
+			gfMulAdd(out, pieces[r], inv[d][i])
+		}
+		pieces[d] = out
+	}
+	for p := c.data; p < len(pieces); p++ {
+		if pieces[p] == nil {
+			pieces[p] = make([]byte, size)
+			missingParity = true
+		}
+	}
+	if missingParity {
+		c.encode(pieces)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package sharded
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// refcountLogPath is the location of the reference count index inside the
+// shard store. It is an append-only log of "<hash> <delta>" lines that is
+// compacted into one line per live shard when the engine is closed.
+const refcountLogPath = "refcount.log"
+
+// refIndex tracks how many manifest chunk entries reference each shard so
+// that unreferenced shards can be deleted immediately instead of by GC.
+type refIndex struct {
+	mu     sync.Mutex
+	fs     afero.Fs
+	counts map[string]int64
+	log    afero.File
+}
+
+// openRefIndex loads the index from fs, or builds it with rebuild when no
+// index has been written yet.
+func openRefIndex(fs afero.Fs, rebuild func() (map[string]int64, error)) (*refIndex, error) {
+	ix := &refIndex{fs: fs}
+
+	f, err := fs.Open(refcountLogPath)
+	switch {
+	case err == nil:
+		ix.counts, err = readRefLog(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		if ix.counts, err = rebuild(); err != nil {
+			return nil, err
+		}
+		if err := ix.compact(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	ix.log, err = fs.OpenFile(refcountLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// readRefLog replays a refcount log. A torn final line left by a crash is
+// ignored.
+func readRefLog(f afero.File) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, rawDelta, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || !isShardName(hash) {
+			continue
+		}
+		delta, err := strconv.ParseInt(rawDelta, 10, 64)
+		if err != nil {
+			continue
+		}
+		if counts[hash] += delta; counts[hash] <= 0 {
+			delete(counts, hash)
+		}
+	}
+	return counts, scanner.Err()
+}
+
+// store runs write (which stores the shard if missing) and takes a reference
+// on hash atomically with respect to concurrent releases.
+func (ix *refIndex) store(hash string, write func() error) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := write(); err != nil {
+		return err
+	}
+	ix.counts[hash]++
+	_, err := fmt.Fprintf(ix.log, "%s +1\n", hash)
+	return err
+}
+
+// apply adds and removes one reference per entry and calls drop for every
+// shard whose count reaches zero.
+func (ix *refIndex) apply(add, remove []string, drop func(hash string) error) error {
+	deltas := make(map[string]int64, len(add)+len(remove))
+	for _, h := range add {
+		deltas[h]++
+	}
+	for _, h := range remove {
+		deltas[h]--
+	}
+	delete(deltas, sbox.HoleChunk)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var sb strings.Builder
+	var dropped []string
+	for h, d := range deltas {
+		if d == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s %+d\n", h, d)
+		if ix.counts[h] += d; ix.counts[h] <= 0 {
+			delete(ix.counts, h)
+			dropped = append(dropped, h)
+		}
+	}
+	if sb.Len() > 0 {
+		if _, err := ix.log.WriteString(sb.String()); err != nil {
+			return err
+		}
+	}
+	for _, h := range dropped {
+		if err := drop(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forget runs remove (which deletes the shard) and drops every reference
+// still counted on hash, atomically with respect to concurrent stores.
+func (ix *refIndex) forget(hash string, remove func() error) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := remove(); err != nil {
+		return err
+	}
+	n, ok := ix.counts[hash]
+	if !ok {
+		return nil
+	}
+	delete(ix.counts, hash)
+	_, err := fmt.Fprintf(ix.log, "%s %+d\n", hash, -n)
+	return err
+}
+
+// compact rewrites the log as a snapshot with one line per live shard.
+func (ix *refIndex) compact() error {
+	tmp := refcountLogPath + ".tmp"
+	var sb strings.Builder
+	for h, n := range ix.counts {
+		fmt.Fprintf(&sb, "%s %d\n", h, n)
+	}
+	if err := afero.WriteFile(ix.fs, tmp, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return ix.fs.Rename(tmp, refcountLogPath)
+}
+
+// close compacts the log and releases the log handle.
+func (ix *refIndex) close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.log.Close(); err != nil {
+		return err
+	}
+	return ix.compact()
+}
+
+// reset replaces the counts, e.g. with counts rebuilt from the manifests,
+// and starts a new log.
+func (ix *refIndex) reset(counts map[string]int64) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.log.Close(); err != nil {
+		return err
+	}
+	ix.counts = counts
+	if err := ix.compact(); err != nil {
+		return err
+	}
+	var err error
+	ix.log, err = ix.fs.OpenFile(refcountLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// rebuildRefs replaces the reference counts with counts. It is a no-op
+// when reference counting is disabled.
+func (e *Engine) rebuildRefs(counts map[string]int64) error {
+	ix, err := e.refs()
+	if ix == nil || err != nil {
+		return err
+	}
+	return ix.reset(counts)
+}
+
+// refs returns the engine's reference count index, loading it on first use.
+// It returns nil when reference counting is disabled.
+func (e *Engine) refs() (*refIndex, error) {
+	if !e.refcount {
+		return nil, nil
+	}
+	e.refsOnce.Do(func() {
+		e.refIdx, e.refsErr = openRefIndex(e.shardsFs, e.countManifestRefs)
+	})
+	return e.refIdx, e.refsErr
+}
+
+// countManifestRefs counts chunk references across all manifests, including
+// those held by versions, transactions, multipart uploads and completed
+// snapshots.
+func (e *Engine) countManifestRefs() (map[string]int64, error) {
+	roots := []string{"manifests", versionsDir, txsDir, uploadsDir}
+	snapshots, err := afero.ReadDir(e.manifestFs, snapshotsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.IsDir() && validSnapshotName(s.Name()) {
+			roots = append(roots, filepath.Join(e.snapshotPath(s.Name()), "manifests"))
+		}
+	}
+
+	counts := make(map[string]int64)
+	for _, root := range roots {
+		chunks, err := e.treeChunks(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range chunks {
+			if h != sbox.HoleChunk {
+				counts[h]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// manifestChunks returns the chunk hashes of the manifest at mPath, or nil
+// if no manifest exists there.
+func (e *Engine) manifestChunks(mPath string) ([]string, error) {
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return nil, err
+	}
+	return m.Chunks, nil
+}
+
+// treeChunks returns the chunk hashes of every manifest below mDir.
+func (e *Engine) treeChunks(mDir string) ([]string, error) {
+	var all []string
+	err := afero.Walk(e.manifestFs, mDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		chunks, err := e.manifestChunks(p)
+		all = append(all, chunks...)
+		return err
+	})
+	return all, err
+}
+
+// adjustRefs takes references on add and releases references on remove,
+// deleting shards that become unreferenced. It is a no-op when reference
+// counting is disabled.
+func (e *Engine) adjustRefs(add, remove []string) error {
+	ix, err := e.refs()
+	if ix == nil || err != nil {
+		return err
+	}
+	return ix.apply(add, remove, func(hash string) error {
+		if err := e.shardsFs.Remove(e.shardPath(hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// removeOrphan deletes the shard at p, which no manifest references, and
+// drops the references the index still counts on it. Writers take their
+// references as they store shards, so a writer that is never closed or
+// aborted leaks them; without this a leaked count would keep the shard from
+// being freed once it is stored again.
+func (e *Engine) removeOrphan(p, hash string) error {
+	remove := func() error {
+		if err := e.shardsFs.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	ix, err := e.refs()
+	if err != nil {
+		return err
+	}
+	if ix == nil {
+		return remove()
+	}
+	return ix.forget(hash, remove)
+}
+
+// refChunks calls load(p) only when reference counting is enabled, so the
+// default configuration does not pay for reading manifests it won't use.
+// It loads the index first so that a rebuild sees the pre-mutation state.
+func (e *Engine) refChunks(load func(string) ([]string, error), p string) ([]string, error) {
+	if _, err := e.refs(); !e.refcount || err != nil {
+		return nil, err
+	}
+	return load(p)
+}
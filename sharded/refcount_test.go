@@ -0,0 +1,180 @@
+package sharded_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func newRefcountEngine(manifestFs, shardsFs afero.Fs) *sharded.Engine {
+	return sharded.New(manifestFs, shardsFs, 8, sharded.WithRefcount(true))
+}
+
+func shardCount(t *testing.T, fs afero.Fs) int {
+	t.Helper()
+	count := 0
+	countShards(t, fs, "", &count)
+	// Ignore the index itself.
+	if exists, _ := afero.Exists(fs, "refcount.log"); exists {
+		count--
+	}
+	return count
+}
+
+func TestRefcount_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, newRefcountEngine(afero.NewMemMapFs(), afero.NewMemMapFs()))
+}
+
+func TestRefcount_ImmediateDelete(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := newRefcountEngine(afero.NewMemMapFs(), shardsFs)
+	ctx := context.Background()
+
+	// Chunk size is 8: "shared-1" is common, the second chunk differs.
+	writeFile(t, engine, "a.txt", "shared-1aaaaaaaa")
+	writeFile(t, engine, "b.txt", "shared-1bbbbbbbb")
+	if n := shardCount(t, shardsFs); n != 3 {
+		t.Fatalf("shards = %d, want 3", n)
+	}
+
+	if err := engine.Remove(ctx, "a.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Errorf("shards after removing a.txt = %d, want 2", n)
+	}
+	if got := readFile(t, engine, "b.txt"); got != "shared-1bbbbbbbb" {
+		t.Errorf("b.txt = %q", got)
+	}
+
+	// Overwriting releases the old content.
+	writeFile(t, engine, "b.txt", "replaced")
+	if n := shardCount(t, shardsFs); n != 1 {
+		t.Errorf("shards after overwrite = %d, want 1", n)
+	}
+}
+
+func TestRefcount_AppendCopyRename(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := newRefcountEngine(afero.NewMemMapFs(), shardsFs)
+	ctx := context.Background()
+
+	writeFile(t, engine, "log.txt", "12345678")
+	w, err := engine.OpenFile(ctx, "log.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, _ = w.Write([]byte("abcdefgh"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := readFile(t, engine, "log.txt"); got != "12345678abcdefgh" {
+		t.Fatalf("after append = %q", got)
+	}
+
+	if err := engine.Copy(ctx, "log.txt", "copy.txt"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if err := engine.Remove(ctx, "log.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := readFile(t, engine, "copy.txt"); got != "12345678abcdefgh" {
+		t.Errorf("copy after source removed = %q", got)
+	}
+
+	writeFile(t, engine, "other.txt", "other!!!")
+	if err := engine.Rename(ctx, "other.txt", "copy.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 1 {
+		t.Errorf("shards after rename over copy = %d, want 1", n)
+	}
+
+	if err := engine.Remove(ctx, ""); err != nil {
+		t.Fatalf("Remove root: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 0 {
+		t.Errorf("shards after removing everything = %d, want 0", n)
+	}
+}
+
+func TestRefcount_PersistsAcrossClose(t *testing.T) {
+	manifestFs := afero.NewMemMapFs()
+	shardsFs := afero.NewMemMapFs()
+	ctx := context.Background()
+
+	// Files written before refcounting was enabled are picked up by the
+	// initial rebuild.
+	writeFile(t, sharded.New(manifestFs, shardsFs, 8), "old.txt", "legacy!!")
+
+	engine := newRefcountEngine(manifestFs, shardsFs)
+	writeFile(t, engine, "new.txt", "legacy!!fresh!!!")
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := afero.ReadFile(shardsFs, "refcount.log")
+	if err != nil {
+		t.Fatalf("refcount index not persisted: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("compacted index has %d lines, want 2:\n%s", lines, data)
+	}
+
+	reopened := newRefcountEngine(manifestFs, shardsFs)
+	if err := reopened.Remove(ctx, "new.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 1 {
+		t.Errorf("shards = %d, want 1 (legacy shard still referenced)", n)
+	}
+	if got := readFile(t, reopened, "old.txt"); got != "legacy!!" {
+		t.Errorf("old.txt = %q", got)
+	}
+}
+
+func TestRefcount_DroppedWriter(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := newRefcountEngine(afero.NewMemMapFs(), shardsFs)
+	ctx := context.Background()
+
+	// A writer that is neither closed nor aborted leaves its shards and the
+	// references it took behind.
+	w, err := engine.Create(ctx, "dropped.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := w.Write([]byte("dropped!again!!!")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Fatalf("shards after dropping the writer = %d, want 2", n)
+	}
+
+	stats, err := engine.GC(ctx)
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Deleted != 2 {
+		t.Errorf("GC deleted %d shards, want 2", stats.Deleted)
+	}
+	if n := shardCount(t, shardsFs); n != 0 {
+		t.Fatalf("shards after GC = %d, want 0", n)
+	}
+
+	// The leaked references are gone, so storing the same chunks again and
+	// removing the file frees them immediately.
+	writeFile(t, engine, "again.txt", "dropped!again!!!")
+	if err := engine.Remove(ctx, "again.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 0 {
+		t.Errorf("shards after removing the rewritten file = %d, want 0", n)
+	}
+}
@@ -0,0 +1,127 @@
+package sharded
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// ErrCorruptShard is returned when a shard's content does not match its hash.
+var ErrCorruptShard = errors.New("sbox/sharded: shard checksum mismatch")
+
+// loadChunk returns the content of a chunk. The shard is loaded, verified
+// when verify-on-read is enabled, decrypted if key is set and decompressed
+// if compressed is set.
+func (e *Engine) loadChunk(hash, key string, compressed bool) ([]byte, error) {
+	e.accessShard(hash)
+	var data []byte
+	var err error
+	if e.verifyOnRead {
+		data, err = e.readShard(hash, e.shardCheck(hash, key, compressed))
+		if err != nil || key == "" {
+			return data, err
+		}
+	} else if data, err = afero.ReadFile(e.shardsFs, e.shardPath(hash)); err != nil {
+		return nil, err
+	}
+	if key != "" {
+		if data, err = e.decryptChunk(data, key); err != nil {
+			return nil, err
+		}
+	}
+	return decodeShard(data, compressed)
+}
+
+// shardCheck returns a function that checks a copy of a shard against its
+// hash. It returns the decoded content (the ciphertext, for encrypted
+// shards), the bytes to store in place of a damaged shard and whether the
+// copy is good.
+//
+// A replica may have stored the chunk with a different compression setting.
+// Unencrypted copies are accepted in either form and stored in the form
+// the manifests expect, so that a stored shard stays compressed exactly
+// when it is smaller than its chunk.
+func (e *Engine) shardCheck(hash, key string, compressed bool) func([]byte) ([]byte, []byte, bool) {
+	if key != "" {
+		// Encrypted shards are addressed by the hash of the ciphertext.
+		return func(data []byte) ([]byte, []byte, bool) {
+			return data, data, shardHash(data) == hash
+		}
+	}
+	return func(data []byte) ([]byte, []byte, bool) {
+		plain, ok := verifyShard(data, hash, compressed)
+		if !ok {
+			return nil, nil, false
+		}
+		switch {
+		case !compressed:
+			return plain, plain, true
+		case len(data) < len(plain):
+			return plain, data, true
+		}
+		return plain, zstdEncoder().EncodeAll(plain, nil), true
+	}
+}
+
+// verifyShard decodes a stored shard and checks it against its hash. The
+// other form is tried too, since a replica may have been stored with a
+// different compression setting.
+func verifyShard(data []byte, hash string, compressed bool) ([]byte, bool) {
+	for _, c := range []bool{compressed, !compressed} {
+		if plain, err := decodeShard(data, c); err == nil && shardHash(plain) == hash {
+			return plain, true
+		}
+	}
+	return nil, false
+}
+
+// readShard loads a whole shard and passes it to check, built by
+// shardCheck. When read repair is configured, a corrupt or missing shard is
+// replaced with a good copy from the first repair source that has one.
+func (e *Engine) readShard(hash string, check func([]byte) ([]byte, []byte, bool)) ([]byte, error) {
+	shardPath := e.shardPath(hash)
+	data, err := afero.ReadFile(e.shardsFs, shardPath)
+	if err == nil {
+		if plain, _, ok := check(data); ok {
+			return plain, nil
+		}
+		err = fmt.Errorf("%w: %s", ErrCorruptShard, hash)
+	}
+	if len(e.repairSources) == 0 {
+		return nil, err
+	}
+
+	for _, src := range e.repairSources {
+		good, readErr := afero.ReadFile(src, shardPath)
+		if readErr != nil {
+			continue
+		}
+		plain, stored, ok := check(good)
+		if !ok {
+			continue
+		}
+		writeErr := e.shardsFs.MkdirAll(filepath.Dir(shardPath), 0755)
+		if writeErr == nil {
+			writeErr = afero.WriteFile(e.shardsFs, shardPath, stored, 0644)
+		}
+		if writeErr != nil {
+			e.logger.Warn("sbox/sharded: read repair failed to rewrite shard",
+				"hash", hash, "cause", err, "error", writeErr)
+			return plain, nil
+		}
+		e.logger.Info("sbox/sharded: repaired shard from replica", "hash", hash, "cause", err)
+		return plain, nil
+	}
+	e.logger.Error("sbox/sharded: no good replica found for shard", "hash", hash, "cause", err)
+	return nil, err
+}
+
+// shardHash returns the hex-encoded SHA-256 digest of data.
+func shardHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
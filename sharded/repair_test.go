@@ -0,0 +1,85 @@
+package sharded_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+var quietLogger = sharded.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+func contentShardPath(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return sbox.HashPath(hex.EncodeToString(sum[:]))
+}
+
+func TestReadRepair(t *testing.T) {
+	content := "precious data that must survive bit rot"
+	manifestFs := afero.NewMemMapFs()
+	primary := afero.NewMemMapFs()
+	replica := afero.NewMemMapFs()
+
+	writeFile(t, sharded.New(manifestFs, primary, sharded.DefaultChunkSize), "file.txt", content)
+	writeFile(t, sharded.New(afero.NewMemMapFs(), replica, sharded.DefaultChunkSize), "file.txt", content)
+
+	shardPath := contentShardPath(content)
+	if err := afero.WriteFile(primary, shardPath, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("corrupt shard: %v", err)
+	}
+
+	// Verification alone detects the corruption.
+	verifying := sharded.New(manifestFs, primary, sharded.DefaultChunkSize, sharded.WithVerifyOnRead(true))
+	r, err := verifying.Open(context.Background(), "file.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_, err = io.ReadAll(r)
+	_ = r.Close()
+	if !errors.Is(err, sharded.ErrCorruptShard) {
+		t.Fatalf("read corrupt shard = %v, want %v", err, sharded.ErrCorruptShard)
+	}
+
+	// Read repair heals the primary from the replica.
+	repairing := sharded.New(manifestFs, primary, sharded.DefaultChunkSize, sharded.WithReadRepair(replica), quietLogger)
+	if got := readFile(t, repairing, "file.txt"); got != content {
+		t.Errorf("repaired read = %q, want %q", got, content)
+	}
+
+	healed, err := afero.ReadFile(primary, shardPath)
+	if err != nil {
+		t.Fatalf("read healed shard: %v", err)
+	}
+	if string(healed) != content {
+		t.Errorf("primary shard = %q, want %q", string(healed), content)
+	}
+}
+
+func TestReadRepair_NoGoodReplica(t *testing.T) {
+	content := "nobody has a good copy"
+	manifestFs := afero.NewMemMapFs()
+	primary := afero.NewMemMapFs()
+
+	writeFile(t, sharded.New(manifestFs, primary, sharded.DefaultChunkSize), "file.txt", content)
+	if err := afero.WriteFile(primary, contentShardPath(content), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("corrupt shard: %v", err)
+	}
+
+	engine := sharded.New(manifestFs, primary, sharded.DefaultChunkSize, sharded.WithReadRepair(afero.NewMemMapFs()), quietLogger)
+	r, err := engine.Open(context.Background(), "file.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	if _, err := io.ReadAll(r); !errors.Is(err, sharded.ErrCorruptShard) {
+		t.Errorf("read = %v, want %v", err, sharded.ErrCorruptShard)
+	}
+}
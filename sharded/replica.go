@@ -0,0 +1,329 @@
+package sharded
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// replicatedFs is the shard store of an engine with WithReplicas: it
+// writes each shard to n of its stores and reads it from the first of them
+// that has it. Other files are written to the first store, and directories
+// list the union of all stores.
+type replicatedFs struct {
+	stores []afero.Fs
+	n      int
+	engine *Engine
+}
+
+// placement returns stores in the order they hold the shard hash. The
+// order ranks the stores by a hash of the shard and the store index
+// (rendezvous hashing), which spreads shards evenly and moves few of them
+// when stores change.
+func placement(stores []afero.Fs, hash string) []afero.Fs {
+	type ranked struct {
+		fs    afero.Fs
+		score uint64
+	}
+	rank := make([]ranked, len(stores))
+	for i, fs := range stores {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s/%d", hash, i)
+		rank[i] = ranked{fs: fs, score: h.Sum64()}
+	}
+	slices.SortStableFunc(rank, func(a, b ranked) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	order := make([]afero.Fs, len(rank))
+	for i, r := range rank {
+		order[i] = r.fs
+	}
+	return order
+}
+
+// order returns the stores in the order name is looked up.
+func (r *replicatedFs) order(name string) []afero.Fs {
+	if hash := shardOf(name); hash != "" {
+		return placement(r.stores, hash)
+	}
+	return r.stores
+}
+
+// first calls fn with the stores of name in order until it succeeds. It
+// returns the first error other than a missing file, if all fail.
+func (r *replicatedFs) first(name string, fn func(afero.Fs) error) error {
+	var firstErr error
+	for _, fs := range r.order(name) {
+		err := fn(fs)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil || os.IsNotExist(firstErr) && !os.IsNotExist(err) {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// allStores calls fn with every store. It succeeds if fn succeeds for one
+// of them and fails for none except with a missing file.
+func allStores(stores []afero.Fs, fn func(afero.Fs) error) error {
+	var notExist error
+	found := false
+	for _, fs := range stores {
+		switch err := fn(fs); {
+		case err == nil:
+			found = true
+		case os.IsNotExist(err):
+			notExist = err
+		default:
+			return err
+		}
+	}
+	if found {
+		return nil
+	}
+	return notExist
+}
+
+// put writes the shard at name to the first n stores of its placement
+// that accept it, failing if fewer do. Later stores of the placement are
+// only used when earlier ones fail, so reads, which follow the same
+// order, find the shard.
+func (r *replicatedFs) put(name, hash string, data []byte) error {
+	stored := 0
+	var errs []error
+	for _, fs := range placement(r.stores, hash) {
+		if stored == r.n {
+			break
+		}
+		if err := r.putOne(fs, name, data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stored++
+	}
+	if stored < r.n {
+		return fmt.Errorf("sbox/sharded: shard %s stored in %d of %d stores: %w", hash, stored, r.n, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (r *replicatedFs) putOne(fs afero.Fs, name string, data []byte) error {
+	if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && r.engine.syncs() {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *replicatedFs) Name() string { return "sharded-replicated" }
+
+func (r *replicatedFs) Create(name string) (afero.File, error) {
+	return r.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (r *replicatedFs) Mkdir(name string, perm os.FileMode) error {
+	return r.MkdirAll(name, perm)
+}
+
+// MkdirAll creates the directory in every store, since the shards below a
+// hash directory may be placed in any of them.
+func (r *replicatedFs) MkdirAll(path string, perm os.FileMode) error {
+	for _, fs := range r.stores {
+		if err := fs.MkdirAll(path, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open opens name in the first store that has it. A directory lists the
+// entries of all stores.
+func (r *replicatedFs) Open(name string) (afero.File, error) {
+	var f afero.File
+	var from afero.Fs
+	err := r.first(name, func(fs afero.Fs) error {
+		var err error
+		f, err = fs.Open(name)
+		from = fs
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return &unionDir{File: f, more: func() ([]os.FileInfo, error) {
+			var more []os.FileInfo
+			seen := make(map[string]bool)
+			for _, fs := range r.stores {
+				if fs == from {
+					continue
+				}
+				entries, err := afero.ReadDir(fs, name)
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				for _, info := range entries {
+					if !seen[info.Name()] {
+						seen[info.Name()] = true
+						more = append(more, info)
+					}
+				}
+			}
+			return more, nil
+		}}, nil
+	}
+	return f, nil
+}
+
+// OpenFile opens shards for writing as a buffer stored in their stores on
+// Close; other files are written to the first store.
+func (r *replicatedFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return r.Open(name)
+	}
+	hash := shardOf(name)
+	if hash == "" {
+		return r.stores[0].OpenFile(name, flag, perm)
+	}
+	if flag&os.O_EXCL != 0 {
+		if _, err := r.Stat(name); err == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+	}
+	return &shardWriter{name: name, hash: hash, put: r.put}, nil
+}
+
+// Remove removes name from every store that has it.
+func (r *replicatedFs) Remove(name string) error {
+	return allStores(r.stores, func(fs afero.Fs) error { return fs.Remove(name) })
+}
+
+func (r *replicatedFs) RemoveAll(path string) error {
+	for _, fs := range r.stores {
+		if err := fs.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename renames within the first store, except to a shard path, as when
+// a shard is promoted: the file is then stored as a shard, and removed.
+func (r *replicatedFs) Rename(oldname, newname string) error {
+	hash := shardOf(newname)
+	if hash == "" {
+		return r.stores[0].Rename(oldname, newname)
+	}
+	data, err := afero.ReadFile(r, oldname)
+	if err != nil {
+		return err
+	}
+	if err := r.put(newname, hash, data); err != nil {
+		return err
+	}
+	return r.Remove(oldname)
+}
+
+func (r *replicatedFs) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := r.first(name, func(fs afero.Fs) error {
+		var err error
+		info, err = fs.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+func (r *replicatedFs) Chmod(name string, mode os.FileMode) error {
+	return allStores(r.stores, func(fs afero.Fs) error { return fs.Chmod(name, mode) })
+}
+
+func (r *replicatedFs) Chown(name string, uid, gid int) error {
+	return allStores(r.stores, func(fs afero.Fs) error { return fs.Chown(name, uid, gid) })
+}
+
+func (r *replicatedFs) Chtimes(name string, atime, mtime time.Time) error {
+	return allStores(r.stores, func(fs afero.Fs) error { return fs.Chtimes(name, atime, mtime) })
+}
+
+// shardWriter buffers a shard and passes it to put on Close, for stores
+// that write every shard in one piece.
+type shardWriter struct {
+	name   string
+	hash   string
+	put    func(name, hash string, data []byte) error
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *shardWriter) Name() string { return w.name }
+
+func (w *shardWriter) Stat() (os.FileInfo, error) {
+	return packedInfo{name: w.hash, entry: packEntry{length: int64(w.buf.Len()), modTime: time.Now().Unix()}}, nil
+}
+
+func (w *shardWriter) Write(p []byte) (int, error)        { return w.buf.Write(p) }
+func (w *shardWriter) WriteString(s string) (int, error)  { return w.buf.WriteString(s) }
+func (w *shardWriter) Read([]byte) (int, error)           { return 0, os.ErrPermission }
+func (w *shardWriter) ReadAt([]byte, int64) (int, error)  { return 0, os.ErrPermission }
+func (w *shardWriter) WriteAt([]byte, int64) (int, error) { return 0, sbox.ErrNotSupported }
+func (w *shardWriter) Truncate(int64) error               { return sbox.ErrNotSupported }
+func (w *shardWriter) Readdir(int) ([]os.FileInfo, error) { return nil, syscall.ENOTDIR }
+func (w *shardWriter) Readdirnames(int) ([]string, error) { return nil, syscall.ENOTDIR }
+
+// Sync does nothing: the shard is written, and synced, by put.
+func (w *shardWriter) Sync() error { return nil }
+
+// Seek only reports the offset, which is the end.
+func (w *shardWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence == io.SeekStart && w.buf.Len() != 0 {
+		return 0, sbox.ErrNotSupported
+	}
+	return int64(w.buf.Len()), nil
+}
+
+func (w *shardWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	return w.put(w.name, w.hash, w.buf.Bytes())
+}
+
+// Compile-time interface checks.
+var (
+	_ afero.Fs   = (*replicatedFs)(nil)
+	_ afero.File = (*shardWriter)(nil)
+)
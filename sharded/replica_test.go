@@ -0,0 +1,83 @@
+package sharded_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestReplicas_Suite(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+			sharded.WithReplicas(2, afero.NewMemMapFs(), afero.NewMemMapFs()))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+	t.Run("packing+refcount", func(t *testing.T) {
+		engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+			sharded.WithReplicas(2, afero.NewMemMapFs()), sharded.WithPacking(0, 64), sharded.WithRefcount(true))
+		sboxtest.StorageTestSuite(t, engine)
+	})
+}
+
+func TestReplicas(t *testing.T) {
+	ctx := context.Background()
+	stores := []afero.Fs{afero.NewMemMapFs(), afero.NewMemMapFs(), afero.NewMemMapFs()}
+	engine := sharded.New(afero.NewMemMapFs(), stores[0], 8,
+		sharded.WithReplicas(2, stores[1:]...), sharded.WithVerifyOnRead(true))
+	content := "aaaaaaaabbbbbbbbccccccccdddddddd"
+	writeFile(t, engine, "a.txt", content)
+
+	// Each of the four shards is in exactly two stores.
+	for _, chunk := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc", "dddddddd"} {
+		copies := 0
+		for _, fs := range stores {
+			if exists, _ := afero.Exists(fs, sbox.HashPath(sha256Hex(chunk))); exists {
+				copies++
+			}
+		}
+		if copies != 2 {
+			t.Errorf("shard of %q has %d copies, want 2", chunk, copies)
+		}
+	}
+
+	// Reads fail over when a store loses its shards, or holds a corrupt
+	// copy, which is repaired.
+	if err := stores[0].RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	p := sbox.HashPath(sha256Hex("aaaaaaaa"))
+	for _, fs := range stores[1:] {
+		if exists, _ := afero.Exists(fs, p); exists {
+			if err := afero.WriteFile(fs, p, []byte("corrupt!"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if got := readFile(t, engine, "a.txt"); got != content {
+		t.Errorf("a.txt after losing a store = %q", got)
+	}
+	if report, err := engine.Verify(ctx, sharded.VerifyOptions{}); err != nil || !report.OK() {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+
+	// GC removes every copy.
+	if err := engine.Remove(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := engine.GC(ctx); err != nil || stats.Deleted != 4 {
+		t.Errorf("GC = %+v, %v", stats, err)
+	}
+	for i, fs := range stores {
+		var n int
+		countShards(t, fs, "", &n)
+		if n != 0 {
+			t.Errorf("store %d holds %d files after GC", i, n)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// scrubCursorPath is the location of the scrubber's progress inside the
+// shard store. It holds one line: "<last shard> <shards in last pass>
+// <shards seen in this pass>".
+const scrubCursorPath = "scrub.cursor"
+
+const (
+	// DefaultScrubFraction verifies the whole store about every four days.
+	DefaultScrubFraction = 0.01
+
+	// DefaultScrubInterval is how often a Scrubber verifies a batch.
+	DefaultScrubInterval = time.Minute
+)
+
+// errScrubBatchDone stops the shard walk once a batch is complete.
+var errScrubBatchDone = errors.New("scrub batch done")
+
+// ScrubOptions configures a Scrubber.
+type ScrubOptions struct {
+	// Fraction of all shards verified per hour. Defaults to
+	// DefaultScrubFraction.
+	Fraction float64
+
+	// Interval between batches. Defaults to DefaultScrubInterval.
+	Interval time.Duration
+
+	// OnBatch, if set, is called after every batch, e.g. to update metrics
+	// or alert on corrupt shards.
+	OnBatch func(report ScrubReport)
+}
+
+// ScrubReport describes one batch of a Scrubber.
+type ScrubReport struct {
+	Checked int      // Shards verified
+	Bytes   int64    // Bytes read
+	Corrupt []string // Shards whose content does not match their hash
+
+	// Cursor is the last shard verified; shards are visited in hash order.
+	// PassComplete is set when the batch reached the end of the store, in
+	// which case the next batch starts over.
+	Cursor       string
+	PassComplete bool
+
+	// Err is set when the batch stopped early.
+	Err error
+}
+
+// Scrubber verifies shards in the background, a few at a time, so that
+// silent corruption is found long before the data is needed. Progress is
+// persisted in the shard store, so a restarted scrubber resumes where the
+// previous one stopped.
+//
+// The scrubber only detects damage; run Verify with a replica to repair it
+// and to find the affected files.
+type Scrubber struct {
+	engine *Engine
+	opts   ScrubOptions
+	budget float64 // Fractional shards carried over between batches
+}
+
+// NewScrubber returns a Scrubber for the engine. Call Run to start it.
+func (e *Engine) NewScrubber(opts ScrubOptions) *Scrubber {
+	if opts.Fraction <= 0 {
+		opts.Fraction = DefaultScrubFraction
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultScrubInterval
+	}
+	return &Scrubber{engine: e, opts: opts}
+}
+
+// Run verifies a batch every interval until ctx is cancelled or the engine
+// is closed. Batch errors are reported through OnBatch and do not stop the
+// scrubber.
+func (s *Scrubber) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if s.engine.closed.Load() {
+			return sbox.ErrClosed
+		}
+
+		report := &ScrubReport{}
+		n, err := s.batchSize()
+		if err == nil && n > 0 {
+			var r *ScrubReport
+			if r, err = s.Step(ctx, n); r != nil {
+				report = r
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		report.Err = err
+		if s.opts.OnBatch != nil && (report.Checked > 0 || report.Err != nil) {
+			s.opts.OnBatch(*report)
+		}
+	}
+}
+
+// batchSize returns how many shards the next batch verifies.
+func (s *Scrubber) batchSize() (int, error) {
+	c, err := s.engine.readScrubCursor()
+	if err != nil {
+		return 0, err
+	}
+	total := c.total
+	if total == 0 {
+		// First pass: count the shards once to pace it.
+		if total, err = s.engine.countShards(); err != nil {
+			return 0, err
+		}
+	}
+	s.budget += float64(total) * s.opts.Fraction * s.opts.Interval.Hours()
+	n := math.Floor(s.budget)
+	s.budget -= n
+	return int(n), nil
+}
+
+// Step verifies up to n shards after the persisted cursor and advances it.
+func (s *Scrubber) Step(ctx context.Context, n int) (*ScrubReport, error) {
+	e := s.engine
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	c, err := e.readScrubCursor()
+	if err != nil {
+		return nil, err
+	}
+	report := &ScrubReport{Cursor: c.last}
+
+	err = e.walkShards(e.shardsFs, c.last, func(p, hash string) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if report.Checked >= n {
+			return errScrubBatchDone
+		}
+		data, err := afero.ReadFile(e.shardsFs, p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed by GC since the walk listed it.
+			}
+			return err
+		}
+		// Any stored form is accepted: plain, compressed or encrypted.
+		if _, ok := verifyShard(data, hash, true); !ok {
+			e.logger.Error("sbox/sharded: scrub found corrupt shard", "hash", hash)
+			report.Corrupt = append(report.Corrupt, hash)
+		}
+		report.Checked++
+		report.Bytes += int64(len(data))
+		report.Cursor = hash
+		return nil
+	})
+	c.last, c.seen = report.Cursor, c.seen+report.Checked
+	if err == nil {
+		report.PassComplete = true
+		c = scrubCursor{total: c.seen}
+	} else if errors.Is(err, errScrubBatchDone) {
+		err = nil
+	}
+	if werr := e.writeScrubCursor(c); err == nil {
+		err = werr
+	}
+	return report, err
+}
+
+// walkShards calls fn for every shard of fs whose hash sorts after
+// `after`, in hash order. Directories that hold only earlier shards are
+// skipped.
+func (e *Engine) walkShards(fs afero.Fs, after string, fn func(p, hash string) error) error {
+	return afero.Walk(fs, "", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if p == "manifests" || p == snapshotsDir || p == versionsDir || p == txsDir || p == uploadsDir {
+				return filepath.SkipDir
+			}
+			prefix := strings.ReplaceAll(filepath.ToSlash(p), "/", "")
+			if after != "" && prefix != "" && prefix < after[:min(len(prefix), len(after))] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := info.Name()
+		if !isShardName(name) || name <= after || filepath.ToSlash(p) != filepath.ToSlash(e.shardPath(name)) {
+			return nil
+		}
+		return fn(p, name)
+	})
+}
+
+// countShards returns the number of shards in the store.
+func (e *Engine) countShards() (int, error) {
+	var n int
+	err := e.walkShards(e.shardsFs, "", func(string, string) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+type scrubCursor struct {
+	last  string // Last shard verified in this pass
+	total int    // Shards in the last complete pass; 0 if unknown
+	seen  int    // Shards verified in this pass
+}
+
+func (e *Engine) readScrubCursor() (scrubCursor, error) {
+	var c scrubCursor
+	data, err := afero.ReadFile(e.shardsFs, scrubCursorPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return c, err
+	}
+	if _, err := fmt.Sscanf(string(data), "%s %d %d", &c.last, &c.total, &c.seen); err != nil {
+		e.logger.Warn("sbox/sharded: ignoring malformed scrub cursor", "error", err)
+		return scrubCursor{}, nil
+	}
+	if c.last == "-" {
+		c.last = ""
+	}
+	return c, nil
+}
+
+func (e *Engine) writeScrubCursor(c scrubCursor) error {
+	last := c.last
+	if last == "" {
+		last = "-"
+	}
+	tmp := scrubCursorPath + ".tmp"
+	if err := afero.WriteFile(e.shardsFs, tmp, fmt.Appendf(nil, "%s %d %d\n", last, c.total, c.seen), 0644); err != nil {
+		return err
+	}
+	return e.shardsFs.Rename(tmp, scrubCursorPath)
+}
@@ -0,0 +1,81 @@
+package sharded_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestScrubber_Step(t *testing.T) {
+	ctx := context.Background()
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 16, quietLogger)
+
+	var content strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&content, "chunk number %02d\n", i)
+	}
+	writeFile(t, engine, "f.txt", content.String())
+	_ = afero.WriteFile(shardsFs, contentShardPath("chunk number 03\n"), []byte("garbage"), 0644)
+
+	// Each scrubber resumes from the persisted cursor.
+	var checked int
+	var corrupt []string
+	for i := 0; ; i++ {
+		report, err := engine.NewScrubber(sharded.ScrubOptions{}).Step(ctx, 3)
+		if err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+		checked += report.Checked
+		corrupt = append(corrupt, report.Corrupt...)
+		if report.PassComplete {
+			break
+		}
+		if i > 10 {
+			t.Fatal("pass never completed")
+		}
+	}
+	if checked != 10 || len(corrupt) != 1 {
+		t.Errorf("checked %d shards, corrupt %v; want 10 and one", checked, corrupt)
+	}
+
+	// The next pass starts over.
+	report, err := engine.NewScrubber(sharded.ScrubOptions{}).Step(ctx, 100)
+	if err != nil || report.Checked != 10 || !report.PassComplete {
+		t.Errorf("second pass = %+v, %v", report, err)
+	}
+}
+
+func TestScrubber_Run(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16, quietLogger)
+	writeFile(t, engine, "f.txt", strings.Repeat("0123456789abcdef", 4)+"unique tail")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reports := make(chan sharded.ScrubReport, 100)
+	scrubber := engine.NewScrubber(sharded.ScrubOptions{
+		Fraction: 3600, // Every shard every second.
+		Interval: 10 * time.Millisecond,
+		OnBatch:  func(r sharded.ScrubReport) { reports <- r },
+	})
+	go func() { _ = scrubber.Run(ctx) }()
+
+	var checked int
+	for checked < 2 {
+		select {
+		case r := <-reports:
+			if r.Err != nil || len(r.Corrupt) > 0 {
+				t.Fatalf("batch %+v", r)
+			}
+			checked += r.Checked
+		case <-ctx.Done():
+			t.Fatalf("scrubber checked %d shards before timing out", checked)
+		}
+	}
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/spf13/afero"
 
 	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/lockfile"
 )
 
 // DefaultChunkSize is the default chunk size (4MB).
@@ -32,6 +39,12 @@ func init() {
 				chunkSize = n
 			case float64:
 				chunkSize = int64(n)
+			case string:
+				parsed, err := strconv.ParseInt(n, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("sbox/sharded: invalid chunkSize %q: %w", n, err)
+				}
+				chunkSize = parsed
 			}
 		}
 
@@ -54,34 +67,312 @@ func init() {
 			}
 		}
 
-		// Ensure directories exist
-		if err := os.MkdirAll(manifestPath, 0750); err != nil {
+		var opts []Option
+		if optBool(cfg.Options, "verifyOnRead") {
+			opts = append(opts, WithVerifyOnRead(true))
+		}
+		if optBool(cfg.Options, "refcount") {
+			opts = append(opts, WithRefcount(true))
+		}
+		if optBool(cfg.Options, "journal") {
+			opts = append(opts, WithJournal(true))
+		}
+		if optBool(cfg.Options, "sparse") {
+			opts = append(opts, WithSparseFiles(true))
+		}
+		if s := optString(cfg.Options, "durability"); s != "" {
+			d, err := sbox.ParseDurability(s)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithDurability(d))
+		}
+		switch c := Compression(optString(cfg.Options, "compression")); c {
+		case "", CompressionNone:
+		case CompressionZstd, CompressionGzip:
+			opts = append(opts, WithCompression(c))
+		default:
+			return nil, fmt.Errorf("sbox/sharded: unknown compression %q", c)
+		}
+		if mode := EncryptionMode(optString(cfg.Options, "encryption")); mode != "" {
+			key, err := hex.DecodeString(optString(cfg.Options, "encryptionKey"))
+			if err != nil {
+				return nil, fmt.Errorf("sbox/sharded: invalid encryptionKey: %w", err)
+			}
+			if _, err := newEncryptor(mode, key); err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithEncryption(mode, key))
+		}
+		switch enc := sbox.ManifestEncoding(optString(cfg.Options, "manifestEncoding")); enc {
+		case "", sbox.ManifestJSON:
+		case sbox.ManifestBinary:
+			opts = append(opts, WithManifestEncoding(enc))
+		default:
+			return nil, fmt.Errorf("sbox/sharded: unknown manifestEncoding %q", enc)
+		}
+		switch c := Chunking(optString(cfg.Options, "chunking")); c {
+		case "", ChunkingFixed:
+		case ChunkingCDC:
+			opts = append(opts, WithChunking(c))
+		default:
+			return nil, fmt.Errorf("sbox/sharded: unknown chunking %q", c)
+		}
+		if n, ok, err := optInt(cfg.Options, "versions"); err != nil {
+			return nil, err
+		} else if ok {
+			opts = append(opts, WithVersions(n))
+		}
+		if n, ok, err := optInt(cfg.Options, "writeConcurrency"); err != nil {
+			return nil, err
+		} else if ok {
+			opts = append(opts, WithWriteConcurrency(n))
+		}
+		if optBool(cfg.Options, "packing") {
+			threshold, _, err := optInt(cfg.Options, "packThreshold")
+			if err != nil {
+				return nil, err
+			}
+			size, _, err := optInt(cfg.Options, "packSize")
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithPacking(int64(threshold), int64(size)))
+		}
+		if n, ok, err := optInt(cfg.Options, "readAhead"); err != nil {
+			return nil, err
+		} else if ok {
+			opts = append(opts, WithReadAhead(n))
+		}
+		if n, ok, err := optInt(cfg.Options, "minFreeSpace"); err != nil {
+			return nil, err
+		} else if ok {
+			opts = append(opts, WithMinFreeSpace(shardsPath, int64(n)))
+		}
+		if s := optString(cfg.Options, "pollInterval"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/sharded: invalid pollInterval %q: %w", s, err)
+			}
+			opts = append(opts, WithPollInterval(d))
+		}
+		if n, ok, err := optInt(cfg.Options, "manifestCache"); err != nil {
+			return nil, err
+		} else if ok {
+			var ttl time.Duration
+			if s := optString(cfg.Options, "manifestCacheTTL"); s != "" {
+				if ttl, err = time.ParseDuration(s); err != nil {
+					return nil, fmt.Errorf("sbox/sharded: invalid manifestCacheTTL %q: %w", s, err)
+				}
+			}
+			opts = append(opts, WithManifestCache(n, ttl))
+		}
+		if optBool(cfg.Options, "readRepair") {
+			var sources []afero.Fs
+			for _, dir := range optStrings(cfg.Options, "repairShardsDirs") {
+				sources = append(sources, afero.NewBasePathFs(afero.NewOsFs(), dir))
+			}
+			opts = append(opts, WithReadRepair(sources...))
+		}
+
+		if n, ok, err := optInt(cfg.Options, "replicas"); err != nil {
 			return nil, err
+		} else if dirs := optStrings(cfg.Options, "replicaShardsDirs"); ok || len(dirs) > 0 {
+			var stores []afero.Fs
+			for _, dir := range dirs {
+				if err := os.MkdirAll(dir, 0750); err != nil {
+					return nil, err
+				}
+				stores = append(stores, afero.NewBasePathFs(afero.NewOsFs(), dir))
+			}
+			opts = append(opts, WithReplicas(n, stores...))
 		}
-		if err := os.MkdirAll(shardsPath, 0750); err != nil {
+
+		if data, ok, err := optInt(cfg.Options, "erasureData"); err != nil {
 			return nil, err
+		} else if parity, pok, err := optInt(cfg.Options, "erasureParity"); err != nil {
+			return nil, err
+		} else if dirs := optStrings(cfg.Options, "erasureShardsDirs"); ok || pok || len(dirs) > 0 {
+			var stores []afero.Fs
+			for _, dir := range dirs {
+				if err := os.MkdirAll(dir, 0750); err != nil {
+					return nil, err
+				}
+				stores = append(stores, afero.NewBasePathFs(afero.NewOsFs(), dir))
+			}
+			opts = append(opts, WithErasureCoding(data, parity, stores...))
 		}
 
-		manifestFs := afero.NewBasePathFs(afero.NewOsFs(), manifestPath)
-		shardsFs := afero.NewBasePathFs(afero.NewOsFs(), shardsPath)
+		policy := TierPolicy{Promote: optBool(cfg.Options, "promote")}
+		if s := optString(cfg.Options, "demoteAfter"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("sbox/sharded: invalid demoteAfter %q: %w", s, err)
+			}
+			policy.DemoteAfter = d
+		}
 
-		return New(manifestFs, shardsFs, chunkSize), nil
+		// Stores last, so that invalid options leave no engine open.
+		var owned []sbox.StorageEngine
+		store := func(key, dir string) (afero.Fs, error) {
+			fs, engine, err := openStore(cfg.Options, key, dir)
+			if err != nil {
+				for _, e := range owned {
+					_ = sbox.Close(e)
+				}
+				return nil, err
+			}
+			if engine != nil {
+				owned = append(owned, engine)
+			}
+			return fs, nil
+		}
+		manifestFs, err := store("manifestURL", manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		shardsFs, err := store("shardsURL", shardsPath)
+		if err != nil {
+			return nil, err
+		}
+		if optString(cfg.Options, "coldShardsURL") != "" || optString(cfg.Options, "coldShardsDir") != "" {
+			coldFs, err := store("coldShardsURL", optString(cfg.Options, "coldShardsDir"))
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithColdShards(coldFs, policy))
+		}
+		opts = append(opts, closing(owned...))
+
+		return New(manifestFs, shardsFs, chunkSize, opts...), nil
 	})
 }
 
+// openStore returns a store on the engine of the DSN in option key, if
+// set, or on the OS directory dir, which is created if needed. The engine
+// is returned so that the sharded Engine can close it.
+func openStore(opts map[string]any, key, dir string) (afero.Fs, sbox.StorageEngine, error) {
+	if dsn := optString(opts, key); dsn != "" {
+		engine, err := sbox.OpenURL(dsn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sbox/sharded: %s: %w", key, err)
+		}
+		return EngineFs(engine), engine, nil
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, nil, err
+	}
+	return afero.NewBasePathFs(afero.NewOsFs(), dir), nil, nil
+}
+
+// optBool reads a boolean driver option.
+func optBool(opts map[string]any, key string) bool {
+	switch v := opts[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true" || v == "1"
+	}
+	return false
+}
+
+// optString reads a string driver option.
+func optString(opts map[string]any, key string) string {
+	s, _ := opts[key].(string)
+	return s
+}
+
+// optInt reads an integer driver option. It reports false if the option is
+// not set.
+func optInt(opts map[string]any, key string) (int, bool, error) {
+	switch v := opts[key].(type) {
+	case int:
+		return v, true, nil
+	case int64:
+		return int(v), true, nil
+	case float64:
+		return int(v), true, nil
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, false, fmt.Errorf("sbox/sharded: invalid %s %q: %w", key, v, err)
+		}
+		return n, true, nil
+	}
+	return 0, false, nil
+}
+
+// optStrings reads a driver option holding one or more strings.
+func optStrings(opts map[string]any, key string) []string {
+	switch v := opts[key].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
 // Engine implements sbox.StorageEngine using content-addressed chunked storage.
 type Engine struct {
-	manifestFs afero.Fs
-	shardsFs   afero.Fs
-	chunkSize  int64
-	bufferPool *sync.Pool
+	manifestFs  afero.Fs
+	shardsFs    afero.Fs
+	chunkSize   int64
+	chunking    Chunking
+	cdc         *cdc // nil with fixed-size chunking
+	compression Compression
+	encryptor   *encryptor // nil without encryption
+	sparse      bool       // Store zero chunks as holes
+	bufferPool  *sync.Pool
+
+	verifyOnRead     bool
+	readAhead        int
+	writeConcurrency int
+	repairSources    []afero.Fs
+	logger           *slog.Logger
+
+	versions   int
+	journal    bool
+	durability sbox.Durability
+	space      *sbox.SpaceGuard // nil without WithMinFreeSpace
+	poll       time.Duration    // Watch poll interval
+
+	manifestEncoding sbox.ManifestEncoding
+
+	packThreshold int64 // Zero without WithPacking
+	packSize      int64
+	packs         []*packStore // One per shard store with packing
+	replicas      int
+	replicaStores []afero.Fs
+	erasureData   int
+	erasureParity int // Zero without WithErasureCoding
+	erasureStores []afero.Fs
+	erasure       *erasureFs           // nil without WithErasureCoding
+	manifests     *manifestCache       // nil without WithManifestCache
+	tier          *tiering             // nil without WithColdShards
+	owned         []sbox.StorageEngine // Engines under the stores, closed by Close
+
+	refcount bool
+	refsOnce sync.Once
+	refIdx   *refIndex
+	refsErr  error
+
+	closed atomic.Bool
 }
 
 // New creates a new sharded Engine.
 // manifestFs stores manifest JSON files (mirroring logical paths),
 // shardsFs stores chunk blobs (content-addressed via HashPath).
 // They can share the same filesystem or be separate (e.g., for cross-user dedup).
-func New(manifestFs, shardsFs afero.Fs, chunkSize int64) *Engine {
+func New(manifestFs, shardsFs afero.Fs, chunkSize int64, opts ...Option) *Engine {
 	if chunkSize <= 0 {
 		chunkSize = DefaultChunkSize
 	}
@@ -89,6 +380,49 @@ func New(manifestFs, shardsFs afero.Fs, chunkSize int64) *Engine {
 		manifestFs: manifestFs,
 		shardsFs:   shardsFs,
 		chunkSize:  chunkSize,
+		logger:     slog.Default(),
+		poll:       sbox.DefaultPollInterval,
+
+		manifestEncoding: sbox.ManifestJSON,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.manifests != nil {
+		e.manifestFs = &manifestCacheFs{Fs: manifestFs, cache: e.manifests}
+	}
+	if e.chunking == ChunkingCDC {
+		e.cdc = newCDC(e.chunkSize)
+	}
+	stores := append([]afero.Fs{shardsFs}, e.replicaStores...)
+	if e.erasureParity > 0 {
+		stores = append([]afero.Fs{shardsFs}, e.erasureStores...)
+	}
+	if e.packThreshold > 0 {
+		for i, fs := range stores {
+			p := &packStore{fs: fs, engine: e, threshold: e.packThreshold, packSize: e.packSize}
+			e.packs = append(e.packs, p)
+			stores[i] = p
+		}
+	}
+	e.shardsFs = stores[0]
+	if e.erasureParity > 0 {
+		if e.erasureData <= 0 {
+			e.erasureData = max(len(stores)-e.erasureParity, 1)
+		}
+		e.erasureData = min(e.erasureData, 256-e.erasureParity)
+		e.erasure = &erasureFs{stores: stores, code: newRSCode(e.erasureData, e.erasureParity), engine: e}
+		e.shardsFs = e.erasure
+	} else if len(stores) > 1 {
+		if e.replicas <= 0 || e.replicas > len(stores) {
+			e.replicas = len(stores)
+		}
+		e.shardsFs = &replicatedFs{stores: stores, n: e.replicas, engine: e}
+		e.repairSources = append(e.repairSources, stores...)
+	}
+	if e.tier != nil {
+		e.tier.hot = e.shardsFs
+		e.shardsFs = &tieredFs{hot: e.tier.hot, cold: e.tier.cold}
 	}
 	e.bufferPool = &sync.Pool{
 		New: func() interface{} {
@@ -101,7 +435,9 @@ func New(manifestFs, shardsFs afero.Fs, chunkSize int64) *Engine {
 
 // cleanPath normalizes a logical path for manifest storage.
 func cleanPath(p string) string {
-	clean := filepath.Clean(p)
+	// Rooting the path before cleaning drops ".." elements that would
+	// otherwise name manifests outside the metadata directory.
+	clean := filepath.Clean("/" + p)
 	clean = filepath.ToSlash(clean)
 	clean = strings.TrimPrefix(clean, "/")
 	if clean == "" || clean == "." {
@@ -134,8 +470,37 @@ func (e *Engine) shardPath(hash string) string {
 	return sbox.HashPath(hash)
 }
 
-// Stat returns information about a logical file or directory.
-func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+// wrapErr makes *err an *sbox.PathError of the sharded driver.
+func wrapErr(op, path string, err *error) {
+	*err = sbox.NewPathError("sharded", op, path, *err)
+}
+
+// Stat returns information about a logical file or directory, following
+// symbolic links.
+func (e *Engine) Stat(ctx context.Context, path string) (_ *sbox.EntryInfo, err error) {
+	defer wrapErr("stat", path, &err)
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	target := path
+	for range maxLinks {
+		info, err := e.lstat(target)
+		if err != nil {
+			return nil, err
+		}
+		if info.LinkTarget == "" {
+			if target != path {
+				info.Name, info.Path = filepath.Base(cleanPath(path)), path
+			}
+			return info, nil
+		}
+		target = linkPath(target, info.LinkTarget)
+	}
+	return nil, linkLoopError(path)
+}
+
+// lstat returns information about a logical file, directory or link.
+func (e *Engine) lstat(path string) (*sbox.EntryInfo, error) {
 	p := cleanPath(path)
 	if p == "" {
 		return &sbox.EntryInfo{
@@ -147,19 +512,12 @@ func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error)
 
 	// Try as file (load manifest)
 	mPath := e.manifestPath(path)
-	data, err := afero.ReadFile(e.manifestFs, mPath)
+	m, err := e.loadManifest(mPath)
 	if err == nil {
-		var m sbox.Manifest
-		if unmarshalErr := json.Unmarshal(data, &m); unmarshalErr != nil {
-			return nil, unmarshalErr
-		}
-		return &sbox.EntryInfo{
-			Name:    filepath.Base(p),
-			Size:    m.Size,
-			ModTime: m.ModTime,
-			IsDir:   false,
-			Path:    path,
-		}, nil
+		return manifestEntry(filepath.Base(p), path, &m), nil
+	}
+	if _, serr := e.manifestFs.Stat(mPath); serr == nil {
+		return nil, err // Invalid manifest
 	}
 
 	// Try as directory
@@ -177,27 +535,74 @@ func (e *Engine) Stat(ctx context.Context, path string) (*sbox.EntryInfo, error)
 	return nil, os.ErrNotExist
 }
 
+// manifestEntry describes the file or link with manifest m.
+func manifestEntry(name, path string, m *sbox.Manifest) *sbox.EntryInfo {
+	entry := &sbox.EntryInfo{
+		Name:     name,
+		Size:     m.Size,
+		ModTime:  m.ModTime,
+		Path:     path,
+		Metadata: maps.Clone(m.Metadata),
+		Mode:     m.Mode,
+		Uid:      m.Uid,
+		Gid:      m.Gid,
+
+		ContentType: m.ContentType,
+	}
+	if m.LinkTarget != "" {
+		entry.Size = int64(len(m.LinkTarget))
+		entry.Mode |= os.ModeSymlink
+		entry.LinkTarget = m.LinkTarget
+	}
+	return entry
+}
+
 // Open returns a reader that transparently stitches shards together.
-func (e *Engine) Open(ctx context.Context, path string) (sbox.ReadSeekCloser, error) {
-	mPath := e.manifestPath(path)
-	data, err := afero.ReadFile(e.manifestFs, mPath)
-	if err != nil {
-		return nil, err
+func (e *Engine) Open(ctx context.Context, path string) (_ sbox.ReadSeekCloser, err error) {
+	defer wrapErr("open", path, &err)
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
 	}
-	var m sbox.Manifest
-	if err := json.Unmarshal(data, &m); err != nil {
-		return nil, err
+	target := path
+	for range maxLinks {
+		m, err := e.loadManifest(e.manifestPath(target))
+		if err != nil {
+			return nil, err
+		}
+		if m.LinkTarget == "" {
+			return newShardedReader(ctx, e, m), nil
+		}
+		target = linkPath(target, m.LinkTarget)
 	}
-	return newShardedReader(e, m), nil
+	return nil, linkLoopError(path)
 }
 
 // Create creates or overwrites a file for writing.
-func (e *Engine) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+func (e *Engine) Create(ctx context.Context, path string) (_ sbox.WriteCloser, err error) {
+	defer wrapErr("create", path, &err)
 	return e.OpenFile(ctx, path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 }
 
-// OpenFile returns a WriteSeekCloser.
-func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (sbox.WriteSeekCloser, error) {
+// OpenFile returns a WriteSeekCloser. With O_RDWR the file supports
+// random access: it can be read, written and truncated at any offset, and
+// only the chunks touched are stored anew on Close. Otherwise writes are
+// sequential, appending to the existing content with O_APPEND.
+func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (_ sbox.WriteSeekCloser, err error) {
+	defer wrapErr("open", path, &err)
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	if cleanPath(path) == "" {
+		return nil, sbox.ErrIsDir
+	}
+	if e.space != nil {
+		if err := e.space.Reserve(path, 0); err != nil {
+			return nil, err
+		}
+	}
+	if flag&os.O_RDWR != 0 {
+		return e.openRandom(ctx, path, flag)
+	}
 	var buf []byte
 	var pb *[]byte
 	if pbi, ok := e.bufferPool.Get().(*[]byte); ok && pbi != nil {
@@ -208,6 +613,7 @@ func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.Fi
 	}
 
 	writer := &shardedWriter{
+		ctx:    ctx,
 		engine: e,
 		path:   path,
 		buffer: buf,
@@ -222,19 +628,27 @@ func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.Fi
 		data, err := afero.ReadFile(e.manifestFs, mPath)
 		if err == nil {
 			var m sbox.Manifest
-			if err := json.Unmarshal(data, &m); err == nil {
-				writer.hashes = m.Chunks
-				writer.chunkSizes = m.ChunkSizes
-				writer.size = m.Size
-
-				// Ensure ChunkSizes is populated for existing fixed-size files
-				if len(writer.chunkSizes) == 0 && len(writer.hashes) > 0 {
-					for i := 0; i < len(writer.hashes)-1; i++ {
-						writer.chunkSizes = append(writer.chunkSizes, e.chunkSize)
-					}
-					lastSize := writer.size - int64(len(writer.hashes)-1)*e.chunkSize
-					writer.chunkSizes = append(writer.chunkSizes, lastSize)
-				}
+			if err := sbox.UnmarshalManifest(data, &m); err != nil {
+				writer.release()
+				return nil, err
+			}
+			writer.hashes = m.Chunks
+			writer.inherited = len(m.Chunks)
+			writer.chunkSizes = e.chunkSizes(&m)
+			writer.size = m.Size
+			writer.metadata = m.Metadata
+			writer.perms = permissionsOf(&m)
+			writer.ctype = m.ContentType
+
+			writer.compressed = m.Compressed
+			writer.storedSizes = m.StoredSizes
+			if len(writer.compressed) == 0 {
+				writer.compressed = make([]bool, len(writer.hashes))
+				writer.storedSizes = slices.Clone(writer.chunkSizes)
+			}
+			writer.keys = m.Keys
+			if len(writer.keys) == 0 {
+				writer.keys = make([]string, len(writer.hashes))
 			}
 		}
 	} else if flag&os.O_CREATE != 0 {
@@ -243,25 +657,109 @@ func (e *Engine) OpenFile(ctx context.Context, path string, flag int, perm os.Fi
 			return nil, err
 		}
 	}
+	if writer.size == 0 {
+		writer.content = sha256.New()
+	}
+	if md := sbox.MetadataFromContext(ctx); md != nil {
+		writer.metadata = md
+	}
+	// Content types are detected from the start of the file, so only for
+	// new content; appends keep the type already stored.
+	opts := sbox.FileOptionsFromContext(ctx)
+	if opts.ContentType != "" {
+		writer.ctype = opts.ContentType
+	} else if opts.DetectContentType && writer.size == 0 {
+		writer.sniffer = sbox.NewContentTypeSniffer(path)
+	}
+	if opts.Checksum != "" && opts.ChecksumAlgorithm == "sha256" {
+		writer.checksum = opts.Checksum
+		if writer.content == nil {
+			writer.check = sha256.New()
+		}
+	}
 
 	return writer, nil
 }
 
+// chunkSizes returns the size of every chunk of m. Manifests of fixed-size
+// files may omit them.
+func (e *Engine) chunkSizes(m *sbox.Manifest) []int64 {
+	if len(m.ChunkSizes) > 0 || len(m.Chunks) == 0 {
+		return m.ChunkSizes
+	}
+	sizes := make([]int64, len(m.Chunks))
+	for i := range sizes {
+		sizes[i] = e.chunkSize
+	}
+	sizes[len(sizes)-1] = m.Size - int64(len(sizes)-1)*e.chunkSize
+	return sizes
+}
+
 // Remove deletes a file or directory.
-func (e *Engine) Remove(ctx context.Context, path string) error {
+func (e *Engine) Remove(ctx context.Context, path string) (err error) {
+	defer wrapErr("remove", path, &err)
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
 	mPath := e.manifestPath(path)
 	exists, _ := afero.Exists(e.manifestFs, mPath)
-	if exists {
+	if exists && cleanPath(path) != "" {
 		// Only remove the manifest. Shards are content-addressed and may be
-		// shared; orphan cleanup should be done separately (GC).
-		return e.manifestFs.Remove(mPath)
+		// shared; orphaned shards are reclaimed by GC, or released right
+		// away when reference counting is enabled.
+		// With versioning, the removed manifest is kept as a version.
+		chunks, err := e.refChunks(e.manifestChunks, mPath)
+		if err != nil {
+			return err
+		}
+		old := e.previousManifest(mPath)
+		if err := e.manifestFs.Remove(mPath); err != nil {
+			return err
+		}
+		release, err := e.supersede(mPath, old, chunks)
+		if rerr := e.adjustRefs(nil, release); err == nil {
+			err = rerr
+		}
+		return err
 	}
 	mDir := e.manifestDirPath(path)
-	return e.manifestFs.RemoveAll(mDir)
+	if cleanPath(path) != "" {
+		if ok, err := afero.DirExists(e.manifestFs, mDir); err != nil {
+			return err
+		} else if !ok {
+			return os.ErrNotExist
+		}
+	}
+	chunks, err := e.refChunks(e.treeChunks, mDir)
+	if err != nil {
+		return err
+	}
+	olds, err := e.previousTree(mDir)
+	if err != nil {
+		return err
+	}
+	if err := e.manifestFs.RemoveAll(mDir); err != nil {
+		return err
+	}
+	if olds == nil {
+		return e.adjustRefs(nil, chunks)
+	}
+	var release []string
+	var errs []error
+	for mPath, old := range olds {
+		r, err := e.supersede(mPath, old, nil)
+		release = append(release, r...)
+		errs = append(errs, err)
+	}
+	return errors.Join(append(errs, e.adjustRefs(nil, release))...)
 }
 
 // Rename moves or renames a file or directory.
-func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
+func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) (err error) {
+	defer wrapErr("rename", oldPath, &err)
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
 	oldM := e.manifestPath(oldPath)
 	newM := e.manifestPath(newPath)
 
@@ -270,7 +768,19 @@ func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
 		if err := e.manifestFs.MkdirAll(filepath.Dir(newM), 0755); err != nil {
 			return err
 		}
-		return e.manifestFs.Rename(oldM, newM)
+		replaced, err := e.refChunks(e.manifestChunks, newM)
+		if err != nil {
+			return err
+		}
+		old := e.previousManifest(newM)
+		if err := e.manifestFs.Rename(oldM, newM); err != nil {
+			return err
+		}
+		release, err := e.supersede(newM, old, replaced)
+		if rerr := e.adjustRefs(nil, release); err == nil {
+			err = rerr
+		}
+		return err
 	}
 
 	oldD := e.manifestDirPath(oldPath)
@@ -282,13 +792,27 @@ func (e *Engine) Rename(ctx context.Context, oldPath, newPath string) error {
 }
 
 // MkdirAll creates a directory (mirrored in manifest filesystem).
-func (e *Engine) MkdirAll(ctx context.Context, path string) error {
+// It returns sbox.ErrNotDir if a file exists at path or at any parent.
+func (e *Engine) MkdirAll(ctx context.Context, path string) (err error) {
+	defer wrapErr("mkdir", path, &err)
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	for p := cleanPath(path); p != ""; p = cleanPath(filepath.Dir(p)) {
+		if exists, _ := afero.Exists(e.manifestFs, e.manifestPath(p)); exists {
+			return sbox.ErrNotDir
+		}
+	}
 	mDir := e.manifestDirPath(path)
 	return e.manifestFs.MkdirAll(mDir, 0755)
 }
 
 // ReadDir returns the contents of a directory.
-func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, error) {
+func (e *Engine) ReadDir(ctx context.Context, path string) (_ []*sbox.EntryInfo, err error) {
+	defer wrapErr("readdir", path, &err)
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
 	mDir := e.manifestDirPath(path)
 	entries, err := afero.ReadDir(e.manifestFs, mDir)
 	if err != nil {
@@ -314,32 +838,113 @@ func (e *Engine) ReadDir(ctx context.Context, path string) ([]*sbox.EntryInfo, e
 			})
 		} else if strings.HasSuffix(name, ".json") {
 			logicalName := strings.TrimSuffix(name, ".json")
-			var size int64
-			var modTime time.Time
-			mData, err := afero.ReadFile(e.manifestFs, filepath.Join(mDir, name))
-			if err == nil {
-				var m sbox.Manifest
-				if err := json.Unmarshal(mData, &m); err == nil {
-					size = m.Size
-					modTime = m.ModTime
-				}
-			}
-			result = append(result, &sbox.EntryInfo{
-				Name:    logicalName,
-				Size:    size,
-				ModTime: modTime,
-				IsDir:   false,
-				Path:    filepath.Join(path, logicalName),
-			})
+			m, _ := e.loadManifest(filepath.Join(mDir, name))
+			result = append(result, manifestEntry(logicalName, filepath.Join(path, logicalName), &m))
 		}
 	}
 	return result, nil
 }
 
+// === Extension: Lister ===
+
+// readdirChunk is the number of manifest directory entries read at a time.
+const readdirChunk = 1024
+
+// List reads the manifest directory in chunks and loads only the manifests
+// of files that end up on the page, unlike ReadDir, which loads them all.
+// Each page rescans the directory.
+func (e *Engine) List(ctx context.Context, path string, opts sbox.ListOptions) (*sbox.ListPage, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	b := sbox.NewPageBuilder(opts)
+	mDir := e.manifestDirPath(path)
+	f, err := e.manifestFs.Open(mDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if cleanPath(path) == "" {
+				return b.Page(), nil
+			}
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		infos, err := f.Readdir(readdirChunk)
+		for _, info := range infos {
+			name := info.Name()
+			switch {
+			case info.IsDir():
+				b.Add(name, func() *sbox.EntryInfo {
+					return &sbox.EntryInfo{
+						Name:    name,
+						ModTime: info.ModTime(),
+						IsDir:   true,
+						Path:    filepath.Join(path, name),
+					}
+				})
+			case strings.HasSuffix(name, ".json"):
+				logicalName := strings.TrimSuffix(name, ".json")
+				b.Add(logicalName, func() *sbox.EntryInfo {
+					m, _ := e.loadManifest(filepath.Join(mDir, name))
+					return manifestEntry(logicalName, filepath.Join(path, logicalName), &m)
+				})
+			}
+		}
+		if err == io.EOF || (err == nil && len(infos) == 0) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Page(), nil
+}
+
+// Close flushes persistent state and releases cached resources. It is
+// idempotent; once closed, all operations return sbox.ErrClosed. Close must
+// not be called while other operations are in flight.
+func (e *Engine) Close() error {
+	if !e.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if e.erasure != nil {
+		e.erasure.heals.Wait()
+	}
+	var err error
+	if e.refIdx != nil {
+		err = e.refIdx.close()
+	}
+	for _, p := range e.packs {
+		if perr := p.close(); err == nil {
+			err = perr
+		}
+	}
+	if e.tier != nil {
+		if terr := e.tier.save(); err == nil {
+			err = terr
+		}
+	}
+	for _, engine := range e.owned {
+		if cerr := sbox.Close(engine); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 // === Extension: Copier ===
 
 // Copy copies a file by duplicating only its manifest (zero-copy for shards).
 func (e *Engine) Copy(ctx context.Context, src, dst string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
 	srcM := e.manifestPath(src)
 	dstM := e.manifestPath(dst)
 
@@ -351,14 +956,37 @@ func (e *Engine) Copy(ctx context.Context, src, dst string) error {
 	if err := e.manifestFs.MkdirAll(filepath.Dir(dstM), 0755); err != nil {
 		return err
 	}
-	return afero.WriteFile(e.manifestFs, dstM, data, 0644)
+	added, err := e.refChunks(e.manifestChunks, srcM)
+	if err != nil {
+		return err
+	}
+	replaced, err := e.refChunks(e.manifestChunks, dstM)
+	if err != nil {
+		return err
+	}
+	old := e.previousManifest(dstM)
+	if err := e.writeManifest(dstM, data); err != nil {
+		return err
+	}
+	release, err := e.supersede(dstM, old, replaced)
+	if rerr := e.adjustRefs(added, release); err == nil {
+		err = rerr
+	}
+	return err
 }
 
 // === Extension: Hasher ===
 
 func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (string, error) {
 	if algorithm != "sha256" {
-		return "", fmt.Errorf("sbox/sharded: only sha256 is supported")
+		return "", fmt.Errorf("sbox/sharded: unsupported hash algorithm %q, only sha256: %w", algorithm, sbox.ErrNotSupported)
+	}
+	// Version 2 manifests record the hash of the content they describe.
+	if data, err := afero.ReadFile(e.manifestFs, e.manifestPath(path)); err == nil {
+		var m sbox.Manifest
+		if sbox.UnmarshalManifest(data, &m) == nil && m.Hash != "" {
+			return m.Hash, nil
+		}
 	}
 	r, err := e.Open(ctx, path)
 	if err != nil {
@@ -373,9 +1001,434 @@ func (e *Engine) Hash(ctx context.Context, path string, algorithm string) (strin
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// === Extension: AtomicWriter ===
+
+// CreateAtomic returns a writer whose file becomes visible only on Close.
+// Sharded writes are inherently atomic: shards are immutable and the new
+// manifest replaces the old one with a single rename.
+func (e *Engine) CreateAtomic(ctx context.Context, path string) (sbox.AtomicWriteCloser, error) {
+	w, err := e.OpenFile(ctx, path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return w.(*shardedWriter), nil
+}
+
+// === Extension: Appender ===
+
+// Append adds r to the end of path as new chunks, leaving the existing
+// shards untouched. The manifest is only replaced once r has been read
+// completely, so a failed append leaves the file as it was.
+func (e *Engine) Append(ctx context.Context, path string, r io.Reader) (int64, error) {
+	w, err := e.OpenFile(ctx, path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	sw := w.(*shardedWriter)
+	n, err := io.Copy(sw, r)
+	if err != nil {
+		_ = sw.Abort()
+		return 0, err
+	}
+	return n, sw.Close()
+}
+
+// === Extension: RangeWriter ===
+
+// PutRange writes r at offset in the existing file at path. Only the
+// chunks the range touches are rewritten; the others stay shared.
+func (e *Engine) PutRange(ctx context.Context, path string, offset int64, r io.Reader) (int64, error) {
+	if offset < 0 {
+		return 0, sbox.ErrInvalid
+	}
+	w, err := e.OpenFile(ctx, path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	rw := w.(*randomWriter)
+	if _, err := rw.Seek(offset, io.SeekStart); err != nil {
+		_ = rw.Abort()
+		return 0, err
+	}
+	n, err := io.Copy(rw, r)
+	if err != nil {
+		_ = rw.Abort()
+		return 0, err
+	}
+	return n, rw.Close()
+}
+
+// === Extension: MetadataWriter ===
+
+// SetModTime changes the modification time of a file, recorded in its
+// manifest, or of a directory. Like SetMetadata, it rewrites the manifest in
+// place and does not create a version.
+func (e *Engine) SetModTime(ctx context.Context, path string, t time.Time) error {
+	err := e.updateManifest(path, func(m *sbox.Manifest) { m.ModTime = t })
+	if os.IsNotExist(err) {
+		if info, serr := e.manifestFs.Stat(e.manifestDirPath(path)); serr == nil && info.IsDir() {
+			return e.manifestFs.Chtimes(e.manifestDirPath(path), t, t)
+		}
+	}
+	return err
+}
+
+// SetMetadata replaces the user metadata stored in the manifest of a file.
+func (e *Engine) SetMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	return e.updateManifest(path, func(m *sbox.Manifest) { m.Metadata = maps.Clone(metadata) })
+}
+
+// updateManifest rewrites the manifest of path after applying update. The
+// chunks must not change.
+func (e *Engine) updateManifest(path string, update func(m *sbox.Manifest)) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	mPath := e.manifestPath(path)
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	if err != nil {
+		return err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return err
+	}
+	update(&m)
+	if data, err = e.marshalManifest(&m); err != nil {
+		return err
+	}
+	return e.writeManifest(mPath, data)
+}
+
+// === Extension: PermissionManager ===
+
+// Chmod records the permission bits of mode in the manifest of a file or
+// link. Like metadata, permissions and ownership survive appends and
+// random-access writes, but not overwrites. Directories are not supported.
+func (e *Engine) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	return e.updatePermissions(path, func(m *sbox.Manifest) { m.Mode = mode.Perm() })
+}
+
+// Chown records the owner and group in the manifest of a file or link. The
+// IDs are stored as given; no privileges are required.
+func (e *Engine) Chown(ctx context.Context, path string, uid, gid int) error {
+	return e.updatePermissions(path, func(m *sbox.Manifest) {
+		if uid != -1 {
+			m.Uid = uid
+		}
+		if gid != -1 {
+			m.Gid = gid
+		}
+	})
+}
+
+// updatePermissions is updateManifest, reporting sbox.ErrNotSupported for
+// directories.
+func (e *Engine) updatePermissions(path string, update func(m *sbox.Manifest)) error {
+	err := e.updateManifest(path, update)
+	if os.IsNotExist(err) {
+		if info, serr := e.manifestFs.Stat(e.manifestDirPath(path)); serr == nil && info.IsDir() {
+			return sbox.ErrNotSupported
+		}
+	}
+	return err
+}
+
+// permissions are the mode and ownership recorded in a manifest.
+type permissions struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+func permissionsOf(m *sbox.Manifest) permissions {
+	return permissions{mode: m.Mode, uid: m.Uid, gid: m.Gid}
+}
+
+func (p permissions) apply(m *sbox.Manifest) {
+	m.Mode, m.Uid, m.Gid = p.mode, p.uid, p.gid
+}
+
+// === Extension: Symlinker ===
+
+// maxLinks is the number of symbolic links followed when resolving a path,
+// as on Linux.
+const maxLinks = 40
+
+// Symlink stores a link as a manifest without chunks that records target.
+// Links are only resolved as the last element of a path, and targets
+// cannot escape the engine root. Writing to a link replaces it with a
+// regular file.
+func (e *Engine) Symlink(ctx context.Context, target, path string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	if target == "" || cleanPath(path) == "" {
+		return sbox.ErrInvalid
+	}
+	if _, err := e.lstat(path); err == nil {
+		return sbox.ErrExist
+	}
+	if err := e.MkdirAll(ctx, filepath.Dir(cleanPath(path))); err != nil {
+		return err
+	}
+	data, err := e.marshalManifest(&sbox.Manifest{
+		ModTime:    time.Now(),
+		CreatedBy:  createdBy,
+		LinkTarget: target,
+	})
+	if err != nil {
+		return err
+	}
+	return e.writeManifest(e.manifestPath(path), data)
+}
+
+func (e *Engine) Readlink(ctx context.Context, path string) (string, error) {
+	info, err := e.Lstat(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if info.LinkTarget == "" {
+		return "", sbox.ErrInvalid
+	}
+	return info.LinkTarget, nil
+}
+
+func (e *Engine) Lstat(ctx context.Context, path string) (*sbox.EntryInfo, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	return e.lstat(path)
+}
+
+// linkPath resolves the target of the link at path. Relative targets are
+// relative to the link's directory; ".." never leaves the root.
+func linkPath(path, target string) string {
+	if !strings.HasPrefix(target, "/") {
+		target = filepath.Join(filepath.Dir(cleanPath(path)), target)
+	}
+	return cleanPath(filepath.Join("/", target))
+}
+
+func linkLoopError(path string) error {
+	return fmt.Errorf("sbox/sharded: too many levels of symbolic links: %s: %w", path, sbox.ErrInvalid)
+}
+
+// === Extension: Locker ===
+
+// locksDir holds lock files on the manifest filesystem, next to the
+// manifests:
+//
+//	locks/<sha256 of path>.lock
+const locksDir = "locks"
+
+// Lock takes a lease on path with a lock file on the manifest filesystem.
+// Writers of the same file, e.g. several processes appending to it, must
+// hold the lock: manifests are replaced whole, so otherwise the last
+// writer silently discards the others' changes.
+func (e *Engine) Lock(ctx context.Context, path string, opts sbox.LockOptions) (sbox.Lease, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	return lockfile.New(e.manifestFs, locksDir).Lock(ctx, path, opts)
+}
+
+// === Extension: ConditionalWriter ===
+
+// ETag is the SHA-256 of the manifest of path, which changes with every
+// write and metadata update.
+func (e *Engine) ETag(ctx context.Context, path string) (string, error) {
+	if e.closed.Load() {
+		return "", sbox.ErrClosed
+	}
+	data, err := afero.ReadFile(e.manifestFs, e.manifestPath(path))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CreateIf returns a writer like CreateAtomic whose Close checks the
+// precondition again and replaces the manifest while holding a lock in
+// "locks/commit", so conditional writers exclude each other.
+// Unconditional writes are not held up.
+func (e *Engine) CreateIf(ctx context.Context, path string, cond sbox.Precondition) (sbox.AtomicWriteCloser, error) {
+	if err := cond.Check(e.ETag(ctx, path)); err != nil {
+		return nil, err
+	}
+	w, err := e.OpenFile(ctx, path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sw := w.(*shardedWriter)
+	sw.cond = &cond
+	return sw, nil
+}
+
+// commitLocker returns the locker serializing conditional writes.
+func (e *Engine) commitLocker() *lockfile.Locker {
+	return lockfile.New(e.manifestFs, filepath.Join(locksDir, "commit"))
+}
+
+// === Extension: TierManager ===
+
+// GetTier is not supported: shards are shared between files, so tiers
+// cannot be managed per logical file.
+func (e *Engine) GetTier(ctx context.Context, path string) (string, error) {
+	return "", sbox.ErrNotSupported
+}
+
+// SetTier is not supported: shards are shared between files, so tiers
+// cannot be managed per logical file.
+func (e *Engine) SetTier(ctx context.Context, path string, tier string) error {
+	return sbox.ErrNotSupported
+}
+
+// === Extension: HealthChecker ===
+
+// Ping checks that the manifest and shard directories exist and are
+// writable, by creating and removing a temporary file in each. Neither
+// root holds logical files or shards, so the files are never listed.
+func (e *Engine) Ping(ctx context.Context) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	for _, dir := range []struct {
+		name string
+		fs   afero.Fs
+	}{{"manifest", e.manifestFs}, {"shards", e.shardsFs}} {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f, err := afero.TempFile(dir.fs, ".", ".sbox-ping-*")
+		if err != nil {
+			return fmt.Errorf("sbox/sharded: %s directory is not writable: %w", dir.name, err)
+		}
+		name := f.Name()
+		_ = f.Close()
+		if err := dir.fs.Remove(name); err != nil {
+			return fmt.Errorf("sbox/sharded: %s directory: %w", dir.name, err)
+		}
+	}
+	return nil
+}
+
+// === Extension: UsageReporter ===
+
+// Usage adds up the manifests below path: Bytes is the logical size of the
+// files, and Physical the stored size of the distinct shards they
+// reference, after deduplication and compression. Shards shared with files
+// elsewhere count in full, so the usage of two directories may add up to
+// more than that of their parent. Versions and snapshots are not counted.
+func (e *Engine) Usage(ctx context.Context, path string) (*sbox.UsageInfo, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	usage := &sbox.UsageInfo{}
+	shards := make(map[string]struct{})
+	add := func(mPath string) error {
+		data, err := afero.ReadFile(e.manifestFs, mPath)
+		if err != nil {
+			return err
+		}
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return fmt.Errorf("sbox/sharded: %s: %w", mPath, err)
+		}
+		usage.Files++
+		usage.Bytes += m.Size
+		for _, h := range m.Chunks {
+			if h != sbox.HoleChunk {
+				shards[h] = struct{}{}
+			}
+		}
+		return nil
+	}
+
+	err := add(e.manifestPath(path))
+	if cleanPath(path) == "" || os.IsNotExist(err) {
+		root := e.manifestDirPath(path)
+		err = afero.Walk(e.manifestFs, root, func(p string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			switch {
+			case err != nil:
+				if p == root && cleanPath(path) == "" && os.IsNotExist(err) {
+					return nil // Nothing written yet
+				}
+				return err
+			case info.IsDir():
+				if p != root {
+					usage.Dirs++
+				}
+			case strings.HasSuffix(p, ".json"):
+				return add(p)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Missing shards are reported by Verify, not here.
+		if info, err := e.shardsFs.Stat(e.shardPath(h)); err == nil {
+			usage.Physical += info.Size()
+		}
+	}
+	return usage, nil
+}
+
+// === Extension: Watcher ===
+
+// Watch polls the manifests below path every poll interval (see
+// WithPollInterval), so it sees changes made through every engine sharing
+// the manifest directory.
+func (e *Engine) Watch(ctx context.Context, path string, recursive bool) (<-chan sbox.WatchEvent, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	return sbox.Poll(ctx, e, path, recursive, e.poll)
+}
+
+// === Extension: CapabilityReporter ===
+
+// Supports reports the extensions that can succeed: versions must be
+// enabled with WithVersions, and tiers are not supported.
+func (e *Engine) Supports(c sbox.Capability) bool {
+	switch c {
+	case sbox.CapVersions:
+		return e.versions > 0
+	case sbox.CapTier:
+		return false
+	}
+	return sbox.Implements(e, c)
+}
+
 // Compile-time interface checks.
 var (
-	_ sbox.StorageEngine = (*Engine)(nil)
-	_ sbox.Copier        = (*Engine)(nil)
-	_ sbox.Hasher        = (*Engine)(nil)
+	_ sbox.StorageEngine      = (*Engine)(nil)
+	_ sbox.Copier             = (*Engine)(nil)
+	_ sbox.Hasher             = (*Engine)(nil)
+	_ sbox.TierManager        = (*Engine)(nil)
+	_ sbox.MultipartUploader  = (*Engine)(nil)
+	_ sbox.AtomicWriter       = (*Engine)(nil)
+	_ sbox.Versioner          = (*Engine)(nil)
+	_ sbox.Lister             = (*Engine)(nil)
+	_ sbox.Appender           = (*Engine)(nil)
+	_ sbox.RangeWriter        = (*Engine)(nil)
+	_ sbox.MetadataWriter     = (*Engine)(nil)
+	_ sbox.Symlinker          = (*Engine)(nil)
+	_ sbox.PermissionManager  = (*Engine)(nil)
+	_ sbox.Locker             = (*Engine)(nil)
+	_ sbox.ConditionalWriter  = (*Engine)(nil)
+	_ sbox.HealthChecker      = (*Engine)(nil)
+	_ sbox.UsageReporter      = (*Engine)(nil)
+	_ sbox.Watcher            = (*Engine)(nil)
+	_ sbox.CapabilityReporter = (*Engine)(nil)
 )
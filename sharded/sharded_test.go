@@ -2,12 +2,18 @@ package sharded_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 
+	"github.com/nuln/sbox"
 	"github.com/nuln/sbox/sboxtest"
 	"github.com/nuln/sbox/sharded"
 )
@@ -23,6 +29,11 @@ func TestShardedEngine(t *testing.T) {
 	sboxtest.StorageTestSuite(t, engine)
 }
 
+func TestShardedEngine_VerifyOnRead(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16, sharded.WithVerifyOnRead(true))
+	sboxtest.StorageTestSuite(t, engine)
+}
+
 func TestShardedEngine_Deduplication(t *testing.T) {
 	// Shared shards filesystem
 	shardsFs := afero.NewMemMapFs()
@@ -118,3 +129,398 @@ func countShards(t *testing.T, fs afero.Fs, dir string, count *int) {
 		}
 	}
 }
+
+func TestShardedEngine_Usage(t *testing.T) {
+	engine := newTestEngine()
+	ctx := context.Background()
+	data := strings.Repeat("deduplicated ", 1000)
+	for _, p := range []string{"a/one.txt", "a/b/two.txt", "three.txt"} {
+		if err := sbox.PutAtomic(ctx, engine, p, strings.NewReader(data)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	u, err := engine.Usage(ctx, "")
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	// Three copies of the same content share their shards.
+	if u.Files != 3 || u.Dirs != 2 || u.Bytes != 3*int64(len(data)) || u.Physical != int64(len(data)) {
+		t.Errorf("Usage = %+v", u)
+	}
+	if u, err := engine.Usage(ctx, "a"); err != nil || u.Files != 2 || u.Dirs != 1 || u.Physical != int64(len(data)) {
+		t.Errorf("Usage(a) = %+v, %v", u, err)
+	}
+	if u, err := engine.Usage(ctx, "three.txt"); err != nil || u.Files != 1 || u.Bytes != int64(len(data)) {
+		t.Errorf("Usage(three.txt) = %+v, %v", u, err)
+	}
+	if _, err := engine.Usage(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Usage(missing) = %v", err)
+	}
+	if u, err := newTestEngine().Usage(ctx, ""); err != nil || u.Files != 0 {
+		t.Errorf("Usage of empty engine = %+v, %v", u, err)
+	}
+}
+
+func TestShardedEngine_Close(t *testing.T) {
+	engine := newTestEngine()
+	ctx := context.Background()
+
+	w, _ := engine.Create(ctx, "before.txt")
+	_, _ = io.WriteString(w, "data")
+	_ = w.Close()
+
+	if err := engine.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := sbox.Close(engine); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := engine.Ping(ctx); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Ping after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+	// Close is idempotent.
+	if err := engine.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	if _, err := engine.Stat(ctx, "before.txt"); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Stat after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+	if _, err := engine.Open(ctx, "before.txt"); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Open after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+	if _, err := engine.Create(ctx, "after.txt"); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Create after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+	if err := engine.Remove(ctx, "before.txt"); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Remove after Close = %v, want %v", err, sbox.ErrClosed)
+	}
+}
+
+func TestShardedEngine_OpenURL(t *testing.T) {
+	dir := t.TempDir()
+	engine, err := sbox.OpenURL("sharded://" + dir + "?chunkSize=4")
+	if err != nil {
+		t.Fatalf("OpenURL: %v", err)
+	}
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestShardedEngine_List(t *testing.T) {
+	ctx := context.Background()
+	engine := newTestEngine()
+	for _, p := range []string{"dir/c.txt", "dir/a.txt", "dir/sub/x.txt", "dir/b.txt"} {
+		if err := sbox.PutAtomic(ctx, engine, p, strings.NewReader(p)); err != nil {
+			t.Fatalf("PutAtomic: %v", err)
+		}
+	}
+
+	page, err := engine.List(ctx, "dir", sbox.ListOptions{PageSize: 3})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(page.Entries) != 3 || page.Entries[0].Name != "a.txt" || page.Entries[0].Size != 9 || page.NextCursor != "c.txt" {
+		t.Fatalf("first page = %+v, cursor %q", page.Entries, page.NextCursor)
+	}
+	page, err = engine.List(ctx, "dir", sbox.ListOptions{PageSize: 3, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(page.Entries) != 1 || !page.Entries[0].IsDir || page.Entries[0].Path != "dir/sub" || page.NextCursor != "" {
+		t.Errorf("second page = %+v, cursor %q", page.Entries, page.NextCursor)
+	}
+
+	if page, err := engine.List(ctx, "", sbox.ListOptions{}); err != nil || len(page.Entries) != 1 {
+		t.Errorf("List(root) = %+v, %v", page, err)
+	}
+	if _, err := engine.List(ctx, "missing", sbox.ListOptions{}); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("List(missing) error = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestShardedEngine_MetadataWriter(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+	if err := sbox.PutAtomic(ctx, engine, "dir/f.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := engine.SetModTime(ctx, "dir/f.txt", mtime); err != nil {
+		t.Fatalf("SetModTime: %v", err)
+	}
+	if err := engine.SetMetadata(ctx, "dir/f.txt", map[string]string{"owner": "alice"}); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	info, err := engine.Stat(ctx, "dir/f.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !info.ModTime.Equal(mtime) || info.Metadata["owner"] != "alice" || info.Size != 5 {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	// Appending keeps the metadata; overwriting drops it.
+	if _, err := sbox.Append(ctx, engine, "dir/f.txt", strings.NewReader("!")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "dir/f.txt"); info.Metadata["owner"] != "alice" {
+		t.Errorf("metadata after append = %v", info.Metadata)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "dir/f.txt", strings.NewReader("new")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "dir/f.txt"); info.Metadata != nil {
+		t.Errorf("metadata after overwrite = %v", info.Metadata)
+	}
+
+	if err := engine.SetModTime(ctx, "dir", mtime); err != nil {
+		t.Fatalf("SetModTime(dir): %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "dir"); !info.ModTime.Equal(mtime) {
+		t.Errorf("dir modtime = %v", info.ModTime)
+	}
+	if err := engine.SetMetadata(ctx, "missing", nil); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("SetMetadata(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestShardedEngine_SymlinkResolution(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+	if err := sbox.PutAtomic(ctx, engine, "data/f.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+
+	// Absolute targets and ".." are resolved within the engine root.
+	links := map[string]string{
+		"a/abs":   "/data/f.txt",
+		"a/up":    "../../../data/f.txt",
+		"a/chain": "abs",
+		"a/dir":   "/data",
+	}
+	for link, target := range links {
+		if err := engine.Symlink(ctx, target, link); err != nil {
+			t.Fatalf("Symlink %s: %v", link, err)
+		}
+	}
+	for _, link := range []string{"a/abs", "a/up", "a/chain"} {
+		if info, err := engine.Stat(ctx, link); err != nil || info.Size != 5 {
+			t.Errorf("Stat %s = %+v, %v", link, info, err)
+		}
+	}
+	if info, err := engine.Stat(ctx, "a/dir"); err != nil || !info.IsDir {
+		t.Errorf("Stat of a directory link = %+v, %v", info, err)
+	}
+
+	if err := engine.Symlink(ctx, "loop2", "loop1"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := engine.Symlink(ctx, "loop1", "loop2"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if _, err := engine.Open(ctx, "loop1"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Open of a link loop: %v, want %v", err, sbox.ErrInvalid)
+	}
+
+	// Links hold no chunks, so collecting garbage keeps the target intact.
+	if err := engine.Remove(ctx, "a"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := engine.GC(ctx); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	r, err := engine.Open(ctx, "data/f.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "hello" {
+		t.Errorf("content = %q", data)
+	}
+}
+
+func TestShardedEngine_Permissions(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+	if err := sbox.PutAtomic(ctx, engine, "dir/f.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if err := engine.Chmod(ctx, "dir/f.txt", 0750|os.ModeSetuid); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if err := engine.Chown(ctx, "dir/f.txt", 1000, 100); err != nil {
+		t.Fatalf("Chown: %v", err)
+	}
+	if err := engine.Chown(ctx, "dir/f.txt", -1, 200); err != nil {
+		t.Fatalf("Chown: %v", err)
+	}
+	info, err := engine.Stat(ctx, "dir/f.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Mode != 0750 || info.Uid != 1000 || info.Gid != 200 {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	// Appending keeps permissions; overwriting drops them.
+	if _, err := engine.Append(ctx, "dir/f.txt", strings.NewReader("!")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "dir/f.txt"); info.Mode != 0750 || info.Uid != 1000 {
+		t.Errorf("after append = %+v", info)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "dir/f.txt", strings.NewReader("new")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "dir/f.txt"); info.Mode != 0 || info.Uid != 0 {
+		t.Errorf("after overwrite = %+v", info)
+	}
+
+	if err := engine.Chmod(ctx, "dir", 0700); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Chmod(dir) = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
+
+func TestShardedEngine_MinFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := sbox.FreeSpace(dir); err != nil {
+		t.Skip("free space cannot be measured here")
+	}
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 1024, sharded.WithMinFreeSpace(dir, 1<<60))
+	if _, err := engine.Create(ctx, "f.txt"); !errors.Is(err, sbox.ErrNoSpace) {
+		t.Errorf("Create over the reserve = %v", err)
+	}
+
+	engine = sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 1024, sharded.WithMinFreeSpace(dir, 1))
+	if err := sbox.PutAtomic(ctx, engine, "f.txt", strings.NewReader("data")); err != nil {
+		t.Errorf("PutAtomic under the reserve: %v", err)
+	}
+}
+
+func TestShardedEngine_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 1024, sharded.WithPollInterval(20*time.Millisecond))
+	events, err := engine.Watch(ctx, "", true)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if err := sbox.PutAtomic(ctx, engine, "dir/f.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for !seen["create dir/f.txt"] {
+		select {
+		case ev := <-events:
+			seen[ev.String()] = true
+		case <-timeout:
+			t.Fatalf("events seen: %v", seen)
+		}
+	}
+	cancel()
+	for range events {
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Watch(context.Background(), "", true); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Watch after Close = %v", err)
+	}
+}
+
+func TestShardedEngine_Concurrency(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64)
+	sboxtest.Concurrency(t, engine, sboxtest.Options{Concurrency: 16})
+}
+
+func TestShardedEngine_LargeFiles(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4096)
+	opts := sboxtest.Options{LargeFileSize: 1 << 20, ChunkSize: 4096}
+	sboxtest.LargeFiles(t, engine, opts)
+	sboxtest.EdgeCases(t, engine, opts)
+}
+
+func TestShardedEngine_ContentType(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+
+	detect := sbox.WithFileOptions(ctx, sbox.WithDetectedContentType())
+	if err := sbox.PutAtomic(detect, engine, "page", strings.NewReader("<html><body>hi</body></html>")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "page"); info.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("detected content type = %q", info.ContentType)
+	}
+	entries, err := engine.ReadDir(ctx, "")
+	if err != nil || len(entries) != 1 || entries[0].ContentType != "text/html; charset=utf-8" {
+		t.Errorf("ReadDir = %v, %v", entries, err)
+	}
+
+	// Appending keeps the type; an explicit type wins over detection.
+	if _, err := sbox.Append(detect, engine, "page", strings.NewReader("\x00\x01")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "page"); info.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("content type after append = %q", info.ContentType)
+	}
+	w, err := sbox.CreateWith(detect, engine, "page", sbox.WithContentType("text/x-custom"))
+	if err != nil {
+		t.Fatalf("CreateWith: %v", err)
+	}
+	_, _ = io.WriteString(w, "<html></html>")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "page"); info.ContentType != "text/x-custom" {
+		t.Errorf("explicit content type = %q", info.ContentType)
+	}
+
+	// Without options, no type is stored.
+	if err := sbox.PutAtomic(ctx, engine, "page", strings.NewReader("<html></html>")); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if info, _ := engine.Stat(ctx, "page"); info.ContentType != "" {
+		t.Errorf("content type without options = %q", info.ContentType)
+	}
+}
+
+func TestShardedEngine_Checksum(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	withSum := func(s string) context.Context {
+		return sbox.WithFileOptions(ctx, sbox.WithChecksum("sha256", sha(s)))
+	}
+
+	if err := sbox.PutAtomic(withSum("hello"), engine, "f", strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutAtomic with a matching checksum: %v", err)
+	}
+	if err := sbox.PutAtomic(withSum("other"), engine, "f", strings.NewReader("hello, world")); !errors.Is(err, sbox.ErrChecksumMismatch) {
+		t.Fatalf("PutAtomic with a wrong checksum = %v, want %v", err, sbox.ErrChecksumMismatch)
+	}
+	if info, _ := engine.Stat(ctx, "f"); info.Size != 5 {
+		t.Errorf("size after a rejected write = %d, want 5", info.Size)
+	}
+
+	// The checksum of an append covers the appended data.
+	if _, err := sbox.Append(withSum(" world"), engine, "f", strings.NewReader(" world")); err != nil {
+		t.Fatalf("Append with a matching checksum: %v", err)
+	}
+	if _, err := sbox.Append(withSum("x"), engine, "f", strings.NewReader("y")); !errors.Is(err, sbox.ErrChecksumMismatch) {
+		t.Fatalf("Append with a wrong checksum = %v, want %v", err, sbox.ErrChecksumMismatch)
+	}
+	if info, _ := engine.Stat(ctx, "f"); info.Size != 11 {
+		t.Errorf("size after appends = %d, want 11", info.Size)
+	}
+	if sum, err := engine.Hash(ctx, "f", "sha256"); err != nil || sum != sha("hello world") {
+		t.Errorf("Hash = %s, %v, want the hash of %q", sum, err, "hello world")
+	}
+}
@@ -0,0 +1,261 @@
+package sharded
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// snapshotsDir holds snapshots in the manifest filesystem. Each snapshot is
+// a copy of the manifest tree plus an info file; shards are shared with the
+// live tree, so a snapshot costs only the space of its manifests.
+//
+//	snapshots/<name>/snapshot.json
+//	snapshots/<name>/manifests/...
+const snapshotsDir = "snapshots"
+
+// snapshotInfoFile is the name of the info file inside a snapshot.
+const snapshotInfoFile = "snapshot.json"
+
+// snapshotTmpPrefix marks snapshots that are still being written.
+const snapshotTmpPrefix = ".tmp-"
+
+// SnapshotInfo describes a snapshot.
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"` // Files in the snapshot
+	Size    int64     `json:"size"`  // Total logical size of those files
+}
+
+// validSnapshotName reports whether name can be used as a snapshot name.
+func validSnapshotName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+func (e *Engine) snapshotPath(name string) string {
+	return filepath.Join(snapshotsDir, name)
+}
+
+// Snapshot records the current state of every file under name. Only
+// manifests are copied, so taking a snapshot is cheap regardless of how
+// much data the engine holds. Shards referenced by a snapshot are kept by
+// GC and reference counting until the snapshot is deleted.
+func (e *Engine) Snapshot(ctx context.Context, name string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	if !validSnapshotName(name) {
+		return fmt.Errorf("sbox/sharded: invalid snapshot name %q: %w", name, sbox.ErrInvalid)
+	}
+	dir := e.snapshotPath(name)
+	if exists, _ := afero.Exists(e.manifestFs, dir); exists {
+		return fmt.Errorf("sbox/sharded: snapshot %q: %w", name, sbox.ErrExist)
+	}
+	// Load the index first so that a rebuild does not count the snapshot
+	// before adjustRefs does.
+	if _, err := e.refs(); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(snapshotsDir, snapshotTmpPrefix+name)
+	_ = e.manifestFs.RemoveAll(tmp)
+	info, chunks, err := e.copyManifests(ctx, "manifests", filepath.Join(tmp, "manifests"))
+	if err == nil {
+		info.Name = name
+		info.Created = time.Now()
+		var data []byte
+		if data, err = json.Marshal(info); err == nil {
+			err = afero.WriteFile(e.manifestFs, filepath.Join(tmp, snapshotInfoFile), data, 0644)
+		}
+	}
+	if err == nil {
+		err = e.manifestFs.Rename(tmp, dir)
+	}
+	if err != nil {
+		_ = e.manifestFs.RemoveAll(tmp)
+		return err
+	}
+	return e.adjustRefs(chunks, nil)
+}
+
+// ListSnapshots returns all snapshots, oldest first.
+func (e *Engine) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	entries, err := afero.ReadDir(e.manifestFs, snapshotsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+	result := make([]SnapshotInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || !validSnapshotName(entry.Name()) {
+			continue
+		}
+		info, err := e.snapshotInfo(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Created.Equal(result[j].Created) {
+			return result[i].Created.Before(result[j].Created)
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// snapshotInfo reads the info file of the named snapshot.
+func (e *Engine) snapshotInfo(name string) (*SnapshotInfo, error) {
+	data, err := afero.ReadFile(e.manifestFs, filepath.Join(e.snapshotPath(name), snapshotInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	var info SnapshotInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RestoreSnapshot replaces every file in the engine with the state recorded
+// in the named snapshot. Files created after the snapshot was taken are
+// removed. The snapshot itself is kept.
+func (e *Engine) RestoreSnapshot(ctx context.Context, name string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	if err := e.checkSnapshot(name); err != nil {
+		return err
+	}
+	if _, err := e.refs(); err != nil {
+		return err
+	}
+
+	staging := "manifests.restore"
+	_ = e.manifestFs.RemoveAll(staging)
+	if err := e.manifestFs.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	_, added, err := e.copyManifests(ctx, filepath.Join(e.snapshotPath(name), "manifests"), staging)
+	if err != nil {
+		_ = e.manifestFs.RemoveAll(staging)
+		return err
+	}
+	current, err := e.refChunks(e.treeChunks, "manifests")
+	if err != nil {
+		_ = e.manifestFs.RemoveAll(staging)
+		return err
+	}
+
+	// Swap the trees with renames so that a failure leaves the live tree
+	// in place.
+	old := "manifests.old"
+	_ = e.manifestFs.RemoveAll(old)
+	hadLive, _ := afero.Exists(e.manifestFs, "manifests")
+	if hadLive {
+		if err := e.manifestFs.Rename("manifests", old); err != nil {
+			_ = e.manifestFs.RemoveAll(staging)
+			return err
+		}
+	}
+	if err := e.manifestFs.Rename(staging, "manifests"); err != nil {
+		if hadLive {
+			_ = e.manifestFs.Rename(old, "manifests")
+		}
+		_ = e.manifestFs.RemoveAll(staging)
+		return err
+	}
+	if err := e.manifestFs.RemoveAll(old); err != nil {
+		e.logger.Warn("sbox/sharded: failed to remove replaced manifests", "error", err)
+	}
+	return e.adjustRefs(added, current)
+}
+
+// DeleteSnapshot removes the named snapshot. Shards referenced only by the
+// snapshot are released (with reference counting) or left for GC.
+func (e *Engine) DeleteSnapshot(ctx context.Context, name string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	if err := e.checkSnapshot(name); err != nil {
+		return err
+	}
+	dir := e.snapshotPath(name)
+	chunks, err := e.refChunks(e.treeChunks, filepath.Join(dir, "manifests"))
+	if err != nil {
+		return err
+	}
+	if err := e.manifestFs.RemoveAll(dir); err != nil {
+		return err
+	}
+	return e.adjustRefs(nil, chunks)
+}
+
+// checkSnapshot validates name and verifies that the snapshot exists.
+func (e *Engine) checkSnapshot(name string) error {
+	if !validSnapshotName(name) {
+		return fmt.Errorf("sbox/sharded: invalid snapshot name %q: %w", name, sbox.ErrInvalid)
+	}
+	if exists, _ := afero.Exists(e.manifestFs, e.snapshotPath(name)); !exists {
+		return fmt.Errorf("sbox/sharded: snapshot %q: %w", name, sbox.ErrNotFound)
+	}
+	return nil
+}
+
+// copyManifests copies the manifest tree at src to dst and returns a summary
+// of the copied files together with every chunk hash they reference.
+func (e *Engine) copyManifests(ctx context.Context, src, dst string) (*SnapshotInfo, []string, error) {
+	info := &SnapshotInfo{}
+	var chunks []string
+	err := afero.Walk(e.manifestFs, src, func(p string, fi os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return e.manifestFs.MkdirAll(target, 0755)
+		}
+		if !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		data, err := afero.ReadFile(e.manifestFs, p)
+		if err != nil {
+			return err
+		}
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return err
+		}
+		info.Files++
+		info.Size += m.Size
+		chunks = append(chunks, m.Chunks...)
+		return e.writeFile(e.manifestFs, target, data)
+	})
+	return info, chunks, err
+}
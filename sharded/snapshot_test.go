@@ -0,0 +1,168 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestSnapshot_Restore(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 8)
+	ctx := context.Background()
+
+	writeFile(t, engine, "docs/a.txt", "version one")
+	writeFile(t, engine, "b.txt", "bravo")
+	if err := engine.Snapshot(ctx, "v1"); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	writeFile(t, engine, "docs/a.txt", "version two")
+	writeFile(t, engine, "new.txt", "created later")
+	if err := engine.Remove(ctx, "b.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := engine.Snapshot(ctx, "v2"); err != nil {
+		t.Fatalf("Snapshot v2: %v", err)
+	}
+
+	snaps, err := engine.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if len(snaps) != 2 || snaps[0].Name != "v1" || snaps[1].Name != "v2" {
+		t.Fatalf("snapshots = %+v", snaps)
+	}
+	if snaps[0].Files != 2 || snaps[0].Size != 16 {
+		t.Errorf("v1 = %+v, want Files=2 Size=16", snaps[0])
+	}
+
+	// Snapshots are not visible as files.
+	entries, err := engine.ReadDir(ctx, "")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("root has %d entries, want 2", len(entries))
+	}
+
+	if err := engine.RestoreSnapshot(ctx, "v1"); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if got := readFile(t, engine, "docs/a.txt"); got != "version one" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got := readFile(t, engine, "b.txt"); got != "bravo" {
+		t.Errorf("b.txt = %q", got)
+	}
+	if _, err := engine.Stat(ctx, "new.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("new.txt after restore: %v", err)
+	}
+
+	if err := engine.RestoreSnapshot(ctx, "v2"); err != nil {
+		t.Fatalf("RestoreSnapshot v2: %v", err)
+	}
+	if got := readFile(t, engine, "docs/a.txt"); got != "version two" {
+		t.Errorf("a.txt after v2 = %q", got)
+	}
+}
+
+func TestSnapshot_Errors(t *testing.T) {
+	engine := newTestEngine()
+	ctx := context.Background()
+
+	for _, name := range []string{"", "../x", "a/b", ".hidden"} {
+		if err := engine.Snapshot(ctx, name); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Snapshot(%q) = %v, want %v", name, err, sbox.ErrInvalid)
+		}
+	}
+	if err := engine.Snapshot(ctx, "s"); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := engine.Snapshot(ctx, "s"); !errors.Is(err, sbox.ErrExist) {
+		t.Errorf("duplicate Snapshot = %v, want %v", err, sbox.ErrExist)
+	}
+	if err := engine.RestoreSnapshot(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("RestoreSnapshot missing = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if err := engine.DeleteSnapshot(ctx, "s"); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if snaps, _ := engine.ListSnapshots(ctx); len(snaps) != 0 {
+		t.Errorf("snapshots after delete = %+v", snaps)
+	}
+}
+
+func TestSnapshot_KeepsShards(t *testing.T) {
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 8)
+	ctx := context.Background()
+
+	writeFile(t, engine, "a.txt", "snapshotted data")
+	if err := engine.Snapshot(ctx, "keep"); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := engine.Remove(ctx, "a.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	stats, err := engine.GC(ctx)
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if stats.Deleted != 0 {
+		t.Errorf("GC deleted %d shards held by a snapshot", stats.Deleted)
+	}
+
+	if err := engine.DeleteSnapshot(ctx, "keep"); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if stats, err = engine.GC(ctx); err != nil || stats.Deleted != 2 {
+		t.Errorf("GC after DeleteSnapshot: stats=%+v err=%v", stats, err)
+	}
+}
+
+func TestSnapshot_Refcount(t *testing.T) {
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := newRefcountEngine(manifestFs, shardsFs)
+	ctx := context.Background()
+
+	writeFile(t, engine, "a.txt", "snapshotted data")
+	if err := engine.Snapshot(ctx, "s"); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := engine.Remove(ctx, "a.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Fatalf("shards after Remove = %d, want 2", n)
+	}
+
+	// A rebuilt index must count the snapshot's references too.
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := shardsFs.Remove("refcount.log"); err != nil {
+		t.Fatalf("remove index: %v", err)
+	}
+	engine = newRefcountEngine(manifestFs, shardsFs)
+	if err := engine.RestoreSnapshot(ctx, "s"); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if err := engine.DeleteSnapshot(ctx, "s"); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if got := readFile(t, engine, "a.txt"); got != "snapshotted data" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if err := engine.Remove(ctx, "a.txt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 0 {
+		t.Errorf("shards after final Remove = %d, want 0", n)
+	}
+}
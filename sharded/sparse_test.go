@@ -0,0 +1,121 @@
+package sharded_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestSparse_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+		sharded.WithSparseFiles(true)))
+}
+
+func TestSparse_Holes(t *testing.T) {
+	zeros := strings.Repeat("\x00", 64)
+	content := strings.Repeat("a", 64) + zeros + zeros + strings.Repeat("b", 10) + zeros[:54] + zeros
+
+	for _, tt := range []struct {
+		name string
+		opts []sharded.Option
+	}{
+		{"plain", nil},
+		{"verify", []sharded.Option{sharded.WithVerifyOnRead(true)}},
+		{"readahead", []sharded.Option{sharded.WithReadAhead(2)}},
+		{"refcount", []sharded.Option{sharded.WithRefcount(true), sharded.WithWriteConcurrency(4)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+			engine := sharded.New(manifestFs, shardsFs, 64, append(tt.opts, sharded.WithSparseFiles(true))...)
+			writeFile(t, engine, "disk.img", content)
+
+			// Only the chunks with data are stored.
+			if n := shardCount(t, shardsFs); n != 2 {
+				t.Errorf("stored %d shards, want 2", n)
+			}
+			m := readManifest(t, manifestFs, "manifests/disk.img.json")
+			want := []string{m.Chunks[0], sbox.HoleChunk, sbox.HoleChunk, m.Chunks[3], sbox.HoleChunk}
+			for i := range want {
+				if m.Chunks[i] != want[i] {
+					t.Errorf("chunks = %v, want holes at 1, 2 and 4", m.Chunks)
+					break
+				}
+			}
+			if got := readFile(t, engine, "disk.img"); got != content {
+				t.Errorf("content mismatch: got %d bytes", len(got))
+			}
+
+			// Reads from the middle of a hole.
+			r, err := engine.Open(context.Background(), "disk.img")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			_, _ = r.Seek(100, io.SeekStart)
+			buf := make([]byte, 100)
+			if _, err := io.ReadFull(r, buf); err != nil || string(buf) != content[100:200] {
+				t.Errorf("read at 100 = %q, %v", buf, err)
+			}
+			_ = r.Close()
+
+			if report, err := engine.Verify(context.Background(), sharded.VerifyOptions{}); err != nil || !report.OK() {
+				t.Errorf("Verify = %+v, %v", report, err)
+			}
+			if err := engine.Remove(context.Background(), "disk.img"); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+		})
+	}
+}
+
+func TestSparse_Truncate(t *testing.T) {
+	ctx := context.Background()
+	shardsFs := afero.NewMemMapFs()
+	engine := sharded.New(afero.NewMemMapFs(), shardsFs, 64, sharded.WithSparseFiles(true), sharded.WithRefcount(true))
+	writeFile(t, engine, "db", "header")
+
+	// Growing a file adds holes instead of zero shards.
+	f, err := engine.OpenFile(ctx, "db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	rw := f.(interface {
+		io.WriterAt
+		Truncate(int64) error
+	})
+	if err := rw.Truncate(1 << 20); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if _, err := rw.WriteAt([]byte("page"), 4096+10); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Errorf("stored %d shards, want 2", n)
+	}
+
+	got := readFile(t, engine, "db")
+	want := make([]byte, 1<<20)
+	copy(want, "header")
+	copy(want[4096+10:], "page")
+	if got != string(want) {
+		t.Errorf("content mismatch after Truncate and WriteAt")
+	}
+
+	// Overwriting a hole with zeros keeps it a hole.
+	if _, err := engine.PutRange(ctx, "db", 8192, strings.NewReader(strings.Repeat("\x00", 64))); err != nil {
+		t.Fatalf("PutRange: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Errorf("stored %d shards after writing zeros, want 2", n)
+	}
+}
@@ -0,0 +1,143 @@
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// Stats reports how much space deduplication saves. Holes of sparse files
+// count in LogicalBytes only.
+type Stats struct {
+	Files      int // Files, symbolic links excluded
+	Chunks     int // Distinct chunks referenced by the files
+	References int // Chunk references, counting every file that shares a chunk
+
+	LogicalBytes  int64 // Total size of the files
+	UniqueBytes   int64 // Size of the distinct chunks, before compression
+	PhysicalBytes int64 // Stored size of the distinct shards, after compression
+
+	DedupRatio       float64 // LogicalBytes / UniqueBytes; 1 without shared chunks
+	AverageChunkSize int64   // UniqueBytes / Chunks
+
+	// Dirs breaks the totals down by top-level directory, with files at
+	// the root under "". Chunks shared between directories count in each
+	// of them. Dirs of the entries are nil.
+	Dirs map[string]*Stats
+}
+
+// statsAcc accumulates the Stats of a set of files.
+type statsAcc struct {
+	stats  Stats
+	chunks map[string]int64 // Logical size of every distinct chunk
+}
+
+func (a *statsAcc) add(m *sbox.Manifest, sizes []int64) {
+	a.stats.Files++
+	a.stats.LogicalBytes += m.Size
+	for i, h := range m.Chunks {
+		if h == sbox.HoleChunk {
+			continue
+		}
+		a.stats.References++
+		if _, ok := a.chunks[h]; !ok && i < len(sizes) {
+			a.chunks[h] = sizes[i]
+		}
+	}
+}
+
+// finish computes the totals from the distinct chunks, whose stored sizes
+// are in physical.
+func (a *statsAcc) finish(physical map[string]int64) *Stats {
+	s := &a.stats
+	s.Chunks = len(a.chunks)
+	for h, size := range a.chunks {
+		s.UniqueBytes += size
+		s.PhysicalBytes += physical[h]
+	}
+	if s.UniqueBytes > 0 {
+		s.DedupRatio = float64(s.LogicalBytes) / float64(s.UniqueBytes)
+		s.AverageChunkSize = s.UniqueBytes / int64(s.Chunks)
+	}
+	return s
+}
+
+// Stats reports the deduplication statistics of the live files. Like
+// Usage, it does not count versions, snapshots or uploads in progress, and
+// reads every manifest and the size of every shard they reference, so it
+// is meant for occasional reports rather than frequent polling.
+func (e *Engine) Stats(ctx context.Context) (*Stats, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	total := &statsAcc{chunks: make(map[string]int64)}
+	dirs := make(map[string]*statsAcc)
+
+	err := afero.Walk(e.manifestFs, "manifests", func(p string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if p == "manifests" && os.IsNotExist(err) {
+				return nil // Nothing written yet
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		data, err := afero.ReadFile(e.manifestFs, p)
+		if err != nil {
+			return err
+		}
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return fmt.Errorf("sbox/sharded: %s: %w", p, err)
+		}
+		if m.LinkTarget != "" {
+			return nil
+		}
+
+		rel, _ := filepath.Rel("manifests", p)
+		dir, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+		if !found {
+			dir = ""
+		}
+		acc := dirs[dir]
+		if acc == nil {
+			acc = &statsAcc{chunks: make(map[string]int64)}
+			dirs[dir] = acc
+		}
+		sizes := e.chunkSizes(&m)
+		total.add(&m, sizes)
+		acc.add(&m, sizes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	physical := make(map[string]int64, len(total.chunks))
+	for h := range total.chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Missing shards are reported by Verify, not here.
+		if info, err := e.shardsFs.Stat(e.shardPath(h)); err == nil {
+			physical[h] = info.Size()
+		}
+	}
+
+	stats := total.finish(physical)
+	stats.Dirs = make(map[string]*Stats, len(dirs))
+	for dir, acc := range dirs {
+		stats.Dirs[dir] = acc.finish(physical)
+	}
+	return stats, nil
+}
@@ -0,0 +1,61 @@
+package sharded_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 1024)
+
+	if s, err := engine.Stats(ctx); err != nil || s.Files != 0 || s.DedupRatio != 0 || len(s.Dirs) != 0 {
+		t.Fatalf("Stats of an empty engine = %+v, %v", s, err)
+	}
+
+	shared := strings.Repeat("a", 1024) + strings.Repeat("b", 1024)
+	files := map[string]string{
+		"docs/one.txt":   shared,
+		"docs/b/two.txt": shared,
+		"three.txt":      shared + strings.Repeat("c", 512),
+	}
+	for p, content := range files {
+		if err := sbox.PutAtomic(ctx, engine, p, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put %s: %v", p, err)
+		}
+	}
+	if err := engine.Symlink(ctx, "three.txt", "link"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+
+	s, err := engine.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	// Three distinct chunks: "a", "b" and the tail of three.txt.
+	if s.Files != 3 || s.Chunks != 3 || s.References != 7 {
+		t.Errorf("Stats counts = %+v", s)
+	}
+	if s.LogicalBytes != 3*2048+512 || s.UniqueBytes != 2560 || s.PhysicalBytes != 2560 {
+		t.Errorf("Stats bytes = %+v", s)
+	}
+	if s.DedupRatio != 6656.0/2560 || s.AverageChunkSize != 2560/3 {
+		t.Errorf("DedupRatio = %v, AverageChunkSize = %d", s.DedupRatio, s.AverageChunkSize)
+	}
+
+	if len(s.Dirs) != 2 {
+		t.Fatalf("Dirs = %v", s.Dirs)
+	}
+	if d := s.Dirs["docs"]; d == nil || d.Files != 2 || d.Chunks != 2 || d.LogicalBytes != 4096 || d.PhysicalBytes != 2048 || d.DedupRatio != 2 {
+		t.Errorf("Dirs[docs] = %+v", d)
+	}
+	if d := s.Dirs[""]; d == nil || d.Files != 1 || d.Chunks != 3 || d.UniqueBytes != 2560 || d.DedupRatio != 1 {
+		t.Errorf("Dirs[\"\"] = %+v", d)
+	}
+}
@@ -0,0 +1,445 @@
+package sharded
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// DefaultDemoteAfter is the TierPolicy.DemoteAfter used when it is zero.
+const DefaultDemoteAfter = 30 * 24 * time.Hour
+
+// accessIndexPath is the location of the access-time index inside the hot
+// shard store. It holds one "<hash> <unix seconds>" line per hot shard
+// read since it was stored.
+const accessIndexPath = "access.idx"
+
+// TierPolicy controls when shards move between the hot and cold stores,
+// see WithColdShards.
+type TierPolicy struct {
+	// DemoteAfter is how long a shard stays in the hot store after it was
+	// last read, or stored if it was never read. DefaultDemoteAfter if
+	// zero.
+	DemoteAfter time.Duration
+
+	// Promote moves a cold shard back to the hot store when it is read.
+	Promote bool
+}
+
+// TierStats reports the outcome of a Demote run.
+type TierStats struct {
+	Scanned int   // Shards examined in the hot store
+	Demoted int   // Shards moved to the cold store
+	Bytes   int64 // Total size of the demoted shards
+}
+
+// tiering is the state of shard tiering: the stores and the time of the
+// last read of hot shards. Index updates are kept in memory and saved by
+// Demote and Engine.Close, so reads cost no writes; after a crash, shards
+// read since the last save may be demoted early.
+type tiering struct {
+	hot, cold afero.Fs
+	policy    TierPolicy
+
+	loadOnce sync.Once
+	mu       sync.Mutex
+	reads    map[string]int64
+	dirty    bool
+
+	moveMu sync.Mutex // Serializes moves between the stores
+}
+
+// load reads the access-time index on first use. A missing or damaged
+// index only makes shards look older than they are.
+func (t *tiering) load() {
+	t.loadOnce.Do(func() {
+		t.reads = make(map[string]int64)
+		f, err := t.hot.Open(accessIndexPath)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			hash, raw, ok := strings.Cut(scanner.Text(), " ")
+			if !ok || !isShardName(hash) {
+				continue
+			}
+			if sec, err := strconv.ParseInt(raw, 10, 64); err == nil {
+				t.reads[hash] = sec
+			}
+		}
+	})
+}
+
+// touch records a read of a shard at now.
+func (t *tiering) touch(hash string, now time.Time) {
+	t.load()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reads[hash] = now.Unix()
+	t.dirty = true
+}
+
+// lastRead returns the time of the last recorded read of a shard.
+func (t *tiering) lastRead(hash string) (time.Time, bool) {
+	t.load()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sec, ok := t.reads[hash]
+	return time.Unix(sec, 0), ok
+}
+
+// forget drops the reads of the shards for which drop returns true.
+func (t *tiering) forget(drop func(hash string) bool) {
+	t.load()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for h := range t.reads {
+		if drop(h) {
+			delete(t.reads, h)
+			t.dirty = true
+		}
+	}
+}
+
+// save writes the index if it changed since it was loaded or saved.
+func (t *tiering) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	hashes := make([]string, 0, len(t.reads))
+	for h := range t.reads {
+		hashes = append(hashes, h)
+	}
+	slices.Sort(hashes)
+	var sb strings.Builder
+	for _, h := range hashes {
+		fmt.Fprintf(&sb, "%s %d\n", h, t.reads[h])
+	}
+	tmp := accessIndexPath + ".tmp"
+	if err := afero.WriteFile(t.hot, tmp, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	if err := t.hot.Rename(tmp, accessIndexPath); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// accessShard records a read of a shard and, with promotion, moves it back
+// to the hot store if it was demoted. A failed promotion is logged and the
+// shard is read from the cold store.
+func (e *Engine) accessShard(hash string) {
+	if e.tier == nil {
+		return
+	}
+	e.tier.touch(hash, time.Now())
+	if !e.tier.policy.Promote {
+		return
+	}
+	if _, err := e.tier.hot.Stat(e.shardPath(hash)); err == nil {
+		return
+	}
+	if _, err := e.moveShard(e.tier.cold, e.tier.hot, hash); err != nil {
+		e.logger.Warn("sbox/sharded: failed to promote shard", "hash", hash, "error", err)
+	}
+}
+
+// moveShard moves a shard from one store to the other, unless it is
+// missing from the source. The copy is complete, and synced with
+// durability enabled, before the source is removed, so the shard can
+// always be found in one of the stores. It returns the size of the shard
+// moved, or -1 if nothing was moved.
+func (e *Engine) moveShard(from, to afero.Fs, hash string) (int64, error) {
+	e.tier.moveMu.Lock()
+	defer e.tier.moveMu.Unlock()
+
+	p := e.shardPath(hash)
+	if _, err := to.Stat(p); err == nil {
+		// Left by an interrupted move.
+		if err := from.Remove(p); err != nil && !os.IsNotExist(err) {
+			return -1, err
+		}
+		return -1, nil
+	}
+	src, err := from.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, nil
+		}
+		return -1, err
+	}
+	defer src.Close()
+
+	if err := to.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return -1, err
+	}
+	tmp := p + ".tmp"
+	dst, err := to.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return -1, err
+	}
+	n, err := io.Copy(dst, src)
+	if err == nil && e.syncs() {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = to.Rename(tmp, p)
+	}
+	if err == nil {
+		err = e.syncDirs(to, filepath.Dir(p))
+	}
+	if err != nil {
+		_ = to.Remove(tmp)
+		return -1, err
+	}
+	if err := from.Remove(p); err != nil && !os.IsNotExist(err) {
+		return -1, err
+	}
+	return n, nil
+}
+
+// Demote moves the shards of the hot store that were not read for the
+// DemoteAfter of the tier policy to the cold store. Like GC, it is meant
+// to run periodically, and stops promptly when ctx is cancelled, returning
+// the statistics gathered so far together with the context error. Every
+// shard examined is also reported as the "demote" operation to the
+// sbox.Progress attached to ctx. It returns sbox.ErrNotSupported unless
+// the engine was created with WithColdShards.
+func (e *Engine) Demote(ctx context.Context) (*TierStats, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	if e.tier == nil {
+		return nil, fmt.Errorf("sbox/sharded: no cold shard store: %w", sbox.ErrNotSupported)
+	}
+	stats := &TierStats{}
+	tracker := sbox.NewProgressTracker(ctx, "demote")
+	cutoff := time.Now().Add(-e.tier.policy.DemoteAfter)
+	hot := make(map[string]bool)
+
+	err := e.walkShards(e.tier.hot, "", func(p, hash string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := e.tier.hot.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed meanwhile
+			}
+			return err
+		}
+		stats.Scanned++
+		tracker.Advance(p, info.Size(), 1)
+
+		last, ok := e.tier.lastRead(hash)
+		if !ok {
+			last = info.ModTime()
+		}
+		if !last.Before(cutoff) {
+			hot[hash] = true
+			return nil
+		}
+		n, err := e.moveShard(e.tier.hot, e.tier.cold, hash)
+		if err != nil {
+			return err
+		}
+		if n >= 0 {
+			stats.Demoted++
+			stats.Bytes += n
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	// Reads of shards that were demoted or deleted are no longer needed.
+	e.tier.forget(func(hash string) bool { return !hot[hash] })
+	return stats, e.tier.save()
+}
+
+// tieredFs is the shard store of an engine with a cold store: it reads
+// from the hot store, then the cold one, lists the union of both and
+// writes to the hot store. Removing a file removes it from both.
+type tieredFs struct {
+	hot, cold afero.Fs
+}
+
+func (t *tieredFs) Name() string { return "sharded-tiered" }
+
+func (t *tieredFs) Create(name string) (afero.File, error) {
+	return t.hot.Create(name)
+}
+
+func (t *tieredFs) Mkdir(name string, perm os.FileMode) error {
+	return t.hot.Mkdir(name, perm)
+}
+
+func (t *tieredFs) MkdirAll(path string, perm os.FileMode) error {
+	return t.hot.MkdirAll(path, perm)
+}
+
+func (t *tieredFs) Open(name string) (afero.File, error) {
+	f, err := t.hot.Open(name)
+	if os.IsNotExist(err) {
+		return t.cold.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return &unionDir{File: f, more: func() ([]os.FileInfo, error) {
+			entries, err := afero.ReadDir(t.cold, name)
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return entries, err
+		}}, nil
+	}
+	return f, nil
+}
+
+func (t *tieredFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return t.Open(name)
+	}
+	return t.hot.OpenFile(name, flag, perm)
+}
+
+func (t *tieredFs) Remove(name string) error {
+	hotErr := t.hot.Remove(name)
+	coldErr := t.cold.Remove(name)
+	switch {
+	case hotErr == nil || os.IsNotExist(hotErr):
+		if hotErr == nil && os.IsNotExist(coldErr) {
+			return nil
+		}
+		return coldErr
+	default:
+		return hotErr
+	}
+}
+
+func (t *tieredFs) RemoveAll(path string) error {
+	if err := t.hot.RemoveAll(path); err != nil {
+		return err
+	}
+	return t.cold.RemoveAll(path)
+}
+
+func (t *tieredFs) Rename(oldname, newname string) error {
+	err := t.hot.Rename(oldname, newname)
+	if os.IsNotExist(err) {
+		return t.cold.Rename(oldname, newname)
+	}
+	return err
+}
+
+func (t *tieredFs) Stat(name string) (os.FileInfo, error) {
+	info, err := t.hot.Stat(name)
+	if os.IsNotExist(err) {
+		return t.cold.Stat(name)
+	}
+	return info, err
+}
+
+func (t *tieredFs) Chmod(name string, mode os.FileMode) error {
+	err := t.hot.Chmod(name, mode)
+	if os.IsNotExist(err) {
+		return t.cold.Chmod(name, mode)
+	}
+	return err
+}
+
+func (t *tieredFs) Chown(name string, uid, gid int) error {
+	err := t.hot.Chown(name, uid, gid)
+	if os.IsNotExist(err) {
+		return t.cold.Chown(name, uid, gid)
+	}
+	return err
+}
+
+func (t *tieredFs) Chtimes(name string, atime, mtime time.Time) error {
+	err := t.hot.Chtimes(name, atime, mtime)
+	if os.IsNotExist(err) {
+		return t.cold.Chtimes(name, atime, mtime)
+	}
+	return err
+}
+
+// unionDir is a directory whose listing includes the entries returned by
+// more, such as those of the same directory in another store. Entries
+// listed by the directory itself come first and win over those of more.
+type unionDir struct {
+	afero.File
+	more func() ([]os.FileInfo, error)
+
+	entries []os.FileInfo // Union of both listings, loaded on first use
+	loaded  bool
+}
+
+func (d *unionDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		entries, err := d.File.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		more, err := d.more()
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(entries))
+		for _, info := range entries {
+			seen[info.Name()] = true
+		}
+		for _, info := range more {
+			if !seen[info.Name()] {
+				entries = append(entries, info)
+			}
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *unionDir) Readdirnames(n int) ([]string, error) {
+	entries, err := d.Readdir(n)
+	names := make([]string, len(entries))
+	for i, info := range entries {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+// Compile-time interface checks.
+var _ afero.Fs = (*tieredFs)(nil)
@@ -0,0 +1,113 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+// tierShards counts the shards of a tier, without the access-time index.
+func tierShards(t *testing.T, fs afero.Fs) int {
+	t.Helper()
+	count := 0
+	countShards(t, fs, "", &count)
+	if exists, _ := afero.Exists(fs, "access.idx"); exists {
+		count--
+	}
+	return count
+}
+
+// ageShards makes every shard of fs look stored d ago.
+func ageShards(t *testing.T, fs afero.Fs, d time.Duration) {
+	t.Helper()
+	old := time.Now().Add(-d)
+	err := afero.Walk(fs, "", func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			err = fs.Chtimes(p, old, old)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTier_Suite(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16,
+		sharded.WithColdShards(afero.NewMemMapFs(), sharded.TierPolicy{DemoteAfter: time.Nanosecond}))
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestTier_Demote(t *testing.T) {
+	ctx := context.Background()
+	for _, promote := range []bool{false, true} {
+		manifestFs, hot, cold := afero.NewMemMapFs(), afero.NewMemMapFs(), afero.NewMemMapFs()
+		engine := sharded.New(manifestFs, hot, 8,
+			sharded.WithColdShards(cold, sharded.TierPolicy{DemoteAfter: time.Hour, Promote: promote}))
+		writeFile(t, engine, "read.txt", "aaaaaaaabbbbbbbb")
+		writeFile(t, engine, "idle.txt", "ccccccccdddddddd")
+		ageShards(t, hot, 2*time.Hour)
+
+		// Reading read.txt keeps its shards hot.
+		if got := readFile(t, engine, "read.txt"); got != "aaaaaaaabbbbbbbb" {
+			t.Fatalf("read.txt = %q", got)
+		}
+		stats, err := engine.Demote(ctx)
+		if err != nil {
+			t.Fatalf("Demote: %v", err)
+		}
+		if stats.Scanned != 4 || stats.Demoted != 2 || stats.Bytes != 16 {
+			t.Errorf("Demote = %+v", stats)
+		}
+		if n, m := tierShards(t, hot), tierShards(t, cold); n != 2 || m != 2 {
+			t.Errorf("hot, cold shards = %d, %d; want 2, 2", n, m)
+		}
+
+		// Cold shards are still read, and promoted if enabled.
+		if got := readFile(t, engine, "idle.txt"); got != "ccccccccdddddddd" {
+			t.Fatalf("idle.txt = %q", got)
+		}
+		want := 2
+		if promote {
+			want = 0
+		}
+		if m := tierShards(t, cold); m != want {
+			t.Errorf("promote=%v: cold shards after read = %d, want %d", promote, m, want)
+		}
+
+		// GC and Remove reach both tiers.
+		if err := engine.Remove(ctx, "idle.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := engine.GC(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if n, m := tierShards(t, hot), tierShards(t, cold); n != 2 || m != 0 {
+			t.Errorf("promote=%v: after GC hot, cold shards = %d, %d; want 2, 0", promote, n, m)
+		}
+		if err := engine.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The access times survive the engine.
+		engine = sharded.New(manifestFs, hot, 8,
+			sharded.WithColdShards(cold, sharded.TierPolicy{DemoteAfter: time.Hour, Promote: promote}))
+		if stats, err := engine.Demote(ctx); err != nil || stats.Demoted != 0 {
+			t.Errorf("Demote after reopening = %+v, %v", stats, err)
+		}
+	}
+}
+
+func TestTier_NotConfigured(t *testing.T) {
+	if _, err := newTestEngine().Demote(context.Background()); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("Demote = %v, want ErrNotSupported", err)
+	}
+}
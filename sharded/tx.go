@@ -0,0 +1,243 @@
+package sharded
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// txsDir holds the manifests staged by transactions until they are
+// published:
+//
+//	txs/<id>/<n>.json   staged manifests
+//	txs/<id>/journal    written by Commit: the manifests to publish
+//
+// Like versions, staged manifests keep their shards alive.
+const txsDir = "txs"
+
+// journalFile is the name of the commit record inside a transaction.
+const journalFile = "journal"
+
+// txJournal is the commit record of a transaction.
+type txJournal struct {
+	Files []txFile `json:"files"`
+}
+
+// txFile is a staged manifest and the logical path it is published at.
+type txFile struct {
+	Path   string `json:"path"`
+	Staged string `json:"staged"`
+}
+
+// Tx is a transaction that publishes several files all at once or not at
+// all, e.g. a data file together with its index. Files written through the
+// transaction are staged and stay invisible, also to the transaction
+// itself, until Commit.
+//
+// Commit first records the staged files in a journal, which is the commit
+// point, and then moves them into place. A crash during Commit leaves the
+// journal behind, and RecoverTransactions finishes publishing. Readers may
+// observe the files appearing one by one while Commit runs.
+//
+// A Tx is safe for concurrent use. Writes outside the transaction to the
+// same paths are not excluded; hold a lock for that (see Engine.Lock).
+type Tx struct {
+	engine *Engine
+	dir    string
+
+	mu     sync.Mutex
+	staged map[string]string // Logical path to staged manifest
+	n      int               // Number of manifests staged so far
+	done   bool
+}
+
+// BeginTx starts a transaction.
+func (e *Engine) BeginTx(ctx context.Context) (*Tx, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(txsDir, strconv.FormatInt(time.Now().UnixNano(), 36)+"-"+hex.EncodeToString(id))
+	if err := e.manifestFs.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Tx{engine: e, dir: dir, staged: make(map[string]string)}, nil
+}
+
+// Create returns a writer for path whose content is staged on Close. Writing
+// the same path again within the transaction replaces the staged file.
+func (tx *Tx) Create(ctx context.Context, path string) (sbox.WriteCloser, error) {
+	tx.mu.Lock()
+	done := tx.done
+	tx.mu.Unlock()
+	if done {
+		return nil, sbox.ErrClosed
+	}
+	if cleanPath(path) == "" {
+		return nil, sbox.ErrIsDir
+	}
+	// Without O_CREATE, OpenFile creates no manifest directory before the
+	// file is published.
+	w, err := tx.engine.OpenFile(ctx, path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sw := w.(*shardedWriter)
+	sw.stager = tx
+	return sw, nil
+}
+
+// stage records the manifest data of path in the transaction.
+func (tx *Tx) stage(path string, data []byte) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return sbox.ErrClosed
+	}
+	staged := filepath.Join(tx.dir, strconv.Itoa(tx.n)+".json")
+	tx.n++
+	if err := tx.engine.writeManifest(staged, data); err != nil {
+		return err
+	}
+	key := cleanPath(path)
+	prev, replaced := tx.staged[key]
+	tx.staged[key] = staged
+	if replaced {
+		return tx.engine.discardStaged(prev, tx.engine.manifestChunks)
+	}
+	return nil
+}
+
+// Commit publishes every staged file. Writers still open are not part of
+// the transaction; their Close fails with sbox.ErrClosed.
+func (tx *Tx) Commit(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return sbox.ErrClosed
+	}
+	tx.done = true
+	if tx.engine.closed.Load() {
+		return sbox.ErrClosed
+	}
+
+	var journal txJournal
+	for p, staged := range tx.staged {
+		journal.Files = append(journal.Files, txFile{Path: p, Staged: staged})
+	}
+	sort.Slice(journal.Files, func(i, j int) bool { return journal.Files[i].Path < journal.Files[j].Path })
+	data, err := json.Marshal(&journal)
+	if err == nil {
+		err = tx.engine.writeManifest(filepath.Join(tx.dir, journalFile), data)
+	}
+	if err != nil {
+		_ = tx.engine.discardStaged(tx.dir, tx.engine.treeChunks)
+		return err
+	}
+	return tx.engine.applyJournal(tx.dir, &journal)
+}
+
+// Rollback discards every staged file. It is a no-op after Commit.
+func (tx *Tx) Rollback(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	return tx.engine.discardStaged(tx.dir, tx.engine.treeChunks)
+}
+
+// applyJournal moves the staged manifests of a committed transaction into
+// place and removes the transaction. Manifests published before an
+// interruption are skipped, so it can be repeated.
+func (e *Engine) applyJournal(dir string, journal *txJournal) error {
+	for _, f := range journal.Files {
+		if exists, _ := afero.Exists(e.manifestFs, f.Staged); !exists {
+			continue
+		}
+		mPath := e.manifestPath(f.Path)
+		if err := e.manifestFs.MkdirAll(filepath.Dir(mPath), 0755); err != nil {
+			return err
+		}
+		// The staged manifest already holds its references.
+		err := e.replaceManifest(mPath, nil, func() error {
+			return e.manifestFs.Rename(f.Staged, mPath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return e.manifestFs.RemoveAll(dir)
+}
+
+// discardStaged removes the staged manifests at p, a file or a
+// transaction directory, and releases their references.
+func (e *Engine) discardStaged(p string, load func(string) ([]string, error)) error {
+	chunks, err := e.refChunks(load, p)
+	if err != nil {
+		return err
+	}
+	if err := e.manifestFs.RemoveAll(p); err != nil {
+		return err
+	}
+	return e.adjustRefs(nil, chunks)
+}
+
+// RecoverTransactions finishes publishing transactions whose Commit was
+// interrupted, e.g. by a crash, and discards the staged files of those
+// never committed. Like GC, it must not run while transactions are in
+// progress; run it at startup. It returns the number of transactions
+// recovered or discarded.
+func (e *Engine) RecoverTransactions(ctx context.Context) (int, error) {
+	if e.closed.Load() {
+		return 0, sbox.ErrClosed
+	}
+	entries, err := afero.ReadDir(e.manifestFs, txsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(txsDir, entry.Name())
+		data, err := afero.ReadFile(e.manifestFs, filepath.Join(dir, journalFile))
+		switch {
+		case err == nil:
+			var journal txJournal
+			if err := json.Unmarshal(data, &journal); err != nil {
+				return n, err
+			}
+			err = e.applyJournal(dir, &journal)
+		case os.IsNotExist(err):
+			err = e.discardStaged(dir, e.treeChunks)
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,152 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sharded"
+)
+
+// txWrite writes content to path within tx.
+func txWrite(t *testing.T, tx *sharded.Tx, path, content string) {
+	t.Helper()
+	w, err := tx.Create(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Create %s: %v", path, err)
+	}
+	_, _ = io.WriteString(w, content)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close %s: %v", path, err)
+	}
+}
+
+func TestTx_Commit(t *testing.T) {
+	ctx := context.Background()
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+	writeFile(t, engine, "index", "old index")
+
+	tx, err := engine.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	txWrite(t, tx, "data/part-1", "first draft")
+	txWrite(t, tx, "data/part-1", "records")
+	txWrite(t, tx, "index", "new index")
+
+	if _, err := engine.Stat(ctx, "data"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("staged directory visible before Commit: %v", err)
+	}
+	if got := readFile(t, engine, "index"); got != "old index" {
+		t.Errorf("index before Commit = %q", got)
+	}
+
+	open, err := tx.Create(ctx, "late")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got := readFile(t, engine, "data/part-1"); got != "records" {
+		t.Errorf("data/part-1 = %q", got)
+	}
+	if got := readFile(t, engine, "index"); got != "new index" {
+		t.Errorf("index = %q", got)
+	}
+	if err := open.Close(); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("Close after Commit = %v, want %v", err, sbox.ErrClosed)
+	}
+	if err := tx.Commit(ctx); !errors.Is(err, sbox.ErrClosed) {
+		t.Errorf("second Commit = %v, want %v", err, sbox.ErrClosed)
+	}
+}
+
+func TestTx_Rollback(t *testing.T) {
+	ctx := context.Background()
+	shardsFs := afero.NewMemMapFs()
+	engine := newRefcountEngine(afero.NewMemMapFs(), shardsFs)
+
+	tx, err := engine.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	txWrite(t, tx, "a.txt", "staged content")
+	if n := shardCount(t, shardsFs); n == 0 {
+		t.Fatal("no shards stored while staging")
+	}
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if _, err := engine.Stat(ctx, "a.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat after Rollback = %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 0 {
+		t.Errorf("shards after Rollback = %d, want 0", n)
+	}
+}
+
+func TestTx_Recover(t *testing.T) {
+	ctx := context.Background()
+	manifestFs := &failingRenameFs{Fs: afero.NewMemMapFs(), fail: "manifests/b.txt.json"}
+	engine := sharded.New(manifestFs, afero.NewMemMapFs(), 4)
+
+	// A commit interrupted after its journal was written is finished.
+	tx, err := engine.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	txWrite(t, tx, "a.txt", "alpha")
+	txWrite(t, tx, "b.txt", "bravo")
+	if err := tx.Commit(ctx); err == nil {
+		t.Fatal("Commit succeeded")
+	}
+	if _, err := engine.Stat(ctx, "b.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Fatalf("b.txt published: %v", err)
+	}
+
+	// A transaction that never committed is discarded, but GC keeps its
+	// shards until then.
+	pending, err := engine.BeginTx(ctx)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	txWrite(t, pending, "c.txt", "charlie")
+	if stats, err := engine.GC(ctx); err != nil || stats.Deleted != 0 {
+		t.Errorf("GC = %+v, %v", stats, err)
+	}
+
+	manifestFs.fail = ""
+	n, err := engine.RecoverTransactions(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("RecoverTransactions = %d, %v", n, err)
+	}
+	if got := readFile(t, engine, "b.txt"); got != "bravo" {
+		t.Errorf("b.txt = %q", got)
+	}
+	if _, err := engine.Stat(ctx, "c.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("uncommitted c.txt: %v", err)
+	}
+	if n, err := engine.RecoverTransactions(ctx); err != nil || n != 0 {
+		t.Errorf("second RecoverTransactions = %d, %v", n, err)
+	}
+}
+
+// failingRenameFs fails renames onto one path.
+type failingRenameFs struct {
+	afero.Fs
+	fail string
+}
+
+func (f *failingRenameFs) Rename(oldname, newname string) error {
+	if f.fail != "" && strings.HasSuffix(newname, f.fail) {
+		return os.ErrPermission
+	}
+	return f.Fs.Rename(oldname, newname)
+}
@@ -0,0 +1,199 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// VerifyOptions controls a Verify run.
+type VerifyOptions struct {
+	// Replica, if set, is used to repair missing and corrupt shards: a good
+	// copy of each damaged shard is read from the replica's shard store and
+	// written to this engine's.
+	Replica *Engine
+
+	// Progress, if set, is called after every manifest is checked with a
+	// snapshot of the report gathered so far.
+	Progress func(report VerifyReport)
+}
+
+// VerifyReport describes the outcome of a Verify run. Shard lists hold
+// hashes and, like the file lists, are sorted.
+type VerifyReport struct {
+	Manifests int // Manifests checked, including snapshots and versions
+	Shards    int // Distinct shards checked
+
+	Missing  []string // Shards that do not exist
+	Corrupt  []string // Shards whose content does not match their hash
+	Repaired []string // Missing or corrupt shards restored from the replica
+
+	// Files lists logical paths of files that reference a missing or
+	// corrupt shard that was not repaired. History lists the manifests of
+	// snapshots and versions in the same state.
+	Files   []string
+	History []string
+
+	// CorruptManifests lists manifests that cannot be decoded or fail their
+	// checksum.
+	CorruptManifests []string
+}
+
+// OK reports whether the run found no damage left unrepaired.
+func (r *VerifyReport) OK() bool {
+	return len(r.Files) == 0 && len(r.History) == 0 && len(r.CorruptManifests) == 0
+}
+
+// Verify walks every manifest, including those held by snapshots and
+// versions, confirms that each referenced shard exists and re-hashes its
+// content against its name. Damage is reported rather than returned as an
+// error; the error is reserved for failures that stop the run, such as
+// cancellation of ctx, in which case the report gathered so far is returned
+// with it. Every manifest checked is also reported as the "verify"
+// operation to the sbox.Progress attached to ctx.
+func (e *Engine) Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	report := &VerifyReport{}
+	good := make(map[string]bool)
+	tracker := sbox.NewProgressTracker(ctx, "verify")
+
+	for _, root := range []string{"manifests", snapshotsDir, versionsDir} {
+		err := afero.Walk(e.manifestFs, root, func(p string, info os.FileInfo, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				if strings.HasPrefix(info.Name(), snapshotTmpPrefix) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(p, ".json") || info.Name() == snapshotInfoFile {
+				return nil
+			}
+			if err := e.verifyManifest(p, opts.Replica, good, report); err != nil {
+				return err
+			}
+			tracker.Advance(p, 0, 1)
+			if opts.Progress != nil {
+				opts.Progress(*report)
+			}
+			return nil
+		})
+		if err != nil {
+			report.sort()
+			return report, err
+		}
+	}
+	report.sort()
+	return report, nil
+}
+
+// verifyManifest checks the shards of the manifest at mPath. good caches
+// the outcome for shards already checked.
+func (e *Engine) verifyManifest(mPath string, replica *Engine, good map[string]bool, report *VerifyReport) error {
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	if err != nil {
+		return err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		if errors.Is(err, sbox.ErrCorruptManifest) {
+			report.CorruptManifests = append(report.CorruptManifests, mPath)
+			return nil
+		}
+		return err
+	}
+	report.Manifests++
+
+	damaged := false
+	for i, hash := range m.Chunks {
+		if hash == sbox.HoleChunk {
+			continue
+		}
+		ok, checked := good[hash]
+		if !checked {
+			var key string
+			if i < len(m.Keys) {
+				key = m.Keys[i]
+			}
+			compressed := i < len(m.Compressed) && m.Compressed[i]
+			if ok, err = e.checkShard(hash, key, compressed, replica, report); err != nil {
+				return err
+			}
+			good[hash] = ok
+			report.Shards++
+		}
+		damaged = damaged || !ok
+	}
+	if damaged {
+		if rel, live := strings.CutPrefix(mPath, "manifests"+string(filepath.Separator)); live {
+			report.Files = append(report.Files, filepath.ToSlash(strings.TrimSuffix(rel, ".json")))
+		} else {
+			report.History = append(report.History, mPath)
+		}
+	}
+	return nil
+}
+
+// checkShard checks one shard, repairing it from replica if it is damaged,
+// and reports whether a good copy is now in place.
+func (e *Engine) checkShard(hash, key string, compressed bool, replica *Engine, report *VerifyReport) (bool, error) {
+	check := e.shardCheck(hash, key, compressed)
+
+	shardPath := e.shardPath(hash)
+	data, err := afero.ReadFile(e.shardsFs, shardPath)
+	switch {
+	case err == nil:
+		if _, _, ok := check(data); ok {
+			return true, nil
+		}
+		report.Corrupt = append(report.Corrupt, hash)
+	case os.IsNotExist(err):
+		report.Missing = append(report.Missing, hash)
+	default:
+		return false, err
+	}
+	if replica == nil {
+		return false, nil
+	}
+
+	var stored []byte
+	ok := false
+	if data, err = afero.ReadFile(replica.shardsFs, replica.shardPath(hash)); err == nil {
+		_, stored, ok = check(data)
+	}
+	if !ok {
+		e.logger.Warn("sbox/sharded: no good replica found for shard", "hash", hash)
+		return false, nil
+	}
+	if err := e.shardsFs.MkdirAll(filepath.Dir(shardPath), 0755); err != nil {
+		return false, err
+	}
+	if err := afero.WriteFile(e.shardsFs, shardPath, stored, 0644); err != nil {
+		return false, err
+	}
+	report.Repaired = append(report.Repaired, hash)
+	return true, nil
+}
+
+func (r *VerifyReport) sort() {
+	for _, s := range [][]string{r.Missing, r.Corrupt, r.Repaired, r.Files, r.History, r.CorruptManifests} {
+		sort.Strings(s)
+	}
+}
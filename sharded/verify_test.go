@@ -0,0 +1,81 @@
+package sharded_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, shardsFs, sharded.DefaultChunkSize, quietLogger)
+
+	writeFile(t, engine, "ok.txt", "healthy file")
+	writeFile(t, engine, "rotten.txt", "this shard will rot")
+	writeFile(t, engine, "lost.txt", "this shard will vanish")
+	if err := engine.Snapshot(ctx, "snap"); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	report, err := engine.Verify(ctx, sharded.VerifyOptions{})
+	if err != nil || !report.OK() {
+		t.Fatalf("Verify healthy store = %+v, %v", report, err)
+	}
+	if report.Manifests != 6 || report.Shards != 3 {
+		t.Errorf("checked %d manifests and %d shards, want 6 and 3", report.Manifests, report.Shards)
+	}
+
+	_ = afero.WriteFile(shardsFs, contentShardPath("this shard will rot"), []byte("garbage"), 0644)
+	_ = shardsFs.Remove(contentShardPath("this shard will vanish"))
+	_ = afero.WriteFile(manifestFs, "manifests/ok.txt.json", []byte("{"), 0644)
+
+	report, err = engine.Verify(ctx, sharded.VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(report.Corrupt) != 1 || len(report.Missing) != 1 {
+		t.Errorf("corrupt %v, missing %v; want one of each", report.Corrupt, report.Missing)
+	}
+	if !slices.Equal(report.Files, []string{"lost.txt", "rotten.txt"}) {
+		t.Errorf("Files = %v", report.Files)
+	}
+	if len(report.History) != 2 {
+		t.Errorf("History = %v, want both snapshot manifests", report.History)
+	}
+	if !slices.Equal(report.CorruptManifests, []string{"manifests/ok.txt.json"}) {
+		t.Errorf("CorruptManifests = %v", report.CorruptManifests)
+	}
+}
+
+func TestVerify_Repair(t *testing.T) {
+	ctx := context.Background()
+	manifestFs, shardsFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, shardsFs, 64, quietLogger)
+	replica := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64, sharded.WithCompression(sharded.CompressionZstd))
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	content := a + b + "tail"
+
+	writeFile(t, engine, "f.txt", content)
+	writeFile(t, replica, "f.txt", content)
+	_ = afero.WriteFile(shardsFs, contentShardPath(a), []byte("garbage"), 0644)
+	_ = shardsFs.Remove(contentShardPath(b))
+
+	report, err := engine.Verify(ctx, sharded.VerifyOptions{Replica: replica})
+	if err != nil || !report.OK() {
+		t.Fatalf("Verify = %+v, %v", report, err)
+	}
+	if len(report.Repaired) != 2 {
+		t.Errorf("Repaired = %v, want 2 shards", report.Repaired)
+	}
+	// The repaired shards are stored uncompressed, as the manifest expects,
+	// even though the replica compressed them.
+	if got := readFile(t, engine, "f.txt"); got != content {
+		t.Errorf("read after repair = %q", got)
+	}
+}
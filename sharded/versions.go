@@ -0,0 +1,277 @@
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+)
+
+// versionsDir holds previous manifests in the manifest filesystem, one
+// directory per logical path:
+//
+//	versions/<path>/<id>.json
+//
+// Like snapshots, versions share shards with the live tree.
+const versionsDir = "versions"
+
+// versionIDFormat produces fixed-width IDs that sort chronologically.
+const versionIDFormat = "20060102T150405.000000000Z"
+
+// versionDirOf returns the version directory for the manifest at mPath.
+func versionDirOf(mPath string) string {
+	rel := strings.TrimPrefix(mPath, "manifests"+string(filepath.Separator))
+	return filepath.Join(versionsDir, strings.TrimSuffix(rel, ".json"))
+}
+
+// previousManifest returns the manifest stored at mPath so that it can be
+// kept as a version once it is replaced. It returns nil when versioning is
+// disabled or no manifest exists.
+func (e *Engine) previousManifest(mPath string) []byte {
+	if e.versions <= 0 {
+		return nil
+	}
+	data, err := afero.ReadFile(e.manifestFs, mPath)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// previousTree returns every manifest below mDir, keyed by manifest path,
+// when versioning is enabled.
+func (e *Engine) previousTree(mDir string) (map[string][]byte, error) {
+	if e.versions <= 0 {
+		return nil, nil
+	}
+	tree := make(map[string][]byte)
+	err := afero.Walk(e.manifestFs, mDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		data, err := afero.ReadFile(e.manifestFs, p)
+		tree[p] = data
+		return err
+	})
+	return tree, err
+}
+
+// supersede keeps old, the manifest that was just replaced or removed at
+// mPath, as a version and prunes versions beyond the configured limit. It
+// returns the chunks whose references must now be released: replaced when
+// versioning is disabled, otherwise those of pruned versions. The returned
+// chunks are correct even when an error is returned.
+func (e *Engine) supersede(mPath string, old []byte, replaced []string) ([]string, error) {
+	if old == nil {
+		return replaced, nil
+	}
+	dir := versionDirOf(mPath)
+	err := e.manifestFs.MkdirAll(dir, 0755)
+	if err == nil {
+		err = e.writeManifest(filepath.Join(dir, e.newVersionID(dir)+".json"), old)
+	}
+	if err != nil {
+		var m sbox.Manifest
+		_ = sbox.UnmarshalManifest(old, &m)
+		return m.Chunks, err
+	}
+	return e.pruneVersions(dir)
+}
+
+// newVersionID returns an unused version ID in dir based on the current
+// time.
+func (e *Engine) newVersionID(dir string) string {
+	t := time.Now().UTC()
+	for {
+		id := t.Format(versionIDFormat)
+		if exists, _ := afero.Exists(e.manifestFs, filepath.Join(dir, id+".json")); !exists {
+			return id
+		}
+		t = t.Add(time.Nanosecond)
+	}
+}
+
+// versionIDs returns the IDs of the versions in dir, oldest first.
+func (e *Engine) versionIDs(dir string) ([]string, error) {
+	entries, err := afero.ReadDir(e.manifestFs, dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, ".json") {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// pruneVersions removes the oldest versions in dir beyond the configured
+// limit and returns their chunks.
+func (e *Engine) pruneVersions(dir string) ([]string, error) {
+	ids, err := e.versionIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var released []string
+	for _, id := range ids[:max(len(ids)-e.versions, 0)] {
+		p := filepath.Join(dir, id+".json")
+		chunks, err := e.refChunks(e.manifestChunks, p)
+		if err != nil {
+			return released, err
+		}
+		if err := e.manifestFs.Remove(p); err != nil {
+			return released, err
+		}
+		released = append(released, chunks...)
+	}
+	return released, nil
+}
+
+// versionPath returns the manifest path of a version of path.
+func (e *Engine) versionPath(path, versionID string) (string, error) {
+	if e.versions <= 0 {
+		return "", sbox.ErrNotSupported
+	}
+	if versionID == "" || strings.HasPrefix(versionID, ".") || strings.ContainsAny(versionID, `/\`) {
+		return "", fmt.Errorf("sbox/sharded: invalid version ID %q: %w", versionID, sbox.ErrInvalid)
+	}
+	p := filepath.Join(versionDirOf(e.manifestPath(path)), versionID+".json")
+	if exists, _ := afero.Exists(e.manifestFs, p); !exists {
+		return "", fmt.Errorf("sbox/sharded: version %s of %s: %w", versionID, path, sbox.ErrNotFound)
+	}
+	return p, nil
+}
+
+// === Extension: Versioner ===
+
+// ListVersions returns the previous versions of path, newest first.
+func (e *Engine) ListVersions(ctx context.Context, path string) ([]sbox.VersionInfo, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	if e.versions <= 0 {
+		return nil, sbox.ErrNotSupported
+	}
+	dir := versionDirOf(e.manifestPath(path))
+	ids, err := e.versionIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]sbox.VersionInfo, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		data, err := afero.ReadFile(e.manifestFs, filepath.Join(dir, ids[i]+".json"))
+		if err != nil {
+			return nil, err
+		}
+		var m sbox.Manifest
+		if err := sbox.UnmarshalManifest(data, &m); err != nil {
+			return nil, err
+		}
+		result = append(result, sbox.VersionInfo{ID: ids[i], Size: m.Size, ModTime: m.ModTime})
+	}
+	return result, nil
+}
+
+// OpenVersion opens a previous version of path for reading.
+func (e *Engine) OpenVersion(ctx context.Context, path, versionID string) (sbox.ReadSeekCloser, error) {
+	if e.closed.Load() {
+		return nil, sbox.ErrClosed
+	}
+	vPath, err := e.versionPath(path, versionID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(e.manifestFs, vPath)
+	if err != nil {
+		return nil, err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return nil, err
+	}
+	return newShardedReader(ctx, e, m), nil
+}
+
+// RestoreVersion makes a previous version the current content of path,
+// recreating the file if it was removed. The content being replaced is
+// kept as a new version, so a restore can itself be undone.
+func (e *Engine) RestoreVersion(ctx context.Context, path, versionID string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	vPath, err := e.versionPath(path, versionID)
+	if err != nil {
+		return err
+	}
+	data, err := afero.ReadFile(e.manifestFs, vPath)
+	if err != nil {
+		return err
+	}
+	var m sbox.Manifest
+	if err := sbox.UnmarshalManifest(data, &m); err != nil {
+		return err
+	}
+	m.ModTime = time.Now()
+	if data, err = e.marshalManifest(&m); err != nil {
+		return err
+	}
+
+	mPath := e.manifestPath(path)
+	if err := e.manifestFs.MkdirAll(filepath.Dir(mPath), 0755); err != nil {
+		return err
+	}
+	added, err := e.refChunks(func(string) ([]string, error) { return m.Chunks, nil }, vPath)
+	if err != nil {
+		return err
+	}
+	replaced, err := e.refChunks(e.manifestChunks, mPath)
+	if err != nil {
+		return err
+	}
+	old := e.previousManifest(mPath)
+	if err := e.writeManifest(mPath, data); err != nil {
+		return err
+	}
+	release, err := e.supersede(mPath, old, replaced)
+	if rerr := e.adjustRefs(added, release); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// DeleteVersion permanently removes a previous version of path.
+func (e *Engine) DeleteVersion(ctx context.Context, path, versionID string) error {
+	if e.closed.Load() {
+		return sbox.ErrClosed
+	}
+	vPath, err := e.versionPath(path, versionID)
+	if err != nil {
+		return err
+	}
+	chunks, err := e.refChunks(e.manifestChunks, vPath)
+	if err != nil {
+		return err
+	}
+	if err := e.manifestFs.Remove(vPath); err != nil {
+		return err
+	}
+	return e.adjustRefs(nil, chunks)
+}
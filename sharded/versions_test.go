@@ -0,0 +1,141 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func newVersionedEngine(shardsFs afero.Fs, n int, opts ...sharded.Option) *sharded.Engine {
+	return sharded.New(afero.NewMemMapFs(), shardsFs, 8, append(opts, sharded.WithVersions(n))...)
+}
+
+func readVersion(t *testing.T, engine *sharded.Engine, path, id string) string {
+	t.Helper()
+	r, err := engine.OpenVersion(context.Background(), path, id)
+	if err != nil {
+		t.Fatalf("OpenVersion %s@%s: %v", path, id, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return string(data)
+}
+
+func TestVersions_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, newVersionedEngine(afero.NewMemMapFs(), 3))
+}
+
+func TestVersions_Rollback(t *testing.T) {
+	engine := newVersionedEngine(afero.NewMemMapFs(), 2)
+	ctx := context.Background()
+
+	writeFile(t, engine, "f.txt", "one")
+	writeFile(t, engine, "f.txt", "two")
+	writeFile(t, engine, "f.txt", "three")
+	writeFile(t, engine, "f.txt", "four")
+
+	versions, err := engine.ListVersions(ctx, "f.txt")
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("versions = %+v, want 2", versions)
+	}
+	if got := readVersion(t, engine, "f.txt", versions[0].ID); got != "three" {
+		t.Errorf("newest version = %q", got)
+	}
+	if got := readVersion(t, engine, "f.txt", versions[1].ID); got != "two" {
+		t.Errorf("oldest version = %q", got)
+	}
+	if versions[1].Size != 3 {
+		t.Errorf("oldest version size = %d", versions[1].Size)
+	}
+
+	if err := engine.RestoreVersion(ctx, "f.txt", versions[1].ID); err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	if got := readFile(t, engine, "f.txt"); got != "two" {
+		t.Errorf("restored content = %q", got)
+	}
+	// The replaced content became a version itself.
+	versions, _ = engine.ListVersions(ctx, "f.txt")
+	if got := readVersion(t, engine, "f.txt", versions[0].ID); got != "four" {
+		t.Errorf("newest version after restore = %q", got)
+	}
+
+	if err := engine.DeleteVersion(ctx, "f.txt", versions[0].ID); err != nil {
+		t.Fatalf("DeleteVersion: %v", err)
+	}
+	if _, err := engine.OpenVersion(ctx, "f.txt", versions[0].ID); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("OpenVersion deleted = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestVersions_Undelete(t *testing.T) {
+	engine := newVersionedEngine(afero.NewMemMapFs(), 5)
+	ctx := context.Background()
+
+	writeFile(t, engine, "dir/a.txt", "precious")
+	if err := engine.Remove(ctx, "dir"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	versions, err := engine.ListVersions(ctx, "dir/a.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("ListVersions after Remove: %+v, %v", versions, err)
+	}
+	if err := engine.RestoreVersion(ctx, "dir/a.txt", versions[0].ID); err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	if got := readFile(t, engine, "dir/a.txt"); got != "precious" {
+		t.Errorf("undeleted content = %q", got)
+	}
+}
+
+func TestVersions_GCAndRefcount(t *testing.T) {
+	ctx := context.Background()
+
+	shardsFs := afero.NewMemMapFs()
+	engine := newVersionedEngine(shardsFs, 1)
+	writeFile(t, engine, "f.txt", "old data")
+	writeFile(t, engine, "f.txt", "new data")
+	if stats, err := engine.GC(ctx); err != nil || stats.Deleted != 0 {
+		t.Errorf("GC with versions: stats=%+v err=%v", stats, err)
+	}
+
+	shardsFs = afero.NewMemMapFs()
+	engine = newVersionedEngine(shardsFs, 1, sharded.WithRefcount(true))
+	writeFile(t, engine, "f.txt", "aaaaaaaa")
+	writeFile(t, engine, "f.txt", "bbbbbbbb")
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Errorf("shards with one version = %d, want 2", n)
+	}
+	// Pruning the oldest version releases its shard.
+	writeFile(t, engine, "f.txt", "cccccccc")
+	if n := shardCount(t, shardsFs); n != 2 {
+		t.Errorf("shards after pruning = %d, want 2", n)
+	}
+	versions, _ := engine.ListVersions(ctx, "f.txt")
+	if err := engine.DeleteVersion(ctx, "f.txt", versions[0].ID); err != nil {
+		t.Fatalf("DeleteVersion: %v", err)
+	}
+	if n := shardCount(t, shardsFs); n != 1 {
+		t.Errorf("shards after DeleteVersion = %d, want 1", n)
+	}
+}
+
+func TestVersions_Disabled(t *testing.T) {
+	engine := newTestEngine()
+	if _, err := engine.ListVersions(context.Background(), "f.txt"); !errors.Is(err, sbox.ErrNotSupported) {
+		t.Errorf("ListVersions = %v, want %v", err, sbox.ErrNotSupported)
+	}
+}
@@ -1,12 +1,17 @@
 package sharded
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/spf13/afero"
@@ -16,7 +21,10 @@ import (
 
 // shardedWriter implements sbox.WriteSeekCloser for sharded storage.
 // It accumulates data into chunks, hashes them, and writes unique shards.
+// Once the context it was opened with is done, writes fail with the
+// context error and Close discards the file.
 type shardedWriter struct {
+	ctx        context.Context
 	engine     *Engine
 	path       string
 	hashes     []string
@@ -24,10 +32,50 @@ type shardedWriter struct {
 	size       int64
 	buffer     []byte
 	pbuf       *[]byte
+	inherited  int // Leading entries of hashes loaded from an appended manifest
+	metadata   map[string]string
+	perms      permissions
+	ctype      string                   // Content type, unless sniffed
+	sniffer    *sbox.ContentTypeSniffer // Detects the content type, if asked
+	cond       *sbox.Precondition       // Checked on Close, if set
+	stager     stager                   // Stages the file instead of publishing it, if set
+	journal    *writeJournal            // Started when the first shard is stored
+
+	compressed  []bool   // Per-chunk compression flags
+	storedSizes []int64  // Per-chunk stored shard sizes
+	keys        []string // Per-chunk wrapped keys ("" if not encrypted)
+
+	// content hashes everything written; nil when appending to existing
+	// content, whose hash would require reading it back.
+	content hash.Hash
+
+	// checksum is the SHA-256 the written data must have, if given with
+	// sbox.WithChecksum; check hashes appended data for it.
+	checksum string
+	check    hash.Hash
+
+	// Background chunk writes, with write concurrency.
+	pending []*pendingChunk
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error // First background write error
 }
 
 func (w *shardedWriter) Write(p []byte) (n int, err error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
 	total := len(p)
+	if w.content != nil {
+		w.content.Write(p)
+	}
+	if w.sniffer != nil {
+		w.sniffer.Write(p)
+	}
+	if w.check != nil {
+		w.check.Write(p)
+	}
 	for len(p) > 0 {
 		space := int(w.engine.chunkSize) - len(w.buffer)
 		if space > len(p) {
@@ -45,33 +93,231 @@ func (w *shardedWriter) Write(p []byte) (n int, err error) {
 	return total, nil
 }
 
+// flush stores the buffered data as one chunk or, with content-defined
+// chunking, stores the first chunk found in the buffer and keeps the rest.
 func (w *shardedWriter) flush() error {
 	if len(w.buffer) == 0 {
 		return nil
 	}
+	if w.engine.cdc == nil {
+		if err := w.storeChunk(w.buffer); err != nil {
+			return err
+		}
+		w.buffer = w.buffer[:0]
+		return nil
+	}
+	n := w.engine.cdc.cut(w.buffer)
+	if err := w.storeChunk(w.buffer[:n]); err != nil {
+		return err
+	}
+	w.buffer = w.buffer[:copy(w.buffer, w.buffer[n:])]
+	return nil
+}
 
-	hash := sha256.Sum256(w.buffer)
-	hashStr := hex.EncodeToString(hash[:])
-	shardPath := w.engine.shardPath(hashStr)
+// flushAll stores everything left in the buffer.
+func (w *shardedWriter) flushAll() error {
+	for len(w.buffer) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stager receives the manifest of a file written in a transaction or as a
+// part of a multipart upload, instead of it being published.
+type stager interface {
+	stage(path string, data []byte) error
+}
 
-	if err := w.engine.shardsFs.MkdirAll(filepath.Dir(shardPath), 0755); err != nil {
+// storedChunk describes a chunk written to the shard store.
+type storedChunk struct {
+	hash       string
+	size       int64
+	compressed bool
+	stored     int64  // Size of the stored shard
+	key        string // Wrapped key, "" if not encrypted
+}
+
+// pendingChunk is a chunk being stored by a background worker. Its entry in
+// the writer's chunk lists is filled in by wait.
+type pendingChunk struct {
+	idx   int
+	chunk storedChunk
+	err   error
+}
+
+// storeChunk appends data to the manifest being built and writes it as a
+// shard. With write concurrency, the shard is written in the background and
+// data may be reused as soon as storeChunk returns.
+func (w *shardedWriter) storeChunk(data []byte) error {
+	if err := w.ctx.Err(); err != nil {
 		return err
 	}
-
-	// Content-addressed: skip write if shard already exists (dedup)
-	exists, _ := afero.Exists(w.engine.shardsFs, shardPath)
-	if !exists {
-		if err := afero.WriteFile(w.engine.shardsFs, shardPath, w.buffer, 0644); err != nil {
+	j, err := w.engine.startJournal(&w.journal, w.path)
+	if err != nil {
+		return err
+	}
+	if w.engine.writeConcurrency <= 1 {
+		c, err := w.engine.storeChunk(data, j)
+		if err != nil {
 			return err
 		}
+		w.appendChunk(c)
+		return nil
+	}
+	if err := w.asyncErr(); err != nil {
+		return err
 	}
 
-	w.hashes = append(w.hashes, hashStr)
-	w.chunkSizes = append(w.chunkSizes, int64(len(w.buffer)))
-	w.buffer = w.buffer[:0]
+	if w.sem == nil {
+		w.sem = make(chan struct{}, w.engine.writeConcurrency)
+	}
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	buf := w.engine.bufferPool.Get().(*[]byte)
+	*buf = append((*buf)[:0], data...)
+	pc := &pendingChunk{idx: len(w.hashes)}
+	w.appendChunk(storedChunk{size: int64(len(data))})
+	w.pending = append(w.pending, pc)
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			w.engine.bufferPool.Put(buf)
+			<-w.sem
+			w.wg.Done()
+		}()
+		pc.chunk, pc.err = w.engine.storeChunk(*buf, j)
+		if pc.err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = pc.err
+			}
+			w.mu.Unlock()
+		}
+	}()
 	return nil
 }
 
+// asyncErr returns the first error of a background chunk write.
+func (w *shardedWriter) asyncErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// wait waits for background chunk writes and records their results. It
+// returns the first error; the entries of failed chunks stay empty.
+func (w *shardedWriter) wait() error {
+	w.wg.Wait()
+	for _, pc := range w.pending {
+		if pc.err == nil {
+			w.hashes[pc.idx] = pc.chunk.hash
+			w.compressed[pc.idx] = pc.chunk.compressed
+			w.storedSizes[pc.idx] = pc.chunk.stored
+			w.keys[pc.idx] = pc.chunk.key
+		}
+	}
+	w.pending = nil
+	return w.asyncErr()
+}
+
+func (w *shardedWriter) appendChunk(c storedChunk) {
+	w.hashes = append(w.hashes, c.hash)
+	w.chunkSizes = append(w.chunkSizes, c.size)
+	w.compressed = append(w.compressed, c.compressed)
+	w.storedSizes = append(w.storedSizes, c.stored)
+	w.keys = append(w.keys, c.key)
+}
+
+// isZero reports whether data holds only zero bytes.
+func isZero(data []byte) bool {
+	var zero [4096]byte
+	for len(data) > 0 {
+		n := min(len(data), len(zero))
+		if !bytes.Equal(data[:n], zero[:n]) {
+			return false
+		}
+		data = data[n:]
+	}
+	return true
+}
+
+// storeChunk writes data as a shard, unless an identical one exists, and
+// records it in the journal j first. With sparse files, chunks of zeros
+// are holes and not stored.
+func (e *Engine) storeChunk(data []byte, j *writeJournal) (storedChunk, error) {
+	c := storedChunk{size: int64(len(data))}
+	if e.sparse && isZero(data) {
+		c.hash = sbox.HoleChunk
+		return c, nil
+	}
+	var store func() error
+
+	if x := e.encryptor; x != nil {
+		// Encrypted shards are addressed by the hash of the ciphertext, so
+		// the shard name reveals nothing about the content, and an
+		// existing shard with the same name is exactly what would be
+		// written.
+		out, ok := e.compressChunk(data)
+		sealed, wrapped, err := x.seal(out)
+		if err != nil {
+			return c, err
+		}
+		c.hash, c.key, c.compressed, c.stored = shardHash(sealed), wrapped, ok, int64(len(sealed))
+		shardPath := e.shardPath(c.hash)
+		store = func() error {
+			if exists, _ := afero.Exists(e.shardsFs, shardPath); exists {
+				return nil
+			}
+			if err := e.writeFile(e.shardsFs, shardPath, sealed); err != nil {
+				return err
+			}
+			return e.syncDirs(e.shardsFs, filepath.Dir(shardPath))
+		}
+	} else {
+		// Content-addressed: skip write if shard already exists (dedup). A
+		// stored shard is compressed exactly when it is smaller than its
+		// chunk, which also holds for shards written by engines with other
+		// settings.
+		c.hash = shardHash(data)
+		shardPath := e.shardPath(c.hash)
+		store = func() error {
+			if info, err := e.shardsFs.Stat(shardPath); err == nil {
+				c.stored = info.Size()
+				c.compressed = c.stored < c.size
+				return nil
+			}
+			out, ok := e.compressChunk(data)
+			c.compressed, c.stored = ok, int64(len(out))
+			if err := e.writeFile(e.shardsFs, shardPath, out); err != nil {
+				return err
+			}
+			return e.syncDirs(e.shardsFs, filepath.Dir(shardPath))
+		}
+	}
+
+	if err := j.record(c.hash); err != nil {
+		return c, err
+	}
+	if err := e.shardsFs.MkdirAll(filepath.Dir(e.shardPath(c.hash)), 0755); err != nil {
+		return c, err
+	}
+	ix, err := e.refs()
+	if err != nil {
+		return c, err
+	}
+	if ix != nil {
+		err = ix.store(c.hash, store)
+	} else {
+		err = store()
+	}
+	return c, err
+}
+
 func (w *shardedWriter) Seek(offset int64, whence int) (int64, error) {
 	// Only support seeking to current end (for append/TUS compatibility)
 	if whence == io.SeekStart && offset == w.size {
@@ -84,7 +330,19 @@ func (w *shardedWriter) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (w *shardedWriter) Close() error {
-	if err := w.flush(); err != nil {
+	if err := w.ctx.Err(); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	err := w.flushAll()
+	if werr := w.wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return err
+	}
+	if err := w.verify(); err != nil {
+		_ = w.Abort()
 		return err
 	}
 
@@ -93,28 +351,185 @@ func (w *shardedWriter) Close() error {
 		ChunkSizes: w.chunkSizes,
 		Size:       w.size,
 		ModTime:    time.Now(),
+		CreatedBy:  createdBy,
+		Metadata:   w.metadata,
+	}
+	w.perms.apply(&manifest)
+	manifest.ContentType = w.ctype
+	if w.sniffer != nil {
+		manifest.ContentType = w.sniffer.ContentType()
+	}
+	if w.content != nil {
+		manifest.Hash = hex.EncodeToString(w.content.Sum(nil))
+	}
+	if slices.Contains(w.compressed, true) {
+		manifest.Compressed = w.compressed
+		manifest.StoredSizes = w.storedSizes
+	}
+	if slices.ContainsFunc(w.keys, func(k string) bool { return k != "" }) {
+		manifest.Keys = w.keys
 	}
 
-	data, err := json.Marshal(manifest)
+	data, err := w.engine.marshalManifest(&manifest)
 	if err != nil {
 		return err
 	}
 
+	if w.stager != nil {
+		if err := w.stager.stage(w.path, data); err != nil {
+			_ = w.Abort()
+			return err
+		}
+		w.release()
+		// The staged manifest now keeps the shards alive.
+		err := w.journal.done()
+		w.journal = nil
+		return err
+	}
+
 	mPath := w.engine.manifestPath(w.path)
 	if mkdirErr := w.engine.manifestFs.MkdirAll(filepath.Dir(mPath), 0750); mkdirErr != nil {
 		return mkdirErr
 	}
 
-	err = afero.WriteFile(w.engine.manifestFs, mPath, data, 0644)
+	if w.cond == nil {
+		err = w.publish(mPath, data)
+		w.release()
+		return err
+	}
 
-	// Return buffer to pool
+	// Conditional writers check and publish under a lock.
+	published := false
+	err = sbox.WithLock(w.ctx, w.engine.commitLocker(), w.path, sbox.LockOptions{Wait: sbox.DefaultLockTTL}, func() error {
+		if err := w.cond.Check(w.engine.ETag(w.ctx, w.path)); err != nil {
+			return err
+		}
+		published = true
+		return w.publish(mPath, data)
+	})
+	if !published {
+		_ = w.Abort()
+		return err
+	}
+	w.release()
+	return err
+}
+
+// publish replaces the manifest at mPath with data and completes the
+// journal, if any.
+func (w *shardedWriter) publish(mPath string, data []byte) error {
+	if err := w.journal.commit(data); err != nil {
+		w.journal.abandon()
+		w.journal = nil
+		return err
+	}
+	// Chunks written by this writer were referenced as they were stored;
+	// chunks inherited from an appended manifest are referenced now.
+	installed := false
+	err := w.engine.replaceManifest(mPath, w.hashes[:w.inherited], func() error {
+		if err := w.engine.writeManifest(mPath, data); err != nil {
+			return err
+		}
+		installed = true
+		return nil
+	})
+	if !installed {
+		w.journal.abandon()
+		w.journal = nil
+		return err
+	}
+	if jerr := w.journal.done(); err == nil {
+		err = jerr
+	}
+	w.journal = nil
+	return err
+}
+
+// replaceManifest installs a new manifest at mPath with install. The
+// manifest being replaced is kept as a version and releases its chunk
+// references; the references in added are taken.
+func (e *Engine) replaceManifest(mPath string, added []string, install func() error) error {
+	replaced, err := e.refChunks(e.manifestChunks, mPath)
+	if err != nil {
+		return err
+	}
+	old := e.previousManifest(mPath)
+	if err := install(); err != nil {
+		return err
+	}
+	release, err := e.supersede(mPath, old, replaced)
+	if rerr := e.adjustRefs(added, release); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// verify checks the written data against the expected checksum, if any.
+func (w *shardedWriter) verify() error {
+	if w.checksum == "" {
+		return nil
+	}
+	h := w.check
+	if h == nil {
+		h = w.content
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != w.checksum {
+		return fmt.Errorf("sbox/sharded: content has sha256 %s, want %s: %w", sum, w.checksum, sbox.ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// Abort discards the written data without touching the existing manifest.
+// References taken on shards stored so far are released.
+func (w *shardedWriter) Abort() error {
+	_ = w.wait()
+	w.release()
+	stored := slices.DeleteFunc(slices.Clone(w.hashes[w.inherited:]), func(h string) bool { return h == "" })
+	err := w.engine.adjustRefs(nil, stored)
+	if jerr := w.journal.done(); err == nil {
+		err = jerr
+	}
+	w.journal = nil
+	return err
+}
+
+// release returns the chunk buffer to the pool.
+func (w *shardedWriter) release() {
 	if w.pbuf != nil {
 		*w.pbuf = w.buffer[:cap(w.buffer)]
 		w.engine.bufferPool.Put(w.pbuf)
 		w.pbuf = nil
 		w.buffer = nil
 	}
-	return err
+}
+
+// marshalManifest encodes m with the manifest encoding of the engine.
+func (e *Engine) marshalManifest(m *sbox.Manifest) ([]byte, error) {
+	return sbox.MarshalManifestAs(m, e.manifestEncoding)
+}
+
+// manifestTmpSuffix follows the manifest path in the names of the
+// temporary files of writeManifest, then random hex digits.
+const manifestTmpSuffix = ".tmp"
+
+// writeManifest stores a manifest via a temporary file and rename so that
+// readers never observe a partially written manifest. Each call has its
+// own temporary file, so that concurrent writers of a path do not rename
+// each other's.
+func (e *Engine) writeManifest(mPath string, data []byte) error {
+	rnd := make([]byte, 8)
+	if _, err := rand.Read(rnd); err != nil {
+		return err
+	}
+	tmp := mPath + manifestTmpSuffix + hex.EncodeToString(rnd)
+	if err := e.writeFile(e.manifestFs, tmp, data); err != nil {
+		_ = e.manifestFs.Remove(tmp)
+		return err
+	}
+	if err := e.manifestFs.Rename(tmp, mPath); err != nil {
+		return err
+	}
+	return e.syncDirs(e.manifestFs, filepath.Dir(mPath))
 }
 
 // copyBuffered is a helper for hashing.
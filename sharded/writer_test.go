@@ -0,0 +1,151 @@
+package sharded_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/sharded"
+)
+
+func TestWriteConcurrency_Suite(t *testing.T) {
+	sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+		sharded.WithWriteConcurrency(4)))
+	t.Run("refcount", func(t *testing.T) {
+		sboxtest.StorageTestSuite(t, sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64,
+			sharded.WithWriteConcurrency(4), sharded.WithRefcount(true)))
+	})
+}
+
+func TestWriteConcurrency_Overlaps(t *testing.T) {
+	shards := &slowFs{Fs: afero.NewMemMapFs()}
+	engine := sharded.New(afero.NewMemMapFs(), shards, 1024, sharded.WithWriteConcurrency(8))
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+
+	if err := sbox.PutAtomic(context.Background(), engine, "big.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("PutAtomic: %v", err)
+	}
+	if peak := shards.peak.Load(); peak < 4 {
+		t.Errorf("at most %d shards were written at once, want concurrent writes", peak)
+	}
+	if got := readFile(t, engine, "big.bin"); got != string(data) {
+		t.Error("content mismatch")
+	}
+}
+
+func TestWriteManifest_ConcurrentOverwrite(t *testing.T) {
+	ctx := context.Background()
+	manifestFs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	engine := sharded.New(manifestFs, afero.NewBasePathFs(afero.NewOsFs(), t.TempDir()), 16)
+	const writers = 64
+	contents := make(map[string]bool, writers)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		content := fmt.Sprintf("content of writer %02d", i)
+		contents[content] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, err := engine.Create(ctx, "same.txt")
+			if err == nil {
+				_, err = io.WriteString(w, content)
+				if cerr := w.Close(); err == nil {
+					err = cerr
+				}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("write: %v", err)
+		}
+	}
+
+	if got := readFile(t, engine, "same.txt"); !contents[got] {
+		t.Errorf("same.txt = %q, want the content of one writer", got)
+	}
+	entries, err := afero.ReadDir(manifestFs, "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("manifest directory holds %v, want only same.txt.json", names)
+	}
+}
+
+// failingFs fails every file creation.
+type failingFs struct{ afero.Fs }
+
+var errDiskFull = errors.New("disk full")
+
+func (failingFs) OpenFile(string, int, os.FileMode) (afero.File, error) { return nil, errDiskFull }
+
+func TestWriteConcurrency_Error(t *testing.T) {
+	manifestFs := afero.NewMemMapFs()
+	engine := sharded.New(manifestFs, failingFs{afero.NewMemMapFs()}, 16, sharded.WithWriteConcurrency(4))
+
+	w, err := engine.Create(context.Background(), "f.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, err = w.Write(bytes.Repeat([]byte("x"), 100))
+	if cerr := w.Close(); !errors.Is(err, errDiskFull) && !errors.Is(cerr, errDiskFull) {
+		t.Errorf("Write = %v, Close = %v; want %v", err, cerr, errDiskFull)
+	}
+	if exists, _ := afero.Exists(manifestFs, "manifests/f.txt.json"); exists {
+		t.Error("manifest written despite failed chunk writes")
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	engine := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 64, sharded.WithWriteConcurrency(2))
+	writeFile(t, engine, "old.bin", string(make([]byte, 256)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := engine.Create(ctx, "new.bin")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := w.Write(make([]byte, 200)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	r, err := engine.Open(ctx, "old.bin")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	cancel()
+
+	if _, err := w.Write(make([]byte, 200)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write after cancel = %v, want %v", err, context.Canceled)
+	}
+	if err := w.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close after cancel = %v, want %v", err, context.Canceled)
+	}
+	if _, err := engine.Stat(context.Background(), "new.bin"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("canceled file was published: %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read after cancel = %v, want %v", err, context.Canceled)
+	}
+}
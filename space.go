@@ -0,0 +1,91 @@
+package sbox
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NoSpaceError is returned by writes that would leave less free space than
+// the engine is configured to keep. It wraps ErrNoSpace.
+type NoSpaceError struct {
+	Path      string
+	Needed    int64 // Bytes the write needed
+	Available int64 // Bytes that could still be written, or -1 if unknown
+}
+
+func (e *NoSpaceError) Error() string {
+	if e.Available < 0 {
+		return fmt.Sprintf("sbox: no space left for %s: %d bytes needed", e.Path, e.Needed)
+	}
+	return fmt.Sprintf("sbox: no space left for %s: %d bytes needed, %d available", e.Path, e.Needed, e.Available)
+}
+
+func (e *NoSpaceError) Unwrap() error {
+	return ErrNoSpace
+}
+
+// spaceRefresh is how long a SpaceGuard trusts a measurement of the free
+// space, which other writers and deletions change.
+const spaceRefresh = time.Second
+
+// SpaceGuard keeps a reserve of free space on the filesystem holding a
+// directory, for drivers that write to the OS filesystem. Drivers call
+// Reserve before writing, so writes fail early with a *NoSpaceError
+// instead of halfway with an OS error. A SpaceGuard is safe for concurrent
+// use; writers share one budget measured at most once a second, or when
+// the budget runs out.
+type SpaceGuard struct {
+	dir     string
+	reserve int64
+
+	mu       sync.Mutex
+	budget   int64 // Free space above the reserve, less what was reserved since
+	measured time.Time
+}
+
+// NewSpaceGuard returns a SpaceGuard keeping reserve bytes free on the
+// filesystem holding dir. On platforms where free space cannot be
+// measured, it allows every write.
+func NewSpaceGuard(dir string, reserve int64) *SpaceGuard {
+	return &SpaceGuard{dir: dir, reserve: max(reserve, 0)}
+}
+
+// Reserve accounts for n bytes about to be written to path. It returns a
+// *NoSpaceError if they do not fit above the reserve; with n zero, if
+// nothing does.
+func (g *SpaceGuard) Reserve(path string, n int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fits := func() bool { return n <= g.budget && g.budget > 0 }
+	if !fits() || time.Since(g.measured) > spaceRefresh {
+		free, err := FreeSpace(g.dir)
+		if err != nil {
+			return nil // Cannot tell; let the write find out
+		}
+		g.budget, g.measured = free-g.reserve, time.Now()
+	}
+	if !fits() {
+		return &NoSpaceError{Path: path, Needed: n, Available: max(g.budget, 0)}
+	}
+	g.budget -= n
+	return nil
+}
+
+// Check returns err, converting an OS error for a full filesystem from a
+// write of n bytes to path into a *NoSpaceError.
+func (g *SpaceGuard) Check(path string, n int64, err error) error {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+	available := int64(-1)
+	if free, ferr := FreeSpace(g.dir); ferr == nil {
+		available = max(free-g.reserve, 0)
+	}
+	return &NoSpaceError{Path: path, Needed: n, Available: available}
+}
+
+// Compile-time interface checks.
+var _ error = (*NoSpaceError)(nil)
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package sbox
+
+// FreeSpace returns the bytes available to unprivileged writers on the
+// filesystem holding dir. It returns ErrNotSupported on platforms other
+// than Linux and macOS.
+func FreeSpace(dir string) (int64, error) {
+	return 0, ErrNotSupported
+}
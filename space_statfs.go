@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package sbox
+
+import "syscall"
+
+// FreeSpace returns the bytes available to unprivileged writers on the
+// filesystem holding dir. It returns ErrNotSupported on platforms other
+// than Linux and macOS.
+func FreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil //nolint:unconvert // Types differ between platforms
+}
@@ -0,0 +1,44 @@
+package sbox_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"syscall"
+	"testing"
+
+	"github.com/nuln/sbox"
+)
+
+func TestSpaceGuard(t *testing.T) {
+	dir := t.TempDir()
+	free, err := sbox.FreeSpace(dir)
+	if errors.Is(err, sbox.ErrNotSupported) {
+		t.Skip("free space cannot be measured here")
+	}
+	if err != nil || free <= 0 {
+		t.Fatalf("FreeSpace = %d, %v", free, err)
+	}
+
+	if err := sbox.NewSpaceGuard(dir, 0).Reserve("a", 1); err != nil {
+		t.Errorf("Reserve without reserve: %v", err)
+	}
+
+	g := sbox.NewSpaceGuard(dir, math.MaxInt64/2)
+	var nse *sbox.NoSpaceError
+	err = g.Reserve("a", 0)
+	if !errors.As(err, &nse) || !errors.Is(err, sbox.ErrNoSpace) {
+		t.Fatalf("Reserve over the reserve = %v", err)
+	}
+	if nse.Path != "a" || nse.Available != 0 {
+		t.Errorf("NoSpaceError = %+v", nse)
+	}
+
+	err = g.Check("b", 10, fmt.Errorf("write: %w", syscall.ENOSPC))
+	if !errors.As(err, &nse) || nse.Needed != 10 {
+		t.Errorf("Check(ENOSPC) = %v", err)
+	}
+	if err := g.Check("b", 10, sbox.ErrPermission); err != sbox.ErrPermission {
+		t.Errorf("Check(other) = %v", err)
+	}
+}
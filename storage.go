@@ -2,11 +2,14 @@ package sbox
 
 import (
 	"context"
+	"io"
 	"os"
 )
 
 // StorageEngine defines the unified interface for all storage backends.
-// All driver implementations must satisfy this interface.
+// All driver implementations must satisfy this interface. Errors are
+// returned as *PathError, and a missing path fails with an error matching
+// ErrNotFound in Stat, Open, Remove, Rename and ReadDir.
 type StorageEngine interface {
 	// Stat returns metadata about a file or directory.
 	Stat(ctx context.Context, path string) (*EntryInfo, error)
@@ -21,15 +24,27 @@ type StorageEngine interface {
 	// OpenFile opens a file with specific flags (e.g. os.O_APPEND).
 	OpenFile(ctx context.Context, path string, flag int, perm os.FileMode) (WriteSeekCloser, error)
 
-	// Remove deletes a file or directory (and all children).
+	// Remove deletes a file or directory (and all children). Removing a
+	// missing path fails.
 	Remove(ctx context.Context, path string) error
 
 	// Rename moves or renames a file or directory.
 	Rename(ctx context.Context, oldPath, newPath string) error
 
-	// MkdirAll creates a directory and all necessary parents.
+	// MkdirAll creates a directory and all necessary parents. It succeeds
+	// silently if the directory already exists and returns ErrNotDir if a
+	// file occupies the path.
 	MkdirAll(ctx context.Context, path string) error
 
 	// ReadDir returns the contents of a directory.
 	ReadDir(ctx context.Context, path string) ([]*EntryInfo, error)
 }
+
+// Close releases resources held by engine. Engines that cache state or hold
+// open handles implement io.Closer; for all others Close is a no-op.
+func Close(engine StorageEngine) error {
+	if c, ok := engine.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
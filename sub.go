@@ -0,0 +1,220 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// SubEngine is a StorageEngine scoped to a directory of another engine,
+// analogous to fs.Sub. Paths are resolved relative to the directory, and
+// paths with a ".." element are rejected with ErrInvalid instead of being
+// cleaned (see [CleanPath]), so no path can reach outside it:
+//
+//	tenant, err := sbox.Sub(engine, "tenants/"+id)
+//	w, _ := tenant.Create(ctx, "report.pdf") // "tenants/<id>/report.pdf"
+//
+// Entries report paths relative to the directory. The directory itself
+// cannot be removed or renamed through the SubEngine, and closing it does
+// not close the underlying engine, which is typically shared.
+type SubEngine struct {
+	engine StorageEngine
+	prefix string
+}
+
+// Sub returns an engine for the directory prefix of engine. It fails with
+// ErrInvalid if [CleanPath] rejects prefix. The directory does not have to
+// exist.
+func Sub(engine StorageEngine, prefix string) (*SubEngine, error) {
+	prefix, err := CleanPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := engine.(*SubEngine); ok {
+		return &SubEngine{engine: s.engine, prefix: strings.TrimPrefix(path.Join(s.prefix, prefix), "/")}, nil
+	}
+	return &SubEngine{engine: engine, prefix: prefix}, nil
+}
+
+// resolve returns the path of p relative to the sub-tree and in the
+// underlying engine.
+func (s *SubEngine) resolve(p string) (rel, full string, err error) {
+	if rel, err = CleanPath(p); err != nil {
+		return "", "", err
+	}
+	return rel, strings.TrimPrefix(path.Join(s.prefix, rel), "/"), nil
+}
+
+// Engine returns the underlying engine.
+func (s *SubEngine) Engine() StorageEngine {
+	return s.engine
+}
+
+// Prefix returns the directory the engine is scoped to.
+func (s *SubEngine) Prefix() string {
+	return s.prefix
+}
+
+// outer maps an entry at rel back into the sub-tree.
+func (s *SubEngine) outer(info *EntryInfo, rel string) *EntryInfo {
+	c := *info
+	c.Path = rel
+	if rel == "" {
+		c.Name = "/"
+	}
+	return &c
+}
+
+func (s *SubEngine) Stat(ctx context.Context, p string) (*EntryInfo, error) {
+	rel, full, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.engine.Stat(ctx, full)
+	if err != nil {
+		return nil, err
+	}
+	return s.outer(info, rel), nil
+}
+
+func (s *SubEngine) Open(ctx context.Context, p string) (ReadSeekCloser, error) {
+	_, full, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.engine.Open(ctx, full)
+}
+
+func (s *SubEngine) Create(ctx context.Context, p string) (WriteCloser, error) {
+	_, full, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.engine.Create(ctx, full)
+}
+
+func (s *SubEngine) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (WriteSeekCloser, error) {
+	_, full, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.engine.OpenFile(ctx, full, flag, perm)
+}
+
+func (s *SubEngine) Remove(ctx context.Context, p string) error {
+	rel, full, err := s.resolve(p)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return ErrPermission
+	}
+	return s.engine.Remove(ctx, full)
+}
+
+func (s *SubEngine) Rename(ctx context.Context, oldPath, newPath string) error {
+	oldRel, oldFull, err := s.resolve(oldPath)
+	if err != nil {
+		return err
+	}
+	newRel, newFull, err := s.resolve(newPath)
+	if err != nil {
+		return err
+	}
+	if oldRel == "" || newRel == "" {
+		return ErrPermission
+	}
+	return s.engine.Rename(ctx, oldFull, newFull)
+}
+
+func (s *SubEngine) MkdirAll(ctx context.Context, p string) error {
+	_, full, err := s.resolve(p)
+	if err != nil {
+		return err
+	}
+	return s.engine.MkdirAll(ctx, full)
+}
+
+func (s *SubEngine) ReadDir(ctx context.Context, p string) ([]*EntryInfo, error) {
+	rel, full, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.engine.ReadDir(ctx, full)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*EntryInfo, len(entries))
+	for i, e := range entries {
+		result[i] = s.outer(e, path.Join(rel, e.Name))
+	}
+	return result, nil
+}
+
+// === Extension: Copier ===
+
+func (s *SubEngine) Copy(ctx context.Context, src, dst string) error {
+	_, srcFull, err := s.resolve(src)
+	if err != nil {
+		return err
+	}
+	dstRel, dstFull, err := s.resolve(dst)
+	if err != nil {
+		return err
+	}
+	if dstRel == "" {
+		return ErrPermission
+	}
+	if c, ok := s.engine.(Copier); ok {
+		err := c.Copy(ctx, srcFull, dstFull)
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return CopyTree(ctx, s.engine, srcFull, s.engine, dstFull, CopyTreeOptions{})
+}
+
+// === Extension: StreamReader ===
+
+func (s *SubEngine) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	_, full, err := s.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if sr, ok := s.engine.(StreamReader); ok {
+		return sr.Get(ctx, full)
+	}
+	return s.engine.Open(ctx, full)
+}
+
+// === Extension: StreamWriter ===
+
+func (s *SubEngine) Put(ctx context.Context, p string, reader io.Reader) error {
+	_, full, err := s.resolve(p)
+	if err != nil {
+		return err
+	}
+	if sw, ok := s.engine.(StreamWriter); ok {
+		return sw.Put(ctx, full, reader)
+	}
+	w, err := s.engine.Create(ctx, full)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Compile-time interface checks.
+var (
+	_ StorageEngine = (*SubEngine)(nil)
+	_ Copier        = (*SubEngine)(nil)
+	_ StreamReader  = (*SubEngine)(nil)
+	_ StreamWriter  = (*SubEngine)(nil)
+)
@@ -0,0 +1,68 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+)
+
+func TestSub_Suite(t *testing.T) {
+	sub, err := sbox.Sub(memory.New(), "tenants/a")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	sboxtest.StorageTestSuite(t, sub)
+}
+
+func TestSub_Scoping(t *testing.T) {
+	ctx := context.Background()
+	base := memory.New()
+	routerWrite(t, base, "secret.txt", "outside")
+	a, err := sbox.Sub(base, "/tenants/a/")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	routerWrite(t, a, "docs/report.txt", "tenant a")
+
+	if got := routerRead(t, base, "tenants/a/docs/report.txt"); got != "tenant a" {
+		t.Errorf("underlying file = %q", got)
+	}
+	entries, err := a.ReadDir(ctx, "docs")
+	if err != nil || len(entries) != 1 || entries[0].Path != "docs/report.txt" {
+		t.Fatalf("ReadDir = %+v, %v", entries, err)
+	}
+	if info, err := a.Stat(ctx, "/"); err != nil || info.Name != "/" || info.Path != "" || !info.IsDir {
+		t.Errorf("Stat(root) = %+v, %v", info, err)
+	}
+
+	for _, p := range []string{"../../secret.txt", "docs/../../../secret.txt", `..\..\secret.txt`, "docs/.."} {
+		if _, err := a.Open(ctx, p); !errors.Is(err, sbox.ErrInvalid) {
+			t.Errorf("Open(%q) = %v, want %v", p, err, sbox.ErrInvalid)
+		}
+	}
+	if err := a.Rename(ctx, "docs/report.txt", "../b/stolen.txt"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Rename out = %v, want %v", err, sbox.ErrInvalid)
+	}
+	if err := a.Remove(ctx, ""); !errors.Is(err, sbox.ErrPermission) {
+		t.Errorf("Remove(root) = %v, want %v", err, sbox.ErrPermission)
+	}
+	if _, err := sbox.Sub(base, "tenants/../etc"); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Sub(..) = %v, want %v", err, sbox.ErrInvalid)
+	}
+
+	// A Sub of a Sub scopes further.
+	docs, err := sbox.Sub(a, "docs")
+	if err != nil {
+		t.Fatalf("nested Sub: %v", err)
+	}
+	if docs.Prefix() != "tenants/a/docs" || docs.Engine() != sbox.StorageEngine(base) {
+		t.Errorf("nested Sub = %q over %T", docs.Prefix(), docs.Engine())
+	}
+	if got := routerRead(t, docs, "report.txt"); got != "tenant a" {
+		t.Errorf("nested read = %q", got)
+	}
+}
@@ -0,0 +1,317 @@
+// Package sync performs incremental one-way synchronization between any
+// two storage engines, in the spirit of "rclone sync".
+//
+//	stats, err := sync.Sync(ctx, src, dst, sync.Options{
+//	    Compare:     sync.CompareHash,
+//	    Delete:      true,
+//	    Concurrency: 8,
+//	})
+//
+// Files that are missing from the destination or differ from the source are
+// copied; everything else is left alone, so repeated runs only transfer
+// what changed.
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+
+	"github.com/nuln/sbox"
+)
+
+// Compare selects how a source file is compared with its destination copy.
+type Compare int
+
+const (
+	// CompareModTime copies a file when the sizes differ or the source was
+	// modified after the destination.
+	CompareModTime Compare = iota
+
+	// CompareSize copies a file only when the sizes differ.
+	CompareSize
+
+	// CompareHash copies a file when the sizes or SHA-256 digests differ.
+	// Files on engines that do not implement sbox.Hasher are compared as
+	// with CompareModTime.
+	CompareHash
+)
+
+// DefaultConcurrency is the number of files transferred in parallel when
+// Options.Concurrency is not set.
+const DefaultConcurrency = 4
+
+// Options controls a sync run.
+type Options struct {
+	// SrcPath and DstPath are the roots synchronized on each side. The
+	// empty string means the whole engine.
+	SrcPath string
+	DstPath string
+
+	// Compare selects how existing destination files are checked.
+	Compare Compare
+
+	// Delete removes destination entries that do not exist on the source.
+	// Deletions are skipped if any transfer failed, so an interrupted run
+	// never loses data.
+	Delete bool
+
+	// Concurrency is the number of files checked and copied in parallel.
+	Concurrency int
+
+	// DryRun reports what would be copied and deleted without changing
+	// the destination.
+	DryRun bool
+
+	// Progress, if set, is called after every file is checked or deleted
+	// with a snapshot of the statistics gathered so far. Calls are
+	// serialized.
+	Progress func(stats Stats)
+}
+
+// Stats reports the outcome of a sync run.
+type Stats struct {
+	Checked int   // Source files examined
+	Copied  int   // Files copied (or that would be, in dry-run mode)
+	Deleted int   // Destination entries deleted (or that would be)
+	Bytes   int64 // Total size of copied files
+	Errors  int   // Files that could not be checked, copied or deleted
+}
+
+// syncer holds the state of a single Sync call.
+type syncer struct {
+	src, dst sbox.StorageEngine
+	opts     Options
+	tracker  *sbox.ProgressTracker
+
+	mu    gosync.Mutex
+	stats Stats
+	errs  []error
+}
+
+// Sync makes the tree at opts.DstPath on dst match the tree at
+// opts.SrcPath on src. It returns the statistics gathered so far together
+// with every error encountered; a failure on one file does not stop the
+// others. Progress is also reported as the "sync" operation to the
+// sbox.Progress attached to ctx.
+func Sync(ctx context.Context, src, dst sbox.StorageEngine, opts Options) (*Stats, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	s := &syncer{src: src, dst: dst, opts: opts, tracker: sbox.NewProgressTracker(ctx, "sync")}
+
+	files := make(chan *sbox.EntryInfo)
+	var wg gosync.WaitGroup
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range files {
+				s.syncFile(ctx, info)
+			}
+		}()
+	}
+
+	seen := make(map[string]bool)
+	walkErr := sbox.Walk(ctx, src, opts.SrcPath, func(p string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel := relPath(opts.SrcPath, p)
+		seen[rel] = true
+		if info.IsDir {
+			if dp := s.dstPath(rel); dp != "" && !opts.DryRun {
+				if err := dst.MkdirAll(ctx, dp); err != nil {
+					s.fail(fmt.Errorf("sbox/sync: mkdir %s: %w", dp, err))
+				}
+			}
+			return nil
+		}
+		s.tracker.AddTotal(info.Size, 1)
+		select {
+		case files <- info:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	if walkErr != nil {
+		s.fail(fmt.Errorf("sbox/sync: walk %s: %w", opts.SrcPath, walkErr))
+		return s.result()
+	}
+	if opts.Delete && s.stats.Errors == 0 {
+		s.deleteExtraneous(ctx, seen)
+	}
+	return s.result()
+}
+
+func (s *syncer) result() (*Stats, error) {
+	stats := s.stats
+	return &stats, errors.Join(s.errs...)
+}
+
+// relPath returns p relative to root.
+func relPath(root, p string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+}
+
+func (s *syncer) dstPath(rel string) string {
+	if rel == "" {
+		return s.opts.DstPath
+	}
+	return path.Join(s.opts.DstPath, rel)
+}
+
+// fail records an error that is not tied to a checked file.
+func (s *syncer) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Errors++
+	s.errs = append(s.errs, err)
+}
+
+// record updates the statistics and reports progress.
+func (s *syncer) record(update func(*Stats), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.stats)
+	if err != nil {
+		s.stats.Errors++
+		s.errs = append(s.errs, err)
+	}
+	if s.opts.Progress != nil {
+		s.opts.Progress(s.stats)
+	}
+}
+
+// syncFile copies a single source file if the destination differs.
+func (s *syncer) syncFile(ctx context.Context, info *sbox.EntryInfo) {
+	dp := s.dstPath(relPath(s.opts.SrcPath, info.Path))
+	need, err := s.needsCopy(ctx, info, dp)
+	if err == nil && need && !s.opts.DryRun {
+		err = s.copy(ctx, info, dp)
+	}
+	if err != nil {
+		err = fmt.Errorf("sbox/sync: %s: %w", info.Path, err)
+	}
+	// Files that are skipped count as done; copies were counted as read.
+	var skipped int64
+	if !need || s.opts.DryRun {
+		skipped = info.Size
+	}
+	s.tracker.Advance(info.Path, skipped, 1)
+	s.record(func(st *Stats) {
+		st.Checked++
+		if need && err == nil {
+			st.Copied++
+			st.Bytes += info.Size
+		}
+	}, err)
+}
+
+// needsCopy reports whether the destination copy of a file is missing or
+// out of date.
+func (s *syncer) needsCopy(ctx context.Context, info *sbox.EntryInfo, dp string) (bool, error) {
+	dinfo, err := s.dst.Stat(ctx, dp)
+	if errors.Is(err, sbox.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if dinfo.IsDir {
+		return false, fmt.Errorf("destination %s: %w", dp, sbox.ErrIsDir)
+	}
+	if dinfo.Size != info.Size {
+		return true, nil
+	}
+
+	switch s.opts.Compare {
+	case CompareSize:
+		return false, nil
+	case CompareHash:
+		sh, sok := s.src.(sbox.Hasher)
+		dh, dok := s.dst.(sbox.Hasher)
+		if sok && dok {
+			a, aerr := sh.Hash(ctx, info.Path, "sha256")
+			b, berr := dh.Hash(ctx, dp, "sha256")
+			if aerr == nil && berr == nil {
+				return !strings.EqualFold(a, b), nil
+			}
+		}
+	}
+	return info.ModTime.After(dinfo.ModTime), nil
+}
+
+// copy transfers a file and, where the destination supports it, its
+// modification time, so that CompareModTime sees the copies as current.
+func (s *syncer) copy(ctx context.Context, info *sbox.EntryInfo, dstPath string) error {
+	r, err := s.src.Open(ctx, info.Path)
+	if err != nil {
+		return err
+	}
+	err = sbox.PutAtomic(ctx, s.dst, dstPath, s.tracker.Reader(info.Path, r))
+	_ = r.Close()
+	if err != nil {
+		return err
+	}
+	if ms, ok := s.dst.(sbox.ModTimeSetter); ok && !info.ModTime.IsZero() {
+		if err := ms.SetModTime(ctx, dstPath, info.ModTime); err != nil && !errors.Is(err, sbox.ErrNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteExtraneous removes destination entries that were not seen on the
+// source.
+func (s *syncer) deleteExtraneous(ctx context.Context, seen map[string]bool) {
+	var extra []string
+	err := sbox.Walk(ctx, s.dst, s.opts.DstPath, func(p string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, sbox.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if rel := relPath(s.opts.DstPath, p); rel != "" && !seen[rel] {
+			extra = append(extra, p)
+			if info.IsDir {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.fail(fmt.Errorf("sbox/sync: walk %s: %w", s.opts.DstPath, err))
+		return
+	}
+
+	for _, p := range extra {
+		var err error
+		if !s.opts.DryRun {
+			err = s.dst.Remove(ctx, p)
+		}
+		if err != nil {
+			err = fmt.Errorf("sbox/sync: remove %s: %w", p, err)
+		}
+		s.record(func(st *Stats) {
+			if err == nil {
+				st.Deleted++
+			}
+		}, err)
+	}
+}
@@ -0,0 +1,187 @@
+package sync_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sharded"
+	"github.com/nuln/sbox/sync"
+)
+
+func writeFile(t *testing.T, engine sbox.StorageEngine, path, content string) {
+	t.Helper()
+	if err := sbox.PutAtomic(context.Background(), engine, path, strings.NewReader(content)); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, engine sbox.StorageEngine, path string) string {
+	t.Helper()
+	r, err := engine.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open %s: %v", path, err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestSync_Incremental(t *testing.T) {
+	src := memory.New()
+	dst := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 4)
+	ctx := context.Background()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "dir/b.txt", "bravo")
+	writeFile(t, src, "dir/sub/c.txt", "charlie")
+
+	var calls int
+	stats, err := sync.Sync(ctx, src, dst, sync.Options{
+		Concurrency: 2,
+		Progress:    func(sync.Stats) { calls++ },
+	})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if stats.Checked != 3 || stats.Copied != 3 || stats.Bytes != 17 {
+		t.Errorf("first run stats = %+v", stats)
+	}
+	if calls != 3 {
+		t.Errorf("progress called %d times, want 3", calls)
+	}
+	if got := readFile(t, dst, "dir/sub/c.txt"); got != "charlie" {
+		t.Errorf("dst c.txt = %q", got)
+	}
+
+	// Nothing changed, so nothing is copied.
+	stats, err = sync.Sync(ctx, src, dst, sync.Options{})
+	if err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if stats.Checked != 3 || stats.Copied != 0 {
+		t.Errorf("second run stats = %+v", stats)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, src, "dir/b.txt", "BRAVO")
+	stats, err = sync.Sync(ctx, src, dst, sync.Options{})
+	if err != nil {
+		t.Fatalf("third Sync: %v", err)
+	}
+	if stats.Copied != 1 {
+		t.Errorf("third run copied %d, want 1", stats.Copied)
+	}
+	if got := readFile(t, dst, "dir/b.txt"); got != "BRAVO" {
+		t.Errorf("dst b.txt = %q", got)
+	}
+}
+
+func TestSync_CompareHash(t *testing.T) {
+	src, dst := memory.New(), memory.New()
+	ctx := context.Background()
+	writeFile(t, dst, "f.txt", "old!")
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, src, "f.txt", "same")
+	writeFile(t, dst, "f.txt", "diff") // same size, newer than the source
+
+	stats, err := sync.Sync(ctx, src, dst, sync.Options{Compare: sync.CompareSize})
+	if err != nil || stats.Copied != 0 {
+		t.Fatalf("size compare: stats=%+v err=%v", stats, err)
+	}
+	stats, err = sync.Sync(ctx, src, dst, sync.Options{Compare: sync.CompareHash})
+	if err != nil || stats.Copied != 1 {
+		t.Fatalf("hash compare: stats=%+v err=%v", stats, err)
+	}
+	if got := readFile(t, dst, "f.txt"); got != "same" {
+		t.Errorf("dst f.txt = %q", got)
+	}
+}
+
+func TestSync_DeleteAndDryRun(t *testing.T) {
+	src, dst := memory.New(), memory.New()
+	ctx := context.Background()
+	writeFile(t, src, "src/keep.txt", "keep")
+	writeFile(t, src, "src/new.txt", "new")
+	writeFile(t, dst, "backup/keep.txt", "keep")
+	writeFile(t, dst, "backup/stale.txt", "stale")
+	writeFile(t, dst, "backup/old/x.txt", "x")
+	writeFile(t, dst, "other.txt", "outside the synced root")
+
+	opts := sync.Options{SrcPath: "src", DstPath: "backup", Compare: sync.CompareSize, Delete: true, DryRun: true}
+	stats, err := sync.Sync(ctx, src, dst, opts)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if stats.Copied != 1 || stats.Deleted != 2 {
+		t.Errorf("dry run stats = %+v", stats)
+	}
+	if _, err := dst.Stat(ctx, "backup/new.txt"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("dry run copied new.txt: %v", err)
+	}
+	if _, err := dst.Stat(ctx, "backup/stale.txt"); err != nil {
+		t.Errorf("dry run deleted stale.txt: %v", err)
+	}
+
+	opts.DryRun = false
+	if _, err := sync.Sync(ctx, src, dst, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := readFile(t, dst, "backup/new.txt"); got != "new" {
+		t.Errorf("dst new.txt = %q", got)
+	}
+	for _, p := range []string{"backup/stale.txt", "backup/old"} {
+		if _, err := dst.Stat(ctx, p); !errors.Is(err, sbox.ErrNotFound) {
+			t.Errorf("%s survived sync: %v", p, err)
+		}
+	}
+	if _, err := dst.Stat(ctx, "other.txt"); err != nil {
+		t.Errorf("file outside DstPath was touched: %v", err)
+	}
+}
+
+func TestSync_MissingSource(t *testing.T) {
+	_, err := sync.Sync(context.Background(), memory.New(), memory.New(), sync.Options{SrcPath: "nope"})
+	if !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Sync missing source = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestSync_PreservesModTime(t *testing.T) {
+	ctx := context.Background()
+	src := memory.New()
+	dst := sharded.New(afero.NewMemMapFs(), afero.NewMemMapFs(), 16)
+	writeFile(t, src, "a.txt", "alpha")
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := src.SetModTime(ctx, "a.txt", mtime); err != nil {
+		t.Fatalf("SetModTime: %v", err)
+	}
+
+	if _, err := sync.Sync(ctx, src, dst, sync.Options{}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	info, err := dst.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !info.ModTime.Equal(mtime) {
+		t.Errorf("modtime = %v, want %v", info.ModTime, mtime)
+	}
+
+	stats, err := sync.Sync(ctx, src, dst, sync.Options{})
+	if err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if stats.Copied != 0 {
+		t.Errorf("second run copied %d files, want 0", stats.Copied)
+	}
+}
@@ -0,0 +1,366 @@
+package sbox
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the subset of TOML that configuration files need:
+// tables, dotted keys, strings, integers, floats, booleans, arrays and
+// inline tables. Multi-line strings, dates and arrays of tables are
+// rejected.
+func parseTOML(src string) (map[string]any, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := make(map[string]any)
+	cur := root
+	for {
+		p.skip(true)
+		if p.eof() {
+			return root, nil
+		}
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.pos++
+			p.skipSpace()
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+			if cur, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		} else if err := p.keyValue(cur); err != nil {
+			return nil, err
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("toml: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skip skips spaces and comments and, if newlines is set, line breaks.
+func (p *tomlParser) skip(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endLine() error {
+	p.skip(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("unexpected %q", p.peek())
+	}
+	return nil
+}
+
+func (p *tomlParser) expect(c byte) error {
+	if p.eof() || p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// key parses a possibly dotted key.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		k, err := p.simpleKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		p.skipSpace()
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+		p.skipSpace()
+	}
+}
+
+func (p *tomlParser) simpleKey() (string, error) {
+	if p.eof() {
+		return "", p.errorf("expected key")
+	}
+	switch p.peek() {
+	case '"':
+		return p.basicString()
+	case '\'':
+		return p.literalString()
+	}
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected key")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// table returns the table at keys below root, creating it if needed.
+func (p *tomlParser) table(root map[string]any, keys []string) (map[string]any, error) {
+	t := root
+	for _, k := range keys {
+		v, ok := t[k]
+		if !ok {
+			v = make(map[string]any)
+			t[k] = v
+		}
+		sub, ok := v.(map[string]any)
+		if !ok {
+			return nil, p.errorf("key %q is not a table", k)
+		}
+		t = sub
+	}
+	return t, nil
+}
+
+func (p *tomlParser) keyValue(t map[string]any) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('='); err != nil {
+		return err
+	}
+	p.skipSpace()
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	if t, err = p.table(t, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	k := keys[len(keys)-1]
+	if _, ok := t[k]; ok {
+		return p.errorf("duplicate key %q", k)
+	}
+	t[k] = v
+	return nil
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+	rest := p.src[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"""`), strings.HasPrefix(rest, "'''"):
+		return nil, p.errorf("multi-line strings are not supported")
+	case rest[0] == '"':
+		return p.basicString()
+	case rest[0] == '\'':
+		return p.literalString()
+	case rest[0] == '[':
+		return p.array()
+	case rest[0] == '{':
+		return p.inlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n,]}#", p.peek()) < 0 {
+		p.pos++
+	}
+	tok := p.src[start:p.pos]
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	s := strings.ReplaceAll(tok, "_", "")
+	if len(s) > 2 && s[0] == '0' {
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[s[1]]
+		if base != 0 {
+			n, err := strconv.ParseInt(s[2:], base, 64)
+			if err != nil {
+				return nil, p.errorf("invalid integer %q", tok)
+			}
+			return n, nil
+		}
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if strings.ContainsAny(s, ".eE") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	if strings.ContainsAny(tok, ":") || strings.Count(tok, "-") >= 2 {
+		return nil, p.errorf("dates are not supported")
+	}
+	return nil, p.errorf("invalid value %q", tok)
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++ // Opening quote
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+		default:
+			b.WriteByte(c)
+			continue
+		}
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		c = p.peek()
+		p.pos++
+		switch c {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\':
+			b.WriteByte(c)
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if p.pos+n > len(p.src) {
+				return "", p.errorf("invalid escape")
+			}
+			r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", p.errorf("invalid escape")
+			}
+			b.WriteRune(rune(r))
+			p.pos += n
+		default:
+			return "", p.errorf("invalid escape \\%c", c)
+		}
+	}
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++ // Opening quote
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++ // [
+	a := []any{}
+	for {
+		p.skip(true)
+		if !p.eof() && p.peek() == ']' {
+			p.pos++
+			return a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+		p.skip(true)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect(']'); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.pos++ // {
+	t := make(map[string]any)
+	p.skipSpace()
+	if !p.eof() && p.peek() == '}' {
+		p.pos++
+		return t, nil
+	}
+	for {
+		p.skipSpace()
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect('}'); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+}
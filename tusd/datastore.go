@@ -0,0 +1,113 @@
+//go:build tusd
+
+package tusd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tus/tusd/v2/pkg/handler"
+
+	"github.com/nuln/sbox"
+)
+
+// DataStore adapts a Store to tusd's handler, with support for the
+// termination, concatenation and creation-defer-length extensions.
+type DataStore struct {
+	store *Store
+}
+
+// NewDataStore returns a DataStore for store.
+func NewDataStore(store *Store) *DataStore {
+	return &DataStore{store: store}
+}
+
+// UseIn registers the DataStore and its extensions in composer.
+func (d *DataStore) UseIn(composer *handler.StoreComposer) {
+	composer.UseCore(d)
+	composer.UseTerminater(d)
+	composer.UseConcater(d)
+	composer.UseLengthDeferrer(d)
+}
+
+func (d *DataStore) NewUpload(ctx context.Context, info handler.FileInfo) (handler.Upload, error) {
+	u, err := d.store.NewUpload(ctx, Info{
+		ID:             info.ID,
+		Size:           info.Size,
+		SizeIsDeferred: info.SizeIsDeferred,
+		MetaData:       info.MetaData,
+		IsPartial:      info.IsPartial,
+		IsFinal:        info.IsFinal,
+		PartialUploads: info.PartialUploads,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return upload{u}, nil
+}
+
+func (d *DataStore) GetUpload(ctx context.Context, id string) (handler.Upload, error) {
+	u, err := d.store.GetUpload(ctx, id)
+	if errors.Is(err, sbox.ErrNotFound) {
+		return nil, handler.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upload{u}, nil
+}
+
+func (d *DataStore) AsTerminatableUpload(u handler.Upload) handler.TerminatableUpload {
+	return u.(upload)
+}
+
+func (d *DataStore) AsConcatableUpload(u handler.Upload) handler.ConcatableUpload {
+	return u.(upload)
+}
+
+func (d *DataStore) AsLengthDeclarableUpload(u handler.Upload) handler.LengthDeclarableUpload {
+	return u.(upload)
+}
+
+// upload adapts an Upload to tusd's upload interfaces.
+type upload struct {
+	*Upload
+}
+
+func (u upload) GetInfo(ctx context.Context) (handler.FileInfo, error) {
+	info, err := u.Upload.GetInfo(ctx)
+	if err != nil {
+		return handler.FileInfo{}, err
+	}
+	return handler.FileInfo{
+		ID:             info.ID,
+		Size:           info.Size,
+		SizeIsDeferred: info.SizeIsDeferred,
+		Offset:         info.Offset,
+		MetaData:       info.MetaData,
+		IsPartial:      info.IsPartial,
+		IsFinal:        info.IsFinal,
+		PartialUploads: info.PartialUploads,
+		Storage:        info.Storage,
+	}, nil
+}
+
+func (u upload) ConcatUploads(ctx context.Context, partials []handler.Upload) error {
+	ups := make([]*Upload, len(partials))
+	for i, p := range partials {
+		ups[i] = p.(upload).Upload
+	}
+	return u.Upload.ConcatUploads(ctx, ups)
+}
+
+// Compile-time interface checks.
+var (
+	_ handler.DataStore               = (*DataStore)(nil)
+	_ handler.TerminaterDataStore     = (*DataStore)(nil)
+	_ handler.ConcaterDataStore       = (*DataStore)(nil)
+	_ handler.LengthDeferrerDataStore = (*DataStore)(nil)
+	_ handler.Upload                  = upload{}
+	_ handler.TerminatableUpload      = upload{}
+	_ handler.ConcatableUpload        = upload{}
+	_ handler.LengthDeclarableUpload  = upload{}
+)
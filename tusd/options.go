@@ -0,0 +1,11 @@
+package tusd
+
+// Option configures optional Store behavior.
+type Option func(*Store)
+
+// WithDir keeps uploads in dir instead of "uploads".
+func WithDir(dir string) Option {
+	return func(s *Store) {
+		s.dir = dir
+	}
+}
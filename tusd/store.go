@@ -0,0 +1,243 @@
+// Package tusd stores tus resumable uploads (https://tus.io) in any
+// sbox.StorageEngine, so web apps can upload large files directly into sbox
+// storage and resume them after a dropped connection.
+//
+//	store := tusd.New(engine, tusd.WithDir("uploads"))
+//
+// Store and Upload follow the data store protocol of tusd, the reference
+// tus server: an upload is created with its expected size, receives
+// chunks at increasing offsets, and can be read, terminated, concatenated
+// from partial uploads or given its size later. Building with the "tusd"
+// tag adds DataStore, which plugs a Store into tusd's handler:
+//
+//	composer := handler.NewStoreComposer()
+//	tusd.NewDataStore(store).UseIn(composer)
+//
+// Like tusd's file store, each upload is kept as two files in the upload
+// directory: "<id>" holds the data received so far, whose size is the
+// upload's offset, and "<id>.info" its description as JSON. Chunks are
+// written with sbox.Append, so engines implementing sbox.Appender keep
+// the bytes of an interrupted chunk and others rewrite the file per chunk.
+// Stores do not lock uploads; tusd's handler serializes requests per
+// upload with its own locker.
+package tusd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/nuln/sbox"
+)
+
+// Info describes an upload. It mirrors tusd's handler.FileInfo and is
+// stored in the same JSON form.
+type Info struct {
+	ID string
+	// Size is the total size of the upload, unknown while SizeIsDeferred.
+	Size           int64
+	SizeIsDeferred bool
+	// Offset is the number of bytes received so far.
+	Offset   int64
+	MetaData map[string]string
+	// IsPartial and IsFinal mark the parts and the result of a
+	// concatenation, whose parts are listed in PartialUploads.
+	IsPartial      bool
+	IsFinal        bool
+	PartialUploads []string
+	// Storage holds the "Type" ("sbox") and "Path" of the upload's data.
+	Storage map[string]string
+}
+
+// Store keeps uploads in an engine.
+type Store struct {
+	engine sbox.StorageEngine
+	dir    string
+}
+
+// New returns a Store keeping uploads in engine, by default in the
+// directory "uploads".
+func New(engine sbox.StorageEngine, opts ...Option) *Store {
+	s := &Store{engine: engine, dir: "uploads"}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Engine returns the engine the uploads are kept in.
+func (s *Store) Engine() sbox.StorageEngine {
+	return s.engine
+}
+
+// NewUpload creates an empty upload described by info, generating an ID
+// if info.ID is empty.
+func (s *Store) NewUpload(ctx context.Context, info Info) (*Upload, error) {
+	if info.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		info.ID = hex.EncodeToString(id)
+	} else if !validID(info.ID) {
+		return nil, fmt.Errorf("sbox/tusd: invalid upload id %q: %w", info.ID, sbox.ErrInvalid)
+	}
+	u := &Upload{store: s, id: info.ID}
+	info.Offset = 0
+	info.Storage = map[string]string{"Type": "sbox", "Path": u.Path()}
+
+	if err := s.engine.MkdirAll(ctx, s.dir); err != nil {
+		return nil, err
+	}
+	if err := sbox.PutAtomic(ctx, s.engine, u.Path(), bytes.NewReader(nil)); err != nil {
+		return nil, err
+	}
+	if err := u.writeInfo(ctx, info); err != nil {
+		_ = s.engine.Remove(ctx, u.Path())
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetUpload returns the upload with the given ID, or an error wrapping
+// sbox.ErrNotFound if there is none.
+func (s *Store) GetUpload(ctx context.Context, id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("sbox/tusd: upload %q: %w", id, sbox.ErrNotFound)
+	}
+	u := &Upload{store: s, id: id}
+	if _, err := s.engine.Stat(ctx, u.infoPath()); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// validID reports whether id names a file directly in the upload
+// directory.
+func validID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && !strings.HasPrefix(id, ".") && !strings.HasSuffix(id, ".info")
+}
+
+// Upload is an upload in a Store.
+type Upload struct {
+	store *Store
+	id    string
+}
+
+// Path returns the path of the upload's data in the store's engine, e.g.
+// to move a finished upload to its final place.
+func (u *Upload) Path() string {
+	return path.Join(u.store.dir, u.id)
+}
+
+func (u *Upload) infoPath() string {
+	return u.Path() + ".info"
+}
+
+func (u *Upload) readInfo(ctx context.Context) (Info, error) {
+	var info Info
+	r, err := u.store.engine.Open(ctx, u.infoPath())
+	if err != nil {
+		return info, err
+	}
+	defer func() { _ = r.Close() }()
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return info, fmt.Errorf("sbox/tusd: upload %s: invalid info: %w", u.id, err)
+	}
+	return info, nil
+}
+
+func (u *Upload) writeInfo(ctx context.Context, info Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return sbox.PutAtomic(ctx, u.store.engine, u.infoPath(), bytes.NewReader(data))
+}
+
+// GetInfo returns the upload's description, with Offset set to the bytes
+// received so far.
+func (u *Upload) GetInfo(ctx context.Context) (Info, error) {
+	info, err := u.readInfo(ctx)
+	if err != nil {
+		return info, err
+	}
+	fi, err := u.store.engine.Stat(ctx, u.Path())
+	if err != nil {
+		return info, err
+	}
+	info.Offset = fi.Size
+	return info, nil
+}
+
+// WriteChunk appends src to the upload, which must have received exactly
+// offset bytes so far, and returns the number of bytes written. Data
+// beyond the upload's size is not read.
+func (u *Upload) WriteChunk(ctx context.Context, offset int64, src io.Reader) (int64, error) {
+	info, err := u.GetInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if offset != info.Offset {
+		return 0, fmt.Errorf("sbox/tusd: upload %s is at offset %d, not %d: %w", u.id, info.Offset, offset, sbox.ErrPreconditionFailed)
+	}
+	if !info.SizeIsDeferred {
+		src = io.LimitReader(src, info.Size-offset)
+	}
+	return sbox.Append(ctx, u.store.engine, u.Path(), src)
+}
+
+// GetReader returns the data received so far.
+func (u *Upload) GetReader(ctx context.Context) (io.ReadCloser, error) {
+	return u.store.engine.Open(ctx, u.Path())
+}
+
+// FinishUpload is called once all data has been received. The data stays
+// at Path; move it from a post-finish hook to publish it elsewhere.
+func (u *Upload) FinishUpload(ctx context.Context) error {
+	return nil
+}
+
+// Terminate removes the upload and its data.
+func (u *Upload) Terminate(ctx context.Context) error {
+	err := u.store.engine.Remove(ctx, u.Path())
+	if ierr := u.store.engine.Remove(ctx, u.infoPath()); err == nil || errors.Is(err, sbox.ErrNotFound) {
+		err = ierr
+	}
+	return err
+}
+
+// DeclareLength sets the size of an upload created with SizeIsDeferred.
+func (u *Upload) DeclareLength(ctx context.Context, length int64) error {
+	info, err := u.readInfo(ctx)
+	if err != nil {
+		return err
+	}
+	info.Size = length
+	info.SizeIsDeferred = false
+	return u.writeInfo(ctx, info)
+}
+
+// ConcatUploads appends the data of the finished partial uploads, in
+// order, to this upload.
+func (u *Upload) ConcatUploads(ctx context.Context, partials []*Upload) error {
+	for _, p := range partials {
+		r, err := p.GetReader(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = sbox.Append(ctx, u.store.engine, u.Path(), r)
+		_ = r.Close()
+		if err != nil {
+			return fmt.Errorf("sbox/tusd: concatenate %s: %w", p.id, err)
+		}
+	}
+	return nil
+}
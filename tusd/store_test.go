@@ -0,0 +1,121 @@
+package tusd_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/local"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/tusd"
+)
+
+func readAll(t *testing.T, u *tusd.Upload) string {
+	t.Helper()
+	r, err := u.GetReader(context.Background())
+	if err != nil {
+		t.Fatalf("GetReader: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return string(data)
+}
+
+func TestStore_Resume(t *testing.T) {
+	engine, err := local.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	store := tusd.New(engine)
+	ctx := context.Background()
+
+	u, err := store.NewUpload(ctx, tusd.Info{Size: 11, MetaData: map[string]string{"filename": "a.txt"}})
+	if err != nil {
+		t.Fatalf("NewUpload: %v", err)
+	}
+	info, err := u.GetInfo(ctx)
+	if err != nil {
+		t.Fatalf("GetInfo: %v", err)
+	}
+	if info.ID == "" || info.Offset != 0 || info.MetaData["filename"] != "a.txt" || info.Storage["Path"] != u.Path() {
+		t.Fatalf("GetInfo = %+v", info)
+	}
+
+	if n, err := u.WriteChunk(ctx, 0, strings.NewReader("hello ")); n != 6 || err != nil {
+		t.Fatalf("WriteChunk = %d, %v", n, err)
+	}
+
+	// A new store, as after a restart, resumes at the stored offset.
+	u, err = tusd.New(engine).GetUpload(ctx, info.ID)
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if info, _ := u.GetInfo(ctx); info.Offset != 6 {
+		t.Fatalf("Offset = %d, want 6", info.Offset)
+	}
+	if _, err := u.WriteChunk(ctx, 0, strings.NewReader("again")); !errors.Is(err, sbox.ErrPreconditionFailed) {
+		t.Errorf("WriteChunk at a stale offset = %v, want %v", err, sbox.ErrPreconditionFailed)
+	}
+	if n, err := u.WriteChunk(ctx, 6, strings.NewReader("world and more")); n != 5 || err != nil {
+		t.Fatalf("WriteChunk = %d, %v", n, err)
+	}
+	if got := readAll(t, u); got != "hello world" {
+		t.Errorf("data = %q", got)
+	}
+	if err := u.FinishUpload(ctx); err != nil {
+		t.Fatalf("FinishUpload: %v", err)
+	}
+
+	if err := u.Terminate(ctx); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+	if _, err := store.GetUpload(ctx, info.ID); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("GetUpload after Terminate = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := store.GetUpload(ctx, "../etc"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("GetUpload of an invalid id = %v, want %v", err, sbox.ErrNotFound)
+	}
+}
+
+func TestStore_DeferredAndConcat(t *testing.T) {
+	store := tusd.New(memory.New(), tusd.WithDir("tus"))
+	ctx := context.Background()
+
+	var parts []*tusd.Upload
+	for _, s := range []string{"foo", "bar"} {
+		p, err := store.NewUpload(ctx, tusd.Info{SizeIsDeferred: true, IsPartial: true})
+		if err != nil {
+			t.Fatalf("NewUpload: %v", err)
+		}
+		if _, err := p.WriteChunk(ctx, 0, strings.NewReader(s)); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+		if err := p.DeclareLength(ctx, 3); err != nil {
+			t.Fatalf("DeclareLength: %v", err)
+		}
+		if info, _ := p.GetInfo(ctx); info.Size != 3 || info.SizeIsDeferred {
+			t.Errorf("GetInfo after DeclareLength = %+v", info)
+		}
+		parts = append(parts, p)
+	}
+
+	final, err := store.NewUpload(ctx, tusd.Info{ID: "final", Size: 6, IsFinal: true})
+	if err != nil {
+		t.Fatalf("NewUpload: %v", err)
+	}
+	if err := final.ConcatUploads(ctx, parts); err != nil {
+		t.Fatalf("ConcatUploads: %v", err)
+	}
+	if got := readAll(t, final); got != "foobar" {
+		t.Errorf("data = %q", got)
+	}
+	if final.Path() != "tus/final" {
+		t.Errorf("Path = %q", final.Path())
+	}
+}
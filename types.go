@@ -2,6 +2,7 @@ package sbox
 
 import (
 	"io"
+	"io/fs"
 	"os"
 	"time"
 )
@@ -15,13 +16,32 @@ type EntryInfo struct {
 	IsDir    bool              `json:"isDir"`
 	Path     string            `json:"path"`
 	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Uid and Gid are the numeric owner and group, where the engine
+	// records them (see PermissionManager); 0 otherwise.
+	Uid int `json:"uid,omitempty"`
+	Gid int `json:"gid,omitempty"`
+
+	// LinkTarget is the target of a symbolic link, as reported by Lstat
+	// and ReadDir; see Symlinker.
+	LinkTarget string `json:"linkTarget,omitempty"`
+
+	// ContentType is the MIME type of a file, where the engine stores it
+	// (see WithContentType and WithDetectedContentType); "" otherwise.
+	ContentType string `json:"contentType,omitempty"`
 }
 
-// ToFileInfo converts EntryInfo to a standard os.FileInfo.
+// ToFileInfo converts EntryInfo to a standard os.FileInfo. Its Sys method
+// returns e.
 func (e *EntryInfo) ToFileInfo() os.FileInfo {
 	return &entryFileInfoWrap{e}
 }
 
+// ToDirEntry converts EntryInfo to a standard fs.DirEntry.
+func (e *EntryInfo) ToDirEntry() fs.DirEntry {
+	return fs.FileInfoToDirEntry(e.ToFileInfo())
+}
+
 type entryFileInfoWrap struct {
 	e *EntryInfo
 }
@@ -31,7 +51,7 @@ func (w *entryFileInfoWrap) Size() int64        { return w.e.Size }
 func (w *entryFileInfoWrap) Mode() os.FileMode  { return w.e.Mode }
 func (w *entryFileInfoWrap) ModTime() time.Time { return w.e.ModTime }
 func (w *entryFileInfoWrap) IsDir() bool        { return w.e.IsDir }
-func (w *entryFileInfoWrap) Sys() interface{}   { return nil }
+func (w *entryFileInfoWrap) Sys() interface{}   { return w.e }
 
 // ReadSeekCloser groups Read, Seek, and Close.
 type ReadSeekCloser = io.ReadSeekCloser
@@ -46,10 +66,34 @@ type WriteSeekCloser interface {
 	io.Closer
 }
 
+// HoleChunk is the chunk hash of a hole in a sparse file: a chunk of zero
+// bytes that has no shard and reads as zeros.
+const HoleChunk = "hole"
+
 // Manifest represents the metadata of a chunked/sharded file.
 type Manifest struct {
-	Chunks     []string  `json:"chunks"`               // Chunk hashes
+	Version    int       `json:"version,omitempty"`    // Format version; 0 for version 1 manifests
+	Chunks     []string  `json:"chunks"`               // Chunk hashes, or HoleChunk
 	ChunkSizes []int64   `json:"chunkSizes,omitempty"` // Per-chunk sizes (for variable-sized chunks)
 	Size       int64     `json:"size"`
 	ModTime    time.Time `json:"modTime"`
+
+	// Set only when at least one chunk is stored compressed.
+	Compressed  []bool  `json:"compressed,omitempty"`  // Per-chunk: shard is stored compressed
+	StoredSizes []int64 `json:"storedSizes,omitempty"` // Per-chunk size of the stored shard
+
+	// Set only when at least one chunk is encrypted.
+	Keys []string `json:"keys,omitempty"` // Per-chunk wrapped encryption key ("" if not encrypted)
+
+	// Version 2 fields.
+	Hash      string `json:"hash,omitempty"`      // SHA-256 of the file content (hex), if known
+	CreatedBy string `json:"createdBy,omitempty"` // Component that wrote the manifest
+
+	Metadata    map[string]string `json:"metadata,omitempty"`    // User metadata, see MetadataWriter
+	LinkTarget  string            `json:"linkTarget,omitempty"`  // Set for symbolic links, which have no chunks
+	Mode        os.FileMode       `json:"mode,omitempty"`        // Permission bits, see PermissionManager
+	ContentType string            `json:"contentType,omitempty"` // MIME type, see FileOptions
+	Uid         int               `json:"uid,omitempty"`
+	Gid         int               `json:"gid,omitempty"`
+	Checksum    string            `json:"checksum,omitempty"` // SHA-256 of the manifest without this field
 }
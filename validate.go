@@ -0,0 +1,218 @@
+package sbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ProbeTimeout bounds the checks made by [Probe] and [Validate].
+const ProbeTimeout = 30 * time.Second
+
+// Diagnostic is the outcome of one check made by [Validate] or [Probe].
+type Diagnostic struct {
+	// Check names the check: "config", "driver", "open", "capabilities",
+	// "stat", "list", "ping", "write", "read" or "remove".
+	Check string
+
+	// Err is why the check failed, or nil if it passed.
+	Err error
+
+	// Hint suggests how to fix a failure.
+	Hint string
+}
+
+func (d Diagnostic) String() string {
+	if d.Err == nil {
+		return d.Check + ": ok"
+	}
+	s := d.Check + ": " + d.Err.Error()
+	if d.Hint != "" {
+		s += " (" + d.Hint + ")"
+	}
+	return s
+}
+
+// ValidationError is returned by [Validate], and by [Open] with [Probe],
+// when a check fails. It lists every check made, and wraps the errors of
+// those that failed.
+type ValidationError struct {
+	Driver      string
+	Diagnostics []Diagnostic
+}
+
+// Failed returns the diagnostics of the failed checks.
+func (e *ValidationError) Failed() []Diagnostic {
+	var failed []Diagnostic
+	for _, d := range e.Diagnostics {
+		if d.Err != nil {
+			failed = append(failed, d)
+		}
+	}
+	return failed
+}
+
+func (e *ValidationError) Error() string {
+	failed := e.Failed()
+	msgs := make([]string, len(failed))
+	for i, d := range failed {
+		msgs[i] = d.String()
+	}
+	if e.Driver == "" {
+		return "sbox: config failed validation: " + strings.Join(msgs, "; ")
+	}
+	return fmt.Sprintf("sbox: driver %q failed validation: %s", e.Driver, strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Unwrap() []error {
+	var errs []error
+	for _, d := range e.Diagnostics {
+		if d.Err != nil {
+			errs = append(errs, d.Err)
+		}
+	}
+	return errs
+}
+
+// Probe makes [Open] check that the engine works before returning it, by
+// a Stat and a ReadDir of its root and, if it is a [HealthChecker], a
+// Ping. With write set, it also writes, reads
+// back and removes a small file, to check write permissions. Failures are
+// reported as a *ValidationError.
+func Probe(write bool) OpenOption {
+	return func(o *openOptions) {
+		o.probe = true
+		o.probeWrite = o.probeWrite || write
+	}
+}
+
+// Validate checks cfg without keeping an engine open: it checks that the
+// driver is registered, opens the engine, checks the capabilities given
+// to [Require] and probes the engine as [Probe] does, then closes it.
+// It returns a *ValidationError describing each failure, so services can
+// fail fast at startup:
+//
+//	if err := sbox.Validate(cfg, sbox.Probe(true)); err != nil {
+//		log.Fatal(err)
+//	}
+func Validate(cfg *Config, opts ...OpenOption) error {
+	if cfg == nil {
+		return &ValidationError{Diagnostics: []Diagnostic{{
+			Check: "config", Err: fmt.Errorf("config must not be nil: %w", ErrInvalid),
+		}}}
+	}
+	if cfg.Type == "" {
+		return &ValidationError{Diagnostics: []Diagnostic{{
+			Check: "config", Err: fmt.Errorf("type is empty: %w", ErrInvalid),
+			Hint: "set the driver name, e.g. \"local\" or \"sharded\"",
+		}}}
+	}
+	mu.RLock()
+	_, ok := factories[cfg.Type]
+	mu.RUnlock()
+	if !ok {
+		return &ValidationError{Driver: cfg.Type, Diagnostics: []Diagnostic{{
+			Check: "driver", Err: fmt.Errorf("unknown driver %q: %w", cfg.Type, ErrNotSupported),
+			Hint: "import the driver package, e.g. github.com/nuln/sbox/drivers; registered: " + strings.Join(Drivers(), ", "),
+		}}}
+	}
+
+	engine, err := Open(cfg, append(opts, Probe(false))...)
+	if err == nil {
+		return Close(engine)
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return ve
+	}
+	d := Diagnostic{Check: "open", Err: err, Hint: hint(err)}
+	var ce *CapabilityError
+	if errors.As(err, &ce) {
+		d = Diagnostic{Check: "capabilities", Err: err, Hint: "use a driver or middleware that supports them"}
+	}
+	return &ValidationError{Driver: cfg.Type, Diagnostics: []Diagnostic{d}}
+}
+
+// probe checks that engine works, returning a *ValidationError if not.
+func probe(driver string, engine StorageEngine, write bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ProbeTimeout)
+	defer cancel()
+
+	var diags []Diagnostic
+	failed := false
+	check := func(name string, err error) bool {
+		diags = append(diags, Diagnostic{Check: name, Err: err, Hint: hint(err)})
+		failed = failed || err != nil
+		return err == nil
+	}
+
+	_, err := engine.Stat(ctx, "")
+	check("stat", err)
+	_, err = engine.ReadDir(ctx, "")
+	check("list", err)
+	if h, ok := engine.(HealthChecker); ok && Supports(engine, CapHealth) {
+		check("ping", h.Ping(ctx))
+	}
+
+	if write {
+		var b [8]byte
+		_, _ = rand.Read(b[:])
+		name := ".sbox-probe-" + hex.EncodeToString(b[:])
+		data := []byte("sbox probe " + name)
+		if check("write", PutAtomic(ctx, engine, name, bytes.NewReader(data))) {
+			check("read", readBack(ctx, engine, name, data))
+			check("remove", engine.Remove(ctx, name))
+		}
+	}
+
+	if failed {
+		return &ValidationError{Driver: driver, Diagnostics: diags}
+	}
+	return nil
+}
+
+// readBack checks that the file at name holds data.
+func readBack(ctx context.Context, engine StorageEngine, name string, data []byte) error {
+	r, err := engine.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, data) {
+		return fmt.Errorf("read back %d bytes differing from the %d written", len(got), len(data))
+	}
+	return nil
+}
+
+// hint suggests how to fix the cause of err.
+func hint(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrPermission):
+		return "check the credentials and the permissions of the base path"
+	case errors.Is(err, ErrNotFound):
+		return "check that the base path exists"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "check that the backend is reachable"
+	case errors.Is(err, ErrNotSupported):
+		return "the driver or its configuration does not support this"
+	}
+	return ""
+}
+
+// Compile-time interface checks.
+var (
+	_ error        = (*ValidationError)(nil)
+	_ fmt.Stringer = Diagnostic{}
+)
@@ -0,0 +1,77 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+// readOnlyEngine refuses writes.
+type readOnlyEngine struct {
+	sbox.StorageEngine
+}
+
+func (readOnlyEngine) Create(context.Context, string) (sbox.WriteCloser, error) {
+	return nil, sbox.ErrPermission
+}
+
+func init() {
+	sbox.Register("validatetest", func(cfg *sbox.Config) (sbox.StorageEngine, error) {
+		if cfg.Options["fail"] == true {
+			return nil, errors.New("connection refused")
+		}
+		return readOnlyEngine{memory.New()}, nil
+	})
+}
+
+func TestValidate(t *testing.T) {
+	if err := sbox.Validate(&sbox.Config{Type: "validatetest"}); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	var ve *sbox.ValidationError
+	err := sbox.Validate(&sbox.Config{Type: "validatetest"}, sbox.Probe(true))
+	if !errors.As(err, &ve) || !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Validate with write probe = %v", err)
+	}
+	if len(ve.Diagnostics) != 3 || ve.Diagnostics[0].Err != nil || len(ve.Failed()) != 1 {
+		t.Fatalf("Diagnostics = %v", ve.Diagnostics)
+	}
+	if d := ve.Failed()[0]; d.Check != "write" || !strings.Contains(d.Hint, "permissions") {
+		t.Fatalf("failed check = %v", d)
+	}
+
+	for _, tt := range []struct {
+		cfg   *sbox.Config
+		opts  []sbox.OpenOption
+		check string
+	}{
+		{nil, nil, "config"},
+		{&sbox.Config{}, nil, "config"},
+		{&sbox.Config{Type: "nosuchdriver"}, nil, "driver"},
+		{&sbox.Config{Type: "validatetest", Options: map[string]any{"fail": true}}, nil, "open"},
+		{&sbox.Config{Type: "validatetest"}, []sbox.OpenOption{sbox.Require(sbox.CapTier)}, "capabilities"},
+	} {
+		err := sbox.Validate(tt.cfg, tt.opts...)
+		if !errors.As(err, &ve) || len(ve.Failed()) != 1 || ve.Failed()[0].Check != tt.check {
+			t.Errorf("Validate(%+v) = %v, want failed check %q", tt.cfg, err, tt.check)
+		}
+	}
+}
+
+func TestOpen_Probe(t *testing.T) {
+	engine, err := sbox.Open(&sbox.Config{Type: "memory"}, sbox.Probe(true))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if entries, err := engine.ReadDir(context.Background(), ""); err != nil || len(entries) != 0 {
+		t.Fatalf("probe left %v, %v", entries, err)
+	}
+	if _, err := sbox.Open(&sbox.Config{Type: "validatetest"}, sbox.Probe(true)); !errors.Is(err, sbox.ErrPermission) {
+		t.Fatalf("Open read-only engine with write probe = %v", err)
+	}
+}
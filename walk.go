@@ -2,30 +2,67 @@ package sbox
 
 import (
 	"context"
+	"io/fs"
 	"path/filepath"
 )
 
 // WalkFunc is the callback for Walk. It is called for each file or directory
 // visited. If it returns filepath.SkipDir for a directory, Walk skips that
-// directory's contents.
+// directory's contents; for a file, the remaining entries of its
+// directory. If it returns filepath.SkipAll, Walk stops without error.
 type WalkFunc func(path string, info *EntryInfo, err error) error
 
 // Walk walks the file tree rooted at root, calling fn for each file or
-// directory in the tree, including root. It works with any StorageEngine.
-func Walk(ctx context.Context, engine StorageEngine, root string, fn WalkFunc) error {
+// directory in the tree, including root. It works with any StorageEngine,
+// reading one directory at a time in the order ReadDir returns entries;
+// engines implementing [Walker] walk natively, in their own order. opts
+// limit the depth and filter the entries passed to fn.
+func Walk(ctx context.Context, engine StorageEngine, root string, fn WalkFunc, opts ...WalkOption) error {
+	c, err := newWalkConfig(opts)
+	if err != nil {
+		return err
+	}
+	fn = c.filter(root, fn)
+	if w, ok := engine.(Walker); ok && c.native() && Supports(engine, CapWalk) {
+		return skipped(w.Walk(ctx, root, fn))
+	}
+	return skipped(walk(ctx, engine, root, fn, c))
+}
+
+// WalkDirFunc is the callback for WalkDir, like fs.WalkDirFunc. d is nil
+// when err is set. The Sys method of d's Info returns the *EntryInfo.
+type WalkDirFunc func(path string, d fs.DirEntry, err error) error
+
+// WalkDir is [Walk] with an fs.WalkDir style callback.
+func WalkDir(ctx context.Context, engine StorageEngine, root string, fn WalkDirFunc, opts ...WalkOption) error {
+	return Walk(ctx, engine, root, func(p string, info *EntryInfo, err error) error {
+		if info == nil {
+			return fn(p, nil, err)
+		}
+		return fn(p, info.ToDirEntry(), err)
+	}, opts...)
+}
+
+// skipped returns err, or nil for filepath.SkipDir and filepath.SkipAll.
+func skipped(err error) error {
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk walks the tree serially.
+func walk(ctx context.Context, engine StorageEngine, root string, fn WalkFunc, c *walkConfig) error {
 	info, err := engine.Stat(ctx, root)
 	if err != nil {
 		err = fn(root, nil, err)
 	} else {
-		err = walkDir(ctx, engine, root, info, fn)
-	}
-	if err == filepath.SkipDir {
-		return nil
+		err = walkDir(ctx, engine, root, info, fn, c)
 	}
 	return err
 }
 
-func walkDir(ctx context.Context, engine StorageEngine, path string, info *EntryInfo, fn WalkFunc) error {
+func walkDir(ctx context.Context, engine StorageEngine, path string, info *EntryInfo, fn WalkFunc, c *walkConfig) error {
 	if !info.IsDir {
 		return fn(path, info, nil)
 	}
@@ -50,7 +87,7 @@ func walkDir(ctx context.Context, engine StorageEngine, path string, info *Entry
 	}
 
 	for _, entry := range entries {
-		err = walkDir(ctx, engine, entry.Path, entry, fn)
+		err = walkDir(ctx, engine, entry.Path, c.resolve(ctx, engine, entry), fn, c)
 		if err != nil {
 			if err == filepath.SkipDir {
 				return nil
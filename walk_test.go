@@ -0,0 +1,198 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+// walkTree returns an engine holding a few levels of directories.
+func walkTree(t *testing.T) sbox.StorageEngine {
+	t.Helper()
+	engine := memory.New()
+	ctx := context.Background()
+	for _, p := range []string{"a/1.txt", "a/b/2.txt", "a/b/c/3.txt", "a/d/4.txt", "e/5.txt", "e/f/6.txt", "g.txt"} {
+		if err := sbox.PutAtomic(ctx, engine, p, strings.NewReader(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return engine
+}
+
+// collect returns a WalkFunc recording the visited paths, skipping "a/b".
+func collect(paths *[]string) sbox.WalkFunc {
+	return func(p string, info *sbox.EntryInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		*paths = append(*paths, filepath.ToSlash(p))
+		if p == filepath.FromSlash("a/b") {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	ctx := context.Background()
+	engine := walkTree(t)
+	var serial []string
+	if err := sbox.Walk(ctx, engine, "", collect(&serial)); err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if strings.Contains(strings.Join(serial, ","), "a/b/") {
+		t.Fatalf("Walk did not skip a/b: %v", serial)
+	}
+
+	for _, workers := range []int{1, 2, 16} {
+		var ordered []string
+		err := sbox.WalkParallel(ctx, engine, "", collect(&ordered), sbox.WalkWorkers(workers), sbox.WalkOrdered())
+		if err != nil {
+			t.Fatalf("WalkParallel ordered: %v", err)
+		}
+		if strings.Join(ordered, ",") != strings.Join(serial, ",") {
+			t.Errorf("ordered walk with %d workers = %v, want %v", workers, ordered, serial)
+		}
+
+		var unordered []string
+		err = sbox.WalkParallel(ctx, engine, "", collect(&unordered), sbox.WalkWorkers(workers))
+		if err != nil {
+			t.Fatalf("WalkParallel: %v", err)
+		}
+		if unordered[0] != "" {
+			t.Errorf("root not visited first: %v", unordered)
+		}
+		sort.Strings(unordered)
+		sorted := append([]string(nil), serial...)
+		sort.Strings(sorted)
+		if strings.Join(unordered, ",") != strings.Join(sorted, ",") {
+			t.Errorf("unordered walk with %d workers = %v, want %v", workers, unordered, sorted)
+		}
+	}
+
+	stop := errors.New("stop")
+	for _, ordered := range []bool{false, true} {
+		var opts []sbox.WalkOption
+		if ordered {
+			opts = append(opts, sbox.WalkOrdered())
+		}
+		n := 0
+		err := sbox.WalkParallel(ctx, engine, "", func(p string, info *sbox.EntryInfo, err error) error {
+			if n++; n == 3 {
+				return stop
+			}
+			return nil
+		}, opts...)
+		if !errors.Is(err, stop) || n != 3 {
+			t.Errorf("WalkParallel(ordered=%v) stopped after %d with %v", ordered, n, err)
+		}
+		n = 0
+		err = sbox.WalkParallel(ctx, engine, "", func(p string, info *sbox.EntryInfo, err error) error {
+			if n++; n == 3 {
+				return filepath.SkipAll
+			}
+			return nil
+		}, opts...)
+		if err != nil || n != 3 {
+			t.Errorf("WalkParallel(ordered=%v) with SkipAll stopped after %d with %v", ordered, n, err)
+		}
+		err = sbox.WalkParallel(ctx, engine, "missing", func(p string, info *sbox.EntryInfo, err error) error {
+			return err
+		}, opts...)
+		if !errors.Is(err, sbox.ErrNotFound) {
+			t.Errorf("WalkParallel(ordered=%v) of missing root = %v", ordered, err)
+		}
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	engine := walkTree(t)
+	var files []string
+	err := sbox.WalkDir(context.Background(), engine, "a", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "c" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if e, ok := info.Sys().(*sbox.EntryInfo); !ok || e.Size != int64(len(filepath.ToSlash(p))) {
+				t.Errorf("Sys of %s = %#v", p, info.Sys())
+			}
+			files = append(files, filepath.ToSlash(p))
+		}
+		if p == filepath.FromSlash("a/d/4.txt") {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	if got := strings.Join(files, ","); got != "a/1.txt,a/b/2.txt,a/d/4.txt" {
+		t.Errorf("WalkDir files = %s", got)
+	}
+}
+
+func TestWalkOptions(t *testing.T) {
+	ctx := context.Background()
+	engine := walkTree(t)
+	walks := map[string]func(fn sbox.WalkFunc, opts ...sbox.WalkOption) error{
+		"Walk": func(fn sbox.WalkFunc, opts ...sbox.WalkOption) error {
+			return sbox.Walk(ctx, engine, "", fn, opts...)
+		},
+		"WalkParallel": func(fn sbox.WalkFunc, opts ...sbox.WalkOption) error {
+			return sbox.WalkParallel(ctx, engine, "", fn, opts...)
+		},
+		"WalkParallel ordered": func(fn sbox.WalkFunc, opts ...sbox.WalkOption) error {
+			return sbox.WalkParallel(ctx, engine, "", fn, append(opts, sbox.WalkOrdered())...)
+		},
+	}
+	tests := []struct {
+		name string
+		opts []sbox.WalkOption
+		want string
+	}{
+		{"depth 0", []sbox.WalkOption{sbox.WalkMaxDepth(0)}, ""},
+		{"depth 1", []sbox.WalkOption{sbox.WalkMaxDepth(1)}, ",a,e,g.txt"},
+		{"depth 2 files", []sbox.WalkOption{sbox.WalkMaxDepth(2), sbox.WalkOnly(sbox.WalkFiles)}, "a/1.txt,e/5.txt,g.txt"},
+		{"dirs", []sbox.WalkOption{sbox.WalkOnly(sbox.WalkDirs)}, ",a,a/b,a/b/c,a/d,e,e/f"},
+		{"include", []sbox.WalkOption{sbox.WalkInclude("[56].txt", "a/**/3.txt"), sbox.WalkOnly(sbox.WalkFiles)}, "a/b/c/3.txt,e/5.txt,e/f/6.txt"},
+		{"exclude", []sbox.WalkOption{sbox.WalkExclude("b", "e/*", "g.txt")}, ",a,a/1.txt,a/d,a/d/4.txt,e"},
+	}
+	for name, walk := range walks {
+		for _, tt := range tests {
+			var got []string
+			err := walk(func(p string, info *sbox.EntryInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				got = append(got, filepath.ToSlash(p))
+				return nil
+			}, tt.opts...)
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, tt.name, err)
+			}
+			sort.Strings(got)
+			if s := strings.Join(got, ","); s != tt.want {
+				t.Errorf("%s %s = %s, want %s", name, tt.name, s, tt.want)
+			}
+		}
+	}
+
+	err := sbox.Walk(ctx, engine, "", collect(new([]string)), sbox.WalkInclude("["))
+	if err == nil {
+		t.Error("Walk with a bad pattern succeeded")
+	}
+}
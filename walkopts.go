@@ -0,0 +1,207 @@
+package sbox
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WalkOption configures [Walk], [WalkDir] and [WalkParallel].
+type WalkOption func(*walkConfig)
+
+// WalkType selects the kinds of entries passed to a WalkFunc; see [WalkOnly].
+type WalkType int
+
+const (
+	WalkFiles    WalkType = 1 << iota // Regular files and other non-directories
+	WalkDirs                          // Directories
+	WalkSymlinks                      // Symbolic links not followed
+)
+
+// maxLinkDepth bounds how deep WalkFollowSymlinks descends, for links whose
+// target the engine does not report.
+const maxLinkDepth = 255
+
+type walkConfig struct {
+	maxDepth int // Negative for no limit
+	follow   bool
+	include  []string
+	exclude  []string
+	types    WalkType
+	workers  int
+	ordered  bool
+}
+
+// WalkMaxDepth stops the walk n levels below root, which is at depth 0:
+// directories at depth n are passed to fn but not read.
+func WalkMaxDepth(n int) WalkOption {
+	return func(c *walkConfig) { c.maxDepth = n }
+}
+
+// WalkFollowSymlinks descends into symbolic links to directories, and
+// passes links to files with the target's info. Links back to the
+// directory containing them or one of its parents are not followed. Walks
+// following links read directories themselves, also on engines
+// implementing [Walker].
+func WalkFollowSymlinks() WalkOption {
+	return func(c *walkConfig) { c.follow = true }
+}
+
+// WalkInclude passes only files matching one of patterns to fn. Patterns
+// containing "/" match the path below root, with "**" matching any number
+// of directories as in [Glob]; others match the base name. Directories
+// are always walked.
+func WalkInclude(patterns ...string) WalkOption {
+	return func(c *walkConfig) { c.include = append(c.include, patterns...) }
+}
+
+// WalkExclude skips files and directories matching one of patterns, which
+// match as for [WalkInclude]. Excluded directories are not read.
+func WalkExclude(patterns ...string) WalkOption {
+	return func(c *walkConfig) { c.exclude = append(c.exclude, patterns...) }
+}
+
+// WalkOnly passes only entries of the given types to fn, e.g.
+// WalkOnly(WalkFiles). Directories not passed are still walked.
+func WalkOnly(types WalkType) WalkOption {
+	return func(c *walkConfig) { c.types = types }
+}
+
+// WalkWorkers sets the number of directories [WalkParallel] reads
+// concurrently; DefaultWalkWorkers if not set.
+func WalkWorkers(n int) WalkOption {
+	return func(c *walkConfig) { c.workers = n }
+}
+
+// WalkOrdered makes [WalkParallel] pass the tree to fn exactly as [Walk]
+// does on an engine whose ReadDir sorts by name: directories are read
+// ahead of fn, up to a few per worker, and a directory fn skips may have
+// been read in vain. Without it, each directory's entries are passed
+// sorted by name as soon as it is read, so siblings' subtrees interleave,
+// and engines implementing [Walker] walk natively.
+func WalkOrdered() WalkOption {
+	return func(c *walkConfig) { c.ordered = true }
+}
+
+// newWalkConfig applies opts and checks the patterns.
+func newWalkConfig(opts []WalkOption) (*walkConfig, error) {
+	c := &walkConfig{maxDepth: -1, workers: DefaultWalkWorkers}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.workers <= 0 {
+		c.workers = DefaultWalkWorkers
+	}
+	for _, pats := range [][]string{c.include, c.exclude} {
+		for _, p := range pats {
+			if err := checkGlob(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// native reports whether an engine implementing Walker may walk natively.
+func (c *walkConfig) native() bool {
+	return !c.follow
+}
+
+// filter returns fn with the depth limit, patterns and types applied.
+// Errors are passed through unfiltered.
+func (c *walkConfig) filter(root string, fn WalkFunc) WalkFunc {
+	if c.maxDepth < 0 && len(c.include) == 0 && len(c.exclude) == 0 && c.types == 0 {
+		return fn
+	}
+	base := len(splitGlob(filepath.ToSlash(root)))
+	return func(p string, info *EntryInfo, err error) error {
+		if err != nil || info == nil {
+			return fn(p, info, err)
+		}
+		var rel []string
+		if elems := splitGlob(filepath.ToSlash(p)); len(elems) > base {
+			rel = elems[base:]
+		}
+		if len(rel) > 0 {
+			if c.maxDepth >= 0 && len(rel) > c.maxDepth || c.matches(c.exclude, rel) {
+				return skipEntry(info)
+			}
+			if !info.IsDir && len(c.include) > 0 && !c.matches(c.include, rel) {
+				return nil
+			}
+		}
+		if c.wants(info) {
+			if err := fn(p, info, nil); err != nil {
+				return err
+			}
+		}
+		if info.IsDir && c.maxDepth >= 0 && len(rel) >= c.maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+}
+
+// skipEntry returns what a WalkFunc returns to leave info out of the walk.
+func skipEntry(info *EntryInfo) error {
+	if info.IsDir {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// matches reports whether the path rel, split into elements, matches one
+// of pats.
+func (c *walkConfig) matches(pats []string, rel []string) bool {
+	for _, pat := range pats {
+		if strings.Contains(pat, "/") {
+			if matchGlob(splitGlob(pat), rel) {
+				return true
+			}
+		} else if ok, _ := path.Match(pat, rel[len(rel)-1]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wants reports whether info is of a type passed to fn.
+func (c *walkConfig) wants(info *EntryInfo) bool {
+	switch {
+	case c.types == 0:
+		return true
+	case info.Mode&os.ModeSymlink != 0:
+		return c.types&WalkSymlinks != 0
+	case info.IsDir:
+		return c.types&WalkDirs != 0
+	default:
+		return c.types&WalkFiles != 0
+	}
+}
+
+// resolve returns the info of the target of e if it is a symbolic link to
+// follow, and e otherwise.
+func (c *walkConfig) resolve(ctx context.Context, engine StorageEngine, e *EntryInfo) *EntryInfo {
+	if !c.follow || e.Mode&os.ModeSymlink == 0 || len(splitGlob(filepath.ToSlash(e.Path))) > maxLinkDepth {
+		return e
+	}
+	info, err := engine.Stat(ctx, e.Path)
+	if err != nil {
+		return e // Dangling, reported as the link
+	}
+	if info.IsDir && e.LinkTarget != "" {
+		p := cleanRoutePath(filepath.ToSlash(e.Path))
+		target := filepath.ToSlash(e.LinkTarget)
+		if !strings.HasPrefix(target, "/") {
+			target = path.Join(path.Dir(p), target)
+		}
+		if target = cleanRoutePath(target); under(p, target) {
+			return e // Would loop
+		}
+	}
+	resolved := *info
+	resolved.Name, resolved.Path = e.Name, e.Path
+	return &resolved
+}
@@ -0,0 +1,255 @@
+package sbox
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultWalkWorkers is the number of directories [WalkParallel] reads
+// concurrently unless [WalkWorkers] is given.
+const DefaultWalkWorkers = 16
+
+// WalkParallel walks the file tree rooted at root like [Walk], but reads
+// directories concurrently, which makes a large difference on remote
+// backends where every ReadDir is a round trip. fn is never called
+// concurrently, and is called for every directory before its entries.
+func WalkParallel(ctx context.Context, engine StorageEngine, root string, fn WalkFunc, opts ...WalkOption) error {
+	c, err := newWalkConfig(opts)
+	if err != nil {
+		return err
+	}
+	fn = c.filter(root, fn)
+	if w, ok := engine.(Walker); ok && !c.ordered && c.native() && Supports(engine, CapWalk) {
+		return skipped(w.Walk(ctx, root, fn))
+	}
+
+	info, err := engine.Stat(ctx, root)
+	if err != nil {
+		return skipped(fn(root, nil, err))
+	}
+	if err := fn(root, info, nil); err != nil || !info.IsDir {
+		return skipped(err)
+	}
+
+	if !c.ordered {
+		return skipped(walkUnordered(ctx, engine, root, fn, c))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &orderedWalk{
+		ctx:    ctx,
+		engine: engine,
+		fn:     fn,
+		cfg:    c,
+		sem:    make(chan struct{}, c.workers),
+		ahead:  make(chan struct{}, 4*c.workers),
+	}
+	err = w.visit(&walkNode{path: root})
+	cancel()
+	w.wg.Wait()
+	return skipped(err)
+}
+
+// sortEntries sorts entries by name.
+func sortEntries(entries []*EntryInfo) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+}
+
+// readDir reads dir, resolving the links c follows.
+func readDir(ctx context.Context, engine StorageEngine, dir string, c *walkConfig) ([]*EntryInfo, error) {
+	entries, err := engine.ReadDir(ctx, dir)
+	for i, e := range entries {
+		entries[i] = c.resolve(ctx, engine, e)
+	}
+	return entries, err
+}
+
+// walkEntries calls fn for the entries of a directory, and returns the
+// directories fn did not skip.
+func walkEntries(entries []*EntryInfo, fn WalkFunc) ([]string, error) {
+	var dirs []string
+	for _, e := range entries {
+		err := fn(e.Path, e, nil)
+		switch {
+		case err == filepath.SkipDir && e.IsDir:
+		case err == filepath.SkipDir:
+			return dirs, nil
+		case err != nil:
+			return nil, err
+		case e.IsDir:
+			dirs = append(dirs, e.Path)
+		}
+	}
+	return dirs, nil
+}
+
+// walkUnordered reads the directories below root with up to workers
+// ReadDir calls at a time, passing each directory's entries to fn as soon
+// as it is read.
+func walkUnordered(ctx context.Context, engine StorageEngine, root string, fn WalkFunc, c *walkConfig) error {
+	type listing struct {
+		dir     string
+		entries []*EntryInfo
+		err     error
+	}
+	results := make(chan listing)
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	queue := []string{root}
+	running := 0
+	for len(queue) > 0 || running > 0 {
+		for ; running < c.workers && len(queue) > 0; running++ {
+			dir := queue[0]
+			queue = queue[1:]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				entries, err := readDir(ctx, engine, dir, c)
+				select {
+				case results <- listing{dir, entries, err}:
+				case <-ctx.Done():
+				}
+			}()
+		}
+		var l listing
+		select {
+		case l = <-results:
+			running--
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if l.err != nil {
+			if err := fn(l.dir, nil, l.err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		sortEntries(l.entries)
+		dirs, err := walkEntries(l.entries, fn)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, dirs...)
+	}
+	return nil
+}
+
+// orderedWalk walks a tree in order, reading directories ahead.
+type orderedWalk struct {
+	ctx    context.Context
+	engine StorageEngine
+	fn     WalkFunc
+	cfg    *walkConfig
+	sem    chan struct{} // Limits concurrent ReadDir calls
+	ahead  chan struct{} // Limits directories read but not yet visited
+	wg     sync.WaitGroup
+}
+
+// walkNode is a directory to visit.
+type walkNode struct {
+	path    string
+	started bool
+	ahead   bool // Holds a token of orderedWalk.ahead
+	done    chan struct{}
+	entries []*EntryInfo
+	err     error
+}
+
+func (w *orderedWalk) start(n *walkNode, ahead bool) {
+	n.started, n.ahead = true, ahead
+	n.done = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer close(n.done)
+		select {
+		case w.sem <- struct{}{}:
+		case <-w.ctx.Done():
+			n.err = w.ctx.Err()
+			return
+		}
+		n.entries, n.err = readDir(w.ctx, w.engine, n.path, w.cfg)
+		<-w.sem
+	}()
+}
+
+// readAhead starts reading n if not too many directories are read ahead.
+func (w *orderedWalk) readAhead(n *walkNode) {
+	select {
+	case w.ahead <- struct{}{}:
+		w.start(n, true)
+	default:
+	}
+}
+
+// release returns the read-ahead token of n once n is read, without
+// waiting for it.
+func (w *orderedWalk) release(n *walkNode) {
+	if !n.ahead {
+		return
+	}
+	n.ahead = false
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		<-n.done
+		<-w.ahead
+	}()
+}
+
+// visit passes the entries of the directory n to fn, and visits the
+// directories fn does not skip.
+func (w *orderedWalk) visit(n *walkNode) error {
+	if !n.started {
+		w.start(n, false)
+	}
+	<-n.done
+	w.release(n)
+	if n.err != nil {
+		if err := w.fn(n.path, nil, n.err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+
+	sortEntries(n.entries)
+	children := make([]*walkNode, len(n.entries))
+	for i, e := range n.entries {
+		if e.IsDir {
+			children[i] = &walkNode{path: e.Path}
+			w.readAhead(children[i])
+		}
+	}
+	// Directories not visited give their read-ahead tokens back.
+	defer func() {
+		for _, c := range children {
+			if c != nil {
+				w.release(c)
+			}
+		}
+	}()
+	for i, e := range n.entries {
+		err := w.fn(e.Path, e, nil)
+		switch {
+		case err == filepath.SkipDir && e.IsDir:
+		case err == filepath.SkipDir:
+			return nil
+		case err != nil:
+			return err
+		case e.IsDir:
+			c := children[i]
+			children[i] = nil
+			if err := w.visit(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package sbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WatchOp is the kind of change a [WatchEvent] reports.
+type WatchOp int
+
+const (
+	WatchCreate WatchOp = iota + 1 // A file or directory appeared, also by a rename
+	WatchWrite                     // A file's content changed
+	WatchRemove                    // A file or directory disappeared, also by a rename
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchCreate:
+		return "create"
+	case WatchWrite:
+		return "write"
+	case WatchRemove:
+		return "remove"
+	}
+	return fmt.Sprintf("WatchOp(%d)", int(op))
+}
+
+// WatchEvent reports a change below a watched path. Renames are reported
+// as a removal and a creation. Events that report a problem instead have
+// Err set; watching continues after them, but changes may have been
+// missed.
+type WatchEvent struct {
+	Op    WatchOp
+	Path  string
+	IsDir bool
+	Err   error
+}
+
+func (e WatchEvent) String() string {
+	if e.Err != nil {
+		return "error: " + e.Err.Error()
+	}
+	return e.Op.String() + " " + e.Path
+}
+
+// DefaultPollInterval is how often [Watch] polls engines that cannot
+// watch for changes themselves.
+const DefaultPollInterval = 10 * time.Second
+
+// watchBuffer is the capacity of the channels returned by Poll.
+const watchBuffer = 64
+
+// Watch watches path with [Watcher] if engine supports it, and otherwise
+// with [Poll] every DefaultPollInterval.
+func Watch(ctx context.Context, engine StorageEngine, path string, recursive bool) (<-chan WatchEvent, error) {
+	if w, ok := engine.(Watcher); ok && Supports(engine, CapWatch) {
+		events, err := w.Watch(ctx, path, recursive)
+		if !errors.Is(err, ErrNotSupported) {
+			return events, err
+		}
+	}
+	return Poll(ctx, engine, path, recursive, DefaultPollInterval)
+}
+
+// Poll watches path on any engine by listing it every interval and
+// reporting the differences: files whose size or modification time
+// changed are reported as written. Changes undone between two polls are
+// missed. Poll lists path once before returning, and fails if that does;
+// later failures are reported as events with Err set.
+func Poll(ctx context.Context, engine StorageEngine, path string, recursive bool, interval time.Duration) (<-chan WatchEvent, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("sbox: poll interval must be positive: %w", ErrInvalid)
+	}
+	root, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := pollSnapshot(ctx, engine, root, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan WatchEvent, watchBuffer)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur, err := pollSnapshot(ctx, engine, root, recursive)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if !sendEvent(ctx, events, WatchEvent{Err: err}) {
+					return
+				}
+				continue
+			}
+			for _, ev := range diffSnapshots(prev, cur) {
+				if !sendEvent(ctx, events, ev) {
+					return
+				}
+			}
+			prev = cur
+		}
+	}()
+	return events, nil
+}
+
+// sendEvent sends ev unless ctx is canceled first.
+func sendEvent(ctx context.Context, events chan<- WatchEvent, ev WatchEvent) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pollEntry is the state of a file or directory that Poll compares.
+type pollEntry struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// pollSnapshot lists root, and everything below it if recursive is set.
+// A missing root is an empty snapshot.
+func pollSnapshot(ctx context.Context, engine StorageEngine, root string, recursive bool) (map[string]pollEntry, error) {
+	snap := make(map[string]pollEntry)
+	err := Walk(ctx, engine, root, func(p string, info *EntryInfo, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		snap[p] = pollEntry{isDir: info.IsDir, size: info.Size, modTime: info.ModTime}
+		if info.IsDir && p != root && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return snap, err
+}
+
+// diffSnapshots returns the events that turn prev into cur: removals with
+// children before parents, then creations and writes in path order.
+func diffSnapshots(prev, cur map[string]pollEntry) []WatchEvent {
+	var events, removed []WatchEvent
+	for p, old := range prev {
+		if e, ok := cur[p]; !ok || e.isDir != old.isDir {
+			removed = append(removed, WatchEvent{Op: WatchRemove, Path: p, IsDir: old.isDir})
+		}
+	}
+	for p, e := range cur {
+		old, ok := prev[p]
+		switch {
+		case !ok || old.isDir != e.isDir:
+			events = append(events, WatchEvent{Op: WatchCreate, Path: p, IsDir: e.isDir})
+		case !e.isDir && (old.size != e.size || !old.modTime.Equal(e.modTime)):
+			events = append(events, WatchEvent{Op: WatchWrite, Path: p})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Path > removed[j].Path })
+	return append(removed, events...)
+}
+
+// Compile-time interface checks.
+var (
+	_ fmt.Stringer = WatchOp(0)
+	_ fmt.Stringer = WatchEvent{}
+)
@@ -0,0 +1,82 @@
+package sbox_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+)
+
+// nextEvents collects the events received until none arrive for a while,
+// sorted, as changes may straddle two polls.
+func nextEvents(t *testing.T, events <-chan sbox.WatchEvent) []string {
+	t.Helper()
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				sort.Strings(got)
+				return got
+			}
+			got = append(got, ev.String())
+		case <-time.After(200 * time.Millisecond):
+			if len(got) > 0 {
+				sort.Strings(got)
+				return got
+			}
+		case <-timeout:
+			t.Fatal("no events")
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	engine := memory.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	put := func(p, data string) {
+		if err := sbox.PutAtomic(ctx, engine, p, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("in/old.txt", "a")
+	put("in/keep.txt", "a")
+
+	events, err := sbox.Poll(ctx, engine, "in", true, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	put("in/sub/new.txt", "b")
+	put("in/keep.txt", "changed")
+	if err := engine.Remove(ctx, "in/old.txt"); err != nil {
+		t.Fatal(err)
+	}
+	want := "create in/sub,create in/sub/new.txt,remove in/old.txt,write in/keep.txt"
+	if got := strings.Join(nextEvents(t, events), ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+
+	flat, err := sbox.Poll(ctx, engine, "in", false, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	put("in/sub/ignored.txt", "c")
+	put("in/top.txt", "c")
+	if got := strings.Join(nextEvents(t, flat), ","); got != "create in/top.txt" {
+		t.Errorf("non-recursive events = %s", got)
+	}
+
+	cancel()
+	for range events {
+	}
+	if _, err := sbox.Poll(context.Background(), engine, "", true, 0); !errors.Is(err, sbox.ErrInvalid) {
+		t.Errorf("Poll with zero interval = %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package webdav_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nuln/sbox"
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/webdav"
+)
+
+// newClient serves a memory engine under prefix and returns a client for it
+// that streams every write.
+func newClient(t *testing.T, prefix string) (*webdav.Engine, sbox.StorageEngine) {
+	t.Helper()
+	backend := memory.New()
+	srv := httptest.NewServer(webdav.NewHandler(backend, prefix))
+	t.Cleanup(srv.Close)
+	engine, err := webdav.New(srv.URL+prefix, webdav.WithMemoryCap(4))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return engine, backend
+}
+
+func TestClient_Suite(t *testing.T) {
+	engine, _ := newClient(t, "/remote.php/dav files")
+	sboxtest.StorageTestSuite(t, engine)
+}
+
+func TestClient_Operations(t *testing.T) {
+	ctx := context.Background()
+	engine, backend := newClient(t, "/dav")
+
+	if err := engine.Put(ctx, "deep/dir/a b.txt", strings.NewReader("hello webdav")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	info, err := backend.Stat(ctx, "deep/dir/a b.txt")
+	if err != nil || info.Size != 12 {
+		t.Fatalf("backend Stat = %+v, %v", info, err)
+	}
+
+	rc, err := engine.GetRange(ctx, "deep/dir/a b.txt", 6, 3)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(got) != "web" {
+		t.Errorf("GetRange = %q, want %q", got, "web")
+	}
+
+	if _, err := engine.Append(ctx, "deep/dir/a b.txt", strings.NewReader("!")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	entries, err := engine.ReadDir(ctx, "deep/dir")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "a b.txt" || entries[0].Size != 13 || entries[0].Path != "deep/dir/a b.txt" {
+		t.Errorf("ReadDir = %+v", entries)
+	}
+
+	if _, err := engine.Stat(ctx, "missing"); !errors.Is(err, sbox.ErrNotFound) {
+		t.Errorf("Stat(missing) = %v, want %v", err, sbox.ErrNotFound)
+	}
+	if _, err := engine.Open(ctx, "deep"); !errors.Is(err, sbox.ErrIsDir) {
+		t.Errorf("Open(dir) = %v, want %v", err, sbox.ErrIsDir)
+	}
+	if err := engine.Rename(ctx, "deep/dir/a b.txt", "other/b.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if info, err := engine.Stat(ctx, "other"); err != nil || !info.IsDir {
+		t.Errorf("Stat(other) = %+v, %v", info, err)
+	}
+}
+
+func TestClient_BasicAuth(t *testing.T) {
+	handler := webdav.NewHandler(memory.New(), "")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	dsn := "webdav://alice:secret@" + strings.TrimPrefix(srv.URL, "http://") + "/?scheme=http"
+	engine, err := sbox.OpenURL(dsn)
+	if err != nil {
+		t.Fatalf("OpenURL: %v", err)
+	}
+	if err := engine.MkdirAll(ctx, "docs"); err != nil {
+		t.Errorf("MkdirAll: %v", err)
+	}
+
+	anonymous, err := sbox.Open(&sbox.Config{Type: "webdav", BasePath: srv.URL})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := anonymous.Stat(ctx, "docs"); !errors.Is(err, sbox.ErrPermission) {
+		t.Errorf("Stat without credentials = %v, want %v", err, sbox.ErrPermission)
+	}
+}
@@ -0,0 +1,99 @@
+package webdav_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/webdav"
+
+	"github.com/nuln/sbox/memory"
+	"github.com/nuln/sbox/rclone"
+	"github.com/nuln/sbox/sboxtest"
+	"github.com/nuln/sbox/webdav"
+)
+
+func do(t *testing.T, method, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	return resp
+}
+
+func expect(t *testing.T, resp *http.Response, status int) string {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status = %d, want %d: %s", resp.Request.Method, resp.Request.URL, resp.StatusCode, status, body)
+	}
+	return string(body)
+}
+
+func TestHandler_Methods(t *testing.T) {
+	srv := httptest.NewServer(webdav.NewHandler(memory.New(), "/dav"))
+	defer srv.Close()
+	base := srv.URL + "/dav"
+
+	expect(t, do(t, "MKCOL", base+"/docs", "", nil), http.StatusCreated)
+	expect(t, do(t, "MKCOL", base+"/docs", "", nil), http.StatusMethodNotAllowed)
+	expect(t, do(t, "MKCOL", base+"/missing/child", "", nil), http.StatusConflict)
+
+	expect(t, do(t, "PUT", base+"/docs/a.txt", "hello webdav", nil), http.StatusCreated)
+	if got := expect(t, do(t, "GET", base+"/docs/a.txt", "", nil), http.StatusOK); got != "hello webdav" {
+		t.Errorf("GET = %q, want %q", got, "hello webdav")
+	}
+
+	resp := do(t, "GET", base+"/docs/a.txt", "", map[string]string{"Range": "bytes=6-"})
+	if got := expect(t, resp, http.StatusPartialContent); got != "webdav" {
+		t.Errorf("ranged GET = %q, want %q", got, "webdav")
+	}
+
+	listing := expect(t, do(t, "PROPFIND", base+"/docs", "", map[string]string{"Depth": "1"}), http.StatusMultiStatus)
+	if !strings.Contains(listing, "a.txt") {
+		t.Errorf("PROPFIND listing missing a.txt:\n%s", listing)
+	}
+
+	expect(t, do(t, "MOVE", base+"/docs/a.txt", "", map[string]string{"Destination": base + "/docs/b.txt"}), http.StatusCreated)
+	expect(t, do(t, "GET", base+"/docs/a.txt", "", nil), http.StatusNotFound)
+
+	lockBody := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	resp = do(t, "LOCK", base+"/docs/b.txt", lockBody, map[string]string{"Timeout": "Second-60"})
+	token := resp.Header.Get("Lock-Token")
+	expect(t, resp, http.StatusOK)
+	if token == "" {
+		t.Fatal("LOCK returned no Lock-Token")
+	}
+	expect(t, do(t, "PUT", base+"/docs/b.txt", "blocked", nil), http.StatusLocked)
+	expect(t, do(t, "PUT", base+"/docs/b.txt", "allowed", map[string]string{"If": "(" + token + ")"}), http.StatusCreated)
+	expect(t, do(t, "UNLOCK", base+"/docs/b.txt", "", map[string]string{"Lock-Token": token}), http.StatusNoContent)
+
+	expect(t, do(t, "DELETE", base+"/docs", "", nil), http.StatusNoContent)
+	expect(t, do(t, "PROPFIND", base+"/docs", "", map[string]string{"Depth": "0"}), http.StatusNotFound)
+}
+
+// TestHandler_RcloneClient mounts the WebDAV endpoint with rclone's WebDAV
+// client and runs the generic storage suite through it.
+func TestHandler_RcloneClient(t *testing.T) {
+	srv := httptest.NewServer(webdav.NewHandler(memory.New(), ""))
+	defer srv.Close()
+
+	engine, err := rclone.New(fmt.Sprintf(":webdav,url='%s':", srv.URL))
+	if err != nil {
+		t.Fatalf("rclone.New: %v", err)
+	}
+	sboxtest.StorageTestSuite(t, engine)
+}